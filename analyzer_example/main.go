@@ -0,0 +1,159 @@
+// Command analyzer_example is an example analysis plugin for the server's "analyzers"
+// setting. It is run once per photo with the photo's path and its metadata as JSON, and
+// prints what it found as JSON: tags for the photo's orientation, brightness and main
+// colors, the colors as labels with their share of the picture, and a color histogram
+// as the embedding. Real plugins (object detection, OCR, pet recognition) follow the
+// same protocol:
+//
+//	{"analyzers": [{"name": "colors", "command": "/usr/local/bin/analyzer_example"}]}
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"os"
+	"sort"
+	"strings"
+)
+
+// input is the part of the server's AnalysisInput this plugin uses
+type input struct {
+	Name  string `json:"name"`
+	Media string `json:"media"`
+	Exif  *struct {
+		Make string `json:"make"`
+	} `json:"exif"`
+}
+
+type label struct {
+	Name  string  `json:"name"`
+	Score float64 `json:"score"`
+}
+
+type analysis struct {
+	Tags      []string  `json:"tags,omitempty"`
+	Labels    []label   `json:"labels,omitempty"`
+	Embedding []float32 `json:"embedding,omitempty"`
+}
+
+// palette names the colors pixels are sorted into
+var palette = []struct {
+	name    string
+	r, g, b float64
+}{
+	{"black", 0, 0, 0}, {"white", 255, 255, 255}, {"gray", 128, 128, 128},
+	{"red", 200, 40, 40}, {"orange", 240, 140, 30}, {"yellow", 240, 220, 60},
+	{"green", 60, 160, 60}, {"blue", 50, 90, 200}, {"sky", 130, 180, 235},
+	{"purple", 130, 60, 160}, {"pink", 240, 150, 190}, {"brown", 120, 80, 40},
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprintln(os.Stderr, "usage: analyzer_example <file> [metadata JSON]")
+		os.Exit(2)
+	}
+	var in input
+	if len(os.Args) > 2 {
+		if err := json.Unmarshal([]byte(os.Args[2]), &in); err != nil {
+			fmt.Fprintln(os.Stderr, "bad metadata:", err)
+			os.Exit(2)
+		}
+	}
+	result, err := analyze(os.Args[1], &in)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	json.NewEncoder(os.Stdout).Encode(result)
+}
+
+func analyze(path string, in *input) (*analysis, error) {
+	result := &analysis{}
+	// Screenshots have no camera; name them so they can be told apart from photos
+	if (in.Exif == nil || in.Exif.Make == "") && strings.Contains(strings.ToLower(in.Name), "screenshot") {
+		result.Tags = append(result.Tags, "screenshot")
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	img, _, err := image.Decode(f)
+	if err != nil {
+		// HEIC and the like: nothing to say about the pixels, which is an answer too
+		return result, nil
+	}
+
+	b := img.Bounds()
+	switch w, h := b.Dx(), b.Dy(); {
+	case w > h*11/10:
+		result.Tags = append(result.Tags, "landscape")
+	case h > w*11/10:
+		result.Tags = append(result.Tags, "portrait")
+	default:
+		result.Tags = append(result.Tags, "square")
+	}
+
+	// Sample about 100x100 pixels, into a 4x4x4 RGB histogram and the palette colors
+	step := max(1, max(b.Dx(), b.Dy())/100)
+	hist := make([]float32, 64)
+	counts := make([]int, len(palette))
+	var brightness float64
+	n := 0
+	for y := b.Min.Y; y < b.Max.Y; y += step {
+		for x := b.Min.X; x < b.Max.X; x += step {
+			r16, g16, b16, _ := img.At(x, y).RGBA()
+			r, g, bl := float64(r16>>8), float64(g16>>8), float64(b16>>8)
+			hist[int(r)/64*16+int(g)/64*4+int(bl)/64]++
+			counts[nearestColor(r, g, bl)]++
+			brightness += 0.299*r + 0.587*g + 0.114*bl
+			n++
+		}
+	}
+	if n == 0 {
+		return result, nil
+	}
+	for i := range hist {
+		hist[i] /= float32(n)
+	}
+	result.Embedding = hist
+
+	switch brightness /= float64(n); {
+	case brightness < 60:
+		result.Tags = append(result.Tags, "dark")
+	case brightness > 190:
+		result.Tags = append(result.Tags, "bright")
+	}
+
+	order := make([]int, len(palette))
+	for i := range order {
+		order[i] = i
+	}
+	sort.Slice(order, func(i, j int) bool { return counts[order[i]] > counts[order[j]] })
+	for _, i := range order[:3] {
+		share := float64(counts[i]) / float64(n)
+		if share < 0.15 {
+			break
+		}
+		result.Labels = append(result.Labels, label{Name: palette[i].name, Score: share})
+		result.Tags = append(result.Tags, palette[i].name)
+	}
+	return result, nil
+}
+
+// nearestColor returns the palette color closest to a pixel
+func nearestColor(r, g, b float64) int {
+	best, bestDist := 0, -1.0
+	for i, c := range palette {
+		d := (r-c.r)*(r-c.r) + (g-c.g)*(g-c.g) + (b-c.b)*(b-c.b)
+		if bestDist < 0 || d < bestDist {
+			best, bestDist = i, d
+		}
+	}
+	return best
+}
@@ -0,0 +1,4 @@
+// Package photosyncpb holds the generated code of the PhotoSync gRPC service.
+package photosyncpb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative photosync.proto
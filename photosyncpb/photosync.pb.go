@@ -0,0 +1,839 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.6
+// 	protoc        v5.29.3
+// source: photosync.proto
+
+package photosyncpb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+// Device selects the phone directory a call works on: a registered device (stable ID,
+// as REGISTER_DEVICE) or, for clients without one, the directory name (as SET_PHONE_NAME)
+type Device struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	DeviceId      string                 `protobuf:"bytes,1,opt,name=device_id,json=deviceId,proto3" json:"device_id,omitempty"`
+	Name          string                 `protobuf:"bytes,2,opt,name=name,proto3" json:"name,omitempty"`
+	TimeZone      string                 `protobuf:"bytes,3,opt,name=time_zone,json=timeZone,proto3" json:"time_zone,omitempty"` // optional IANA zone for device-local capture times
+	Token         string                 `protobuf:"bytes,4,opt,name=token,proto3" json:"token,omitempty"`                       // device token issued at pairing, required once paired
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Device) Reset() {
+	*x = Device{}
+	mi := &file_photosync_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Device) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Device) ProtoMessage() {}
+
+func (x *Device) ProtoReflect() protoreflect.Message {
+	mi := &file_photosync_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Device.ProtoReflect.Descriptor instead.
+func (*Device) Descriptor() ([]byte, []int) {
+	return file_photosync_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *Device) GetDeviceId() string {
+	if x != nil {
+		return x.DeviceId
+	}
+	return ""
+}
+
+func (x *Device) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *Device) GetTimeZone() string {
+	if x != nil {
+		return x.TimeZone
+	}
+	return ""
+}
+
+func (x *Device) GetToken() string {
+	if x != nil {
+		return x.Token
+	}
+	return ""
+}
+
+type UploadHeader struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Device        *Device                `protobuf:"bytes,1,opt,name=device,proto3" json:"device,omitempty"`
+	Id            string                 `protobuf:"bytes,2,opt,name=id,proto3" json:"id,omitempty"`         // media ID, stored as <id>.<media>
+	Media         string                 `protobuf:"bytes,3,opt,name=media,proto3" json:"media,omitempty"`   // file extension, e.g. "jpg" or "mp4"
+	Size          int64                  `protobuf:"varint,4,opt,name=size,proto3" json:"size,omitempty"`    // total bytes that follow
+	Sha256        string                 `protobuf:"bytes,5,opt,name=sha256,proto3" json:"sha256,omitempty"` // optional, verified before storing
+	Taken         string                 `protobuf:"bytes,6,opt,name=taken,proto3" json:"taken,omitempty"`   // optional capture time (RFC 3339 or EXIF style)
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *UploadHeader) Reset() {
+	*x = UploadHeader{}
+	mi := &file_photosync_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *UploadHeader) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*UploadHeader) ProtoMessage() {}
+
+func (x *UploadHeader) ProtoReflect() protoreflect.Message {
+	mi := &file_photosync_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use UploadHeader.ProtoReflect.Descriptor instead.
+func (*UploadHeader) Descriptor() ([]byte, []int) {
+	return file_photosync_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *UploadHeader) GetDevice() *Device {
+	if x != nil {
+		return x.Device
+	}
+	return nil
+}
+
+func (x *UploadHeader) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+func (x *UploadHeader) GetMedia() string {
+	if x != nil {
+		return x.Media
+	}
+	return ""
+}
+
+func (x *UploadHeader) GetSize() int64 {
+	if x != nil {
+		return x.Size
+	}
+	return 0
+}
+
+func (x *UploadHeader) GetSha256() string {
+	if x != nil {
+		return x.Sha256
+	}
+	return ""
+}
+
+func (x *UploadHeader) GetTaken() string {
+	if x != nil {
+		return x.Taken
+	}
+	return ""
+}
+
+type UploadMediaRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Types that are valid to be assigned to Part:
+	//
+	//	*UploadMediaRequest_Header
+	//	*UploadMediaRequest_Chunk
+	Part          isUploadMediaRequest_Part `protobuf_oneof:"part"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *UploadMediaRequest) Reset() {
+	*x = UploadMediaRequest{}
+	mi := &file_photosync_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *UploadMediaRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*UploadMediaRequest) ProtoMessage() {}
+
+func (x *UploadMediaRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_photosync_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use UploadMediaRequest.ProtoReflect.Descriptor instead.
+func (*UploadMediaRequest) Descriptor() ([]byte, []int) {
+	return file_photosync_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *UploadMediaRequest) GetPart() isUploadMediaRequest_Part {
+	if x != nil {
+		return x.Part
+	}
+	return nil
+}
+
+func (x *UploadMediaRequest) GetHeader() *UploadHeader {
+	if x != nil {
+		if x, ok := x.Part.(*UploadMediaRequest_Header); ok {
+			return x.Header
+		}
+	}
+	return nil
+}
+
+func (x *UploadMediaRequest) GetChunk() []byte {
+	if x != nil {
+		if x, ok := x.Part.(*UploadMediaRequest_Chunk); ok {
+			return x.Chunk
+		}
+	}
+	return nil
+}
+
+type isUploadMediaRequest_Part interface {
+	isUploadMediaRequest_Part()
+}
+
+type UploadMediaRequest_Header struct {
+	Header *UploadHeader `protobuf:"bytes,1,opt,name=header,proto3,oneof"`
+}
+
+type UploadMediaRequest_Chunk struct {
+	Chunk []byte `protobuf:"bytes,2,opt,name=chunk,proto3,oneof"`
+}
+
+func (*UploadMediaRequest_Header) isUploadMediaRequest_Part() {}
+
+func (*UploadMediaRequest_Chunk) isUploadMediaRequest_Part() {}
+
+type UploadMediaResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Id            string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	Code          string                 `protobuf:"bytes,2,opt,name=code,proto3" json:"code,omitempty"`   // ok, duplicate, checksum_mismatch, size_mismatch, disk_full, ...
+	Error         string                 `protobuf:"bytes,3,opt,name=error,proto3" json:"error,omitempty"` // set when the code is an error
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *UploadMediaResponse) Reset() {
+	*x = UploadMediaResponse{}
+	mi := &file_photosync_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *UploadMediaResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*UploadMediaResponse) ProtoMessage() {}
+
+func (x *UploadMediaResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_photosync_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use UploadMediaResponse.ProtoReflect.Descriptor instead.
+func (*UploadMediaResponse) Descriptor() ([]byte, []int) {
+	return file_photosync_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *UploadMediaResponse) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+func (x *UploadMediaResponse) GetCode() string {
+	if x != nil {
+		return x.Code
+	}
+	return ""
+}
+
+func (x *UploadMediaResponse) GetError() string {
+	if x != nil {
+		return x.Error
+	}
+	return ""
+}
+
+type MediaItem struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Id            string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	Media         string                 `protobuf:"bytes,2,opt,name=media,proto3" json:"media,omitempty"`
+	Size          int64                  `protobuf:"varint,3,opt,name=size,proto3" json:"size,omitempty"`    // optional, compared when present
+	Sha256        string                 `protobuf:"bytes,4,opt,name=sha256,proto3" json:"sha256,omitempty"` // optional, compared when present and sizes match
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *MediaItem) Reset() {
+	*x = MediaItem{}
+	mi := &file_photosync_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *MediaItem) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*MediaItem) ProtoMessage() {}
+
+func (x *MediaItem) ProtoReflect() protoreflect.Message {
+	mi := &file_photosync_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use MediaItem.ProtoReflect.Descriptor instead.
+func (*MediaItem) Descriptor() ([]byte, []int) {
+	return file_photosync_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *MediaItem) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+func (x *MediaItem) GetMedia() string {
+	if x != nil {
+		return x.Media
+	}
+	return ""
+}
+
+func (x *MediaItem) GetSize() int64 {
+	if x != nil {
+		return x.Size
+	}
+	return 0
+}
+
+func (x *MediaItem) GetSha256() string {
+	if x != nil {
+		return x.Sha256
+	}
+	return ""
+}
+
+type ListMissingRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Device        *Device                `protobuf:"bytes,1,opt,name=device,proto3" json:"device,omitempty"`
+	Items         []*MediaItem           `protobuf:"bytes,2,rep,name=items,proto3" json:"items,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListMissingRequest) Reset() {
+	*x = ListMissingRequest{}
+	mi := &file_photosync_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListMissingRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListMissingRequest) ProtoMessage() {}
+
+func (x *ListMissingRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_photosync_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListMissingRequest.ProtoReflect.Descriptor instead.
+func (*ListMissingRequest) Descriptor() ([]byte, []int) {
+	return file_photosync_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *ListMissingRequest) GetDevice() *Device {
+	if x != nil {
+		return x.Device
+	}
+	return nil
+}
+
+func (x *ListMissingRequest) GetItems() []*MediaItem {
+	if x != nil {
+		return x.Items
+	}
+	return nil
+}
+
+type ListMissingResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Missing       []*MediaItem           `protobuf:"bytes,1,rep,name=missing,proto3" json:"missing,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListMissingResponse) Reset() {
+	*x = ListMissingResponse{}
+	mi := &file_photosync_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListMissingResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListMissingResponse) ProtoMessage() {}
+
+func (x *ListMissingResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_photosync_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListMissingResponse.ProtoReflect.Descriptor instead.
+func (*ListMissingResponse) Descriptor() ([]byte, []int) {
+	return file_photosync_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *ListMissingResponse) GetMissing() []*MediaItem {
+	if x != nil {
+		return x.Missing
+	}
+	return nil
+}
+
+type DeleteMediaRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Device        *Device                `protobuf:"bytes,1,opt,name=device,proto3" json:"device,omitempty"`
+	Ids           []string               `protobuf:"bytes,2,rep,name=ids,proto3" json:"ids,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *DeleteMediaRequest) Reset() {
+	*x = DeleteMediaRequest{}
+	mi := &file_photosync_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *DeleteMediaRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DeleteMediaRequest) ProtoMessage() {}
+
+func (x *DeleteMediaRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_photosync_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DeleteMediaRequest.ProtoReflect.Descriptor instead.
+func (*DeleteMediaRequest) Descriptor() ([]byte, []int) {
+	return file_photosync_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *DeleteMediaRequest) GetDevice() *Device {
+	if x != nil {
+		return x.Device
+	}
+	return nil
+}
+
+func (x *DeleteMediaRequest) GetIds() []string {
+	if x != nil {
+		return x.Ids
+	}
+	return nil
+}
+
+type DeleteMediaResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Deleted       int32                  `protobuf:"varint,1,opt,name=deleted,proto3" json:"deleted,omitempty"`
+	NotFound      []string               `protobuf:"bytes,2,rep,name=not_found,json=notFound,proto3" json:"not_found,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *DeleteMediaResponse) Reset() {
+	*x = DeleteMediaResponse{}
+	mi := &file_photosync_proto_msgTypes[8]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *DeleteMediaResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DeleteMediaResponse) ProtoMessage() {}
+
+func (x *DeleteMediaResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_photosync_proto_msgTypes[8]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DeleteMediaResponse.ProtoReflect.Descriptor instead.
+func (*DeleteMediaResponse) Descriptor() ([]byte, []int) {
+	return file_photosync_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *DeleteMediaResponse) GetDeleted() int32 {
+	if x != nil {
+		return x.Deleted
+	}
+	return 0
+}
+
+func (x *DeleteMediaResponse) GetNotFound() []string {
+	if x != nil {
+		return x.NotFound
+	}
+	return nil
+}
+
+type GetThumbnailsRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Device        *Device                `protobuf:"bytes,1,opt,name=device,proto3" json:"device,omitempty"`
+	PageIndex     int32                  `protobuf:"varint,2,opt,name=page_index,json=pageIndex,proto3" json:"page_index,omitempty"`
+	PageSize      int32                  `protobuf:"varint,3,opt,name=page_size,json=pageSize,proto3" json:"page_size,omitempty"` // default 100
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetThumbnailsRequest) Reset() {
+	*x = GetThumbnailsRequest{}
+	mi := &file_photosync_proto_msgTypes[9]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetThumbnailsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetThumbnailsRequest) ProtoMessage() {}
+
+func (x *GetThumbnailsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_photosync_proto_msgTypes[9]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetThumbnailsRequest.ProtoReflect.Descriptor instead.
+func (*GetThumbnailsRequest) Descriptor() ([]byte, []int) {
+	return file_photosync_proto_rawDescGZIP(), []int{9}
+}
+
+func (x *GetThumbnailsRequest) GetDevice() *Device {
+	if x != nil {
+		return x.Device
+	}
+	return nil
+}
+
+func (x *GetThumbnailsRequest) GetPageIndex() int32 {
+	if x != nil {
+		return x.PageIndex
+	}
+	return 0
+}
+
+func (x *GetThumbnailsRequest) GetPageSize() int32 {
+	if x != nil {
+		return x.PageSize
+	}
+	return 0
+}
+
+type Thumbnail struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Id            string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	Media         string                 `protobuf:"bytes,2,opt,name=media,proto3" json:"media,omitempty"` // image extension, or "video" when the original is a video
+	Data          []byte                 `protobuf:"bytes,3,opt,name=data,proto3" json:"data,omitempty"`   // JPEG thumbnail
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Thumbnail) Reset() {
+	*x = Thumbnail{}
+	mi := &file_photosync_proto_msgTypes[10]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Thumbnail) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Thumbnail) ProtoMessage() {}
+
+func (x *Thumbnail) ProtoReflect() protoreflect.Message {
+	mi := &file_photosync_proto_msgTypes[10]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Thumbnail.ProtoReflect.Descriptor instead.
+func (*Thumbnail) Descriptor() ([]byte, []int) {
+	return file_photosync_proto_rawDescGZIP(), []int{10}
+}
+
+func (x *Thumbnail) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+func (x *Thumbnail) GetMedia() string {
+	if x != nil {
+		return x.Media
+	}
+	return ""
+}
+
+func (x *Thumbnail) GetData() []byte {
+	if x != nil {
+		return x.Data
+	}
+	return nil
+}
+
+var File_photosync_proto protoreflect.FileDescriptor
+
+const file_photosync_proto_rawDesc = "" +
+	"\n" +
+	"\x0fphotosync.proto\x12\fphotosync.v1\"l\n" +
+	"\x06Device\x12\x1b\n" +
+	"\tdevice_id\x18\x01 \x01(\tR\bdeviceId\x12\x12\n" +
+	"\x04name\x18\x02 \x01(\tR\x04name\x12\x1b\n" +
+	"\ttime_zone\x18\x03 \x01(\tR\btimeZone\x12\x14\n" +
+	"\x05token\x18\x04 \x01(\tR\x05token\"\xa4\x01\n" +
+	"\fUploadHeader\x12,\n" +
+	"\x06device\x18\x01 \x01(\v2\x14.photosync.v1.DeviceR\x06device\x12\x0e\n" +
+	"\x02id\x18\x02 \x01(\tR\x02id\x12\x14\n" +
+	"\x05media\x18\x03 \x01(\tR\x05media\x12\x12\n" +
+	"\x04size\x18\x04 \x01(\x03R\x04size\x12\x16\n" +
+	"\x06sha256\x18\x05 \x01(\tR\x06sha256\x12\x14\n" +
+	"\x05taken\x18\x06 \x01(\tR\x05taken\"j\n" +
+	"\x12UploadMediaRequest\x124\n" +
+	"\x06header\x18\x01 \x01(\v2\x1a.photosync.v1.UploadHeaderH\x00R\x06header\x12\x16\n" +
+	"\x05chunk\x18\x02 \x01(\fH\x00R\x05chunkB\x06\n" +
+	"\x04part\"O\n" +
+	"\x13UploadMediaResponse\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\x12\x12\n" +
+	"\x04code\x18\x02 \x01(\tR\x04code\x12\x14\n" +
+	"\x05error\x18\x03 \x01(\tR\x05error\"]\n" +
+	"\tMediaItem\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\x12\x14\n" +
+	"\x05media\x18\x02 \x01(\tR\x05media\x12\x12\n" +
+	"\x04size\x18\x03 \x01(\x03R\x04size\x12\x16\n" +
+	"\x06sha256\x18\x04 \x01(\tR\x06sha256\"q\n" +
+	"\x12ListMissingRequest\x12,\n" +
+	"\x06device\x18\x01 \x01(\v2\x14.photosync.v1.DeviceR\x06device\x12-\n" +
+	"\x05items\x18\x02 \x03(\v2\x17.photosync.v1.MediaItemR\x05items\"H\n" +
+	"\x13ListMissingResponse\x121\n" +
+	"\amissing\x18\x01 \x03(\v2\x17.photosync.v1.MediaItemR\amissing\"T\n" +
+	"\x12DeleteMediaRequest\x12,\n" +
+	"\x06device\x18\x01 \x01(\v2\x14.photosync.v1.DeviceR\x06device\x12\x10\n" +
+	"\x03ids\x18\x02 \x03(\tR\x03ids\"L\n" +
+	"\x13DeleteMediaResponse\x12\x18\n" +
+	"\adeleted\x18\x01 \x01(\x05R\adeleted\x12\x1b\n" +
+	"\tnot_found\x18\x02 \x03(\tR\bnotFound\"\x80\x01\n" +
+	"\x14GetThumbnailsRequest\x12,\n" +
+	"\x06device\x18\x01 \x01(\v2\x14.photosync.v1.DeviceR\x06device\x12\x1d\n" +
+	"\n" +
+	"page_index\x18\x02 \x01(\x05R\tpageIndex\x12\x1b\n" +
+	"\tpage_size\x18\x03 \x01(\x05R\bpageSize\"E\n" +
+	"\tThumbnail\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\x12\x14\n" +
+	"\x05media\x18\x02 \x01(\tR\x05media\x12\x12\n" +
+	"\x04data\x18\x03 \x01(\fR\x04data2\xd9\x02\n" +
+	"\tPhotoSync\x12T\n" +
+	"\vUploadMedia\x12 .photosync.v1.UploadMediaRequest\x1a!.photosync.v1.UploadMediaResponse(\x01\x12R\n" +
+	"\vListMissing\x12 .photosync.v1.ListMissingRequest\x1a!.photosync.v1.ListMissingResponse\x12R\n" +
+	"\vDeleteMedia\x12 .photosync.v1.DeleteMediaRequest\x1a!.photosync.v1.DeleteMediaResponse\x12N\n" +
+	"\rGetThumbnails\x12\".photosync.v1.GetThumbnailsRequest\x1a\x17.photosync.v1.Thumbnail0\x01B\x1fZ\x1dphoto_sync_server/photosyncpbb\x06proto3"
+
+var (
+	file_photosync_proto_rawDescOnce sync.Once
+	file_photosync_proto_rawDescData []byte
+)
+
+func file_photosync_proto_rawDescGZIP() []byte {
+	file_photosync_proto_rawDescOnce.Do(func() {
+		file_photosync_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_photosync_proto_rawDesc), len(file_photosync_proto_rawDesc)))
+	})
+	return file_photosync_proto_rawDescData
+}
+
+var file_photosync_proto_msgTypes = make([]protoimpl.MessageInfo, 11)
+var file_photosync_proto_goTypes = []any{
+	(*Device)(nil),               // 0: photosync.v1.Device
+	(*UploadHeader)(nil),         // 1: photosync.v1.UploadHeader
+	(*UploadMediaRequest)(nil),   // 2: photosync.v1.UploadMediaRequest
+	(*UploadMediaResponse)(nil),  // 3: photosync.v1.UploadMediaResponse
+	(*MediaItem)(nil),            // 4: photosync.v1.MediaItem
+	(*ListMissingRequest)(nil),   // 5: photosync.v1.ListMissingRequest
+	(*ListMissingResponse)(nil),  // 6: photosync.v1.ListMissingResponse
+	(*DeleteMediaRequest)(nil),   // 7: photosync.v1.DeleteMediaRequest
+	(*DeleteMediaResponse)(nil),  // 8: photosync.v1.DeleteMediaResponse
+	(*GetThumbnailsRequest)(nil), // 9: photosync.v1.GetThumbnailsRequest
+	(*Thumbnail)(nil),            // 10: photosync.v1.Thumbnail
+}
+var file_photosync_proto_depIdxs = []int32{
+	0,  // 0: photosync.v1.UploadHeader.device:type_name -> photosync.v1.Device
+	1,  // 1: photosync.v1.UploadMediaRequest.header:type_name -> photosync.v1.UploadHeader
+	0,  // 2: photosync.v1.ListMissingRequest.device:type_name -> photosync.v1.Device
+	4,  // 3: photosync.v1.ListMissingRequest.items:type_name -> photosync.v1.MediaItem
+	4,  // 4: photosync.v1.ListMissingResponse.missing:type_name -> photosync.v1.MediaItem
+	0,  // 5: photosync.v1.DeleteMediaRequest.device:type_name -> photosync.v1.Device
+	0,  // 6: photosync.v1.GetThumbnailsRequest.device:type_name -> photosync.v1.Device
+	2,  // 7: photosync.v1.PhotoSync.UploadMedia:input_type -> photosync.v1.UploadMediaRequest
+	5,  // 8: photosync.v1.PhotoSync.ListMissing:input_type -> photosync.v1.ListMissingRequest
+	7,  // 9: photosync.v1.PhotoSync.DeleteMedia:input_type -> photosync.v1.DeleteMediaRequest
+	9,  // 10: photosync.v1.PhotoSync.GetThumbnails:input_type -> photosync.v1.GetThumbnailsRequest
+	3,  // 11: photosync.v1.PhotoSync.UploadMedia:output_type -> photosync.v1.UploadMediaResponse
+	6,  // 12: photosync.v1.PhotoSync.ListMissing:output_type -> photosync.v1.ListMissingResponse
+	8,  // 13: photosync.v1.PhotoSync.DeleteMedia:output_type -> photosync.v1.DeleteMediaResponse
+	10, // 14: photosync.v1.PhotoSync.GetThumbnails:output_type -> photosync.v1.Thumbnail
+	11, // [11:15] is the sub-list for method output_type
+	7,  // [7:11] is the sub-list for method input_type
+	7,  // [7:7] is the sub-list for extension type_name
+	7,  // [7:7] is the sub-list for extension extendee
+	0,  // [0:7] is the sub-list for field type_name
+}
+
+func init() { file_photosync_proto_init() }
+func file_photosync_proto_init() {
+	if File_photosync_proto != nil {
+		return
+	}
+	file_photosync_proto_msgTypes[2].OneofWrappers = []any{
+		(*UploadMediaRequest_Header)(nil),
+		(*UploadMediaRequest_Chunk)(nil),
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_photosync_proto_rawDesc), len(file_photosync_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   11,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_photosync_proto_goTypes,
+		DependencyIndexes: file_photosync_proto_depIdxs,
+		MessageInfos:      file_photosync_proto_msgTypes,
+	}.Build()
+	File_photosync_proto = out.File
+	file_photosync_proto_goTypes = nil
+	file_photosync_proto_depIdxs = nil
+}
@@ -0,0 +1,250 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.5.1
+// - protoc             v5.29.3
+// source: photosync.proto
+
+package photosyncpb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	PhotoSync_UploadMedia_FullMethodName   = "/photosync.v1.PhotoSync/UploadMedia"
+	PhotoSync_ListMissing_FullMethodName   = "/photosync.v1.PhotoSync/ListMissing"
+	PhotoSync_DeleteMedia_FullMethodName   = "/photosync.v1.PhotoSync/DeleteMedia"
+	PhotoSync_GetThumbnails_FullMethodName = "/photosync.v1.PhotoSync/GetThumbnails"
+)
+
+// PhotoSyncClient is the client API for PhotoSync service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// PhotoSync is the typed alternative to the framed TCP sync protocol. It runs on its
+// own port (grpc_port) and stores into the same phone directories, so both kinds of
+// clients share a library.
+type PhotoSyncClient interface {
+	// UploadMedia streams one file: a header first, then its bytes in any number of
+	// chunks. The response carries the same code as the TCP file ACK.
+	UploadMedia(ctx context.Context, opts ...grpc.CallOption) (grpc.ClientStreamingClient[UploadMediaRequest, UploadMediaResponse], error)
+	// ListMissing returns the subset of the client's media the server doesn't hold yet
+	ListMissing(ctx context.Context, in *ListMissingRequest, opts ...grpc.CallOption) (*ListMissingResponse, error)
+	// DeleteMedia removes originals and their thumbnails by media ID
+	DeleteMedia(ctx context.Context, in *DeleteMediaRequest, opts ...grpc.CallOption) (*DeleteMediaResponse, error)
+	// GetThumbnails streams one page of the phone's thumbnails, ordered by name
+	GetThumbnails(ctx context.Context, in *GetThumbnailsRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[Thumbnail], error)
+}
+
+type photoSyncClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewPhotoSyncClient(cc grpc.ClientConnInterface) PhotoSyncClient {
+	return &photoSyncClient{cc}
+}
+
+func (c *photoSyncClient) UploadMedia(ctx context.Context, opts ...grpc.CallOption) (grpc.ClientStreamingClient[UploadMediaRequest, UploadMediaResponse], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &PhotoSync_ServiceDesc.Streams[0], PhotoSync_UploadMedia_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[UploadMediaRequest, UploadMediaResponse]{ClientStream: stream}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type PhotoSync_UploadMediaClient = grpc.ClientStreamingClient[UploadMediaRequest, UploadMediaResponse]
+
+func (c *photoSyncClient) ListMissing(ctx context.Context, in *ListMissingRequest, opts ...grpc.CallOption) (*ListMissingResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ListMissingResponse)
+	err := c.cc.Invoke(ctx, PhotoSync_ListMissing_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *photoSyncClient) DeleteMedia(ctx context.Context, in *DeleteMediaRequest, opts ...grpc.CallOption) (*DeleteMediaResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(DeleteMediaResponse)
+	err := c.cc.Invoke(ctx, PhotoSync_DeleteMedia_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *photoSyncClient) GetThumbnails(ctx context.Context, in *GetThumbnailsRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[Thumbnail], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &PhotoSync_ServiceDesc.Streams[1], PhotoSync_GetThumbnails_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[GetThumbnailsRequest, Thumbnail]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type PhotoSync_GetThumbnailsClient = grpc.ServerStreamingClient[Thumbnail]
+
+// PhotoSyncServer is the server API for PhotoSync service.
+// All implementations must embed UnimplementedPhotoSyncServer
+// for forward compatibility.
+//
+// PhotoSync is the typed alternative to the framed TCP sync protocol. It runs on its
+// own port (grpc_port) and stores into the same phone directories, so both kinds of
+// clients share a library.
+type PhotoSyncServer interface {
+	// UploadMedia streams one file: a header first, then its bytes in any number of
+	// chunks. The response carries the same code as the TCP file ACK.
+	UploadMedia(grpc.ClientStreamingServer[UploadMediaRequest, UploadMediaResponse]) error
+	// ListMissing returns the subset of the client's media the server doesn't hold yet
+	ListMissing(context.Context, *ListMissingRequest) (*ListMissingResponse, error)
+	// DeleteMedia removes originals and their thumbnails by media ID
+	DeleteMedia(context.Context, *DeleteMediaRequest) (*DeleteMediaResponse, error)
+	// GetThumbnails streams one page of the phone's thumbnails, ordered by name
+	GetThumbnails(*GetThumbnailsRequest, grpc.ServerStreamingServer[Thumbnail]) error
+	mustEmbedUnimplementedPhotoSyncServer()
+}
+
+// UnimplementedPhotoSyncServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedPhotoSyncServer struct{}
+
+func (UnimplementedPhotoSyncServer) UploadMedia(grpc.ClientStreamingServer[UploadMediaRequest, UploadMediaResponse]) error {
+	return status.Errorf(codes.Unimplemented, "method UploadMedia not implemented")
+}
+func (UnimplementedPhotoSyncServer) ListMissing(context.Context, *ListMissingRequest) (*ListMissingResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListMissing not implemented")
+}
+func (UnimplementedPhotoSyncServer) DeleteMedia(context.Context, *DeleteMediaRequest) (*DeleteMediaResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DeleteMedia not implemented")
+}
+func (UnimplementedPhotoSyncServer) GetThumbnails(*GetThumbnailsRequest, grpc.ServerStreamingServer[Thumbnail]) error {
+	return status.Errorf(codes.Unimplemented, "method GetThumbnails not implemented")
+}
+func (UnimplementedPhotoSyncServer) mustEmbedUnimplementedPhotoSyncServer() {}
+func (UnimplementedPhotoSyncServer) testEmbeddedByValue()                   {}
+
+// UnsafePhotoSyncServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to PhotoSyncServer will
+// result in compilation errors.
+type UnsafePhotoSyncServer interface {
+	mustEmbedUnimplementedPhotoSyncServer()
+}
+
+func RegisterPhotoSyncServer(s grpc.ServiceRegistrar, srv PhotoSyncServer) {
+	// If the following call pancis, it indicates UnimplementedPhotoSyncServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&PhotoSync_ServiceDesc, srv)
+}
+
+func _PhotoSync_UploadMedia_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(PhotoSyncServer).UploadMedia(&grpc.GenericServerStream[UploadMediaRequest, UploadMediaResponse]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type PhotoSync_UploadMediaServer = grpc.ClientStreamingServer[UploadMediaRequest, UploadMediaResponse]
+
+func _PhotoSync_ListMissing_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListMissingRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PhotoSyncServer).ListMissing(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: PhotoSync_ListMissing_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PhotoSyncServer).ListMissing(ctx, req.(*ListMissingRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _PhotoSync_DeleteMedia_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DeleteMediaRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PhotoSyncServer).DeleteMedia(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: PhotoSync_DeleteMedia_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PhotoSyncServer).DeleteMedia(ctx, req.(*DeleteMediaRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _PhotoSync_GetThumbnails_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(GetThumbnailsRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(PhotoSyncServer).GetThumbnails(m, &grpc.GenericServerStream[GetThumbnailsRequest, Thumbnail]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type PhotoSync_GetThumbnailsServer = grpc.ServerStreamingServer[Thumbnail]
+
+// PhotoSync_ServiceDesc is the grpc.ServiceDesc for PhotoSync service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var PhotoSync_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "photosync.v1.PhotoSync",
+	HandlerType: (*PhotoSyncServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "ListMissing",
+			Handler:    _PhotoSync_ListMissing_Handler,
+		},
+		{
+			MethodName: "DeleteMedia",
+			Handler:    _PhotoSync_DeleteMedia_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "UploadMedia",
+			Handler:       _PhotoSync_UploadMedia_Handler,
+			ClientStreams: true,
+		},
+		{
+			StreamName:    "GetThumbnails",
+			Handler:       _PhotoSync_GetThumbnails_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "photosync.proto",
+}
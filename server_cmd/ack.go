@@ -0,0 +1,205 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"strconv"
+	"syscall"
+)
+
+// ACK status values
+const (
+	ackStatusOK    = "ok"
+	ackStatusError = "error"
+)
+
+// ACK codes sent to clients that negotiated the "json_ack" feature. Clients should treat
+// unknown error codes like io_error.
+const (
+	ackCodeOK        = "ok"
+	ackCodeDuplicate = "duplicate"         // content already stored, nothing written
+	ackCodeDecode    = "decode_error"      // base64 or JSON payload couldn't be decoded
+	ackCodeChecksum  = "checksum_mismatch" // data doesn't match the sha256 sent, resend it
+	ackCodeInvalid   = "invalid_request"   // missing fields or impossible transfer parameters
+	ackCodeDiskFull  = "disk_full"         // not enough free space on the server
+	ackCodeQuota     = "quota_exceeded"    // the device's storage quota is used up
+	ackCodeIO        = "io_error"          // the server failed to write the file
+	ackCodeSize      = "size_mismatch"     // chunk or file size doesn't match the announced size
+	ackCodeRange     = "out_of_range"      // chunk index outside the transfer
+	ackCodeMissing   = "missing_chunks"    // resend the chunks listed in missing, then complete again
+	ackCodeNoDevice  = "no_device"         // the message needs a phone name or registered device first
+	ackCodeApproval  = "not_approved"      // the device is waiting for approval or blocked on the devices page
+)
+
+// Deduplication outcomes reported in file ACKs
+const (
+	ackDedupExisting = "existing" // identical to a file the phone already had, nothing written; path names that file
+	ackDedupHardlink = "hardlink" // stored as a hard link to an identical file of another phone
+)
+
+// ACK kinds, which also select the legacy text format
+const (
+	ackKindFile   = "file"   // OK:<id>, OK:<id>:DUPLICATE, ERR:<id>:<reason>
+	ackKindStart  = "start"  // OK:START, ERR:<id>:<reason>
+	ackKindChunk  = "chunk"  // OK:CHUNK:<i>, ERR:CHUNK:<i>:<reason>
+	ackKindDevice = "device" // OK:DEVICE:<dir>, ERR:DEVICE:<reason>
+	ackKindLog    = "log"    // OK:LOG, ERR:LOG:<reason>
+	ackKindBatch  = "batch"  // OK:BATCH:<batchId>, ERR:BATCH:<batchId>:<reason>
+)
+
+// Ack is the structured form of a msgTypeAck reply
+type Ack struct {
+	Kind    string `json:"kind"`
+	ID      string `json:"id,omitempty"`
+	Status  string `json:"status"`
+	Code    string `json:"code"`
+	Message string `json:"message,omitempty"`
+	Chunk   *int   `json:"chunk,omitempty"`   // chunk ACKs
+	Missing []int  `json:"missing,omitempty"` // missing_chunks errors
+	Device  string `json:"device,omitempty"`  // storage directory of a registered device
+	Token   string `json:"token,omitempty"`   // device token issued when pairing, to send with later registrations
+	Resumed int    `json:"resumed,omitempty"` // start ACKs: chunks already held from an interrupted attempt
+
+	// quota_exceeded and disk_full refusals: the phone's disk use and the space left
+	Quota *QuotaUsage `json:"quota,omitempty"`
+
+	// Stored files: where the content is kept (relative to the phone directory, slash
+	// separated), how it was deduplicated if at all, and its SHA-256 as the server has it
+	Path   string `json:"path,omitempty"`
+	Dedup  string `json:"dedup,omitempty"`
+	SHA256 string `json:"sha256,omitempty"`
+}
+
+func okAck(kind, id string) Ack {
+	return Ack{Kind: kind, ID: id, Status: ackStatusOK, Code: ackCodeOK}
+}
+
+// storedAck acknowledges a file kept as name in the phone's catalog
+func storedAck(id, name, sum, dedup string) Ack {
+	ack := okAck(ackKindFile, id)
+	if dedup == ackDedupExisting {
+		ack.Code = ackCodeDuplicate
+	}
+	ack.Path, ack.SHA256, ack.Dedup = name, sum, dedup
+	return ack
+}
+
+func errorAck(kind, id, code string, err error) Ack {
+	ack := Ack{Kind: kind, ID: id, Status: ackStatusError, Code: code}
+	if err != nil {
+		ack.Message = err.Error()
+	}
+	return ack
+}
+
+func chunkAck(id string, index int, code string, err error) Ack {
+	ack := okAck(ackKindChunk, id)
+	if code != ackCodeOK {
+		ack = errorAck(ackKindChunk, id, code, err)
+	}
+	ack.Chunk = &index
+	return ack
+}
+
+// writeErrorCode classifies a failed write as disk_full or io_error
+func writeErrorCode(err error) string {
+	if errors.Is(err, errNoSpace) || errors.Is(err, syscall.ENOSPC) {
+		return ackCodeDiskFull
+	}
+	return ackCodeIO
+}
+
+// legacyReason maps a code to the reason word of the original text ACKs
+func legacyReason(kind, code string) string {
+	switch code {
+	case ackCodeDecode:
+		return "decode"
+	case ackCodeChecksum:
+		return "checksum"
+	case ackCodeInvalid:
+		return "invalid"
+	case ackCodeDiskFull, ackCodeQuota:
+		if kind == ackKindChunk {
+			return "write"
+		}
+		return "nospace"
+	case ackCodeSize:
+		return "size"
+	case ackCodeRange:
+		return "range"
+	case ackCodeNoDevice:
+		return "nodevice"
+	case ackCodeApproval:
+		return "unapproved"
+	}
+	if kind == ackKindChunk {
+		return "write"
+	}
+	return "io"
+}
+
+// legacyText renders the ACK in the original text format for clients without "json_ack"
+func (a Ack) legacyText() string {
+	switch a.Kind {
+	case ackKindChunk:
+		index := 0
+		if a.Chunk != nil {
+			index = *a.Chunk
+		}
+		if a.Status == ackStatusOK {
+			return "OK:CHUNK:" + strconv.Itoa(index)
+		}
+		return "ERR:CHUNK:" + strconv.Itoa(index) + ":" + legacyReason(a.Kind, a.Code)
+	case ackKindDevice:
+		if a.Status != ackStatusOK {
+			return "ERR:DEVICE:" + legacyReason(a.Kind, a.Code)
+		}
+		return "OK:DEVICE:" + a.Device
+	case ackKindLog:
+		if a.Status == ackStatusOK {
+			return "OK:LOG"
+		}
+		return "ERR:LOG:" + legacyReason(a.Kind, a.Code)
+	case ackKindStart:
+		if a.Status == ackStatusOK {
+			return "OK:START"
+		}
+	case ackKindBatch:
+		if a.Status == ackStatusOK {
+			return "OK:BATCH:" + a.ID
+		}
+		return "ERR:BATCH:" + a.ID + ":" + legacyReason(a.Kind, a.Code)
+	}
+	switch {
+	case a.Code == ackCodeDuplicate:
+		return "OK:" + a.ID + ":DUPLICATE"
+	case a.Status == ackStatusOK:
+		return "OK:" + a.ID
+	case a.Code == ackCodeMissing:
+		return missingChunksAck(a.ID, a.Missing)
+	}
+	return "ERR:" + a.ID + ":" + legacyReason(a.Kind, a.Code)
+}
+
+// ackSender writes ACKs for one connection, as JSON once the client negotiated "json_ack"
+// in HELLO and in the original text format otherwise
+type ackSender struct {
+	conn    net.Conn
+	json    bool
+	session *syncSession // records the outcomes for the sync summary, if set
+}
+
+func (s *ackSender) send(ack Ack) error {
+	if s.session != nil {
+		s.session.recordAck(ack)
+	}
+	if !s.json {
+		return writeMessage(s.conn, msgTypeAck, []byte(ack.legacyText()))
+	}
+	b, err := json.Marshal(ack)
+	if err != nil {
+		return err
+	}
+	return writeMessage(s.conn, msgTypeAck, b)
+}
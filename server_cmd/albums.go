@@ -0,0 +1,429 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// albumsFileName stores the shared albums in the base receive dir
+const albumsFileName = ".albums.json"
+
+// albumsMutex serializes reads and writes of the albums file
+var albumsMutex sync.Mutex
+
+// AlbumItem is one media file contributed to a shared album. The original stays in
+// its phone directory; albums only reference it.
+type AlbumItem struct {
+	Phone   string    `json:"phone"`   // phone directory holding the original
+	Name    string    `json:"name"`    // original file name within the phone directory
+	AddedBy string    `json:"addedBy"` // contributor: family member, device or "auto-share"
+	Added   time.Time `json:"added"`
+}
+
+// AutoShareRule adds newly received media to an album automatically. All set fields must match.
+type AutoShareRule struct {
+	Phone     string `json:"phone,omitempty"`     // phone directory, empty matches every phone
+	Pattern   string `json:"pattern,omitempty"`   // filepath.Match glob on the file name, e.g. "PXL_*"
+	MediaType string `json:"mediaType,omitempty"` // "photo" or "video", empty matches both
+}
+
+// Album is a shared album that any device or family member can contribute to
+type Album struct {
+	Name    string          `json:"name"`
+	Created time.Time       `json:"created"`
+	Items   []AlbumItem     `json:"items"`
+	Rules   []AutoShareRule `json:"rules,omitempty"`
+}
+
+func validAlbumName(name string) bool {
+	return name != "" && len(name) <= 100 && !strings.ContainsAny(name, "/\\") && !strings.Contains(name, "..")
+}
+
+func loadAlbums(baseDir string) (map[string]*Album, error) {
+	albums := make(map[string]*Album)
+	b, err := os.ReadFile(filepath.Join(baseDir, albumsFileName))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return albums, nil
+		}
+		return nil, err
+	}
+	if err := json.Unmarshal(b, &albums); err != nil {
+		return nil, fmt.Errorf("parse albums: %w", err)
+	}
+	return albums, nil
+}
+
+func saveAlbums(baseDir string, albums map[string]*Album) error {
+	b, err := json.MarshalIndent(albums, "", "  ")
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(baseDir, albumsFileName)
+	if err := os.WriteFile(path+".tmp", b, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// updateAlbum loads the albums, applies fn to the named album (created if missing) and saves
+func updateAlbum(baseDir, name string, fn func(a *Album) error) error {
+	if !validAlbumName(name) {
+		return fmt.Errorf("invalid album name %q", name)
+	}
+	albumsMutex.Lock()
+	defer albumsMutex.Unlock()
+
+	albums, err := loadAlbums(baseDir)
+	if err != nil {
+		return err
+	}
+	a, ok := albums[name]
+	if !ok {
+		a = &Album{Name: name, Created: clock.Now()}
+		albums[name] = a
+	}
+	if err := fn(a); err != nil {
+		return err
+	}
+	return saveAlbums(baseDir, albums)
+}
+
+// addAlbumItems adds originals from one phone directory to an album, skipping ones already in it.
+// It returns how many were added.
+func addAlbumItems(baseDir, album, phone string, names []string, addedBy string) (int, error) {
+	added := 0
+	err := updateAlbum(baseDir, album, func(a *Album) error {
+		have := make(map[string]bool)
+		for _, it := range a.Items {
+			have[it.Phone+"/"+it.Name] = true
+		}
+		for _, name := range names {
+			if have[phone+"/"+name] {
+				continue
+			}
+			a.Items = append(a.Items, AlbumItem{Phone: phone, Name: name, AddedBy: addedBy, Added: clock.Now()})
+			have[phone+"/"+name] = true
+			added++
+		}
+		return nil
+	})
+	return added, err
+}
+
+func (r AutoShareRule) matches(phone, name string) bool {
+	if r.Phone != "" && r.Phone != phone {
+		return false
+	}
+	if r.Pattern != "" {
+		if ok, _ := filepath.Match(r.Pattern, name); !ok {
+			return false
+		}
+	}
+	switch r.MediaType {
+	case "photo":
+		return hasExtension(name, photoExtensions)
+	case "video":
+		return hasExtension(name, videoExtensions)
+	}
+	return true
+}
+
+// applyAutoShareRules adds a newly received file to every album whose rules match it
+// and notifies subscribed devices about the new shared content.
+func applyAutoShareRules(config *Config, baseDir, phone, name string) {
+	albumsMutex.Lock()
+	albums, err := loadAlbums(baseDir)
+	albumsMutex.Unlock()
+	if err != nil {
+		log.Printf("Error loading albums for auto-share: %v", err)
+		return
+	}
+
+	for albumName, a := range albums {
+		matched := false
+		for _, rule := range a.Rules {
+			if rule.matches(phone, name) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			continue
+		}
+		added, err := addAlbumItems(baseDir, albumName, phone, []string{name}, "auto-share")
+		if err != nil {
+			log.Printf("Error auto-sharing %s/%s to album %s: %v", phone, name, albumName, err)
+			continue
+		}
+		if added > 0 {
+			log.Printf("Auto-shared %s/%s to album %s", phone, name, albumName)
+			notifyEvent(config, PushEvent{
+				Type:    pushEventSharedAlbum,
+				Title:   "New in " + albumName,
+				Message: fmt.Sprintf("%s added %s to %s", phone, name, albumName),
+			})
+		}
+	}
+}
+
+// registerAlbumRoutes adds the shared album API and album page to the router
+func registerAlbumRoutes(router *mux.Router, config *Config) {
+	baseDirFor := func() string {
+		if config.ReceiveDir == "" {
+			return "received"
+		}
+		return config.ReceiveDir
+	}
+
+	// List albums
+	router.HandleFunc("/api/albums", func(w http.ResponseWriter, r *http.Request) {
+		albumsMutex.Lock()
+		albums, err := loadAlbums(baseDirFor())
+		albumsMutex.Unlock()
+		if err != nil {
+			writeJSON(w, map[string]interface{}{"success": false, "error": err.Error()})
+			return
+		}
+		type albumSummary struct {
+			Name    string    `json:"name"`
+			Items   int       `json:"items"`
+			Created time.Time `json:"created"`
+		}
+		after, err := decodeListCursor(r.URL.Query().Get("cursor"))
+		if err != nil {
+			writeJSON(w, map[string]interface{}{"success": false, "error": err.Error()})
+			return
+		}
+		names := make([]string, 0, len(albums))
+		byName := make(map[string]*Album, len(albums))
+		for _, a := range albums {
+			if a.Name > after {
+				names = append(names, a.Name)
+				byName[a.Name] = a
+			}
+		}
+		sort.Strings(names)
+
+		limit, next := listPageSize(r), ""
+		if len(names) > limit {
+			names = names[:limit]
+			next = encodeListCursor(names[limit-1])
+		}
+		list := newJSONListWriter(w, "albums")
+		for _, name := range names {
+			a := byName[name]
+			list.Item(albumSummary{Name: a.Name, Items: len(a.Items), Created: a.Created})
+		}
+		list.Close(next)
+	}).Methods("GET")
+
+	// Create an album
+	router.HandleFunc("/api/albums", func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Name string `json:"name"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeJSON(w, map[string]interface{}{"success": false, "error": "Invalid request: " + err.Error()})
+			return
+		}
+		if err := updateAlbum(baseDirFor(), req.Name, func(a *Album) error { return nil }); err != nil {
+			writeJSON(w, map[string]interface{}{"success": false, "error": err.Error()})
+			return
+		}
+		writeJSON(w, map[string]interface{}{"success": true, "name": req.Name})
+	}).Methods("POST")
+
+	// Contribute photos (thumbnail or original names, as sent by the gallery) to an album
+	router.HandleFunc("/api/albums/{album}/items", func(w http.ResponseWriter, r *http.Request) {
+		album := mux.Vars(r)["album"]
+		var req struct {
+			PhoneName string   `json:"phoneName"`
+			Photos    []string `json:"photos"`
+			AddedBy   string   `json:"addedBy"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeJSON(w, map[string]interface{}{"success": false, "error": "Invalid request: " + err.Error()})
+			return
+		}
+		if validatePhoneName(req.PhoneName) != nil {
+			writeJSON(w, map[string]interface{}{"success": false, "error": "Invalid phone name"})
+			return
+		}
+
+		phoneDir := filepath.Join(baseDirFor(), req.PhoneName)
+		var names []string
+		for _, photo := range req.Photos {
+			if orig, ok := originalForThumbnail(phoneDir, photo); ok {
+				names = append(names, filepath.Base(orig))
+			}
+		}
+		if len(names) == 0 {
+			writeJSON(w, map[string]interface{}{"success": false, "error": "No valid photos selected"})
+			return
+		}
+		addedBy := req.AddedBy
+		if addedBy == "" {
+			addedBy = req.PhoneName
+		}
+
+		added, err := addAlbumItems(baseDirFor(), album, req.PhoneName, names, addedBy)
+		if err != nil {
+			writeJSON(w, map[string]interface{}{"success": false, "error": err.Error()})
+			return
+		}
+		if added > 0 {
+			countFeature("album_add")
+			notifyEvent(config, PushEvent{
+				Type:    pushEventSharedAlbum,
+				Title:   "New in " + album,
+				Message: fmt.Sprintf("%s added %d item(s) to %s", addedBy, added, album),
+			})
+		}
+		writeJSON(w, map[string]interface{}{"success": true, "added": added})
+	}).Methods("POST")
+
+	// Add an opt-in auto-share rule to an album
+	router.HandleFunc("/api/albums/{album}/rules", func(w http.ResponseWriter, r *http.Request) {
+		album := mux.Vars(r)["album"]
+		var rule AutoShareRule
+		if err := json.NewDecoder(r.Body).Decode(&rule); err != nil {
+			writeJSON(w, map[string]interface{}{"success": false, "error": "Invalid request: " + err.Error()})
+			return
+		}
+		if rule.Pattern != "" {
+			if _, err := filepath.Match(rule.Pattern, ""); err != nil {
+				writeJSON(w, map[string]interface{}{"success": false, "error": "Invalid pattern: " + err.Error()})
+				return
+			}
+		}
+		if rule.MediaType != "" && rule.MediaType != "photo" && rule.MediaType != "video" {
+			writeJSON(w, map[string]interface{}{"success": false, "error": "mediaType must be photo or video"})
+			return
+		}
+		err := updateAlbum(baseDirFor(), album, func(a *Album) error {
+			a.Rules = append(a.Rules, rule)
+			return nil
+		})
+		if err != nil {
+			writeJSON(w, map[string]interface{}{"success": false, "error": err.Error()})
+			return
+		}
+		writeJSON(w, map[string]interface{}{"success": true})
+	}).Methods("POST")
+
+	// Album page: combined timeline of all contributions, newest first
+	router.HandleFunc("/album/{album}", func(w http.ResponseWriter, r *http.Request) {
+		albumName := mux.Vars(r)["album"]
+		baseDir := baseDirFor()
+
+		albumsMutex.Lock()
+		albums, err := loadAlbums(baseDir)
+		albumsMutex.Unlock()
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Error reading albums: %v", err), http.StatusInternalServerError)
+			return
+		}
+		a, ok := albums[albumName]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+
+		type timelineItem struct {
+			AlbumItem
+			Thumb    string
+			IsVideo  bool
+			Taken    time.Time
+			Comments []Comment
+		}
+		var items []timelineItem
+		for _, it := range a.Items {
+			path := filepath.Join(baseDir, it.Phone, it.Name)
+			info, err := os.Stat(path)
+			if err != nil {
+				continue // original was deleted
+			}
+			items = append(items, timelineItem{
+				AlbumItem: it,
+				Thumb:     thumbnailName(it.Name),
+				IsVideo:   hasExtension(it.Name, videoExtensions),
+				Taken:     openCatalog(filepath.Join(baseDir, it.Phone)).CaptureTime(path, info),
+				Comments:  openCatalog(filepath.Join(baseDir, it.Phone)).CommentsFor(path),
+			})
+		}
+		sort.Slice(items, func(i, j int) bool { return items[i].Taken.After(items[j].Taken) })
+
+		contributors := make(map[string]int)
+		for _, it := range items {
+			contributors[it.AddedBy]++
+		}
+
+		tmpl := `<!DOCTYPE html>
+<html>
+<head>
+    <title>{{.Name}} - Shared Album</title>
+    <style>
+        body { font-family: 'Segoe UI', Tahoma, Arial, sans-serif; margin: 0; padding: 20px; background: #000000; color: #ffffff; }
+        h1 { color: #ffffff; font-weight: 300; letter-spacing: 1px; }
+        .back-link { display: inline-block; margin-bottom: 20px; color: #88aaff; text-decoration: none; font-size: 14px; }
+        .back-link:hover { color: #aaccff; text-decoration: underline; }
+        .contributors { color: #aaaaaa; font-size: 14px; margin-bottom: 20px; }
+        .gallery { display: grid; grid-template-columns: repeat(auto-fill, minmax(200px, 1fr)); gap: 20px; padding: 10px; }
+        .gallery-item { background: #1a1a1a; padding: 10px; border-radius: 12px; text-align: center; border: 1px solid #2a2a2a; }
+        .gallery-item img { width: 180px; height: 180px; object-fit: cover; border-radius: 8px; }
+        .caption { margin-top: 8px; font-size: 12px; color: #888888; word-break: break-all; }
+        .by { color: #667eea; }
+        .comment { margin-top: 6px; font-size: 12px; color: #cccccc; text-align: left; }
+    </style>
+</head>
+<body>
+    <a href="/" class="back-link">← Back to Home</a>
+    <h1>📚 {{.Name}}</h1>
+    <div class="contributors">{{len .Items}} items{{range $who, $n := .Contributors}} · <span class="by">{{$who}}</span> ({{$n}}){{end}}</div>
+    {{if .Items}}
+    <div class="gallery">
+        {{range .Items}}
+        <div class="gallery-item">
+            <a href="/orig/{{.Phone}}/{{.Name}}" target="_blank">
+                <img src="/thumb/{{.Phone}}/{{.Thumb}}" alt="{{.Name}}" loading="lazy" />
+            </a>
+            <div class="caption">{{if .IsVideo}}🎬 {{end}}{{.Name}}<br>by <span class="by">{{.AddedBy}}</span> from {{.Phone}} · {{.Taken.Format "2006-01-02"}}</div>
+            {{range .Comments}}
+            <div class="comment"><span class="by">{{.Author}}</span>: {{.Text}}</div>
+            {{end}}
+        </div>
+        {{end}}
+    </div>
+    {{else}}
+    <p>This album is empty.</p>
+    {{end}}
+</body>
+</html>`
+
+		t := template.Must(template.New("album").Parse(tmpl))
+		data := struct {
+			Name         string
+			Items        []timelineItem
+			Contributors map[string]int
+		}{
+			Name:         a.Name,
+			Items:        items,
+			Contributors: contributors,
+		}
+
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		t.Execute(w, data)
+	}).Methods("GET")
+}
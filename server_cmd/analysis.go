@@ -0,0 +1,435 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	// analyzerTimeout is how long an analyzer may take over one file unless configured
+	analyzerTimeout = 2 * time.Minute
+
+	// maxAnalysisText caps the text kept per analyzer and file, so a scanned book page
+	// doesn't bloat the catalog
+	maxAnalysisText = 16 << 10
+)
+
+// AnalyzerConfig adds an analysis plugin, which looks at new photos (and videos, if asked)
+// and reports tags, labels, an embedding and text, kept in the catalog under its name.
+// Type "command" (the default) runs Command with Args, then the file's path and its
+// metadata as AnalysisInput JSON, and expects Analysis JSON on stdout; see
+// analyzer_example. Type "tesseract" reads the text of screenshots and documents, see ocr.go.
+type AnalyzerConfig struct {
+	Name       string   `json:"name"`
+	Type       string   `json:"type"`
+	Command    string   `json:"command"`
+	Args       []string `json:"args"`
+	Media      []string `json:"media"`       // "photo" and/or "video" (default photos only)
+	TimeoutSec int      `json:"timeout_sec"` // per file (default 120)
+
+	Lang  string `json:"lang"`  // tesseract: the languages to read, e.g. "eng+deu" (default eng)
+	Scope string `json:"scope"` // tesseract: "documents" (default) or "all" photos
+}
+
+// AnalysisInput is what an analyzer is told about a file besides its content
+type AnalysisInput struct {
+	Path  string     `json:"path"`  // absolute path of the original
+	Phone string     `json:"phone"` // phone directory name
+	Name  string     `json:"name"`  // path in the phone directory
+	Media string     `json:"media"` // "photo" or "video"
+	Size  int64      `json:"size"`
+	Taken *time.Time `json:"taken,omitempty"`
+	Exif  *ExifInfo  `json:"exif,omitempty"`
+	Place *Place     `json:"place,omitempty"`
+	Geo   *GeoPlace  `json:"geo,omitempty"`
+}
+
+// Analysis is what one analyzer found in a file; all of it is optional
+type Analysis struct {
+	Tags      []string        `json:"tags,omitempty"`      // words to find the file by, e.g. "cat", "beach"
+	Labels    []AnalysisLabel `json:"labels,omitempty"`    // detected things with a confidence
+	Embedding []float32       `json:"embedding,omitempty"` // a vector for similarity search
+	Text      string          `json:"text,omitempty"`      // text read in the picture, searchable from the gallery
+	Analyzed  time.Time       `json:"analyzed"`
+}
+
+// AnalysisLabel is one thing an analyzer detected, with its confidence from 0 to 1
+type AnalysisLabel struct {
+	Name  string  `json:"name"`
+	Score float64 `json:"score"`
+}
+
+// mediaAnalyzer is an analysis plugin. The server runs each over every file it handles
+// once, and again when the file's content changes.
+type mediaAnalyzer interface {
+	name() string
+	handles(media string) bool
+	analyze(ctx context.Context, in *AnalysisInput) (*Analysis, error)
+}
+
+// analyzerTypes makes analyzers from their config by type. Built-in analyzers add
+// themselves here; anything else runs as a "command".
+var analyzerTypes = map[string]func(AnalyzerConfig) (mediaAnalyzer, error){
+	"command":   newCommandAnalyzer,
+	"tesseract": newTesseractAnalyzer,
+}
+
+var (
+	// analyzers are the analysis plugins in use, set from the config at startup
+	analyzers []mediaAnalyzer
+
+	// analysisSlot lets one file at a time be analyzed across all phones, so analysis
+	// never takes more than a core from syncing
+	analysisSlot = make(chan struct{}, 1)
+
+	analysisStatsMutex sync.Mutex
+	analysisErrors     = make(map[string]int) // analyzer name -> files it failed on since the start
+)
+
+// setAnalyzers enables the configured analysis plugins
+func setAnalyzers(config *Config) error {
+	seen := make(map[string]bool)
+	var list []mediaAnalyzer
+	for _, ac := range config.Analyzers {
+		if ac.Name == "" || strings.ContainsAny(ac.Name, "/\\") {
+			return fmt.Errorf("analyzer needs a plain name, got %q", ac.Name)
+		}
+		if seen[ac.Name] {
+			return fmt.Errorf("analyzer %s is configured twice", ac.Name)
+		}
+		seen[ac.Name] = true
+		if ac.Type == "" {
+			ac.Type = "command"
+		}
+		for _, m := range ac.Media {
+			if m != "photo" && m != "video" {
+				return fmt.Errorf("analyzer %s: unknown media %q", ac.Name, m)
+			}
+		}
+		newAnalyzer, ok := analyzerTypes[strings.ToLower(ac.Type)]
+		if !ok {
+			return fmt.Errorf("analyzer %s: unknown type %q", ac.Name, ac.Type)
+		}
+		a, err := newAnalyzer(ac)
+		if err != nil {
+			return fmt.Errorf("analyzer %s: %w", ac.Name, err)
+		}
+		list = append(list, a)
+	}
+	if len(list) == 0 {
+		return nil
+	}
+	analyzers = list
+	names := make([]string, len(list))
+	for i, a := range list {
+		names[i] = a.name()
+	}
+	log.Printf("Analyzers enabled: %s", strings.Join(names, ", "))
+	return nil
+}
+
+// mediaKind is the analyzer media type of a file, empty for neither photo nor video
+func mediaKind(path string) string {
+	switch {
+	case hasExtension(path, photoExtensions):
+		return "photo"
+	case hasExtension(path, videoExtensions):
+		return "video"
+	}
+	return ""
+}
+
+// handlesMedia implements handles for a configured media list, photos by default
+func handlesMedia(configured []string, media string) bool {
+	if len(configured) == 0 {
+		return media == "photo"
+	}
+	for _, m := range configured {
+		if m == media {
+			return true
+		}
+	}
+	return false
+}
+
+// pendingAnalyzers returns the analyzers that haven't looked at a file yet
+func pendingAnalyzers(e *CatalogEntry) []mediaAnalyzer {
+	if e.Archived || e.Damaged != "" || e.SHA256 == "" {
+		return nil
+	}
+	media := mediaKind(e.Name)
+	var pending []mediaAnalyzer
+	for _, a := range analyzers {
+		if _, done := e.Analysis[a.name()]; !done && media != "" && a.handles(media) {
+			pending = append(pending, a)
+		}
+	}
+	return pending
+}
+
+// analyzeLater queues a file for the analyzers, through the jobs of its phone
+func analyzeLater(phoneDir, path string) {
+	if len(analyzers) == 0 {
+		return
+	}
+	jobsFor(phoneDir).analyzeLater(path)
+}
+
+// analyzeLater queues a file of the phone for the analyzers and starts the phone's
+// analysis run unless one is going. A file queued twice is analyzed once.
+func (j *phoneJobs) analyzeLater(path string) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.analysisQueued[path] {
+		return
+	}
+	j.analysisQueued[path] = true
+	j.analysisPending = append(j.analysisPending, path)
+	if j.analysisCancel != nil {
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	j.analysisGeneration++
+	j.analysisCancel = cancel
+	j.running.Add(1)
+	go j.runAnalysis(ctx, j.analysisGeneration, cancel)
+}
+
+// cancelAnalysis stops the phone's analysis run and drops its queue; the files are left
+// to the backfill at the next start
+func (j *phoneJobs) cancelAnalysis(reason string) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.analysisCancel != nil {
+		log.Printf("Cancelling analysis for %s (%s)\n", j.dir, reason)
+		j.analysisCancel()
+		j.analysisCancel = nil
+	}
+	j.analysisPending = nil
+	clear(j.analysisQueued)
+}
+
+// runAnalysis works through the phone's queued files until none are left or ctx ends.
+// A run cancelled meanwhile leaves a newer one alone.
+func (j *phoneJobs) runAnalysis(ctx context.Context, generation uint64, cancel context.CancelFunc) {
+	defer j.running.Done()
+	defer cancel()
+	for {
+		j.mu.Lock()
+		if ctx.Err() != nil {
+			j.mu.Unlock()
+			return
+		}
+		if len(j.analysisPending) == 0 || draining() {
+			if j.analysisGeneration == generation {
+				j.analysisCancel = nil
+			}
+			j.mu.Unlock()
+			return
+		}
+		path := j.analysisPending[0]
+		j.analysisPending = j.analysisPending[1:]
+		delete(j.analysisQueued, path)
+		j.mu.Unlock()
+
+		select {
+		case analysisSlot <- struct{}{}:
+		case <-ctx.Done():
+			return
+		}
+		analyzeFile(ctx, j.dir, path)
+		<-analysisSlot
+	}
+}
+
+// analyzeFile runs the analyzers that haven't looked at a file yet
+func analyzeFile(ctx context.Context, phoneDir, path string) {
+	catalog := openCatalog(phoneDir)
+	e, ok := catalog.Entry(path)
+	if !ok {
+		return
+	}
+	in := &AnalysisInput{Phone: filepath.Base(phoneDir), Name: e.Name, Media: mediaKind(e.Name),
+		Size: e.Size, Taken: e.Taken, Exif: e.Exif, Place: e.Place, Geo: e.Geo}
+	in.Path, _ = filepath.Abs(entryPath(phoneDir, &e))
+	if in.Exif == nil && in.Media == "photo" {
+		in.Exif = catalog.Exif(entryPath(phoneDir, &e))
+	}
+	for _, a := range pendingAnalyzers(&e) {
+		result, err := a.analyze(ctx, in)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			log.Printf("Analyzer %s: error analyzing %s: %v", a.name(), path, err)
+			analysisStatsMutex.Lock()
+			analysisErrors[a.name()]++
+			analysisStatsMutex.Unlock()
+			continue
+		}
+		if len(result.Text) > maxAnalysisText {
+			result.Text = strings.ToValidUTF8(result.Text[:maxAnalysisText], "")
+		}
+		result.Analyzed = clock.Now()
+		catalog.SetAnalysis(path, e.SHA256, a.name(), result)
+	}
+}
+
+// analyzeLibrary queues the files some analyzer hasn't looked at, e.g. after one was
+// added to an existing library
+func analyzeLibrary(baseDir string) {
+	if len(analyzers) == 0 {
+		return
+	}
+	for _, phone := range libraryPhones(baseDir) {
+		phoneDir := filepath.Join(baseDir, phone)
+		for _, e := range openCatalog(phoneDir).AllEntries() {
+			if draining() {
+				return
+			}
+			if len(pendingAnalyzers(&e)) > 0 {
+				jobsFor(phoneDir).analyzeLater(openCatalog(phoneDir).Path(e.Name))
+			}
+		}
+	}
+}
+
+// commandAnalyzer runs a program for each file: the example external-process plugin
+type commandAnalyzer struct {
+	config  AnalyzerConfig
+	timeout time.Duration
+}
+
+func newCommandAnalyzer(ac AnalyzerConfig) (mediaAnalyzer, error) {
+	if ac.Command == "" {
+		return nil, fmt.Errorf("no command configured")
+	}
+	if _, err := tools.LookPath(ac.Command); err != nil {
+		return nil, err
+	}
+	timeout := analyzerTimeout
+	if ac.TimeoutSec > 0 {
+		timeout = time.Duration(ac.TimeoutSec) * time.Second
+	}
+	return &commandAnalyzer{config: ac, timeout: timeout}, nil
+}
+
+func (a *commandAnalyzer) name() string { return a.config.Name }
+
+func (a *commandAnalyzer) handles(media string) bool { return handlesMedia(a.config.Media, media) }
+
+func (a *commandAnalyzer) analyze(ctx context.Context, in *AnalysisInput) (*Analysis, error) {
+	meta, err := json.Marshal(in)
+	if err != nil {
+		return nil, err
+	}
+	args := append(append([]string{}, a.config.Args...), in.Path, string(meta))
+	output, err := runTool(ctx, a.timeout, a.config.Command, args...)
+	if err != nil {
+		return nil, fmt.Errorf("%s failed: %v, output: %s", a.config.Command, err, strings.TrimSpace(string(output)))
+	}
+	var result Analysis
+	if err := json.Unmarshal(output, &result); err != nil {
+		return nil, fmt.Errorf("%s printed no analysis JSON: %w", a.config.Command, err)
+	}
+	return &result, nil
+}
+
+// registerAnalysisRoutes adds the tags the analyzers found, across the library or for
+// one phone
+func registerAnalysisRoutes(router *mux.Router, config *Config) {
+	router.HandleFunc("/api/tags", func(w http.ResponseWriter, r *http.Request) {
+		baseDir := config.ReceiveDir
+		if baseDir == "" {
+			baseDir = "received"
+		}
+		phones := libraryPhones(baseDir)
+		if phone := r.URL.Query().Get("phone"); phone != "" {
+			phones = []string{phone}
+		}
+
+		type tagGroup struct {
+			Tag       string         `json:"tag"`
+			Count     int            `json:"count"`
+			Analyzers []string       `json:"analyzers"`
+			Phones    map[string]int `json:"phones"`
+			by        map[string]bool
+		}
+		groups := make(map[string]*tagGroup)
+		analyzed := 0
+		for _, phone := range phones {
+			if validatePhoneName(phone) != nil {
+				continue
+			}
+			for _, e := range openCatalog(filepath.Join(baseDir, phone)).AllEntries() {
+				if len(e.Analysis) == 0 {
+					continue
+				}
+				analyzed++
+				tagged := make(map[string]bool)
+				for name, a := range e.Analysis {
+					for _, tag := range a.Tags {
+						tag = strings.ToLower(strings.TrimSpace(tag))
+						if tag == "" {
+							continue
+						}
+						g, ok := groups[tag]
+						if !ok {
+							g = &tagGroup{Tag: tag, Phones: make(map[string]int), by: make(map[string]bool)}
+							groups[tag] = g
+						}
+						g.by[name] = true
+						if !tagged[tag] {
+							tagged[tag] = true
+							g.Count++
+							g.Phones[phone]++
+						}
+					}
+				}
+			}
+		}
+		list := make([]*tagGroup, 0, len(groups))
+		for _, g := range groups {
+			for name := range g.by {
+				g.Analyzers = append(g.Analyzers, name)
+			}
+			sort.Strings(g.Analyzers)
+			list = append(list, g)
+		}
+		sort.Slice(list, func(i, j int) bool {
+			if list[i].Count != list[j].Count {
+				return list[i].Count > list[j].Count
+			}
+			return list[i].Tag < list[j].Tag
+		})
+
+		names := make([]string, 0, len(analyzers))
+		analysisStatsMutex.Lock()
+		errors := make(map[string]int, len(analysisErrors))
+		for name, n := range analysisErrors {
+			errors[name] = n
+		}
+		analysisStatsMutex.Unlock()
+		for _, a := range analyzers {
+			names = append(names, a.name())
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success":   true,
+			"tags":      list,
+			"analyzed":  analyzed, // files some analyzer has looked at
+			"analyzers": names,
+			"errors":    errors, // files each analyzer failed on since the start
+		})
+	}).Methods("GET")
+}
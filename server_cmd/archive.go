@@ -0,0 +1,494 @@
+package main
+
+import (
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// archiveInterval is how often the archive policy looks for originals to move
+const archiveInterval = 24 * time.Hour
+
+// ArchiveConfig sets when and how originals move to archive_dir, a cold storage tier for
+// originals that are rarely opened: archived originals move there, as
+// <archive_dir>/<phone>/<name>, while their thumbnails and catalog entries stay, so the
+// gallery, search and albums work as before. Opening an archived original copies it back
+// first. Originals are archived from the gallery or the storage page, or by this policy.
+type ArchiveConfig struct {
+	AfterDays int  `json:"after_days"` // archive originals taken longer ago than this, daily; 0 archives only on request. Favorites stay online.
+	Compress  bool `json:"compress"`   // store originals gzip-compressed, as <name>.gz
+}
+
+var (
+	// archiveDir is config.ArchiveDir once checked, empty without an archive
+	archiveDir string
+	// archivePolicy is config.Archive, or the defaults without one
+	archivePolicy ArchiveConfig
+
+	archiveMutex    sync.Mutex       // one move in or out of the archive at a time
+	retrievalsMutex sync.Mutex       // guards retrievals
+	retrievals      map[string]error // phone dir/name -> nil while being retrieved, else why it failed
+)
+
+// setArchive validates the archive and enables it
+func setArchive(config *Config) error {
+	if config.ArchiveDir == "" {
+		if config.Archive != nil {
+			return fmt.Errorf("archive needs archive_dir")
+		}
+		return nil
+	}
+	if config.Archive != nil {
+		if config.Archive.AfterDays < 0 {
+			return fmt.Errorf("after_days must not be negative")
+		}
+		archivePolicy = *config.Archive
+	}
+	baseDir := config.ReceiveDir
+	if baseDir == "" {
+		baseDir = "received"
+	}
+	absBase, _ := filepath.Abs(baseDir)
+	abs, err := filepath.Abs(config.ArchiveDir)
+	if err != nil {
+		return err
+	}
+	if abs == absBase || strings.HasPrefix(abs, absBase+string(filepath.Separator)) ||
+		strings.HasPrefix(absBase, abs+string(filepath.Separator)) {
+		return fmt.Errorf("%s overlaps the receive directory", config.ArchiveDir)
+	}
+	// Like a storage pool, an archive that isn't mounted just fails moves until it is
+	if info, err := os.Stat(config.ArchiveDir); err != nil || !info.IsDir() {
+		log.Printf("Archive: %s is not available", config.ArchiveDir)
+	}
+	archiveDir = config.ArchiveDir
+	policy := "on request only"
+	if archivePolicy.AfterDays > 0 {
+		policy = fmt.Sprintf("originals older than %d days", archivePolicy.AfterDays)
+	}
+	log.Printf("Archive enabled at %s (%s)", archiveDir, policy)
+	return nil
+}
+
+// archivedCopy returns where the archived original of a catalog name is, compressed or not
+func archivedCopy(phoneDir, name string) (string, bool) {
+	if archiveDir == "" {
+		return "", false
+	}
+	p := filepath.Join(archiveDir, filepath.Base(phoneDir), filepath.FromSlash(name))
+	for _, candidate := range []string{p + ".gz", p} {
+		if info, err := os.Stat(candidate); err == nil && !info.IsDir() {
+			return candidate, true
+		}
+	}
+	return "", false
+}
+
+// archivedTrashPath returns where the archived original of a trashed catalog name is kept:
+// in the trash of the phone's archive directory, compressed or not
+func archivedTrashPath(phoneDir, name string) string {
+	p := filepath.Join(archiveDir, filepath.Base(phoneDir), trashDirName, filepath.FromSlash(name))
+	if _, err := os.Stat(p + ".gz"); err == nil {
+		return p + ".gz"
+	}
+	return p
+}
+
+// restoredArchivePath returns where an archived original restored from trashed, its place
+// in the archive's trash, goes back to in the archive
+func restoredArchivePath(phoneDir, name, trashed string) string {
+	p := filepath.Join(archiveDir, filepath.Base(phoneDir), filepath.FromSlash(name))
+	if strings.HasSuffix(trashed, ".gz") && !strings.HasSuffix(p, ".gz") {
+		p += ".gz"
+	}
+	return p
+}
+
+// copyVerified copies src to dest through a partial file, compressing or decompressing on
+// the way, and only puts it in place once the content matches sum. dest keeps src's
+// modification time, so the catalog knows the original again when it comes back.
+func copyVerified(src, dest, sum string, compress, decompress bool) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+	var r io.Reader = in
+	if decompress {
+		gz, err := gzip.NewReader(in)
+		if err != nil {
+			return err
+		}
+		r = gz
+	}
+	if err := os.MkdirAll(filepath.Dir(dest), 0o755); err != nil {
+		return err
+	}
+	tmp := dest + partialFileSuffix
+	out, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	h := sha256.New()
+	var w io.Writer = out
+	var gw *gzip.Writer
+	if compress {
+		gw = gzip.NewWriter(out)
+		w = gw
+	}
+	_, err = io.Copy(io.MultiWriter(w, h), r)
+	if err == nil && gw != nil {
+		err = gw.Close()
+	}
+	if err == nil {
+		err = out.Sync()
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil && !strings.EqualFold(hex.EncodeToString(h.Sum(nil)), sum) {
+		err = fmt.Errorf("copy of %s doesn't match its checksum", src)
+	}
+	if err == nil {
+		os.Chtimes(tmp, info.ModTime(), info.ModTime())
+		err = os.Rename(tmp, dest)
+	}
+	if err != nil {
+		os.Remove(tmp)
+	}
+	return err
+}
+
+// archiveFile moves the original of a catalog name to the archive. The online file is
+// only removed once the archived copy is verified.
+func archiveFile(phoneDir, name string) error {
+	if archiveDir == "" {
+		return fmt.Errorf("no archive_dir configured")
+	}
+	archiveMutex.Lock()
+	defer archiveMutex.Unlock()
+
+	catalog := openCatalog(phoneDir)
+	src := catalog.Path(name)
+	e, ok := catalog.Entry(src)
+	switch {
+	case !ok:
+		return fmt.Errorf("%s is not in the catalog", name)
+	case e.Archived:
+		return nil
+	case e.SHA256 == "":
+		return fmt.Errorf("%s has no checksum yet", name)
+	case e.Damaged != "":
+		return fmt.Errorf("%s is damaged: %s", name, e.Damaged)
+	}
+	dest := filepath.Join(archiveDir, filepath.Base(phoneDir), filepath.FromSlash(name))
+	if archivePolicy.Compress {
+		dest += ".gz"
+	}
+	if _, ok := archivedCopy(phoneDir, name); ok {
+		return fmt.Errorf("%s is already in the archive", name)
+	}
+	if err := copyVerified(src, dest, e.SHA256, archivePolicy.Compress, false); err != nil {
+		return err
+	}
+	catalog.SetArchived(name, true, "")
+	if err := os.Remove(src); err != nil {
+		log.Printf("Archive: error removing %s after archiving it: %v", src, err)
+	}
+	log.Printf("Archive: moved %s (%s) to %s", src, formatBytes(e.Size), dest)
+	return nil
+}
+
+// retrieveFile copies an archived original back where new originals go, the phone
+// directory or a storage pool with room, and drops the archived copy
+func retrieveFile(phoneDir, name string) error {
+	archiveMutex.Lock()
+	defer archiveMutex.Unlock()
+
+	catalog := openCatalog(phoneDir)
+	e, ok := catalog.Entry(catalog.Path(name))
+	if !ok || !e.Archived {
+		return nil // retrieved already, or stored again since
+	}
+	src, ok := archivedCopy(phoneDir, name)
+	if !ok {
+		return fmt.Errorf("%s is not in the archive, is it mounted?", name)
+	}
+	dest := spillPath(phoneDir, filepath.Join(phoneDir, filepath.FromSlash(name)), e.Size)
+	if err := copyVerified(src, dest, e.SHA256, false, strings.HasSuffix(src, ".gz")); err != nil {
+		return err
+	}
+	pool := ""
+	if p, _, ok := poolOf(phoneDir, dest); ok {
+		pool = p
+	}
+	catalog.SetArchived(name, false, pool)
+	if err := os.Remove(src); err != nil {
+		log.Printf("Archive: error removing %s after retrieving it: %v", src, err)
+	}
+	log.Printf("Archive: retrieved %s", dest)
+	return nil
+}
+
+// trashArchived moves an archived original to the trash without retrieving it: within the
+// archive, into its trash, where "Recently deleted" can restore it (to the archive) until
+// the trash expires
+func trashArchived(phoneDir, name string) error {
+	archiveMutex.Lock()
+	defer archiveMutex.Unlock()
+
+	catalog := openCatalog(phoneDir)
+	e, ok := catalog.Entry(catalog.Path(name))
+	if !ok || !e.Archived {
+		return fmt.Errorf("%s is not archived", name)
+	}
+	src, ok := archivedCopy(phoneDir, name)
+	if !ok {
+		return fmt.Errorf("%s is not in the archive, is it mounted?", name)
+	}
+	dest := filepath.Join(archiveDir, filepath.Base(phoneDir), trashDirName, filepath.FromSlash(name))
+	if strings.HasSuffix(src, ".gz") && !strings.HasSuffix(dest, ".gz") {
+		dest += ".gz"
+	}
+	if err := os.MkdirAll(filepath.Dir(dest), 0o755); err != nil {
+		return err
+	}
+	if err := os.Rename(src, dest); err != nil {
+		return err
+	}
+	catalog.TrashArchived(name)
+	log.Printf("Archive: moved %s to the trash", src)
+	return nil
+}
+
+// requestRetrieval starts copying an archived original back, unless that is under way
+func requestRetrieval(phoneDir, name string) {
+	key := phoneDir + "/" + name
+	retrievalsMutex.Lock()
+	if err, ok := retrievals[key]; ok && err == nil {
+		retrievalsMutex.Unlock()
+		return
+	}
+	if retrievals == nil {
+		retrievals = make(map[string]error)
+	}
+	retrievals[key] = nil
+	retrievalsMutex.Unlock()
+
+	go func() {
+		err := retrieveFile(phoneDir, name)
+		retrievalsMutex.Lock()
+		defer retrievalsMutex.Unlock()
+		if err != nil {
+			log.Printf("Archive: error retrieving %s/%s: %v", filepath.Base(phoneDir), name, err)
+			retrievals[key] = err
+		} else {
+			delete(retrievals, key)
+		}
+	}()
+}
+
+// retrievalState reports whether an archived original is being retrieved, or why its
+// last retrieval failed
+func retrievalState(phoneDir, name string) (running bool, err error) {
+	retrievalsMutex.Lock()
+	defer retrievalsMutex.Unlock()
+	err, ok := retrievals[phoneDir+"/"+name]
+	return ok && err == nil, err
+}
+
+// serveArchived answers a request for an original that is in the archive: it starts
+// the retrieval and tells the client to come back. It reports whether it answered.
+func serveArchived(w http.ResponseWriter, phoneDir, name string) bool {
+	key, ok := openCatalog(phoneDir).ArchivedFile(name)
+	if !ok {
+		return false
+	}
+	requestRetrieval(phoneDir, key)
+	countFeature("archive_retrieve")
+	w.Header().Set("Retry-After", "5")
+	http.Error(w, "The original is being retrieved from the archive, try again shortly", http.StatusServiceUnavailable)
+	return true
+}
+
+// archivedVideos lists a phone's archived videos for the gallery, which finds videos by
+// their files; archived photos are listed by their thumbnails
+func archivedVideos(phoneDir string) []string {
+	if archiveDir == "" {
+		return nil
+	}
+	var names []string
+	for _, e := range openCatalog(phoneDir).AllEntries() {
+		if e.Archived && hasExtension(e.Name, videoExtensions) {
+			names = append(names, path.Base(e.Name))
+		}
+	}
+	return names
+}
+
+// runArchive moves the originals the policy selects to the archive: those taken (or,
+// without a capture time, modified) more than after_days ago, except favorites and the
+// ones retrieved within after_days
+func runArchive(config *Config) {
+	baseDir := config.ReceiveDir
+	if baseDir == "" {
+		baseDir = "received"
+	}
+	cutoff := clock.Now().Add(-time.Duration(archivePolicy.AfterDays) * 24 * time.Hour)
+	moved, bytes := 0, int64(0)
+	for _, phone := range libraryPhones(baseDir) {
+		phoneDir := filepath.Join(baseDir, phone)
+		for _, e := range openCatalog(phoneDir).AllEntries() {
+			if draining() {
+				return
+			}
+			if e.Archived || e.Favorite || e.Damaged != "" || e.Pool != "" && !poolOnline(e.Pool) {
+				continue
+			}
+			if e.Retrieved != nil && e.Retrieved.After(cutoff) {
+				continue // viewed lately, so not that cold
+			}
+			taken := e.ModTime
+			if e.Taken != nil {
+				taken = *e.Taken
+			}
+			if taken.After(cutoff) {
+				continue
+			}
+			if err := archiveFile(phoneDir, e.Name); err != nil {
+				log.Printf("Archive: %s/%s: %v", phone, e.Name, err)
+				continue
+			}
+			moved++
+			bytes += e.Size
+		}
+	}
+	if moved > 0 {
+		log.Printf("Archive: moved %d original(s), %s, taken before %s", moved, formatBytes(bytes), cutoff.Format("2006-01-02"))
+	}
+}
+
+// startArchive runs the archive policy now and then daily
+func startArchive(config *Config) {
+	if archiveDir == "" || archivePolicy.AfterDays <= 0 {
+		return
+	}
+	runArchive(config)
+	ticker := clock.NewTicker(archiveInterval)
+	defer ticker.Stop()
+	for range ticker.C() {
+		runArchive(config)
+	}
+}
+
+// registerArchiveRoutes adds archiving and retrieving originals from the gallery, and
+// the state of an archived original for the photo viewer
+func registerArchiveRoutes(router *mux.Router, config *Config) {
+	decodePhotos := func(w http.ResponseWriter, r *http.Request) (string, []string, bool) {
+		phoneDir, err := phoneDirFromRequest(config, r)
+		if err != nil {
+			writeJSON(w, map[string]interface{}{"success": false, "error": "Invalid phone name"})
+			return "", nil, false
+		}
+		var req struct {
+			Photos []string `json:"photos"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || len(req.Photos) == 0 {
+			writeJSON(w, map[string]interface{}{"success": false, "error": "No photos selected"})
+			return "", nil, false
+		}
+		return phoneDir, req.Photos, true
+	}
+
+	handleTransfer(router, "/api/phones/{phoneName}/archive", func(w http.ResponseWriter, r *http.Request) {
+		if archiveDir == "" {
+			writeJSON(w, map[string]interface{}{"success": false, "error": "No archive_dir is configured"})
+			return
+		}
+		phoneDir, photos, ok := decodePhotos(w, r)
+		if !ok {
+			return
+		}
+		catalog := openCatalog(phoneDir)
+		archived := 0
+		errors := []string{}
+		for _, photo := range photos {
+			if strings.Contains(photo, "..") {
+				continue
+			}
+			if _, ok := catalog.ArchivedFile(photo); ok {
+				continue
+			}
+			orig, ok := originalForThumbnail(phoneDir, photo)
+			if !ok {
+				errors = append(errors, fmt.Sprintf("%s: original not found", photo))
+				continue
+			}
+			e, ok := catalog.Entry(orig)
+			if !ok {
+				errors = append(errors, fmt.Sprintf("%s: not in the catalog", photo))
+				continue
+			}
+			if err := archiveFile(phoneDir, e.Name); err != nil {
+				errors = append(errors, fmt.Sprintf("%s: %v", photo, err))
+				continue
+			}
+			archived++
+		}
+		countFeature("archive")
+		writeJSON(w, map[string]interface{}{"success": archived > 0 || len(errors) == 0, "archived": archived, "errors": errors})
+	}).Methods("POST")
+
+	router.HandleFunc("/api/phones/{phoneName}/retrieve", func(w http.ResponseWriter, r *http.Request) {
+		phoneDir, photos, ok := decodePhotos(w, r)
+		if !ok {
+			return
+		}
+		catalog := openCatalog(phoneDir)
+		retrieving := 0
+		for _, photo := range photos {
+			if key, ok := catalog.ArchivedFile(photo); ok {
+				requestRetrieval(phoneDir, key)
+				retrieving++
+			}
+		}
+		countFeature("archive_retrieve")
+		writeJSON(w, map[string]interface{}{"success": true, "retrieving": retrieving})
+	}).Methods("POST")
+
+	// Whether an original the viewer couldn't load is archived, and how its retrieval goes
+	router.HandleFunc("/api/phones/{phoneName}/archive/{thumbName}", func(w http.ResponseWriter, r *http.Request) {
+		phoneDir, err := phoneDirFromRequest(config, r)
+		if err != nil {
+			writeJSON(w, map[string]interface{}{"success": false, "error": "Invalid phone name"})
+			return
+		}
+		key, archived := openCatalog(phoneDir).ArchivedFile(mux.Vars(r)["thumbName"])
+		resp := map[string]interface{}{"success": true, "archived": archived}
+		if archived {
+			running, err := retrievalState(phoneDir, key)
+			resp["retrieving"] = running
+			if err != nil {
+				resp["error"] = err.Error()
+			}
+		}
+		writeJSON(w, resp)
+	}).Methods("GET")
+}
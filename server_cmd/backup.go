@@ -0,0 +1,509 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	// backupStateFileName records what is in the bucket, in the receive directory
+	backupStateFileName = ".backup.json"
+	// defaultBackupScanInterval is how often the library is compared with the bucket, to
+	// pick up files the queue missed, unless configured
+	defaultBackupScanInterval = time.Hour
+	// backupUploadTimeout bounds one object upload
+	backupUploadTimeout = time.Hour
+	// backupMaxBackoff caps the wait after failed uploads
+	backupMaxBackoff = 5 * time.Minute
+)
+
+// BackupConfig copies received originals and the phones' catalogs to an S3-compatible
+// bucket. Uploads are incremental: a file is sent once per content, queued as it is
+// received; a periodic scan catches up on anything the queue missed. Files deleted on the
+// server stay in the bucket, use the bucket's lifecycle rules to expire them.
+type BackupConfig struct {
+	Endpoint  string `json:"endpoint"` // e.g. https://s3.eu-central-1.amazonaws.com, https://s3.us-west-002.backblazeb2.com or http://nas:9000
+	Region    string `json:"region"`   // default us-east-1
+	Bucket    string `json:"bucket"`
+	Prefix    string `json:"prefix"` // key prefix inside the bucket, e.g. "photos/"
+	AccessKey string `json:"access_key"`
+	SecretKey string `json:"secret_key"`
+	PathStyle bool   `json:"path_style"` // endpoint/bucket/key addressing, needed by MinIO and most self-hosted stores
+	ScanMin   int    `json:"scan_min"`   // minutes between scans of the library, default 60
+}
+
+// backupPhoneState is what the bucket holds of one phone
+type backupPhoneState struct {
+	LastBackup  time.Time         `json:"lastBackup,omitempty"`  // of the last original uploaded
+	IndexBackup time.Time         `json:"indexBackup,omitempty"` // of the last catalog uploaded
+	Objects     map[string]string `json:"objects"`               // catalog name -> SHA-256 of the uploaded copy
+}
+
+type backupJob struct {
+	phone, name string // name is the catalog name inside the phone directory
+}
+
+var (
+	// backupTarget is the bucket in use, set from the config at startup (nil: off)
+	backupTarget *s3Client
+	backupPrefix string
+
+	backupMutex     sync.Mutex // guards everything below
+	backupQueue     []backupJob
+	backupQueued    map[backupJob]bool
+	backupPhones    map[string]*backupPhoneState
+	backupIndexDue  map[string]bool // phones whose catalog changed since it was uploaded
+	backupCurrent   string          // key being uploaded
+	backupUploaded  int             // since startup
+	backupLastError string
+	backupErrorTime time.Time
+	backupWake      = make(chan struct{}, 1)
+)
+
+// setBackup enables the S3 backup from the config
+func setBackup(config *Config) error {
+	bc := config.Backup
+	if bc == nil {
+		return nil
+	}
+	endpoint, err := url.Parse(bc.Endpoint)
+	if err != nil || (endpoint.Scheme != "http" && endpoint.Scheme != "https") || endpoint.Host == "" {
+		return fmt.Errorf("endpoint must be an http(s) URL, got %q", bc.Endpoint)
+	}
+	if bc.Bucket == "" || bc.AccessKey == "" || bc.SecretKey == "" {
+		return fmt.Errorf("bucket, access_key and secret_key are required")
+	}
+	region := bc.Region
+	if region == "" {
+		region = "us-east-1"
+	}
+	baseDir := config.ReceiveDir
+	if baseDir == "" {
+		baseDir = "received"
+	}
+	interval := defaultBackupScanInterval
+	if bc.ScanMin > 0 {
+		interval = time.Duration(bc.ScanMin) * time.Minute
+	}
+
+	phones, err := loadBackupState(baseDir)
+	if err != nil {
+		return err
+	}
+	backupMutex.Lock()
+	backupPhones = phones
+	backupQueued = make(map[backupJob]bool)
+	backupIndexDue = make(map[string]bool)
+	backupMutex.Unlock()
+	backupPrefix = strings.Trim(bc.Prefix, "/")
+	if backupPrefix != "" {
+		backupPrefix += "/"
+	}
+	backupTarget = &s3Client{endpoint: endpoint, region: region, bucket: bc.Bucket, accessKey: bc.AccessKey,
+		secretKey: bc.SecretKey, pathStyle: bc.PathStyle, http: &http.Client{}}
+	log.Printf("S3 backup enabled: bucket %s at %s (scan every %v)", bc.Bucket, endpoint.Host, interval)
+	go runBackup(baseDir, interval)
+	return nil
+}
+
+func loadBackupState(baseDir string) (map[string]*backupPhoneState, error) {
+	phones := make(map[string]*backupPhoneState)
+	b, err := os.ReadFile(filepath.Join(baseDir, backupStateFileName))
+	if os.IsNotExist(err) {
+		return phones, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, &phones); err != nil {
+		return nil, fmt.Errorf("%s: %w", backupStateFileName, err)
+	}
+	return phones, nil
+}
+
+// saveBackupState writes the bucket's state; callers hold backupMutex
+func saveBackupState(baseDir string) {
+	b, err := json.Marshal(backupPhones)
+	if err == nil {
+		err = writeFileAtomic(filepath.Join(baseDir, backupStateFileName), b)
+	}
+	if err != nil {
+		log.Printf("Error saving %s: %v", backupStateFileName, err)
+	}
+}
+
+// backupPhone returns the state of a phone, creating it; callers hold backupMutex
+func backupPhone(phone string) *backupPhoneState {
+	st, ok := backupPhones[phone]
+	if !ok {
+		st = &backupPhoneState{Objects: make(map[string]string)}
+		backupPhones[phone] = st
+	}
+	if st.Objects == nil {
+		st.Objects = make(map[string]string)
+	}
+	return st
+}
+
+// queueBackup adds a file to the backlog unless it is queued already; callers hold backupMutex
+func queueBackup(job backupJob) {
+	if !backupQueued[job] {
+		backupQueued[job] = true
+		backupQueue = append(backupQueue, job)
+	}
+}
+
+func wakeBackup() {
+	select {
+	case backupWake <- struct{}{}:
+	default:
+	}
+}
+
+// backupLater queues an original that was just stored for upload
+func backupLater(phoneDir, path string) {
+	if backupTarget == nil {
+		return
+	}
+	rel, err := filepath.Rel(phoneDir, path)
+	if err != nil {
+		return
+	}
+	backupMutex.Lock()
+	queueBackup(backupJob{phone: filepath.Base(phoneDir), name: filepath.ToSlash(rel)})
+	backupMutex.Unlock()
+	wakeBackup()
+}
+
+// scanBackup queues every original whose current content isn't in the bucket
+func scanBackup(baseDir string) {
+	queued := 0
+	for _, phone := range libraryPhones(baseDir) {
+		entries := openCatalog(filepath.Join(baseDir, phone)).AllEntries()
+		backupMutex.Lock()
+		st := backupPhone(phone)
+		if st.IndexBackup.IsZero() {
+			backupIndexDue[phone] = true
+		}
+		for _, e := range entries {
+			if e.SHA256 != "" && e.Damaged == "" && !e.Archived && !strings.EqualFold(st.Objects[e.Name], e.SHA256) {
+				queueBackup(backupJob{phone: phone, name: e.Name})
+				queued++
+			}
+		}
+		backupMutex.Unlock()
+	}
+	if queued > 0 {
+		log.Printf("S3 backup: %d file(s) to upload", queued)
+	}
+}
+
+// backupFile uploads one queued original if the bucket doesn't have its content yet
+func backupFile(baseDir string, job backupJob) error {
+	phoneDir := filepath.Join(baseDir, job.phone)
+	path := openCatalog(phoneDir).Path(job.name)
+	e, ok := openCatalog(phoneDir).Entry(path)
+	if !ok || e.SHA256 == "" || e.Damaged != "" {
+		return nil // gone, or not to be trusted
+	}
+	key := backupPrefix + job.phone + "/" + job.name
+	backupMutex.Lock()
+	if strings.EqualFold(backupPhone(job.phone).Objects[job.name], e.SHA256) {
+		backupMutex.Unlock()
+		return nil
+	}
+	backupCurrent = key
+	backupMutex.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), backupUploadTimeout)
+	defer cancel()
+	err := backupTarget.putFile(ctx, key, path, e.SHA256)
+
+	backupMutex.Lock()
+	defer backupMutex.Unlock()
+	backupCurrent = ""
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil // deleted meanwhile
+		}
+		return err
+	}
+	st := backupPhone(job.phone)
+	st.Objects[job.name] = strings.ToLower(e.SHA256)
+	st.LastBackup = clock.Now()
+	backupIndexDue[job.phone] = true
+	backupUploaded++
+	backupLastError = ""
+	return nil
+}
+
+// backupIndexes uploads the catalogs that changed and the shared albums, so the bucket
+// can rebuild a library with its dates, favorites and albums
+func backupIndexes(baseDir string) error {
+	backupMutex.Lock()
+	var phones []string
+	for phone := range backupIndexDue {
+		phones = append(phones, phone)
+	}
+	backupMutex.Unlock()
+	if len(phones) == 0 {
+		return nil
+	}
+	sort.Strings(phones)
+
+	ctx, cancel := context.WithTimeout(context.Background(), backupUploadTimeout)
+	defer cancel()
+	for _, phone := range phones {
+		data, err := openCatalog(filepath.Join(baseDir, phone)).snapshot()
+		if err != nil {
+			return err
+		}
+		if err := backupTarget.putBytes(ctx, backupPrefix+phone+"/"+catalogFileName, data); err != nil {
+			return err
+		}
+		backupMutex.Lock()
+		delete(backupIndexDue, phone)
+		backupPhone(phone).IndexBackup = clock.Now()
+		backupMutex.Unlock()
+	}
+	albumsMutex.Lock()
+	data, err := os.ReadFile(filepath.Join(baseDir, albumsFileName))
+	albumsMutex.Unlock()
+	if err == nil {
+		return backupTarget.putBytes(ctx, backupPrefix+albumsFileName, data)
+	}
+	return nil
+}
+
+func setBackupError(err error) {
+	backupMutex.Lock()
+	backupLastError, backupErrorTime = err.Error(), clock.Now()
+	backupMutex.Unlock()
+	log.Printf("S3 backup: %v", err)
+}
+
+// runBackup works through the backlog, one upload at a time. Failed uploads go back to
+// the end of the queue and the next one waits a bit longer, up to backupMaxBackoff.
+func runBackup(baseDir string, interval time.Duration) {
+	scanBackup(baseDir)
+	ticker := clock.NewTicker(interval)
+	defer ticker.Stop()
+	backoff := time.Duration(0)
+	for {
+		if draining() {
+			return
+		}
+		backupMutex.Lock()
+		var job backupJob
+		ok := len(backupQueue) > 0
+		if ok {
+			job = backupQueue[0]
+			backupQueue = backupQueue[1:]
+			delete(backupQueued, job)
+		}
+		backupMutex.Unlock()
+
+		if !ok {
+			// Caught up: the catalogs go last, so they describe what was uploaded
+			if err := backupIndexes(baseDir); err != nil {
+				setBackupError(err)
+			}
+			backupMutex.Lock()
+			saveBackupState(baseDir)
+			backupMutex.Unlock()
+			select {
+			case <-backupWake:
+			case <-ticker.C():
+				scanBackup(baseDir)
+			}
+			continue
+		}
+
+		if err := backupFile(baseDir, job); err != nil {
+			setBackupError(err)
+			backupMutex.Lock()
+			queueBackup(job)
+			backupMutex.Unlock()
+			backoff = min(max(2*backoff, time.Second), backupMaxBackoff)
+			time.Sleep(backoff)
+			continue
+		}
+		backoff = 0
+	}
+}
+
+// BackupPhoneStatus is the backup state of one phone for the status page
+type BackupPhoneStatus struct {
+	Phone        string    `json:"phone"`
+	LastBackup   time.Time `json:"lastBackup"`
+	IndexBackup  time.Time `json:"indexBackup"`
+	Files        int       `json:"files"`
+	Pending      int       `json:"pending"`
+	PendingBytes int64     `json:"pendingBytes"`
+}
+
+// backupStatus compares every phone with what the bucket holds
+func backupStatus(baseDir string) []BackupPhoneStatus {
+	var list []BackupPhoneStatus
+	for _, phone := range libraryPhones(baseDir) {
+		entries := openCatalog(filepath.Join(baseDir, phone)).AllEntries()
+		backupMutex.Lock()
+		st := backupPhone(phone)
+		s := BackupPhoneStatus{Phone: phone, LastBackup: st.LastBackup, IndexBackup: st.IndexBackup}
+		for _, e := range entries {
+			if e.SHA256 == "" || e.Damaged != "" {
+				continue
+			}
+			if strings.EqualFold(st.Objects[e.Name], e.SHA256) {
+				s.Files++
+			} else {
+				s.Pending++
+				s.PendingBytes += e.Size
+			}
+		}
+		backupMutex.Unlock()
+		list = append(list, s)
+	}
+	return list
+}
+
+// registerBackupRoutes adds the backup status page and a way to scan for new files now
+func registerBackupRoutes(router *mux.Router, config *Config) {
+	baseDirFor := func() string {
+		if config.ReceiveDir == "" {
+			return "received"
+		}
+		return config.ReceiveDir
+	}
+
+	router.HandleFunc("/api/backup", func(w http.ResponseWriter, r *http.Request) {
+		if backupTarget == nil {
+			writeJSON(w, map[string]interface{}{"success": true, "enabled": false})
+			return
+		}
+		phones := backupStatus(baseDirFor())
+		backupMutex.Lock()
+		defer backupMutex.Unlock()
+		status := map[string]interface{}{"success": true, "enabled": true, "bucket": backupTarget.bucket,
+			"endpoint": backupTarget.endpoint.Host, "queued": len(backupQueue), "current": backupCurrent,
+			"uploaded": backupUploaded, "phones": phones}
+		if backupLastError != "" {
+			status["lastError"], status["lastErrorTime"] = backupLastError, backupErrorTime
+		}
+		writeJSON(w, status)
+	}).Methods("GET")
+
+	router.HandleFunc("/api/backup/scan", func(w http.ResponseWriter, r *http.Request) {
+		if backupTarget == nil {
+			writeJSON(w, map[string]interface{}{"success": false, "error": "No backup configured"})
+			return
+		}
+		scanBackup(baseDirFor())
+		wakeBackup()
+		countFeature("backup_scan")
+		writeJSON(w, map[string]interface{}{"success": true})
+	}).Methods("POST")
+
+	router.HandleFunc("/backup", func(w http.ResponseWriter, r *http.Request) {
+		tmpl := `<!DOCTYPE html>
+<html>
+<head>
+    <title>Backup - Photo Sync Server</title>
+    <style>
+        body { font-family: 'Segoe UI', Tahoma, Arial, sans-serif; margin: 0; padding: 20px; background: #000000; color: #ffffff; }
+        h1 { color: #ffffff; font-weight: 300; letter-spacing: 1px; }
+        h2 { font-size: 20px; margin-top: 30px; color: #aaaaaa; font-weight: 300; }
+        .back-link { display: inline-block; margin-bottom: 20px; color: #88aaff; text-decoration: none; font-size: 14px; }
+        .back-link:hover { color: #aaccff; text-decoration: underline; }
+        .panel { background: #1a1a1a; border: 1px solid #2a2a2a; border-radius: 12px; padding: 20px; max-width: 700px; font-size: 14px; color: #aaaaaa; }
+        .hint { color: #888888; font-size: 12px; }
+        table { border-collapse: collapse; width: 100%; max-width: 700px; font-size: 13px; }
+        th, td { text-align: left; padding: 8px; border-bottom: 1px solid #2a2a2a; }
+        th { color: #888888; font-weight: normal; }
+        button { background: #667eea; color: #ffffff; border: none; border-radius: 6px; padding: 10px 20px; font-size: 14px; cursor: pointer; margin: 16px 8px 0 0; }
+        button:hover { background: #5a6fd6; }
+        .error { color: #ff6b6b; margin-top: 12px; }
+        .done { color: #4ade80; }
+    </style>
+</head>
+<body>
+    <a href="/" class="back-link">← Back to Home</a>
+    <h1>☁️ Backup</h1>
+    <div class="panel" id="summary"><span class="hint">Loading…</span></div>
+
+    <h2>Phones</h2>
+    <table id="phones"></table>
+
+    <script>
+        function escapeHTML(s) {
+            return String(s).replace(/[&<>"']/g, function(c) {
+                return {'&': '&amp;', '<': '&lt;', '>': '&gt;', '"': '&quot;', "'": '&#39;'}[c];
+            });
+        }
+
+        function formatBytes(n) {
+            if (n >= 1073741824) return (n / 1073741824).toFixed(1) + ' GB';
+            if (n >= 1048576) return (n / 1048576).toFixed(1) + ' MB';
+            if (n >= 1024) return (n / 1024).toFixed(0) + ' KB';
+            return n + ' B';
+        }
+
+        function when(t) {
+            return t && !t.startsWith('0001') ? new Date(t).toLocaleString() : 'never';
+        }
+
+        function scan() {
+            fetch('/api/backup/scan', {method: 'POST'}).then(refresh);
+        }
+
+        function refresh() {
+            fetch('/api/backup')
+                .then(function(r) { return r.json(); })
+                .then(function(data) {
+                    if (!data.enabled) {
+                        document.getElementById('summary').innerHTML = 'No backup configured. Add a backup section with the endpoint, ' +
+                            'bucket and keys of an S3-compatible store (AWS, Backblaze B2, MinIO) to the config.';
+                        return;
+                    }
+                    let html = 'Bucket <b>' + escapeHTML(data.bucket) + '</b> at ' + escapeHTML(data.endpoint) + '<br>';
+                    html += data.queued ? data.queued + ' file(s) queued' : '<span class="done">Up to date</span>';
+                    if (data.current) html += ' · uploading ' + escapeHTML(data.current);
+                    html += '<br><span class="hint">' + data.uploaded + ' file(s) uploaded since the server started</span>';
+                    if (data.lastError) html += '<div class="error">' + escapeHTML(data.lastError) + ' (' + when(data.lastErrorTime) + ')</div>';
+                    html += '<div><button onclick="scan()">Scan for new files</button></div>';
+                    document.getElementById('summary').innerHTML = html;
+
+                    const phones = data.phones || [];
+                    document.getElementById('phones').innerHTML = phones.length ?
+                        '<tr><th>Phone</th><th>Last backed up</th><th>In the bucket</th><th>Waiting</th></tr>' + phones.map(function(p) {
+                            return '<tr><td>📱 ' + escapeHTML(p.phone) + '</td><td>' + when(p.lastBackup) + '</td><td>' + p.files +
+                                '</td><td>' + (p.pending ? p.pending + ' (' + formatBytes(p.pendingBytes) + ')' : '<span class="done">none</span>') + '</td></tr>';
+                        }).join('') : '<tr><td class="hint">No phones yet.</td></tr>';
+                })
+                .catch(function() {});
+        }
+
+        refresh();
+        setInterval(refresh, 3000);
+    </script>
+</body>
+</html>`
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if err := template.Must(template.New("backup").Parse(tmpl)).Execute(w, nil); err != nil {
+			log.Printf("Error rendering backup page: %v", err)
+		}
+	}).Methods("GET")
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"path"
+	"sort"
+	"strings"
+)
+
+const (
+	// maxBatchEntries bounds the number of files in one batch upload
+	maxBatchEntries = 10000
+
+	// maxBatchEntrySize and maxBatchTotalSize bound the unpacked data, so a small
+	// archive can't expand into gigabytes
+	maxBatchEntrySize = 64 * 1024 * 1024
+	maxBatchTotalSize = 1024 * 1024 * 1024
+)
+
+// BatchEntry describes one file of a batch upload
+type BatchEntry struct {
+	Name   string `json:"name"`             // path inside the archive
+	ID     string `json:"id,omitempty"`     // storage ID, defaults to the name
+	Media  string `json:"media,omitempty"`  // defaults to the name's extension
+	SHA256 string `json:"sha256,omitempty"` // optional, hex SHA-256 of the file
+	Taken  string `json:"taken,omitempty"`  // optional capture time, RFC 3339 or device-local
+	MTime  string `json:"mtime,omitempty"`  // optional file modification time on the phone
+}
+
+// BatchUpload is the msgTypeBatchUpload payload: a zip or tar archive of many small files
+// and an optional manifest. Without a manifest every regular file in the archive is stored.
+type BatchUpload struct {
+	BatchID string       `json:"batchId"`
+	Format  string       `json:"format"` // zip (default) or tar
+	Data    string       `json:"data"`   // base64 archive
+	Entries []BatchEntry `json:"entries,omitempty"`
+}
+
+// readArchive returns the regular files of a zip or tar archive by cleaned name
+func readArchive(format string, data []byte) (map[string][]byte, error) {
+	files := make(map[string][]byte)
+	var total int64
+	add := func(name string, size int64, r io.Reader) error {
+		if len(files) >= maxBatchEntries {
+			return fmt.Errorf("more than %d files", maxBatchEntries)
+		}
+		if size > maxBatchEntrySize {
+			return fmt.Errorf("%s is larger than %d bytes", name, maxBatchEntrySize)
+		}
+		b, err := io.ReadAll(io.LimitReader(r, maxBatchEntrySize+1))
+		if err != nil {
+			return fmt.Errorf("read %s: %w", name, err)
+		}
+		if len(b) > maxBatchEntrySize {
+			return fmt.Errorf("%s is larger than %d bytes", name, maxBatchEntrySize)
+		}
+		if total += int64(len(b)); total > maxBatchTotalSize {
+			return fmt.Errorf("archive unpacks to more than %d bytes", maxBatchTotalSize)
+		}
+		files[path.Clean(name)] = b
+		return nil
+	}
+
+	switch format {
+	case "", "zip":
+		zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range zr.File {
+			if !f.Mode().IsRegular() {
+				continue
+			}
+			rc, err := f.Open()
+			if err != nil {
+				return nil, fmt.Errorf("open %s: %w", f.Name, err)
+			}
+			err = add(f.Name, int64(f.UncompressedSize64), rc)
+			rc.Close()
+			if err != nil {
+				return nil, err
+			}
+		}
+	case "tar":
+		tr := tar.NewReader(bytes.NewReader(data))
+		for {
+			hdr, err := tr.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return nil, err
+			}
+			if hdr.Typeflag != tar.TypeReg {
+				continue
+			}
+			if err := add(hdr.Name, hdr.Size, tr); err != nil {
+				return nil, err
+			}
+		}
+	default:
+		return nil, fmt.Errorf("unsupported archive format %q", format)
+	}
+	return files, nil
+}
+
+// handleBatchUpload unpacks a batch upload into recvDir, sending one file ACK per entry
+// followed by a batch ACK. It only returns an error when the connection failed.
+func handleBatchUpload(config *Config, baseRecvDir, recvDir string, session *syncSession, acks *ackSender, payload []byte) error {
+	var req BatchUpload
+	if err := json.Unmarshal(payload, &req); err != nil {
+		log.Printf("Invalid batch upload JSON: %v\n", err)
+		return acks.send(errorAck(ackKindBatch, "", ackCodeDecode, err))
+	}
+	if recvDir == baseRecvDir {
+		log.Printf("Batch upload before phone name/device registration, ignoring\n")
+		return acks.send(errorAck(ackKindBatch, req.BatchID, ackCodeNoDevice, nil))
+	}
+
+	data, err := base64.StdEncoding.DecodeString(req.Data)
+	if err != nil {
+		log.Printf("Error decoding batch %s: %v\n", req.BatchID, err)
+		return acks.send(errorAck(ackKindBatch, req.BatchID, ackCodeDecode, err))
+	}
+	files, err := readArchive(req.Format, data)
+	if err != nil {
+		log.Printf("Error unpacking batch %s: %v\n", req.BatchID, err)
+		return acks.send(errorAck(ackKindBatch, req.BatchID, ackCodeInvalid, err))
+	}
+
+	entries := req.Entries
+	if len(entries) == 0 {
+		names := make([]string, 0, len(files))
+		for name := range files {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			entries = append(entries, BatchEntry{Name: name})
+		}
+	}
+	if len(entries) > maxBatchEntries {
+		err := fmt.Errorf("more than %d entries", maxBatchEntries)
+		return acks.send(errorAck(ackKindBatch, req.BatchID, ackCodeInvalid, err))
+	}
+	log.Printf("Batch %s: %d entries in a %d byte %s archive", req.BatchID, len(entries), len(data), req.Format)
+
+	stored, duplicates, failed := 0, 0, 0
+	for _, e := range entries {
+		id := e.ID
+		if id == "" {
+			id = path.Clean(e.Name)
+		}
+		media := e.Media
+		if media == "" {
+			media = strings.TrimPrefix(path.Ext(e.Name), ".")
+		}
+
+		var ack Ack
+		fileBytes, ok := files[path.Clean(e.Name)]
+		switch {
+		case media == "":
+			ack = errorAck(ackKindFile, id, ackCodeInvalid, errors.New("no media type"))
+		case !ok:
+			ack = errorAck(ackKindFile, id, ackCodeInvalid, fmt.Errorf("%s is not in the archive", e.Name))
+		case !checksumMatches(fileBytes, e.SHA256):
+			ack = errorAck(ackKindFile, id, ackCodeChecksum, nil)
+		default:
+			ack = storeReceivedFile(config, baseRecvDir, recvDir, session, id, media, e.Taken, e.MTime, fileBytes)
+		}
+
+		switch {
+		case ack.Code == ackCodeDuplicate:
+			duplicates++
+		case ack.Status == ackStatusOK:
+			stored++
+		default:
+			failed++
+		}
+		if err := acks.send(ack); err != nil {
+			return err
+		}
+	}
+
+	log.Printf("Batch %s done: %d stored, %d duplicates, %d failed", req.BatchID, stored, duplicates, failed)
+	countFeature("batch_upload")
+	ack := okAck(ackKindBatch, req.BatchID)
+	ack.Message = fmt.Sprintf("%d stored, %d duplicates, %d failed", stored, duplicates, failed)
+	return acks.send(ack)
+}
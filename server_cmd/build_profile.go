@@ -0,0 +1,7 @@
+//go:build !smallboard
+
+package main
+
+// builtinHardwareProfile names a profile the binary was built for; empty leaves the choice
+// to detection and the config
+const builtinHardwareProfile = ""
@@ -0,0 +1,7 @@
+//go:build smallboard
+
+package main
+
+// builtinHardwareProfile: built with -tags smallboard for ARM boards and NAS boxes, the
+// small profile applies unless the config sets hardware_profile to standard
+const builtinHardwareProfile = hardwareProfileSmall
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// localTimeLayouts are accepted capture time formats without a UTC offset; they are
+// interpreted in the phone's default time zone
+var localTimeLayouts = []string{
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006:01:02 15:04:05", // EXIF DateTimeOriginal
+}
+
+// parseCaptureTime parses a client-reported capture time. Times with an offset (RFC 3339)
+// keep it; device-local times are placed in loc. The second result names the zone source.
+func parseCaptureTime(value string, loc *time.Location) (time.Time, string, error) {
+	value = strings.TrimSpace(value)
+	if t, err := time.Parse(time.RFC3339Nano, value); err == nil {
+		return t, "client", nil
+	}
+	for _, layout := range localTimeLayouts {
+		if t, err := time.ParseInLocation(layout, value, loc); err == nil {
+			return t, loc.String(), nil
+		}
+	}
+	return time.Time{}, "", fmt.Errorf("unrecognized capture time %q", value)
+}
+
+// parseFileTime parses a client-reported file time: a capture time format, or Unix time in
+// seconds or milliseconds as sent by most phone APIs
+func parseFileTime(value string, loc *time.Location) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	if n, err := strconv.ParseInt(value, 10, 64); err == nil && n > 0 {
+		if n > 1e11 {
+			return time.UnixMilli(n), nil
+		}
+		return time.Unix(n, 0), nil
+	}
+	t, _, err := parseCaptureTime(value, loc)
+	return t, err
+}
+
+// preserveFileTimes sets the modification time of a stored file to the phone's file time
+// (mtime) or, without one, the capture time, so file managers, backups and the timeline
+// see when a photo was taken instead of when it was synced. Callers set it before the
+// catalog records the file.
+func preserveFileTimes(recvDir, path, mtime, taken string) {
+	value := mtime
+	if value == "" {
+		value = taken
+	}
+	if value == "" {
+		return
+	}
+	t, err := parseFileTime(value, openCatalog(recvDir).Location())
+	if err != nil {
+		log.Printf("Keeping receive time on %s: %v", path, err)
+		return
+	}
+	if err := os.Chtimes(path, time.Now(), t); err != nil {
+		log.Printf("Error setting file time of %s: %v", path, err)
+	}
+}
+
+// recordCaptureTime stores the capture time a client sent with an upload, if any
+func recordCaptureTime(recvDir, path, taken string) {
+	if taken == "" {
+		return
+	}
+	catalog := openCatalog(recvDir)
+	t, zone, err := parseCaptureTime(taken, catalog.Location())
+	if err != nil {
+		log.Printf("Ignoring capture time for %s: %v", path, err)
+		return
+	}
+	catalog.SetTaken(path, t, zone)
+}
+
+// registerCaptureTimeRoutes adds the per-phone time zone setting and the bulk capture
+// time correction tool to the router
+func registerCaptureTimeRoutes(router *mux.Router, config *Config) {
+	// Get the phone's default time zone
+	router.HandleFunc("/api/phones/{phoneName}/timezone", func(w http.ResponseWriter, r *http.Request) {
+		phoneDir, err := phoneDirFromRequest(config, r)
+		if err != nil {
+			writeJSON(w, map[string]interface{}{"success": false, "error": "Invalid phone name"})
+			return
+		}
+		writeJSON(w, map[string]interface{}{"success": true, "timeZone": openCatalog(phoneDir).Location().String()})
+	}).Methods("GET")
+
+	// Set the phone's default time zone, used for capture times sent without an offset
+	router.HandleFunc("/api/phones/{phoneName}/timezone", func(w http.ResponseWriter, r *http.Request) {
+		phoneDir, err := phoneDirFromRequest(config, r)
+		if err != nil {
+			writeJSON(w, map[string]interface{}{"success": false, "error": "Invalid phone name"})
+			return
+		}
+		var req struct {
+			TimeZone string `json:"timeZone"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeJSON(w, map[string]interface{}{"success": false, "error": "Invalid request: " + err.Error()})
+			return
+		}
+		if err := openCatalog(phoneDir).SetTimeZone(req.TimeZone); err != nil {
+			writeJSON(w, map[string]interface{}{"success": false, "error": "Unknown time zone: " + err.Error()})
+			return
+		}
+		writeJSON(w, map[string]interface{}{"success": true, "timeZone": req.TimeZone})
+	}).Methods("POST")
+
+	// Shift capture times of selected photos, or of everything taken in [from, to], by an
+	// offset, e.g. photos from a trip taken with the phone clock still on home time
+	router.HandleFunc("/api/phones/{phoneName}/time-shift", func(w http.ResponseWriter, r *http.Request) {
+		phoneDir, err := phoneDirFromRequest(config, r)
+		if err != nil {
+			writeJSON(w, map[string]interface{}{"success": false, "error": "Invalid phone name"})
+			return
+		}
+		var req struct {
+			Photos        []string `json:"photos"` // thumbnail or original names
+			From          string   `json:"from"`   // capture time range, used when photos is empty
+			To            string   `json:"to"`
+			OffsetMinutes int      `json:"offsetMinutes"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeJSON(w, map[string]interface{}{"success": false, "error": "Invalid request: " + err.Error()})
+			return
+		}
+		if req.OffsetMinutes == 0 {
+			writeJSON(w, map[string]interface{}{"success": false, "error": "offsetMinutes is required"})
+			return
+		}
+
+		catalog := openCatalog(phoneDir)
+		var paths []string
+		if len(req.Photos) > 0 {
+			for _, photo := range req.Photos {
+				if orig, ok := originalForThumbnail(phoneDir, photo); ok {
+					paths = append(paths, orig)
+				}
+			}
+		} else {
+			loc := catalog.Location()
+			from, _, errFrom := parseCaptureTime(req.From, loc)
+			to, _, errTo := parseCaptureTime(req.To, loc)
+			if errFrom != nil || errTo != nil || to.Before(from) {
+				writeJSON(w, map[string]interface{}{"success": false, "error": "Select photos or give a valid from/to range"})
+				return
+			}
+			for _, dir := range phoneMediaDirs(phoneDir) {
+				entries, err := os.ReadDir(dir)
+				if err != nil {
+					if dir == phoneDir {
+						writeJSON(w, map[string]interface{}{"success": false, "error": err.Error()})
+						return
+					}
+					continue
+				}
+				for _, e := range entries {
+					if e.IsDir() || !(hasExtension(e.Name(), photoExtensions) || hasExtension(e.Name(), videoExtensions)) {
+						continue
+					}
+					info, err := e.Info()
+					if err != nil {
+						continue
+					}
+					path := filepath.Join(dir, e.Name())
+					if t := catalog.CaptureTime(path, info); !t.Before(from) && !t.After(to) {
+						paths = append(paths, path)
+					}
+				}
+			}
+		}
+
+		shifted := catalog.ShiftTaken(paths, time.Duration(req.OffsetMinutes)*time.Minute)
+		countFeature("time_shift")
+		log.Printf("Shifted capture times of %d file(s) in %s by %d minutes", shifted, phoneDir, req.OffsetMinutes)
+		writeJSON(w, map[string]interface{}{"success": true, "shifted": shifted})
+	}).Methods("POST")
+}
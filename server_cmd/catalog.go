@@ -0,0 +1,1223 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// catalogFileName is the per-phone metadata index, stored in the phone directory
+const catalogFileName = ".catalog.json"
+
+// catalogFlushDelay coalesces catalog writes: a sync recording thousands of files rewrites
+// the file at most this often instead of once per file. flushCatalogs writes what's pending.
+const catalogFlushDelay = time.Second
+
+// CatalogEntry is the indexed state of one stored media file
+type CatalogEntry struct {
+	Name    string    `json:"name"` // path relative to the phone directory, slash separated
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mtime"`
+	SHA256  string    `json:"sha256,omitempty"`
+
+	// Panorama is "equirectangular", "wide" or empty; Probed records that it was determined
+	Panorama string `json:"panorama,omitempty"`
+	Probed   bool   `json:"probed,omitempty"`
+
+	// Taken is the capture time with the offset it was taken in; TakenZone says where the
+	// offset came from: "client" (sent with an offset), a time zone name (device-local time
+	// interpreted in the phone's default zone), "shifted" (bulk corrected) or "manual"
+	// (entered in the bulk metadata editor)
+	Taken     *time.Time `json:"taken,omitempty"`
+	TakenZone string     `json:"takenZone,omitempty"`
+
+	// TakenPrecision marks a hand-entered approximate date ("year", "month" or "day") of a
+	// scan or old photo; empty for exact capture times
+	TakenPrecision string `json:"takenPrecision,omitempty"`
+
+	// Place is where the photo was taken, when assigned by hand
+	Place *Place `json:"place,omitempty"`
+
+	// Favorite is set from the gallery
+	Favorite bool `json:"favorite,omitempty"`
+
+	// Added is when the server received the file; for files found on disk instead, their
+	// modification time
+	Added time.Time `json:"added"`
+
+	// Duration is the length of a video in seconds, nil until probed (0 when ffprobe couldn't tell)
+	Duration *float64 `json:"duration,omitempty"`
+
+	// Thumbnail is the thumbnailPolicy key the thumbnail was generated with; empty for
+	// thumbnails made before this was recorded, which used the default policy
+	Thumbnail string `json:"thumbnail,omitempty"`
+
+	// Created marks a video made on the server (slideshow, trim) rather than synced
+	Created bool `json:"created,omitempty"`
+
+	// Exif is the camera metadata of a photo, nil until extracted (empty when it has none)
+	Exif *ExifInfo `json:"exif,omitempty"`
+
+	// Geo is the reverse-geocoded place of the file's position (Place, else the EXIF GPS),
+	// nil until looked up, see geotag.go
+	Geo *GeoPlace `json:"geo,omitempty"`
+
+	// Damaged is what the integrity scrub found wrong with the file ("missing", "truncated"
+	// or "corrupted"); SHA256 stays the hash of the intact file. Cleared when the file is
+	// stored again, see scrub.go
+	Damaged string `json:"damaged,omitempty"`
+
+	// Pool is the storage pool the file was stored in when the receive directory was nearly
+	// full, empty for the phone directory, see storage_pools.go
+	Pool string `json:"pool,omitempty"`
+
+	// Source is where the file came from, as the client sent it, see sidecar.go; nil for
+	// files stored before this was recorded and ones found on disk
+	Source *MediaSource `json:"source,omitempty"`
+
+	// Archived marks an original moved to the cold storage archive; its thumbnail and
+	// entry stay, see archive.go
+	Archived bool `json:"archived,omitempty"`
+
+	// Retrieved is when an archived original was last copied back, which keeps the
+	// archive policy from moving it out again for after_days
+	Retrieved *time.Time `json:"retrieved,omitempty"`
+
+	// Analysis is what each analysis plugin found in the file, by analyzer name, see
+	// analysis.go; dropped when the content changes, so the analyzers look again
+	Analysis map[string]*Analysis `json:"analysis,omitempty"`
+}
+
+// TrashEntry is a deleted file kept in the phone's trash until trashRetention has passed
+type TrashEntry struct {
+	CatalogEntry
+	Deleted time.Time `json:"deleted"`
+}
+
+// Place is a photo location
+type Place struct {
+	Lat  float64 `json:"lat"`
+	Lon  float64 `json:"lon"`
+	Name string  `json:"name,omitempty"` // e.g. "Grandma's house, Lisbon"
+}
+
+// keepUserFields carries over what was recorded about a file besides its content when
+// its entry is rebuilt
+func (e *CatalogEntry) keepUserFields(old *CatalogEntry) {
+	e.Taken, e.TakenZone, e.TakenPrecision = old.Taken, old.TakenZone, old.TakenPrecision
+	e.Place = old.Place
+	e.Favorite = old.Favorite
+	e.Created = old.Created
+	e.Source = old.Source
+	if !old.Added.IsZero() {
+		e.Added = old.Added
+	}
+}
+
+// Comment is one message in a photo's comment thread
+type Comment struct {
+	Author string    `json:"author"`
+	Text   string    `json:"text"`
+	Time   time.Time `json:"time"`
+}
+
+// Catalog indexes the media files of one phone directory by name and content hash.
+// Lookups take a read lock, so gallery pages aren't held up by a sync recording files.
+type Catalog struct {
+	mu        sync.RWMutex
+	dir       string
+	Version   int                      `json:"version"` // catalogVersion, see catalog_migrations.go
+	Entries   map[string]*CatalogEntry `json:"entries"`
+	Aliases   map[string]string        `json:"aliases,omitempty"`  // name a client uploaded -> identical file kept instead
+	Comments  map[string][]Comment     `json:"comments,omitempty"` // comment threads by file name
+	TimeZone  string                   `json:"timeZone,omitempty"` // default zone for device-local capture times
+	Trash     map[string]*TrashEntry   `json:"trash,omitempty"`    // deleted files by their former name, see trash.go
+	refreshed bool
+	byHash    map[string]string // lowercase SHA-256 -> entry name; nil until built and after removals
+	pooled    map[string]string // base name -> entry name of files in storage pools; nil until built
+	archived  map[string]string // thumbnail name -> entry name of archived files; nil until built
+
+	flushMu      sync.Mutex // guards flushPending; never held while taking mu
+	flushPending bool
+	writeMu      sync.Mutex // serializes writes of the file
+}
+
+var (
+	catalogsMutex sync.Mutex
+	catalogs      = make(map[string]*Catalog)
+)
+
+// openCatalog returns the shared catalog for a phone directory, loading it on first use
+func openCatalog(dir string) *Catalog {
+	key, err := filepath.Abs(dir)
+	if err != nil {
+		key = filepath.Clean(dir)
+	}
+
+	catalogsMutex.Lock()
+	defer catalogsMutex.Unlock()
+
+	if c, ok := catalogs[key]; ok {
+		return c
+	}
+
+	c := &Catalog{
+		dir:      dir,
+		Entries:  make(map[string]*CatalogEntry),
+		Aliases:  make(map[string]string),
+		Comments: make(map[string][]Comment),
+		Trash:    make(map[string]*TrashEntry),
+	}
+	if err := migrateCatalogFile(dir); err != nil {
+		log.Printf("Error migrating catalog in %s: %v", dir, err)
+	}
+	if b, err := os.ReadFile(filepath.Join(dir, catalogFileName)); err == nil {
+		if err := json.Unmarshal(b, c); err != nil {
+			log.Printf("Error parsing catalog in %s, rebuilding: %v", dir, err)
+		}
+		if c.Entries == nil {
+			c.Entries = make(map[string]*CatalogEntry)
+		}
+		if c.Aliases == nil {
+			c.Aliases = make(map[string]string)
+		}
+		if c.Comments == nil {
+			c.Comments = make(map[string][]Comment)
+		}
+		if c.Trash == nil {
+			c.Trash = make(map[string]*TrashEntry)
+		}
+	}
+	c.Version = catalogVersion
+	catalogs[key] = c
+	return c
+}
+
+// catalogName converts a path inside the phone directory, or the phone's directory in a
+// storage pool, to its catalog key
+func (c *Catalog) catalogName(path string) string {
+	return phoneFileName(c.dir, path)
+}
+
+// phoneFileName returns the name of a file of the phone directory phoneDir, stored there
+// or in a storage pool, relative to the phone's directory
+func phoneFileName(phoneDir, path string) string {
+	rel, err := filepath.Rel(phoneDir, path)
+	if err != nil {
+		rel = filepath.Base(path)
+	}
+	if strings.HasPrefix(rel, "..") {
+		if _, name, ok := poolOf(phoneDir, path); ok {
+			return name
+		}
+	}
+	return filepath.ToSlash(rel)
+}
+
+// path returns where the file of a catalog key is; callers hold c.mu
+func (c *Catalog) path(name string) string {
+	if e, ok := c.Entries[name]; ok {
+		return entryPath(c.dir, e)
+	}
+	return filepath.Join(c.dir, filepath.FromSlash(name))
+}
+
+// Path returns where the file of a catalog key is, in the phone directory or a pool
+func (c *Catalog) Path(name string) string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.path(name)
+}
+
+// PooledFile finds a file stored in a storage pool by its base name, for resolving the
+// names the web UI uses. It returns the file's path even while the pool is offline.
+func (c *Catalog) PooledFile(base string) (string, bool) {
+	if storagePools == nil {
+		return "", false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.pooled == nil {
+		c.pooled = make(map[string]string)
+		for name, e := range c.Entries {
+			if e.Pool != "" {
+				c.pooled[path.Base(name)] = name
+			}
+		}
+	}
+	name, ok := c.pooled[base]
+	if !ok {
+		return "", false
+	}
+	if e, ok := c.Entries[name]; !ok || e.Pool == "" {
+		return "", false // removed or stored again in the phone directory since
+	}
+	return c.path(name), true
+}
+
+// save schedules writing the catalog to disk within catalogFlushDelay; callers hold c.mu
+func (c *Catalog) save() {
+	c.flushMu.Lock()
+	defer c.flushMu.Unlock()
+	if !c.flushPending {
+		c.flushPending = true
+		time.AfterFunc(catalogFlushDelay, c.flush)
+	}
+}
+
+// snapshot returns the catalog as it would be written to disk
+func (c *Catalog) snapshot() ([]byte, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return json.Marshal(c)
+}
+
+// flush writes the catalog to disk. Changes made while it encodes schedule another flush.
+func (c *Catalog) flush() {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	c.flushMu.Lock()
+	c.flushPending = false
+	c.flushMu.Unlock()
+
+	c.mu.RLock()
+	b, err := json.Marshal(c)
+	c.mu.RUnlock()
+	mediaLibraryChanged()
+	if err != nil {
+		log.Printf("Error encoding catalog for %s: %v", c.dir, err)
+		return
+	}
+	path := filepath.Join(c.dir, catalogFileName)
+	if err := os.WriteFile(path+".tmp", b, 0o644); err != nil {
+		log.Printf("Error writing catalog for %s: %v", c.dir, err)
+		return
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		log.Printf("Error replacing catalog for %s: %v", c.dir, err)
+	}
+}
+
+// refresh brings the index in line with the directory and the phone's directories in the
+// storage pools: new or changed media files are hashed, deleted ones dropped. Only the
+// first call per process walks the directory; files received afterwards are recorded as
+// they arrive. Callers hold c.mu.
+func (c *Catalog) refresh() {
+	if c.refreshed {
+		return
+	}
+	c.refreshed = true
+
+	seen := make(map[string]bool)
+	changed := false
+	walk := func(root, pool string) {
+		filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return nil
+			}
+			name := d.Name()
+			if d.IsDir() {
+				if path != root && (name == "thumbnails" || strings.HasPrefix(name, ".")) {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if strings.HasPrefix(name, ".") || !(hasExtension(name, photoExtensions) || hasExtension(name, videoExtensions)) {
+				return nil
+			}
+			info, err := d.Info()
+			if err != nil {
+				return nil
+			}
+			key := c.catalogName(path)
+			seen[key] = true
+			if e, ok := c.Entries[key]; ok && e.Size == info.Size() && e.ModTime.Equal(info.ModTime()) && e.SHA256 != "" {
+				if !e.Probed {
+					e.Panorama, e.Probed = detectPanorama(path), true
+					changed = true
+				}
+				return nil
+			}
+			sum, err := calculateSHA256(path)
+			if err != nil {
+				log.Printf("Error hashing %s for catalog: %v", path, err)
+				return nil
+			}
+			entry := &CatalogEntry{Name: key, Size: info.Size(), ModTime: info.ModTime(), SHA256: sum,
+				Panorama: detectPanorama(path), Probed: true, Added: info.ModTime(), Pool: pool}
+			if old, ok := c.Entries[key]; ok {
+				entry.keepUserFields(old)
+				if old.Damaged != "" {
+					// A restart doesn't make a damaged file whole
+					entry.Size, entry.SHA256, entry.Damaged = old.Size, old.SHA256, old.Damaged
+				}
+			}
+			c.Entries[key] = entry
+			changed = true
+			return nil
+		})
+	}
+	walk(c.dir, "")
+	if storagePools != nil {
+		for _, p := range storagePools.Pools {
+			if poolOnline(p.Name) {
+				walk(filepath.Join(p.Dir, filepath.Base(c.dir)), p.Name)
+			}
+		}
+	}
+	for key, e := range c.Entries {
+		if e.Archived || e.Pool != "" && !poolOnline(e.Pool) {
+			continue // in the archive or on a volume that isn't mounted: not known to be gone
+		}
+		if !seen[key] {
+			unmirrorOriginal(c.dir, c.path(key))
+			delete(c.Entries, key)
+			delete(c.Comments, key)
+			changed = true
+		}
+	}
+	if changed {
+		c.byHash, c.pooled, c.archived = nil, nil, nil
+		c.save()
+	}
+}
+
+// flushCatalogs writes every catalog with pending changes, before the server exits
+func flushCatalogs() {
+	catalogsMutex.Lock()
+	list := make([]*Catalog, 0, len(catalogs))
+	for _, c := range catalogs {
+		list = append(list, c)
+	}
+	catalogsMutex.Unlock()
+
+	for _, c := range list {
+		c.flushMu.Lock()
+		pending := c.flushPending
+		c.flushMu.Unlock()
+		if pending {
+			c.flush()
+		}
+	}
+}
+
+// FindByHash returns the catalog name of a stored file with the given SHA-256, if any
+func (c *Catalog) FindByHash(sum string) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.refresh()
+	sum = strings.ToLower(sum)
+	for {
+		if c.byHash == nil {
+			c.byHash = make(map[string]string, len(c.Entries))
+			for name, e := range c.Entries {
+				if e.SHA256 != "" && e.Damaged == "" {
+					c.byHash[strings.ToLower(e.SHA256)] = name
+				}
+			}
+		}
+		name, ok := c.byHash[sum]
+		if !ok {
+			return "", false
+		}
+		// Make sure the file wasn't deleted behind our back (web UI, cleanup)
+		if _, err := os.Stat(c.path(name)); err == nil {
+			return name, true
+		}
+		if e := c.Entries[name]; e.Archived || e.Pool != "" && !poolOnline(e.Pool) {
+			return name, true // not reachable right now, but not gone
+		}
+		// Another file may have the same content
+		delete(c.Entries, name)
+		c.byHash = nil
+	}
+}
+
+// Record indexes a file that was just stored at path
+func (c *Catalog) Record(path, sum string) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return
+	}
+	panorama := detectPanorama(path)
+	exif := readExifInfo(path)
+	mirrorOriginal(c.dir, path)
+	backupLater(c.dir, path)
+	secondaryLater(c.dir, path)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	key := c.catalogName(path)
+	entry := &CatalogEntry{Name: key, Size: info.Size(), ModTime: info.ModTime(), SHA256: sum,
+		Panorama: panorama, Probed: true, Exif: exif, Added: clock.Now()}
+	if pool, _, ok := poolOf(c.dir, path); ok {
+		entry.Pool = pool
+		c.pooled = nil
+	}
+	if old, ok := c.Entries[key]; ok {
+		entry.keepUserFields(old)
+		c.byHash = nil // the old content's hash may point here
+		if old.Archived {
+			c.archived = nil
+		}
+	} else if c.byHash != nil && sum != "" {
+		c.byHash[strings.ToLower(sum)] = key
+	}
+	c.Entries[key] = entry
+	delete(c.Aliases, key)
+	c.save()
+	if lat, lon, ok := entry.position(); ok {
+		geocodeLater(c.dir, path, lat, lon)
+	}
+	analyzeLater(c.dir, path)
+}
+
+// AddAlias records that a client's upload for path was satisfied by an identical stored file
+func (c *Catalog) AddAlias(path, name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.Aliases[c.catalogName(path)] = name
+	c.save()
+}
+
+// HasAlias reports whether path was deduplicated against a file that still exists
+func (c *Catalog) HasAlias(path string) bool {
+	c.mu.RLock()
+	name, ok := c.Aliases[c.catalogName(path)]
+	if ok {
+		path = c.path(name)
+	}
+	c.mu.RUnlock()
+	if !ok {
+		return false
+	}
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// CommentsFor returns the comment thread of the file at path, oldest first
+func (c *Catalog) CommentsFor(path string) []Comment {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	comments := c.Comments[c.catalogName(path)]
+	return append([]Comment(nil), comments...)
+}
+
+// AddComment appends a comment to the thread of the file at path
+func (c *Catalog) AddComment(path string, comment Comment) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	key := c.catalogName(path)
+	c.Comments[key] = append(c.Comments[key], comment)
+	c.save()
+}
+
+// Panorama returns the panorama kind of the file at path, probing and caching it if the
+// catalog hasn't yet
+func (c *Catalog) Panorama(path string) string {
+	key := c.catalogName(path)
+	c.mu.RLock()
+	if e, ok := c.Entries[key]; ok && e.Probed {
+		c.mu.RUnlock()
+		return e.Panorama
+	}
+	c.mu.RUnlock()
+
+	panorama := detectPanorama(path)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.Entries[key]; ok {
+		e.Panorama, e.Probed = panorama, true
+		c.save()
+	}
+	return panorama
+}
+
+// Location returns the phone's default time zone, the server's if none is set
+func (c *Catalog) Location() *time.Location {
+	c.mu.RLock()
+	name := c.TimeZone
+	c.mu.RUnlock()
+	if name != "" {
+		if loc, err := time.LoadLocation(name); err == nil {
+			return loc
+		}
+	}
+	return time.Local
+}
+
+// SetTimeZone sets the phone's default time zone (an IANA name such as "Europe/Berlin")
+func (c *Catalog) SetTimeZone(name string) error {
+	if _, err := time.LoadLocation(name); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.TimeZone = name
+	c.save()
+	return nil
+}
+
+// SetTaken records the capture time of the file at path, if it is cataloged
+func (c *Catalog) SetTaken(path string, taken time.Time, zone string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if e, ok := c.Entries[c.catalogName(path)]; ok {
+		e.Taken, e.TakenZone, e.TakenPrecision = &taken, zone, ""
+		c.save()
+	}
+}
+
+// CaptureTime returns the recorded capture time of the file at path, falling back to
+// the file's modification time
+func (c *Catalog) CaptureTime(path string, info os.FileInfo) time.Time {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if e, ok := c.Entries[c.catalogName(path)]; ok && e.Taken != nil {
+		return *e.Taken
+	}
+	return info.ModTime()
+}
+
+// ShiftTaken moves the capture times of the given files by delta, starting from the
+// modification time for files without one. It returns how many files were shifted.
+func (c *Catalog) ShiftTaken(paths []string, delta time.Duration) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	shifted := 0
+	for _, path := range paths {
+		e, ok := c.ensureEntry(path)
+		if !ok {
+			continue
+		}
+		if e.Taken == nil {
+			info, err := os.Stat(path)
+			if err != nil {
+				continue
+			}
+			t := info.ModTime()
+			e.Taken = &t
+		}
+		t := e.Taken.Add(delta)
+		e.Taken, e.TakenZone = &t, "shifted"
+		shifted++
+	}
+	if shifted > 0 {
+		c.save()
+	}
+	return shifted
+}
+
+// ensureEntry returns the entry of the file at path, adding a bare one for an existing
+// file that isn't hashed yet (the next refresh fills in the rest). Callers hold c.mu.
+func (c *Catalog) ensureEntry(path string) (*CatalogEntry, bool) {
+	key := c.catalogName(path)
+	if e, ok := c.Entries[key]; ok {
+		return e, true
+	}
+	if _, err := os.Stat(path); err != nil {
+		return nil, false
+	}
+	e := &CatalogEntry{Name: key}
+	c.Entries[key] = e
+	return e, true
+}
+
+// SetMetadata assigns a hand-entered capture date (with its precision) and/or place to
+// the given files; a nil taken or place leaves that field alone unless clearPlace is set.
+// It returns how many files were updated.
+func (c *Catalog) SetMetadata(paths []string, taken *time.Time, precision string, place *Place, clearPlace bool) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	updated := 0
+	for _, path := range paths {
+		e, ok := c.ensureEntry(path)
+		if !ok {
+			continue
+		}
+		if taken != nil {
+			t := *taken
+			e.Taken, e.TakenZone, e.TakenPrecision = &t, "manual", precision
+		}
+		if place != nil || clearPlace {
+			if place != nil {
+				p := *place
+				e.Place = &p
+			} else {
+				e.Place = nil
+			}
+			e.Geo = nil
+			if lat, lon, ok := e.position(); ok {
+				geocodeLater(c.dir, path, lat, lon)
+			}
+		}
+		updated++
+	}
+	if updated > 0 {
+		c.save()
+	}
+	return updated
+}
+
+// SetFavorite marks or unmarks the given files as favorites and returns how many changed
+func (c *Catalog) SetFavorite(paths []string, favorite bool) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	updated := 0
+	for _, path := range paths {
+		e, ok := c.ensureEntry(path)
+		if !ok || e.Favorite == favorite {
+			continue
+		}
+		e.Favorite = favorite
+		updated++
+	}
+	if updated > 0 {
+		c.save()
+	}
+	return updated
+}
+
+// IsFavorite reports whether the file at path is marked as a favorite
+func (c *Catalog) IsFavorite(path string) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	e, ok := c.Entries[c.catalogName(path)]
+	return ok && e.Favorite
+}
+
+// Entry returns a copy of the catalog entry of the file at path
+func (c *Catalog) Entry(path string) (CatalogEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.refresh()
+	if e, ok := c.Entries[c.catalogName(path)]; ok {
+		return *e, true
+	}
+	return CatalogEntry{}, false
+}
+
+// AllEntries returns copies of every cataloged file's entry, in no particular order
+func (c *Catalog) AllEntries() []CatalogEntry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.refresh()
+	list := make([]CatalogEntry, 0, len(c.Entries))
+	for _, e := range c.Entries {
+		list = append(list, *e)
+	}
+	return list
+}
+
+// UsedBytes returns the disk space taken by the phone's originals, trashed ones included
+// until the trash is emptied
+func (c *Catalog) UsedBytes() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.refresh()
+	var n int64
+	for _, e := range c.Entries {
+		n += e.Size
+	}
+	for _, t := range c.Trash {
+		n += t.Size
+	}
+	return n
+}
+
+// Forget drops the entry of a file that was moved out of the library
+func (c *Catalog) Forget(path string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	key := c.catalogName(path)
+	delete(c.Entries, key)
+	delete(c.Comments, key)
+	c.byHash = nil
+	c.save()
+	unmirrorOriginal(c.dir, path)
+}
+
+// CopyUserFieldsFromTrash gives the file at path the favorite, dates and place of a
+// trashed file it replaces, e.g. a transcoded copy of a video
+func (c *Catalog) CopyUserFieldsFromTrash(trashName, path string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	t, ok := c.Trash[trashName]
+	if !ok {
+		return
+	}
+	if e, ok := c.ensureEntry(path); ok {
+		e.keepUserFields(&t.CatalogEntry)
+		c.save()
+	}
+}
+
+// MarkCreated flags the file at path as made on the server
+func (c *Catalog) MarkCreated(path string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if e, ok := c.ensureEntry(path); ok && !e.Created {
+		e.Created = true
+		c.save()
+	}
+}
+
+// SetSource records where the file at path came from
+func (c *Catalog) SetSource(path string, src *MediaSource) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if e, ok := c.ensureEntry(path); ok {
+		e.Source = src
+		c.save()
+	}
+}
+
+// SetArchived records that the original of a catalog name moved to the archive, or back
+// out of it into pool (empty for the phone directory)
+func (c *Catalog) SetArchived(name string, archived bool, pool string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if e, ok := c.Entries[name]; ok {
+		e.Archived, e.Pool = archived, pool
+		if !archived {
+			now := clock.Now()
+			e.Retrieved = &now
+		}
+		c.pooled, c.archived = nil, nil
+		c.save()
+	}
+}
+
+// TrashArchived moves the entry of an archived original, whose file archive.go moved into
+// the archive's trash, into the trash like MoveToTrash
+func (c *Catalog) TrashArchived(name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.Entries[name]
+	if !ok {
+		return
+	}
+	c.Trash[name] = &TrashEntry{CatalogEntry: *e, Deleted: clock.Now()}
+	delete(c.Entries, name)
+	c.byHash, c.archived = nil, nil
+	c.purgeExpiredTrash()
+	c.save()
+}
+
+// ArchivedFile finds an archived original by the name the web UI uses for it: its
+// thumbnail name, or the file name of a video
+func (c *Catalog) ArchivedFile(name string) (string, bool) {
+	if !strings.HasPrefix(strings.ToLower(name), "tbn-") {
+		name = thumbnailName(name)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.refresh()
+	if c.archived == nil {
+		c.archived = make(map[string]string)
+		for key, e := range c.Entries {
+			if e.Archived {
+				c.archived[thumbnailName(path.Base(key))] = key
+			}
+		}
+	}
+	key, ok := c.archived[name]
+	return key, ok
+}
+
+// IsCreated reports whether the file at path was made on the server
+func (c *Catalog) IsCreated(path string) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	e, ok := c.Entries[c.catalogName(path)]
+	return ok && e.Created
+}
+
+// MarkDamaged flags the file at path as damaged, if its entry still has the given hash.
+// Damaged files aren't offered for deduplication, so a fresh upload of the same content
+// is stored over them.
+func (c *Catalog) MarkDamaged(path, sum, problem string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.Entries[c.catalogName(path)]
+	if !ok || !strings.EqualFold(e.SHA256, sum) {
+		return false // stored again meanwhile
+	}
+	e.Damaged = problem
+	c.byHash = nil
+	c.save()
+	return true
+}
+
+// Damaged returns what the integrity scrub found wrong with the file at path, if anything
+func (c *Catalog) Damaged(path string) string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if e, ok := c.Entries[c.catalogName(path)]; ok {
+		return e.Damaged
+	}
+	return ""
+}
+
+// SetThumbnailPolicy records the policy the thumbnail of the file at path was generated with
+func (c *Catalog) SetThumbnailPolicy(path, key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if e, ok := c.ensureEntry(path); ok && e.Thumbnail != key {
+		e.Thumbnail = key
+		c.save()
+	}
+}
+
+// OutdatedThumbnails returns the names of the files whose thumbnails were generated with
+// a different policy than photoKey (videoKey for videos). Entries without a recorded
+// policy count as made under the defaults.
+func (c *Catalog) OutdatedThumbnails(photoKey, videoKey, defaultPhotoKey, defaultVideoKey string) []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	var names []string
+	for name, e := range c.Entries {
+		want, have := photoKey, e.Thumbnail
+		if hasExtension(name, videoExtensions) {
+			want = videoKey
+			if have == "" {
+				have = defaultVideoKey
+			}
+		} else if have == "" {
+			have = defaultPhotoKey
+		}
+		if have != want {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// VideoDuration returns the length of the video at path in seconds, probing and caching
+// it if the catalog hasn't yet
+func (c *Catalog) VideoDuration(ctx context.Context, path string) float64 {
+	key := c.catalogName(path)
+	c.mu.RLock()
+	if e, ok := c.Entries[key]; ok && e.Duration != nil {
+		c.mu.RUnlock()
+		return *e.Duration
+	}
+	c.mu.RUnlock()
+
+	duration, err := probeVideoDuration(ctx, path)
+	if err != nil {
+		log.Printf("Error probing duration of %s: %v", path, err)
+		if ctx.Err() != nil {
+			return 0 // don't cache a cancelled probe
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.ensureEntry(path); ok {
+		e.Duration = &duration
+		c.save()
+	}
+	return duration
+}
+
+// Exif returns the camera metadata of the photo at path, extracting and caching it for
+// files stored before the catalog recorded it; nil for videos
+func (c *Catalog) Exif(path string) *ExifInfo {
+	key := c.catalogName(path)
+	c.mu.RLock()
+	if e, ok := c.Entries[key]; ok && e.Exif != nil {
+		c.mu.RUnlock()
+		return e.Exif
+	}
+	c.mu.RUnlock()
+
+	info := readExifInfo(path)
+	if info == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.ensureEntry(path); ok {
+		e.Exif = info
+		c.save()
+	}
+	return info
+}
+
+// SetGeo records the reverse-geocoded place of the file at path
+func (c *Catalog) SetGeo(path string, place *GeoPlace) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if e, ok := c.ensureEntry(path); ok {
+		e.Geo = place
+		c.save()
+	}
+}
+
+// SetAnalysis records what an analyzer found in the file at path, unless the file's content
+// changed from sum while it looked
+func (c *Catalog) SetAnalysis(path, sum, analyzer string, a *Analysis) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.Entries[c.catalogName(path)]
+	if !ok || !strings.EqualFold(e.SHA256, sum) {
+		return
+	}
+	// Entries handed out by Entry and AllEntries share the map, so replace it
+	analysis := make(map[string]*Analysis, len(e.Analysis)+1)
+	for name, old := range e.Analysis {
+		analysis[name] = old
+	}
+	analysis[analyzer] = a
+	e.Analysis = analysis
+	c.save()
+}
+
+// Metadata returns the recorded capture date precision and place of the file at path
+func (c *Catalog) Metadata(path string) (string, *Place) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if e, ok := c.Entries[c.catalogName(path)]; ok {
+		return e.TakenPrecision, e.Place
+	}
+	return "", nil
+}
+
+// trashPath is where the file with the given catalog name is kept once trashed; callers
+// hold c.mu
+func (c *Catalog) trashPath(name string) string {
+	if t, ok := c.Trash[name]; ok {
+		return trashedPath(c.dir, &t.CatalogEntry)
+	}
+	if e, ok := c.Entries[name]; ok {
+		return trashedPath(c.dir, e)
+	}
+	return filepath.Join(c.dir, trashDirName, filepath.FromSlash(name))
+}
+
+// MoveToTrash moves the file at path into the phone's trash, keeping its catalog entry
+// so a restore brings back its favorite, dates and place
+func (c *Catalog) MoveToTrash(path string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	entry := CatalogEntry{Name: c.catalogName(path), Size: info.Size(), ModTime: info.ModTime()}
+	if pool, _, ok := poolOf(c.dir, path); ok {
+		entry.Pool = pool // trashed on the pool's volume, so it is a rename
+	}
+	key := entry.Name
+	dest := trashedPath(c.dir, &entry)
+	if err := os.MkdirAll(filepath.Dir(dest), 0o755); err != nil {
+		return err
+	}
+	if err := os.Rename(path, dest); err != nil {
+		return err
+	}
+	unmirrorOriginal(c.dir, path)
+
+	if e, ok := c.Entries[key]; ok {
+		entry = *e
+	}
+	c.Trash[key] = &TrashEntry{CatalogEntry: entry, Deleted: clock.Now()}
+	delete(c.Entries, key)
+	c.byHash = nil
+	c.purgeExpiredTrash()
+	c.save()
+	return nil
+}
+
+// Relocate moves the original at from to to inside the phone directory, taking its catalog
+// entry, comments and the aliases pointing at it along. The destination must not exist.
+func (c *Catalog) Relocate(from, to string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, err := os.Lstat(to); err == nil {
+		return fmt.Errorf("%s already exists", c.catalogName(to))
+	}
+	if err := os.MkdirAll(filepath.Dir(to), 0o755); err != nil {
+		return err
+	}
+	if err := os.Rename(from, to); err != nil {
+		return err
+	}
+	unmirrorOriginal(c.dir, from)
+
+	oldKey, newKey := c.catalogName(from), c.catalogName(to)
+	if e, ok := c.Entries[oldKey]; ok {
+		e.Name = newKey
+		c.Entries[newKey] = e
+		delete(c.Entries, oldKey)
+	}
+	if comments, ok := c.Comments[oldKey]; ok {
+		c.Comments[newKey] = comments
+		delete(c.Comments, oldKey)
+	}
+	for alias, name := range c.Aliases {
+		if name == oldKey {
+			c.Aliases[alias] = newKey
+		}
+	}
+	c.byHash = nil
+	c.save()
+	go mirrorOriginal(c.dir, to)
+	return nil
+}
+
+// RestoreFromTrash moves a trashed file back to where it was deleted from
+func (c *Catalog) RestoreFromTrash(name string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	t, ok := c.Trash[name]
+	if !ok {
+		return fmt.Errorf("%s is not in the trash", name)
+	}
+	src := c.trashPath(name)
+	dest := entryPath(c.dir, &t.CatalogEntry)
+	if _, err := os.Stat(dest); err == nil {
+		return fmt.Errorf("%s exists again, delete or rename it first", name)
+	}
+	if t.Archived {
+		dest = restoredArchivePath(c.dir, name, src) // back into the archive
+	}
+	if err := os.MkdirAll(filepath.Dir(dest), 0o755); err != nil {
+		return err
+	}
+	if err := os.Rename(src, dest); err != nil {
+		return err
+	}
+	entry := t.CatalogEntry
+	c.Entries[name] = &entry
+	delete(c.Trash, name)
+	c.byHash, c.pooled, c.archived = nil, nil, nil
+	c.save()
+	if !entry.Archived {
+		go mirrorOriginal(c.dir, dest) // a copy of a large video shouldn't hold the catalog
+	}
+	return nil
+}
+
+// DeleteFromTrash removes a trashed file for good
+func (c *Catalog) DeleteFromTrash(name string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.Trash[name]; !ok {
+		return fmt.Errorf("%s is not in the trash", name)
+	}
+	if err := os.Remove(c.trashPath(name)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	delete(c.Trash, name)
+	c.save()
+	return nil
+}
+
+// TrashEntries returns the files in the trash, most recently deleted first. Files past
+// trashRetention are removed first.
+func (c *Catalog) TrashEntries() []TrashEntry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.purgeExpiredTrash() {
+		c.save()
+	}
+	list := make([]TrashEntry, 0, len(c.Trash))
+	for _, t := range c.Trash {
+		list = append(list, *t)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Deleted.After(list[j].Deleted) })
+	return list
+}
+
+// purgeExpiredTrash removes trashed files deleted more than trashRetention ago, and the
+// records of trashed files that vanished from disk. Callers hold c.mu and save if it
+// reports a change.
+func (c *Catalog) purgeExpiredTrash() bool {
+	changed := false
+	for name, t := range c.Trash {
+		path := c.trashPath(name)
+		if clock.Since(t.Deleted) > trashRetention {
+			if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+				log.Printf("Error emptying trash %s: %v", path, err)
+				continue
+			}
+			log.Printf("Removed %s from the trash for good", path)
+		} else if _, err := os.Stat(path); err == nil {
+			continue
+		}
+		delete(c.Trash, name)
+		changed = true
+	}
+	return changed
+}
+
+// findHashInOtherPhones looks for a stored file with the given hash in any phone directory
+// other than exceptDir, for hard-linking identical files across phones.
+func findHashInOtherPhones(baseDir, exceptDir, sum string) (string, bool) {
+	entries, err := os.ReadDir(baseDir)
+	if err != nil {
+		return "", false
+	}
+	except, _ := filepath.Abs(exceptDir)
+	for _, e := range entries {
+		if !e.IsDir() || presetFolders[strings.ToLower(e.Name())] || strings.HasPrefix(e.Name(), ".") {
+			continue
+		}
+		dir := filepath.Join(baseDir, e.Name())
+		if abs, _ := filepath.Abs(dir); abs == except {
+			continue
+		}
+		c := openCatalog(dir)
+		if name, ok := c.FindByHash(sum); ok {
+			return c.Path(name), true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// catalogVersion is the catalog format this server writes. Catalogs written by older
+// servers are migrated step by step when first opened, after a backup of the file.
+const catalogVersion = 1
+
+// catalogMigration upgrades a decoded catalog document from version-1 to version
+type catalogMigration struct {
+	version     int
+	description string
+	migrate     func(doc map[string]interface{}) error
+}
+
+// catalogMigrations are applied in order; append new ones with the next version and bump
+// catalogVersion. Never change a released migration.
+var catalogMigrations = []catalogMigration{
+	{1, "lowercase content hashes and fill in entry names", func(doc map[string]interface{}) error {
+		entries, _ := doc["entries"].(map[string]interface{})
+		for key, v := range entries {
+			entry, ok := v.(map[string]interface{})
+			if !ok {
+				delete(entries, key)
+				continue
+			}
+			if sum, ok := entry["sha256"].(string); ok {
+				entry["sha256"] = strings.ToLower(sum)
+			}
+			if name, _ := entry["name"].(string); name == "" {
+				entry["name"] = key
+			}
+		}
+		return nil
+	}},
+}
+
+// migrateCatalogFile brings the catalog file of a phone directory up to catalogVersion.
+// The original is kept as .catalog.json.v<old version>.bak.
+func migrateCatalogFile(dir string) error {
+	path := filepath.Join(dir, catalogFileName)
+	b, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var doc map[string]interface{}
+	if err := json.Unmarshal(b, &doc); err != nil {
+		return nil // unreadable catalogs are rebuilt by openCatalog
+	}
+	version := 0
+	if v, ok := doc["version"].(float64); ok {
+		version = int(v)
+	}
+	if version == catalogVersion {
+		return nil
+	}
+
+	backup := fmt.Sprintf("%s.v%d.bak", path, version)
+	if err := os.WriteFile(backup, b, 0o644); err != nil {
+		return fmt.Errorf("back up catalog: %w", err)
+	}
+	if version > catalogVersion {
+		// Written by a newer server; this one rewrites it in its own format
+		log.Printf("Catalog in %s has version %d, newer than %d; kept a copy as %s", dir, version, catalogVersion, backup)
+		return nil
+	}
+
+	for _, m := range catalogMigrations {
+		if m.version <= version {
+			continue
+		}
+		if err := m.migrate(doc); err != nil {
+			return fmt.Errorf("migration %d (%s): %w", m.version, m.description, err)
+		}
+		log.Printf("Catalog in %s: applied migration %d (%s)", dir, m.version, m.description)
+		version = m.version
+	}
+	doc["version"] = version
+
+	b, err = json.Marshal(doc)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(path+".tmp", b, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// migrateCatalogs migrates the catalogs of all phone directories at startup, so format
+// problems show up in the log right away rather than on a phone's next sync
+func migrateCatalogs(baseDir string) {
+	entries, err := os.ReadDir(baseDir)
+	if err != nil {
+		return
+	}
+	for _, e := range entries {
+		if !e.IsDir() || presetFolders[strings.ToLower(e.Name())] || strings.HasPrefix(e.Name(), ".") {
+			continue
+		}
+		if err := migrateCatalogFile(filepath.Join(baseDir, e.Name())); err != nil {
+			log.Printf("Error migrating catalog of %s: %v", e.Name(), err)
+		}
+	}
+}
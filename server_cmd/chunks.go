@@ -0,0 +1,196 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/bits"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// maxTotalChunks bounds the bitmap a client can make us allocate
+const maxTotalChunks = 1 << 24
+
+// maxMissingInAck caps how many missing chunk indices are listed in one ACK
+const maxMissingInAck = 100
+
+// diskReserveBytes is kept free beyond a transfer's size when admitting it
+const diskReserveBytes = 64 * 1024 * 1024
+
+// errNoSpace reports that a transfer doesn't fit on the disk
+var errNoSpace = errors.New("not enough free disk space")
+
+// chunkBitmap records which chunk indices of a transfer have been written
+type chunkBitmap []uint64
+
+func newChunkBitmap(n int) chunkBitmap {
+	return make(chunkBitmap, (n+63)/64)
+}
+
+func (b chunkBitmap) set(i int) {
+	b[i/64] |= 1 << uint(i%64)
+}
+
+func (b chunkBitmap) has(i int) bool {
+	return b[i/64]&(1<<uint(i%64)) != 0
+}
+
+func (b chunkBitmap) count() int {
+	n := 0
+	for _, w := range b {
+		n += bits.OnesCount64(w)
+	}
+	return n
+}
+
+// validateChunkedStart checks the transfer parameters of a chunked file start message
+func validateChunkedStart(totalSize int64, chunkSize, totalChunks int) error {
+	if chunkSize <= 0 || totalChunks <= 0 || totalChunks > maxTotalChunks {
+		return fmt.Errorf("invalid chunkSize %d / totalChunks %d", chunkSize, totalChunks)
+	}
+	if totalSize > 0 && int64(chunkSize)*int64(totalChunks) < totalSize {
+		return fmt.Errorf("%d chunks of %d bytes can't hold %d bytes", totalChunks, chunkSize, totalSize)
+	}
+	return nil
+}
+
+// reserveChunkedFile checks that a transfer of totalSize bytes fits in dir and
+// preallocates its temp file. errNoSpace means the transfer must be refused.
+func reserveChunkedFile(f *os.File, dir string, totalSize int64) error {
+	if totalSize <= 0 {
+		return nil
+	}
+	if free, err := diskFreeBytes(dir); err == nil && uint64(totalSize)+diskReserveBytes > free {
+		return errNoSpace
+	}
+	return preallocateFile(f, totalSize)
+}
+
+// writeChunk stores one chunk at its offset, so chunks may arrive in any order and
+// retransmits simply overwrite the same bytes. It returns the ACK code on rejection.
+func (info *ChunkedFileInfo) writeChunk(index int, data []byte) (string, error) {
+	if index < 0 || index >= info.TotalChunks {
+		return ackCodeRange, fmt.Errorf("chunk index %d out of range (0-%d)", index, info.TotalChunks-1)
+	}
+	if len(data) > info.ChunkSize {
+		return ackCodeSize, fmt.Errorf("chunk %d is %d bytes, larger than chunkSize %d", index, len(data), info.ChunkSize)
+	}
+	if _, err := info.TempFile.WriteAt(data, int64(index)*int64(info.ChunkSize)); err != nil {
+		return writeErrorCode(err), err
+	}
+	if index == info.TotalChunks-1 {
+		info.DataEnd = int64(index)*int64(info.ChunkSize) + int64(len(data))
+	}
+	if !info.Received.has(index) {
+		info.Received.set(index)
+		info.ReceivedChunks++
+	}
+	return "", nil
+}
+
+// missingChunks lists the chunk indices not received yet, at most limit of them
+func (info *ChunkedFileInfo) missingChunks(limit int) []int {
+	var missing []int
+	for i := 0; i < info.TotalChunks && len(missing) < limit; i++ {
+		if !info.Received.has(i) {
+			missing = append(missing, i)
+		}
+	}
+	return missing
+}
+
+// missingChunksAck builds the legacy "ERR:<id>:missing:<i>,<j>,..." asking the client to resend chunks
+func missingChunksAck(id string, missing []int) string {
+	list := make([]string, len(missing))
+	for i, idx := range missing {
+		list[i] = strconv.Itoa(idx)
+	}
+	return "ERR:" + id + ":missing:" + strings.Join(list, ",")
+}
+
+// chunkedStateSuffix marks the file next to a kept temp file that records the transfer's
+// progress, written when a shutdown interrupts it
+const chunkedStateSuffix = ".state"
+
+// chunkedTransferState is what a kept transfer needs to resume
+type chunkedTransferState struct {
+	ID          string      `json:"id"`
+	Media       string      `json:"media"`
+	TotalSize   int64       `json:"totalSize"`
+	ChunkSize   int         `json:"chunkSize"`
+	TotalChunks int         `json:"totalChunks"`
+	Received    chunkBitmap `json:"received"`
+	DataEnd     int64       `json:"dataEnd"`
+	SHA256      string      `json:"sha256,omitempty"`
+	Taken       string      `json:"taken,omitempty"`
+	MTime       string      `json:"mtime,omitempty"`
+}
+
+// persistChunkedTransfer keeps an incomplete transfer's temp file and records its
+// progress next to it, so a START for the same file after a restart resumes it
+func persistChunkedTransfer(info *ChunkedFileInfo) error {
+	if info.TempFilePath == "" {
+		return fmt.Errorf("no temp file")
+	}
+	b, err := json.Marshal(chunkedTransferState{
+		ID: info.ID, Media: info.Media, TotalSize: info.TotalSize, ChunkSize: info.ChunkSize,
+		TotalChunks: info.TotalChunks, Received: info.Received, DataEnd: info.DataEnd,
+		SHA256: info.SHA256, Taken: info.Taken, MTime: info.MTime,
+	})
+	if err != nil {
+		return err
+	}
+	path := info.TempFilePath + chunkedStateSuffix
+	if err := os.WriteFile(path+".tmp", b, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// resumeChunkedTransfer looks for a kept transfer of the same file (same ID and transfer
+// parameters) in recvDir and reopens it
+func resumeChunkedTransfer(recvDir, id string, totalSize int64, chunkSize, totalChunks int, sum string) (*ChunkedFileInfo, bool) {
+	pattern := filepath.Join(recvDir, ".chunked_"+strings.ReplaceAll(id, string(filepath.Separator), "_")+"_*.tmp"+chunkedStateSuffix)
+	states, _ := filepath.Glob(pattern)
+	for _, statePath := range states {
+		b, err := os.ReadFile(statePath)
+		if err != nil {
+			continue
+		}
+		var st chunkedTransferState
+		if err := json.Unmarshal(b, &st); err != nil || st.ID != id || st.TotalSize != totalSize ||
+			st.ChunkSize != chunkSize || st.TotalChunks != totalChunks || st.SHA256 != sum ||
+			len(st.Received) != len(newChunkBitmap(totalChunks)) {
+			continue
+		}
+		tmpPath := strings.TrimSuffix(statePath, chunkedStateSuffix)
+		f, err := os.OpenFile(tmpPath, os.O_RDWR, 0)
+		if err != nil {
+			os.Remove(statePath)
+			continue
+		}
+		// The transfer belongs to this connection again
+		os.Remove(statePath)
+		return &ChunkedFileInfo{
+			ID:             id,
+			Media:          st.Media,
+			TotalSize:      st.TotalSize,
+			ChunkSize:      st.ChunkSize,
+			TotalChunks:    st.TotalChunks,
+			ReceivedChunks: st.Received.count(),
+			Received:       st.Received,
+			TempFilePath:   tmpPath,
+			TempFile:       f,
+			DataEnd:        st.DataEnd,
+			RecvDir:        recvDir,
+			SHA256:         st.SHA256,
+			Taken:          st.Taken,
+			MTime:          st.MTime,
+			LastActivity:   clock.Now(),
+		}, true
+	}
+	return nil, false
+}
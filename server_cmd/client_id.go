@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// maxClientIDLength bounds a file ID; each of its path elements must also fit a file name
+const maxClientIDLength = 1024
+
+// reservedDeviceNames can't be file names on Windows, whatever their extension
+var reservedDeviceNames = map[string]bool{
+	"CON": true, "PRN": true, "AUX": true, "NUL": true,
+	"COM1": true, "COM2": true, "COM3": true, "COM4": true, "COM5": true, "COM6": true, "COM7": true, "COM8": true, "COM9": true,
+	"LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true, "LPT5": true, "LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+}
+
+// validateClientID checks a file ID sent by a client, which becomes the file's path in
+// the phone directory: a clean, relative, slash-separated path such as "IMG_0001" or
+// "Camera/IMG_0001.jpg". It rejects anything that could resolve outside the phone
+// directory (absolute paths, "..", backslashes, drive letters), hidden names, which the
+// server keeps its own files under (the catalog, the trash, partial transfers), the
+// thumbnails folder, control characters, and names Windows can't store, so a library
+// can be copied between systems.
+func validateClientID(id string) error {
+	if id == "" {
+		return fmt.Errorf("empty id")
+	}
+	if len(id) > maxClientIDLength {
+		return fmt.Errorf("id longer than %d bytes", maxClientIDLength)
+	}
+	if strings.ContainsAny(id, "\\:") {
+		return fmt.Errorf("invalid id %q: backslashes and colons aren't allowed", id)
+	}
+	for _, r := range id {
+		if r < 0x20 || r == 0x7f {
+			return fmt.Errorf("invalid id %q: control characters aren't allowed", id)
+		}
+	}
+	if path.IsAbs(id) || filepath.IsAbs(id) {
+		return fmt.Errorf("invalid id %q: absolute paths aren't allowed", id)
+	}
+	if path.Clean(id) != id {
+		return fmt.Errorf("invalid id %q: not a clean relative path", id)
+	}
+	elems := strings.Split(id, "/")
+	for i, elem := range elems {
+		switch {
+		case elem == "." || elem == "..":
+			return fmt.Errorf("invalid id %q: path traversal isn't allowed", id)
+		case strings.HasPrefix(elem, "."):
+			return fmt.Errorf("invalid id %q: hidden names are reserved for the server", id)
+		case elem == "thumbnails" && i < len(elems)-1:
+			return fmt.Errorf("invalid id %q: the thumbnails folder is reserved for the server", id)
+		case strings.HasSuffix(elem, partialFileSuffix):
+			return fmt.Errorf("invalid id %q: %s files are reserved for transfers", id, partialFileSuffix)
+		case len(elem) > 255:
+			return fmt.Errorf("invalid id %q: a path element is longer than 255 bytes", id)
+		case strings.HasSuffix(elem, " ") || strings.HasSuffix(elem, "."):
+			return fmt.Errorf("invalid id %q: names can't end in a space or dot", id)
+		case reservedDeviceNames[strings.ToUpper(strings.TrimSpace(strings.SplitN(elem, ".", 2)[0]))]:
+			return fmt.Errorf("invalid id %q: %s is a reserved device name", id, elem)
+		}
+	}
+	return nil
+}
+
+// validatePhoneName checks a phone name sent by a client without a device ID, which
+// becomes the name of the phone's directory in the receive directory: a single plain
+// file name. Like a file ID it can't lead outside the receive directory or be hidden,
+// and it can't take the name of a folder the server uses itself.
+func validatePhoneName(name string) error {
+	switch {
+	case name == "":
+		return fmt.Errorf("empty phone name")
+	case len(name) > 255:
+		return fmt.Errorf("phone name longer than 255 bytes")
+	case strings.ContainsAny(name, "/\\:"):
+		return fmt.Errorf("invalid phone name %q: slashes, backslashes and colons aren't allowed", name)
+	case name == "." || name == "..":
+		return fmt.Errorf("invalid phone name %q: path traversal isn't allowed", name)
+	case filepath.IsAbs(name):
+		return fmt.Errorf("invalid phone name %q: absolute paths aren't allowed", name)
+	case strings.HasPrefix(name, "."):
+		return fmt.Errorf("invalid phone name %q: hidden names are reserved for the server", name)
+	case presetFolders[strings.ToLower(name)]:
+		return fmt.Errorf("invalid phone name %q: the folder is reserved for the server", name)
+	case strings.HasSuffix(name, " ") || strings.HasSuffix(name, "."):
+		return fmt.Errorf("invalid phone name %q: names can't end in a space or dot", name)
+	case reservedDeviceNames[strings.ToUpper(strings.TrimSpace(strings.SplitN(name, ".", 2)[0]))]:
+		return fmt.Errorf("invalid phone name %q: %s is a reserved device name", name, name)
+	}
+	for _, r := range name {
+		if r < 0x20 || r == 0x7f {
+			return fmt.Errorf("invalid phone name %q: control characters aren't allowed", name)
+		}
+	}
+	return nil
+}
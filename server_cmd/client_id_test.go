@@ -0,0 +1,141 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateClientID(t *testing.T) {
+	valid := []string{
+		"IMG_0001",
+		"IMG_0001.jpg",
+		"Camera/IMG_0001.jpg",
+		"DCIM/Camera/VID_20240501_120000.mp4",
+		"thumbnails.jpg",
+		"Album/thumbnails",
+		"photo with spaces.jpg",
+		"Ünïcödé/写真.heic",
+	}
+	for _, id := range valid {
+		if err := validateClientID(id); err != nil {
+			t.Errorf("validateClientID(%q) = %v, want nil", id, err)
+		}
+	}
+
+	invalid := []string{
+		"",
+		"/etc/passwd",
+		"../outside.jpg",
+		"Camera/../../outside.jpg",
+		"Camera/..",
+		"./IMG_0001.jpg",
+		"Camera//IMG_0001.jpg",
+		"Camera/",
+		`Camera\IMG_0001.jpg`,
+		`..\..\outside.jpg`,
+		"C:/Windows/win.ini",
+		"C:IMG_0001.jpg",
+		".catalog.json",
+		"Camera/.trash/IMG_0001.jpg",
+		"thumbnails/tbn-IMG_0001.jpg",
+		"IMG_0001.jpg.partial",
+		"IMG_0001\x00.jpg",
+		"IMG_0001\n.jpg",
+		"IMG_0001.jpg ",
+		"IMG_0001.",
+		"CON",
+		"Camera/nul.jpg",
+		"lpt1.txt",
+		string(make([]byte, maxClientIDLength+1)),
+	}
+	for _, id := range invalid {
+		if err := validateClientID(id); err == nil {
+			t.Errorf("validateClientID(%q) = nil, want an error", id)
+		}
+	}
+}
+
+func TestValidatePhoneName(t *testing.T) {
+	valid := []string{
+		"Pixel",
+		"Pixel 8 Pro",
+		"iPhone-15",
+		"Anna's phone",
+		"Ünïcödé",
+		"con-phone",
+	}
+	for _, name := range valid {
+		if err := validatePhoneName(name); err != nil {
+			t.Errorf("validatePhoneName(%q) = %v, want nil", name, err)
+		}
+	}
+
+	invalid := []string{
+		"",
+		".",
+		"..",
+		"../../x",
+		"../x",
+		"a/b",
+		"/etc",
+		`a\b`,
+		`..\x`,
+		"C:",
+		".hidden",
+		".devices.json",
+		"music",
+		"Data",
+		"Pixel ",
+		"Pixel.",
+		"CON",
+		"aux.phone",
+		"Pixel\x00",
+		"Pixel\t8",
+		string(make([]byte, 256)),
+	}
+	for _, name := range invalid {
+		if err := validatePhoneName(name); err == nil {
+			t.Errorf("validatePhoneName(%q) = nil, want an error", name)
+		}
+	}
+}
+
+func TestDeviceDirName(t *testing.T) {
+	id := "1a2b3c4d-5e6f"
+	tests := map[string]string{
+		"Pixel 8":                "Pixel 8",
+		"a/b":                    "a_b",
+		"":                       "device-1a2b3c4d",
+		"...":                    "device-1a2b3c4d",
+		"music":                  "music-1a2b3c4d",
+		"Data":                   "Data-1a2b3c4d",
+		"CON":                    "CON-1a2b3c4d",
+		"NUL.jpg":                "device-1a2b3c4d",
+		".hidden":                "hidden",
+		strings.Repeat("x", 300): strings.Repeat("x", maxDeviceDirName),
+	}
+	for name, want := range tests {
+		got := deviceDirName(name, id)
+		if got != want {
+			t.Errorf("deviceDirName(%q) = %q, want %q", name, got, want)
+		}
+		if err := validatePhoneName(got); err != nil {
+			t.Errorf("deviceDirName(%q) = %q, which validatePhoneName rejects: %v", name, got, err)
+		}
+	}
+}
+
+func TestRegisterDeviceCaseInsensitiveDirs(t *testing.T) {
+	baseDir := t.TempDir()
+	first, err := registerDevice(baseDir, "device-one", "Pixel")
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := registerDevice(baseDir, "device-two", "pixel")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.Dir != "Pixel" || second.Dir != "pixel-2" {
+		t.Errorf("dirs = %q, %q, want \"Pixel\", \"pixel-2\"", first.Dir, second.Dir)
+	}
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// clientLogFileName holds the rolling client-reported error log, per phone directory
+const clientLogFileName = ".client_log.json"
+
+const (
+	// maxClientLogEntries is how many reports are kept per phone, oldest dropped first
+	maxClientLogEntries = 500
+
+	// maxClientLogMessage and maxClientLogDetails truncate oversized reports
+	maxClientLogMessage = 1024
+	maxClientLogDetails = 8 * 1024
+)
+
+// clientLogMutex serializes updates of the client log files
+var clientLogMutex sync.Mutex
+
+// ClientLogEntry is one diagnostic report sent by a client (msgTypeClientLog payload)
+type ClientLogEntry struct {
+	Received   time.Time `json:"received"`
+	ClientTime string    `json:"clientTime,omitempty"` // client clock when the problem happened
+	Level      string    `json:"level"`                // error, warning, info, crash
+	Message    string    `json:"message"`              // short summary, e.g. "upload failed: timeout"
+	Details    string    `json:"details,omitempty"`    // stack trace or context
+	AppVersion string    `json:"appVersion,omitempty"`
+	DeviceID   string    `json:"deviceId,omitempty"`
+}
+
+func truncateString(s string, max int) string {
+	if len(s) <= max {
+		return s
+	}
+	return s[:max] + "…"
+}
+
+func loadClientLog(phoneDir string) ([]ClientLogEntry, error) {
+	var entries []ClientLogEntry
+	b, err := os.ReadFile(filepath.Join(phoneDir, clientLogFileName))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return entries, nil
+		}
+		return nil, err
+	}
+	if err := json.Unmarshal(b, &entries); err != nil {
+		return nil, fmt.Errorf("parse client log: %w", err)
+	}
+	return entries, nil
+}
+
+// appendClientLog stores the reports in a client log payload, either a single entry or
+// {"entries":[...]}, and returns how many were stored
+func appendClientLog(phoneDir, deviceID string, payload []byte) (int, error) {
+	var batch struct {
+		Entries []ClientLogEntry `json:"entries"`
+	}
+	if err := json.Unmarshal(payload, &batch); err != nil {
+		return 0, err
+	}
+	if len(batch.Entries) == 0 {
+		var single ClientLogEntry
+		if err := json.Unmarshal(payload, &single); err != nil {
+			return 0, err
+		}
+		batch.Entries = append(batch.Entries, single)
+	}
+
+	now := clock.Now()
+	var accepted []ClientLogEntry
+	for _, e := range batch.Entries {
+		if strings.TrimSpace(e.Message) == "" {
+			continue
+		}
+		e.Received = now
+		e.DeviceID = deviceID
+		if e.Level == "" {
+			e.Level = "error"
+		}
+		e.Level = truncateString(strings.ToLower(e.Level), 16)
+		e.Message = truncateString(e.Message, maxClientLogMessage)
+		e.Details = truncateString(e.Details, maxClientLogDetails)
+		e.ClientTime = truncateString(e.ClientTime, 64)
+		e.AppVersion = truncateString(e.AppVersion, 64)
+		accepted = append(accepted, e)
+	}
+	if len(accepted) == 0 {
+		return 0, nil
+	}
+
+	clientLogMutex.Lock()
+	defer clientLogMutex.Unlock()
+
+	entries, err := loadClientLog(phoneDir)
+	if err != nil {
+		log.Printf("Client log in %s unreadable, starting over: %v", phoneDir, err)
+		entries = nil
+	}
+	entries = append(entries, accepted...)
+	if len(entries) > maxClientLogEntries {
+		entries = entries[len(entries)-maxClientLogEntries:]
+	}
+	b, err := json.Marshal(entries)
+	if err != nil {
+		return 0, err
+	}
+	path := filepath.Join(phoneDir, clientLogFileName)
+	if err := os.WriteFile(path+".tmp", b, 0o644); err != nil {
+		return 0, err
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		return 0, err
+	}
+	return len(accepted), nil
+}
+
+// registerClientLogRoutes adds the client log admin page to the router
+func registerClientLogRoutes(router *mux.Router, config *Config) {
+	router.HandleFunc("/client-logs", func(w http.ResponseWriter, r *http.Request) {
+		baseDir := config.ReceiveDir
+		if baseDir == "" {
+			baseDir = "received"
+		}
+
+		dirs, err := os.ReadDir(baseDir)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Error reading directory: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		type phoneLog struct {
+			Phone   string
+			Entries []ClientLogEntry
+		}
+		var logs []phoneLog
+		filter := r.URL.Query().Get("phone")
+		for _, d := range dirs {
+			if !d.IsDir() || presetFolders[strings.ToLower(d.Name())] || strings.HasPrefix(d.Name(), ".") {
+				continue
+			}
+			if filter != "" && d.Name() != filter {
+				continue
+			}
+			clientLogMutex.Lock()
+			entries, err := loadClientLog(filepath.Join(baseDir, d.Name()))
+			clientLogMutex.Unlock()
+			if err != nil || len(entries) == 0 {
+				continue
+			}
+			// Newest first
+			sort.SliceStable(entries, func(i, j int) bool { return entries[i].Received.After(entries[j].Received) })
+			logs = append(logs, phoneLog{Phone: d.Name(), Entries: entries})
+		}
+
+		tmpl := `<!DOCTYPE html>
+<html>
+<head>
+    <title>Client Logs - Photo Sync Server</title>
+    <style>
+        body { font-family: 'Segoe UI', Tahoma, Arial, sans-serif; margin: 0; padding: 20px; background: #000000; color: #ffffff; }
+        h1 { color: #ffffff; font-weight: 300; letter-spacing: 1px; }
+        h2 { font-size: 20px; margin-top: 30px; color: #aaaaaa; font-weight: 300; }
+        .back-link { display: inline-block; margin-bottom: 20px; color: #88aaff; text-decoration: none; font-size: 14px; }
+        .back-link:hover { color: #aaccff; text-decoration: underline; }
+        table { border-collapse: collapse; width: 100%; max-width: 1200px; font-size: 13px; }
+        th, td { text-align: left; padding: 8px; border-bottom: 1px solid #2a2a2a; vertical-align: top; }
+        th { color: #888888; font-weight: normal; }
+        .level { font-weight: 600; text-transform: uppercase; font-size: 11px; }
+        .level-error, .level-crash { color: #ff6b6b; }
+        .level-warning { color: #fbbf24; }
+        .level-info { color: #60a5fa; }
+        details { color: #aaaaaa; }
+        pre { white-space: pre-wrap; word-break: break-all; margin: 6px 0 0 0; }
+    </style>
+</head>
+<body>
+    <a href="/" class="back-link">← Back to Home</a>
+    <h1>🩺 Client Logs</h1>
+    {{range .}}
+    <h2>📱 {{.Phone}} ({{len .Entries}})</h2>
+    <table>
+        <tr><th>Received</th><th>Level</th><th>Message</th><th>App</th><th>Client time</th></tr>
+        {{range .Entries}}
+        <tr>
+            <td>{{.Received.Format "2006-01-02 15:04:05"}}</td>
+            <td class="level level-{{.Level}}">{{.Level}}</td>
+            <td>{{.Message}}{{if .Details}}<details><summary>details</summary><pre>{{.Details}}</pre></details>{{end}}</td>
+            <td>{{.AppVersion}}</td>
+            <td>{{.ClientTime}}</td>
+        </tr>
+        {{end}}
+    </table>
+    {{else}}
+    <p>No client reports.</p>
+    {{end}}
+</body>
+</html>`
+
+		t := template.Must(template.New("clientlogs").Parse(tmpl))
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if err := t.Execute(w, logs); err != nil {
+			log.Printf("Error rendering client logs: %v", err)
+		}
+	}).Methods("GET")
+}
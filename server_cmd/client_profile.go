@@ -0,0 +1,180 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/gorilla/mux"
+)
+
+// Chunk sizes suggested to constrained clients in the HELLO limits: on a metered or
+// flaky link a failed chunk costs little to resend, and on battery the radio can sleep
+// between smaller bursts
+const (
+	meteredChunkSize = 256 * 1024
+	batteryChunkSize = 1024 * 1024
+)
+
+// syncHistoryFileName keeps the recent sync sessions of a phone, for diagnosing slow syncs
+const syncHistoryFileName = ".sync_history.json"
+
+const (
+	// maxSyncHistoryEntries is how many sessions are kept per phone, oldest dropped first
+	maxSyncHistoryEntries = 200
+
+	// maxSyncHistoryFailures bounds the failures kept per session; the count covers all
+	maxSyncHistoryFailures = 20
+)
+
+// ClientProfile is what a client tells about its circumstances in HELLO
+type ClientProfile struct {
+	OnBattery  bool   `json:"onBattery,omitempty"`
+	LowBattery bool   `json:"lowBattery,omitempty"` // battery saver on or nearly empty
+	Metered    bool   `json:"metered,omitempty"`    // cellular or a metered Wi-Fi
+	Network    string `json:"network,omitempty"`    // free-form, e.g. "wifi" or "cellular/lte", for the history
+}
+
+// String describes the profile for logs, e.g. "metered, on battery (cellular)"
+func (p *ClientProfile) String() string {
+	if p == nil {
+		return "unknown"
+	}
+	var parts []string
+	if p.Metered {
+		parts = append(parts, "metered")
+	}
+	switch {
+	case p.LowBattery:
+		parts = append(parts, "low battery")
+	case p.OnBattery:
+		parts = append(parts, "on battery")
+	}
+	if len(parts) == 0 {
+		parts = append(parts, "unconstrained")
+	}
+	s := strings.Join(parts, ", ")
+	if p.Network != "" {
+		s += " (" + p.Network + ")"
+	}
+	return s
+}
+
+// adjustLimits tightens the limits sent to a client for its profile: smaller chunks, and
+// videos left for a later sync on a metered network or a low battery
+func (p *ClientProfile) adjustLimits(limits *ServerLimits) {
+	if p == nil {
+		return
+	}
+	if p.Metered {
+		limits.ChunkSize = meteredChunkSize
+	} else if p.OnBattery || p.LowBattery {
+		limits.ChunkSize = batteryChunkSize
+	}
+	if p.Metered || p.LowBattery {
+		limits.PhotosOnly = true
+	}
+	if limits.ChunkSize > 0 && int64(limits.ChunkSize) > limits.MaxPayloadSize {
+		limits.ChunkSize = int(limits.MaxPayloadSize)
+	}
+}
+
+// SyncHistoryEntry is one finished sync session of a phone
+type SyncHistoryEntry struct {
+	SyncSummary
+	Remote      string         `json:"remote"`
+	DeviceID    string         `json:"deviceId,omitempty"`
+	BytesPerSec int64          `json:"bytesPerSec"`
+	Profile     *ClientProfile `json:"profile,omitempty"`
+}
+
+// syncHistoryMutex serializes updates of the sync history files
+var syncHistoryMutex sync.Mutex
+
+func loadSyncHistory(phoneDir string) ([]SyncHistoryEntry, error) {
+	var entries []SyncHistoryEntry
+	b, err := os.ReadFile(filepath.Join(phoneDir, syncHistoryFileName))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return entries, nil
+		}
+		return nil, err
+	}
+	if err := json.Unmarshal(b, &entries); err != nil {
+		return nil, fmt.Errorf("parse sync history: %w", err)
+	}
+	return entries, nil
+}
+
+// recordSyncHistory appends a finished session to its phone's history. Connections that
+// transferred nothing (pings, thumbnail browsing) are not recorded.
+func recordSyncHistory(phoneDir, deviceID string, s *syncSession) {
+	p := s.snapshot()
+	sum := s.summary()
+	if p.BytesReceived == 0 && sum.FilesReceived == 0 && sum.Duplicates == 0 && sum.FailedCount == 0 {
+		return
+	}
+	if len(sum.Failures) > maxSyncHistoryFailures {
+		sum.Failures = sum.Failures[:maxSyncHistoryFailures]
+	}
+	entry := SyncHistoryEntry{SyncSummary: sum, Remote: p.Remote, DeviceID: deviceID, Profile: p.Profile}
+	if elapsed := sum.Finished.Sub(sum.Started).Seconds(); elapsed >= 1 {
+		entry.BytesPerSec = int64(float64(sum.BytesReceived) / elapsed)
+	}
+
+	syncHistoryMutex.Lock()
+	defer syncHistoryMutex.Unlock()
+
+	entries, err := loadSyncHistory(phoneDir)
+	if err != nil {
+		log.Printf("Sync history in %s unreadable, starting over: %v", phoneDir, err)
+		entries = nil
+	}
+	entries = append(entries, entry)
+	if len(entries) > maxSyncHistoryEntries {
+		entries = entries[len(entries)-maxSyncHistoryEntries:]
+	}
+	b, err := json.Marshal(entries)
+	if err != nil {
+		return
+	}
+	path := filepath.Join(phoneDir, syncHistoryFileName)
+	if err := os.WriteFile(path+".tmp", b, 0o644); err == nil {
+		err = os.Rename(path+".tmp", path)
+	}
+	if err != nil {
+		log.Printf("Error writing sync history of %s: %v", phoneDir, err)
+	}
+}
+
+// registerSyncHistoryRoutes adds the per-phone sync session history to the router
+func registerSyncHistoryRoutes(router *mux.Router, config *Config) {
+	router.HandleFunc("/api/phones/{phoneName}/sync-history", func(w http.ResponseWriter, r *http.Request) {
+		phoneDir, err := phoneDirFromRequest(config, r)
+		if err != nil {
+			http.Error(w, "Invalid phone name", http.StatusBadRequest)
+			return
+		}
+
+		syncHistoryMutex.Lock()
+		entries, err := loadSyncHistory(phoneDir)
+		syncHistoryMutex.Unlock()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		// Newest first
+		sort.SliceStable(entries, func(i, j int) bool { return entries[i].Started.After(entries[j].Started) })
+		if entries == nil {
+			entries = []SyncHistoryEntry{}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "sessions": entries})
+	}).Methods("GET")
+}
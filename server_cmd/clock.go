@@ -0,0 +1,35 @@
+package main
+
+import "time"
+
+// Clock is the time source of the time-based features: transfer expiry, notification
+// throttles, caches, periodic cleaners and the tool watchdog. Network deadlines stay on
+// the real clock.
+type Clock interface {
+	Now() time.Time
+	Since(t time.Time) time.Duration
+	NewTicker(d time.Duration) Ticker
+}
+
+// Ticker is the part of time.Ticker the periodic tasks use
+type Ticker interface {
+	C() <-chan time.Time
+	Stop()
+}
+
+// clock is the server's time source; tests swap in a fakeClock
+var clock Clock = systemClock{}
+
+// systemClock is the real time
+type systemClock struct{}
+
+func (systemClock) Now() time.Time                  { return time.Now() }
+func (systemClock) Since(t time.Time) time.Duration { return time.Since(t) }
+func (systemClock) NewTicker(d time.Duration) Ticker {
+	return systemTicker{time.NewTicker(d)}
+}
+
+type systemTicker struct{ t *time.Ticker }
+
+func (t systemTicker) C() <-chan time.Time { return t.t.C }
+func (t systemTicker) Stop()               { t.t.Stop() }
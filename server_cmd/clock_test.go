@@ -0,0 +1,126 @@
+package main
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeClock only moves when told to. Tickers fire during Advance, once for every period
+// that elapsed, and like time.Ticker drop ticks their reader isn't ready for.
+type fakeClock struct {
+	mu      sync.Mutex
+	now     time.Time
+	tickers []*fakeTicker
+}
+
+func newFakeClock(now time.Time) *fakeClock {
+	return &fakeClock{now: now}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Since(t time.Time) time.Duration {
+	return c.Now().Sub(t)
+}
+
+func (c *fakeClock) NewTicker(d time.Duration) Ticker {
+	if d <= 0 {
+		panic("non-positive interval for NewTicker")
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t := &fakeTicker{clock: c, period: d, next: c.now.Add(d), ch: make(chan time.Time, 1)}
+	c.tickers = append(c.tickers, t)
+	return t
+}
+
+// Advance moves the clock forward by d, firing the tickers that came due in order
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	end := c.now.Add(d)
+	for {
+		var due *fakeTicker
+		for _, t := range c.tickers {
+			if !t.next.After(end) && (due == nil || t.next.Before(due.next)) {
+				due = t
+			}
+		}
+		if due == nil {
+			break
+		}
+		c.now = due.next
+		due.next = due.next.Add(due.period)
+		select {
+		case due.ch <- c.now:
+		default:
+		}
+	}
+	c.now = end
+	c.mu.Unlock()
+}
+
+type fakeTicker struct {
+	clock  *fakeClock
+	period time.Duration
+	next   time.Time
+	ch     chan time.Time
+}
+
+func (t *fakeTicker) C() <-chan time.Time { return t.ch }
+
+func (t *fakeTicker) Stop() {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+	tickers := t.clock.tickers[:0]
+	for _, other := range t.clock.tickers {
+		if other != t {
+			tickers = append(tickers, other)
+		}
+	}
+	t.clock.tickers = tickers
+}
+
+// useFakeClock installs a fake clock at now for the rest of the test
+func useFakeClock(t *testing.T, now time.Time) *fakeClock {
+	t.Helper()
+	c := newFakeClock(now)
+	clock = c
+	t.Cleanup(func() { clock = systemClock{} })
+	return c
+}
+
+func TestFakeClockTickers(t *testing.T) {
+	c := newFakeClock(time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC))
+	ticker := c.NewTicker(time.Hour)
+	defer ticker.Stop()
+
+	c.Advance(59 * time.Minute)
+	select {
+	case <-ticker.C():
+		t.Fatal("ticked before its period")
+	default:
+	}
+	c.Advance(time.Minute)
+	select {
+	case now := <-ticker.C():
+		if want := time.Date(2024, 6, 1, 13, 0, 0, 0, time.UTC); !now.Equal(want) {
+			t.Errorf("ticked at %v, want %v", now, want)
+		}
+	default:
+		t.Fatal("didn't tick after its period")
+	}
+
+	// Ticks the reader isn't ready for are dropped, like time.Ticker's
+	c.Advance(3 * time.Hour)
+	<-ticker.C()
+	select {
+	case <-ticker.C():
+		t.Error("kept more than one missed tick")
+	default:
+	}
+}
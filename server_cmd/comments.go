@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"path/filepath"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// maxCommentLength caps the size of a single comment, in bytes
+const maxCommentLength = 2000
+
+// registerCommentRoutes adds the photo comment thread API to the router. Photos are
+// addressed like the gallery does, by thumbnail or original name within a phone directory.
+func registerCommentRoutes(router *mux.Router, config *Config) {
+	baseDirFor := func() string {
+		if config.ReceiveDir == "" {
+			return "received"
+		}
+		return config.ReceiveDir
+	}
+	// resolve maps the route variables to the phone directory and the original file
+	resolve := func(r *http.Request) (string, string, bool) {
+		vars := mux.Vars(r)
+		phoneName := vars["phoneName"]
+		fileName := vars["fileName"]
+		if validatePhoneName(phoneName) != nil ||
+			strings.Contains(fileName, "..") || strings.ContainsAny(fileName, "/\\") {
+			return "", "", false
+		}
+		phoneDir := filepath.Join(baseDirFor(), phoneName)
+		orig, ok := originalForThumbnail(phoneDir, fileName)
+		return phoneDir, orig, ok
+	}
+
+	// Get a photo's comment thread
+	router.HandleFunc("/api/comments/{phoneName}/{fileName}", func(w http.ResponseWriter, r *http.Request) {
+		phoneDir, orig, ok := resolve(r)
+		if !ok {
+			writeJSON(w, map[string]interface{}{"success": false, "error": "Photo not found"})
+			return
+		}
+		writeJSON(w, map[string]interface{}{
+			"success":  true,
+			"name":     filepath.Base(orig),
+			"comments": openCatalog(phoneDir).CommentsFor(orig),
+		})
+	}).Methods("GET")
+
+	// Add a comment to a photo
+	router.HandleFunc("/api/comments/{phoneName}/{fileName}", func(w http.ResponseWriter, r *http.Request) {
+		phoneDir, orig, ok := resolve(r)
+		if !ok {
+			writeJSON(w, map[string]interface{}{"success": false, "error": "Photo not found"})
+			return
+		}
+		var req struct {
+			Author string `json:"author"`
+			Text   string `json:"text"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeJSON(w, map[string]interface{}{"success": false, "error": "Invalid request: " + err.Error()})
+			return
+		}
+		req.Author = strings.TrimSpace(req.Author)
+		req.Text = strings.TrimSpace(req.Text)
+		if req.Author == "" || req.Text == "" {
+			writeJSON(w, map[string]interface{}{"success": false, "error": "author and text are required"})
+			return
+		}
+		if len(req.Text) > maxCommentLength {
+			writeJSON(w, map[string]interface{}{"success": false, "error": "Comment is too long"})
+			return
+		}
+
+		comment := Comment{Author: req.Author, Text: req.Text, Time: clock.Now()}
+		openCatalog(phoneDir).AddComment(orig, comment)
+		countFeature("comment")
+
+		notifyEvent(config, PushEvent{
+			Type:    pushEventComment,
+			Title:   req.Author + " commented on a photo",
+			Message: filepath.Base(phoneDir) + "/" + filepath.Base(orig) + ": " + req.Text,
+		})
+		writeJSON(w, map[string]interface{}{"success": true, "comment": comment})
+	}).Methods("POST")
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// createdVideoThumbnails says whether videos made on the server (slideshows, trims) get
+// thumbnails like synced ones; set from skip_created_video_thumbnails at startup
+var createdVideoThumbnails = true
+
+// recordCreatedVideo catalogs a video the server just made in a phone directory and flags
+// it as created there. A thumbnail left from an earlier video of the same name is removed,
+// so the gallery generates a fresh one, and subscribed apps are told about the video.
+func recordCreatedVideo(phoneDir, path string) {
+	c := openCatalog(phoneDir)
+	if sum, err := calculateSHA256(path); err == nil {
+		c.Record(path, sum)
+	}
+	c.MarkCreated(path)
+	os.Remove(filepath.Join(phoneDir, "thumbnails", thumbnailName(filepath.Base(path))))
+	notifyMediaChange(phoneDir, mediaChangeAdded, path)
+}
+
+// skipsThumbnail reports whether the original at path of phoneDir goes without a thumbnail
+// because it is a created video and those aren't thumbnailed
+func skipsThumbnail(phoneDir, path string) bool {
+	return !createdVideoThumbnails && hasExtension(path, videoExtensions) && openCatalog(phoneDir).IsCreated(path)
+}
+
+// adoptCreatedVideoMarkers moves the created flag of videos from the hidden
+// .<name>.created files older servers wrote next to them into the catalog
+func adoptCreatedVideoMarkers(baseDir string) {
+	adopted := 0
+	for _, phone := range libraryPhones(baseDir) {
+		phoneDir := filepath.Join(baseDir, phone)
+		for _, dir := range phoneMediaDirs(phoneDir) {
+			markers, _ := filepath.Glob(filepath.Join(dir, ".*.created"))
+			for _, marker := range markers {
+				base := strings.TrimSuffix(strings.TrimPrefix(filepath.Base(marker), "."), ".created")
+				for _, ext := range videoExtensions {
+					video := filepath.Join(dir, base+ext)
+					if _, err := os.Stat(video); err == nil {
+						openCatalog(phoneDir).MarkCreated(video)
+						adopted++
+						break
+					}
+				}
+				if err := os.Remove(marker); err != nil {
+					log.Printf("Error removing created video marker %s: %v", marker, err)
+				}
+			}
+		}
+	}
+	if adopted > 0 {
+		log.Printf("Moved the created flag of %d video(s) from marker files into the catalogs", adopted)
+	}
+}
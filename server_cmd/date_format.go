@@ -0,0 +1,89 @@
+package main
+
+import (
+	"html/template"
+	"os"
+)
+
+// mediaDate is a media item's capture date as the web UI formats it in the viewer's locale
+type mediaDate struct {
+	Time      string // RFC 3339 with the offset it was taken in
+	Local     string // wall-clock time where it was taken, without offset
+	Precision string // "year", "month" or "day" for approximate dates, empty when exact
+	Fallback  string // ISO date shown until the page script formats it
+}
+
+// mediaDateFor returns the capture date of the original behind a gallery entry (an original
+// or its thumbnail), or nil when the original is gone
+func mediaDateFor(phoneDir, name string) *mediaDate {
+	orig, ok := originalForThumbnail(phoneDir, name)
+	if !ok {
+		return nil
+	}
+	info, err := os.Stat(orig)
+	if err != nil {
+		return nil
+	}
+	catalog := openCatalog(phoneDir)
+	taken := catalog.CaptureTime(orig, info)
+	precision, _ := catalog.Metadata(orig)
+	fallback := "2006-01-02"
+	switch precision {
+	case "year":
+		fallback = "2006"
+	case "month":
+		fallback = "2006-01"
+	}
+	return &mediaDate{
+		Time:      taken.Format("2006-01-02T15:04:05Z07:00"),
+		Local:     taken.Format("2006-01-02T15:04:05"),
+		Precision: precision,
+		Fallback:  taken.Format(fallback),
+	}
+}
+
+// dateFormatJS formats <time data-local> elements and capture dates from the API in the
+// viewer's language: ?lang=xx (remembered), else the browser's preferred languages. Dates
+// show the wall-clock time where they were taken, whatever the viewer's time zone.
+const dateFormatJS template.JS = `
+const photoDates = {
+    locale() {
+        const fromURL = new URLSearchParams(location.search).get('lang');
+        if (fromURL) localStorage.setItem('lang', fromURL);
+        const lang = fromURL || localStorage.getItem('lang');
+        const candidates = lang ? [lang] : (navigator.languages || [navigator.language]);
+        try {
+            return Intl.DateTimeFormat.supportedLocalesOf(candidates)[0] || undefined;
+        } catch (e) {
+            return undefined;
+        }
+    },
+    options(precision) {
+        switch (precision) {
+        case 'year': return { year: 'numeric', timeZone: 'UTC' };
+        case 'month': return { year: 'numeric', month: 'long', timeZone: 'UTC' };
+        case 'day': return { dateStyle: 'long', timeZone: 'UTC' };
+        default: return { dateStyle: 'medium', timeStyle: 'short', timeZone: 'UTC' };
+        }
+    },
+    // local is a wall-clock time like 2024-06-01T14:03:00
+    format(local, precision) {
+        const d = new Date(local + 'Z');
+        if (isNaN(d)) return local;
+        return new Intl.DateTimeFormat(this.locale(), this.options(precision)).format(d);
+    },
+    // formatOffset formats an RFC 3339 time from the API at its own offset
+    formatOffset(rfc3339, precision) {
+        const m = /^(\d{4}-\d\d-\d\dT\d\d:\d\d:\d\d)/.exec(rfc3339 || '');
+        return m ? this.format(m[1], precision) : '';
+    },
+    apply(root) {
+        const locale = this.locale();
+        if (locale) document.documentElement.lang = locale;
+        (root || document).querySelectorAll('time[data-local]').forEach(el => {
+            el.textContent = this.format(el.dataset.local, el.dataset.precision);
+        });
+    }
+};
+document.addEventListener('DOMContentLoaded', () => photoDates.apply());
+`
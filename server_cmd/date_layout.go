@@ -0,0 +1,208 @@
+package main
+
+import (
+	"encoding/binary"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// exifScanLimit is how much of a file is searched for its EXIF block; JPEG has it up front,
+// HEIC usually within the first item data
+const exifScanLimit = 1 << 20
+
+// EXIF tags used to find the capture time
+const (
+	exifTagExifIFD            = 0x8769
+	exifTagDateTimeOriginal   = 0x9003
+	exifTagOffsetTimeOriginal = 0x9011
+	exifTypeASCII             = 2
+	exifTypeLong              = 4
+)
+
+// exifDateTimeOriginal returns DateTimeOriginal ("2006:01:02 15:04:05") and, when present,
+// OffsetTimeOriginal ("+02:00") from the EXIF block in data (JPEG APP1 or a HEIC Exif item)
+func exifDateTimeOriginal(data []byte) (string, string, bool) {
+	tiff, bo, ok := exifTIFF(data)
+	if !ok {
+		return "", "", false
+	}
+	exifIFD, ok := exifLong(tiff, bo, bo.Uint32(tiff[4:8]), exifTagExifIFD)
+	if !ok {
+		return "", "", false
+	}
+	taken, ok := exifASCII(tiff, bo, exifIFD, exifTagDateTimeOriginal)
+	if !ok {
+		return "", "", false
+	}
+	offset, _ := exifASCII(tiff, bo, exifIFD, exifTagOffsetTimeOriginal)
+	return taken, offset, true
+}
+
+// exifEntry finds tag in the IFD at off and returns its type, count and 4-byte value field
+func exifEntry(tiff []byte, bo binary.ByteOrder, off uint32, tag uint16) (uint16, uint32, []byte, bool) {
+	if int64(off)+2 > int64(len(tiff)) {
+		return 0, 0, nil, false
+	}
+	n := int(bo.Uint16(tiff[off:]))
+	for i := 0; i < n; i++ {
+		e := int64(off) + 2 + int64(i)*12
+		if e+12 > int64(len(tiff)) {
+			break
+		}
+		if bo.Uint16(tiff[e:]) == tag {
+			return bo.Uint16(tiff[e+2:]), bo.Uint32(tiff[e+4:]), tiff[e+8 : e+12], true
+		}
+	}
+	return 0, 0, nil, false
+}
+
+func exifLong(tiff []byte, bo binary.ByteOrder, ifd uint32, tag uint16) (uint32, bool) {
+	typ, count, value, ok := exifEntry(tiff, bo, ifd, tag)
+	if !ok || typ != exifTypeLong || count != 1 {
+		return 0, false
+	}
+	return bo.Uint32(value), true
+}
+
+func exifASCII(tiff []byte, bo binary.ByteOrder, ifd uint32, tag uint16) (string, bool) {
+	typ, count, value, ok := exifEntry(tiff, bo, ifd, tag)
+	if !ok || typ != exifTypeASCII {
+		return "", false
+	}
+	s := value
+	if count > 4 {
+		off := bo.Uint32(value)
+		if int64(off)+int64(count) > int64(len(tiff)) {
+			return "", false
+		}
+		s = tiff[off : off+count]
+	} else {
+		s = s[:count]
+	}
+	return strings.TrimRight(string(s), "\x00 "), true
+}
+
+// exifCaptureTime returns the EXIF capture time of a photo, placing times without an
+// offset in loc
+func exifCaptureTime(data []byte, loc *time.Location) (time.Time, bool) {
+	taken, offset, ok := exifDateTimeOriginal(data)
+	if !ok {
+		return time.Time{}, false
+	}
+	if offset != "" {
+		if t, err := time.Parse("2006:01:02 15:04:05-07:00", taken+offset); err == nil {
+			return t, true
+		}
+	}
+	t, err := time.ParseInLocation("2006:01:02 15:04:05", taken, loc)
+	if err != nil || t.Year() < 1800 {
+		return time.Time{}, false // "0000:00:00 00:00:00" and other placeholders
+	}
+	return t, true
+}
+
+// readFileHead returns up to exifScanLimit bytes from the start of a file
+func readFileHead(path string) []byte {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	head, _ := io.ReadAll(io.LimitReader(f, exifScanLimit))
+	return head
+}
+
+// datedPath moves a received file's path into <year>/<month>/ of the phone directory when
+// organize_by_date is on. The capture time comes from EXIF DateTimeOriginal, then the
+// client's taken field, then the time of receipt. IDs with their own subdirectories keep
+// them. It also returns the capture time to record when the client sent none.
+func datedPath(config *Config, recvDir, fname string, head []byte, taken string) (string, string) {
+	if config == nil || !config.OrganizeByDate || filepath.Dir(fname) != filepath.Clean(recvDir) {
+		return fname, taken
+	}
+	loc := openCatalog(recvDir).Location()
+	t, ok := exifCaptureTime(head, loc)
+	switch {
+	case ok:
+		if taken == "" {
+			taken = t.Format(time.RFC3339)
+		}
+	case taken != "":
+		var err error
+		if t, _, err = parseCaptureTime(taken, loc); err != nil {
+			log.Printf("Filing %s by receive time: %v", filepath.Base(fname), err)
+			t = clock.Now().In(loc)
+		}
+	default:
+		t = clock.Now().In(loc)
+	}
+	return filepath.Join(recvDir, t.Format("2006"), t.Format("01"), filepath.Base(fname)), taken
+}
+
+// isDateDirName reports whether name is a <year> (digits == 4) or <month> (digits == 2)
+// directory of the organize_by_date layout
+func isDateDirName(name string, digits int) bool {
+	if len(name) != digits {
+		return false
+	}
+	n, err := strconv.Atoi(name)
+	if err != nil {
+		return false
+	}
+	return digits == 4 || (n >= 1 && n <= 12)
+}
+
+// phoneMediaDirs returns the directories holding a phone's originals: the phone directory
+// itself and its <year>/<month> directories, oldest first, then the same for the phone's
+// directory in each storage pool
+func phoneMediaDirs(phoneDir string) []string {
+	dirs := datedMediaDirs(phoneDir)
+	for _, dir := range poolPhoneDirs(phoneDir) {
+		if info, err := os.Stat(dir); err == nil && info.IsDir() {
+			dirs = append(dirs, datedMediaDirs(dir)...)
+		}
+	}
+	return dirs
+}
+
+// datedMediaDirs returns dir and its <year>/<month> directories, oldest first
+func datedMediaDirs(dir string) []string {
+	dirs := []string{dir}
+	years, err := os.ReadDir(dir)
+	if err != nil {
+		return dirs
+	}
+	for _, y := range years {
+		if !y.IsDir() || !isDateDirName(y.Name(), 4) {
+			continue
+		}
+		months, err := os.ReadDir(filepath.Join(dir, y.Name()))
+		if err != nil {
+			continue
+		}
+		for _, m := range months {
+			if m.IsDir() && isDateDirName(m.Name(), 2) {
+				dirs = append(dirs, filepath.Join(dir, y.Name(), m.Name()))
+			}
+		}
+	}
+	return dirs
+}
+
+// isPhoneMediaDir reports whether dir is phoneDir or one of its <year>/<month> directories
+func isPhoneMediaDir(phoneDir, dir string) bool {
+	rel, err := filepath.Rel(phoneDir, dir)
+	if err != nil {
+		return false
+	}
+	if rel == "." {
+		return true
+	}
+	parts := strings.Split(filepath.ToSlash(rel), "/")
+	return len(parts) == 2 && isDateDirName(parts[0], 4) && isDateDirName(parts[1], 2)
+}
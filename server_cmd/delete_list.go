@@ -0,0 +1,216 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	// maxDeletePreviewThumbs bounds the thumbnails sent with a delete preview; further
+	// items are described without one
+	maxDeletePreviewThumbs = 200
+
+	// deletePreviewTTL is how long a preview's confirm token stays valid
+	deletePreviewTTL = 10 * time.Minute
+)
+
+// MediaDelRequest is the msgTypeMediaDelList payload. With preview set the server only
+// describes the items, so the app can show a confirmation screen of the server-side
+// copies, and answers with a confirm token; sending the token back deletes exactly the
+// previewed items. Without either, the items are deleted right away.
+type MediaDelRequest struct {
+	IDs     []string `json:"ids"` // thumbnail list IDs, thumbnail or original names
+	Preview bool     `json:"preview,omitempty"`
+	Confirm string   `json:"confirm,omitempty"`
+}
+
+// MediaDelItem describes one item of a delete preview
+type MediaDelItem struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"` // original, relative to the phone directory
+	Media     string    `json:"media"`
+	Size      int64     `json:"size"`
+	Taken     string    `json:"taken,omitempty"`
+	Favorite  bool      `json:"favorite,omitempty"`
+	Thumbnail string    `json:"thumbnail,omitempty"` // base64 JPEG/PNG, as in MEDIA_THUMB_DATA
+	Exif      *ExifInfo `json:"exif,omitempty"`
+}
+
+// MediaDelResponse is the msgTypeMediaDelAck payload
+type MediaDelResponse struct {
+	Preview  []MediaDelItem `json:"preview,omitempty"`
+	Confirm  string         `json:"confirm,omitempty"`
+	Expires  *time.Time     `json:"expires,omitempty"`
+	Deleted  []string       `json:"deleted,omitempty"`
+	NotFound []string       `json:"notFound,omitempty"`
+	Error    string         `json:"error,omitempty"`
+}
+
+// deletePreview is a preview awaiting confirmation on a connection
+type deletePreview struct {
+	ids     []string
+	expires time.Time
+}
+
+// trashMediaItem moves the original of a thumbnail list ID to the trash, archived ones
+// included, and removes its thumbnail. It returns the original's path.
+func trashMediaItem(phoneDir, id string) (string, bool) {
+	if id == "" || strings.Contains(id, "..") || strings.ContainsAny(id, "/\\") {
+		return "", false
+	}
+	catalog := openCatalog(phoneDir)
+	orig, ok := originalForThumbnail(phoneDir, id)
+	if !ok {
+		key, archived := catalog.ArchivedFile(id)
+		if !archived {
+			return "", false
+		}
+		orig = catalog.Path(key)
+		if err := trashArchived(phoneDir, key); err != nil {
+			log.Printf("Error deleting %s: %v", orig, err)
+			return "", false
+		}
+	} else if err := catalog.MoveToTrash(orig); err != nil {
+		log.Printf("Error deleting %s: %v", orig, err)
+		return "", false
+	}
+	log.Printf("Moved original file to the trash: %s", orig)
+	thumbPath := filepath.Join(phoneDir, "thumbnails", thumbnailName(filepath.Base(orig)))
+	if err := os.Remove(thumbPath); err != nil && !os.IsNotExist(err) {
+		log.Printf("Warning: Failed to delete thumbnail %s: %v", thumbPath, err)
+	}
+	return orig, true
+}
+
+// previewMediaItems describes the items a delete would remove
+func previewMediaItems(ctx context.Context, phoneDir string, ids []string) ([]MediaDelItem, []string) {
+	catalog := openCatalog(phoneDir)
+	var items []MediaDelItem
+	var notFound []string
+	for _, id := range ids {
+		orig, ok, archived := "", false, false
+		if id != "" && !strings.Contains(id, "..") && !strings.ContainsAny(id, "/\\") {
+			orig, ok = originalForThumbnail(phoneDir, id)
+			if !ok {
+				var key string
+				if key, archived = catalog.ArchivedFile(id); archived {
+					orig, ok = catalog.Path(key), true
+				}
+			}
+		}
+		if !ok {
+			notFound = append(notFound, id)
+			continue
+		}
+		item := MediaDelItem{ID: id, Name: catalog.catalogName(orig), Media: strings.TrimPrefix(strings.ToLower(filepath.Ext(orig)), ".")}
+		video := hasExtension(orig, videoExtensions)
+		if video {
+			item.Media = "video"
+		}
+		if archived {
+			// Described from the catalog; the file is in the archive
+			e, _ := catalog.Entry(orig)
+			if !video && !e.Exif.empty() {
+				item.Exif = e.Exif
+			}
+			item.Size = e.Size
+			taken := e.ModTime
+			if e.Taken != nil {
+				taken = *e.Taken
+			}
+			item.Taken = taken.Format(time.RFC3339)
+		} else {
+			if !video {
+				if exif := catalog.Exif(orig); !exif.empty() {
+					item.Exif = exif
+				}
+			}
+			if info, err := os.Stat(orig); err == nil {
+				item.Size = info.Size()
+				item.Taken = catalog.CaptureTime(orig, info).Format(time.RFC3339)
+			}
+		}
+		item.Favorite = catalog.IsFavorite(orig)
+		if len(items) < maxDeletePreviewThumbs {
+			thumbName := thumbnailName(filepath.Base(orig))
+			if ensureThumbnail(ctx, phoneDir, thumbName) {
+				if b, err := os.ReadFile(filepath.Join(phoneDir, "thumbnails", thumbName)); err == nil {
+					item.Thumbnail = base64.StdEncoding.EncodeToString(b)
+				}
+			}
+		}
+		items = append(items, item)
+	}
+	return items, notFound
+}
+
+// handleMediaDelList answers a MEDIA_DEL_LIST request of a connection syncing into
+// phoneDir; previews holds the connection's previews awaiting confirmation
+func handleMediaDelList(phoneDir string, previews map[string]deletePreview, payload []byte) MediaDelResponse {
+	var req MediaDelRequest
+	if err := json.Unmarshal(payload, &req); err != nil {
+		return MediaDelResponse{Error: "invalid request: " + err.Error()}
+	}
+	now := clock.Now()
+	for token, p := range previews {
+		if now.After(p.expires) {
+			delete(previews, token)
+		}
+	}
+
+	ids := req.IDs
+	switch {
+	case req.Preview:
+		if len(ids) == 0 {
+			return MediaDelResponse{Error: "no ids"}
+		}
+		items, notFound := previewMediaItems(context.Background(), phoneDir, ids)
+		resp := MediaDelResponse{Preview: items, NotFound: notFound}
+		if len(items) > 0 {
+			b := make([]byte, 16)
+			rand.Read(b)
+			resp.Confirm = hex.EncodeToString(b)
+			expires := now.Add(deletePreviewTTL)
+			resp.Expires = &expires
+			previewed := make([]string, len(items))
+			for i, item := range items {
+				previewed[i] = item.ID
+			}
+			previews[resp.Confirm] = deletePreview{ids: previewed, expires: expires}
+		}
+		countFeature("delete_preview")
+		return resp
+	case req.Confirm != "":
+		p, ok := previews[req.Confirm]
+		if !ok {
+			return MediaDelResponse{Error: "unknown or expired confirm token, preview again"}
+		}
+		delete(previews, req.Confirm)
+		ids = p.ids
+	case len(ids) == 0:
+		return MediaDelResponse{Error: "no ids"}
+	}
+
+	var resp MediaDelResponse
+	var deleted []string
+	for _, id := range ids {
+		orig, ok := trashMediaItem(phoneDir, id)
+		if !ok {
+			resp.NotFound = append(resp.NotFound, id)
+			continue
+		}
+		resp.Deleted = append(resp.Deleted, id)
+		deleted = append(deleted, orig)
+	}
+	notifyMediaChange(phoneDir, mediaChangeDeleted, deleted...)
+	countFeature("delete_list")
+	return resp
+}
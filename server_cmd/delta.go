@@ -0,0 +1,267 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// DeltaBlock is the signature of one block of the file a client re-sends. Blocks are the
+// chunks of the transfer: block i covers bytes [i*chunkSize, (i+1)*chunkSize).
+type DeltaBlock struct {
+	// Weak is the rsync rolling checksum of the block: with a the sum of its bytes and b
+	// the sum of (len-i)*byte[i], both mod 65536, weak = a | b<<16
+	Weak uint32 `json:"weak"`
+	// Strong is the hex SHA-256 of the block
+	Strong string `json:"strong"`
+}
+
+// DeltaStartRequest is the msgTypeDeltaStart payload: a chunked file start carrying the
+// signatures of every block of the new file
+type DeltaStartRequest struct {
+	ID          string       `json:"id"`
+	Media       string       `json:"media"`
+	TotalSize   int64        `json:"totalSize"`
+	ChunkSize   int          `json:"chunkSize"`
+	TotalChunks int          `json:"totalChunks"`
+	SHA256      string       `json:"sha256"` // of the whole new file, required
+	Taken       string       `json:"taken"`
+	MTime       string       `json:"mtime"`
+	Blocks      []DeltaBlock `json:"blocks"`
+}
+
+// rollingChecksum is the weak checksum of DeltaBlock, updated a byte at a time
+type rollingChecksum struct {
+	a, b uint32
+	n    uint32 // window length
+}
+
+func newRollingChecksum(block []byte) rollingChecksum {
+	r := rollingChecksum{n: uint32(len(block))}
+	for i, c := range block {
+		r.a += uint32(c)
+		r.b += (r.n - uint32(i)) * uint32(c)
+	}
+	return r
+}
+
+func (r rollingChecksum) sum() uint32 {
+	return r.a&0xffff | (r.b&0xffff)<<16
+}
+
+// roll slides the window one byte: out leaves it, in enters it
+func (r *rollingChecksum) roll(out, in byte) {
+	r.a += uint32(in) - uint32(out)
+	r.b += r.a - r.n*uint32(out)
+}
+
+// deltaBasePath finds the stored copy of a re-sent file, where haveMedia would
+func deltaBasePath(recvDir, id, media string) (string, bool) {
+	fname := mediaFileName(recvDir, id, media)
+	for _, dir := range phoneMediaDirs(recvDir) {
+		path := filepath.Join(dir, filepath.Base(fname))
+		if dir == recvDir {
+			path = fname
+		}
+		if info, err := os.Stat(path); err == nil && info.Mode().IsRegular() {
+			return path, true
+		}
+	}
+	return "", false
+}
+
+// matchDeltaBlocks scans the old copy of a file for the blocks of the new one, at any
+// offset, and writes the ones found into the transfer. It returns how many were found.
+func matchDeltaBlocks(info *ChunkedFileInfo, basePath string, blocks []DeltaBlock) (int, error) {
+	f, err := os.Open(basePath)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	stat, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+
+	bs := info.ChunkSize
+	lastLen := bs
+	if info.TotalSize > 0 {
+		lastLen = int(info.TotalSize - int64(info.TotalChunks-1)*int64(bs))
+	}
+	byWeak := make(map[uint32][]int)
+	for i, b := range blocks {
+		if i < len(blocks)-1 || lastLen == bs {
+			byWeak[b.Weak] = append(byWeak[b.Weak], i)
+		}
+	}
+
+	found := 0
+	place := func(data []byte, want func(i int) bool) error {
+		sum := sha256.Sum256(data)
+		strong := hex.EncodeToString(sum[:])
+		for i, b := range blocks {
+			if want(i) && !info.Received.has(i) && strings.EqualFold(b.Strong, strong) {
+				if _, err := info.writeChunk(i, data); err != nil {
+					return err
+				}
+				found++
+			}
+		}
+		return nil
+	}
+
+	// A shorter last block is looked for at its old offset and at the end of the old copy:
+	// edits that don't move the end, and trims at the start
+	if lastLen < bs && lastLen > 0 {
+		last := info.TotalChunks - 1
+		tail := make([]byte, lastLen)
+		for _, off := range []int64{int64(last) * int64(bs), stat.Size() - int64(lastLen)} {
+			if off < 0 || info.Received.has(last) {
+				continue
+			}
+			if _, err := f.ReadAt(tail, off); err == nil {
+				if err := place(tail, func(i int) bool { return i == last }); err != nil {
+					return found, err
+				}
+			}
+		}
+	}
+	if stat.Size() < int64(bs) || len(byWeak) == 0 {
+		return found, nil
+	}
+
+	// Slide a window over the old copy; after a match, jump past it like rsync does
+	r := bufio.NewReaderSize(f, 1<<20)
+	window := make([]byte, bs)
+	block := make([]byte, bs)
+	fill := func() bool {
+		_, err := io.ReadFull(r, window)
+		return err == nil
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return found, err
+	}
+	if !fill() {
+		return found, nil
+	}
+	roll := newRollingChecksum(window)
+	pos := 0 // where the window starts in the ring
+	for {
+		if cands := byWeak[roll.sum()]; len(cands) > 0 {
+			n := copy(block, window[pos:])
+			copy(block[n:], window[:pos])
+			before := found
+			if err := place(block, func(i int) bool {
+				for _, c := range cands {
+					if c == i {
+						return true
+					}
+				}
+				return false
+			}); err != nil {
+				return found, err
+			}
+			if found > before {
+				if found == len(blocks) || !fill() {
+					return found, nil
+				}
+				roll, pos = newRollingChecksum(window), 0
+				continue
+			}
+		}
+		in, err := r.ReadByte()
+		if err != nil {
+			return found, nil
+		}
+		out := window[pos]
+		window[pos] = in
+		pos = (pos + 1) % bs
+		roll.roll(out, in)
+	}
+}
+
+// startDeltaTransfer opens a chunked transfer for a re-sent file and fills in the blocks
+// its old copy on the server already has. The start ACK lists every chunk still to send;
+// the client sends those as CHUNKED_VIDEO_DATA and completes the transfer as usual.
+func startDeltaTransfer(config *Config, recvDir string, payload []byte) (*ChunkedFileInfo, Ack) {
+	var req DeltaStartRequest
+	if err := json.Unmarshal(payload, &req); err != nil {
+		return nil, errorAck(ackKindStart, "", ackCodeDecode, err)
+	}
+	if err := validateChunkedStart(req.TotalSize, req.ChunkSize, req.TotalChunks); err != nil {
+		return nil, errorAck(ackKindStart, req.ID, ackCodeInvalid, err)
+	}
+	if err := validateClientID(req.ID); err != nil {
+		return nil, errorAck(ackKindStart, req.ID, ackCodeInvalid, err)
+	}
+	if req.ID == "" || req.TotalSize <= 0 || req.SHA256 == "" || len(req.Blocks) != req.TotalChunks {
+		err := fmt.Errorf("a delta start needs id, totalSize, sha256 and one block signature per chunk")
+		return nil, errorAck(ackKindStart, req.ID, ackCodeInvalid, err)
+	}
+
+	basePath, haveBase := deltaBasePath(recvDir, req.ID, req.Media)
+	var baseSize int64
+	if haveBase {
+		if info, err := os.Stat(basePath); err == nil {
+			baseSize = info.Size()
+		}
+	}
+	// The new copy replaces the old one, so only growth counts against the quota
+	if ack, ok := admitUpload(config, recvDir, ackKindStart, req.ID, max(0, req.TotalSize-baseSize)); !ok {
+		return nil, ack
+	}
+
+	tmpFile, err := os.CreateTemp(recvDir, fmt.Sprintf(".chunked_%s_*.tmp",
+		strings.ReplaceAll(req.ID, string(filepath.Separator), "_")))
+	if err != nil {
+		return nil, errorAck(ackKindStart, req.ID, writeErrorCode(err), err)
+	}
+	if err := reserveChunkedFile(tmpFile, recvDir, req.TotalSize); err != nil {
+		tmpFile.Close()
+		os.Remove(tmpFile.Name())
+		return nil, errorAck(ackKindStart, req.ID, writeErrorCode(err), err)
+	}
+	info := &ChunkedFileInfo{
+		ID:           req.ID,
+		TotalSize:    req.TotalSize,
+		ChunkSize:    req.ChunkSize,
+		TotalChunks:  req.TotalChunks,
+		Received:     newChunkBitmap(req.TotalChunks),
+		TempFilePath: tmpFile.Name(),
+		TempFile:     tmpFile,
+		Media:        req.Media,
+		RecvDir:      recvDir,
+		SHA256:       strings.ToLower(req.SHA256),
+		Taken:        req.Taken,
+		MTime:        req.MTime,
+		LastActivity: clock.Now(),
+	}
+
+	if haveBase {
+		started := time.Now()
+		found, err := matchDeltaBlocks(info, basePath, req.Blocks)
+		if err != nil {
+			info.TempFile.Close()
+			os.Remove(info.TempFilePath)
+			return nil, errorAck(ackKindStart, req.ID, writeErrorCode(err), err)
+		}
+		log.Printf("Delta for %s: %d/%d blocks reused from %s in %v, %d to send\n",
+			req.ID, found, req.TotalChunks, basePath, time.Since(started).Round(time.Millisecond), req.TotalChunks-found)
+	} else {
+		log.Printf("Delta for %s: no stored copy, all %d blocks to send\n", req.ID, req.TotalChunks)
+	}
+	countFeature("delta_upload")
+
+	ack := okAck(ackKindStart, req.ID)
+	ack.Resumed = info.ReceivedChunks
+	ack.Missing = info.missingChunks(info.TotalChunks)
+	return info, ack
+}
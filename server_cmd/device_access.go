@@ -0,0 +1,294 @@
+package main
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// Device approval states. Records without a status were registered before approval
+// was required and count as approved.
+const (
+	deviceApproved = "approved"
+	devicePending  = "pending"
+	deviceBlocked  = "blocked"
+)
+
+// nameDevicePrefix keys the registry records of clients that only send SET_PHONE_NAME
+const nameDevicePrefix = "name:"
+
+// DevicesConfig restricts syncing to approved devices. Devices that aren't allowed here
+// wait as pending until the admin approves (or blocks) them on the devices page; nothing
+// is written for them until then.
+//
+// With Pairing set, the home page also shows a rotating PIN and QR code; a phone that
+// presents one is approved at once and gets a device token it must send from then on.
+type DevicesConfig struct {
+	Allowed []AllowedDevice `json:"allowed"`
+	Pairing bool            `json:"pairing"`
+}
+
+// AllowedDevice pre-approves a device by its ID, its phone name or a pairing token the app sends
+type AllowedDevice struct {
+	ID    string `json:"id,omitempty"`
+	Name  string `json:"name,omitempty"`
+	Token string `json:"token,omitempty"`
+}
+
+// allows reports whether the allowlist pre-approves the device
+func (dc *DevicesConfig) allows(id, name, token string) bool {
+	for _, a := range dc.Allowed {
+		if (a.ID != "" && a.ID == id) || (a.Name != "" && a.Name == name) || (a.Token != "" && a.Token == token) {
+			return true
+		}
+	}
+	return false
+}
+
+// deviceAccess returns the approval state of a device, recording devices seen for the
+// first time as pending (or approved when allowlisted). Without a devices section in the
+// config every device is approved. Clients without a device ID pass nameDevicePrefix+name.
+func deviceAccess(config *Config, baseDir, id, name, token string) (string, error) {
+	if config == nil || config.Devices == nil {
+		return deviceApproved, nil
+	}
+	if !strings.HasPrefix(id, nameDevicePrefix) && !validDeviceID(id) {
+		return "", fmt.Errorf("invalid device id %q", id)
+	}
+	allowed := config.Devices.allows(id, name, token)
+
+	devicesMutex.Lock()
+	defer devicesMutex.Unlock()
+
+	devices, err := loadDevices(baseDir)
+	if err != nil {
+		return "", err
+	}
+	rec, ok := devices[id]
+	if ok && rec.TokenHash != "" && subtle.ConstantTimeCompare([]byte(hashDeviceToken(token)), []byte(rec.TokenHash)) != 1 {
+		// A paired device proves its identity with the token; the ID alone could be copied
+		return "", fmt.Errorf("device token missing or wrong, pair the device again")
+	}
+	if ok && rec.Status == "" {
+		return deviceApproved, nil
+	}
+	if ok && (rec.Status != devicePending || !allowed) {
+		return rec.Status, nil
+	}
+
+	// New, or pending and allowlisted since
+	if !ok {
+		now := clock.Now()
+		rec = &DeviceRecord{ID: id, Name: name, FirstSeen: now, LastSeen: now}
+		if strings.HasPrefix(id, nameDevicePrefix) {
+			rec.Dir = name
+		}
+		devices[id] = rec
+	}
+	rec.Status = devicePending
+	if allowed {
+		rec.Status = deviceApproved
+	} else {
+		log.Printf("Device %s (%s) is waiting for approval on the devices page", id, name)
+	}
+	if err := os.MkdirAll(baseDir, 0o755); err != nil {
+		return "", err
+	}
+	return rec.Status, saveDevices(baseDir, devices)
+}
+
+// requestDevice returns the ID of the device an API request comes from, by its X-Device-Id
+// and X-Device-Token headers. Unlike a sync, it never registers anything: the device must
+// have synced before, be approved, and send its token once paired, so a caller can only
+// act as itself.
+func requestDevice(config *Config, baseDir string, r *http.Request) (string, error) {
+	id := r.Header.Get("X-Device-Id")
+	if !validDeviceID(id) {
+		return "", fmt.Errorf("X-Device-Id header missing or invalid")
+	}
+	token := r.Header.Get("X-Device-Token")
+
+	devicesMutex.Lock()
+	devices, err := loadDevices(baseDir)
+	devicesMutex.Unlock()
+	if err != nil {
+		return "", err
+	}
+	rec, ok := devices[id]
+	if !ok {
+		return "", fmt.Errorf("unknown device %q, sync it first", id)
+	}
+	if rec.TokenHash != "" && subtle.ConstantTimeCompare([]byte(hashDeviceToken(token)), []byte(rec.TokenHash)) != 1 {
+		return "", fmt.Errorf("device token missing or wrong, pair the device again")
+	}
+	status, err := deviceAccess(config, baseDir, id, rec.Name, token)
+	if err != nil {
+		return "", err
+	}
+	if status != deviceApproved {
+		return "", fmt.Errorf("device is %s, see the server's devices page", status)
+	}
+	return id, nil
+}
+
+// setDeviceStatus approves or blocks a device; forget removes its record instead
+func setDeviceStatus(baseDir, id, status string, forget bool) error {
+	devicesMutex.Lock()
+	defer devicesMutex.Unlock()
+
+	devices, err := loadDevices(baseDir)
+	if err != nil {
+		return err
+	}
+	rec, ok := devices[id]
+	if !ok {
+		return fmt.Errorf("unknown device %q", id)
+	}
+	if forget {
+		delete(devices, id)
+	} else {
+		rec.Status = status
+	}
+	return saveDevices(baseDir, devices)
+}
+
+// registerDeviceRoutes adds the device management page and its API to the router
+func registerDeviceRoutes(router *mux.Router, config *Config) {
+	baseDirFor := func() string {
+		if config.ReceiveDir == "" {
+			return "received"
+		}
+		return config.ReceiveDir
+	}
+
+	for _, action := range []string{"approve", "block", "forget"} {
+		action := action
+		router.HandleFunc("/api/devices/{id}/"+action, func(w http.ResponseWriter, r *http.Request) {
+			id := mux.Vars(r)["id"]
+			status := deviceApproved
+			if action == "block" {
+				status = deviceBlocked
+			}
+			if err := setDeviceStatus(baseDirFor(), id, status, action == "forget"); err != nil {
+				writeJSON(w, map[string]interface{}{"success": false, "error": err.Error()})
+				return
+			}
+			log.Printf("Device %s: %s", id, action)
+			writeJSON(w, map[string]interface{}{"success": true})
+		}).Methods("POST")
+	}
+
+	router.HandleFunc("/devices", func(w http.ResponseWriter, r *http.Request) {
+		devicesMutex.Lock()
+		devices, err := loadDevices(baseDirFor())
+		devicesMutex.Unlock()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		type deviceRow struct {
+			*DeviceRecord
+			State string
+		}
+		rows := make([]deviceRow, 0, len(devices))
+		for _, rec := range devices {
+			state := rec.Status
+			if state == "" {
+				state = deviceApproved
+			}
+			rows = append(rows, deviceRow{DeviceRecord: rec, State: state})
+		}
+		// Pending first, then most recently seen
+		sort.Slice(rows, func(i, j int) bool {
+			if (rows[i].State == devicePending) != (rows[j].State == devicePending) {
+				return rows[i].State == devicePending
+			}
+			return rows[i].LastSeen.After(rows[j].LastSeen)
+		})
+
+		tmpl := `<!DOCTYPE html>
+<html>
+<head>
+    <title>Devices - Photo Sync Server</title>
+    <style>
+        body { font-family: 'Segoe UI', Tahoma, Arial, sans-serif; margin: 0; padding: 20px; background: #000000; color: #ffffff; }
+        h1 { color: #ffffff; font-weight: 300; letter-spacing: 1px; }
+        .back-link { display: inline-block; margin-bottom: 20px; color: #88aaff; text-decoration: none; font-size: 14px; }
+        .back-link:hover { color: #aaccff; text-decoration: underline; }
+        .note { color: #888888; font-size: 13px; max-width: 800px; }
+        table { border-collapse: collapse; width: 100%; max-width: 1200px; font-size: 13px; }
+        th, td { text-align: left; padding: 8px; border-bottom: 1px solid #2a2a2a; vertical-align: middle; }
+        th { color: #888888; font-weight: normal; }
+        .state { font-weight: 600; text-transform: uppercase; font-size: 11px; }
+        .state-pending { color: #fbbf24; }
+        .state-approved { color: #4ade80; }
+        .state-blocked { color: #ff6b6b; }
+        button { background: #1a1a1a; color: #ffffff; border: 1px solid #333333; border-radius: 4px; padding: 5px 12px; cursor: pointer; font-size: 12px; }
+        button:hover { background: #2a2a2a; }
+        .id { color: #888888; font-family: monospace; font-size: 12px; }
+    </style>
+</head>
+<body>
+    <a href="/" class="back-link">← Back to Home</a>
+    <h1>📱 Devices</h1>
+    {{if not .Enforced}}<p class="note">Any device may sync: add a "devices" section to the config to require approval.</p>{{end}}
+    {{if .Rows}}
+    <table>
+        <tr><th>Device</th><th>Folder</th><th>State</th><th>First seen</th><th>Last seen</th><th></th></tr>
+        {{range .Rows}}
+        <tr>
+            <td>{{.Name}}<div class="id">{{.ID}}</div></td>
+            <td>{{.Dir}}</td>
+            <td class="state state-{{.State}}">{{.State}}{{if .TokenHash}} <span title="Paired with a PIN or QR code">🔑</span>{{end}}</td>
+            <td>{{.FirstSeen.Format "2006-01-02 15:04"}}</td>
+            <td>{{.LastSeen.Format "2006-01-02 15:04"}}</td>
+            <td>
+                {{if ne .State "approved"}}<button onclick="deviceAction('{{.ID}}', 'approve')">Approve</button>{{end}}
+                {{if ne .State "blocked"}}<button onclick="deviceAction('{{.ID}}', 'block')">Block</button>{{end}}
+                <button onclick="deviceAction('{{.ID}}', 'forget')">Forget</button>
+            </td>
+        </tr>
+        {{end}}
+    </table>
+    {{else}}
+    <p>No devices yet.</p>
+    {{end}}
+    <script>
+        function deviceAction(id, action) {
+            if (action !== 'approve' && !confirm('Really ' + action + ' this device?')) {
+                return;
+            }
+            fetch('/api/devices/' + encodeURIComponent(id) + '/' + action, { method: 'POST' })
+            .then(response => response.json())
+            .then(data => {
+                if (data.success) {
+                    location.reload();
+                } else {
+                    alert('Error: ' + data.error);
+                }
+            })
+            .catch(err => alert('Error: ' + err));
+        }
+    </script>
+</body>
+</html>`
+
+		t := template.Must(template.New("devices").Parse(tmpl))
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		data := struct {
+			Rows     []deviceRow
+			Enforced bool
+		}{rows, config.Devices != nil}
+		if err := t.Execute(w, data); err != nil {
+			log.Printf("Error rendering devices page: %v", err)
+		}
+	}).Methods("GET")
+}
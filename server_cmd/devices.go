@@ -0,0 +1,162 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// devicesFileName is the registry of known client devices, stored in the base receive dir
+const devicesFileName = ".devices.json"
+
+// devicesMutex serializes reads and writes of the device registry file
+var devicesMutex sync.Mutex
+
+// DeviceRecord describes a registered client device. The storage directory is
+// fixed at first registration, so later display name changes don't move the library.
+type DeviceRecord struct {
+	ID        string    `json:"id"`   // stable client identity (UUID or public key fingerprint)
+	Name      string    `json:"name"` // current display name reported by the phone
+	Dir       string    `json:"dir"`  // subdirectory under the receive dir
+	FirstSeen time.Time `json:"first_seen"`
+	LastSeen  time.Time `json:"last_seen"`
+	Status    string    `json:"status,omitempty"`     // approval state when the config requires approval, see device_access.go
+	TokenHash string    `json:"token_hash,omitempty"` // SHA-256 of the device token issued when pairing, see pairing.go
+}
+
+// validDeviceID reports whether id is usable as a device identity
+func validDeviceID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '-' || c == '_' || c == ':' || c == '.':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// maxDeviceDirName leaves room in a file name for the suffixes registerDevice adds
+const maxDeviceDirName = 200
+
+// deviceDirName turns a display name into a safe single-level directory name. A name
+// validatePhoneName still rejects, such as a preset folder ("music") or a Windows device
+// name ("CON"), gets the start of the device ID appended, or is replaced by it.
+func deviceDirName(name, id string) string {
+	short := id
+	if len(short) > 8 {
+		short = short[:8]
+	}
+	short = strings.NewReplacer(":", "_", ".", "_").Replace(short)
+	fallback := "device-" + short
+
+	name = strings.TrimSpace(name)
+	name = strings.Map(func(r rune) rune {
+		if r == '/' || r == '\\' || r == ':' || r < 0x20 || r == 0x7f {
+			return '_'
+		}
+		return r
+	}, name)
+	if len(name) > maxDeviceDirName {
+		name = strings.ToValidUTF8(name[:maxDeviceDirName], "")
+	}
+	name = strings.Trim(name, ". ")
+	if name == "" {
+		return fallback
+	}
+	if validatePhoneName(name) != nil {
+		name += "-" + short
+	}
+	if validatePhoneName(name) != nil {
+		return fallback
+	}
+	return name
+}
+
+func loadDevices(baseDir string) (map[string]*DeviceRecord, error) {
+	devices := make(map[string]*DeviceRecord)
+	b, err := os.ReadFile(filepath.Join(baseDir, devicesFileName))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return devices, nil
+		}
+		return nil, fmt.Errorf("read device registry: %w", err)
+	}
+	if err := json.Unmarshal(b, &devices); err != nil {
+		return nil, fmt.Errorf("parse device registry: %w", err)
+	}
+	return devices, nil
+}
+
+func saveDevices(baseDir string, devices map[string]*DeviceRecord) error {
+	b, err := json.MarshalIndent(devices, "", "  ")
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(baseDir, devicesFileName)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o644); err != nil {
+		return fmt.Errorf("write device registry: %w", err)
+	}
+	return os.Rename(tmp, path)
+}
+
+// registerDevice looks up the device by its stable ID, creating a record on first
+// contact. A new device claims the directory named after its display name if no other
+// device owns it (this keeps legacy SET_PHONE_NAME folders), otherwise a suffix is added.
+// Known devices keep their directory and only have the display name refreshed.
+func registerDevice(baseDir, id, name string) (*DeviceRecord, error) {
+	if !validDeviceID(id) {
+		return nil, fmt.Errorf("invalid device id %q", id)
+	}
+
+	devicesMutex.Lock()
+	defer devicesMutex.Unlock()
+
+	if err := os.MkdirAll(baseDir, 0o755); err != nil {
+		return nil, fmt.Errorf("create receive dir: %w", err)
+	}
+
+	devices, err := loadDevices(baseDir)
+	if err != nil {
+		return nil, err
+	}
+
+	now := clock.Now()
+	rec, ok := devices[id]
+	if ok && rec.Dir != "" {
+		if name != "" {
+			rec.Name = name
+		}
+		rec.LastSeen = now
+		return rec, saveDevices(baseDir, devices)
+	}
+
+	// Devices known only from an approval request get their directory now
+	owned := make(map[string]bool)
+	for _, other := range devices {
+		if !strings.HasPrefix(other.ID, nameDevicePrefix) {
+			// Case-insensitive file systems would merge "Pixel" and "pixel"
+			owned[strings.ToLower(other.Dir)] = true
+		}
+	}
+	dir := deviceDirName(name, id)
+	for i := 2; owned[strings.ToLower(dir)]; i++ {
+		dir = fmt.Sprintf("%s-%d", deviceDirName(name, id), i)
+	}
+
+	if !ok {
+		rec = &DeviceRecord{ID: id, FirstSeen: now}
+		devices[id] = rec
+	}
+	rec.Name, rec.Dir, rec.LastSeen = name, dir, now
+	return rec, saveDevices(baseDir, devices)
+}
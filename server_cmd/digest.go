@@ -0,0 +1,688 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"log"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/http"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	// digestCheckInterval is how often the scheduler looks whether a digest is due
+	digestCheckInterval = time.Hour
+	// digestStateFileName keeps when each digest was sent and the library size samples
+	// of the storage trend in the receive directory
+	digestStateFileName = ".digest.json"
+	// digestPeriod is what a digest covers, and about how often one is sent
+	digestPeriod = 7 * 24 * time.Hour
+	// digestTrendWeeks is how far back the storage trend goes
+	digestTrendWeeks = 8
+
+	defaultDigestThumbnails = 6
+	maxDigestThumbnails     = 20
+	defaultDigestHour       = 8
+	digestSMTPTimeout       = 2 * time.Minute
+)
+
+// DigestConfig mails a weekly digest per phone: what it synced, a few of the new photos
+// inline, how the phone's storage grew and links into the gallery. Each recipient picks
+// the phones, the day and the hour of their digests.
+type DigestConfig struct {
+	SMTPHost   string            `json:"smtp_host"`
+	SMTPPort   int               `json:"smtp_port"` // default 587 (STARTTLS when offered); 465 for TLS from the start
+	Username   string            `json:"username"`  // SMTP login, none when empty
+	Password   string            `json:"password"`
+	From       string            `json:"from"`
+	BaseURL    string            `json:"base_url"` // how recipients reach the web UI, for the links (default http://<hostname>:<http_port>)
+	Recipients []DigestRecipient `json:"recipients"`
+}
+
+// DigestRecipient is one person getting digests
+type DigestRecipient struct {
+	Email      string   `json:"email"`
+	Phones     []string `json:"phones"`     // phones to send digests of, all when empty
+	Weekday    string   `json:"weekday"`    // day to send on, default monday
+	Hour       *int     `json:"hour"`       // local hour to send from, default 8
+	Thumbnails *int     `json:"thumbnails"` // inline thumbnails per digest, default 6, 0 for none
+	SkipEmpty  bool     `json:"skip_empty"` // no digest for a week without anything synced
+}
+
+// digestState is the digest scheduler's memory across restarts
+type digestState struct {
+	Sent  map[string]time.Time    `json:"sent"`  // recipient email + "/" + phone -> last digest
+	Sizes map[string][]digestSize `json:"sizes"` // phone -> daily library size samples, oldest first
+}
+
+type digestSize struct {
+	Date  time.Time `json:"date"`
+	Bytes int64     `json:"bytes"`
+}
+
+// phoneDigest is what one phone's digest says
+type phoneDigest struct {
+	ServerName   string
+	Phone        string
+	Since, Until time.Time
+	Photos       int
+	Videos       int
+	Bytes        int64
+	Total        int64 // library size now
+	Change       int64 // since a week ago
+	HasChange    bool  // whether a size a week ago is known
+	Days         []digestDay
+	Weeks        []digestWeek
+	Thumbs       []digestThumb
+	GalleryURL   string
+	RecentURL    string
+}
+
+// digestDay is one day of the week with something synced, newest first like a feed
+type digestDay struct {
+	Label  string
+	Photos int
+	Videos int
+}
+
+// digestWeek is one bar of the storage trend
+type digestWeek struct {
+	Label   string
+	Bytes   int64
+	Percent int // of the largest week, for the bar height
+}
+
+// digestThumb is an inline thumbnail: its file, and how the HTML refers to it
+type digestThumb struct {
+	path string
+	CID  string
+	Src  template.URL
+	Link string
+}
+
+var (
+	digestMutex sync.Mutex // guards the state file, between the scheduler and send-now
+	digestDays  = map[string]time.Weekday{
+		"sunday": time.Sunday, "monday": time.Monday, "tuesday": time.Tuesday, "wednesday": time.Wednesday,
+		"thursday": time.Thursday, "friday": time.Friday, "saturday": time.Saturday,
+	}
+)
+
+// checkDigest validates the digest settings
+func checkDigest(config *Config) error {
+	dc := config.Digest
+	if dc == nil {
+		return nil
+	}
+	if dc.SMTPHost == "" {
+		return fmt.Errorf("digest needs smtp_host")
+	}
+	if _, err := mail.ParseAddress(dc.From); err != nil {
+		return fmt.Errorf("digest from %q: %v", dc.From, err)
+	}
+	if dc.BaseURL != "" {
+		if u, err := url.Parse(dc.BaseURL); err != nil || u.Scheme == "" || u.Host == "" {
+			return fmt.Errorf("digest base_url %q is not an absolute URL", dc.BaseURL)
+		}
+	}
+	if len(dc.Recipients) == 0 {
+		return fmt.Errorf("digest has no recipients")
+	}
+	for _, r := range dc.Recipients {
+		if _, err := mail.ParseAddress(r.Email); err != nil {
+			return fmt.Errorf("digest recipient %q: %v", r.Email, err)
+		}
+		if _, ok := digestDays[strings.ToLower(r.Weekday)]; r.Weekday != "" && !ok {
+			return fmt.Errorf("digest recipient %s: unknown weekday %q", r.Email, r.Weekday)
+		}
+		if r.Hour != nil && (*r.Hour < 0 || *r.Hour > 23) {
+			return fmt.Errorf("digest recipient %s: hour %d is not between 0 and 23", r.Email, *r.Hour)
+		}
+		if r.Thumbnails != nil && (*r.Thumbnails < 0 || *r.Thumbnails > maxDigestThumbnails) {
+			return fmt.Errorf("digest recipient %s: thumbnails %d is not between 0 and %d", r.Email, *r.Thumbnails, maxDigestThumbnails)
+		}
+		for _, phone := range r.Phones {
+			if validatePhoneName(phone) != nil {
+				return fmt.Errorf("digest recipient %s: invalid phone %q", r.Email, phone)
+			}
+		}
+	}
+	return nil
+}
+
+func loadDigestState(baseDir string) *digestState {
+	state := &digestState{Sent: make(map[string]time.Time), Sizes: make(map[string][]digestSize)}
+	b, err := os.ReadFile(filepath.Join(baseDir, digestStateFileName))
+	if err != nil {
+		return state
+	}
+	if err := json.Unmarshal(b, state); err != nil {
+		log.Printf("Warning: ignoring %s: %v", digestStateFileName, err)
+		return &digestState{Sent: make(map[string]time.Time), Sizes: make(map[string][]digestSize)}
+	}
+	if state.Sent == nil {
+		state.Sent = make(map[string]time.Time)
+	}
+	if state.Sizes == nil {
+		state.Sizes = make(map[string][]digestSize)
+	}
+	return state
+}
+
+func saveDigestState(baseDir string, state *digestState) error {
+	b, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(baseDir, digestStateFileName), b)
+}
+
+// sampleSizes records each phone's library size once a day, for the storage trend
+func (s *digestState) sampleSizes(baseDir string) {
+	now := clock.Now()
+	for _, phone := range libraryPhones(baseDir) {
+		samples := s.Sizes[phone]
+		if n := len(samples); n > 0 && now.Sub(samples[n-1].Date) < 23*time.Hour {
+			continue
+		}
+		samples = append(samples, digestSize{Date: now, Bytes: openCatalog(filepath.Join(baseDir, phone)).UsedBytes()})
+		// Keep a little more than the trend shows, so its oldest week has a sample
+		cutoff := now.Add(-time.Duration(digestTrendWeeks+1) * digestPeriod)
+		for len(samples) > 0 && samples[0].Date.Before(cutoff) {
+			samples = samples[1:]
+		}
+		s.Sizes[phone] = samples
+	}
+}
+
+// sizeAt returns the phone's library size sampled last at or before t
+func (s *digestState) sizeAt(phone string, t time.Time) (int64, bool) {
+	var size int64
+	found := false
+	for _, sample := range s.Sizes[phone] {
+		if sample.Date.After(t) {
+			break
+		}
+		size, found = sample.Bytes, true
+	}
+	return size, found
+}
+
+// digestBaseURL is where the digest's links point: base_url, else this machine
+func digestBaseURL(config *Config) string {
+	if config.Digest != nil && config.Digest.BaseURL != "" {
+		return strings.TrimSuffix(config.Digest.BaseURL, "/")
+	}
+	host, err := os.Hostname()
+	if err != nil {
+		host = "localhost"
+	}
+	return "http://" + net.JoinHostPort(host, config.HttpPort)
+}
+
+// collectPhoneDigest gathers what a phone synced in the week up to until, picking up to
+// thumbs of the new photos spread over the week
+func collectPhoneDigest(config *Config, baseDir, phone string, state *digestState, until time.Time, thumbs int) *phoneDigest {
+	phoneDir := filepath.Join(baseDir, phone)
+	catalog := openCatalog(phoneDir)
+	d := &phoneDigest{ServerName: config.ServerName, Phone: phone, Since: until.Add(-digestPeriod), Until: until,
+		Total: catalog.UsedBytes()}
+	base := digestBaseURL(config)
+	d.GalleryURL = base + "/phone/" + url.PathEscape(phone)
+	d.RecentURL = d.GalleryURL + "?view=recent"
+
+	var photos []CatalogEntry
+	days := make(map[string]*digestDay) // by date
+	for _, e := range catalog.AllEntries() {
+		added := entryAdded(&e)
+		if e.Created || added.Before(d.Since) || !added.Before(until) {
+			continue
+		}
+		date := added.Format("2006-01-02")
+		day, ok := days[date]
+		if !ok {
+			day = &digestDay{Label: added.Format("Monday, January 2")}
+			days[date] = day
+		}
+		switch {
+		case hasExtension(e.Name, photoExtensions):
+			d.Photos++
+			day.Photos++
+			photos = append(photos, e)
+		case hasExtension(e.Name, videoExtensions):
+			d.Videos++
+			day.Videos++
+		default:
+			continue
+		}
+		d.Bytes += e.Size
+	}
+	dates := make([]string, 0, len(days))
+	for date := range days {
+		dates = append(dates, date)
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(dates)))
+	for _, date := range dates {
+		if day := days[date]; day.Photos+day.Videos > 0 {
+			d.Days = append(d.Days, *day)
+		}
+	}
+
+	// The week's photos in the order they were taken, sampled evenly so one burst
+	// doesn't fill the digest
+	sort.Slice(photos, func(i, j int) bool { return entryTaken(&photos[i]).Before(entryTaken(&photos[j])) })
+	thumbDir := filepath.Join(phoneDir, "thumbnails")
+	for i := 0; i < thumbs && i < len(photos); i++ {
+		e := photos[i*len(photos)/min(thumbs, len(photos))]
+		name := thumbnailName(path.Base(e.Name))
+		if !ensureThumbnail(context.Background(), phoneDir, name) {
+			continue
+		}
+		p := filepath.Join(thumbDir, name)
+		cid := fmt.Sprintf("thumb%d@photo-sync", i)
+		d.Thumbs = append(d.Thumbs, digestThumb{path: p, CID: cid, Src: template.URL("cid:" + cid),
+			Link: d.GalleryURL})
+	}
+
+	if size, ok := state.sizeAt(phone, until.Add(-digestPeriod)); ok {
+		d.Change, d.HasChange = d.Total-size, true
+	}
+	var largest int64
+	for w := digestTrendWeeks - 1; w >= 0; w-- {
+		at := until.Add(-time.Duration(w) * digestPeriod)
+		size, ok := state.sizeAt(phone, at)
+		if w == 0 {
+			size, ok = d.Total, true
+		}
+		if !ok {
+			continue
+		}
+		d.Weeks = append(d.Weeks, digestWeek{Label: at.Format("Jan 2"), Bytes: size})
+		largest = max(largest, size)
+	}
+	for i := range d.Weeks {
+		if largest > 0 {
+			d.Weeks[i].Percent = max(2, int(d.Weeks[i].Bytes*100/largest))
+		}
+	}
+	return d
+}
+
+// entryTaken is when a file was taken, or its modification time without a capture time
+func entryTaken(e *CatalogEntry) time.Time {
+	if e.Taken != nil {
+		return *e.Taken
+	}
+	return e.ModTime
+}
+
+// Subject is the digest email's subject line
+func (d *phoneDigest) Subject() string {
+	if d.Photos+d.Videos == 0 {
+		return fmt.Sprintf("%s: nothing new from %s this week", d.ServerName, d.Phone)
+	}
+	return fmt.Sprintf("%s: %s synced %d photo(s) and %d video(s) this week", d.ServerName, d.Phone, d.Photos, d.Videos)
+}
+
+// buildDigestMail renders a digest as a MIME message, the thumbnails attached inline
+func buildDigestMail(from, to string, d *phoneDigest) ([]byte, error) {
+	var html bytes.Buffer
+	if err := digestTemplate.Execute(&html, d); err != nil {
+		return nil, err
+	}
+
+	var msg bytes.Buffer
+	related := multipart.NewWriter(&msg)
+	// The message headers go before the parts, with the writer's boundary
+	var head bytes.Buffer
+	fmt.Fprintf(&head, "From: %s\r\nTo: %s\r\nSubject: %s\r\nDate: %s\r\nMIME-Version: 1.0\r\n",
+		from, to, mime.QEncoding.Encode("utf-8", d.Subject()), clock.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&head, "Content-Type: multipart/related; type=\"text/html\"; boundary=%s\r\n\r\n", related.Boundary())
+
+	part, err := related.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {"text/html; charset=utf-8"},
+		"Content-Transfer-Encoding": {"quoted-printable"},
+	})
+	if err != nil {
+		return nil, err
+	}
+	qp := quotedprintable.NewWriter(part)
+	qp.Write(html.Bytes())
+	qp.Close()
+
+	for _, t := range d.Thumbs {
+		data, err := os.ReadFile(t.path)
+		if err != nil {
+			return nil, err
+		}
+		contentType := "image/jpeg"
+		if strings.EqualFold(filepath.Ext(t.path), ".png") {
+			contentType = "image/png"
+		}
+		part, err := related.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {contentType},
+			"Content-Transfer-Encoding": {"base64"},
+			"Content-ID":                {"<" + t.CID + ">"},
+			"Content-Disposition":       {"inline; filename=\"" + filepath.Base(t.path) + "\""},
+		})
+		if err != nil {
+			return nil, err
+		}
+		encoded := base64.StdEncoding.EncodeToString(data)
+		for len(encoded) > 76 {
+			part.Write([]byte(encoded[:76] + "\r\n"))
+			encoded = encoded[76:]
+		}
+		part.Write([]byte(encoded + "\r\n"))
+	}
+	if err := related.Close(); err != nil {
+		return nil, err
+	}
+	return append(head.Bytes(), msg.Bytes()...), nil
+}
+
+// sendMail delivers a message through the configured SMTP server: TLS from the start on
+// port 465, else STARTTLS when the server offers it
+func sendMail(dc *DigestConfig, to string, msg []byte) error {
+	port := dc.SMTPPort
+	if port == 0 {
+		port = 587
+	}
+	addr := net.JoinHostPort(dc.SMTPHost, strconv.Itoa(port))
+	tlsConfig := &tls.Config{ServerName: dc.SMTPHost}
+	var conn net.Conn
+	var err error
+	dialer := &net.Dialer{Timeout: 30 * time.Second}
+	if port == 465 {
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, tlsConfig)
+	} else {
+		conn, err = dialer.Dial("tcp", addr)
+	}
+	if err != nil {
+		return err
+	}
+	conn.SetDeadline(clock.Now().Add(digestSMTPTimeout))
+	c, err := smtp.NewClient(conn, dc.SMTPHost)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+	if ok, _ := c.Extension("STARTTLS"); ok && port != 465 {
+		if err := c.StartTLS(tlsConfig); err != nil {
+			return err
+		}
+	}
+	if dc.Username != "" {
+		if err := c.Auth(smtp.PlainAuth("", dc.Username, dc.Password, dc.SMTPHost)); err != nil {
+			return err
+		}
+	}
+	from, _ := mail.ParseAddress(dc.From)
+	rcpt, err := mail.ParseAddress(to)
+	if err != nil {
+		return err
+	}
+	if err := c.Mail(from.Address); err != nil {
+		return err
+	}
+	if err := c.Rcpt(rcpt.Address); err != nil {
+		return err
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+// recipientThumbnails is how many thumbnails a recipient's digests carry
+func (r *DigestRecipient) recipientThumbnails() int {
+	if r.Thumbnails != nil {
+		return *r.Thumbnails
+	}
+	return defaultDigestThumbnails
+}
+
+// digestPhones lists the phones a recipient gets digests of
+func (r *DigestRecipient) digestPhones(baseDir string) []string {
+	if len(r.Phones) > 0 {
+		return r.Phones
+	}
+	return libraryPhones(baseDir)
+}
+
+// due reports whether a recipient's weekly digests are to be sent now
+func (r *DigestRecipient) due(now time.Time) bool {
+	day := time.Monday
+	if r.Weekday != "" {
+		day = digestDays[strings.ToLower(r.Weekday)]
+	}
+	hour := defaultDigestHour
+	if r.Hour != nil {
+		hour = *r.Hour
+	}
+	return now.Weekday() == day && now.Hour() >= hour
+}
+
+// sendDigest mails one phone's digest to a recipient and records it as sent
+func sendDigest(config *Config, baseDir string, state *digestState, r *DigestRecipient, phone string) error {
+	d := collectPhoneDigest(config, baseDir, phone, state, clock.Now(), r.recipientThumbnails())
+	msg, err := buildDigestMail(config.Digest.From, r.Email, d)
+	if err != nil {
+		return err
+	}
+	if err := sendMail(config.Digest, r.Email, msg); err != nil {
+		return err
+	}
+	state.Sent[r.Email+"/"+phone] = clock.Now()
+	log.Printf("Digest: sent %s's week (%d photos, %d videos) to %s", phone, d.Photos, d.Videos, r.Email)
+	return nil
+}
+
+// sendDueDigests samples the storage trend and sends the digests that are due
+func sendDueDigests(config *Config, baseDir string) {
+	digestMutex.Lock()
+	defer digestMutex.Unlock()
+
+	state := loadDigestState(baseDir)
+	state.sampleSizes(baseDir)
+	now := clock.Now()
+	for i := range config.Digest.Recipients {
+		r := &config.Digest.Recipients[i]
+		if !r.due(now) {
+			continue
+		}
+		for _, phone := range r.digestPhones(baseDir) {
+			// Sent already this week: the next one is six days off at least
+			if last, ok := state.Sent[r.Email+"/"+phone]; ok && now.Sub(last) < digestPeriod-24*time.Hour {
+				continue
+			}
+			if r.SkipEmpty {
+				if d := collectPhoneDigest(config, baseDir, phone, state, now, 0); d.Photos+d.Videos == 0 {
+					state.Sent[r.Email+"/"+phone] = now
+					continue
+				}
+			}
+			if err := sendDigest(config, baseDir, state, r, phone); err != nil {
+				log.Printf("Digest: error sending %s's week to %s: %v", phone, r.Email, err)
+			}
+		}
+	}
+	if err := saveDigestState(baseDir, state); err != nil {
+		log.Printf("Digest: error saving %s: %v", digestStateFileName, err)
+	}
+}
+
+// startDigest sends the weekly digests as they come due
+func startDigest(config *Config) {
+	if config.Digest == nil {
+		return
+	}
+	baseDir := config.ReceiveDir
+	if baseDir == "" {
+		baseDir = "received"
+	}
+	log.Printf("Digest emails enabled (%d recipient(s), via %s)", len(config.Digest.Recipients), config.Digest.SMTPHost)
+
+	sendDueDigests(config, baseDir)
+	ticker := clock.NewTicker(digestCheckInterval)
+	defer ticker.Stop()
+	for range ticker.C() {
+		sendDueDigests(config, baseDir)
+	}
+}
+
+// registerDigestRoutes adds a preview of a phone's digest as it would be mailed now, and
+// sending it right away, e.g. to try the SMTP settings
+func registerDigestRoutes(router *mux.Router, config *Config) {
+	baseDirFor := func() string {
+		if config.ReceiveDir == "" {
+			return "received"
+		}
+		return config.ReceiveDir
+	}
+
+	router.HandleFunc("/phone/{phoneName}/digest", func(w http.ResponseWriter, r *http.Request) {
+		phone := mux.Vars(r)["phoneName"]
+		if validatePhoneName(phone) != nil {
+			http.Error(w, "Invalid phone name", http.StatusBadRequest)
+			return
+		}
+		baseDir := baseDirFor()
+		digestMutex.Lock()
+		state := loadDigestState(baseDir)
+		digestMutex.Unlock()
+		d := collectPhoneDigest(config, baseDir, phone, state, clock.Now(), defaultDigestThumbnails)
+		// In the browser the thumbnails come from the server instead of the message
+		for i := range d.Thumbs {
+			d.Thumbs[i].Src = template.URL("/thumb/" + url.PathEscape(phone) + "/" + url.PathEscape(filepath.Base(d.Thumbs[i].path)))
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if err := digestTemplate.Execute(w, d); err != nil {
+			log.Printf("Error rendering digest preview: %v", err)
+		}
+	}).Methods("GET")
+
+	router.HandleFunc("/api/digest/send", func(w http.ResponseWriter, r *http.Request) {
+		if config.Digest == nil {
+			writeJSON(w, map[string]interface{}{"success": false, "error": "Digest emails are not configured"})
+			return
+		}
+		var req struct {
+			Phone string `json:"phone"`
+			Email string `json:"email"` // a configured recipient, all of the phone's when empty
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || validatePhoneName(req.Phone) != nil {
+			writeJSON(w, map[string]interface{}{"success": false, "error": "Invalid request"})
+			return
+		}
+		baseDir := baseDirFor()
+		digestMutex.Lock()
+		defer digestMutex.Unlock()
+		state := loadDigestState(baseDir)
+		state.sampleSizes(baseDir)
+		sent := 0
+		errors := []string{}
+		for i := range config.Digest.Recipients {
+			rcpt := &config.Digest.Recipients[i]
+			if req.Email != "" && !strings.EqualFold(rcpt.Email, req.Email) {
+				continue
+			}
+			gets := false
+			for _, phone := range rcpt.digestPhones(baseDir) {
+				gets = gets || phone == req.Phone
+			}
+			if !gets {
+				continue
+			}
+			if err := sendDigest(config, baseDir, state, rcpt, req.Phone); err != nil {
+				errors = append(errors, fmt.Sprintf("%s: %v", rcpt.Email, err))
+				continue
+			}
+			sent++
+		}
+		if err := saveDigestState(baseDir, state); err != nil {
+			log.Printf("Digest: error saving %s: %v", digestStateFileName, err)
+		}
+		countFeature("digest_send")
+		writeJSON(w, map[string]interface{}{"success": len(errors) == 0 && sent > 0, "sent": sent, "errors": errors})
+	}).Methods("POST")
+}
+
+// digestTemplate is the digest email; styles are inline, as mail clients drop style sheets
+var digestTemplate = template.Must(template.New("digest").Funcs(template.FuncMap{
+	"bytes": formatBytes,
+	"abs": func(n int64) int64 {
+		if n < 0 {
+			return -n
+		}
+		return n
+	},
+}).Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>{{.Subject}}</title></head>
+<body style="margin:0; padding:20px; background:#f4f4f7; font-family:'Segoe UI', Tahoma, Arial, sans-serif; color:#222222;">
+<table role="presentation" width="100%" cellpadding="0" cellspacing="0" style="max-width:600px; margin:0 auto; background:#ffffff; border-radius:8px;">
+<tr><td style="padding:20px 24px; background:#667eea; color:#ffffff; border-radius:8px 8px 0 0;">
+    <div style="font-size:13px; opacity:0.85;">{{.ServerName}} · {{.Since.Format "Jan 2"}} – {{.Until.Format "Jan 2, 2006"}}</div>
+    <div style="font-size:22px; margin-top:4px;">📱 {{.Phone}} this week</div>
+</td></tr>
+<tr><td style="padding:20px 24px;">
+    {{if or .Photos .Videos}}
+    <p style="font-size:16px; margin:0 0 16px;"><strong>{{.Photos}}</strong> photo(s) and <strong>{{.Videos}}</strong> video(s) synced, {{bytes .Bytes}}.</p>
+    {{else}}
+    <p style="font-size:16px; margin:0 0 16px;">Nothing new was synced this week.</p>
+    {{end}}
+    {{if .Thumbs}}
+    <div style="margin-bottom:16px;">
+        {{range .Thumbs}}<a href="{{.Link}}"><img src="{{.Src}}" alt="" width="120" style="width:120px; height:auto; margin:0 6px 6px 0; border-radius:4px; border:0;"></a>{{end}}
+    </div>
+    {{end}}
+    {{if .Days}}
+    <table role="presentation" cellpadding="0" cellspacing="0" style="width:100%; margin-bottom:16px; font-size:14px;">
+        {{range .Days}}<tr><td style="padding:6px 0; border-bottom:1px solid #eeeeee;">{{.Label}}</td><td style="padding:6px 0; border-bottom:1px solid #eeeeee; text-align:right; color:#666666;">{{if .Photos}}{{.Photos}} photo(s){{end}}{{if and .Photos .Videos}}, {{end}}{{if .Videos}}{{.Videos}} video(s){{end}}</td></tr>
+        {{end}}
+    </table>
+    {{end}}
+    <p style="font-size:14px; margin:0 0 8px;">💾 Storage: {{bytes .Total}}{{if .HasChange}} ({{if ge .Change 0}}+{{else}}−{{end}}{{bytes (abs .Change)}} this week){{end}}</p>
+    {{if gt (len .Weeks) 1}}
+    <table role="presentation" cellpadding="0" cellspacing="0" style="margin-bottom:16px;"><tr style="vertical-align:bottom;">
+        {{range .Weeks}}<td style="padding:0 3px; text-align:center; font-size:10px; color:#888888;"><div title="{{bytes .Bytes}}" style="width:28px; height:{{.Percent}}px; background:#764ba2; border-radius:2px 2px 0 0; margin:0 auto;"></div>{{.Label}}</td>{{end}}
+    </tr></table>
+    {{end}}
+    <p style="margin:20px 0 0;">
+        <a href="{{.RecentURL}}" style="display:inline-block; padding:10px 18px; background:#667eea; color:#ffffff; text-decoration:none; border-radius:6px;">See what's new</a>
+        <a href="{{.GalleryURL}}" style="display:inline-block; padding:10px 18px; color:#667eea; text-decoration:none;">Open the gallery</a>
+    </p>
+</td></tr>
+</table>
+</body>
+</html>`))
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// discoveryQuery is what clients broadcast to find servers on the LAN
+const discoveryQuery = "who is photo server?"
+
+// DiscoveryResponse answers a discovery query. Clients should ignore fields they don't
+// know; ports that are off are omitted.
+type DiscoveryResponse struct {
+	Service         string         `json:"service"` // always "photo_server"
+	Name            string         `json:"name"`
+	Version         string         `json:"version"`
+	IP              string         `json:"ip"`
+	Ports           DiscoveryPorts `json:"ports"`
+	TLS             DiscoveryTLS   `json:"tls"`
+	FreeBytes       uint64         `json:"freeBytes,omitempty"`
+	ProtocolVersion int            `json:"protocolVersion"`
+	Features        []string       `json:"features"` // sync protocol features, as offered in HELLO
+}
+
+// DiscoveryPorts are the server's listening ports
+type DiscoveryPorts struct {
+	TCP   int `json:"tcp"`
+	UDP   int `json:"udp"`
+	HTTP  int `json:"http"`
+	HTTPS int `json:"https,omitempty"`
+	QUIC  int `json:"quic,omitempty"`
+	GRPC  int `json:"grpc,omitempty"`
+}
+
+// DiscoveryTLS tells clients which encrypted transports exist and how to pin the
+// (usually self-signed) QUIC certificate
+type DiscoveryTLS struct {
+	HTTPS          bool   `json:"https"`
+	QUICCertSHA256 string `json:"quicCertSha256,omitempty"`
+}
+
+// buildDiscoveryResponse answers a discovery query for the server at ip: JSON, or the
+// original "photo_server:NAME,IP:..." string when legacy_discovery is set
+func buildDiscoveryResponse(config *Config, ip string) ([]byte, error) {
+	fingerprint, _ := quicCertFingerprint.Load().(string)
+	if config.LegacyDiscovery {
+		// Ports are appended so clients don't have to assume the defaults; old clients ignore them
+		response := fmt.Sprintf("photo_server:%s,IP:%s,TCP_PORT:%d,HTTP_PORT:%d",
+			config.ServerName, ip, portNumber(config.TcpPort), portNumber(config.HttpPort))
+		if config.HttpsPort != "" {
+			response += fmt.Sprintf(",HTTPS_PORT:%d", portNumber(config.HttpsPort))
+		}
+		if fingerprint != "" {
+			response += fmt.Sprintf(",QUIC_PORT:%d,QUIC_CERT:%s", portNumber(config.QuicPort), fingerprint)
+		}
+		return []byte(response), nil
+	}
+
+	baseDir := config.ReceiveDir
+	if baseDir == "" {
+		baseDir = "received"
+	}
+	response := DiscoveryResponse{
+		Service: "photo_server",
+		Name:    config.ServerName,
+		Version: version,
+		IP:      ip,
+		Ports: DiscoveryPorts{
+			TCP:   portNumber(config.TcpPort),
+			UDP:   portNumber(config.UdpPort),
+			HTTP:  portNumber(config.HttpPort),
+			HTTPS: portNumber(config.HttpsPort),
+			GRPC:  portNumber(config.GrpcPort),
+		},
+		TLS:             DiscoveryTLS{HTTPS: config.HttpsPort != ""},
+		ProtocolVersion: protocolVersion,
+		Features:        serverFeatures,
+	}
+	if fingerprint != "" {
+		// Only once the QUIC listener is up
+		response.Ports.QUIC = portNumber(config.QuicPort)
+		response.TLS.QUICCertSHA256 = fingerprint
+	}
+	if free, err := diskFreeBytes(baseDir); err == nil {
+		response.FreeBytes = free
+	}
+	return json.Marshal(response)
+}
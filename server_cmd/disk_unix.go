@@ -0,0 +1,46 @@
+//go:build !windows
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// diskFreeBytes returns the space available to unprivileged users on the volume holding path
+func diskFreeBytes(path string) (uint64, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return 0, err
+	}
+	return uint64(st.Bavail) * uint64(st.Bsize), nil
+}
+
+// isRemovableDrive reports whether path is the root of a mounted filesystem, i.e. lives on
+// another device than its parent directory. Removable drives are told apart by where they
+// are mounted (the export mount roots).
+func isRemovableDrive(path string) bool {
+	var st, parent syscall.Stat_t
+	if err := syscall.Stat(path, &st); err != nil {
+		return false
+	}
+	if err := syscall.Stat(filepath.Dir(filepath.Clean(path)), &parent); err != nil {
+		return false
+	}
+	return st.Dev != parent.Dev
+}
+
+// syncDir flushes a directory's entries to disk, so a file just renamed into it survives
+// a power loss under its new name
+func syncDir(dir string) error {
+	f, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	err = f.Sync()
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
@@ -0,0 +1,51 @@
+//go:build windows
+
+package main
+
+import (
+	"path/filepath"
+	"syscall"
+	"unsafe"
+)
+
+var (
+	kernel32               = syscall.NewLazyDLL("kernel32.dll")
+	procGetDiskFreeSpaceEx = kernel32.NewProc("GetDiskFreeSpaceExW")
+	procGetDriveType       = kernel32.NewProc("GetDriveTypeW")
+)
+
+// driveRemovable is GetDriveTypeW's result for USB sticks and card readers
+const driveRemovable = 2
+
+// diskFreeBytes returns the space available to the current user on the volume holding path
+func diskFreeBytes(path string) (uint64, error) {
+	p, err := syscall.UTF16PtrFromString(path)
+	if err != nil {
+		return 0, err
+	}
+	var free uint64
+	r, _, err := procGetDiskFreeSpaceEx.Call(uintptr(unsafe.Pointer(p)), uintptr(unsafe.Pointer(&free)), 0, 0)
+	if r == 0 {
+		return 0, err
+	}
+	return free, nil
+}
+
+// isRemovableDrive reports whether path is the root of a removable drive, e.g. "E:\\"
+func isRemovableDrive(path string) bool {
+	if path != filepath.VolumeName(path)+`\` {
+		return false
+	}
+	p, err := syscall.UTF16PtrFromString(path)
+	if err != nil {
+		return false
+	}
+	r, _, _ := procGetDriveType.Call(uintptr(unsafe.Pointer(p)))
+	return r == driveRemovable
+}
+
+// syncDir does nothing on Windows: NTFS journals renames and directory handles can't be
+// flushed
+func syncDir(dir string) error {
+	return nil
+}
@@ -0,0 +1,323 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// DuplicateCopy is one stored copy of a file found under several phone directories
+type DuplicateCopy struct {
+	Phone  string `json:"phone"`
+	Name   string `json:"name"`   // catalog name inside the phone directory
+	Linked bool   `json:"linked"` // shares its disk blocks with another copy (dedup_hardlink)
+}
+
+// DuplicateGroup is a file stored under more than one phone directory
+type DuplicateGroup struct {
+	SHA256      string          `json:"sha256"`
+	Size        int64           `json:"size"`
+	Copies      []DuplicateCopy `json:"copies"`
+	WastedBytes int64           `json:"wastedBytes"` // taken by the copies beyond the first, hard links excepted
+}
+
+// DuplicateReport is the result of the last cross-phone duplicate scan; guarded by
+// duplicatesMutex
+type DuplicateReport struct {
+	Status      string           `json:"status"` // scanning or done
+	Started     time.Time        `json:"started"`
+	Finished    *time.Time       `json:"finished,omitempty"`
+	Files       int              `json:"files"` // files scanned
+	Groups      []DuplicateGroup `json:"groups"`
+	WastedBytes int64            `json:"wastedBytes"`
+}
+
+var (
+	duplicatesMutex  sync.Mutex
+	duplicatesReport *DuplicateReport
+)
+
+// linkDuplicateCopies marks the copies of a group that are hard links of one another and
+// sets the space taken by the copies beyond the first
+func linkDuplicateCopies(baseDir string, g *DuplicateGroup) {
+	infos := make([]os.FileInfo, len(g.Copies))
+	for i, c := range g.Copies {
+		infos[i], _ = os.Stat(openCatalog(filepath.Join(baseDir, c.Phone)).Path(c.Name))
+	}
+	distinct := 0
+	for i := range g.Copies {
+		g.Copies[i].Linked = false
+		seen := false
+		for j := range g.Copies {
+			if j != i && infos[i] != nil && infos[j] != nil && os.SameFile(infos[i], infos[j]) {
+				g.Copies[i].Linked = true
+				seen = seen || j < i
+			}
+		}
+		if infos[i] != nil && !seen {
+			distinct++
+		}
+	}
+	g.WastedBytes = int64(max(0, distinct-1)) * g.Size
+}
+
+// scanDuplicates groups the cataloged files of every phone by content hash and reports the
+// ones stored under more than one phone directory, largest waste first
+func scanDuplicates(baseDir string, report *DuplicateReport) {
+	groups := make(map[string]*DuplicateGroup)
+	files := 0
+	for _, phone := range libraryPhones(baseDir) {
+		for _, e := range openCatalog(filepath.Join(baseDir, phone)).AllEntries() {
+			files++
+			if e.SHA256 == "" {
+				continue
+			}
+			sum := strings.ToLower(e.SHA256)
+			g, ok := groups[sum]
+			if !ok {
+				g = &DuplicateGroup{SHA256: sum, Size: e.Size}
+				groups[sum] = g
+			}
+			g.Copies = append(g.Copies, DuplicateCopy{Phone: phone, Name: e.Name})
+		}
+	}
+
+	list := []DuplicateGroup{}
+	var wasted int64
+	for _, g := range groups {
+		phones := make(map[string]bool)
+		for _, c := range g.Copies {
+			phones[c.Phone] = true
+		}
+		if len(phones) < 2 {
+			continue
+		}
+		linkDuplicateCopies(baseDir, g)
+		list = append(list, *g)
+		wasted += g.WastedBytes
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].WastedBytes != list[j].WastedBytes {
+			return list[i].WastedBytes > list[j].WastedBytes
+		}
+		return list[i].SHA256 < list[j].SHA256
+	})
+
+	duplicatesMutex.Lock()
+	finished := clock.Now()
+	report.Status, report.Finished = "done", &finished
+	report.Files, report.Groups, report.WastedBytes = files, list, wasted
+	duplicatesMutex.Unlock()
+	log.Printf("Duplicate scan: %d file(s) stored under several phones, %s reclaimable", len(list), formatBytes(wasted))
+}
+
+// startDuplicateScan scans the library in the background unless a scan is running, and
+// returns the report the scan fills in
+func startDuplicateScan(baseDir string) *DuplicateReport {
+	duplicatesMutex.Lock()
+	defer duplicatesMutex.Unlock()
+	if duplicatesReport != nil && duplicatesReport.Status == "scanning" {
+		return duplicatesReport
+	}
+	report := &DuplicateReport{Status: "scanning", Started: clock.Now(), Groups: []DuplicateGroup{}}
+	if duplicatesReport != nil {
+		// Keep showing the previous results until the new ones are in
+		report.Groups, report.WastedBytes, report.Files = duplicatesReport.Groups, duplicatesReport.WastedBytes, duplicatesReport.Files
+	}
+	duplicatesReport = report
+	go scanDuplicates(baseDir, report)
+	return report
+}
+
+// resolveDuplicate deduplicates the copies of a file against the one to keep: "link"
+// replaces each copy by a hard link of it, "delete" moves the copies to their phone's
+// trash and points album items at the kept one. Both hash the files first, so a copy
+// changed since the scan is left alone.
+func resolveDuplicate(baseDir, sum string, keep DuplicateCopy, copies []DuplicateCopy, action string) (int, []string) {
+	keepPath := openCatalog(filepath.Join(baseDir, keep.Phone)).Path(keep.Name)
+	if got, err := calculateSHA256(keepPath); err != nil || !strings.EqualFold(got, sum) {
+		return 0, []string{fmt.Sprintf("%s/%s no longer has this content", keep.Phone, keep.Name)}
+	}
+	keepInfo, _ := os.Stat(keepPath)
+
+	done := 0
+	var errors []string
+	repointed := make(map[DuplicateCopy]bool)
+	for _, c := range copies {
+		if c == keep {
+			continue
+		}
+		phoneDir := filepath.Join(baseDir, c.Phone)
+		path := openCatalog(phoneDir).Path(c.Name)
+		if info, err := os.Stat(path); err == nil && keepInfo != nil && os.SameFile(info, keepInfo) && action == "link" {
+			continue // already linked
+		}
+		if got, err := calculateSHA256(path); err != nil || !strings.EqualFold(got, sum) {
+			errors = append(errors, fmt.Sprintf("%s/%s no longer has this content", c.Phone, c.Name))
+			continue
+		}
+
+		catalog := openCatalog(phoneDir)
+		switch action {
+		case "link":
+			tmp := filepath.Join(filepath.Dir(path), fmt.Sprintf(".dedup_%d.tmp", clock.Now().UnixNano()))
+			if err := os.Link(keepPath, tmp); err != nil {
+				errors = append(errors, fmt.Sprintf("%s/%s: %v", c.Phone, c.Name, err))
+				continue
+			}
+			if err := os.Rename(tmp, path); err != nil {
+				os.Remove(tmp)
+				errors = append(errors, fmt.Sprintf("%s/%s: %v", c.Phone, c.Name, err))
+				continue
+			}
+			catalog.Record(path, sum)
+			log.Printf("Hard-linked %s to identical file %s", path, keepPath)
+		case "delete":
+			if err := catalog.MoveToTrash(path); err != nil {
+				errors = append(errors, fmt.Sprintf("%s/%s: %v", c.Phone, c.Name, err))
+				continue
+			}
+			thumbPath := filepath.Join(phoneDir, "thumbnails", thumbnailName(filepath.Base(path)))
+			if err := os.Remove(thumbPath); err != nil && !os.IsNotExist(err) {
+				log.Printf("Warning: Failed to delete thumbnail %s: %v", thumbPath, err)
+			}
+			notifyMediaChange(phoneDir, mediaChangeDeleted, path)
+			repointed[c] = true
+			log.Printf("Moved duplicate %s of %s to the trash", path, keepPath)
+		}
+		done++
+	}
+
+	if len(repointed) > 0 {
+		albumsMutex.Lock()
+		if albums, err := loadAlbums(baseDir); err == nil {
+			changed := false
+			for _, a := range albums {
+				for i, it := range a.Items {
+					if repointed[DuplicateCopy{Phone: it.Phone, Name: it.Name}] {
+						a.Items[i].Phone, a.Items[i].Name = keep.Phone, keep.Name
+						changed = true
+					}
+				}
+			}
+			if changed {
+				if err := saveAlbums(baseDir, albums); err != nil {
+					log.Printf("Error saving albums after deduplicating: %v", err)
+				}
+			}
+		}
+		albumsMutex.Unlock()
+	}
+
+	// Bring the report's group up to date
+	duplicatesMutex.Lock()
+	if duplicatesReport != nil {
+		var wasted int64
+		groups := []DuplicateGroup{}
+		for _, g := range duplicatesReport.Groups {
+			if g.SHA256 == strings.ToLower(sum) {
+				var left []DuplicateCopy
+				for _, c := range g.Copies {
+					if !repointed[DuplicateCopy{Phone: c.Phone, Name: c.Name}] {
+						left = append(left, DuplicateCopy{Phone: c.Phone, Name: c.Name})
+					}
+				}
+				g.Copies = left
+				linkDuplicateCopies(baseDir, &g)
+				if len(g.Copies) < 2 {
+					continue
+				}
+			}
+			groups = append(groups, g)
+			wasted += g.WastedBytes
+		}
+		duplicatesReport.Groups, duplicatesReport.WastedBytes = groups, wasted
+	}
+	duplicatesMutex.Unlock()
+	return done, errors
+}
+
+// registerDuplicateRoutes adds the cross-phone duplicate report and its actions
+func registerDuplicateRoutes(router *mux.Router, config *Config) {
+	baseDirFor := func() string {
+		if config.ReceiveDir == "" {
+			return "received"
+		}
+		return config.ReceiveDir
+	}
+	validCopy := func(c DuplicateCopy) bool {
+		return validatePhoneName(c.Phone) == nil &&
+			c.Name != "" && !strings.Contains(c.Name, "..") && !strings.HasPrefix(c.Name, "/")
+	}
+
+	// The last report; the first request starts a scan
+	router.HandleFunc("/api/duplicates", func(w http.ResponseWriter, r *http.Request) {
+		duplicatesMutex.Lock()
+		report := duplicatesReport
+		duplicatesMutex.Unlock()
+		if report == nil {
+			report = startDuplicateScan(baseDirFor())
+		}
+		duplicatesMutex.Lock()
+		defer duplicatesMutex.Unlock()
+		writeJSON(w, map[string]interface{}{"success": true, "report": report})
+	}).Methods("GET")
+
+	router.HandleFunc("/api/duplicates/scan", func(w http.ResponseWriter, r *http.Request) {
+		startDuplicateScan(baseDirFor())
+		writeJSON(w, map[string]interface{}{"success": true})
+	}).Methods("POST")
+
+	router.HandleFunc("/api/duplicates/resolve", func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			SHA256 string          `json:"sha256"`
+			Keep   DuplicateCopy   `json:"keep"`
+			Action string          `json:"action"` // "link" or "delete"
+			Copies []DuplicateCopy `json:"copies"` // empty for every other copy in the report
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeJSON(w, map[string]interface{}{"success": false, "error": "Invalid request: " + err.Error()})
+			return
+		}
+		if req.Action != "link" && req.Action != "delete" {
+			writeJSON(w, map[string]interface{}{"success": false, "error": "Unknown action " + req.Action})
+			return
+		}
+		req.Keep.Linked = false
+		if req.SHA256 == "" || !validCopy(req.Keep) {
+			writeJSON(w, map[string]interface{}{"success": false, "error": "Invalid file to keep"})
+			return
+		}
+		if len(req.Copies) == 0 {
+			duplicatesMutex.Lock()
+			if duplicatesReport != nil {
+				for _, g := range duplicatesReport.Groups {
+					if g.SHA256 == strings.ToLower(req.SHA256) {
+						req.Copies = append(req.Copies, g.Copies...)
+					}
+				}
+			}
+			duplicatesMutex.Unlock()
+		}
+		for i, c := range req.Copies {
+			if !validCopy(c) {
+				writeJSON(w, map[string]interface{}{"success": false, "error": "Invalid copy " + c.Phone + "/" + c.Name})
+				return
+			}
+			req.Copies[i].Linked = false
+		}
+
+		done, errors := resolveDuplicate(baseDirFor(), req.SHA256, req.Keep, req.Copies, req.Action)
+		countFeature("duplicates_" + req.Action)
+		writeJSON(w, map[string]interface{}{"success": done > 0 || len(errors) == 0, "done": done, "errors": errors})
+	}).Methods("POST")
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	// durabilityFsync flushes each received file and its directory to disk before the
+	// file is acknowledged: an acknowledged file survives a power loss
+	durabilityFsync = "fsync"
+	// durabilityBatched acknowledges a file once it is renamed into place and flushes
+	// the files received since in one go every batch interval: faster on slow disks and
+	// SD cards, but a power loss can lose (or leave empty) what was received in the last
+	// interval, which the app won't send again as it was acknowledged
+	durabilityBatched = "batched"
+
+	// defaultDurabilityBatchMS is how often the batched mode flushes
+	defaultDurabilityBatchMS = 1000
+)
+
+var (
+	// durability is the mode received files are written in, set from the config
+	durability = durabilityFsync
+
+	batchedSyncMutex sync.Mutex
+	batchedSyncFiles = make(map[string]bool) // received files not flushed yet
+	batchedSyncDirs  = make(map[string]bool) // their directories
+)
+
+// setDurability checks the durability mode and starts the batched mode's flusher
+func setDurability(config *Config) error {
+	switch config.Durability {
+	case "", durabilityFsync:
+		durability = durabilityFsync
+		return nil
+	case durabilityBatched:
+	default:
+		return fmt.Errorf("unknown durability %q, use fsync or batched", config.Durability)
+	}
+	ms := config.DurabilityBatchMS
+	if ms <= 0 {
+		ms = defaultDurabilityBatchMS
+	}
+	durability = durabilityBatched
+	log.Printf("Durability: batched, received files are flushed to disk every %v after being acknowledged",
+		time.Duration(ms)*time.Millisecond)
+	go func() {
+		for range time.Tick(time.Duration(ms) * time.Millisecond) {
+			flushBatchedSyncs()
+		}
+	}()
+	return nil
+}
+
+// writeReceivedFile writes a received original like writeFileAtomic, except that in the
+// fsync mode a directory that can't be flushed fails the file too; in the batched mode
+// the flushing is left to the next batch
+func writeReceivedFile(path string, data []byte) error {
+	if durability != durabilityBatched {
+		if err := writeFileRenamed(path, data, true); err != nil {
+			return err
+		}
+		if err := syncDir(filepath.Dir(path)); err != nil {
+			os.Remove(path)
+			return err
+		}
+		return nil
+	}
+	if err := writeFileRenamed(path, data, false); err != nil {
+		return err
+	}
+	queueBatchedSync(path)
+	return nil
+}
+
+// syncReceivedData flushes a received file's data before it is renamed into place, unless
+// the batched mode flushes it later
+func syncReceivedData(f *os.File) error {
+	if durability == durabilityBatched {
+		return nil
+	}
+	return f.Sync()
+}
+
+// receivedFileStored flushes a received file just renamed, copied or linked into place
+// and its directory or, in the batched mode, queues them for the next batch. Flushing
+// the file again is cheap after syncReceivedData and covers the copy made when the
+// rename crosses volumes, as into a storage pool.
+func receivedFileStored(path string) error {
+	if durability == durabilityBatched {
+		queueBatchedSync(path)
+		return nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	err = f.Sync()
+	f.Close()
+	if err != nil {
+		return err
+	}
+	return syncDir(filepath.Dir(path))
+}
+
+func queueBatchedSync(path string) {
+	batchedSyncMutex.Lock()
+	batchedSyncFiles[path] = true
+	batchedSyncDirs[filepath.Dir(path)] = true
+	batchedSyncMutex.Unlock()
+}
+
+// flushBatchedSyncs flushes the files received since the last batch, then their
+// directories, so no name is made durable before its data
+func flushBatchedSyncs() {
+	batchedSyncMutex.Lock()
+	files, dirs := batchedSyncFiles, batchedSyncDirs
+	batchedSyncFiles, batchedSyncDirs = make(map[string]bool), make(map[string]bool)
+	batchedSyncMutex.Unlock()
+
+	for path := range files {
+		f, err := os.Open(path)
+		if err == nil {
+			err = f.Sync()
+			f.Close()
+		}
+		// A file deleted or moved to the trash meanwhile needs no flushing
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			log.Printf("Error flushing %s: %v\n", path, err)
+		}
+	}
+	for dir := range dirs {
+		if err := syncDir(dir); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			log.Printf("Warning: Failed to flush directory %s: %v\n", dir, err)
+		}
+	}
+}
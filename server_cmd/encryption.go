@@ -0,0 +1,93 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+)
+
+// Payload encryption, for networks where neither TLS transport can be used. Once both sides
+// announce "encryption" in HELLO, every later client message payload (except PING) is
+//
+//	nonce (12 random bytes) | AES-GCM ciphertext and tag
+//
+// sealed with the shared payload_key from the config and the message type byte as additional
+// data. Only the uploads are protected: frame headers, HELLO and everything the server sends
+// stay in the clear, so an eavesdropper can still read
+//
+//   - ACKs: file IDs, storage paths, SHA-256 sums, quota figures, error messages, and the
+//     device token a pairing issues
+//   - MISSING_LIST: the IDs of the media the server doesn't have
+//   - MEDIA_COUNT_RSP and MEDIA_THUMB_DATA: the size of the library, and its thumbnails
+//     with their file names
+//   - MEDIA_DEL_ACK: the IDs and names of the media to delete, and in previews their
+//     thumbnails and EXIF data, GPS position included
+//   - MEDIA_CHANGED: phone names and the IDs and names of the changed media
+//   - SYNC_PROGRESS and SYNC_SUMMARY: the phone name, file names and IDs, counts and bytes
+//   - the HELLO and SUBSCRIBE_CHANGES replies and PING echoes
+//
+// Where that matters, use one of the TLS transports instead.
+
+// parsePayloadKey decodes payload_key: 16, 24 or 32 bytes (AES-128/192/256), hex or base64
+func parsePayloadKey(value string) ([]byte, error) {
+	value = strings.TrimSpace(value)
+	key, err := hex.DecodeString(value)
+	if err != nil {
+		if key, err = base64.StdEncoding.DecodeString(value); err != nil {
+			return nil, fmt.Errorf("payload_key is neither hex nor base64")
+		}
+	}
+	switch len(key) {
+	case 16, 24, 32:
+		return key, nil
+	}
+	return nil, fmt.Errorf("payload_key is %d bytes, want 16, 24 or 32", len(key))
+}
+
+// newPayloadCipher returns the AES-GCM cipher for the configured payload_key, or nil when
+// encryption is off
+func newPayloadCipher(config *Config) (cipher.AEAD, error) {
+	if config == nil || config.PayloadKey == "" {
+		return nil, nil
+	}
+	key, err := parsePayloadKey(config.PayloadKey)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// payloadReader reads message payloads off a sync connection, decrypting them once
+// encryption has been negotiated
+type payloadReader struct {
+	conn net.Conn
+	aead cipher.AEAD // nil until negotiated
+}
+
+func (p *payloadReader) read(msgType byte, length uint32) ([]byte, error) {
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(p.conn, payload); err != nil {
+		return nil, err
+	}
+	if p.aead == nil || length == 0 {
+		return payload, nil
+	}
+	nonceSize := p.aead.NonceSize()
+	if len(payload) < nonceSize+p.aead.Overhead() {
+		return nil, fmt.Errorf("encrypted payload too short (%d bytes)", len(payload))
+	}
+	plain, err := p.aead.Open(payload[nonceSize:nonceSize], payload[:nonceSize], payload[nonceSize:], []byte{msgType})
+	if err != nil {
+		return nil, fmt.Errorf("payload does not decrypt with payload_key: %w", err)
+	}
+	return plain, nil
+}
@@ -0,0 +1,357 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"net/http"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// EXIF tags read into ExifInfo, besides the capture time ones in date_layout.go
+const (
+	exifTagMake         = 0x010F
+	exifTagModel        = 0x0110
+	exifTagGPSIFD       = 0x8825
+	exifTagExposureTime = 0x829A
+	exifTagFNumber      = 0x829D
+	exifTagISO          = 0x8827
+	exifTagFocalLength  = 0x920A
+	exifTagPixelX       = 0xA002
+	exifTagPixelY       = 0xA003
+	exifTagLensMake     = 0xA433
+	exifTagLensModel    = 0xA434
+
+	gpsTagLatitudeRef  = 0x0001
+	gpsTagLatitude     = 0x0002
+	gpsTagLongitudeRef = 0x0003
+	gpsTagLongitude    = 0x0004
+	gpsTagAltitudeRef  = 0x0005
+	gpsTagAltitude     = 0x0006
+
+	exifTypeByte     = 1
+	exifTypeRational = 5
+)
+
+// ExifInfo is the camera metadata of a photo, read from its EXIF block and, for what that
+// lacks, its XMP packet when the file is stored
+type ExifInfo struct {
+	Make         string   `json:"make,omitempty"`
+	Model        string   `json:"model,omitempty"`
+	Lens         string   `json:"lens,omitempty"`
+	Taken        string   `json:"taken,omitempty"`       // as recorded: RFC 3339 with the offset when known, else local time without one
+	Orientation  int      `json:"orientation,omitempty"` // EXIF orientation 1-8
+	Width        int      `json:"width,omitempty"`
+	Height       int      `json:"height,omitempty"`
+	FNumber      float64  `json:"fNumber,omitempty"`
+	ExposureTime string   `json:"exposureTime,omitempty"` // e.g. "1/120"
+	ISO          int      `json:"iso,omitempty"`
+	FocalLength  float64  `json:"focalLength,omitempty"` // millimeters
+	GPS          *ExifGPS `json:"gps,omitempty"`
+}
+
+// ExifGPS is the position a photo was taken at
+type ExifGPS struct {
+	Lat float64  `json:"lat"`
+	Lon float64  `json:"lon"`
+	Alt *float64 `json:"alt,omitempty"` // meters above sea level
+}
+
+func (e *ExifInfo) empty() bool {
+	return e == nil || *e == ExifInfo{}
+}
+
+// exifTIFF locates the TIFF structure of the EXIF block in data: after the "Exif\0\0"
+// header of a JPEG APP1 segment or HEIC Exif item, or in the eXIf chunk of a PNG
+func exifTIFF(data []byte) ([]byte, binary.ByteOrder, bool) {
+	var tiff []byte
+	if i := bytes.Index(data, []byte("Exif\x00\x00")); i >= 0 {
+		tiff = data[i+6:]
+	} else if bytes.HasPrefix(data, []byte("\x89PNG\r\n\x1a\n")) {
+		if i := bytes.Index(data, []byte("eXIf")); i >= 0 {
+			tiff = data[i+4:]
+		}
+	}
+	if len(tiff) < 8 {
+		return nil, nil, false
+	}
+	switch string(tiff[:2]) {
+	case "II":
+		return tiff, binary.LittleEndian, true
+	case "MM":
+		return tiff, binary.BigEndian, true
+	}
+	return nil, nil, false
+}
+
+func exifShortOrLong(tiff []byte, bo binary.ByteOrder, ifd uint32, tag uint16) (int, bool) {
+	typ, count, value, ok := exifEntry(tiff, bo, ifd, tag)
+	if !ok || count != 1 {
+		return 0, false
+	}
+	switch typ {
+	case exifTypeShort:
+		return int(bo.Uint16(value)), true
+	case exifTypeLong:
+		return int(bo.Uint32(value)), true
+	}
+	return 0, false
+}
+
+// exifRationals returns the values of an unsigned RATIONAL tag
+func exifRationals(tiff []byte, bo binary.ByteOrder, ifd uint32, tag uint16) ([]float64, bool) {
+	typ, count, value, ok := exifEntry(tiff, bo, ifd, tag)
+	if !ok || typ != exifTypeRational || count == 0 || count > 16 {
+		return nil, false
+	}
+	off := int64(bo.Uint32(value))
+	if off+int64(count)*8 > int64(len(tiff)) {
+		return nil, false
+	}
+	values := make([]float64, count)
+	for i := range values {
+		num := bo.Uint32(tiff[off+int64(i)*8:])
+		den := bo.Uint32(tiff[off+int64(i)*8+4:])
+		if den == 0 {
+			return nil, false
+		}
+		values[i] = float64(num) / float64(den)
+	}
+	return values, true
+}
+
+// exifCoordinate turns degrees, minutes and seconds with a N/S/E/W reference into degrees
+func exifCoordinate(dms []float64, ref string) (float64, bool) {
+	if len(dms) != 3 {
+		return 0, false
+	}
+	deg := dms[0] + dms[1]/60 + dms[2]/3600
+	if ref == "S" || ref == "W" {
+		deg = -deg
+	}
+	return deg, true
+}
+
+// parseExif reads the EXIF block of a file head into info
+func parseExif(data []byte, info *ExifInfo) {
+	tiff, bo, ok := exifTIFF(data)
+	if !ok {
+		return
+	}
+	ifd0 := bo.Uint32(tiff[4:8])
+	info.Make, _ = exifASCII(tiff, bo, ifd0, exifTagMake)
+	info.Model, _ = exifASCII(tiff, bo, ifd0, exifTagModel)
+	if o, ok := exifShortOrLong(tiff, bo, ifd0, exifTagOrientation); ok && o >= 1 && o <= 8 {
+		info.Orientation = o
+	}
+
+	if exifIFD, ok := exifLong(tiff, bo, ifd0, exifTagExifIFD); ok {
+		if taken, ok := exifASCII(tiff, bo, exifIFD, exifTagDateTimeOriginal); ok {
+			offset, _ := exifASCII(tiff, bo, exifIFD, exifTagOffsetTimeOriginal)
+			info.Taken = exifTimeString(taken, offset)
+		}
+		lensMake, _ := exifASCII(tiff, bo, exifIFD, exifTagLensMake)
+		lensModel, _ := exifASCII(tiff, bo, exifIFD, exifTagLensModel)
+		if lensMake != "" && !strings.HasPrefix(lensModel, lensMake) {
+			lensModel = strings.TrimSpace(lensMake + " " + lensModel)
+		}
+		info.Lens = lensModel
+		info.Width, _ = exifShortOrLong(tiff, bo, exifIFD, exifTagPixelX)
+		info.Height, _ = exifShortOrLong(tiff, bo, exifIFD, exifTagPixelY)
+		info.ISO, _ = exifShortOrLong(tiff, bo, exifIFD, exifTagISO)
+		if v, ok := exifRationals(tiff, bo, exifIFD, exifTagFNumber); ok {
+			info.FNumber = math.Round(v[0]*10) / 10
+		}
+		if v, ok := exifRationals(tiff, bo, exifIFD, exifTagFocalLength); ok {
+			info.FocalLength = math.Round(v[0]*10) / 10
+		}
+		if v, ok := exifRationals(tiff, bo, exifIFD, exifTagExposureTime); ok && v[0] > 0 {
+			if v[0] < 1 {
+				info.ExposureTime = fmt.Sprintf("1/%d", int(math.Round(1/v[0])))
+			} else {
+				info.ExposureTime = strconv.FormatFloat(v[0], 'f', -1, 64)
+			}
+		}
+	}
+
+	if gpsIFD, ok := exifLong(tiff, bo, ifd0, exifTagGPSIFD); ok {
+		latRef, _ := exifASCII(tiff, bo, gpsIFD, gpsTagLatitudeRef)
+		lonRef, _ := exifASCII(tiff, bo, gpsIFD, gpsTagLongitudeRef)
+		latDMS, latOK := exifRationals(tiff, bo, gpsIFD, gpsTagLatitude)
+		lonDMS, lonOK := exifRationals(tiff, bo, gpsIFD, gpsTagLongitude)
+		if latOK && lonOK {
+			lat, ok1 := exifCoordinate(latDMS, latRef)
+			lon, ok2 := exifCoordinate(lonDMS, lonRef)
+			if ok1 && ok2 && (lat != 0 || lon != 0) {
+				info.GPS = &ExifGPS{Lat: lat, Lon: lon}
+				if alt, ok := exifRationals(tiff, bo, gpsIFD, gpsTagAltitude); ok {
+					a := alt[0]
+					if typ, _, ref, ok := exifEntry(tiff, bo, gpsIFD, gpsTagAltitudeRef); ok && typ == exifTypeByte && ref[0] == 1 {
+						a = -a // below sea level
+					}
+					info.GPS.Alt = &a
+				}
+			}
+		}
+	}
+}
+
+// exifTimeString formats an EXIF date ("2006:01:02 15:04:05") and optional offset
+func exifTimeString(taken, offset string) string {
+	if offset != "" {
+		if t, err := time.Parse("2006:01:02 15:04:05-07:00", taken+offset); err == nil {
+			return t.Format(time.RFC3339)
+		}
+	}
+	t, err := time.Parse("2006:01:02 15:04:05", taken)
+	if err != nil || t.Year() < 1800 {
+		return ""
+	}
+	return t.Format("2006-01-02T15:04:05")
+}
+
+// xmpValue matches a property of an XMP packet in attribute or element form
+func xmpValue(packet []byte, name string) string {
+	re := regexp.MustCompile(`\b` + regexp.QuoteMeta(name) + `(?:="([^"]*)"|>([^<]*)<)`)
+	m := re.FindSubmatch(packet)
+	if m == nil {
+		return ""
+	}
+	return strings.TrimSpace(string(m[1]) + string(m[2]))
+}
+
+// xmpCoordinate parses an XMP GPS coordinate, "DDD,MM.mmk" or "DDD,MM,SSk"
+func xmpCoordinate(value string) (float64, bool) {
+	if len(value) < 2 {
+		return 0, false
+	}
+	ref := strings.ToUpper(value[len(value)-1:])
+	parts := strings.Split(value[:len(value)-1], ",")
+	dms := make([]float64, 3)
+	if len(parts) < 2 || len(parts) > 3 {
+		return 0, false
+	}
+	for i, p := range parts {
+		v, err := strconv.ParseFloat(strings.TrimSpace(p), 64)
+		if err != nil {
+			return 0, false
+		}
+		dms[i] = v
+	}
+	return exifCoordinate(dms, ref)
+}
+
+// parseXMP fills what the EXIF block didn't have from the XMP packet of a file head
+func parseXMP(data []byte, info *ExifInfo) {
+	start := bytes.Index(data, []byte("<x:xmpmeta"))
+	if start < 0 {
+		return
+	}
+	packet := data[start:]
+	if end := bytes.Index(packet, []byte("</x:xmpmeta>")); end >= 0 {
+		packet = packet[:end]
+	}
+	fill := func(field *string, names ...string) {
+		for _, name := range names {
+			if *field != "" {
+				return
+			}
+			*field = xmpValue(packet, name)
+		}
+	}
+	fill(&info.Make, "tiff:Make")
+	fill(&info.Model, "tiff:Model")
+	fill(&info.Lens, "exifEX:LensModel", "aux:Lens")
+	if info.Taken == "" {
+		for _, name := range []string{"exif:DateTimeOriginal", "photoshop:DateCreated", "xmp:CreateDate"} {
+			if v := xmpValue(packet, name); v != "" {
+				info.Taken = v
+				break
+			}
+		}
+	}
+	if info.Orientation == 0 {
+		if o, err := strconv.Atoi(xmpValue(packet, "tiff:Orientation")); err == nil && o >= 1 && o <= 8 {
+			info.Orientation = o
+		}
+	}
+	if info.GPS == nil {
+		lat, ok1 := xmpCoordinate(xmpValue(packet, "exif:GPSLatitude"))
+		lon, ok2 := xmpCoordinate(xmpValue(packet, "exif:GPSLongitude"))
+		if ok1 && ok2 {
+			info.GPS = &ExifGPS{Lat: lat, Lon: lon}
+		}
+	}
+}
+
+// readExifInfo extracts the camera metadata of a photo; nil for other files. Photos
+// without any give an empty ExifInfo, so the catalog knows they were looked at.
+func readExifInfo(path string) *ExifInfo {
+	if !hasExtension(path, photoExtensions) {
+		return nil
+	}
+	head := readFileHead(path)
+	info := &ExifInfo{}
+	parseExif(head, info)
+	parseXMP(head, info)
+	return info
+}
+
+// registerExifRoutes adds the per-file metadata endpoint. IDs are the ones of the thumbnail
+// list (or a thumbnail or original name); without ?phone= all phones are searched.
+func registerExifRoutes(router *mux.Router, config *Config) {
+	router.HandleFunc("/api/media/{id}/metadata", func(w http.ResponseWriter, r *http.Request) {
+		id := mux.Vars(r)["id"]
+		phone := r.URL.Query().Get("phone")
+		if strings.Contains(id, "..") || strings.ContainsAny(id, "/\\") ||
+			(phone != "" && validatePhoneName(phone) != nil) {
+			writeJSONStatus(w, http.StatusBadRequest, map[string]interface{}{"success": false, "error": "Invalid id or phone"})
+			return
+		}
+		baseDir := config.ReceiveDir
+		if baseDir == "" {
+			baseDir = "received"
+		}
+		phones := []string{phone}
+		if phone == "" {
+			phones = libraryPhones(baseDir)
+		}
+
+		for _, p := range phones {
+			phoneDir := filepath.Join(baseDir, p)
+			orig, ok := originalForThumbnail(phoneDir, id)
+			if !ok {
+				continue
+			}
+			catalog := openCatalog(phoneDir)
+			resp := map[string]interface{}{
+				"success": true,
+				"phone":   p,
+				"name":    catalog.catalogName(orig),
+				"exif":    catalog.Exif(orig),
+			}
+			if e, ok := catalog.Entry(orig); ok {
+				resp["size"] = e.Size
+				resp["favorite"] = e.Favorite
+				if e.Taken != nil {
+					resp["taken"] = e.Taken.Format(time.RFC3339)
+					resp["takenPrecision"] = e.TakenPrecision
+				}
+				if e.Place != nil {
+					resp["place"] = e.Place
+				}
+			}
+			countFeature("media_metadata")
+			writeJSON(w, resp)
+			return
+		}
+		writeJSONStatus(w, http.StatusNotFound, map[string]interface{}{"success": false, "error": "Media not found"})
+	}).Methods("GET")
+}
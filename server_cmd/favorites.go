@@ -0,0 +1,48 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// registerFavoriteRoutes adds marking photos and videos as favorites from the gallery
+func registerFavoriteRoutes(router *mux.Router, config *Config) {
+	router.HandleFunc("/api/phones/{phoneName}/favorites", func(w http.ResponseWriter, r *http.Request) {
+		phoneDir, err := phoneDirFromRequest(config, r)
+		if err != nil {
+			writeJSON(w, map[string]interface{}{"success": false, "error": "Invalid phone name"})
+			return
+		}
+		var req struct {
+			Photos   []string `json:"photos"` // thumbnail or original names
+			Favorite bool     `json:"favorite"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeJSON(w, map[string]interface{}{"success": false, "error": "Invalid request: " + err.Error()})
+			return
+		}
+		if len(req.Photos) == 0 {
+			writeJSON(w, map[string]interface{}{"success": false, "error": "No photos selected"})
+			return
+		}
+
+		var paths []string
+		for _, photo := range req.Photos {
+			if strings.Contains(photo, "..") || strings.ContainsAny(photo, "/\\") {
+				continue
+			}
+			if orig, ok := originalForThumbnail(phoneDir, photo); ok {
+				paths = append(paths, orig)
+			}
+		}
+
+		updated := openCatalog(phoneDir).SetFavorite(paths, req.Favorite)
+		countFeature("favorite")
+		log.Printf("Set favorite=%v on %d file(s) in %s", req.Favorite, updated, phoneDir)
+		writeJSON(w, map[string]interface{}{"success": true, "updated": updated})
+	}).Methods("POST")
+}
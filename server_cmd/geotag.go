@@ -0,0 +1,425 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	// geocodeQueueSize bounds the positions waiting for a lookup; a full queue leaves the
+	// rest to the backfill at the next start
+	geocodeQueueSize = 1000
+
+	// geocodeHTTPInterval spaces out lookups at a web service (Nominatim allows one a second)
+	geocodeHTTPInterval = time.Second
+
+	// geocodeErrorWait pauses the lookups after a failed one, doubling with every further
+	// failure in a row up to geocodeMaxWait, so a service that is down or limiting us
+	// isn't asked again right away
+	geocodeErrorWait = 5 * time.Second
+	geocodeMaxWait   = 10 * time.Minute
+
+	// offlineGeocodeMaxKm is how far the nearest place of the offline database may be
+	offlineGeocodeMaxKm = 50
+)
+
+// GeocoderConfig turns photo positions into place names, so media can be grouped by place.
+// Provider "offline" looks them up in a GeoNames cities file (cities500.txt, cities15000.txt,
+// ...), "http" asks a Nominatim-compatible service at URL, with {lat} and {lon} replaced,
+// and "command" runs Command with the latitude and longitude as arguments, expecting JSON
+// {"city","region","country"} on stdout.
+type GeocoderConfig struct {
+	Provider string `json:"provider"`
+	Database string `json:"database"`
+	URL      string `json:"url"`
+	Command  string `json:"command"`
+}
+
+// GeoPlace is the reverse-geocoded place of a photo's position
+type GeoPlace struct {
+	City    string `json:"city,omitempty"`
+	Region  string `json:"region,omitempty"`
+	Country string `json:"country,omitempty"`
+}
+
+// Label is the place as shown and grouped by, e.g. "Lisbon, Portugal"
+func (p *GeoPlace) Label() string {
+	var parts []string
+	for _, s := range []string{p.City, p.Region, p.Country} {
+		if s != "" && (len(parts) == 0 || parts[len(parts)-1] != s) {
+			parts = append(parts, s)
+		}
+	}
+	if len(parts) == 3 {
+		parts = append(parts[:1], parts[2]) // city and country are enough
+	}
+	return strings.Join(parts, ", ")
+}
+
+// reverseGeocoder looks up the place at a position; a nil place means nothing is there
+type reverseGeocoder interface {
+	reverseGeocode(ctx context.Context, lat, lon float64) (*GeoPlace, error)
+}
+
+type geocodeJob struct {
+	phoneDir, path string
+	lat, lon       float64
+}
+
+var (
+	// geocoder is the reverse geocoder in use, set from the config at startup (nil: off)
+	geocoder     reverseGeocoder
+	geocodeQueue = make(chan geocodeJob, geocodeQueueSize)
+
+	// geocodeCache remembers lookups by position rounded to about 100 m, since a day of
+	// photos is mostly taken in a few places
+	geocodeCacheMutex sync.Mutex
+	geocodeCache      = make(map[[2]int32]*GeoPlace)
+)
+
+// position returns where a file was taken: the hand-assigned place, else the EXIF GPS
+func (e *CatalogEntry) position() (float64, float64, bool) {
+	if e.Place != nil {
+		return e.Place.Lat, e.Place.Lon, true
+	}
+	if e.Exif != nil && e.Exif.GPS != nil {
+		return e.Exif.GPS.Lat, e.Exif.GPS.Lon, true
+	}
+	return 0, 0, false
+}
+
+// setGeocoder enables reverse geocoding from the config
+func setGeocoder(config *Config) error {
+	g := config.Geocoder
+	if g == nil || g.Provider == "" {
+		return nil
+	}
+	var rg reverseGeocoder
+	switch strings.ToLower(g.Provider) {
+	case "offline":
+		db, err := loadOfflineGeocoder(g.Database)
+		if err != nil {
+			return err
+		}
+		rg = db
+	case "http":
+		if !strings.Contains(g.URL, "{lat}") || !strings.Contains(g.URL, "{lon}") {
+			return fmt.Errorf("geocoder url needs {lat} and {lon}")
+		}
+		rg = &httpGeocoder{url: g.URL, client: &http.Client{Timeout: 15 * time.Second}}
+	case "command":
+		if _, err := tools.LookPath(g.Command); err != nil {
+			return fmt.Errorf("geocoder command: %w", err)
+		}
+		rg = commandGeocoder{command: g.Command}
+	default:
+		return fmt.Errorf("unknown geocoder provider %q", g.Provider)
+	}
+	geocoder = rg
+	go runGeocoder()
+	return nil
+}
+
+// geocodeLater queues the lookup of the place of a file's position
+func geocodeLater(phoneDir, path string, lat, lon float64) {
+	if geocoder == nil {
+		return
+	}
+	select {
+	case geocodeQueue <- geocodeJob{phoneDir: phoneDir, path: path, lat: lat, lon: lon}:
+	default:
+	}
+}
+
+// runGeocoder works through the geocode queue, one lookup at a time, backing off after
+// failed lookups
+func runGeocoder() {
+	_, remote := geocoder.(*httpGeocoder)
+	backoff := newFailureThrottle(geocodeErrorWait, geocodeMaxWait)
+	for job := range geocodeQueue {
+		key := [2]int32{int32(math.Round(job.lat * 1000)), int32(math.Round(job.lon * 1000))}
+		geocodeCacheMutex.Lock()
+		place, cached := geocodeCache[key]
+		geocodeCacheMutex.Unlock()
+		if !cached {
+			var err error
+			place, err = geocoder.reverseGeocode(context.Background(), job.lat, job.lon)
+			if err != nil {
+				wait := backoff.failed("geocoder")
+				log.Printf("Error reverse geocoding %s: %v, next lookup in %s", job.path, err, wait)
+				time.Sleep(wait)
+				continue
+			}
+			backoff.succeeded("geocoder")
+			geocodeCacheMutex.Lock()
+			geocodeCache[key] = place
+			geocodeCacheMutex.Unlock()
+			if remote {
+				time.Sleep(geocodeHTTPInterval)
+			}
+		}
+		if place == nil {
+			place = &GeoPlace{} // looked up, nowhere in particular
+		}
+		openCatalog(job.phoneDir).SetGeo(job.path, place)
+	}
+}
+
+// geocodeLibrary queues the files with a position but no place yet, e.g. after the
+// geocoder was enabled on an existing library
+func geocodeLibrary(baseDir string) {
+	if geocoder == nil {
+		return
+	}
+	for _, phone := range libraryPhones(baseDir) {
+		phoneDir := filepath.Join(baseDir, phone)
+		catalog := openCatalog(phoneDir)
+		for _, e := range catalog.AllEntries() {
+			if draining() {
+				return
+			}
+			if e.Geo != nil || !hasExtension(e.Name, photoExtensions) {
+				continue
+			}
+			path := entryPath(phoneDir, &e)
+			if e.Exif == nil {
+				e.Exif = catalog.Exif(path)
+			}
+			if lat, lon, ok := e.position(); ok {
+				geocodeQueue <- geocodeJob{phoneDir: phoneDir, path: path, lat: lat, lon: lon}
+			}
+		}
+	}
+}
+
+// offlineGeocoder finds the nearest place of a GeoNames cities file, bucketed by degree
+type offlineGeocoder struct {
+	cells map[[2]int][]offlinePlace
+}
+
+type offlinePlace struct {
+	lat, lon float64
+	place    *GeoPlace
+}
+
+// loadOfflineGeocoder reads a GeoNames cities file (tab separated: name in column 2,
+// latitude and longitude in 5 and 6, country code in 9). Regions are left out, the file
+// only has their codes.
+func loadOfflineGeocoder(path string) (*offlineGeocoder, error) {
+	if path == "" {
+		return nil, fmt.Errorf("offline geocoder needs a database file")
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	g := &offlineGeocoder{cells: make(map[[2]int][]offlinePlace)}
+	n := 0
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		cols := strings.Split(scanner.Text(), "\t")
+		if len(cols) < 11 {
+			continue
+		}
+		lat, err1 := strconv.ParseFloat(cols[4], 64)
+		lon, err2 := strconv.ParseFloat(cols[5], 64)
+		if err1 != nil || err2 != nil {
+			continue
+		}
+		cell := [2]int{int(math.Floor(lat)), int(math.Floor(lon))}
+		g.cells[cell] = append(g.cells[cell], offlinePlace{lat: lat, lon: lon,
+			place: &GeoPlace{City: cols[1], Country: cols[8]}})
+		n++
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if n == 0 {
+		return nil, fmt.Errorf("no places in %s", path)
+	}
+	log.Printf("Offline geocoder: %d places from %s", n, path)
+	return g, nil
+}
+
+// haversineKm is the great-circle distance between two positions
+func haversineKm(lat1, lon1, lat2, lon2 float64) float64 {
+	const earthRadiusKm = 6371
+	rad := math.Pi / 180
+	dLat, dLon := (lat2-lat1)*rad, (lon2-lon1)*rad
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(lat1*rad)*math.Cos(lat2*rad)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadiusKm * math.Asin(math.Sqrt(a))
+}
+
+func (g *offlineGeocoder) reverseGeocode(ctx context.Context, lat, lon float64) (*GeoPlace, error) {
+	cLat, cLon := int(math.Floor(lat)), int(math.Floor(lon))
+	var best *GeoPlace
+	bestKm := float64(offlineGeocodeMaxKm)
+	for dy := -1; dy <= 1; dy++ {
+		for dx := -1; dx <= 1; dx++ {
+			for _, p := range g.cells[[2]int{cLat + dy, cLon + dx}] {
+				if d := haversineKm(lat, lon, p.lat, p.lon); d < bestKm {
+					best, bestKm = p.place, d
+				}
+			}
+		}
+	}
+	return best, nil
+}
+
+// httpGeocoder asks a Nominatim-compatible reverse geocoding service
+type httpGeocoder struct {
+	url    string
+	client *http.Client
+}
+
+func (g *httpGeocoder) reverseGeocode(ctx context.Context, lat, lon float64) (*GeoPlace, error) {
+	url := strings.NewReplacer(
+		"{lat}", strconv.FormatFloat(lat, 'f', 6, 64),
+		"{lon}", strconv.FormatFloat(lon, 'f', 6, 64)).Replace(g.url)
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", "photo_sync_server/"+version)
+	resp, err := g.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("geocoder answered %s", resp.Status)
+	}
+	var body struct {
+		GeoPlace
+		Address struct {
+			City    string `json:"city"`
+			Town    string `json:"town"`
+			Village string `json:"village"`
+			Hamlet  string `json:"hamlet"`
+			State   string `json:"state"`
+			Country string `json:"country"`
+		} `json:"address"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, err
+	}
+	place := body.GeoPlace
+	a := body.Address
+	for _, city := range []string{a.City, a.Town, a.Village, a.Hamlet} {
+		if place.City == "" {
+			place.City = city
+		}
+	}
+	if place.Region == "" {
+		place.Region = a.State
+	}
+	if place.Country == "" {
+		place.Country = a.Country
+	}
+	if place == (GeoPlace{}) {
+		return nil, nil
+	}
+	return &place, nil
+}
+
+// commandGeocoder runs a program for each lookup
+type commandGeocoder struct {
+	command string
+}
+
+func (g commandGeocoder) reverseGeocode(ctx context.Context, lat, lon float64) (*GeoPlace, error) {
+	output, err := runTool(ctx, geocodeTimeout, g.command,
+		strconv.FormatFloat(lat, 'f', 6, 64), strconv.FormatFloat(lon, 'f', 6, 64))
+	if err != nil {
+		return nil, fmt.Errorf("%s failed: %v, output: %s", g.command, err, strings.TrimSpace(string(output)))
+	}
+	var place GeoPlace
+	if err := json.Unmarshal(output, &place); err != nil {
+		return nil, fmt.Errorf("%s printed no place JSON: %w", g.command, err)
+	}
+	if place == (GeoPlace{}) {
+		return nil, nil
+	}
+	return &place, nil
+}
+
+// registerGeotagRoutes adds the media-by-place overview to the router
+func registerGeotagRoutes(router *mux.Router, config *Config) {
+	router.HandleFunc("/api/places", func(w http.ResponseWriter, r *http.Request) {
+		baseDir := config.ReceiveDir
+		if baseDir == "" {
+			baseDir = "received"
+		}
+		phones := libraryPhones(baseDir)
+		if phone := r.URL.Query().Get("phone"); phone != "" {
+			phones = []string{phone}
+		}
+
+		type placeGroup struct {
+			Label string `json:"label"`
+			GeoPlace
+			Count  int            `json:"count"`
+			Phones map[string]int `json:"phones"`
+		}
+		groups := make(map[string]*placeGroup)
+		located, unresolved := 0, 0
+		for _, phone := range phones {
+			if validatePhoneName(phone) != nil {
+				continue
+			}
+			for _, e := range openCatalog(filepath.Join(baseDir, phone)).AllEntries() {
+				if _, _, ok := e.position(); !ok {
+					continue
+				}
+				located++
+				if e.Geo == nil || e.Geo.Label() == "" {
+					unresolved++
+					continue
+				}
+				label := e.Geo.Label()
+				g, ok := groups[label]
+				if !ok {
+					g = &placeGroup{Label: label, GeoPlace: *e.Geo, Phones: make(map[string]int)}
+					groups[label] = g
+				}
+				g.Count++
+				g.Phones[phone]++
+			}
+		}
+		list := make([]*placeGroup, 0, len(groups))
+		for _, g := range groups {
+			list = append(list, g)
+		}
+		sort.Slice(list, func(i, j int) bool {
+			if list[i].Count != list[j].Count {
+				return list[i].Count > list[j].Count
+			}
+			return list[i].Label < list[j].Label
+		})
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success":    true,
+			"places":     list,
+			"located":    located,    // files with a position
+			"unresolved": unresolved, // of those, not (yet) geocoded
+			"geocoder":   geocoder != nil,
+		})
+	}).Methods("GET")
+}
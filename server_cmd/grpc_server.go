@@ -0,0 +1,232 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/keepalive"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+
+	pb "photo_sync_server/photosyncpb"
+)
+
+// maxGRPCMessageSize bounds one gRPC message; clients send uploads in smaller chunks
+const maxGRPCMessageSize = 16 * 1024 * 1024
+
+// photoSyncService implements the PhotoSync gRPC service on the storage used by the TCP protocol
+type photoSyncService struct {
+	pb.UnimplementedPhotoSyncServer
+	config *Config
+}
+
+func (s *photoSyncService) baseDir() string {
+	if s.config.ReceiveDir == "" {
+		return "received"
+	}
+	return s.config.ReceiveDir
+}
+
+// phoneDir resolves a call's device to its phone directory, registering the device like
+// REGISTER_DEVICE or, without an ID, using the name like SET_PHONE_NAME
+func (s *photoSyncService) phoneDir(dev *pb.Device) (string, error) {
+	baseDir := s.baseDir()
+	name := dev.GetName()
+	accessID := dev.GetDeviceId()
+	if accessID == "" {
+		if err := validatePhoneName(name); err != nil {
+			return "", status.Errorf(codes.InvalidArgument, "device id or a valid phone name is required: %v", err)
+		}
+		accessID = nameDevicePrefix + name
+	}
+	access, err := deviceAccess(s.config, baseDir, accessID, name, dev.GetToken())
+	if err != nil {
+		return "", status.Error(codes.InvalidArgument, err.Error())
+	}
+	if access != deviceApproved {
+		return "", status.Errorf(codes.PermissionDenied, "device is %s, see the server's devices page", access)
+	}
+	if dev.GetDeviceId() != "" {
+		rec, err := registerDevice(baseDir, dev.GetDeviceId(), name)
+		if err != nil {
+			return "", status.Error(codes.InvalidArgument, err.Error())
+		}
+		dir := filepath.Join(baseDir, rec.Dir)
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return "", status.Errorf(codes.Internal, "create receive dir: %v", err)
+		}
+		if tz := dev.GetTimeZone(); tz != "" {
+			if err := openCatalog(dir).SetTimeZone(tz); err != nil {
+				log.Printf("gRPC: ignoring time zone %q from device %s: %v", tz, rec.ID, err)
+			}
+		}
+		return dir, nil
+	}
+
+	dir := filepath.Join(baseDir, name)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", status.Errorf(codes.Internal, "create receive dir: %v", err)
+	}
+	return dir, nil
+}
+
+// UploadMedia receives a header and the file's bytes, then stores them like a TCP upload
+func (s *photoSyncService) UploadMedia(stream pb.PhotoSync_UploadMediaServer) error {
+	first, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+	header := first.GetHeader()
+	if header == nil {
+		return status.Error(codes.InvalidArgument, "the first message must be the upload header")
+	}
+	id := header.GetId()
+	if id == "" || header.GetMedia() == "" || strings.Contains(id, "..") || filepath.IsAbs(id) {
+		return status.Error(codes.InvalidArgument, "invalid media id or type")
+	}
+	if header.GetSize() < 0 || header.GetSize() > maxPayloadSize(s.config) {
+		return status.Errorf(codes.InvalidArgument, "size must be between 0 and %d bytes", maxPayloadSize(s.config))
+	}
+	recvDir, err := s.phoneDir(header.GetDevice())
+	if err != nil {
+		return err
+	}
+
+	remote := "grpc"
+	if p, ok := peer.FromContext(stream.Context()); ok {
+		remote = p.Addr.String()
+	}
+	session := newSyncSession(remote, "grpc")
+	defer session.close()
+	session.setPhone(filepath.Base(recvDir))
+
+	data := make([]byte, 0, header.GetSize())
+	for {
+		msg, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		chunk := msg.GetChunk()
+		if int64(len(data)+len(chunk)) > header.GetSize() {
+			return stream.SendAndClose(ackResponse(errorAck(ackKindFile, id, ackCodeSize,
+				fmt.Errorf("more than the announced %d bytes", header.GetSize()))))
+		}
+		data = append(data, chunk...)
+	}
+
+	if int64(len(data)) != header.GetSize() {
+		return stream.SendAndClose(ackResponse(errorAck(ackKindFile, id, ackCodeSize,
+			fmt.Errorf("received %d of %d bytes", len(data), header.GetSize()))))
+	}
+	if header.GetSha256() != "" && !checksumMatches(data, header.GetSha256()) {
+		return stream.SendAndClose(ackResponse(errorAck(ackKindFile, id, ackCodeChecksum,
+			fmt.Errorf("sha256 mismatch"))))
+	}
+
+	countFeature("grpc_upload")
+	ack := storeReceivedFile(s.config, s.baseDir(), recvDir, session, id, header.GetMedia(), header.GetTaken(), "", data)
+	return stream.SendAndClose(ackResponse(ack))
+}
+
+// ackResponse converts a file ACK into the UploadMedia response
+func ackResponse(ack Ack) *pb.UploadMediaResponse {
+	return &pb.UploadMediaResponse{Id: ack.ID, Code: ack.Code, Error: ack.Message}
+}
+
+// ListMissing is the HAVE_LIST exchange
+func (s *photoSyncService) ListMissing(ctx context.Context, req *pb.ListMissingRequest) (*pb.ListMissingResponse, error) {
+	recvDir, err := s.phoneDir(req.GetDevice())
+	if err != nil {
+		return nil, err
+	}
+	items := make([]HaveItem, 0, len(req.GetItems()))
+	for _, it := range req.GetItems() {
+		items = append(items, HaveItem{ID: it.GetId(), Media: it.GetMedia(), Size: it.GetSize(), SHA256: it.GetSha256()})
+	}
+
+	countFeature("have_list")
+	resp := &pb.ListMissingResponse{}
+	for _, it := range findMissingMedia(recvDir, items) {
+		resp.Missing = append(resp.Missing, &pb.MediaItem{Id: it.ID, Media: it.Media, Size: it.Size, Sha256: it.SHA256})
+	}
+	return resp, nil
+}
+
+// DeleteMedia moves originals to the trash and removes their thumbnails; IDs may be given with or without extension
+func (s *photoSyncService) DeleteMedia(ctx context.Context, req *pb.DeleteMediaRequest) (*pb.DeleteMediaResponse, error) {
+	phoneDir, err := s.phoneDir(req.GetDevice())
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &pb.DeleteMediaResponse{}
+	for _, id := range req.GetIds() {
+		if _, ok := trashMediaItem(phoneDir, id); !ok {
+			resp.NotFound = append(resp.NotFound, id)
+			continue
+		}
+		resp.Deleted++
+	}
+	return resp, nil
+}
+
+// GetThumbnails streams one page of thumbnails, as the thumbnail list message does
+func (s *photoSyncService) GetThumbnails(req *pb.GetThumbnailsRequest, stream pb.PhotoSync_GetThumbnailsServer) error {
+	phoneDir, err := s.phoneDir(req.GetDevice())
+	if err != nil {
+		return err
+	}
+	err = eachThumbnailPaged(phoneDir, thumbListFilter{}, int(req.GetPageIndex()), int(req.GetPageSize()), func(t thumbnailItem) error {
+		return stream.Send(&pb.Thumbnail{Id: t.ID, Media: t.Media, Data: t.Data})
+	})
+	if err != nil && status.Code(err) == codes.Unknown {
+		return status.Error(codes.Internal, err.Error())
+	}
+	return err
+}
+
+// startGRPCServer serves the PhotoSync gRPC service on config.GrpcPort
+func startGRPCServer(config *Config) error {
+	listener, err := net.Listen("tcp", config.GrpcPort)
+	if err != nil {
+		return fmt.Errorf("failed to start gRPC server: %v", err)
+	}
+
+	server := grpc.NewServer(
+		grpc.MaxRecvMsgSize(maxGRPCMessageSize),
+		grpc.KeepaliveParams(keepalive.ServerParameters{MaxConnectionIdle: idleTimeout(config)}),
+	)
+	pb.RegisterPhotoSyncServer(server, &photoSyncService{config: config})
+
+	// Let running calls finish, then cut off whatever outlasts the grace period
+	onShutdown(func(ctx context.Context) {
+		stopped := make(chan struct{})
+		go func() {
+			server.GracefulStop()
+			close(stopped)
+		}()
+		select {
+		case <-stopped:
+		case <-ctx.Done():
+			server.Stop()
+		}
+	})
+
+	log.Printf("gRPC Server listening on port%s\n", config.GrpcPort)
+	if err := server.Serve(listener); err != nil && !errors.Is(err, grpc.ErrServerStopped) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"strconv"
+	"strings"
+)
+
+const (
+	hardwareProfileStandard = "standard"
+	hardwareProfileSmall    = "small"
+
+	// Boards below these amounts of memory get the small profile: any machine under
+	// smallMemoryBytes, and ARM boards (Raspberry Pis, NAS boxes) under smallARMMemoryBytes,
+	// as their swap on an SD card or eMMC is no help during a big sync
+	smallMemoryBytes    = 2 << 30
+	smallARMMemoryBytes = 4 << 30
+)
+
+// hardwareProfile clamps the server's appetite for memory and CPU to the machine. The
+// standard profile leaves everything at the defaults; the small one keeps a big sync from
+// getting the server killed for running out of memory on a small board.
+type hardwareProfile struct {
+	Name        string `json:"name"`
+	Reason      string `json:"reason"` // how the profile was chosen, for the admin page
+	Arch        string `json:"arch"`
+	CPUs        int    `json:"cpus"`
+	MemoryBytes uint64 `json:"memoryBytes"` // total memory, or the cgroup limit; 0 if unknown
+
+	MaxPayloadBytes  int64  `json:"maxPayloadBytes,omitempty"`  // replaces the default max payload, unless max_payload_mb is set
+	ChunkSize        int    `json:"chunkSize,omitempty"`        // chunk size suggested to clients in HELLO
+	ThumbnailWorkers int    `json:"thumbnailWorkers"`           // thumbnails of one phone generated at once, by its background run and on demand together
+	ThumbnailRuns    int    `json:"thumbnailRuns,omitempty"`    // phones thumbnailed in the background at once, 0 for no limit
+	X264Preset       string `json:"x264Preset,omitempty"`       // replaces the libx264 presets of video encodes
+	X264Threads      int    `json:"x264Threads,omitempty"`      // encoder threads, 0 for all cores
+	MemoryLimitBytes int64  `json:"memoryLimitBytes,omitempty"` // soft limit for the Go heap, 0 for none
+}
+
+// hardware is the profile in effect, set from the config at startup
+var hardware = standardHardwareProfile()
+
+func standardHardwareProfile() hardwareProfile {
+	return hardwareProfile{Name: hardwareProfileStandard, Arch: runtime.GOARCH, CPUs: runtime.NumCPU(), ThumbnailWorkers: runtime.NumCPU()}
+}
+
+// smallHardwareProfile sizes the limits for a board with memory bytes of RAM
+func smallHardwareProfile(memory uint64) hardwareProfile {
+	p := standardHardwareProfile()
+	p.Name = hardwareProfileSmall
+	p.MaxPayloadBytes = 64 << 20 // larger files are sent chunked, straight to disk
+	p.ChunkSize = batteryChunkSize
+	p.ThumbnailWorkers = max(1, min(2, p.CPUs/2))
+	p.ThumbnailRuns = 1
+	p.X264Preset = "veryfast"
+	p.X264Threads = 2
+	if memory > 0 {
+		// Leave room for ffmpeg and the page cache next to the heap
+		p.MemoryLimitBytes = int64(memory / 2)
+	}
+	return p
+}
+
+// detectHardwareProfile picks the profile for this machine. name is the configured
+// hardware_profile: auto (or empty), standard or small.
+func detectHardwareProfile(name string) (hardwareProfile, error) {
+	memory := totalMemoryBytes()
+	arm := runtime.GOARCH == "arm" || runtime.GOARCH == "arm64"
+
+	var p hardwareProfile
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "", "auto":
+		switch {
+		case builtinHardwareProfile != "":
+			p = smallHardwareProfile(memory)
+			p.Reason = "built with the " + builtinHardwareProfile + " profile"
+		case memory > 0 && memory < smallMemoryBytes:
+			p = smallHardwareProfile(memory)
+			p.Reason = "detected, less than " + formatBytes(smallMemoryBytes) + " of memory"
+		case arm && memory > 0 && memory < smallARMMemoryBytes:
+			p = smallHardwareProfile(memory)
+			p.Reason = "detected, ARM board with less than " + formatBytes(smallARMMemoryBytes) + " of memory"
+		default:
+			p = standardHardwareProfile()
+			p.Reason = "detected"
+		}
+	case hardwareProfileStandard:
+		p = standardHardwareProfile()
+		p.Reason = "set in the config"
+	case hardwareProfileSmall:
+		p = smallHardwareProfile(memory)
+		p.Reason = "set in the config"
+	default:
+		return standardHardwareProfile(), fmt.Errorf("unknown hardware_profile %q, use auto, standard or small", name)
+	}
+	p.MemoryBytes = memory
+	return p, nil
+}
+
+// setHardwareProfile selects the profile from the config and applies its limits that are
+// fixed at startup; an invalid value keeps the standard profile
+func setHardwareProfile(config *Config) error {
+	p, err := detectHardwareProfile(config.HardwareProfile)
+	hardware = p
+	thumbnailRunWorkers = p.ThumbnailWorkers
+	thumbnailRunSlots = nil
+	if p.ThumbnailRuns > 0 {
+		thumbnailRunSlots = make(chan struct{}, p.ThumbnailRuns)
+	}
+	if p.MemoryLimitBytes > 0 {
+		debug.SetMemoryLimit(p.MemoryLimitBytes)
+	}
+	log.Printf("Hardware profile: %s\n", p.Summary())
+	return err
+}
+
+// Summary describes the profile in one line, e.g.
+// "small (detected, ...): arm64, 4 CPUs, 1.0 GB memory"
+func (p hardwareProfile) Summary() string {
+	s := fmt.Sprintf("%s (%s): %s, %d CPUs", p.Name, p.Reason, p.Arch, p.CPUs)
+	if p.MemoryBytes > 0 {
+		s += ", " + formatBytes(int64(p.MemoryBytes)) + " memory"
+	}
+	return s
+}
+
+// Clamps lists what the profile limits, for the admin page; empty for the standard profile
+func (p hardwareProfile) Clamps() []string {
+	var clamps []string
+	if p.MaxPayloadBytes > 0 {
+		clamps = append(clamps, fmt.Sprintf("single uploads up to %d MB, larger ones chunked", p.MaxPayloadBytes>>20))
+	}
+	if p.ChunkSize > 0 {
+		clamps = append(clamps, fmt.Sprintf("%d KB chunks suggested to phones", p.ChunkSize>>10))
+	}
+	if p.ThumbnailWorkers < p.CPUs {
+		clamps = append(clamps, fmt.Sprintf("%d thumbnail(s) per phone generated at once", p.ThumbnailWorkers))
+	}
+	if p.ThumbnailRuns > 0 {
+		clamps = append(clamps, fmt.Sprintf("%d phone(s) thumbnailed in the background at once", p.ThumbnailRuns))
+	}
+	if p.X264Preset != "" {
+		clamps = append(clamps, fmt.Sprintf("video encodes with the %s preset on %d threads", p.X264Preset, p.X264Threads))
+	}
+	if p.MemoryLimitBytes > 0 {
+		clamps = append(clamps, "memory soft limit "+formatBytes(p.MemoryLimitBytes))
+	}
+	return clamps
+}
+
+// x264Preset returns the libx264 preset for an encode that would normally use preferred
+func x264Preset(preferred string) string {
+	if hardware.X264Preset != "" {
+		return hardware.X264Preset
+	}
+	return preferred
+}
+
+// x264Threads returns the -threads value for video encodes
+func x264Threads() string {
+	return strconv.Itoa(hardware.X264Threads)
+}
+
+// totalMemoryBytes returns the memory available to the server: the cgroup limit when one
+// is set (containers on a NAS), otherwise the machine's total. It is 0 where neither is
+// known, e.g. outside Linux.
+func totalMemoryBytes() uint64 {
+	var total uint64
+	if f, err := os.Open("/proc/meminfo"); err == nil {
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			fields := strings.Fields(scanner.Text())
+			if len(fields) >= 2 && fields[0] == "MemTotal:" {
+				if kb, err := strconv.ParseUint(fields[1], 10, 64); err == nil {
+					total = kb << 10
+				}
+				break
+			}
+		}
+		f.Close()
+	}
+	for _, path := range []string{"/sys/fs/cgroup/memory.max", "/sys/fs/cgroup/memory/memory.limit_in_bytes"} {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		// "max", or a huge number for no limit
+		if limit, err := strconv.ParseUint(strings.TrimSpace(string(b)), 10, 64); err == nil && limit > 0 && (total == 0 || limit < total) {
+			total = limit
+		}
+		break
+	}
+	return total
+}
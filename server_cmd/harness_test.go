@@ -0,0 +1,671 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// harnessTimeout bounds each step of a harness exchange, so a broken server fails a check
+// instead of hanging it
+const harnessTimeout = 10 * time.Second
+
+// harness runs the TCP, UDP and HTTP servers on ephemeral loopback ports against a
+// scratch receive directory, for end-to-end checks of sync, thumbnailing and the web UI
+// without touching the configured ports or library. It installs a fake clock and fake
+// external tools for the whole process, so only one harness runs at a time.
+type harness struct {
+	config  *Config
+	clock   *fakeClock
+	tools   *fakeTools
+	tcp     net.Listener
+	udp     *net.UDPConn
+	http    *http.Server
+	httpURL string // base URL of the web UI, without a trailing slash
+	wg      sync.WaitGroup
+}
+
+// startHarness serves a fresh library in dir. configure, when set, adjusts the config
+// before the servers start; the ports it sets are ignored.
+func startHarness(dir string, configure func(*Config)) (*harness, error) {
+	config := &Config{ServerName: "harness", ReceiveDir: dir}
+	if configure != nil {
+		configure(config)
+	}
+	config.QuicPort, config.GrpcPort, config.HttpsPort = "", "", ""
+
+	h := &harness{config: config, clock: newFakeClock(time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)), tools: newFakeTools()}
+	clock, tools = h.clock, h.tools
+	var err error
+	if h.tcp, err = net.Listen("tcp", "127.0.0.1:0"); err != nil {
+		h.restore()
+		return nil, fmt.Errorf("failed to start TCP server: %v", err)
+	}
+	if h.udp, err = net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}); err != nil {
+		h.restore()
+		h.tcp.Close()
+		return nil, fmt.Errorf("failed to start UDP server: %v", err)
+	}
+	httpListener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		h.restore()
+		h.tcp.Close()
+		h.udp.Close()
+		return nil, fmt.Errorf("failed to start HTTP server: %v", err)
+	}
+
+	// Discovery replies advertise the ports actually bound
+	config.TcpPort = fmt.Sprintf(":%d", h.tcp.Addr().(*net.TCPAddr).Port)
+	config.UdpPort = fmt.Sprintf(":%d", h.udp.LocalAddr().(*net.UDPAddr).Port)
+	config.HttpPort = fmt.Sprintf(":%d", httpListener.Addr().(*net.TCPAddr).Port)
+	h.httpURL = "http://" + httpListener.Addr().String()
+	h.http = &http.Server{Handler: newHTTPRouter(config)}
+
+	loopback := &NetworkInfo{IP: net.IPv4(127, 0, 0, 1), Broadcast: net.IPv4(127, 0, 0, 1)}
+	h.wg.Add(3)
+	go func() {
+		defer h.wg.Done()
+		serveTCP(h.tcp, config)
+	}()
+	go func() {
+		defer h.wg.Done()
+		serveUDP(h.udp, config, loopback)
+	}()
+	go func() {
+		defer h.wg.Done()
+		if err := h.http.Serve(httpListener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Printf("Harness HTTP server error: %v\n", err)
+		}
+	}()
+	return h, nil
+}
+
+// Close stops the servers and the phones' background work, writes pending catalog
+// changes and puts the real clock and tools back. Connections already accepted finish on
+// their own.
+func (h *harness) Close() {
+	h.tcp.Close()
+	h.udp.Close()
+	h.http.Close()
+	h.wg.Wait()
+	stopPhoneJobs(time.Now().Add(harnessTimeout))
+	flushCatalogs()
+	h.restore()
+}
+
+func (h *harness) restore() {
+	clock, tools = systemClock{}, execTools{}
+}
+
+// get fetches path from the web UI and returns the body of a 200 response
+func (h *harness) get(path string) ([]byte, error) {
+	client := &http.Client{Timeout: harnessTimeout}
+	resp, err := client.Get(h.httpURL + path)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %s: %s", path, resp.Status)
+	}
+	return body, nil
+}
+
+// discover sends a UDP query to the harness and returns the first reply
+func (h *harness) discover(query string) (string, error) {
+	conn, err := net.DialUDP("udp", nil, h.udp.LocalAddr().(*net.UDPAddr))
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(harnessTimeout))
+	if _, err := conn.Write([]byte(query)); err != nil {
+		return "", err
+	}
+	buffer := make([]byte, 64*1024)
+	n, err := conn.Read(buffer)
+	if err != nil {
+		return "", err
+	}
+	return string(buffer[:n]), nil
+}
+
+// harnessClient is a scripted phone speaking the framed sync protocol
+type harnessClient struct {
+	conn net.Conn
+}
+
+// dial connects a scripted phone to the harness's sync port
+func (h *harness) dial() (*harnessClient, error) {
+	conn, err := net.DialTimeout("tcp", h.tcp.Addr().String(), harnessTimeout)
+	if err != nil {
+		return nil, err
+	}
+	return &harnessClient{conn: conn}, nil
+}
+
+func (c *harnessClient) Close() error {
+	return c.conn.Close()
+}
+
+// send writes one frame; v is sent as is when it's a string or []byte, as JSON otherwise
+func (c *harnessClient) send(msgType byte, v interface{}) error {
+	var payload []byte
+	switch p := v.(type) {
+	case []byte:
+		payload = p
+	case string:
+		payload = []byte(p)
+	default:
+		var err error
+		if payload, err = json.Marshal(v); err != nil {
+			return err
+		}
+	}
+	c.conn.SetWriteDeadline(time.Now().Add(harnessTimeout))
+	return writeMessage(c.conn, msgType, payload)
+}
+
+// receive reads the next frame from the server
+func (c *harnessClient) receive() (byte, []byte, error) {
+	c.conn.SetReadDeadline(time.Now().Add(harnessTimeout))
+	header := make([]byte, 5)
+	if _, err := io.ReadFull(c.conn, header); err != nil {
+		return 0, nil, err
+	}
+	payload := make([]byte, binary.BigEndian.Uint32(header[1:5]))
+	if _, err := io.ReadFull(c.conn, payload); err != nil {
+		return 0, nil, err
+	}
+	return header[0], payload, nil
+}
+
+// expect reads the next frame, which must be of msgType, and decodes it into v unless v is nil
+func (c *harnessClient) expect(msgType byte, v interface{}) error {
+	got, payload, err := c.receive()
+	if err != nil {
+		return err
+	}
+	if got != msgType {
+		return fmt.Errorf("expected %s, got %s: %.200s", getMsgTypeName(msgType), getMsgTypeName(got), payload)
+	}
+	if v == nil {
+		return nil
+	}
+	return json.Unmarshal(payload, v)
+}
+
+// hello negotiates features; json_ack is always requested so uploads return structured ACKs
+func (c *harnessClient) hello(features ...string) (HelloResponse, error) {
+	var resp HelloResponse
+	req := HelloRequest{Version: protocolVersion, Features: append([]string{"json_ack"}, features...), Client: "harness"}
+	if err := c.send(msgTypeHello, req); err != nil {
+		return resp, err
+	}
+	err := c.expect(msgTypeHello, &resp)
+	return resp, err
+}
+
+// upload sends a file as a single IMAGE_DATA or VIDEO_DATA message and returns its ACK
+func (c *harnessClient) upload(id, media string, data []byte) (Ack, error) {
+	msgType := msgTypeImageData
+	if hasExtension("."+media, videoExtensions) {
+		msgType = msgTypeVideoData
+	}
+	var ack Ack
+	req := map[string]string{"id": id, "media": media, "data": base64.StdEncoding.EncodeToString(data), "sha256": fmt.Sprintf("%x", sha256.Sum256(data))}
+	if err := c.send(msgType, req); err != nil {
+		return ack, err
+	}
+	err := c.expect(msgTypeAck, &ack)
+	return ack, err
+}
+
+// harnessJPEG encodes a small gradient photo; seed varies the content, and so the hash
+func harnessJPEG(seed int) []byte {
+	img := image.NewRGBA(image.Rect(0, 0, 320, 240))
+	for y := 0; y < 240; y++ {
+		for x := 0; x < 320; x++ {
+			img.Set(x, y, color.RGBA{uint8(x + seed), uint8(y), uint8(seed * 37), 255})
+		}
+	}
+	var buf bytes.Buffer
+	jpeg.Encode(&buf, img, &jpeg.Options{Quality: 80})
+	return buf.Bytes()
+}
+
+// waitForFile polls for a file written in the background, like a thumbnail
+func waitForFile(path string) error {
+	for deadline := time.Now().Add(harnessTimeout); time.Now().Before(deadline); time.Sleep(100 * time.Millisecond) {
+		if _, err := os.Stat(path); err == nil {
+			return nil
+		}
+	}
+	return fmt.Errorf("%s not written within %v", path, harnessTimeout)
+}
+
+// TestHarness starts a harness on a temporary library and walks through a phone sync, the
+// resulting thumbnails, transfer expiry, the web UI and discovery, one subtest per check.
+// Time and external tools are faked, so it needs neither ffmpeg nor waiting. The checks
+// build on each other and run in order.
+func TestHarness(t *testing.T) {
+	dir := t.TempDir()
+	h, err := startHarness(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+
+	const phone = "SelfTest"
+	check := func(name string, fn func() error) {
+		t.Run(name, func(t *testing.T) {
+			if err := fn(); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+
+	check("sync", func() error {
+		c, err := h.dial()
+		if err != nil {
+			return err
+		}
+		defer c.Close()
+		if _, err := c.hello("sync_summary"); err != nil {
+			return fmt.Errorf("hello: %v", err)
+		}
+		if err := c.send(msgTypeSetPhoneName, phone); err != nil {
+			return err
+		}
+		photo := harnessJPEG(1)
+		for _, want := range []string{ackCodeOK, ackCodeDuplicate} {
+			ack, err := c.upload("IMG_0001", "jpg", photo)
+			if err != nil {
+				return err
+			}
+			if ack.Code != want {
+				return fmt.Errorf("upload ACK %s (%s), expected %s", ack.Code, ack.Message, want)
+			}
+		}
+		if err := c.send(msgTypeSyncComplete, nil); err != nil {
+			return err
+		}
+		var summary SyncSummary
+		if err := c.expect(msgTypeSyncSummary, &summary); err != nil {
+			return err
+		}
+		if summary.FilesReceived != 1 || summary.Duplicates != 1 || summary.FailedCount != 0 {
+			return fmt.Errorf("summary reports %d received, %d duplicates, %d failed",
+				summary.FilesReceived, summary.Duplicates, summary.FailedCount)
+		}
+		e, ok := openCatalog(filepath.Join(dir, phone)).Entry(filepath.Join(dir, phone, "IMG_0001.jpg"))
+		if !ok || e.Source == nil || e.Source.Via != "tcp" || e.Source.ClientID != "IMG_0001" || e.Source.Session == "" {
+			return fmt.Errorf("IMG_0001.jpg has no provenance recorded: %+v", e.Source)
+		}
+		return nil
+	})
+
+	check("thumbnail", func() error {
+		return waitForFile(filepath.Join(dir, phone, "thumbnails", thumbnailName("IMG_0001.jpg")))
+	})
+
+	check("video thumbnail", func() error {
+		// The fake ffmpeg "extracts" a frame by writing a photo to its output path
+		h.tools.Handle("ffmpeg", func(args []string) ([]byte, error) {
+			return nil, os.WriteFile(args[len(args)-1], harnessJPEG(2), 0o644)
+		})
+		c, err := h.dial()
+		if err != nil {
+			return err
+		}
+		defer c.Close()
+		if _, err := c.hello(); err != nil {
+			return fmt.Errorf("hello: %v", err)
+		}
+		if err := c.send(msgTypeSetPhoneName, phone); err != nil {
+			return err
+		}
+		if ack, err := c.upload("VID_0001", "mp4", []byte("not really a video")); err != nil || ack.Code != ackCodeOK {
+			return fmt.Errorf("upload ACK %+v, %v", ack, err)
+		}
+		if err := c.send(msgTypeSyncComplete, nil); err != nil {
+			return err
+		}
+		thumb := filepath.Join(dir, phone, "thumbnails", thumbnailName("VID_0001.mp4"))
+		if err := waitForFile(thumb); err != nil {
+			return err
+		}
+		if len(h.tools.Calls("ffmpeg")) == 0 {
+			return fmt.Errorf("thumbnail written without running ffmpeg")
+		}
+		return nil
+	})
+
+	check("stale transfer", func() error {
+		c, err := h.dial()
+		if err != nil {
+			return err
+		}
+		defer c.Close()
+		if _, err := c.hello(); err != nil {
+			return fmt.Errorf("hello: %v", err)
+		}
+		if err := c.send(msgTypeSetPhoneName, phone); err != nil {
+			return err
+		}
+		start := map[string]interface{}{"id": "VID_0002.mp4", "media": "mp4", "totalSize": 10, "chunkSize": 5, "totalChunks": 2}
+		if err := c.send(msgTypeChunkedVideoStart, start); err != nil {
+			return err
+		}
+		var ack Ack
+		if err := c.expect(msgTypeAck, &ack); err != nil || ack.Code != ackCodeOK {
+			return fmt.Errorf("start ACK %+v, %v", ack, err)
+		}
+		temps, _ := filepath.Glob(filepath.Join(dir, phone, ".chunked_*.tmp"))
+		if len(temps) != 1 {
+			return fmt.Errorf("%d temp files for the transfer", len(temps))
+		}
+
+		// Any message after the timeout drops the transfer; the ping's echo means it was handled
+		h.clock.Advance(staleTransferTimeout(h.config) + time.Minute)
+		if err := c.send(msgTypePing, "stale?"); err != nil {
+			return err
+		}
+		if err := c.expect(msgTypePing, nil); err != nil {
+			return err
+		}
+		if _, err := os.Stat(temps[0]); !os.IsNotExist(err) {
+			return fmt.Errorf("temp file %s kept after the transfer went stale", temps[0])
+		}
+		return nil
+	})
+
+	check("delta", func() error {
+		c, err := h.dial()
+		if err != nil {
+			return err
+		}
+		defer c.Close()
+		if _, err := c.hello("delta"); err != nil {
+			return fmt.Errorf("hello: %v", err)
+		}
+		if err := c.send(msgTypeSetPhoneName, phone); err != nil {
+			return err
+		}
+		old := make([]byte, 400)
+		for i := range old {
+			old[i] = byte(i*7 + i/13)
+		}
+		if ack, err := c.upload("VID_0003.mp4", "mp4", old); err != nil || ack.Code != ackCodeOK {
+			return fmt.Errorf("upload ACK %+v, %v", ack, err)
+		}
+
+		// An edit that shifts everything after it: only the blocks around it should be sent
+		edited := append(append(append([]byte{}, old[:100]...), "inserted"...), old[100:]...)
+		const chunkSize = 32
+		chunks := (len(edited) + chunkSize - 1) / chunkSize
+		start := DeltaStartRequest{ID: "VID_0003.mp4", Media: "mp4", TotalSize: int64(len(edited)), ChunkSize: chunkSize,
+			TotalChunks: chunks, SHA256: fmt.Sprintf("%x", sha256.Sum256(edited))}
+		for i := 0; i < chunks; i++ {
+			block := edited[i*chunkSize : min(len(edited), (i+1)*chunkSize)]
+			start.Blocks = append(start.Blocks, DeltaBlock{Weak: newRollingChecksum(block).sum(), Strong: fmt.Sprintf("%x", sha256.Sum256(block))})
+		}
+		if err := c.send(msgTypeDeltaStart, start); err != nil {
+			return err
+		}
+		var ack Ack
+		if err := c.expect(msgTypeAck, &ack); err != nil || ack.Status != ackStatusOK {
+			return fmt.Errorf("delta start ACK %+v, %v", ack, err)
+		}
+		if len(ack.Missing) == 0 || len(ack.Missing) > 2 || ack.Resumed+len(ack.Missing) != chunks {
+			return fmt.Errorf("%d of %d blocks reused, asked for %v", ack.Resumed, chunks, ack.Missing)
+		}
+		for _, i := range ack.Missing {
+			block := edited[i*chunkSize : min(len(edited), (i+1)*chunkSize)]
+			data := map[string]interface{}{"id": start.ID, "chunkIndex": i, "data": base64.StdEncoding.EncodeToString(block)}
+			if err := c.send(msgTypeChunkedVideoData, data); err != nil {
+				return err
+			}
+			if err := c.expect(msgTypeAck, &ack); err != nil || ack.Status != ackStatusOK {
+				return fmt.Errorf("chunk ACK %+v, %v", ack, err)
+			}
+		}
+		if err := c.send(msgTypeChunkedVideoComplete, map[string]string{"id": start.ID}); err != nil {
+			return err
+		}
+		if err := c.expect(msgTypeAck, &ack); err != nil || ack.Code != ackCodeOK {
+			return fmt.Errorf("complete ACK %+v, %v", ack, err)
+		}
+		stored, err := os.ReadFile(filepath.Join(dir, phone, "VID_0003.mp4"))
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(stored, edited) {
+			return fmt.Errorf("stored file differs from the edited one")
+		}
+		return nil
+	})
+
+	check("parallel phones", func() error {
+		// Phone A's first video thumbnail hangs in ffmpeg until released; phone B syncing
+		// meanwhile must neither wait for it nor cancel the rest of A's run
+		const phoneA, phoneB = "SelfTestA", "SelfTestB"
+		started, release := make(chan struct{}), make(chan struct{})
+		var once sync.Once
+		h.tools.Handle("ffmpeg", func(args []string) ([]byte, error) {
+			out := args[len(args)-1]
+			first := false
+			if bytes.Contains([]byte(out), []byte(phoneA)) {
+				once.Do(func() { first = true })
+			}
+			if first {
+				close(started)
+				select {
+				case <-release:
+				case <-time.After(harnessTimeout):
+				}
+			}
+			return nil, os.WriteFile(out, harnessJPEG(3), 0o644)
+		})
+
+		syncVideos := func(phone string, ids ...string) (*harnessClient, error) {
+			c, err := h.dial()
+			if err != nil {
+				return nil, err
+			}
+			if _, err := c.hello(); err != nil {
+				c.Close()
+				return nil, fmt.Errorf("hello: %v", err)
+			}
+			if err := c.send(msgTypeSetPhoneName, phone); err != nil {
+				c.Close()
+				return nil, err
+			}
+			for _, id := range ids {
+				if ack, err := c.upload(id, "mp4", []byte("video "+id)); err != nil || ack.Code != ackCodeOK {
+					c.Close()
+					return nil, fmt.Errorf("upload ACK %+v, %v", ack, err)
+				}
+			}
+			if err := c.send(msgTypeSyncComplete, nil); err != nil {
+				c.Close()
+				return nil, err
+			}
+			return c, nil
+		}
+
+		a, err := syncVideos(phoneA, "VID_A1", "VID_A2")
+		if err != nil {
+			return err
+		}
+		defer a.Close()
+		select {
+		case <-started:
+		case <-time.After(harnessTimeout):
+			return fmt.Errorf("%s's thumbnail run didn't start", phoneA)
+		}
+
+		b, err := syncVideos(phoneB, "VID_B1")
+		if err != nil {
+			return err
+		}
+		defer b.Close()
+		if err := waitForFile(filepath.Join(dir, phoneB, "thumbnails", thumbnailName("VID_B1.mp4"))); err != nil {
+			return fmt.Errorf("%s waited for %s: %v", phoneB, phoneA, err)
+		}
+
+		close(release)
+		for _, name := range []string{"VID_A1.mp4", "VID_A2.mp4"} {
+			if err := waitForFile(filepath.Join(dir, phoneA, "thumbnails", thumbnailName(name))); err != nil {
+				return fmt.Errorf("%s's run didn't finish: %v", phoneA, err)
+			}
+		}
+		return nil
+	})
+
+	check("id sandbox", func() error {
+		for _, id := range []string{"IMG_0001", "Camera/IMG_0002.jpg", "2024/05/VID 3.mp4", "thumbnails"} {
+			if err := validateClientID(id); err != nil {
+				return fmt.Errorf("%q rejected: %v", id, err)
+			}
+		}
+		for _, id := range []string{"", "../escape", "a/../../b", "/tmp/abs", "C:\\x", "a\\b", "a//b", "a/", "./a",
+			".catalog.json", "Camera/.trash/x", "thumbnails/tbn-x.jpg", "x.jpg.partial", "CON", "nul.jpg", "a.", "x\x00y"} {
+			if validateClientID(id) == nil {
+				return fmt.Errorf("%q accepted", id)
+			}
+		}
+
+		c, err := h.dial()
+		if err != nil {
+			return err
+		}
+		defer c.Close()
+		if _, err := c.hello(); err != nil {
+			return fmt.Errorf("hello: %v", err)
+		}
+		if err := c.send(msgTypeSetPhoneName, phone); err != nil {
+			return err
+		}
+		for _, id := range []string{"../../escape", filepath.Join(dir, "absolute")} {
+			ack, err := c.upload(id, "jpg", harnessJPEG(9))
+			if err != nil {
+				return err
+			}
+			if ack.Code != ackCodeInvalid {
+				return fmt.Errorf("upload of %q ACK %s, expected %s", id, ack.Code, ackCodeInvalid)
+			}
+		}
+		for _, name := range []string{"escape.jpg", "absolute.jpg", filepath.Join(filepath.Dir(dir), "escape.jpg")} {
+			if !filepath.IsAbs(name) {
+				name = filepath.Join(dir, name)
+			}
+			if _, err := os.Stat(name); err == nil {
+				return fmt.Errorf("%s written outside the phone directory", name)
+			}
+		}
+		return nil
+	})
+
+	check("web", func() error {
+		home, err := h.get("/")
+		if err != nil {
+			return err
+		}
+		if !bytes.Contains(home, []byte(phone)) {
+			return fmt.Errorf("home page doesn't list %s", phone)
+		}
+		items, err := h.get("/api/phones/" + phone + "/items")
+		if err != nil {
+			return err
+		}
+		if !bytes.Contains(items, []byte("IMG_0001.jpg")) {
+			return fmt.Errorf("item list doesn't include IMG_0001.jpg: %.200s", items)
+		}
+		// Everything the pages use is served by the server itself, so it works offline
+		page, err := h.get("/phone/" + phone)
+		if err != nil {
+			return err
+		}
+		for _, p := range [][]byte{home, page} {
+			if m := regexp.MustCompile(`(src|href)="(https?:)?//|url\((https?:)?//|@import`).Find(p); m != nil {
+				return fmt.Errorf("page loads an external resource: %s", m)
+			}
+		}
+		return nil
+	})
+
+	check("remote export", func() error {
+		var listed []byte
+		h.tools.Handle("rsync", func(args []string) ([]byte, error) {
+			for _, arg := range args {
+				if list, ok := strings.CutPrefix(arg, "--files-from="); ok {
+					var err error
+					listed, err = os.ReadFile(list)
+					return nil, err
+				}
+			}
+			return nil, fmt.Errorf("no --files-from")
+		})
+		h.config.RemoteExport = &RemoteExportConfig{Target: "archive@offsite:/photos", Phones: []string{phone}}
+		defer func() { h.config.RemoteExport = nil }()
+		if err := checkRemoteExport(h.config); err != nil {
+			return err
+		}
+		if err := runRemoteExport(h.config); err != nil {
+			return err
+		}
+		calls := h.tools.Calls("rsync")
+		if len(calls) != 1 || !bytes.Contains(listed, []byte("IMG_0001.jpg")) {
+			return fmt.Errorf("%d rsync run(s), file list %q", len(calls), listed)
+		}
+		if dest := calls[0].Args[len(calls[0].Args)-1]; dest != "archive@offsite:/photos/"+phone+"/" {
+			return fmt.Errorf("pushed to %s", dest)
+		}
+		// Nothing new since: nothing to push
+		if err := runRemoteExport(h.config); err != nil {
+			return err
+		}
+		if n := len(h.tools.Calls("rsync")); n != 1 {
+			return fmt.Errorf("pushed again with nothing new (%d rsync runs)", n)
+		}
+		return nil
+	})
+
+	check("discovery", func() error {
+		reply, err := h.discover(discoveryQuery)
+		if err != nil {
+			return err
+		}
+		var info DiscoveryResponse
+		if err := json.Unmarshal([]byte(reply), &info); err != nil {
+			return fmt.Errorf("reply %q: %v", reply, err)
+		}
+		if info.Service != "photo_server" || info.Name != "harness" || info.Ports.TCP != portNumber(h.config.TcpPort) {
+			return fmt.Errorf("unexpected reply %q", reply)
+		}
+		return nil
+	})
+}
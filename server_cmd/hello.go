@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/json"
+	"sort"
+)
+
+// protocolVersion is the newest protocol version this server speaks. Version 1 is the
+// original implicit framing used by clients that never send HELLO.
+const protocolVersion = 2
+
+// serverFeatures lists the optional protocol features this server supports. New features
+// are added here as they roll out and only used once both sides have announced them.
+var serverFeatures = []string{
+	"chunking",     // CHUNKED_VIDEO_START/DATA/COMPLETE transfers
+	"checksum",     // sha256 verification with ERR:<id>:checksum retransmit ACKs
+	"dedup",        // OK:<id>:DUPLICATE ACKs for content already stored
+	"device_id",    // REGISTER_DEVICE stable identities
+	"have_list",    // HAVE_LIST/MISSING_LIST incremental sync
+	"ping",         // PING keepalive within the idle timeout
+	"client_log",   // CLIENT_LOG diagnostic reports
+	"progress",     // SYNC_PROGRESS reports pushed by the server during the sync
+	"json_ack",     // JSON ACKs {kind,id,status,code,message} instead of OK:/ERR: text
+	"batch",        // BATCH_UPLOAD archives of many small files
+	"sync_summary", // SYNC_SUMMARY report answering SYNC_COMPLETE
+	"pairing",      // REGISTER_DEVICE pairingCode exchanged for a device token
+	"encryption",   // AES-GCM payloads with the configured payload_key
+	"thumb_stream", // MEDIA_THUMB_LIST pages sent as several MEDIA_THUMB_DATA messages, the last without "more"
+	"changes",      // MEDIA_CHANGED pushed for media added or deleted on the server, see SUBSCRIBE_CHANGES
+	"delete_list",  // MEDIA_DEL_LIST deletes of server-side copies, with an optional preview/confirm step
+	"delta",        // DELTA_START re-sends of changed files, transferring only the blocks the stored copy lacks
+	"restore_list", // MEDIA_THUMB_LIST "have" manifest and "notOnClient" filter, for restoring what a device lacks
+}
+
+// HelloRequest is the client's msgTypeHello payload
+type HelloRequest struct {
+	Version  int      `json:"version"`
+	Features []string `json:"features"`
+	Client   string   `json:"client,omitempty"` // free-form client name/version, for logs
+
+	// Profile hints at a constrained client; the limits in the reply are adjusted to it
+	Profile *ClientProfile `json:"profile,omitempty"`
+}
+
+// HelloResponse is the server's msgTypeHello reply
+type HelloResponse struct {
+	Version       int          `json:"version"` // protocol version to use: the lower of both sides
+	ServerVersion string       `json:"serverVersion"`
+	ServerName    string       `json:"serverName,omitempty"`
+	Features      []string     `json:"features"` // features both sides support
+	Limits        ServerLimits `json:"limits"`
+}
+
+// ServerLimits tells clients how large their messages may be
+type ServerLimits struct {
+	MaxPayloadSize int64 `json:"maxPayloadSize"`
+	IdleTimeoutSec int   `json:"idleTimeoutSec"` // ping more often than this when otherwise quiet
+
+	// Suggestions for the client's profile: a chunk size for chunked transfers (0: the
+	// client's choice) and whether to leave videos for a later sync
+	ChunkSize  int  `json:"chunkSize,omitempty"`
+	PhotosOnly bool `json:"photosOnly,omitempty"`
+}
+
+// buildHelloResponse answers a client HELLO and returns the negotiated feature set and the
+// client's profile, if it sent one
+func buildHelloResponse(config *Config, payload []byte) ([]byte, map[string]bool, *ClientProfile, error) {
+	var req HelloRequest
+	if err := json.Unmarshal(payload, &req); err != nil {
+		return nil, nil, nil, err
+	}
+
+	offered := make(map[string]bool, len(req.Features))
+	for _, f := range req.Features {
+		offered[f] = true
+	}
+	negotiated := make(map[string]bool)
+	for _, f := range serverFeatures {
+		if f == "encryption" && (config == nil || config.PayloadKey == "") {
+			continue // nothing to agree on without a shared key
+		}
+		if offered[f] {
+			negotiated[f] = true
+		}
+	}
+
+	useVersion := protocolVersion
+	if req.Version > 0 && req.Version < useVersion {
+		useVersion = req.Version
+	}
+
+	resp := HelloResponse{
+		Version:       useVersion,
+		ServerVersion: version,
+		Features:      sortedFeatures(negotiated),
+		Limits: ServerLimits{
+			MaxPayloadSize: maxPayloadSize(config),
+			IdleTimeoutSec: int(idleTimeout(config).Seconds()),
+		},
+	}
+	if config != nil {
+		resp.ServerName = config.ServerName
+	}
+	resp.Limits.ChunkSize = hardware.ChunkSize
+	req.Profile.adjustLimits(&resp.Limits)
+	b, err := json.Marshal(resp)
+	return b, negotiated, req.Profile, err
+}
+
+// sortedFeatures returns the enabled features of a set in a stable order
+func sortedFeatures(features map[string]bool) []string {
+	list := make([]string, 0, len(features))
+	for f, ok := range features {
+		if ok {
+			list = append(list, f)
+		}
+	}
+	sort.Strings(list)
+	return list
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"path/filepath"
+
+	"github.com/gorilla/mux"
+)
+
+// writeJSON answers a request with v encoded as JSON
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeJSONStatus is writeJSON with a status code other than 200 OK
+func writeJSONStatus(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// receiveDir returns the base receive dir holding the phone directories
+func receiveDir(config *Config) string {
+	if config.ReceiveDir == "" {
+		return "received"
+	}
+	return config.ReceiveDir
+}
+
+// phoneDirFromRequest returns the directory of the phone named by the route's {phoneName}
+func phoneDirFromRequest(config *Config, r *http.Request) (string, error) {
+	return phoneDirByName(config, mux.Vars(r)["phoneName"])
+}
+
+// phoneDirByName returns the directory of a phone named in a request's body or query,
+// refusing names validatePhoneName rejects
+func phoneDirByName(config *Config, phoneName string) (string, error) {
+	if err := validatePhoneName(phoneName); err != nil {
+		return "", err
+	}
+	return filepath.Join(receiveDir(config), phoneName), nil
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	defaultHTTPReadHeaderTimeout = 10 * time.Second
+	defaultHTTPIdleTimeout       = 2 * time.Minute
+	defaultHTTPAPITimeout        = time.Minute
+	defaultHTTPTransferTimeout   = time.Hour
+	defaultHTTPMaxHeaderBytes    = 64 << 10
+	defaultHTTPMaxAPIBodyBytes   = 10 << 20
+)
+
+// HTTPLimitsConfig hardens the web server against slow or oversized requests. Pages and
+// JSON APIs get short deadlines; file downloads, uploads and the video tools get long
+// ones; the WebSocket sync has none, as it keeps its own idle timeout.
+type HTTPLimitsConfig struct {
+	ReadHeaderSec int `json:"read_header_sec"` // to receive a request's headers, default 10
+	IdleSec       int `json:"idle_sec"`        // keep-alive connections between requests, default 120
+	APISec        int `json:"api_sec"`         // to read and answer a page or API request, default 60
+	TransferMin   int `json:"transfer_min"`    // to read and answer a file transfer or video tool request, default 60
+	MaxHeaderKB   int `json:"max_header_kb"`   // default 64
+	MaxAPIBodyMB  int `json:"max_api_body_mb"` // request bodies of pages and APIs, default 10
+}
+
+// httpRouteClass sorts routes by how long their requests may take
+type httpRouteClass int
+
+const (
+	httpRouteAPI      httpRouteClass = iota // pages and JSON APIs
+	httpRouteTransfer                       // large bodies either way, or ffmpeg at work
+	httpRouteStream                         // hijacked or open-ended connections
+)
+
+// routeClasses holds the routes registered through handleTransfer and handleStream; the
+// other routes are pages and APIs
+var (
+	routeClassesMutex sync.RWMutex
+	routeClasses      = map[*mux.Route]httpRouteClass{}
+)
+
+// handleTransfer registers a route like router.HandleFunc and gives it the long transfer
+// deadline and no cap on the request body, for handlers that move whole files or run
+// ffmpeg
+func handleTransfer(router *mux.Router, path string, f func(http.ResponseWriter, *http.Request)) *mux.Route {
+	return classifyRoute(router.HandleFunc(path, f), httpRouteTransfer)
+}
+
+// handleStream registers a route like router.HandleFunc without any deadline, for
+// connections that are hijacked or stay open
+func handleStream(router *mux.Router, path string, f func(http.ResponseWriter, *http.Request)) *mux.Route {
+	return classifyRoute(router.HandleFunc(path, f), httpRouteStream)
+}
+
+func classifyRoute(route *mux.Route, class httpRouteClass) *mux.Route {
+	routeClassesMutex.Lock()
+	routeClasses[route] = class
+	routeClassesMutex.Unlock()
+	return route
+}
+
+// routeClass returns the class r's route was registered with
+func routeClass(r *http.Request) httpRouteClass {
+	route := mux.CurrentRoute(r)
+	if route == nil {
+		return httpRouteAPI
+	}
+	routeClassesMutex.RLock()
+	defer routeClassesMutex.RUnlock()
+	return routeClasses[route]
+}
+
+func httpLimits(config *Config) HTTPLimitsConfig {
+	var l HTTPLimitsConfig
+	if config != nil && config.HTTPLimits != nil {
+		l = *config.HTTPLimits
+	}
+	return l
+}
+
+func (l HTTPLimitsConfig) readHeaderTimeout() time.Duration {
+	if l.ReadHeaderSec <= 0 {
+		return defaultHTTPReadHeaderTimeout
+	}
+	return time.Duration(l.ReadHeaderSec) * time.Second
+}
+
+func (l HTTPLimitsConfig) idleTimeout() time.Duration {
+	if l.IdleSec <= 0 {
+		return defaultHTTPIdleTimeout
+	}
+	return time.Duration(l.IdleSec) * time.Second
+}
+
+func (l HTTPLimitsConfig) maxHeaderBytes() int {
+	if l.MaxHeaderKB <= 0 {
+		return defaultHTTPMaxHeaderBytes
+	}
+	return l.MaxHeaderKB << 10
+}
+
+// timeout returns the deadline of a route class, 0 for none
+func (l HTTPLimitsConfig) timeout(class httpRouteClass) time.Duration {
+	switch class {
+	case httpRouteStream:
+		return 0
+	case httpRouteTransfer:
+		if l.TransferMin <= 0 {
+			return defaultHTTPTransferTimeout
+		}
+		return time.Duration(l.TransferMin) * time.Minute
+	}
+	if l.APISec <= 0 {
+		return defaultHTTPAPITimeout
+	}
+	return time.Duration(l.APISec) * time.Second
+}
+
+func (l HTTPLimitsConfig) maxAPIBodyBytes() int64 {
+	if l.MaxAPIBodyMB <= 0 {
+		return defaultHTTPMaxAPIBodyBytes
+	}
+	return int64(l.MaxAPIBodyMB) << 20
+}
+
+// newHTTPServer returns a server for the web UI with the connection-level limits. Read and
+// write deadlines are left to routeLimits, so a video download isn't cut off by the
+// deadline meant for a JSON API.
+func newHTTPServer(config *Config, addr string, handler http.Handler) *http.Server {
+	l := httpLimits(config)
+	return &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadHeaderTimeout: l.readHeaderTimeout(), // slowloris: headers trickling in
+		IdleTimeout:       l.idleTimeout(),
+		MaxHeaderBytes:    l.maxHeaderBytes(),
+	}
+}
+
+// routeLimits is the router middleware that sets each request's read and write deadlines
+// by its route class, cancels its context when they pass, and caps the request bodies of
+// pages and APIs
+func routeLimits(config *Config) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			class := routeClass(r)
+			l := httpLimits(config)
+			timeout := l.timeout(class)
+			if timeout == 0 {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			// A writer without deadline support is still limited through the context
+			deadline := time.Now().Add(timeout)
+			rc := http.NewResponseController(w)
+			rc.SetReadDeadline(deadline)
+			rc.SetWriteDeadline(deadline)
+			ctx, cancel := context.WithDeadline(r.Context(), deadline)
+			defer cancel()
+			if class == httpRouteAPI && r.Body != nil {
+				r.Body = http.MaxBytesReader(w, r.Body, l.maxAPIBodyBytes())
+			}
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"html/template"
 	"io"
@@ -10,7 +11,6 @@ import (
 	"math/rand"
 	"net/http"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"sort"
 	"strings"
@@ -19,8 +19,19 @@ import (
 	"github.com/gorilla/mux"
 )
 
-// createVideoFromPhotos creates a video from selected photos using ffmpeg
-func createVideoFromPhotos(phoneDir string, thumbNames []string, videoName string, frameDuration float64, quality string, musicFile string) error {
+// createVideoFromPhotos creates a video from selected photos using ffmpeg, encoded with
+// the named output preset. A non-nil narration adds a voiceover that ducks the background
+// music while it speaks.
+func createVideoFromPhotos(phoneDir string, thumbNames []string, videoName string, frameDuration float64, presetName string, musicFile string, narration *NarrationOptions) error {
+	var narrationPath string
+	if narration != nil {
+		path, err := narration.resolve(phoneDir)
+		if err != nil {
+			return err
+		}
+		narrationPath = path
+	}
+
 	// Resolve thumbnail names to original photo paths
 	var photoPaths []string
 	for _, thumbName := range thumbNames {
@@ -35,9 +46,14 @@ func createVideoFromPhotos(phoneDir string, thumbNames []string, videoName strin
 		// may differ from original (e.g., HEIC originals have JPG thumbnails)
 		imageExts := []string{".jpg", ".jpeg", ".png", ".heic"}
 
+		origDir := phoneDir
+		if orig, ok := originalForThumbnail(phoneDir, thumbName); ok {
+			origDir = filepath.Dir(orig) // filed by date
+		}
+
 		foundOriginal := false
 		for _, ext := range imageExts {
-			origPath := filepath.Join(phoneDir, base+ext)
+			origPath := filepath.Join(origDir, base+ext)
 			if _, err := os.Stat(origPath); err == nil {
 				photoPaths = append(photoPaths, origPath)
 				foundOriginal = true
@@ -96,8 +112,7 @@ func createVideoFromPhotos(phoneDir string, thumbNames []string, videoName strin
 				jpegPath := filepath.Join(tempDir, fmt.Sprintf("converted_%d.jpg", i))
 
 				// Convert using heif-convert
-				cmd := exec.Command("/usr/local/bin/heif-convert", photoPath, jpegPath)
-				if output, err := cmd.CombinedOutput(); err != nil {
+				if output, err := runTool(context.Background(), heifConvertTimeout, "/usr/local/bin/heif-convert", photoPath, jpegPath); err != nil {
 					log.Printf("Warning: HEIC conversion failed for %s: %v, output: %s", photoPath, err, string(output))
 					continue
 				}
@@ -120,6 +135,14 @@ func createVideoFromPhotos(phoneDir string, thumbNames []string, videoName strin
 		return fmt.Errorf("no valid photos after conversion")
 	}
 
+	// Show each photo shorter if the preset limits the video length
+	preset := videoPresetFor(presetName)
+	frameDuration, err = preset.fitFrameDuration(frameDuration, len(processedPaths))
+	if err != nil {
+		return err
+	}
+	duration := frameDuration * float64(len(processedPaths))
+
 	// Create concat file for ffmpeg
 	concatFile := filepath.Join(tempDir, "concat.txt")
 	f, err := os.Create(concatFile)
@@ -143,29 +166,15 @@ func createVideoFromPhotos(phoneDir string, thumbNames []string, videoName strin
 	}
 	f.Close()
 
-	// Determine video resolution based on quality
-	var scale string
-	switch quality {
-	case "high":
-		scale = "1920:1080"
-	case "medium":
-		scale = "1280:720"
-	case "low":
-		scale = "854:480"
-	default:
-		scale = "1280:720"
-	}
+	// Video resolution from the preset
+	scale := preset.scale()
 
 	// Output video path
 	outputPath := filepath.Join(phoneDir, videoName+".mp4")
-	markerPath := filepath.Join(phoneDir, "."+videoName+".created")
 
 	// Create ffmpeg command with transition effects
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
-	defer cancel()
-
-	// Select BGM file from /data/music
-	musicDir := "/data/music"
+	// Select BGM file from the music library
+	musicDir := musicLibraryDir
 	var bgmPath string
 	useBGM := false
 
@@ -183,35 +192,59 @@ func createVideoFromPhotos(phoneDir string, thumbNames []string, videoName strin
 	// If no specific file was selected or file not found, use random
 	if !useBGM {
 		if musicFiles, err := os.ReadDir(musicDir); err == nil && len(musicFiles) > 0 {
-			// Filter for mp3 files only
+			// Filter for music files only
 			var mp3Files []string
 			for _, file := range musicFiles {
 				if file.IsDir() {
 					continue
 				}
-				ext := strings.ToLower(filepath.Ext(file.Name()))
-				if ext == ".mp3" {
+				if hasExtension(file.Name(), musicExtensions) {
 					mp3Files = append(mp3Files, file.Name())
 				}
 			}
 
 			if len(mp3Files) > 0 {
-				// Randomly select one mp3 file
+				// Randomly select one music file
 				rand.Seed(time.Now().UnixNano())
 				selectedFile := mp3Files[rand.Intn(len(mp3Files))]
 				bgmPath = filepath.Join(musicDir, selectedFile)
 				useBGM = true
 				log.Printf("Selected random background music: %s", selectedFile)
 			} else {
-				log.Printf("No mp3 files found in %s", musicDir)
+				log.Printf("No music files found in %s", musicDir)
 			}
 		} else {
 			log.Printf("Music directory %s not accessible or empty", musicDir)
 		}
 	}
 
+	videoFilter := fmt.Sprintf("scale=%s:force_original_aspect_ratio=decrease,pad=%s:(ow-iw)/2:(oh-ih)/2,setsar=1,fade=t=in:st=0:d=0.5,fade=t=out:st=%.2f:d=0.5", scale, scale, duration-0.5)
+
 	var args []string
-	if useBGM {
+	if narrationPath != "" {
+		// Voiceover, mixed over the ducked background music if there is any. Both audio
+		// chains run on forever (looped music, padded voice), so the length is set explicitly.
+		args = []string{
+			"-f", "concat",
+			"-safe", "0",
+			"-i", concatFile,
+			"-i", narrationPath,
+		}
+		musicInput := -1
+		if useBGM {
+			args = append(args, "-stream_loop", "-1", "-i", bgmPath)
+			musicInput = 2
+		}
+		args = append(args,
+			"-filter_complex", "[0:v]"+videoFilter+"[vout];"+narration.audioFilter(1, musicInput),
+			"-map", "[vout]",
+			"-map", "[aout]",
+			"-t", fmt.Sprintf("%.2f", duration),
+		)
+		args = append(args, preset.encoderArgs(duration, true)...)
+		args = append(args, "-y", outputPath)
+		log.Printf("Creating %s video with narration %s (background music ducked: %v)", preset.Name, narrationPath, useBGM)
+	} else if useBGM {
 		// With background music
 		args = []string{
 			"-f", "concat",
@@ -219,47 +252,31 @@ func createVideoFromPhotos(phoneDir string, thumbNames []string, videoName strin
 			"-i", concatFile,
 			"-stream_loop", "-1", // Loop the audio
 			"-i", bgmPath,
-			"-vf", fmt.Sprintf("scale=%s:force_original_aspect_ratio=decrease,pad=%s:(ow-iw)/2:(oh-ih)/2,setsar=1,fade=t=in:st=0:d=0.5,fade=t=out:st=%.2f:d=0.5", scale, scale, frameDuration*float64(len(processedPaths))-0.5),
-			"-c:v", "libx264",
-			"-preset", "faster", // Use faster preset for speed
-			"-threads", "0", // Use all available CPU cores
-			"-crf", "23",
-			"-pix_fmt", "yuv420p",
-			"-c:a", "aac",
-			"-b:a", "128k",
+			"-vf", videoFilter,
 			"-shortest", // Stop when video ends
-			"-y",
-			outputPath,
 		}
-		log.Printf("Creating video with fade transitions and background music from %s (multi-threaded)", bgmPath)
+		args = append(args, preset.encoderArgs(duration, true)...)
+		args = append(args, "-y", outputPath)
+		log.Printf("Creating %s video with fade transitions and background music from %s (multi-threaded)", preset.Name, bgmPath)
 	} else {
 		// Without background music
 		args = []string{
 			"-f", "concat",
 			"-safe", "0",
 			"-i", concatFile,
-			"-vf", fmt.Sprintf("scale=%s:force_original_aspect_ratio=decrease,pad=%s:(ow-iw)/2:(oh-ih)/2,setsar=1,fade=t=in:st=0:d=0.5,fade=t=out:st=%.2f:d=0.5", scale, scale, frameDuration*float64(len(processedPaths))-0.5),
-			"-c:v", "libx264",
-			"-preset", "faster", // Use faster preset for speed
-			"-threads", "0", // Use all available CPU cores
-			"-crf", "23",
-			"-pix_fmt", "yuv420p",
-			"-y",
-			outputPath,
-		}
-		log.Printf("Creating video with fade transitions (no background music, multi-threaded)")
+			"-vf", videoFilter,
+		}
+		args = append(args, preset.encoderArgs(duration, false)...)
+		args = append(args, "-y", outputPath)
+		log.Printf("Creating %s video with fade transitions (no background music, multi-threaded)", preset.Name)
 	}
 
-	cmd := exec.CommandContext(ctx, "ffmpeg", args...)
-	output, err := cmd.CombinedOutput()
+	output, err := runTool(context.Background(), videoCreateTimeout, "ffmpeg", args...)
 	if err != nil {
 		return fmt.Errorf("ffmpeg failed: %v, output: %s", err, string(output))
 	}
 
-	// Create marker file to indicate this video was created (not synced)
-	if err := os.WriteFile(markerPath, []byte("created"), 0644); err != nil {
-		log.Printf("Warning: failed to create marker file %s: %v", markerPath, err)
-	}
+	recordCreatedVideo(phoneDir, outputPath)
 
 	log.Printf("Video created successfully at %s", outputPath)
 	return nil
@@ -267,6 +284,40 @@ func createVideoFromPhotos(phoneDir string, thumbNames []string, videoName strin
 
 // startHTTPServer starts an HTTP server with Gorilla Mux for browsing thumbnails via web browser
 func startHTTPServer(config *Config) error {
+	router := newHTTPRouter(config)
+
+	// Validated and normalized to ":port" at startup
+	port := config.HttpPort
+
+	if config.HttpsPort != "" {
+		go func() {
+			if err := serveHTTPS(config, router); err != nil {
+				log.Printf("HTTPS Server error: %v\n", err)
+			}
+		}()
+	}
+	if config.PublicGallery != nil {
+		go func() {
+			if err := servePublicGallery(config); err != nil {
+				log.Printf("Public gallery error: %v\n", err)
+			}
+		}()
+	}
+
+	server := newHTTPServer(config, port, router)
+	onShutdown(func(ctx context.Context) { server.Shutdown(ctx) })
+	log.Printf("HTTP Server listening on port %s\n", port)
+	if wa := config.WebAuth; wa.enabled() {
+		log.Printf("Web UI sign-in required (password: %v, knock: %v, %d public album(s))\n", wa.Password != "", wa.Knock != "", len(wa.PublicAlbums))
+	}
+	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// newHTTPRouter builds the web UI and HTTP API handler, shared by the HTTP and HTTPS servers
+func newHTTPRouter(config *Config) *mux.Router {
 	router := mux.NewRouter()
 
 	// Home page - list all phone directories
@@ -282,12 +333,7 @@ func startHTTPServer(config *Config) error {
 			return
 		}
 
-		// Define preset folders that contain files, not photos
-		presetFolders := map[string]bool{
-			"music": true,
-			"data":  true,
-		}
-
+		// Preset folders contain files, not photos
 		var phoneDirs []string
 		var fileFolders []string
 		for _, e := range entries {
@@ -303,6 +349,51 @@ func startHTTPServer(config *Config) error {
 		sort.Strings(phoneDirs)
 		sort.Strings(fileFolders)
 
+		// Disk use of every phone against its quota, and the free space left
+		type phoneUsage struct {
+			Text    string
+			Limited bool
+			Percent int
+			Full    bool
+		}
+		usage := make(map[string]phoneUsage, len(phoneDirs))
+		for _, phone := range phoneDirs {
+			u := phoneQuotaUsage(config, filepath.Join(baseDir, phone))
+			pu := phoneUsage{Text: formatBytes(u.UsedBytes), Limited: u.LimitBytes > 0, Percent: u.Percent()}
+			if pu.Limited {
+				pu.Text = fmt.Sprintf("%s of %s (%d%%)", formatBytes(u.UsedBytes), formatBytes(u.LimitBytes), pu.Percent)
+				pu.Full = u.UsedBytes >= u.LimitBytes
+			}
+			usage[phone] = pu
+		}
+
+		// Originals left flat by servers from before organize_by_date
+		legacyFlat := 0
+		if config.OrganizeByDate {
+			for _, phone := range phoneDirs {
+				legacyFlat += len(flatOriginals(filepath.Join(baseDir, phone)))
+			}
+		}
+		freeSpace, lowSpace := "", false
+		if free, err := diskFreeBytes(baseDir); err == nil {
+			freeSpace = formatBytes(int64(free)) + " free"
+			if minFree := minFreeBytes(config); minFree > 0 {
+				freeSpace += fmt.Sprintf(", uploads stop below %s", formatBytes(int64(minFree)))
+				lowSpace = free < minFree
+			}
+		}
+
+		// Shared albums
+		var albumNames []string
+		albumsMutex.Lock()
+		if albums, err := loadAlbums(baseDir); err == nil {
+			for name := range albums {
+				albumNames = append(albumNames, name)
+			}
+		}
+		albumsMutex.Unlock()
+		sort.Strings(albumNames)
+
 		tmpl := `<!DOCTYPE html>
 <html>
 <head>
@@ -350,22 +441,64 @@ func startHTTPServer(config *Config) error {
             box-shadow: 0 4px 16px rgba(68, 119, 204, 0.3);
             color: #aaccff;
         }
+        #syncStatus { display: none; max-width: 600px; }
+        .sync-item { padding: 15px 20px; margin: 10px 0; background: #111111; border: 1px solid #2a2a2a; border-radius: 8px; font-size: 14px; }
+        .sync-bar { height: 6px; background: #2a2a2a; border-radius: 3px; margin: 10px 0 6px 0; overflow: hidden; }
+        .sync-bar div { height: 100%; background: linear-gradient(90deg, #667eea, #764ba2); transition: width 0.5s ease; }
+        .sync-detail { color: #888888; font-size: 12px; }
+        #pairing { display: flex; gap: 24px; align-items: center; background: #1a1a1a; border: 1px solid #2a2a2a; border-radius: 12px; padding: 16px 20px; margin-bottom: 20px; max-width: 600px; }
+        #pairing img { width: 160px; height: 160px; background: #ffffff; border-radius: 8px; }
+        #pairingPin { font-size: 36px; font-family: monospace; letter-spacing: 6px; color: #ffffff; }
+        .pairing-note { color: #888888; font-size: 13px; }
+        .hardware-note { color: #888888; font-size: 13px; }
+        .usage { display: block; color: #888888; font-size: 12px; margin-top: 4px; }
+        .usage-full { color: #ff6b6b; }
+        .usage-bar { display: block; height: 4px; background: #2a2a2a; border-radius: 2px; margin-top: 6px; overflow: hidden; max-width: 300px; }
+        .usage-bar span { display: block; height: 100%; background: linear-gradient(90deg, #667eea, #764ba2); }
+        .usage-full .usage-bar span { background: #ff6b6b; }
     </style>
 </head>
 <body>
     <h1>Photo Sync Server</h1>
+
+    <div id="syncStatus">
+        <h2>⏳ Syncing Now</h2>
+        <div id="syncList"></div>
+    </div>
     
+    {{if .Pairing}}
+    <h2>🔗 Pair a Phone</h2>
+    <div id="pairing">
+        <img id="pairingQR" src="/pairing/qr.png" alt="Pairing QR code">
+        <div>
+            <div class="pairing-note">Scan the code in the app, or enter this PIN:</div>
+            <div id="pairingPin">······</div>
+            <div class="pairing-note" id="pairingExpiry"></div>
+        </div>
+    </div>
+    {{end}}
+
     {{if .PhoneDirs}}
     <h2>📱 Phone Directories</h2>
     <ul class="phone-list">
         {{range .PhoneDirs}}
-        <li><a href="/phone/{{.}}">📱 {{.}}</a></li>
+        <li><a href="/phone/{{.}}">📱 {{.}}{{with index $.Usage .}}<span class="usage{{if .Full}} usage-full{{end}}">{{.Text}}{{if .Limited}}<span class="usage-bar"><span style="width:{{.Percent}}%"></span></span>{{end}}</span>{{end}}</a></li>
         {{end}}
     </ul>
+    {{if .FreeSpace}}<p class="hardware-note{{if .LowSpace}} usage-full{{end}}">💽 {{.FreeSpace}}</p>{{end}}
     {{else}}
     <p>No phone directories found.</p>
     {{end}}
 
+    {{if .Albums}}
+    <h2>📚 Shared Albums</h2>
+    <ul class="file-list">
+        {{range .Albums}}
+        <li><a href="/album/{{.}}">📚 {{.}}</a></li>
+        {{end}}
+    </ul>
+    {{end}}
+
     {{if .FileFolders}}
     <h2>📁 File Folders</h2>
     <ul class="file-list">
@@ -374,16 +507,132 @@ func startHTTPServer(config *Config) error {
         {{end}}
     </ul>
     {{end}}
+
+    <h2>🛠 Admin</h2>
+    <ul class="file-list">
+        <li><a href="/client-logs">🩺 Client Logs</a></li>
+        <li><a href="/export">💾 Export to USB Drive</a></li>
+        <li><a href="/devices">📱 Devices</a></li>
+        <li><a href="/storage">💽 Storage</a></li>
+        <li><a href="/upgrade">🧳 Upgrade Assistant</a></li>
+        <li><a href="/scrub">🩹 Integrity Scrub</a></li>
+        <li><a href="/backup">☁️ Backup</a></li>
+        <li><a href="/secondary">🗄 Secondary Copy</a>{{if .Secondary}} · {{.Secondary}}{{end}}</li>
+        {{if .SignOut}}<li><a href="/logout">🔒 Sign Out</a></li>{{end}}
+    </ul>
+    {{if .Damaged}}<p class="hardware-note">🩹 The integrity scrub found {{.Damaged}} damaged file(s); see the <a href="/scrub">scrub page</a>.</p>{{end}}
+    {{if .LegacyFlat}}<p class="hardware-note">🧳 {{.LegacyFlat}} original(s) still sit directly in phone folders; the <a href="/upgrade">upgrade assistant</a> can file them by date.</p>{{end}}
+    {{if .Offline}}<p class="hardware-note">📴 Offline mode: {{join .Offline ", "}} are off.</p>{{end}}
+    <p class="hardware-note">⚙️ Hardware profile: {{.Hardware.Summary}}{{range .Hardware.Clamps}}<br>· {{.}}{{end}}</p>
+
+    <script>
+        function formatBytes(n) {
+            if (n >= 1073741824) return (n / 1073741824).toFixed(1) + ' GB';
+            if (n >= 1048576) return (n / 1048576).toFixed(1) + ' MB';
+            if (n >= 1024) return (n / 1024).toFixed(0) + ' KB';
+            return n + ' B';
+        }
+
+        function formatETA(s) {
+            if (s < 0) return '';
+            if (s >= 3600) return Math.floor(s / 3600) + 'h ' + Math.floor((s % 3600) / 60) + 'm left';
+            if (s >= 60) return Math.floor(s / 60) + 'm ' + (s % 60) + 's left';
+            return s + 's left';
+        }
+
+        function escapeHTML(s) {
+            return String(s).replace(/[&<>"']/g, function(c) {
+                return {'&': '&amp;', '<': '&lt;', '>': '&gt;', '"': '&quot;', "'": '&#39;'}[c];
+            });
+        }
+
+        // Poll the live sync status and show one progress bar per connected phone
+        function refreshSyncStatus() {
+            fetch('/api/sync-status')
+                .then(function(r) { return r.json(); })
+                .then(function(data) {
+                    const sessions = (data.sessions || []).filter(function(s) { return s.phone; });
+                    document.getElementById('syncStatus').style.display = sessions.length ? 'block' : 'none';
+                    document.getElementById('syncList').innerHTML = sessions.map(function(s) {
+                        let percent = -1;
+                        if (s.bytesExpected > 0) {
+                            percent = Math.min(100, 100 * s.bytesReceived / s.bytesExpected);
+                        } else if (s.filesExpected > 0) {
+                            percent = Math.min(100, 100 * s.filesCompleted / s.filesExpected);
+                        } else if (s.currentTotal > 0) {
+                            percent = 100 * s.currentBytes / s.currentTotal;
+                        }
+                        let files = s.filesCompleted + (s.filesExpected ? ' / ' + s.filesExpected : '') + ' files';
+                        let detail = [files, formatBytes(s.bytesReceived), formatBytes(s.bytesPerSec) + '/s', formatETA(s.etaSeconds)]
+                            .filter(function(x) { return x; }).join(' · ');
+                        if (s.currentFile) {
+                            detail += '<br>' + escapeHTML(s.currentFile) + ': ' + formatBytes(s.currentBytes) + ' / ' + formatBytes(s.currentTotal);
+                        }
+                        return '<div class="sync-item">📱 ' + escapeHTML(s.phone) +
+                            (percent >= 0 ? '<div class="sync-bar"><div style="width:' + percent.toFixed(1) + '%"></div></div>' : '<br>') +
+                            '<span class="sync-detail">' + detail + '</span></div>';
+                    }).join('');
+                })
+                .catch(function() {});
+        }
+
+        refreshSyncStatus();
+        setInterval(refreshSyncStatus, 2000);
+        {{if .Pairing}}
+
+        // The PIN and QR code rotate and are retired once a phone pairs; follow along
+        let pairingPin = '';
+        function refreshPairing() {
+            fetch('/api/pairing')
+                .then(function(r) { return r.json(); })
+                .then(function(data) {
+                    if (!data.success) return;
+                    if (data.pin !== pairingPin) {
+                        pairingPin = data.pin;
+                        document.getElementById('pairingPin').textContent = data.pin;
+                        document.getElementById('pairingQR').src = '/pairing/qr.png?pin=' + encodeURIComponent(data.pin);
+                    }
+                    const minutes = Math.ceil(data.expiresIn / 60);
+                    document.getElementById('pairingExpiry').textContent = 'Valid for one phone, ' + (minutes > 1 ? minutes + ' more minutes' : 'less than a minute more');
+                })
+                .catch(function() {});
+        }
+        refreshPairing();
+        setInterval(refreshPairing, 5000);
+        {{end}}
+    </script>
 </body>
 </html>`
 
-		t := template.Must(template.New("home").Parse(tmpl))
+		t := template.Must(template.New("home").Funcs(template.FuncMap{"join": strings.Join}).Parse(tmpl))
 		data := struct {
 			PhoneDirs   []string
 			FileFolders []string
+			Albums      []string
+			Pairing     bool
+			Hardware    hardwareProfile
+			Usage       map[string]phoneUsage
+			FreeSpace   string
+			LowSpace    bool
+			LegacyFlat  int
+			SignOut     bool
+			Damaged     int
+			Secondary   string
+			Offline     []string // what offline mode turns off
 		}{
 			PhoneDirs:   phoneDirs,
 			FileFolders: fileFolders,
+			Albums:      albumNames,
+			Pairing:     config.Devices != nil && config.Devices.Pairing,
+			Hardware:    hardware,
+			Usage:       usage,
+			FreeSpace:   freeSpace,
+			LowSpace:    lowSpace,
+			LegacyFlat:  legacyFlat,
+			SignOut:     config.WebAuth.enabled() && config.WebAuth.Password != "",
+			Damaged:     len(scrubIssues(baseDir)),
+			Secondary:   secondaryIndicator(),
+			Offline:     offlineDisabled(),
 		}
 
 		w.Header().Set("Content-Type", "text/html; charset=utf-8")
@@ -415,7 +664,7 @@ func startHTTPServer(config *Config) error {
 		thumbDir := filepath.Join(phoneDir, "thumbnails")
 
 		entries, err := os.ReadDir(thumbDir)
-		if err != nil {
+		if err != nil && !os.IsNotExist(err) {
 			http.Error(w, fmt.Sprintf("Error reading thumbnails: %v", err), http.StatusInternalServerError)
 			return
 		}
@@ -427,25 +676,11 @@ func startHTTPServer(config *Config) error {
 				if ext == ".jpg" || ext == ".jpeg" || ext == ".png" {
 					thumbName := e.Name()
 
-					// Verify that the original file exists before adding thumbnail to list
-					thumbExt := strings.ToLower(filepath.Ext(thumbName))
-					base := strings.TrimSuffix(thumbName, thumbExt)
-					if strings.HasPrefix(strings.ToLower(base), "tbn-") {
-						base = base[4:]
-					}
-
-					// Check if original file exists with any valid extension
-					imageExts := []string{".jpg", ".jpeg", ".png", ".heic"}
-					videoExts := []string{".mp4", ".mov", ".m4v", ".avi", ".mkv"}
-					allExts := append(imageExts, videoExts...)
-
-					foundOriginal := false
-					for _, origExt := range allExts {
-						origPath := filepath.Join(phoneDir, base+origExt)
-						if _, err := os.Stat(origPath); err == nil {
-							foundOriginal = true
-							break
-						}
+					// Verify that the original file exists (with any valid extension, possibly
+					// filed by date, or archived) before adding thumbnail to list
+					_, foundOriginal := originalForThumbnail(phoneDir, thumbName)
+					if !foundOriginal {
+						_, foundOriginal = openCatalog(phoneDir).ArchivedFile(thumbName)
 					}
 
 					// Only add thumbnail if original file exists
@@ -461,9 +696,12 @@ func startHTTPServer(config *Config) error {
 			}
 		}
 
-		// Also include video files from the phone directory
-		phoneEntries, err := os.ReadDir(phoneDir)
-		if err == nil {
+		// Also include video files from the phone directory and its <year>/<month> directories
+		for _, mediaDir := range phoneMediaDirs(phoneDir) {
+			phoneEntries, err := os.ReadDir(mediaDir)
+			if err != nil {
+				continue
+			}
 			for _, e := range phoneEntries {
 				if !e.IsDir() {
 					ext := strings.ToLower(filepath.Ext(e.Name()))
@@ -477,12 +715,36 @@ func startHTTPServer(config *Config) error {
 					}
 					if isVideo {
 						thumbFiles = append(thumbFiles, e.Name())
+					} else if hasExtension(e.Name(), photoExtensions) && !strings.HasPrefix(strings.ToLower(e.Name()), "tbn-") {
+						// Photos without a thumbnail yet; /thumb generates it when the page loads
+						thumbName := thumbnailName(e.Name())
+						if _, err := os.Stat(filepath.Join(thumbDir, thumbName)); os.IsNotExist(err) {
+							thumbFiles = append(thumbFiles, thumbName)
+						}
 					}
 				}
 			}
 		}
+		thumbFiles = append(thumbFiles, archivedVideos(phoneDir)...)
 		sort.Strings(thumbFiles)
 
+		// Smart views narrow the gallery to matches from the catalog
+		viewID := r.URL.Query().Get("view")
+		view, inView := findSmartView(viewID)
+		if inView {
+			thumbFiles = applySmartView(r.Context(), view, phoneDir, thumbFiles)
+			countFeature("smart_view_" + view.ID)
+		} else {
+			viewID = ""
+		}
+
+		// A search narrows it further, by name, place, tags and the text read in photos
+		query := strings.TrimSpace(r.URL.Query().Get("q"))
+		if query != "" {
+			thumbFiles = applySearch(phoneDir, thumbFiles, query)
+			countFeature("search")
+		}
+
 		// Pagination logic
 		const itemsPerPage = 80
 		totalItems := len(thumbFiles)
@@ -527,6 +789,12 @@ func startHTTPServer(config *Config) error {
             transform: translateY(-2px);
             box-shadow: 0 6px 20px rgba(102, 126, 234, 0.6);
         }
+        .smart-views { display: flex; flex-wrap: wrap; gap: 8px; margin-bottom: 16px; }
+        .smart-views a { padding: 6px 12px; border: 1px solid #333333; border-radius: 16px; color: #cccccc; text-decoration: none; font-size: 13px; }
+        .smart-views a:hover { background: #1a1a1a; }
+        .smart-views a.active { background: #667eea; border-color: #667eea; color: #ffffff; }
+        .gallery-search { margin-left: auto; }
+        .gallery-search input { padding: 6px 12px; background: #1a1a1a; border: 1px solid #333333; border-radius: 16px; color: #ffffff; font-size: 13px; width: 280px; }
         .info-bar {
             display: flex;
             justify-content: space-between;
@@ -652,6 +920,26 @@ func startHTTPServer(config *Config) error {
             transform: translateY(-2px);
             box-shadow: 0 4px 12px rgba(76, 175, 80, 0.6);
         }
+        .timezone-setting { color: #aaaaaa; font-size: 14px; margin: -10px 0 20px 0; }
+        .timezone-setting a { color: #88aaff; }
+        .time-btn {
+            background: linear-gradient(135deg, #f7971e 0%, #d97706 100%);
+            color: white;
+            box-shadow: 0 2px 8px rgba(247, 151, 30, 0.4);
+        }
+        .time-btn:hover { 
+            transform: translateY(-2px);
+            box-shadow: 0 4px 12px rgba(247, 151, 30, 0.6);
+        }
+        .album-btn {
+            background: linear-gradient(135deg, #667eea 0%, #764ba2 100%);
+            color: white;
+            box-shadow: 0 2px 8px rgba(102, 126, 234, 0.4);
+        }
+        .album-btn:hover { 
+            transform: translateY(-2px);
+            box-shadow: 0 4px 12px rgba(102, 126, 234, 0.6);
+        }
         .delete-btn {
             background: linear-gradient(135deg, #ff6b6b 0%, #ee5a52 100%);
             color: white;
@@ -687,6 +975,64 @@ func startHTTPServer(config *Config) error {
         .gallery-item {
             position: relative;
         }
+        .gallery-item.focused {
+            outline: 2px solid #f5c542;
+            outline-offset: 2px;
+        }
+        .favorite-badge {
+            display: none;
+            position: absolute;
+            top: 15px;
+            left: 15px;
+            color: #f5c542;
+            font-size: 22px;
+            text-shadow: 0 1px 4px rgba(0,0,0,0.8);
+            z-index: 5;
+        }
+        .gallery-item.favorite .favorite-badge { display: block; }
+        .archive-badge {
+            display: none;
+            position: absolute;
+            bottom: 45px;
+            left: 15px;
+            font-size: 18px;
+            text-shadow: 0 1px 4px rgba(0,0,0,0.8);
+            z-index: 5;
+        }
+        .gallery-item.archived .archive-badge { display: block; }
+        .gallery-item.archived img { filter: grayscale(60%); }
+        .gallery-filter {
+            padding: 9px 12px;
+            background: #1a1a1a;
+            color: #ffffff;
+            border: 1px solid #333333;
+            border-radius: 8px;
+            font-size: 14px;
+        }
+        #shortcutHelp {
+            display: none;
+            position: fixed;
+            right: 20px;
+            bottom: 80px;
+            background: #1a1a1a;
+            border: 1px solid #333333;
+            border-radius: 10px;
+            padding: 12px 18px;
+            font-size: 13px;
+            color: #cccccc;
+            z-index: 3000;
+            box-shadow: 0 4px 20px rgba(0,0,0,0.6);
+        }
+        #shortcutHelp kbd {
+            display: inline-block;
+            min-width: 18px;
+            padding: 1px 5px;
+            margin-right: 6px;
+            background: #333333;
+            border-radius: 4px;
+            text-align: center;
+            color: #ffffff;
+        }
         #videoModal {
             display: none;
             position: fixed;
@@ -789,6 +1135,32 @@ func startHTTPServer(config *Config) error {
             cursor: pointer;
         }
         #videoPlayerModal .close:hover { color: #bbb; }
+        .trim-controls {
+            display: flex;
+            flex-wrap: wrap;
+            align-items: center;
+            gap: 8px;
+            margin-top: 10px;
+            color: #dddddd;
+            font-size: 14px;
+        }
+        .trim-controls button {
+            background: #2a2a2a;
+            color: #ffffff;
+            border: 1px solid #444;
+            border-radius: 6px;
+            padding: 6px 12px;
+            cursor: pointer;
+        }
+        .trim-controls button:hover { background: #3a3a3a; }
+        .trim-controls .trim-btn { background: #667eea; border-color: #667eea; }
+        .trim-controls select {
+            background: #1a1a1a;
+            color: #ffffff;
+            border: 1px solid #444;
+            border-radius: 6px;
+            padding: 6px;
+        }
         
         /* Photo viewer modal */
         #photoViewerModal {
@@ -826,11 +1198,109 @@ func startHTTPServer(config *Config) error {
             z-index: 3001;
         }
         #photoViewerModal .close:hover { color: #bbb; }
+        #photoStage.wide {
+            overflow-x: auto;
+            cursor: grab;
+        }
+        #photoStage.wide img {
+            max-width: none;
+            height: 80vh;
+            max-height: none;
+        }
+        #sphereCanvas {
+            display: none;
+            width: 100%;
+            height: 80vh;
+            border-radius: 5px;
+            cursor: grab;
+            touch-action: none;
+        }
         #photoViewerModal .photo-filename {
             color: #f1f1f1;
             margin-top: 15px;
             font-size: 16px;
         }
+        #photoViewerModal .photo-date {
+            color: #aaaaaa;
+            margin-top: 4px;
+            font-size: 14px;
+        }
+        .archive-state {
+            display: none;
+            margin: 10px auto 0;
+            padding: 10px 14px;
+            max-width: 480px;
+            background: #1a1a1a;
+            border: 1px solid #667eea;
+            border-radius: 8px;
+            color: #cccccc;
+            font-size: 14px;
+        }
+        .archive-state.error { border-color: #f44336; color: #f44336; }
+        .download-menu {
+            position: relative;
+            display: inline-block;
+            margin-top: 10px;
+        }
+        .download-menu > button {
+            background: #1a1a1a;
+            color: #ffffff;
+            border: 1px solid #333;
+            border-radius: 6px;
+            padding: 6px 14px;
+            cursor: pointer;
+        }
+        .download-menu > button:hover { background: #2a2a2a; }
+        .download-options {
+            display: none;
+            position: absolute;
+            left: 50%;
+            transform: translateX(-50%);
+            top: 100%;
+            margin-top: 4px;
+            background: #1a1a1a;
+            border: 1px solid #333;
+            border-radius: 6px;
+            min-width: 200px;
+            z-index: 3002;
+            text-align: left;
+        }
+        .download-options.open { display: block; }
+        .download-options a {
+            display: block;
+            padding: 8px 14px;
+            color: #ffffff;
+            text-decoration: none;
+            font-size: 14px;
+        }
+        .download-options a:hover { background: #2a2a2a; }
+        .comments {
+            max-width: 600px;
+            margin: 15px auto;
+            text-align: left;
+            color: #dddddd;
+            font-size: 14px;
+        }
+        .comment { padding: 8px 0; border-bottom: 1px solid #2a2a2a; }
+        .comment-author { color: #667eea; font-weight: 600; }
+        .comment-time { color: #777777; font-size: 12px; margin-left: 6px; }
+        .comment-form { display: flex; gap: 8px; margin-top: 10px; }
+        .comment-form input {
+            background: #1a1a1a;
+            color: #ffffff;
+            border: 1px solid #333;
+            border-radius: 6px;
+            padding: 8px;
+        }
+        .comment-form #commentText { flex: 1; }
+        .comment-form button {
+            background: #667eea;
+            color: white;
+            border: none;
+            border-radius: 6px;
+            padding: 8px 14px;
+            cursor: pointer;
+        }
         
         /* YouTube download section */
         .youtube-download {
@@ -933,23 +1403,42 @@ func startHTTPServer(config *Config) error {
 <body>
     <a href="/" class="back-link">← Back to Phone List</a>
     <h1>📱 {{.PhoneName}}</h1>
+    <div class="timezone-setting">🌍 Default time zone: <span id="phoneTimeZone">…</span> <a href="#" onclick="setTimeZone(); return false;">change</a></div>
     
     <div class="youtube-download">
         <h3>🎵 Download Music from YouTube</h3>
+        {{if .Offline}}
+        <div id="downloadStatus" class="info" style="display: block;">📴 The server runs in offline mode, so music can't be downloaded. Copy MP3 files to {{.MusicDir}} on the server to use them as background music.</div>
+        {{else}}
         <div class="youtube-input-group">
             <input type="text" id="youtubeUrl" placeholder="Enter YouTube video URL..." />
             <button onclick="downloadMusic()" id="downloadBtn">Download</button>
         </div>
         <div id="downloadStatus"></div>
+        {{end}}
+    </div>
+
+    <div class="smart-views">
+        <a href="/phone/{{.PhoneName}}"{{if not .View}} class="active"{{end}}>All</a>
+        {{range .SmartViews}}<a href="?view={{.ID}}"{{if eq .ID $.View}} class="active"{{end}}>{{.Title}}</a>
+        {{end}}<a href="/phone/{{.PhoneName}}/sessions">🔄 Sync sessions</a>
+        <a href="/phone/{{.PhoneName}}/trash">🗑 Recently deleted{{if .TrashCount}} ({{.TrashCount}}){{end}}</a>
+        <form class="gallery-search" method="get">
+            {{if .View}}<input type="hidden" name="view" value="{{.View}}">{{end}}
+            <input type="search" name="q" value="{{.Query}}" placeholder="Search names, places, tags and text in photos">
+        </form>
     </div>
 
     <div class="info-bar">
-        <p class="count">Total: {{.TotalItems}} | Page {{.CurrentPage}} of {{.TotalPages}}</p>
+        <p class="count">{{if .Query}}Search “{{.Query}}”{{if .ViewTitle}} in {{.ViewTitle}}{{end}}: {{.TotalItems}}{{else if .ViewTitle}}{{.ViewTitle}}: {{.TotalItems}}{{else}}Total: {{.TotalItems}}{{end}} | {{.TotalItems}} | Page {{.CurrentPage}} of {{.TotalPages}}</p>
         <button class="select-all-btn" onclick="selectAllOnPage()">✓ Select All on Page</button>
+        <button class="select-all-btn" onclick="document.getElementById('uploadInput').click()">⬆ Upload Files</button>
+        <input type="file" id="uploadInput" multiple accept="image/*,video/*" style="display: none;" onchange="uploadFiles(this.files)">
+        <input type="search" id="galleryFilter" class="gallery-filter" placeholder="Filter this page ( / )" oninput="filterGallery(this.value)">
         <div class="pagination">
             {{if gt .CurrentPage 1}}
-                <a href="?page=1">« First</a>
-                <a href="?page={{.PrevPage}}">‹ Prev</a>
+                <a href="?{{if $.View}}view={{$.View}}&{{end}}{{if $.Query}}q={{$.Query}}&{{end}}page=1">« First</a>
+                <a href="?{{if $.View}}view={{$.View}}&{{end}}{{if $.Query}}q={{$.Query}}&{{end}}page={{.PrevPage}}">‹ Prev</a>
             {{else}}
                 <span class="disabled">« First</span>
                 <span class="disabled">‹ Prev</span>
@@ -959,13 +1448,13 @@ func startHTTPServer(config *Config) error {
                 {{if eq . $.CurrentPage}}
                     <span class="current">{{.}}</span>
                 {{else}}
-                    <a href="?page={{.}}">{{.}}</a>
+                    <a href="?{{if $.View}}view={{$.View}}&{{end}}{{if $.Query}}q={{$.Query}}&{{end}}page={{.}}">{{.}}</a>
                 {{end}}
             {{end}}
             
             {{if lt .CurrentPage .TotalPages}}
-                <a href="?page={{.NextPage}}">Next ›</a>
-                <a href="?page={{.TotalPages}}">Last »</a>
+                <a href="?{{if $.View}}view={{$.View}}&{{end}}{{if $.Query}}q={{$.Query}}&{{end}}page={{.NextPage}}">Next ›</a>
+                <a href="?{{if $.View}}view={{$.View}}&{{end}}{{if $.Query}}q={{$.Query}}&{{end}}page={{.TotalPages}}">Last »</a>
             {{else}}
                 <span class="disabled">Next ›</span>
                 <span class="disabled">Last »</span>
@@ -974,21 +1463,25 @@ func startHTTPServer(config *Config) error {
     </div>
     {{if .Thumbs}}
     <div class="gallery">
-        {{range .Thumbs}}
+        {{range $i, $t := .Thumbs}}
         {{if isVideo .}}
-		<div class="gallery-item video-item" data-filename="{{.}}" data-is-video="true">
+		<div class="gallery-item video-item{{if isFavorite .}} favorite{{end}}{{if isArchived .}} archived{{end}}" data-filename="{{.}}" data-is-video="true">
             <span class="video-badge">🎬 VIDEO</span>
+            <span class="favorite-badge" title="Favorite">★</span>
+            <span class="archive-badge" title="Archived: the original is retrieved when opened">📦</span>
 			<a href="#" onclick="playVideo('{{$.PhoneName}}', '{{.}}'); return false;">
-				<img src="/thumb/{{$.PhoneName}}/{{getVideoThumb .}}" alt="{{.}}" onerror="this.src='data:image/svg+xml,%3Csvg xmlns=%22http://www.w3.org/2000/svg%22 width=%22200%22 height=%22200%22%3E%3Crect fill=%22%23333%22 width=%22200%22 height=%22200%22/%3E%3Ctext fill=%22%23fff%22 x=%2250%25%22 y=%2250%25%22 text-anchor=%22middle%22 dy=%22.3em%22%3EVIDEO%3C/text%3E%3C/svg%3E'" />
+				<img src="/thumb/{{$.PhoneName}}/{{getVideoThumb .}}" alt="{{.}}" {{if lt $i $.AboveFold}}fetchpriority="high"{{else}}loading="lazy"{{end}} decoding="async" onerror="this.src='data:image/svg+xml,%3Csvg xmlns=%22http://www.w3.org/2000/svg%22 width=%22200%22 height=%22200%22%3E%3Crect fill=%22%23333%22 width=%22200%22 height=%22200%22/%3E%3Ctext fill=%22%23fff%22 x=%2250%25%22 y=%2250%25%22 text-anchor=%22middle%22 dy=%22.3em%22%3EVIDEO%3C/text%3E%3C/svg%3E'" />
 			</a>
-            <div class="filename">{{.}}</div>
+            <div class="filename" title="{{.}}">{{with itemDate .}}<time datetime="{{.Time}}" data-local="{{.Local}}" data-precision="{{.Precision}}">{{.Fallback}}</time>{{else}}{{.}}{{end}}</div>
         </div>
         {{else}}
-		<div class="gallery-item" data-filename="{{.}}">
+		<div class="gallery-item{{if isFavorite .}} favorite{{end}}{{if isArchived .}} archived{{end}}" data-filename="{{.}}">
+            <span class="favorite-badge" title="Favorite">★</span>
+            <span class="archive-badge" title="Archived: the original is retrieved when opened">📦</span>
 			<a href="#" onclick="viewPhoto('{{$.PhoneName}}', '{{.}}'); return false;">
-				<img src="/thumb/{{$.PhoneName}}/{{.}}" alt="{{.}}" />
+				<img src="/thumb/{{$.PhoneName}}/{{.}}" alt="{{.}}" {{if lt $i $.AboveFold}}fetchpriority="high"{{else}}loading="lazy"{{end}} decoding="async" />
 			</a>
-            <div class="filename">{{.}}</div>
+            <div class="filename" title="{{.}}">{{with itemDate .}}<time datetime="{{.Time}}" data-local="{{.Local}}" data-precision="{{.Precision}}">{{.Fallback}}</time>{{else}}{{.}}{{end}}</div>
             <input type="checkbox" class="checkbox" data-filename="{{.}}">
         </div>
         {{end}}
@@ -998,9 +1491,27 @@ func startHTTPServer(config *Config) error {
     <p>No thumbnails found.</p>
     {{end}}
     
+    <div id="shortcutHelp">
+        <div><kbd>←</kbd><kbd>→</kbd><kbd>↑</kbd><kbd>↓</kbd> move</div>
+        <div><kbd>Enter</kbd> open, then <kbd>←</kbd><kbd>→</kbd> previous/next</div>
+        <div><kbd>Space</kbd> select</div>
+        <div><kbd>A</kbd> select all on page</div>
+        <div><kbd>F</kbd> favorite</div>
+        <div><kbd>Del</kbd> delete</div>
+        <div><kbd>/</kbd> filter</div>
+        <div><kbd>Esc</kbd> close, clear</div>
+        <div><kbd>?</kbd> this help</div>
+    </div>
+
     <div class="selection-bar" id="selectionBar">
         <span id="selectionCount">0 selected</span>
         <button class="create-video-btn" onclick="showVideoModal()">🎬 Create Video</button>
+        <button class="album-btn" onclick="addToAlbum()">📚 Add to Album</button>
+        <button class="time-btn" onclick="shiftTimes()">🕒 Shift Time</button>
+        <button class="time-btn" onclick="editMetadata()">📝 Date &amp; Place</button>
+        <button class="album-btn" onclick="printExport()">🖨️ Print</button>
+        {{if .Archive}}<button class="album-btn" onclick="archiveSelected()">📦 Archive</button>
+        <button class="album-btn" onclick="retrieveSelected()">📤 Retrieve</button>{{end}}
         <button class="delete-btn" onclick="deleteSelected()">🗑️ Delete</button>
         <button class="clear-selection-btn" onclick="clearSelection()">✕ Clear</button>
     </div>
@@ -1014,11 +1525,11 @@ func startHTTPServer(config *Config) error {
             <label>Frame Duration (seconds per photo):</label>
             <input type="number" id="frameDuration" value="2" min="0.5" max="10" step="0.5">
             
-            <label>Video Quality:</label>
+            <label>Output Preset:</label>
             <select id="videoQuality">
-                <option value="high">High (1080p)</option>
-                <option value="medium" selected>Medium (720p)</option>
-                <option value="low">Low (480p)</option>
+                {{range .VideoPresets}}
+                <option value="{{.Name}}"{{if eq .Name "medium"}} selected{{end}}>{{.Label}}</option>
+                {{end}}
             </select>
             
             <label>Background Music:</label>
@@ -1028,6 +1539,22 @@ func startHTTPServer(config *Config) error {
                 <option value="{{.}}">{{.}}</option>
                 {{end}}
             </select>
+
+            <label>Narration (voiceover):</label>
+            <select id="narrationFile" onchange="updateNarrationOptions()">
+                <option value="">None</option>
+            </select>
+            <input type="file" id="narrationUpload" accept="audio/*" onchange="uploadNarration(this)">
+            <div id="narrationOptions" style="display: none;">
+                <label>Narration starts at (seconds):</label>
+                <input type="number" id="narrationDelay" value="0" min="0" step="0.5">
+                <label>Music under the voice:</label>
+                <select id="narrationDuck">
+                    <option value="4">Slightly lowered</option>
+                    <option value="8" selected>Lowered</option>
+                    <option value="16">Nearly silent</option>
+                </select>
+            </div>
             
             <div>
                 <button class="modal-create" onclick="createVideo()">Create Video</button>
@@ -1045,18 +1572,63 @@ func startHTTPServer(config *Config) error {
                 <source id="videoSource" src="" type="video/mp4">
                 Your browser does not support the video tag.
             </video>
+            <div class="archive-state" id="videoArchiveState"></div>
+            <div class="trim-controls">
+                <button onclick="stepFrame(-1)" title="Previous frame">◀︎ Frame</button>
+                <button onclick="stepFrame(1)" title="Next frame">Frame ▶︎</button>
+                <button onclick="setTrimPoint('in')">Set In</button>
+                <button onclick="setTrimPoint('out')">Set Out</button>
+                <span id="trimRange">In: – Out: –</span>
+                <select id="trimMode">
+                    <option value="auto">Auto (copy when possible)</option>
+                    <option value="reencode">Frame accurate (re-encode)</option>
+                    <option value="copy">Fast (keyframe copy)</option>
+                </select>
+                <button class="trim-btn" onclick="trimVideo()">✂️ Trim</button>
+                <button onclick="saveFrame()">📷 Save Frame</button>
+                <select id="audioFormat">
+                    <option value="mp3">MP3</option>
+                    <option value="m4a">M4A</option>
+                </select>
+                <button onclick="extractAudio()">🎵 Extract Audio</button>
+                <span id="trimStatus"></span>
+            </div>
         </div>
     </div>
 
     <div id="photoViewerModal">
         <div class="modal-content">
             <span class="close" onclick="closePhotoViewer()">&times;</span>
-            <img id="photoViewerImg" src="" alt="Photo">
+            <div id="photoStage">
+                <img id="photoViewerImg" src="" alt="Photo">
+            </div>
+            <canvas id="sphereCanvas" title="Drag to look around, scroll to zoom"></canvas>
             <div class="photo-filename" id="photoFilename"></div>
+            <div class="photo-date" id="photoDate"></div>
+            <div class="archive-state" id="photoArchiveState"></div>
+            <div class="download-menu">
+                <button onclick="toggleDownloadMenu(event)">⬇ Download ▾</button>
+                <div class="download-options" id="downloadOptions">
+                    <a id="downloadOriginal" href="#" download>Original</a>
+                    <a id="downloadJpeg" href="#" download>JPEG (full size)</a>
+                    <a id="downloadJpeg2048" href="#" download>JPEG, 2048 px</a>
+                    <a id="downloadJpeg1024" href="#" download>JPEG, 1024 px</a>
+                    {{if .Watermark}}<a id="downloadShare" href="#" download>JPEG for sharing, 2048 px, watermarked</a>{{end}}
+                </div>
+            </div>
+            <div class="comments">
+                <div id="commentList"></div>
+                <div class="comment-form">
+                    <input type="text" id="commentAuthor" placeholder="Your name" size="12">
+                    <input type="text" id="commentText" placeholder="Add a comment..." onkeydown="if (event.key === 'Enter') postComment()">
+                    <button onclick="postComment()">Post</button>
+                </div>
+            </div>
         </div>
     </div>
 
     <script>
+        {{.DateFormatJS}}
         let selectedPhotos = new Set();
         const phoneName = '{{.PhoneName}}';
 
@@ -1120,6 +1692,189 @@ func startHTTPServer(config *Config) error {
             updateSelectionBar();
         }
 
+        // Keyboard shortcuts, for curating without the mouse. F and Delete act on the
+        // selection, or on the focused item when nothing is selected.
+        function visibleItems() {
+            return Array.from(document.querySelectorAll('.gallery-item')).filter(item => item.style.display !== 'none');
+        }
+
+        function focusedItem() {
+            return document.querySelector('.gallery-item.focused');
+        }
+
+        function focusItem(item) {
+            if (!item) return;
+            const old = focusedItem();
+            if (old) old.classList.remove('focused');
+            item.classList.add('focused');
+            item.scrollIntoView({ block: 'nearest' });
+        }
+
+        // moveFocus steps through the visible items; a row is as many items as share the first one's top
+        function moveFocus(key) {
+            const items = visibleItems();
+            if (items.length === 0) return;
+            let row = items.findIndex(item => item.offsetTop !== items[0].offsetTop);
+            if (row < 0) row = items.length;
+            const step = { ArrowLeft: -1, ArrowRight: 1, ArrowUp: -row, ArrowDown: row }[key];
+            const current = items.indexOf(focusedItem());
+            const next = current < 0 ? 0 : Math.max(0, Math.min(items.length - 1, current + step));
+            focusItem(items[next]);
+        }
+
+        function openItem(item) {
+            if (!item) return;
+            if (item.dataset.isVideo) {
+                playVideo(phoneName, item.dataset.filename);
+            } else {
+                viewPhoto(phoneName, item.dataset.filename);
+            }
+        }
+
+        function toggleSelected(item) {
+            const filename = item.dataset.filename;
+            const selected = !selectedPhotos.has(filename);
+            if (selected) {
+                selectedPhotos.add(filename);
+            } else {
+                selectedPhotos.delete(filename);
+            }
+            item.classList.toggle('selected', selected);
+            const cb = item.querySelector('.checkbox');
+            if (cb) cb.checked = selected;
+            updateSelectionBar();
+        }
+
+        function galleryItem(filename) {
+            return document.querySelector('.gallery-item[data-filename="' + CSS.escape(filename) + '"]');
+        }
+
+        function shortcutTargets() {
+            if (selectedPhotos.size > 0) return Array.from(selectedPhotos);
+            const item = focusedItem();
+            return item ? [item.dataset.filename] : [];
+        }
+
+        function toggleFavorite() {
+            const targets = shortcutTargets();
+            if (targets.length === 0) return;
+            const items = targets.map(galleryItem).filter(Boolean);
+            // Unmark only when every target already is a favorite
+            const favorite = !items.every(item => item.classList.contains('favorite'));
+            fetch('/api/phones/' + encodeURIComponent(phoneName) + '/favorites', {
+                method: 'POST',
+                headers: { 'Content-Type': 'application/json' },
+                body: JSON.stringify({ photos: targets, favorite: favorite })
+            })
+            .then(response => response.json())
+            .then(data => {
+                if (!data.success) {
+                    alert('Error: ' + (data.error || 'Unknown error'));
+                    return;
+                }
+                items.forEach(item => item.classList.toggle('favorite', favorite));
+            })
+            .catch(err => alert('Error: ' + err));
+        }
+
+        function deleteFocused() {
+            if (selectedPhotos.size === 0) {
+                const item = focusedItem();
+                if (!item) return;
+                toggleSelected(item);
+            }
+            deleteSelected();
+        }
+
+        function filterGallery(text) {
+            const needle = text.trim().toLowerCase();
+            document.querySelectorAll('.gallery-item').forEach(item => {
+                const label = (item.dataset.filename + ' ' + item.querySelector('.filename').textContent).toLowerCase();
+                item.style.display = !needle || label.includes(needle) ? '' : 'none';
+            });
+            const item = focusedItem();
+            if (item && item.style.display === 'none') item.classList.remove('focused');
+        }
+
+        function isOpen(id) {
+            return document.getElementById(id).style.display === 'block';
+        }
+
+        document.addEventListener('keydown', e => {
+            if (e.ctrlKey || e.metaKey || e.altKey) return;
+            if (e.target.matches('input, textarea, select')) {
+                if (e.key === 'Escape' && e.target.id === 'galleryFilter') {
+                    e.target.value = '';
+                    filterGallery('');
+                    e.target.blur();
+                }
+                return;
+            }
+
+            // In the photo viewer, arrows page through the gallery
+            if (isOpen('photoViewerModal')) {
+                if (e.key === 'Escape') {
+                    closePhotoViewer();
+                } else if (e.key === 'ArrowLeft' || e.key === 'ArrowRight') {
+                    e.preventDefault();
+                    const before = focusedItem();
+                    moveFocus(e.key);
+                    if (focusedItem() !== before) openItem(focusedItem());
+                } else if (e.key === 'f' || e.key === 'F') {
+                    toggleFavorite();
+                }
+                return;
+            }
+            if (isOpen('videoPlayerModal')) {
+                if (e.key === 'Escape') closeVideoPlayer();
+                return;
+            }
+            if (isOpen('videoModal')) return;
+
+            switch (e.key) {
+            case 'ArrowLeft':
+            case 'ArrowRight':
+            case 'ArrowUp':
+            case 'ArrowDown':
+                e.preventDefault();
+                moveFocus(e.key);
+                break;
+            case 'Enter':
+                openItem(focusedItem());
+                break;
+            case ' ':
+                e.preventDefault();
+                if (focusedItem()) toggleSelected(focusedItem());
+                break;
+            case 'a':
+            case 'A':
+                selectAllOnPage();
+                break;
+            case 'f':
+            case 'F':
+                toggleFavorite();
+                break;
+            case 'Delete':
+            case 'Backspace':
+                e.preventDefault();
+                deleteFocused();
+                break;
+            case '/':
+                e.preventDefault();
+                document.getElementById('galleryFilter').focus();
+                break;
+            case '?': {
+                const help = document.getElementById('shortcutHelp');
+                help.style.display = help.style.display === 'block' ? 'none' : 'block';
+                break;
+            }
+            case 'Escape':
+                document.getElementById('shortcutHelp').style.display = 'none';
+                clearSelection();
+                break;
+            }
+        });
+
         function downloadMusic() {
             const urlInput = document.getElementById('youtubeUrl');
             const url = urlInput.value.trim();
@@ -1172,7 +1927,60 @@ func startHTTPServer(config *Config) error {
             }
             document.getElementById('videoModal').style.display = 'block';
             document.getElementById('videoStatus').style.display = 'none';
-        }
+            loadNarrations();
+        }
+
+        // Fill the narration select with the phone's uploaded voiceover tracks
+        function loadNarrations(selected) {
+            fetch('/api/narration/' + encodeURIComponent(phoneName))
+                .then(response => response.json())
+                .then(data => {
+                    const select = document.getElementById('narrationFile');
+                    const current = selected || select.value;
+                    select.innerHTML = '<option value="">None</option>';
+                    (data.files || []).forEach(name => {
+                        const option = document.createElement('option');
+                        option.value = name;
+                        option.textContent = name;
+                        select.appendChild(option);
+                    });
+                    select.value = current;
+                    updateNarrationOptions();
+                })
+                .catch(() => {});
+        }
+
+        function updateNarrationOptions() {
+            const hasNarration = document.getElementById('narrationFile').value !== '';
+            document.getElementById('narrationOptions').style.display = hasNarration ? 'block' : 'none';
+        }
+
+        function uploadNarration(input) {
+            if (!input.files.length) return;
+            const status = document.getElementById('videoStatus');
+            status.className = 'info';
+            status.style.display = 'block';
+            status.textContent = 'Uploading narration...';
+
+            const form = new FormData();
+            form.append('file', input.files[0]);
+            fetch('/api/narration/' + encodeURIComponent(phoneName), { method: 'POST', body: form })
+                .then(response => response.json())
+                .then(data => {
+                    input.value = '';
+                    if (data.success) {
+                        status.style.display = 'none';
+                        loadNarrations(data.filename);
+                    } else {
+                        status.className = 'error';
+                        status.textContent = 'Error: ' + (data.error || 'Upload failed');
+                    }
+                })
+                .catch(err => {
+                    status.className = 'error';
+                    status.textContent = 'Error: ' + err.message;
+                });
+        }
 
         function closeVideoModal() {
             document.getElementById('videoModal').style.display = 'none';
@@ -1199,10 +2007,19 @@ func startHTTPServer(config *Config) error {
                 photos: Array.from(selectedPhotos),
                 videoName: videoName,
                 frameDuration: frameDuration,
-                quality: videoQuality,
+                preset: videoQuality,
                 musicFile: musicFile
             };
 
+            const narrationFile = document.getElementById('narrationFile').value;
+            if (narrationFile) {
+                payload.narration = {
+                    file: narrationFile,
+                    delay: parseFloat(document.getElementById('narrationDelay').value) || 0,
+                    duckRatio: parseFloat(document.getElementById('narrationDuck').value)
+                };
+            }
+
             fetch('/create-video', {
                 method: 'POST',
                 headers: { 'Content-Type': 'application/json' },
@@ -1253,24 +2070,156 @@ func startHTTPServer(config *Config) error {
             const videoUrl = '/orig/' + phone + '/' + videoFilename;
             
             shouldReloadAfterVideo = reloadAfterClose || false;
+            playingPhone = phone;
+            playingVideo = filename;
+            trimIn = null;
+            trimOut = null;
+            updateTrimRange();
+            document.getElementById('trimStatus').textContent = '';
             
             console.log('Playing video:', videoUrl);
             videoSource.src = videoUrl;
             videoPlayer.load();
             
+            hideArchiveState('videoArchiveState');
             videoPlayer.onerror = function(e) {
                 console.error('Video load error:', e);
-                alert('Failed to load video: ' + filename + '\nURL: ' + videoUrl);
+                watchArchived(phone, filename, 'videoArchiveState', () => {
+                    if (playingVideo !== filename) return;
+                    videoSource.src = videoUrl + '?retrieved=' + Date.now();
+                    videoPlayer.load();
+                }, () => alert('Failed to load video: ' + filename + '\nURL: ' + videoUrl));
             };
+            videoSource.onerror = videoPlayer.onerror;
             
             document.getElementById('videoPlayerModal').style.display = 'block';
         }
 
+        // Trim state for the video in the player
+        let playingPhone = '';
+        let playingVideo = '';
+        let trimIn = null;
+        let trimOut = null;
+        const frameDuration = 1 / 30;
+
+        function stepFrame(direction) {
+            const videoPlayer = document.getElementById('videoPlayer');
+            videoPlayer.pause();
+            videoPlayer.currentTime = Math.max(0, videoPlayer.currentTime + direction * frameDuration);
+        }
+
+        function setTrimPoint(which) {
+            const t = document.getElementById('videoPlayer').currentTime;
+            if (which === 'in') {
+                trimIn = t;
+            } else {
+                trimOut = t;
+            }
+            updateTrimRange();
+        }
+
+        function updateTrimRange() {
+            const fmt = t => t === null ? '–' : t.toFixed(3) + 's';
+            document.getElementById('trimRange').textContent = 'In: ' + fmt(trimIn) + ' Out: ' + fmt(trimOut);
+        }
+
+        function trimVideo() {
+            const videoPlayer = document.getElementById('videoPlayer');
+            const start = trimIn === null ? 0 : trimIn;
+            const end = trimOut === null ? videoPlayer.duration : trimOut;
+            if (!(end > start)) {
+                alert('The out point must be after the in point');
+                return;
+            }
+            const status = document.getElementById('trimStatus');
+            status.textContent = 'Trimming...';
+            fetch('/trim-video', {
+                method: 'POST',
+                headers: { 'Content-Type': 'application/json' },
+                body: JSON.stringify({
+                    phoneName: playingPhone,
+                    video: playingVideo,
+                    start: start,
+                    end: end,
+                    mode: document.getElementById('trimMode').value
+                })
+            })
+            .then(r => r.json())
+            .then(data => {
+                if (data.success) {
+                    status.textContent = 'Saved as ' + data.filename;
+                    shouldReloadAfterVideo = true;
+                } else {
+                    status.textContent = 'Error: ' + data.error;
+                }
+            })
+            .catch(err => { status.textContent = 'Error: ' + err; });
+        }
+
+        function saveFrame() {
+            const videoPlayer = document.getElementById('videoPlayer');
+            videoPlayer.pause();
+            const status = document.getElementById('trimStatus');
+            status.textContent = 'Saving frame...';
+            fetch('/extract-frame', {
+                method: 'POST',
+                headers: { 'Content-Type': 'application/json' },
+                body: JSON.stringify({
+                    phoneName: playingPhone,
+                    video: playingVideo,
+                    time: videoPlayer.currentTime
+                })
+            })
+            .then(r => r.json())
+            .then(data => {
+                if (data.success) {
+                    status.textContent = 'Saved as ' + data.filename;
+                    shouldReloadAfterVideo = true;
+                } else {
+                    status.textContent = 'Error: ' + data.error;
+                }
+            })
+            .catch(err => { status.textContent = 'Error: ' + err; });
+        }
+
+        // Extract the audio (between the in/out points, if set) into the music library
+        function extractAudio() {
+            const start = trimIn === null ? 0 : trimIn;
+            const end = trimOut === null ? 0 : trimOut;
+            if (end > 0 && end <= start) {
+                alert('The out point must be after the in point');
+                return;
+            }
+            const status = document.getElementById('trimStatus');
+            status.textContent = 'Extracting audio...';
+            fetch('/extract-audio', {
+                method: 'POST',
+                headers: { 'Content-Type': 'application/json' },
+                body: JSON.stringify({
+                    phoneName: playingPhone,
+                    video: playingVideo,
+                    format: document.getElementById('audioFormat').value,
+                    start: start,
+                    end: end
+                })
+            })
+            .then(r => r.json())
+            .then(data => {
+                if (data.success) {
+                    status.textContent = 'Saved to music as ' + data.filename;
+                } else {
+                    status.textContent = 'Error: ' + data.error;
+                }
+            })
+            .catch(err => { status.textContent = 'Error: ' + err; });
+        }
+
         function closeVideoPlayer() {
             const videoPlayer = document.getElementById('videoPlayer');
             videoPlayer.pause();
             videoPlayer.currentTime = 0;
             document.getElementById('videoPlayerModal').style.display = 'none';
+            hideArchiveState('videoArchiveState');
             
             // Reload page if this was a newly created video
             if (shouldReloadAfterVideo) {
@@ -1287,19 +2236,572 @@ func startHTTPServer(config *Config) error {
             console.log('Viewing photo:', photoUrl);
             photoImg.src = photoUrl;
             photoFilename.textContent = filename;
+            document.getElementById('photoDate').textContent = '';
             
+            hideArchiveState('photoArchiveState');
             photoImg.onerror = function(e) {
                 console.error('Photo load error:', e);
-                alert('Failed to load photo: ' + filename + '\nURL: ' + photoUrl);
+                watchArchived(phone, filename, 'photoArchiveState', () => {
+                    if (viewedPhoto === filename) photoImg.src = photoUrl + '?retrieved=' + Date.now();
+                }, () => alert('Failed to load photo: ' + filename + '\nURL: ' + photoUrl));
             };
             
             document.getElementById('photoViewerModal').style.display = 'block';
+            viewedPhone = phone;
+            viewedPhoto = filename;
+            setDownloadLinks(phone, filename);
+            document.getElementById('commentAuthor').value = localStorage.getItem('commentAuthor') || '';
+            loadComments();
+            showPanorama('');
+            fetch('/api/media-info/' + encodeURIComponent(phone) + '/' + encodeURIComponent(filename))
+                .then(r => r.json())
+                .then(data => {
+                    if (!data.success || viewedPhoto !== filename) return;
+                    showPanorama(data.panorama);
+                    document.getElementById('photoDate').textContent = photoDates.formatOffset(data.taken, data.takenPrecision);
+                })
+                .catch(err => console.error('Error loading media info:', err));
+        }
+
+        // Download menu: the original, or converted to JPEG for people who can't open HEIC
+        function setDownloadLinks(phone, filename) {
+            const url = '/download-photo/' + encodeURIComponent(phone) + '/' + encodeURIComponent(filename);
+            document.getElementById('downloadOriginal').href = url + '?format=original';
+            document.getElementById('downloadJpeg').href = url + '?format=jpeg';
+            document.getElementById('downloadJpeg2048').href = url + '?format=jpeg&size=2048';
+            document.getElementById('downloadJpeg1024').href = url + '?format=jpeg&size=1024';
+            const share = document.getElementById('downloadShare');
+            if (share) share.href = url + '?format=jpeg&size=2048&watermark=1';
+            document.getElementById('downloadOptions').classList.remove('open');
+        }
+
+        function toggleDownloadMenu(event) {
+            event.stopPropagation();
+            document.getElementById('downloadOptions').classList.toggle('open');
+        }
+
+        document.addEventListener('click', () => {
+            document.getElementById('downloadOptions').classList.remove('open');
+        });
+
+        // Panorama display: wide panoramas scroll horizontally at full height, 360° photo
+        // spheres are rendered by a small WebGL viewer projecting the equirectangular image
+        let sphere = null;
+
+        function showPanorama(kind) {
+            const stage = document.getElementById('photoStage');
+            const canvas = document.getElementById('sphereCanvas');
+            stage.classList.toggle('wide', kind === 'wide');
+            if (kind !== 'equirectangular') {
+                stage.style.display = '';
+                canvas.style.display = 'none';
+                return;
+            }
+            const img = document.getElementById('photoViewerImg');
+            const start = () => {
+                if (!initSphere(canvas, img)) return; // no WebGL, keep the flat image
+                stage.style.display = 'none';
+                canvas.style.display = 'block';
+                drawSphere();
+            };
+            if (img.complete && img.naturalWidth > 0) {
+                start();
+            } else {
+                img.addEventListener('load', start, { once: true });
+            }
+        }
+
+        function initSphere(canvas, img) {
+            const gl = canvas.getContext('webgl');
+            if (!gl) return false;
+            if (!sphere) {
+                const vs = 'attribute vec2 p; varying vec2 v; void main() { v = p; gl_Position = vec4(p, 0.0, 1.0); }';
+                const fs = 'precision highp float; varying vec2 v; uniform sampler2D tex;' +
+                    'uniform float yaw, pitch, fov, aspect;' +
+                    'void main() {' +
+                    '  float t = tan(fov / 2.0);' +
+                    '  vec3 d = normalize(vec3(v.x * t * aspect, v.y * t, -1.0));' +
+                    '  float cp = cos(pitch), sp = sin(pitch);' +
+                    '  d = vec3(d.x, d.y * cp + d.z * sp, -d.y * sp + d.z * cp);' +
+                    '  float cy = cos(yaw), sy = sin(yaw);' +
+                    '  d = vec3(d.x * cy - d.z * sy, d.y, d.x * sy + d.z * cy);' +
+                    '  float lon = atan(d.x, -d.z);' +
+                    '  float lat = asin(clamp(d.y, -1.0, 1.0));' +
+                    '  gl_FragColor = texture2D(tex, vec2(lon / 6.2831853 + 0.5, 0.5 - lat / 3.1415927));' +
+                    '}';
+                const compile = (type, src) => {
+                    const sh = gl.createShader(type);
+                    gl.shaderSource(sh, src);
+                    gl.compileShader(sh);
+                    return sh;
+                };
+                const prog = gl.createProgram();
+                gl.attachShader(prog, compile(gl.VERTEX_SHADER, vs));
+                gl.attachShader(prog, compile(gl.FRAGMENT_SHADER, fs));
+                gl.linkProgram(prog);
+                if (!gl.getProgramParameter(prog, gl.LINK_STATUS)) return false;
+                gl.useProgram(prog);
+
+                const buf = gl.createBuffer();
+                gl.bindBuffer(gl.ARRAY_BUFFER, buf);
+                gl.bufferData(gl.ARRAY_BUFFER, new Float32Array([-1, -1, 1, -1, -1, 1, 1, 1]), gl.STATIC_DRAW);
+                const loc = gl.getAttribLocation(prog, 'p');
+                gl.enableVertexAttribArray(loc);
+                gl.vertexAttribPointer(loc, 2, gl.FLOAT, false, 0, 0);
+
+                const tex = gl.createTexture();
+                gl.bindTexture(gl.TEXTURE_2D, tex);
+                // Photo sizes are rarely powers of two: no mipmaps, clamp to edge
+                gl.texParameteri(gl.TEXTURE_2D, gl.TEXTURE_WRAP_S, gl.CLAMP_TO_EDGE);
+                gl.texParameteri(gl.TEXTURE_2D, gl.TEXTURE_WRAP_T, gl.CLAMP_TO_EDGE);
+                gl.texParameteri(gl.TEXTURE_2D, gl.TEXTURE_MIN_FILTER, gl.LINEAR);
+                gl.texParameteri(gl.TEXTURE_2D, gl.TEXTURE_MAG_FILTER, gl.LINEAR);
+
+                sphere = { gl: gl, prog: prog, yaw: 0, pitch: 0, fov: 1.6, dragging: false, lastX: 0, lastY: 0 };
+                canvas.addEventListener('pointerdown', e => {
+                    sphere.dragging = true;
+                    sphere.lastX = e.clientX;
+                    sphere.lastY = e.clientY;
+                    canvas.setPointerCapture(e.pointerId);
+                });
+                canvas.addEventListener('pointerup', () => { sphere.dragging = false; });
+                canvas.addEventListener('pointermove', e => {
+                    if (!sphere.dragging) return;
+                    const scale = sphere.fov / canvas.clientHeight;
+                    sphere.yaw -= (e.clientX - sphere.lastX) * scale;
+                    sphere.pitch = Math.max(-1.5, Math.min(1.5, sphere.pitch + (e.clientY - sphere.lastY) * scale));
+                    sphere.lastX = e.clientX;
+                    sphere.lastY = e.clientY;
+                    drawSphere();
+                });
+                canvas.addEventListener('wheel', e => {
+                    e.preventDefault();
+                    sphere.fov = Math.max(0.4, Math.min(2.4, sphere.fov * (e.deltaY > 0 ? 1.1 : 0.9)));
+                    drawSphere();
+                }, { passive: false });
+            }
+            sphere.yaw = 0;
+            sphere.pitch = 0;
+            sphere.fov = 1.6;
+            const maxSize = gl.getParameter(gl.MAX_TEXTURE_SIZE);
+            let source = img;
+            if (img.naturalWidth > maxSize) {
+                // Downscale spheres larger than the GPU supports
+                const c = document.createElement('canvas');
+                c.width = maxSize;
+                c.height = Math.round(maxSize * img.naturalHeight / img.naturalWidth);
+                c.getContext('2d').drawImage(img, 0, 0, c.width, c.height);
+                source = c;
+            }
+            gl.texImage2D(gl.TEXTURE_2D, 0, gl.RGBA, gl.RGBA, gl.UNSIGNED_BYTE, source);
+            return true;
+        }
+
+        function drawSphere() {
+            if (!sphere) return;
+            const gl = sphere.gl;
+            const canvas = gl.canvas;
+            canvas.width = canvas.clientWidth * devicePixelRatio;
+            canvas.height = canvas.clientHeight * devicePixelRatio;
+            gl.viewport(0, 0, canvas.width, canvas.height);
+            gl.uniform1f(gl.getUniformLocation(sphere.prog, 'yaw'), sphere.yaw);
+            gl.uniform1f(gl.getUniformLocation(sphere.prog, 'pitch'), sphere.pitch);
+            gl.uniform1f(gl.getUniformLocation(sphere.prog, 'fov'), sphere.fov);
+            gl.uniform1f(gl.getUniformLocation(sphere.prog, 'aspect'), canvas.width / canvas.height);
+            gl.drawArrays(gl.TRIANGLE_STRIP, 0, 4);
+        }
+
+        let viewedPhone = '';
+        let viewedPhoto = '';
+
+        function commentsUrl() {
+            return '/api/comments/' + encodeURIComponent(viewedPhone) + '/' + encodeURIComponent(viewedPhoto);
+        }
+
+        function loadComments() {
+            const list = document.getElementById('commentList');
+            list.innerHTML = '';
+            fetch(commentsUrl())
+                .then(r => r.json())
+                .then(data => {
+                    if (!data.success) return;
+                    data.comments.forEach(c => list.appendChild(renderComment(c)));
+                })
+                .catch(err => console.error('Error loading comments:', err));
+        }
+
+        function renderComment(c) {
+            const div = document.createElement('div');
+            div.className = 'comment';
+            const author = document.createElement('span');
+            author.className = 'comment-author';
+            author.textContent = c.author;
+            const time = document.createElement('span');
+            time.className = 'comment-time';
+            time.textContent = new Date(c.time).toLocaleString(photoDates.locale());
+            const text = document.createElement('div');
+            text.textContent = c.text;
+            div.appendChild(author);
+            div.appendChild(time);
+            div.appendChild(text);
+            return div;
+        }
+
+        function postComment() {
+            const author = document.getElementById('commentAuthor').value.trim();
+            const textInput = document.getElementById('commentText');
+            const text = textInput.value.trim();
+            if (!author || !text) {
+                alert('Please enter your name and a comment');
+                return;
+            }
+            localStorage.setItem('commentAuthor', author);
+            fetch(commentsUrl(), {
+                method: 'POST',
+                headers: { 'Content-Type': 'application/json' },
+                body: JSON.stringify({ author: author, text: text })
+            })
+            .then(r => r.json())
+            .then(data => {
+                if (data.success) {
+                    textInput.value = '';
+                    document.getElementById('commentList').appendChild(renderComment(data.comment));
+                } else {
+                    alert('Error: ' + data.error);
+                }
+            })
+            .catch(err => alert('Error: ' + err));
         }
 
         function closePhotoViewer() {
             document.getElementById('photoViewerModal').style.display = 'none';
+            hideArchiveState('photoArchiveState');
+        }
+
+        // An original that doesn't load may be archived: asking for it started copying it
+        // back, so say so, and load it again once it is retrieved
+        let archiveWatch = null;
+
+        function watchArchived(phone, filename, stateId, reload, fail, polling) {
+            clearTimeout(archiveWatch);
+            const state = document.getElementById(stateId);
+            fetch('/api/phones/' + encodeURIComponent(phone) + '/archive/' + encodeURIComponent(filename))
+                .then(r => r.json())
+                .then(data => {
+                    if (!data.success || !data.archived) {
+                        hideArchiveState(stateId);
+                        polling ? reload() : fail();
+                        return;
+                    }
+                    state.style.display = 'block';
+                    state.classList.toggle('error', !!data.error);
+                    if (data.error) {
+                        state.textContent = '📦 This original is archived and could not be retrieved: ' + data.error;
+                        return;
+                    }
+                    state.textContent = '📦 This original is archived. Retrieving it from the archive…';
+                    archiveWatch = setTimeout(() => watchArchived(phone, filename, stateId, reload, fail, true), 3000);
+                })
+                .catch(() => { if (!polling) fail(); });
+        }
+
+        function hideArchiveState(stateId) {
+            clearTimeout(archiveWatch);
+            const state = document.getElementById(stateId);
+            state.style.display = 'none';
+            state.textContent = '';
+        }
+
+        // Move the selected originals to the archive; their thumbnails stay in the gallery
+        function archiveSelected() {
+            if (selectedPhotos.size === 0) {
+                alert('Please select at least one photo');
+                return;
+            }
+            if (!confirm('Move the originals of ' + selectedPhotos.size + ' photo(s) to the archive?\n\nThey stay in the gallery and are retrieved when opened.')) {
+                return;
+            }
+            fetch('/api/phones/' + encodeURIComponent(phoneName) + '/archive', {
+                method: 'POST',
+                headers: { 'Content-Type': 'application/json' },
+                body: JSON.stringify({ photos: Array.from(selectedPhotos) })
+            })
+            .then(response => response.json())
+            .then(data => {
+                if (!data.success) {
+                    alert('Error archiving photos: ' + (data.error || (data.errors || []).join('\n')));
+                    return;
+                }
+                let msg = 'Archived ' + data.archived + ' original(s)';
+                if (data.errors && data.errors.length) {
+                    msg += '\n\nNot archived:\n' + data.errors.join('\n');
+                }
+                alert(msg);
+                clearSelection();
+                window.location.reload();
+            })
+            .catch(err => alert('Error archiving photos: ' + err.message));
+        }
+
+        // Copy the selected archived originals back ahead of opening them
+        function retrieveSelected() {
+            if (selectedPhotos.size === 0) {
+                alert('Please select at least one photo');
+                return;
+            }
+            fetch('/api/phones/' + encodeURIComponent(phoneName) + '/retrieve', {
+                method: 'POST',
+                headers: { 'Content-Type': 'application/json' },
+                body: JSON.stringify({ photos: Array.from(selectedPhotos) })
+            })
+            .then(response => response.json())
+            .then(data => {
+                if (!data.success) {
+                    alert('Error retrieving photos: ' + (data.error || 'Unknown error'));
+                    return;
+                }
+                alert(data.retrieving ? 'Retrieving ' + data.retrieving + ' original(s) from the archive' : 'None of the selected photos are archived');
+                clearSelection();
+            })
+            .catch(err => alert('Error retrieving photos: ' + err.message));
+        }
+
+        // Upload photos/videos from this computer into the phone's directory
+        function uploadFiles(files) {
+            if (!files || files.length === 0) {
+                return;
+            }
+            const form = new FormData();
+            for (const f of files) {
+                form.append('mtime', String(f.lastModified));
+                form.append('file', f, f.name);
+            }
+            fetch('/api/phones/' + encodeURIComponent(phoneName) + '/media', { method: 'POST', body: form })
+            .then(response => response.json())
+            .then(data => {
+                document.getElementById('uploadInput').value = '';
+                if (!data.results) {
+                    alert('Upload failed: ' + (data.error || 'Unknown error'));
+                    return;
+                }
+                const failed = data.results.filter(r => r.status !== 'ok');
+                let msg = 'Uploaded ' + data.stored + ' of ' + data.results.length + ' file(s)';
+                if (failed.length > 0) {
+                    msg += '\n\n' + failed.map(r => r.name + ': ' + (r.message || r.code)).join('\n');
+                }
+                alert(msg);
+                if (data.stored > 0) {
+                    // Thumbnails are generated in the background
+                    setTimeout(() => location.reload(), 1500);
+                }
+            })
+            .catch(err => {
+                alert('Upload failed: ' + err.message);
+            });
+        }
+
+        function addToAlbum() {
+            if (selectedPhotos.size === 0) {
+                alert('Please select at least one photo');
+                return;
+            }
+            const album = prompt('Add to which shared album? (a new album is created if needed)', '');
+            if (!album) {
+                return;
+            }
+
+            fetch('/api/albums/' + encodeURIComponent(album) + '/items', {
+                method: 'POST',
+                headers: { 'Content-Type': 'application/json' },
+                body: JSON.stringify({
+                    phoneName: phoneName,
+                    photos: Array.from(selectedPhotos)
+                })
+            })
+            .then(response => response.json())
+            .then(data => {
+                if (data.success) {
+                    alert('Added ' + data.added + ' item(s) to ' + album);
+                    clearSelection();
+                } else {
+                    alert('Error adding to album: ' + (data.error || 'Unknown error'));
+                }
+            })
+            .catch(err => {
+                alert('Error adding to album: ' + err.message);
+            });
+        }
+
+        // Correct capture times of the selection, e.g. a trip shot with the clock on home time
+        function shiftTimes() {
+            if (selectedPhotos.size === 0) {
+                alert('Please select at least one photo');
+                return;
+            }
+            const hours = prompt('Shift capture time by how many hours? (e.g. -7 or 5.5)', '');
+            if (hours === null || hours.trim() === '') {
+                return;
+            }
+            const minutes = Math.round(parseFloat(hours) * 60);
+            if (!minutes) {
+                alert('Please enter a non-zero number of hours');
+                return;
+            }
+
+            fetch('/api/phones/' + encodeURIComponent(phoneName) + '/time-shift', {
+                method: 'POST',
+                headers: { 'Content-Type': 'application/json' },
+                body: JSON.stringify({
+                    photos: Array.from(selectedPhotos),
+                    offsetMinutes: minutes
+                })
+            })
+            .then(response => response.json())
+            .then(data => {
+                if (data.success) {
+                    alert('Shifted ' + data.shifted + ' item(s)');
+                    clearSelection();
+                } else {
+                    alert('Error shifting times: ' + (data.error || 'Unknown error'));
+                }
+            })
+            .catch(err => {
+                alert('Error shifting times: ' + err.message);
+            });
+        }
+
+        // Assign an approximate date and/or a place to scans and other photos without EXIF
+        function editMetadata() {
+            if (selectedPhotos.size === 0) {
+                alert('Please select at least one photo');
+                return;
+            }
+            const date = prompt('Date taken (e.g. 1987, 1987-06 or 1987-06-14), empty to keep:', '');
+            if (date === null) {
+                return;
+            }
+            const coords = prompt('Location as "latitude, longitude" (e.g. 38.7223, -9.1393), empty to keep, "-" to clear:', '');
+            if (coords === null) {
+                return;
+            }
+            const body = { photos: Array.from(selectedPhotos), date: date.trim() };
+            if (coords.trim() === '-') {
+                body.clearPlace = true;
+            } else if (coords.trim() !== '') {
+                const parts = coords.split(',').map(p => parseFloat(p));
+                if (parts.length !== 2 || isNaN(parts[0]) || isNaN(parts[1])) {
+                    alert('Please enter the location as "latitude, longitude"');
+                    return;
+                }
+                const name = prompt('Place name (optional):', '');
+                body.place = { lat: parts[0], lon: parts[1], name: name || '' };
+            }
+            if (!body.date && !body.place && !body.clearPlace) {
+                return;
+            }
+            body.writeExif = confirm('Also write the date/location into the photo files (EXIF)?');
+
+            fetch('/api/phones/' + encodeURIComponent(phoneName) + '/metadata', {
+                method: 'POST',
+                headers: { 'Content-Type': 'application/json' },
+                body: JSON.stringify(body)
+            })
+            .then(response => response.json())
+            .then(data => {
+                if (data.success) {
+                    let message = 'Updated ' + data.updated + ' item(s)';
+                    if (data.exifFailed) {
+                        message += '\nCould not write EXIF to: ' + data.exifFailed.join(', ');
+                    }
+                    alert(message);
+                    clearSelection();
+                } else {
+                    alert('Error updating metadata: ' + (data.error || 'Unknown error'));
+                }
+            })
+            .catch(err => {
+                alert('Error updating metadata: ' + err.message);
+            });
         }
 
+        // Download the selection laid out for a print service: 300 DPI JPEGs in a ZIP
+        function printExport() {
+            if (selectedPhotos.size === 0) {
+                alert('Please select at least one photo');
+                return;
+            }
+            const paper = prompt('Paper size: 4x6, 5x7 or A4', '4x6');
+            if (!paper) {
+                return;
+            }
+            const pad = confirm('Keep the whole photo on white (OK) or fill the paper, cropping the edges (Cancel)?');
+            const border = prompt('White border in mm (0 for none):', '0');
+            if (border === null) {
+                return;
+            }
+
+            fetch('/api/phones/' + encodeURIComponent(phoneName) + '/print-export', {
+                method: 'POST',
+                headers: { 'Content-Type': 'application/json' },
+                body: JSON.stringify({
+                    photos: Array.from(selectedPhotos),
+                    paper: paper.trim(),
+                    fit: pad ? 'pad' : 'crop',
+                    borderMM: parseFloat(border) || 0
+                })
+            })
+            .then(response => {
+                if ((response.headers.get('Content-Type') || '').includes('application/json')) {
+                    return response.json().then(data => { throw new Error(data.error || 'Unknown error'); });
+                }
+                return response.blob();
+            })
+            .then(blob => {
+                const a = document.createElement('a');
+                a.href = URL.createObjectURL(blob);
+                a.download = phoneName + '-print-' + paper.trim().toLowerCase() + '.zip';
+                a.click();
+                setTimeout(() => URL.revokeObjectURL(a.href), 10000);
+                clearSelection();
+            })
+            .catch(err => {
+                alert('Error exporting prints: ' + err.message);
+            });
+        }
+
+        function loadTimeZone() {
+            fetch('/api/phones/' + encodeURIComponent(phoneName) + '/timezone')
+                .then(r => r.json())
+                .then(data => {
+                    if (data.success) document.getElementById('phoneTimeZone').textContent = data.timeZone;
+                })
+                .catch(err => console.error('Error loading time zone:', err));
+        }
+
+        function setTimeZone() {
+            const current = document.getElementById('phoneTimeZone').textContent;
+            const zone = prompt('Time zone for capture times this phone reports without an offset (e.g. Europe/Berlin)', current);
+            if (!zone || zone === current) {
+                return;
+            }
+            fetch('/api/phones/' + encodeURIComponent(phoneName) + '/timezone', {
+                method: 'POST',
+                headers: { 'Content-Type': 'application/json' },
+                body: JSON.stringify({ timeZone: zone })
+            })
+            .then(r => r.json())
+            .then(data => {
+                if (data.success) {
+                    document.getElementById('phoneTimeZone').textContent = data.timeZone;
+                } else {
+                    alert('Error: ' + data.error);
+                }
+            })
+            .catch(err => alert('Error: ' + err.message));
+        }
+
+        loadTimeZone();
+
         function deleteSelected() {
             if (selectedPhotos.size === 0) {
                 alert('Please select at least one photo to delete');
@@ -1307,7 +2809,7 @@ func startHTTPServer(config *Config) error {
             }
 
             const count = selectedPhotos.size;
-            const confirmMsg = 'Are you sure you want to delete ' + count + ' photo(s)?\n\nThis will permanently delete both the original file(s) and thumbnail(s).';
+            const confirmMsg = 'Are you sure you want to delete ' + count + ' photo(s)?\n\nThey can be restored from Recently deleted for 30 days.';
             
             if (!confirm(confirmMsg)) {
                 return;
@@ -1326,7 +2828,7 @@ func startHTTPServer(config *Config) error {
             .then(response => response.json())
             .then(data => {
                 if (data.success) {
-                    alert('Successfully deleted ' + data.deleted + ' photo(s)');
+                    alert('Moved ' + data.deleted + ' photo(s) to Recently deleted');
                     // Remove deleted items from the page
                     photosToDelete.forEach(filename => {
                         const item = document.querySelector('.gallery-item[data-filename="' + filename + '"]');
@@ -1384,16 +2886,13 @@ func startHTTPServer(config *Config) error {
 			pageNumbers = append(pageNumbers, i)
 		}
 
-		// Get music files from /data/music
-		musicDir := "/data/music"
+		// Get music files from the music library
+		musicDir := musicLibraryDir
 		var musicFiles []string
 		if musicEntries, err := os.ReadDir(musicDir); err == nil {
 			for _, entry := range musicEntries {
-				if !entry.IsDir() {
-					ext := strings.ToLower(filepath.Ext(entry.Name()))
-					if ext == ".mp3" {
-						musicFiles = append(musicFiles, entry.Name())
-					}
+				if !entry.IsDir() && hasExtension(entry.Name(), musicExtensions) {
+					musicFiles = append(musicFiles, entry.Name())
 				}
 			}
 		}
@@ -1418,8 +2917,12 @@ func startHTTPServer(config *Config) error {
 			}
 
 			// Check if original file is a video
+			origDir := phoneDir
+			if orig, ok := originalForThumbnail(phoneDir, thumbName); ok {
+				origDir = filepath.Dir(orig) // filed by date
+			}
 			for _, vext := range videoExts {
-				origPath := filepath.Join(phoneDir, base+vext)
+				origPath := filepath.Join(origDir, base+vext)
 				if _, err := os.Stat(origPath); err == nil {
 					return true
 				}
@@ -1447,41 +2950,89 @@ func startHTTPServer(config *Config) error {
 			"hasSuffix":     strings.HasSuffix,
 			"isVideo":       isVideoFunc,
 			"getVideoThumb": getVideoThumbFunc,
+			"itemDate":      func(name string) *mediaDate { return mediaDateFor(phoneDir, name) },
+			"isFavorite": func(name string) bool {
+				catalog := openCatalog(phoneDir)
+				orig, ok := originalForThumbnail(phoneDir, name)
+				if !ok {
+					key, archived := catalog.ArchivedFile(name)
+					orig, ok = catalog.Path(key), archived
+				}
+				return ok && catalog.IsFavorite(orig)
+			},
+			"isArchived": func(name string) bool {
+				_, ok := openCatalog(phoneDir).ArchivedFile(name)
+				return ok
+			},
 		}).Parse(tmpl))
 		data := struct {
-			PhoneName   string
-			Thumbs      []string
-			TotalItems  int
-			TotalPages  int
-			CurrentPage int
-			PrevPage    int
-			NextPage    int
-			PageNumbers []int
-			MusicFiles  []string
+			PhoneName    string
+			Thumbs       []string
+			TotalItems   int
+			TotalPages   int
+			CurrentPage  int
+			PrevPage     int
+			NextPage     int
+			PageNumbers  []int
+			MusicFiles   []string
+			VideoPresets []VideoPreset
+			AboveFold    int
+			DateFormatJS template.JS
+			SmartViews   []smartView
+			View         string
+			ViewTitle    string
+			Query        string
+			TrashCount   int
+			Watermark    bool
+			Offline      bool
+			MusicDir     string
+			Archive      bool
 		}{
-			PhoneName:   phoneName,
-			Thumbs:      pagedThumbs,
-			TotalItems:  totalItems,
-			TotalPages:  totalPages,
-			CurrentPage: page,
-			PrevPage:    page - 1,
-			NextPage:    page + 1,
-			PageNumbers: pageNumbers,
-			MusicFiles:  musicFiles,
+			PhoneName:    phoneName,
+			Thumbs:       pagedThumbs,
+			TotalItems:   totalItems,
+			TotalPages:   totalPages,
+			CurrentPage:  page,
+			PrevPage:     page - 1,
+			NextPage:     page + 1,
+			PageNumbers:  pageNumbers,
+			MusicFiles:   musicFiles,
+			VideoPresets: videoPresets,
+			AboveFold:    aboveFoldThumbs,
+			DateFormatJS: dateFormatJS,
+			SmartViews:   smartViews,
+			View:         viewID,
+			ViewTitle:    view.Title,
+			Query:        query,
+			TrashCount:   len(openCatalog(phoneDir).TrashEntries()),
+			Watermark:    config.Watermark != nil,
+			Offline:      offlineMode,
+			MusicDir:     musicLibraryDir,
+			Archive:      archiveDir != "",
+		}
+
+		// Let HTTP/2 browsers fetch the first screen of thumbnails while the page renders
+		var firstThumbs []string
+		for i, name := range pagedThumbs {
+			if i == aboveFoldThumbs {
+				break
+			}
+			firstThumbs = append(firstThumbs, thumbURL(phoneName, getVideoThumbFunc(name)))
 		}
+		sendEarlyHints(w, r, firstThumbs)
 
 		w.Header().Set("Content-Type", "text/html; charset=utf-8")
 		t.Execute(w, data)
 	}).Methods("GET")
 
 	// Serve thumbnail images
-	router.HandleFunc("/thumb/{phoneName}/{fileName}", func(w http.ResponseWriter, r *http.Request) {
+	handleTransfer(router, "/thumb/{phoneName}/{fileName}", func(w http.ResponseWriter, r *http.Request) {
 		vars := mux.Vars(r)
 		phoneName := vars["phoneName"]
 		fileName := vars["fileName"]
 
 		// Security: prevent path traversal
-		if strings.Contains(phoneName, "..") || strings.Contains(fileName, "..") {
+		if validatePhoneName(phoneName) != nil || strings.Contains(fileName, "..") {
 			http.Error(w, "Invalid path", http.StatusBadRequest)
 			return
 		}
@@ -1493,23 +3044,26 @@ func startHTTPServer(config *Config) error {
 
 		filePath := filepath.Join(baseDir, phoneName, "thumbnails", fileName)
 
-		// Check if file exists
+		// Check if file exists, generating it when the original hasn't been thumbnailed yet
 		if _, err := os.Stat(filePath); os.IsNotExist(err) {
-			http.NotFound(w, r)
-			return
+			if !ensureThumbnail(r.Context(), filepath.Join(baseDir, phoneName), fileName) {
+				http.NotFound(w, r)
+				return
+			}
 		}
 
+		w.Header().Set("Cache-Control", thumbnailCacheControl)
 		http.ServeFile(w, r, filePath)
 	}).Methods("GET")
 
 	// Serve original media corresponding to a thumbnail name
-	router.HandleFunc("/orig/{phoneName}/{thumbName}", func(w http.ResponseWriter, r *http.Request) {
+	handleTransfer(router, "/orig/{phoneName}/{thumbName}", func(w http.ResponseWriter, r *http.Request) {
 		vars := mux.Vars(r)
 		phoneName := vars["phoneName"]
 		thumbName := vars["thumbName"]
 
 		// Security: prevent path traversal
-		if strings.Contains(phoneName, "..") || strings.Contains(thumbName, "..") {
+		if validatePhoneName(phoneName) != nil || strings.Contains(thumbName, "..") {
 			http.Error(w, "Invalid path", http.StatusBadRequest)
 			return
 		}
@@ -1520,6 +3074,11 @@ func startHTTPServer(config *Config) error {
 		}
 
 		phoneDir := filepath.Join(baseDir, phoneName)
+		if orig, ok := originalForThumbnail(phoneDir, thumbName); ok {
+			phoneDir = filepath.Dir(orig) // filed by date
+		} else if serveArchived(w, phoneDir, thumbName) {
+			return
+		}
 
 		// If thumbName is a direct video file, serve it directly
 		thumbExt := strings.ToLower(filepath.Ext(thumbName))
@@ -1607,8 +3166,7 @@ func startHTTPServer(config *Config) error {
 					defer os.Remove(tmpPath)
 
 					// Convert using heif-convert
-					cmd := exec.Command("/usr/local/bin/heif-convert", orig, tmpPath)
-					if output, err := cmd.CombinedOutput(); err != nil {
+					if output, err := runTool(r.Context(), heifConvertTimeout, "/usr/local/bin/heif-convert", orig, tmpPath); err != nil {
 						log.Printf("HEIC conversion failed: %v, output: %s", err, string(output))
 						http.Error(w, "Error converting image", http.StatusInternalServerError)
 						return
@@ -1640,7 +3198,7 @@ func startHTTPServer(config *Config) error {
 	}).Methods("GET")
 
 	// Create video from selected photos
-	router.HandleFunc("/download-music", func(w http.ResponseWriter, r *http.Request) {
+	handleTransfer(router, "/download-music", func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != "POST" {
 			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 			return
@@ -1667,9 +3225,17 @@ func startHTTPServer(config *Config) error {
 			})
 			return
 		}
+		if offlineMode {
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"success": false,
+				"error":   "Music download is unavailable: the server runs in offline mode",
+			})
+			return
+		}
 
 		// Determine the next bgm filename
-		musicDir := "/data/music"
+		musicDir := musicLibraryDir
 		files, err := os.ReadDir(musicDir)
 		if err != nil {
 			// If directory doesn't exist, create it
@@ -1710,15 +3276,10 @@ func startHTTPServer(config *Config) error {
 		log.Printf("Downloading music from %s as %s.mp3", req.URL, fileName)
 
 		// Execute music_get_linux command
-		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
-		defer cancel()
-
-		cmd := exec.CommandContext(ctx, "/usr/local/bin/music_get_linux",
+		output, err := runTool(context.Background(), musicDownloadTimeout, "/usr/local/bin/music_get_linux",
 			"-output", musicDir,
 			"-name", fileName,
 			"-url", req.URL)
-
-		output, err := cmd.CombinedOutput()
 		if err != nil {
 			log.Printf("Failed to download music: %v\nOutput: %s", err, string(output))
 			w.Header().Set("Content-Type", "application/json")
@@ -1738,7 +3299,7 @@ func startHTTPServer(config *Config) error {
 		})
 	}).Methods("POST")
 
-	router.HandleFunc("/create-video", func(w http.ResponseWriter, r *http.Request) {
+	handleTransfer(router, "/create-video", func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != "POST" {
 			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 			return
@@ -1750,7 +3311,11 @@ func startHTTPServer(config *Config) error {
 			VideoName     string   `json:"videoName"`
 			FrameDuration float64  `json:"frameDuration"`
 			Quality       string   `json:"quality"`
+			Preset        string   `json:"preset"` // output preset (see /api/video-presets), overrides quality
 			MusicFile     string   `json:"musicFile"`
+
+			// Narration optionally adds a voiceover that ducks the background music
+			Narration *NarrationOptions `json:"narration"`
 		}
 
 		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -1783,7 +3348,11 @@ func startHTTPServer(config *Config) error {
 		}
 
 		// Create video synchronously so it's ready before we respond
-		if err := createVideoFromPhotos(phoneDir, req.Photos, videoName, req.FrameDuration, req.Quality, req.MusicFile); err != nil {
+		preset := req.Preset
+		if preset == "" {
+			preset = req.Quality
+		}
+		if err := createVideoFromPhotos(phoneDir, req.Photos, videoName, req.FrameDuration, preset, req.MusicFile, req.Narration); err != nil {
 			log.Printf("Error creating video: %v", err)
 			w.Header().Set("Content-Type", "application/json")
 			json.NewEncoder(w).Encode(map[string]interface{}{
@@ -1794,6 +3363,7 @@ func startHTTPServer(config *Config) error {
 		}
 
 		log.Printf("Video created successfully: %s.mp4", videoName)
+		countFeature("video_create")
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(map[string]interface{}{
 			"success":  true,
@@ -1841,7 +3411,7 @@ func startHTTPServer(config *Config) error {
 		thumbDir := filepath.Join(phoneDir, "thumbnails")
 
 		deletedCount := 0
-		var errors []string
+		var errors, deleted []string
 
 		for _, thumbName := range req.Photos {
 			// Extract base name from thumbnail
@@ -1856,13 +3426,37 @@ func startHTTPServer(config *Config) error {
 			videoExts := []string{".mp4", ".mov", ".m4v", ".avi", ".mkv"}
 			allExts := append(imageExts, videoExts...)
 
+			origDir := phoneDir
+			if orig, ok := originalForThumbnail(phoneDir, thumbName); ok {
+				origDir = filepath.Dir(orig) // filed by date
+			}
+
+			// Originals go to the trash, where "Recently deleted" can restore them
 			deletedOriginal := false
 			for _, ext := range allExts {
-				origPath := filepath.Join(phoneDir, base+ext)
-				if err := os.Remove(origPath); err == nil {
-					log.Printf("Deleted original file: %s", origPath)
+				origPath := filepath.Join(origDir, base+ext)
+				if _, err := os.Stat(origPath); err != nil {
+					continue
+				}
+				if err := openCatalog(phoneDir).MoveToTrash(origPath); err != nil {
+					log.Printf("Error moving %s to the trash: %v", origPath, err)
+					continue
+				}
+				log.Printf("Moved original file to the trash: %s", origPath)
+				deleted = append(deleted, origPath)
+				deletedOriginal = true
+				break
+			}
+
+			// An archived original is trashed where it is, without retrieving it first
+			if !deletedOriginal {
+				if key, ok := openCatalog(phoneDir).ArchivedFile(thumbName); ok {
+					if err := trashArchived(phoneDir, key); err != nil {
+						errors = append(errors, fmt.Sprintf("%s: %v", thumbName, err))
+						continue
+					}
+					deleted = append(deleted, openCatalog(phoneDir).Path(key))
 					deletedOriginal = true
-					break
 				}
 			}
 
@@ -1882,6 +3476,7 @@ func startHTTPServer(config *Config) error {
 
 			deletedCount++
 		}
+		notifyMediaChange(phoneDir, mediaChangeDeleted, deleted...)
 
 		w.Header().Set("Content-Type", "application/json")
 		if len(errors) > 0 && deletedCount == 0 {
@@ -1898,6 +3493,82 @@ func startHTTPServer(config *Config) error {
 		}
 	}).Methods("POST")
 
+	// Register a mobile push token for event notifications. Only the device itself may set
+	// its token, so the request carries its device headers like an upload does.
+	router.HandleFunc("/api/push/register", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		var req PushRegistration
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"success": false,
+				"error":   "Invalid request: " + err.Error(),
+			})
+			return
+		}
+
+		baseDir := config.ReceiveDir
+		if baseDir == "" {
+			baseDir = "received"
+		}
+		deviceID, err := requestDevice(config, baseDir, r)
+		if err == nil && req.DeviceID != "" && req.DeviceID != deviceID {
+			err = fmt.Errorf("deviceId does not match X-Device-Id")
+		}
+		if err != nil {
+			log.Printf("Push registration from %s not accepted: %v", r.RemoteAddr, err)
+			w.WriteHeader(http.StatusForbidden)
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"success": false,
+				"error":   err.Error(),
+			})
+			return
+		}
+		req.DeviceID = deviceID
+
+		if err := registerPushToken(config, baseDir, req); err != nil {
+			log.Printf("Error registering push token: %v", err)
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"success": false,
+				"error":   err.Error(),
+			})
+			return
+		}
+		log.Printf("Registered push token for device %s (%s)", req.DeviceID, req.Platform)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": true,
+		})
+	}).Methods("POST")
+
+	// Remove the calling device's push token
+	router.HandleFunc("/api/push/unregister", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		baseDir := config.ReceiveDir
+		if baseDir == "" {
+			baseDir = "received"
+		}
+		deviceID, err := requestDevice(config, baseDir, r)
+		if err != nil {
+			log.Printf("Push unregistration from %s not accepted: %v", r.RemoteAddr, err)
+			w.WriteHeader(http.StatusForbidden)
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"success": false,
+				"error":   err.Error(),
+			})
+			return
+		}
+
+		if err := unregisterPushToken(baseDir, deviceID); err != nil {
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"success": false,
+				"error":   err.Error(),
+			})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": true,
+		})
+	}).Methods("POST")
+
 	// File folder viewer - list files in preset folders (music, data, etc.)
 	router.HandleFunc("/files/{folderName}", func(w http.ResponseWriter, r *http.Request) {
 		vars := mux.Vars(r)
@@ -2060,7 +3731,7 @@ func startHTTPServer(config *Config) error {
 	}).Methods("GET")
 
 	// Download handler for files in preset folders
-	router.HandleFunc("/download/{folderName}/{fileName}", func(w http.ResponseWriter, r *http.Request) {
+	handleTransfer(router, "/download/{folderName}/{fileName}", func(w http.ResponseWriter, r *http.Request) {
 		vars := mux.Vars(r)
 		folderName := vars["folderName"]
 		fileName := vars["fileName"]
@@ -2092,14 +3763,50 @@ func startHTTPServer(config *Config) error {
 		http.ServeFile(w, r, filePath)
 	}).Methods("GET")
 
-	port := config.HttpPort
-	if port == "" {
-		port = ":8080"
-	}
-	if !strings.HasPrefix(port, ":") {
-		port = ":" + port
-	}
-
-	log.Printf("HTTP Server listening on port %s\n", port)
-	return http.ListenAndServe(port, router)
+	// Shared family albums
+	registerAlbumRoutes(router, config)
+	registerCommentRoutes(router, config)
+	registerVideoTrimRoutes(router, config)
+	registerVideoFrameRoutes(router, config)
+	registerVideoAudioRoutes(router, config)
+	registerPanoramaRoutes(router, config)
+	registerPhotoDownloadRoutes(router, config)
+	registerCaptureTimeRoutes(router, config)
+	registerPrintExportRoutes(router, config)
+	registerSyncSessionRoutes(router, config)
+	registerSidecarRoutes(router, config)
+	registerArchiveRoutes(router, config)
+	registerClientLogRoutes(router, config)
+	registerSyncStatusRoutes(router, config)
+	registerNarrationRoutes(router, config)
+	registerVideoPresetRoutes(router, config)
+	registerMetadataRoutes(router, config)
+	registerExportRoutes(router, config)
+	registerWebSocketRoutes(router, config)
+	registerUploadRoutes(router, config)
+	registerMediaListRoutes(router, config)
+	registerDeviceRoutes(router, config)
+	registerFavoriteRoutes(router, config)
+	registerPairingRoutes(router, config)
+	registerTrashRoutes(router, config)
+	registerStorageRoutes(router, config)
+	registerSyncHistoryRoutes(router, config)
+	registerExifRoutes(router, config)
+	registerGeotagRoutes(router, config)
+	registerAnalysisRoutes(router, config)
+	registerSearchRoutes(router, config)
+	registerLayoutUpgradeRoutes(router, config)
+	registerRetentionRoutes(router, config)
+	registerDuplicateRoutes(router, config)
+	registerWebAuthRoutes(router, config)
+	registerScrubRoutes(router, config)
+	registerBackupRoutes(router, config)
+	registerSecondaryRoutes(router, config)
+	registerSnapshotRoutes(router, config)
+	registerDigestRoutes(router, config)
+	registerRemoteExportRoutes(router, config)
+
+	router.Use(routeLimits(config))
+	router.Use(webAuth(config))
+	return router
 }
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// uploadResult is the outcome of one file of a multipart upload
+type uploadResult struct {
+	Name string `json:"name"` // file name as sent
+	Ack
+}
+
+// registerUploadRoutes adds the HTTP multipart ingest path, for desktop uploads and scripted
+// imports. Files land in the phone directory exactly like synced ones.
+//
+// Each "file" part may be preceded by "id", "media", "taken", "mtime" and "sha256" fields, which
+// apply to the next file only; without them the ID and media type come from the file name.
+//
+// Uploads pass the same device approval as syncing: a device sends its ID and, once
+// paired, its device token in the X-Device-Id and X-Device-Token headers and its files
+// go to its registered directory. Without an ID the phone name in the path is checked
+// like SET_PHONE_NAME.
+//
+//	curl -F taken=2024-06-01T12:00:00+02:00 -F file=@IMG_1.jpg http://server:8080/api/phones/Pixel/media
+func registerUploadRoutes(router *mux.Router, config *Config) {
+	handleTransfer(router, "/api/phones/{phoneName}/media", func(w http.ResponseWriter, r *http.Request) {
+		phoneName := mux.Vars(r)["phoneName"]
+		if validatePhoneName(phoneName) != nil {
+			writeJSONStatus(w, http.StatusBadRequest, map[string]interface{}{"success": false, "error": "Invalid phone name"})
+			return
+		}
+		reader, err := r.MultipartReader()
+		if err != nil {
+			writeJSONStatus(w, http.StatusBadRequest, map[string]interface{}{"success": false, "error": "Expected a multipart/form-data upload: " + err.Error()})
+			return
+		}
+
+		baseDir := config.ReceiveDir
+		if baseDir == "" {
+			baseDir = "received"
+		}
+		deviceID := r.Header.Get("X-Device-Id")
+		accessID := deviceID
+		if accessID == "" {
+			accessID = nameDevicePrefix + phoneName
+		}
+		status, err := deviceAccess(config, baseDir, accessID, phoneName, r.Header.Get("X-Device-Token"))
+		if err == nil && status != deviceApproved {
+			err = fmt.Errorf("device is %s, see the server's devices page", status)
+		}
+		if err != nil {
+			log.Printf("HTTP upload from %s for %q not accepted: %v", r.RemoteAddr, accessID, err)
+			writeJSONStatus(w, http.StatusForbidden, map[string]interface{}{"success": false, "error": err.Error()})
+			return
+		}
+		recvDir := filepath.Join(baseDir, phoneName)
+		if deviceID != "" {
+			rec, err := registerDevice(baseDir, deviceID, phoneName)
+			if err != nil {
+				writeJSONStatus(w, http.StatusInternalServerError, map[string]interface{}{"success": false, "error": err.Error()})
+				return
+			}
+			recvDir = filepath.Join(baseDir, rec.Dir)
+		}
+		if err := os.MkdirAll(recvDir, 0o755); err != nil {
+			writeJSONStatus(w, http.StatusInternalServerError, map[string]interface{}{"success": false, "error": err.Error()})
+			return
+		}
+
+		session := newSyncSession(r.RemoteAddr, "http")
+		defer session.close()
+		session.setPhone(phoneName)
+		if deviceID != "" {
+			session.setDevice(deviceID)
+		}
+
+		fields := make(map[string]string)
+		results := []uploadResult{}
+		stored := 0
+		for {
+			part, err := reader.NextPart()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				writeJSONStatus(w, http.StatusBadRequest, map[string]interface{}{"success": false, "error": "Reading upload: " + err.Error(), "results": results})
+				return
+			}
+
+			name := part.FormName()
+			if name != "file" {
+				// Metadata for the next file
+				value, _ := io.ReadAll(io.LimitReader(part, 1024))
+				fields[name] = strings.TrimSpace(string(value))
+				part.Close()
+				continue
+			}
+
+			fileName := filepath.Base(part.FileName())
+			id := fields["id"]
+			if id == "" {
+				id = strings.TrimSuffix(fileName, filepath.Ext(fileName))
+			}
+			media := strings.ToLower(fields["media"])
+			if media == "" {
+				media = strings.ToLower(strings.TrimPrefix(filepath.Ext(fileName), "."))
+			}
+			taken, mtime, sum := fields["taken"], fields["mtime"], fields["sha256"]
+			fields = make(map[string]string)
+
+			ack := func() Ack {
+				if err := validateClientID(id); err != nil {
+					return errorAck(ackKindFile, id, ackCodeInvalid, err)
+				}
+				if !hasExtension("."+media, photoExtensions) && !hasExtension("."+media, videoExtensions) {
+					return errorAck(ackKindFile, id, ackCodeInvalid, fmt.Errorf("unsupported media type %q", media))
+				}
+				limit := maxPayloadSize(config)
+				data, err := io.ReadAll(io.LimitReader(part, limit+1))
+				if err != nil {
+					return errorAck(ackKindFile, id, ackCodeIO, err)
+				}
+				if int64(len(data)) > limit {
+					return errorAck(ackKindFile, id, ackCodeInvalid, fmt.Errorf("file larger than %d bytes", limit))
+				}
+				if sum != "" && !checksumMatches(data, sum) {
+					return errorAck(ackKindFile, id, ackCodeChecksum, fmt.Errorf("sha256 mismatch"))
+				}
+				return storeReceivedFile(config, baseDir, recvDir, session, id, media, taken, mtime, data)
+			}()
+			part.Close()
+
+			if ack.Status == ackStatusOK {
+				stored++
+			}
+			results = append(results, uploadResult{Name: fileName, Ack: ack})
+		}
+
+		if len(results) == 0 {
+			writeJSONStatus(w, http.StatusBadRequest, map[string]interface{}{"success": false, "error": "No file parts in the upload"})
+			return
+		}
+		countFeature("http_upload")
+		log.Printf("HTTP upload to %s: %d of %d file(s) stored", recvDir, stored, len(results))
+
+		if stored > 0 {
+			jobsFor(recvDir).startThumbnails("HTTP upload")
+		}
+		writeJSON(w, map[string]interface{}{"success": stored == len(results), "stored": stored, "results": results})
+	}).Methods("POST")
+}
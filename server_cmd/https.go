@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// aboveFoldThumbs is how many gallery thumbnails are fetched first: about the first screen
+// of a desktop gallery. The rest load lazily.
+const aboveFoldThumbs = 12
+
+// thumbnailCacheControl lets browsers reuse thumbnails across page views without
+// revalidating each one
+const thumbnailCacheControl = "private, max-age=3600"
+
+// serveHTTPS serves the web UI over TLS on config.HttpsPort. net/http negotiates HTTP/2
+// via ALPN, so a gallery page's thumbnails share one multiplexed connection.
+func serveHTTPS(config *Config, handler http.Handler) error {
+	baseDir := config.ReceiveDir
+	if baseDir == "" {
+		baseDir = "received"
+	}
+	cert, err := loadServerCertificate(config, config.TLSCertFile, config.TLSKeyFile, baseDir)
+	if err != nil {
+		return fmt.Errorf("failed to load TLS certificate: %v", err)
+	}
+
+	server := newHTTPServer(config, config.HttpsPort, handler)
+	server.TLSConfig = &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}
+	onShutdown(func(ctx context.Context) { server.Shutdown(ctx) })
+	log.Printf("HTTPS Server (HTTP/2) listening on port %s (certificate sha256 %s)\n", config.HttpsPort, certificateFingerprint(cert))
+	if err := server.ListenAndServeTLS("", ""); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// sendEarlyHints tells HTTP/2 browsers to start fetching the given images (103 Early Hints)
+// while the page is still being rendered
+func sendEarlyHints(w http.ResponseWriter, r *http.Request, images []string) {
+	if r.ProtoMajor < 2 || len(images) == 0 {
+		return
+	}
+	links := make([]string, 0, len(images))
+	for _, img := range images {
+		links = append(links, fmt.Sprintf("<%s>; rel=preload; as=image; fetchpriority=high", img))
+	}
+	w.Header().Set("Link", strings.Join(links, ", "))
+	w.WriteHeader(http.StatusEarlyHints)
+}
+
+// thumbURL returns the path of a thumbnail for use in headers, escaped like the page's links
+func thumbURL(phoneName, thumbName string) string {
+	return "/thumb/" + url.PathEscape(phoneName) + "/" + url.PathEscape(thumbName)
+}
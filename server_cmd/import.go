@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// importResult counts what an import did with the files it found
+type importResult struct {
+	Imported   int
+	Duplicates int
+	Errors     []string
+}
+
+// runImportCommand is the one-shot -import mode: it brings an existing folder tree, such as
+// an old DCIM dump, into the library as phone's files, without starting the server. Photos
+// and videos are copied (or moved), filed by date when organize_by_date is on, recorded in
+// the catalog with their EXIF data and given thumbnails; files the phone has already are
+// left out. Other files and hidden folders are skipped. The server should be stopped while
+// it runs, as both write the catalog.
+func runImportCommand(config *Config, src, phone string, move bool, out io.Writer) error {
+	if phone == "" {
+		return fmt.Errorf("name the phone to import into with -import-phone")
+	}
+	if err := validatePhoneName(phone); err != nil {
+		return err
+	}
+	if info, err := os.Stat(src); err != nil {
+		return err
+	} else if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", src)
+	}
+	baseDir := config.ReceiveDir
+	if baseDir == "" {
+		baseDir = "received"
+	}
+	recvDir := filepath.Join(baseDir, phone)
+	if abs, err := filepath.Abs(src); err == nil {
+		if lib, err := filepath.Abs(recvDir); err == nil && (abs == lib || strings.HasPrefix(abs, lib+string(filepath.Separator))) {
+			return fmt.Errorf("%s is inside the phone's folder already", src)
+		}
+	}
+	migrateCatalogs(baseDir)
+	if err := os.MkdirAll(recvDir, 0o755); err != nil {
+		return err
+	}
+
+	var result importResult
+	catalog := openCatalog(recvDir)
+	err := filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			result.Errors = append(result.Errors, err.Error())
+			return nil
+		}
+		if strings.HasPrefix(d.Name(), ".") && path != src {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() || !(hasExtension(d.Name(), photoExtensions) || hasExtension(d.Name(), videoExtensions)) {
+			return nil
+		}
+		if draining() {
+			return filepath.SkipAll
+		}
+
+		sum, err := calculateSHA256(path)
+		if err != nil {
+			result.Errors = append(result.Errors, fmt.Sprintf("%s: %v", path, err))
+			return nil
+		}
+		if name, ok := catalog.FindByHash(sum); ok {
+			fmt.Fprintf(out, "%s: same as %s, skipped\n", path, name)
+			result.Duplicates++
+			return nil
+		}
+		dest := importDestination(config, recvDir, path)
+		if err := importFile(path, dest, move); err != nil {
+			result.Errors = append(result.Errors, fmt.Sprintf("%s: %v", path, err))
+			return nil
+		}
+		catalog.Record(dest, sum)
+		rel, _ := filepath.Rel(src, path)
+		catalog.SetSource(dest, &MediaSource{Via: "import", ClientID: filepath.ToSlash(rel)})
+		result.Imported++
+		if result.Imported%100 == 0 {
+			fmt.Fprintf(out, "%d file(s) imported\n", result.Imported)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	flushCatalogs()
+
+	if result.Imported > 0 {
+		fmt.Fprintln(out, "Generating thumbnails")
+		if err := generateThumbnails(context.Background(), recvDir); err != nil {
+			result.Errors = append(result.Errors, fmt.Sprintf("thumbnails: %v", err))
+		}
+		flushCatalogs()
+	}
+
+	for _, e := range result.Errors {
+		fmt.Fprintf(out, "  %s\n", e)
+	}
+	fmt.Fprintf(out, "Imported %d file(s) into %s, %d duplicate(s) skipped, %d error(s)\n",
+		result.Imported, phone, result.Duplicates, len(result.Errors))
+	if result.Imported == 0 && len(result.Errors) > 0 {
+		return fmt.Errorf("nothing could be imported")
+	}
+	return nil
+}
+
+// importDestination picks where an imported file goes: its year and month folder with
+// organize_by_date, else the phone folder, under its own name, in a storage pool when the
+// receive directory is nearly full. Thumbnails are named after
+// the file name only, so a name any of the phone's originals has (in any folder, with any
+// media extension) gets a number appended.
+func importDestination(config *Config, recvDir, path string) string {
+	name := filepath.Base(path)
+	ext := filepath.Ext(name)
+	stem := strings.TrimSuffix(name, ext)
+	for i := 1; ; i++ {
+		if _, exists := originalForThumbnail(recvDir, thumbnailName(name)); !exists {
+			break
+		}
+		name = stem + "_" + strconv.Itoa(i) + ext
+	}
+	// Without EXIF the file's own time files it, not the time of the import
+	var taken string
+	var size int64
+	if info, err := os.Stat(path); err == nil {
+		taken, size = info.ModTime().Format(time.RFC3339), info.Size()
+	}
+	dest, _ := datedPath(config, recvDir, filepath.Join(recvDir, name), readFileHead(path), taken)
+	return spillPath(recvDir, dest, size)
+}
+
+// importFile moves or copies src to dest, keeping its modification time. Copies go
+// through a .partial file, so an interrupted import leaves no truncated original; moves
+// across file systems copy, then remove the source.
+func importFile(src, dest string, move bool) error {
+	if err := os.MkdirAll(filepath.Dir(dest), 0o755); err != nil {
+		return err
+	}
+	if move {
+		err := os.Rename(src, dest)
+		if err == nil || !errors.Is(err, syscall.EXDEV) {
+			return err
+		}
+	}
+
+	info, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	f, err := os.CreateTemp(filepath.Dir(dest), "."+filepath.Base(dest)+".*"+partialFileSuffix)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, in)
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(f.Name(), 0o644)
+	}
+	if err == nil {
+		err = os.Chtimes(f.Name(), info.ModTime(), info.ModTime())
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), dest)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	syncDir(filepath.Dir(dest))
+	if move {
+		return os.Remove(src)
+	}
+	return nil
+}
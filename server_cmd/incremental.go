@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// HaveItem describes one media file the client holds locally (msgTypeHaveList payload)
+type HaveItem struct {
+	ID     string `json:"id"`
+	Media  string `json:"media"`
+	Size   int64  `json:"size,omitempty"`   // optional, compared when present
+	SHA256 string `json:"sha256,omitempty"` // optional, compared when present and sizes match
+}
+
+// findMissingMedia returns the items the server doesn't already hold in recvDir.
+// An item counts as present when a file with its storage name exists and, if the client
+// sent them, its size and SHA-256 match.
+func findMissingMedia(recvDir string, items []HaveItem) []HaveItem {
+	missing := make([]HaveItem, 0)
+	for _, item := range items {
+		if item.ID == "" {
+			continue
+		}
+		if !haveMedia(recvDir, item) {
+			missing = append(missing, item)
+		}
+	}
+	return missing
+}
+
+func haveMedia(recvDir string, item HaveItem) bool {
+	if validateClientID(item.ID) != nil {
+		return false // upload it and get the reason in the ACK
+	}
+	fname := mediaFileName(recvDir, item.ID, item.Media)
+	info, err := os.Stat(fname)
+	if err != nil {
+		// Filed by capture date (organize_by_date)
+		for _, dir := range phoneMediaDirs(recvDir)[1:] {
+			if i, statErr := os.Stat(filepath.Join(dir, filepath.Base(fname))); statErr == nil {
+				fname, info, err = filepath.Join(dir, filepath.Base(fname)), i, nil
+				break
+			}
+		}
+	}
+	if err != nil || info.IsDir() {
+		// Archived originals are kept, just not online
+		if e, ok := openCatalog(recvDir).Entry(fname); ok && e.Archived {
+			return (item.Size <= 0 || e.Size == item.Size) && (item.SHA256 == "" || strings.EqualFold(e.SHA256, item.SHA256))
+		}
+		// Uploads that were deduplicated against an identical file count as present
+		return openCatalog(recvDir).HasAlias(fname)
+	}
+	if rerequestDamaged && openCatalog(recvDir).Damaged(fname) != "" {
+		return false // found damaged by the integrity scrub: have the client send it again
+	}
+	if item.Size > 0 && info.Size() != item.Size {
+		return false
+	}
+	if item.SHA256 != "" {
+		sum, err := calculateSHA256(fname)
+		if err != nil {
+			log.Printf("Error hashing %s for missing check: %v", fname, err)
+			return false
+		}
+		if !strings.EqualFold(sum, item.SHA256) {
+			return false
+		}
+	}
+	return true
+}
+
+// buildMissingListPayload answers a HAVE_LIST request with {"missing":[ids...]} and also
+// returns the missing items
+func buildMissingListPayload(recvDir string, payload []byte) ([]byte, []HaveItem, error) {
+	var req struct {
+		Items []HaveItem `json:"items"`
+	}
+	if err := json.Unmarshal(payload, &req); err != nil {
+		return nil, nil, err
+	}
+
+	missing := findMissingMedia(recvDir, req.Items)
+	log.Printf("HAVE_LIST: client has %d items, server is missing %d", len(req.Items), len(missing))
+
+	ids := make([]string, len(missing))
+	for i, item := range missing {
+		ids[i] = item.ID
+	}
+	b, err := json.Marshal(struct {
+		Missing []string `json:"missing"`
+	}{Missing: ids})
+	return b, missing, err
+}
+
+// clientManifest is the local media a client reported holding, for restore listings of
+// what it lacks. Items with a SHA-256 are matched by content; the others by storage name
+// and, if sent, size.
+type clientManifest struct {
+	hashes map[string]bool
+	names  map[string]int64 // storage name -> size, 0 when not sent
+}
+
+func newClientManifest(items []HaveItem) *clientManifest {
+	m := &clientManifest{hashes: make(map[string]bool), names: make(map[string]int64)}
+	for _, item := range items {
+		if item.SHA256 != "" {
+			m.hashes[strings.ToLower(item.SHA256)] = true
+		} else if item.ID != "" {
+			m.names[filepath.Base(mediaFileName("", item.ID, item.Media))] = item.Size
+		}
+	}
+	return m
+}
+
+// has reports whether the client holds the cataloged original
+func (m *clientManifest) has(e *CatalogEntry) bool {
+	if e.SHA256 != "" && m.hashes[strings.ToLower(e.SHA256)] {
+		return true
+	}
+	size, ok := m.names[filepath.Base(filepath.FromSlash(e.Name))]
+	return ok && (size == 0 || size == e.Size)
+}
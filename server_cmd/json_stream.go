@@ -0,0 +1,160 @@
+package main
+
+import (
+	"container/heap"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+)
+
+// Page sizes of the streamed list endpoints
+const (
+	defaultListPageSize = 500
+	maxListPageSize     = 5000
+)
+
+// jsonListWriter streams {"success":true,"<field>":[...],"next":"..."} one item at a time,
+// so a page of a large list is never held in memory as one encoded document
+type jsonListWriter struct {
+	w     http.ResponseWriter
+	enc   *json.Encoder
+	count int
+	err   error
+}
+
+// newJSONListWriter writes the response header and opens the item array
+func newJSONListWriter(w http.ResponseWriter, field string) *jsonListWriter {
+	w.Header().Set("Content-Type", "application/json")
+	l := &jsonListWriter{w: w, enc: json.NewEncoder(w)}
+	_, l.err = fmt.Fprintf(w, `{"success":true,%q:[`, field)
+	return l
+}
+
+// Item appends one element; after a write error (client gone) the rest are dropped
+func (l *jsonListWriter) Item(v interface{}) {
+	if l.err != nil {
+		return
+	}
+	if l.count > 0 {
+		if _, l.err = io.WriteString(l.w, ","); l.err != nil {
+			return
+		}
+	}
+	l.err = l.enc.Encode(v)
+	l.count++
+	if l.count%100 == 0 {
+		if f, ok := l.w.(http.Flusher); ok {
+			f.Flush()
+		}
+	}
+}
+
+// Close ends the array with the count and, when there are more pages, the cursor of the next
+func (l *jsonListWriter) Close(next string) error {
+	if l.err != nil {
+		return l.err
+	}
+	if next != "" {
+		_, l.err = fmt.Fprintf(l.w, `],"count":%d,"next":%q}`+"\n", l.count, next)
+	} else {
+		_, l.err = fmt.Fprintf(l.w, `],"count":%d}`+"\n", l.count)
+	}
+	return l.err
+}
+
+// encodeListCursor makes the opaque pagination token resuming a name-ordered list after name
+func encodeListCursor(name string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(name))
+}
+
+// decodeListCursor returns the name a cursor resumes after; "" starts at the beginning
+func decodeListCursor(cursor string) (string, error) {
+	b, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return "", fmt.Errorf("invalid cursor")
+	}
+	return string(b), nil
+}
+
+// listPageSize reads the limit query parameter
+func listPageSize(r *http.Request) int {
+	limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+	if err != nil || limit <= 0 {
+		return defaultListPageSize
+	}
+	if limit > maxListPageSize {
+		return maxListPageSize
+	}
+	return limit
+}
+
+// nameHeap is a max-heap of names, keeping the smallest n seen
+type nameHeap []string
+
+func (h nameHeap) Len() int            { return len(h) }
+func (h nameHeap) Less(i, j int) bool  { return h[i] > h[j] }
+func (h nameHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *nameHeap) Push(x interface{}) { *h = append(*h, x.(string)) }
+func (h *nameHeap) Pop() interface{} {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
+
+// pageDirNames returns, in order, the first limit names in dirs after the name after that
+// keep accepts, and whether more follow. Directories are read in batches and only limit+1
+// names are kept, so memory stays flat however many files they hold.
+func pageDirNames(dirs []string, after string, limit int, keep func(os.DirEntry) bool) ([]string, bool, error) {
+	h := &nameHeap{}
+	for _, dir := range dirs {
+		if err := heapDirNames(h, dir, after, limit, keep); err != nil {
+			return nil, false, err
+		}
+	}
+
+	more := h.Len() > limit
+	if more {
+		heap.Pop(h) // the largest only tells that another page exists
+	}
+	names := make([]string, h.Len())
+	for i := len(names) - 1; i >= 0; i-- {
+		names[i] = heap.Pop(h).(string)
+	}
+	return names, more, nil
+}
+
+// heapDirNames adds the names of dir to h, keeping the smallest limit+1
+func heapDirNames(h *nameHeap, dir, after string, limit int, keep func(os.DirEntry) bool) error {
+	f, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	for {
+		entries, err := f.ReadDir(256)
+		for _, e := range entries {
+			name := e.Name()
+			if name <= after || !keep(e) {
+				continue
+			}
+			if h.Len() <= limit {
+				heap.Push(h, name)
+			} else if name < (*h)[0] {
+				(*h)[0] = name
+				heap.Fix(h, 0)
+			}
+		}
+		if err == io.EOF || len(entries) == 0 {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"io/fs"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	// defaultIdleTimeout closes sync connections that send nothing for this long
+	defaultIdleTimeout = 5 * time.Minute
+
+	// defaultStaleTransferTimeout drops chunked transfers that received no chunk for this long
+	defaultStaleTransferTimeout = 10 * time.Minute
+
+	// defaultHeaderReadTimeout is how long the rest of a message header may take after its first byte
+	defaultHeaderReadTimeout = 30 * time.Second
+
+	// maxPingPayload limits the opaque payload a client may attach to a ping
+	maxPingPayload = 1024
+
+	// maxPayloadLimitMB keeps max_payload_mb within the protocol's 32-bit length field
+	maxPayloadLimitMB = 4095
+)
+
+// idleTimeout returns the configured sync connection idle timeout
+func idleTimeout(config *Config) time.Duration {
+	if config == nil || config.IdleTimeoutSec <= 0 {
+		return defaultIdleTimeout
+	}
+	return time.Duration(config.IdleTimeoutSec) * time.Second
+}
+
+// staleTransferTimeout returns the configured inactivity limit for chunked transfers
+func staleTransferTimeout(config *Config) time.Duration {
+	if config == nil || config.StaleTransferMin <= 0 {
+		return defaultStaleTransferTimeout
+	}
+	return time.Duration(config.StaleTransferMin) * time.Minute
+}
+
+// maxPayloadSize returns the configured limit for a single message payload or uploaded file;
+// without one, the hardware profile may lower the default
+func maxPayloadSize(config *Config) int64 {
+	if config == nil || config.MaxPayloadMB <= 0 {
+		if hardware.MaxPayloadBytes > 0 {
+			return hardware.MaxPayloadBytes
+		}
+		return defaultMaxPayloadSize
+	}
+	return int64(min(config.MaxPayloadMB, maxPayloadLimitMB)) << 20
+}
+
+// headerReadTimeout returns how long a message header may take once it started arriving
+func headerReadTimeout(config *Config) time.Duration {
+	if config == nil || config.HeaderReadTimeoutSec <= 0 {
+		return defaultHeaderReadTimeout
+	}
+	return time.Duration(config.HeaderReadTimeoutSec) * time.Second
+}
+
+// payloadReadTimeout returns how long reading one message payload may take, 0 for no limit
+func payloadReadTimeout(config *Config) time.Duration {
+	if config == nil || config.PayloadReadTimeoutSec <= 0 {
+		return 0
+	}
+	return time.Duration(config.PayloadReadTimeoutSec) * time.Second
+}
+
+// idleConn pushes the connection deadline forward on every read and write, so a peer that
+// stalls (even mid-payload) fails the blocked call after idle instead of hanging forever,
+// while slow but steady transfers of large payloads keep going. limitReads additionally
+// caps how long the reads of one message part may take altogether.
+type idleConn struct {
+	net.Conn
+	idle     time.Duration
+	deadline time.Time // end of the current message part, zero when unlimited
+}
+
+func newIdleConn(conn net.Conn, idle time.Duration) *idleConn {
+	return &idleConn{Conn: conn, idle: idle}
+}
+
+// limitReads gives the following reads d in total, until the next call; 0 lifts the limit
+func (c *idleConn) limitReads(d time.Duration) {
+	if d <= 0 {
+		c.deadline = time.Time{}
+		return
+	}
+	c.deadline = time.Now().Add(d)
+}
+
+func (c *idleConn) Read(b []byte) (int, error) {
+	deadline := time.Now().Add(c.idle)
+	if !c.deadline.IsZero() && c.deadline.Before(deadline) {
+		deadline = c.deadline
+	}
+	if err := c.Conn.SetReadDeadline(deadline); err != nil {
+		return 0, err
+	}
+	return c.Conn.Read(b)
+}
+
+func (c *idleConn) Write(b []byte) (int, error) {
+	if err := c.Conn.SetWriteDeadline(time.Now().Add(c.idle)); err != nil {
+		return 0, err
+	}
+	return c.Conn.Write(b)
+}
+
+// dropStaleTransfers removes chunked transfers of this connection that have been inactive
+// for longer than maxIdle, deleting their temp files
+func dropStaleTransfers(chunkedFiles map[string]*ChunkedFileInfo, maxIdle time.Duration) {
+	for id, info := range chunkedFiles {
+		if clock.Since(info.LastActivity) < maxIdle {
+			continue
+		}
+		if info.TempFile != nil {
+			info.TempFile.Close()
+		}
+		if info.TempFilePath != "" {
+			os.Remove(info.TempFilePath)
+		}
+		delete(chunkedFiles, id)
+		log.Printf("Dropped stale chunked transfer %s (%d/%d chunks, idle %v)",
+			id, info.ReceivedChunks, info.TotalChunks, clock.Since(info.LastActivity).Round(time.Second))
+	}
+}
+
+// cleanStaleChunkedTempFiles removes transfer temp files (and the progress of transfers kept
+// at shutdown) that haven't been written for maxAge, left behind by crashes, restarts or
+// connections that were never closed cleanly
+func cleanStaleChunkedTempFiles(baseDir string, maxAge time.Duration) {
+	removed := 0
+	filepath.WalkDir(baseDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if d.IsDir() {
+			if d.Name() == "thumbnails" {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		name := d.Name()
+		partial := strings.HasPrefix(name, ".") && strings.HasSuffix(name, partialFileSuffix)
+		if !partial && (!(strings.HasPrefix(name, ".chunked_") || strings.HasPrefix(name, ".upload_")) ||
+			!(strings.HasSuffix(name, ".tmp") || strings.HasSuffix(name, ".tmp"+chunkedStateSuffix))) {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil || clock.Since(info.ModTime()) < maxAge {
+			return nil
+		}
+		if err := os.Remove(path); err == nil {
+			removed++
+		}
+		return nil
+	})
+	if removed > 0 {
+		log.Printf("Removed %d stale chunked transfer temp file(s)", removed)
+	}
+}
@@ -0,0 +1,574 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	// layoutJournalFileName records the moves of a phone's layout upgrade, one JSON line
+	// each, so the upgrade can be rolled back even after a restart
+	layoutJournalFileName = ".layout_upgrade.jsonl"
+
+	// layoutMoveInterval spaces out the moves, so syncs and the gallery keep their share
+	// of the disk while a large library is upgraded
+	layoutMoveInterval = 20 * time.Millisecond
+
+	// layoutAlbumBatch is how many moves go by between rewrites of the album references
+	layoutAlbumBatch = 100
+
+	// maxLayoutErrors caps the per-file errors kept on the upgrade job
+	maxLayoutErrors = 50
+)
+
+// layoutMove is one journal line: an original moved between two names of the phone's
+// catalog. It is written before the move, so after a crash at most the last line names a
+// move that didn't happen, which a rollback skips.
+type layoutMove struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// LayoutPhone is the layout of one phone directory as the upgrade assistant sees it
+type LayoutPhone struct {
+	Phone string `json:"phone"`
+	Flat  int    `json:"flat"`  // originals still directly in the phone directory
+	Moved int    `json:"moved"` // moves in the journal, undone by a rollback
+}
+
+// LayoutUpgrade is the upgrade assistant's current or last job; the fields are guarded by
+// layoutUpgradeMutex
+type LayoutUpgrade struct {
+	Status   string     `json:"status"` // idle, upgrading, rolling_back, done, rolled_back, cancelled or failed
+	Phone    string     `json:"phone,omitempty"`
+	Total    int        `json:"total"`
+	Done     int        `json:"done"`
+	Errors   []string   `json:"errors,omitempty"`
+	Started  *time.Time `json:"started,omitempty"`
+	Finished *time.Time `json:"finished,omitempty"`
+	cancel   context.CancelFunc
+}
+
+var (
+	layoutUpgradeMutex sync.Mutex
+	layoutUpgrade      = &LayoutUpgrade{Status: "idle"}
+)
+
+// layoutMoveSpacing is the pause between moves: layoutMoveInterval while the server runs,
+// none for the -reorganize command, which has the disk to itself
+var layoutMoveSpacing = layoutMoveInterval
+
+func (u *LayoutUpgrade) running() bool {
+	return u.Status == "upgrading" || u.Status == "rolling_back"
+}
+
+// snapshot returns a copy of the job that is safe to encode. Callers hold layoutUpgradeMutex.
+func (u *LayoutUpgrade) snapshot() LayoutUpgrade {
+	s := *u
+	s.Errors = append([]string(nil), u.Errors...)
+	s.cancel = nil
+	return s
+}
+
+func (u *LayoutUpgrade) update(fn func(u *LayoutUpgrade)) {
+	layoutUpgradeMutex.Lock()
+	fn(u)
+	layoutUpgradeMutex.Unlock()
+}
+
+func (u *LayoutUpgrade) fail(format string, args ...interface{}) {
+	msg := fmt.Sprintf(format, args...)
+	log.Printf("Layout upgrade: %s", msg)
+	u.update(func(u *LayoutUpgrade) {
+		if len(u.Errors) < maxLayoutErrors {
+			u.Errors = append(u.Errors, msg)
+		}
+	})
+}
+
+// flatOriginals lists the originals kept directly in a phone directory, the layout of
+// servers before organize_by_date
+func flatOriginals(phoneDir string) []string {
+	entries, err := os.ReadDir(phoneDir)
+	if err != nil {
+		return nil
+	}
+	var paths []string
+	for _, e := range entries {
+		name := e.Name()
+		if e.Type().IsRegular() && !strings.HasPrefix(name, ".") && (hasExtension(name, photoExtensions) || hasExtension(name, videoExtensions)) {
+			paths = append(paths, filepath.Join(phoneDir, name))
+		}
+	}
+	return paths
+}
+
+func readLayoutJournal(phoneDir string) ([]layoutMove, error) {
+	f, err := os.Open(filepath.Join(phoneDir, layoutJournalFileName))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+	var moves []layoutMove
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var m layoutMove
+		if err := json.Unmarshal(scanner.Bytes(), &m); err == nil && m.From != "" && m.To != "" {
+			moves = append(moves, m)
+		}
+	}
+	return moves, scanner.Err()
+}
+
+func appendLayoutJournal(phoneDir string, m layoutMove) error {
+	b, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(filepath.Join(phoneDir, layoutJournalFileName), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(b, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// layoutPhones returns the phones with originals still to file by date or moves that can
+// be rolled back
+func layoutPhones(baseDir string) []LayoutPhone {
+	var phones []LayoutPhone
+	for _, phone := range libraryPhones(baseDir) {
+		phoneDir := filepath.Join(baseDir, phone)
+		moves, _ := readLayoutJournal(phoneDir)
+		p := LayoutPhone{Phone: phone, Flat: len(flatOriginals(phoneDir)), Moved: len(moves)}
+		if p.Flat > 0 || p.Moved > 0 {
+			phones = append(phones, p)
+		}
+	}
+	return phones
+}
+
+// renameAlbumItems points the album items of a phone at the new names of moved originals
+func renameAlbumItems(baseDir, phone string, renames map[string]string) {
+	if len(renames) == 0 {
+		return
+	}
+	albumsMutex.Lock()
+	defer albumsMutex.Unlock()
+	albums, err := loadAlbums(baseDir)
+	if err != nil {
+		log.Printf("Layout upgrade: albums not updated: %v", err)
+		return
+	}
+	changed := false
+	for _, a := range albums {
+		for i, it := range a.Items {
+			if to, ok := renames[it.Name]; ok && it.Phone == phone {
+				a.Items[i].Name = to
+				changed = true
+			}
+		}
+	}
+	if changed {
+		if err := saveAlbums(baseDir, albums); err != nil {
+			log.Printf("Layout upgrade: albums not updated: %v", err)
+		}
+	}
+}
+
+// pause waits between moves; it reports false when the job was cancelled meanwhile
+func pause(ctx context.Context) bool {
+	if layoutMoveSpacing <= 0 {
+		return ctx.Err() == nil
+	}
+	select {
+	case <-ctx.Done():
+		return false
+	case <-time.After(layoutMoveSpacing):
+		return true
+	}
+}
+
+// upgradePhone files the flat originals of a phone under <year>/<month> by capture time,
+// journaling every move. The gallery keeps working meanwhile: each move renames the file
+// and its catalog entry together, and thumbnails stay where they are.
+func upgradePhone(ctx context.Context, baseDir, phone string, u *LayoutUpgrade) {
+	phoneDir := filepath.Join(baseDir, phone)
+	catalog := openCatalog(phoneDir)
+	loc := catalog.Location()
+	renames := make(map[string]string)
+	defer func() { renameAlbumItems(baseDir, phone, renames) }()
+
+	for _, path := range flatOriginals(phoneDir) {
+		if !pause(ctx) {
+			return
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			continue // deleted meanwhile
+		}
+		t := catalog.CaptureTime(path, info).In(loc)
+		to := filepath.Join(phoneDir, t.Format("2006"), t.Format("01"), filepath.Base(path))
+		m := layoutMove{From: catalog.catalogName(path), To: catalog.catalogName(to)}
+		if _, err := os.Lstat(to); err == nil {
+			u.fail("%s/%s kept in place: %s exists", phone, m.From, m.To)
+		} else if err := appendLayoutJournal(phoneDir, m); err != nil {
+			u.fail("%s: journal not writable, stopping: %v", phone, err)
+			return
+		} else if err := catalog.Relocate(path, to); err != nil {
+			u.fail("%s/%s: %v", phone, m.From, err)
+		} else {
+			renames[m.From] = m.To
+		}
+		u.update(func(u *LayoutUpgrade) { u.Done++ })
+		if len(renames) >= layoutAlbumBatch {
+			renameAlbumItems(baseDir, phone, renames)
+			renames = make(map[string]string)
+		}
+	}
+}
+
+// rollbackPhone undoes the journaled moves of a phone, newest first. Moves that can't be
+// undone stay in the journal for another try.
+func rollbackPhone(ctx context.Context, baseDir, phone string, u *LayoutUpgrade) {
+	phoneDir := filepath.Join(baseDir, phone)
+	catalog := openCatalog(phoneDir)
+	moves, err := readLayoutJournal(phoneDir)
+	if err != nil {
+		u.fail("%s: journal unreadable: %v", phone, err)
+		return
+	}
+	renames := make(map[string]string)
+	var kept []layoutMove
+	for i := len(moves) - 1; i >= 0; i-- {
+		m := moves[i]
+		if ctx.Err() != nil || !pause(ctx) {
+			kept = append(kept, moves[:i+1]...)
+			break
+		}
+		from := filepath.Join(phoneDir, filepath.FromSlash(m.From))
+		to := filepath.Join(phoneDir, filepath.FromSlash(m.To))
+		if _, err := os.Stat(to); err != nil {
+			// Never moved (the crash case), or deleted since: nothing to undo
+		} else if err := catalog.Relocate(to, from); err != nil {
+			u.fail("%s/%s: %v", phone, m.To, err)
+			kept = append([]layoutMove{m}, kept...)
+		} else {
+			renames[m.To] = m.From
+			os.Remove(filepath.Dir(to)) // the month and year directories, once empty
+			os.Remove(filepath.Dir(filepath.Dir(to)))
+		}
+		u.update(func(u *LayoutUpgrade) { u.Done++ })
+	}
+	renameAlbumItems(baseDir, phone, renames)
+
+	journal := filepath.Join(phoneDir, layoutJournalFileName)
+	os.Remove(journal)
+	for _, m := range kept {
+		if err := appendLayoutJournal(phoneDir, m); err != nil {
+			u.fail("%s: journal not rewritten: %v", phone, err)
+			return
+		}
+	}
+}
+
+// startLayoutUpgrade starts filing the flat originals of every phone by date, or with
+// rollback set, undoing the journaled moves, one phone at a time in the background
+func startLayoutUpgrade(config *Config, baseDir string, rollback bool) error {
+	if !rollback && !config.OrganizeByDate {
+		return fmt.Errorf("turn on organize_by_date in the config first, so new uploads are filed the same way")
+	}
+	phones := layoutPhones(baseDir)
+	total := 0
+	for _, p := range phones {
+		if rollback {
+			total += p.Moved
+		} else {
+			total += p.Flat
+		}
+	}
+	if total == 0 {
+		return fmt.Errorf("nothing to do")
+	}
+
+	layoutUpgradeMutex.Lock()
+	defer layoutUpgradeMutex.Unlock()
+	if layoutUpgrade.running() {
+		return fmt.Errorf("an upgrade is already running")
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	now := clock.Now()
+	status := "upgrading"
+	if rollback {
+		status = "rolling_back"
+	}
+	u := &LayoutUpgrade{Status: status, Total: total, Started: &now, cancel: cancel}
+	layoutUpgrade = u
+
+	go func() {
+		defer cancel()
+		for _, p := range phones {
+			if ctx.Err() != nil {
+				break
+			}
+			u.update(func(u *LayoutUpgrade) { u.Phone = p.Phone })
+			if rollback {
+				rollbackPhone(ctx, baseDir, p.Phone, u)
+			} else if p.Flat > 0 {
+				upgradePhone(ctx, baseDir, p.Phone, u)
+			}
+		}
+		u.update(func(u *LayoutUpgrade) {
+			finished := clock.Now()
+			u.Finished, u.Phone = &finished, ""
+			switch {
+			case ctx.Err() != nil:
+				u.Status = "cancelled"
+			case rollback:
+				u.Status = "rolled_back"
+			default:
+				u.Status = "done"
+			}
+			if len(u.Errors) > 0 && u.Done == 0 {
+				u.Status = "failed"
+			}
+			log.Printf("Layout upgrade %s: %d of %d originals, %d error(s)", u.Status, u.Done, u.Total, len(u.Errors))
+		})
+	}()
+	return nil
+}
+
+// runReorganizeCommand is the one-shot -reorganize mode: it files the legacy flat phone
+// folders by date ("date") or undoes that ("rollback") without starting the server,
+// printing progress to out. Thumbnails keep their names, which only depend on the file
+// name; the catalogs and albums are updated as the files move. An interrupted run is
+// resumed by running it again, as every finished move is journaled.
+func runReorganizeCommand(config *Config, mode string, out io.Writer) error {
+	if mode != "date" && mode != "rollback" {
+		return fmt.Errorf("unknown mode %q, use date or rollback", mode)
+	}
+	baseDir := config.ReceiveDir
+	if baseDir == "" {
+		baseDir = "received"
+	}
+	migrateCatalogs(baseDir)
+	todo := 0
+	for _, p := range layoutPhones(baseDir) {
+		fmt.Fprintf(out, "%s: %d original(s) in the phone folder, %d filed by date\n", p.Phone, p.Flat, p.Moved)
+		if mode == "rollback" {
+			todo += p.Moved
+		} else {
+			todo += p.Flat
+		}
+	}
+	if todo == 0 {
+		fmt.Fprintln(out, "Nothing to reorganize")
+		return nil
+	}
+
+	layoutMoveSpacing = 0
+	if err := startLayoutUpgrade(config, baseDir, mode == "rollback"); err != nil {
+		return err
+	}
+	var job LayoutUpgrade
+	last := ""
+	for {
+		layoutUpgradeMutex.Lock()
+		job = layoutUpgrade.snapshot()
+		layoutUpgradeMutex.Unlock()
+		if progress := fmt.Sprintf("%s %d/%d", job.Phone, job.Done, job.Total); job.running() && job.Phone != "" && progress != last {
+			fmt.Fprintf(out, "%s: %d of %d originals (%d%%)\n", job.Phone, job.Done, job.Total, job.Done*100/max(1, job.Total))
+			last = progress
+		}
+		if !job.running() {
+			break
+		}
+		time.Sleep(time.Second)
+	}
+	flushCatalogs()
+
+	for _, e := range job.Errors {
+		fmt.Fprintf(out, "  %s\n", e)
+	}
+	fmt.Fprintf(out, "%s: %d of %d originals, %d error(s)\n", strings.ReplaceAll(job.Status, "_", " "), job.Done, job.Total, len(job.Errors))
+	if job.Status == "failed" {
+		return fmt.Errorf("nothing could be moved")
+	}
+	return nil
+}
+
+// registerLayoutUpgradeRoutes adds the upgrade assistant for legacy flat phone directories
+func registerLayoutUpgradeRoutes(router *mux.Router, config *Config) {
+	baseDirFor := func() string {
+		if config.ReceiveDir == "" {
+			return "received"
+		}
+		return config.ReceiveDir
+	}
+
+	router.HandleFunc("/api/upgrade", func(w http.ResponseWriter, r *http.Request) {
+		layoutUpgradeMutex.Lock()
+		job := layoutUpgrade.snapshot()
+		layoutUpgradeMutex.Unlock()
+		phones := layoutPhones(baseDirFor())
+		if phones == nil {
+			phones = []LayoutPhone{}
+		}
+		writeJSON(w, map[string]interface{}{"success": true, "organizeByDate": config.OrganizeByDate, "phones": phones, "job": job})
+	}).Methods("GET")
+
+	for _, action := range []string{"start", "rollback"} {
+		rollback := action == "rollback"
+		router.HandleFunc("/api/upgrade/"+action, func(w http.ResponseWriter, r *http.Request) {
+			if err := startLayoutUpgrade(config, baseDirFor(), rollback); err != nil {
+				writeJSON(w, map[string]interface{}{"success": false, "error": err.Error()})
+				return
+			}
+			countFeature("layout_upgrade")
+			writeJSON(w, map[string]interface{}{"success": true})
+		}).Methods("POST")
+	}
+
+	router.HandleFunc("/api/upgrade/cancel", func(w http.ResponseWriter, r *http.Request) {
+		layoutUpgradeMutex.Lock()
+		if layoutUpgrade.running() {
+			layoutUpgrade.cancel()
+		}
+		layoutUpgradeMutex.Unlock()
+		writeJSON(w, map[string]interface{}{"success": true})
+	}).Methods("POST")
+
+	router.HandleFunc("/upgrade", func(w http.ResponseWriter, r *http.Request) {
+		tmpl := `<!DOCTYPE html>
+<html>
+<head>
+    <title>Upgrade Assistant - Photo Sync Server</title>
+    <style>
+        body { font-family: 'Segoe UI', Tahoma, Arial, sans-serif; margin: 0; padding: 20px; background: #000000; color: #ffffff; }
+        h1 { color: #ffffff; font-weight: 300; letter-spacing: 1px; }
+        h2 { font-size: 20px; margin-top: 30px; color: #aaaaaa; font-weight: 300; }
+        .back-link { display: inline-block; margin-bottom: 20px; color: #88aaff; text-decoration: none; font-size: 14px; }
+        .back-link:hover { color: #aaccff; text-decoration: underline; }
+        .panel { background: #1a1a1a; border: 1px solid #2a2a2a; border-radius: 12px; padding: 20px; max-width: 700px; font-size: 14px; color: #aaaaaa; }
+        .hint { color: #888888; font-size: 12px; }
+        table { border-collapse: collapse; width: 100%; max-width: 700px; font-size: 13px; }
+        th, td { text-align: left; padding: 8px; border-bottom: 1px solid #2a2a2a; }
+        th { color: #888888; font-weight: normal; }
+        button { background: #667eea; color: #ffffff; border: none; border-radius: 6px; padding: 10px 20px; font-size: 14px; cursor: pointer; margin: 16px 8px 0 0; }
+        button:hover { background: #5a6fd6; }
+        button.secondary { background: #333333; }
+        button:disabled { opacity: 0.4; cursor: default; }
+        .error { color: #ff6b6b; margin-top: 12px; }
+        .bar { background: #333333; border-radius: 4px; height: 8px; margin: 8px 0; overflow: hidden; }
+        .bar div { background: #667eea; height: 100%; }
+        .status-done, .status-rolled_back { color: #4ade80; }
+        .status-failed, .status-cancelled { color: #ff6b6b; }
+    </style>
+</head>
+<body>
+    <a href="/" class="back-link">← Back to Home</a>
+    <h1>🧳 Upgrade Assistant</h1>
+    <div class="panel">
+        Phones synced before organize_by_date keep their originals directly in the phone folder.
+        The assistant files them under year and month folders by capture time, one phone at a time,
+        while the gallery and syncs keep working. Every move is journaled and can be rolled back.
+        <div class="hint">Catalogs and thumbnails are upgraded on their own at startup.</div>
+        <div class="error" id="note"></div>
+        <div>
+            <button id="start" onclick="act('start')">File by date</button>
+            <button id="rollback" class="secondary" onclick="act('rollback')">Roll back</button>
+            <button id="cancel" class="secondary" onclick="act('cancel')">Stop</button>
+        </div>
+        <div class="error" id="error"></div>
+    </div>
+
+    <h2>Progress</h2>
+    <div class="panel" id="job"><span class="hint">Not started.</span></div>
+
+    <h2>Phones</h2>
+    <table id="phones"></table>
+
+    <script>
+        function escapeHTML(s) {
+            return String(s).replace(/[&<>"']/g, function(c) {
+                return {'&': '&amp;', '<': '&lt;', '>': '&gt;', '"': '&quot;', "'": '&#39;'}[c];
+            });
+        }
+
+        function act(action) {
+            document.getElementById('error').textContent = '';
+            fetch('/api/upgrade/' + action, {method: 'POST'})
+                .then(function(r) { return r.json(); })
+                .then(function(data) {
+                    if (!data.success) document.getElementById('error').textContent = data.error;
+                    refresh();
+                })
+                .catch(function(e) { document.getElementById('error').textContent = 'Error: ' + e; });
+        }
+
+        function refresh() {
+            fetch('/api/upgrade')
+                .then(function(r) { return r.json(); })
+                .then(function(data) {
+                    const job = data.job, phones = data.phones || [];
+                    const running = job.status === 'upgrading' || job.status === 'rolling_back';
+                    const flat = phones.reduce(function(n, p) { return n + p.flat; }, 0);
+                    const moved = phones.reduce(function(n, p) { return n + p.moved; }, 0);
+                    document.getElementById('start').disabled = running || !flat || !data.organizeByDate;
+                    document.getElementById('rollback').disabled = running || !moved;
+                    document.getElementById('cancel').disabled = !running;
+                    document.getElementById('note').textContent = data.organizeByDate ? '' :
+                        'Turn on organize_by_date in the config first, so new uploads are filed the same way.';
+
+                    if (job.status !== 'idle') {
+                        const percent = job.total > 0 ? Math.min(100, 100 * job.done / job.total) : 100;
+                        let html = '<span class="status-' + job.status + '">' + job.status.replace('_', ' ') + '</span>';
+                        if (job.phone) html += ' · ' + escapeHTML(job.phone);
+                        html += '<div class="bar"><div style="width:' + percent.toFixed(1) + '%"></div></div>';
+                        html += job.done + ' / ' + job.total + ' originals';
+                        (job.errors || []).forEach(function(e) { html += '<br><span class="status-failed">' + escapeHTML(e) + '</span>'; });
+                        document.getElementById('job').innerHTML = html;
+                    }
+
+                    document.getElementById('phones').innerHTML = phones.length ?
+                        '<tr><th>Phone</th><th>In the phone folder</th><th>Filed by the assistant</th></tr>' + phones.map(function(p) {
+                            return '<tr><td>📱 ' + escapeHTML(p.phone) + '</td><td>' + p.flat + '</td><td>' + p.moved + '</td></tr>';
+                        }).join('') : '<tr><td class="hint">Every phone folder is up to date.</td></tr>';
+                })
+                .catch(function() {});
+        }
+
+        refresh();
+        setInterval(refresh, 2000);
+    </script>
+</body>
+</html>`
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if err := template.Must(template.New("upgrade").Parse(tmpl)).Execute(w, nil); err != nil {
+			log.Printf("Error rendering upgrade page: %v", err)
+		}
+	}).Methods("GET")
+}
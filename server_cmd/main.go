@@ -3,9 +3,12 @@ package main
 import (
 	"context"
 	"crypto/md5"
+	"crypto/sha256"
 	"encoding/base64"
 	"encoding/binary"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"image"
@@ -16,11 +19,13 @@ import (
 	"log"
 	"net"
 	"os"
-	"os/exec"
+	"os/signal"
 	"path/filepath"
-	"sort"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	"golang.org/x/image/draw"
@@ -28,9 +33,16 @@ import (
 
 const (
 	version    = "1.0.0"
-	tcpPort    = ":9922"
-	udpPort    = ":7799"
 	bufferSize = 1024
+
+	// Default ports, overridable with tcp_port, udp_port and http_port in the config
+	defaultTCPPort  = ":9922"
+	defaultUDPPort  = ":7799"
+	defaultHTTPPort = ":8080"
+
+	// defaultMaxPayloadSize limits a single message payload unless max_payload_mb is set
+	// (500MB, to handle large videos)
+	defaultMaxPayloadSize = 500 * 1024 * 1024
 )
 
 // protocol format : type(1 byte) + length(4 bytes big-endian) + payload (JSON or raw string)
@@ -44,39 +56,352 @@ const (
 	msgTypeMediaCountRsp        byte = 6  // response with total media count
 	msgTypeMediaThumbList       byte = 7  // request for media thumbnail list (page index and page size in data)
 	msgTypeMediaThumbData       byte = 8  // response with media thumbnail data
-	msgTypeMediaDelList         byte = 9  // request for media deletion list
-	msgTypeMediaDelAck          byte = 10 // acknowledgment for media deletion request
+	msgTypeMediaDelList         byte = 9  // {"ids":[...]} delete now, {"ids":[...],"preview":true} describe them first, {"confirm":"<token>"} delete the previewed ones
+	msgTypeMediaDelAck          byte = 10 // answer to MEDIA_DEL_LIST {"preview","confirm","deleted","notFound","error"}
 	msgTypeMediaDownloadList    byte = 11 // request for media download
 	msgTypeMediaDownloadAck     byte = 12 // acknowledgment for media download request
-	msgTypeChunkedVideoStart    byte = 13 // chunked video start - initiates chunked video transfer
-	msgTypeChunkedVideoData     byte = 14 // chunked video data - one chunk of video data
-	msgTypeChunkedVideoComplete byte = 15 // chunked video complete - all chunks sent
+	msgTypeChunkedVideoStart    byte = 13 // chunked file start - initiates chunked transfer of a video or large photo
+	msgTypeChunkedVideoData     byte = 14 // chunked file data - one chunk of file data
+	msgTypeChunkedVideoComplete byte = 15 // chunked file complete - all chunks sent
+	msgTypeRegisterDevice       byte = 16 // payload JSON {"deviceId":"...","name":"..."}, binds the connection to a stable device identity
+	msgTypeHaveList             byte = 17 // client's local media list {"items":[{id,media,size,sha256}]}
+	msgTypeMissingList          byte = 18 // response with the IDs the server doesn't have {"missing":[...]}
+	msgTypeHello                byte = 19 // version/capabilities handshake, sent by the client first and answered by the server
+	msgTypePing                 byte = 20 // keepalive, answered with msgTypePing echoing the (optional) payload
+	msgTypeClientLog            byte = 21 // client diagnostic report(s) {"level","message","details",...}, stored per device
+	msgTypeSyncProgress         byte = 22 // server to client only: periodic progress {"bytesReceived","filesCompleted","etaSeconds",...}
+	msgTypeBatchUpload          byte = 23 // zip/tar of many small files {"batchId","format","data","entries":[...]}, one ACK per entry
+	msgTypeSyncSummary          byte = 24 // server to client only: answer to SYNC_COMPLETE {"filesReceived","bytesReceived","duplicates","failures",...}
+	msgTypeSubscribeChanges     byte = 25 // {"phones":[...]} selects whose media changes are pushed (empty: own phone, "*": all), echoed back
+	msgTypeMediaChanged         byte = 26 // server to client only: media added/deleted on the server {"phone","event","ids","names"}
+	msgTypeDeltaStart           byte = 27 // chunked start of a re-sent file with block signatures {...,"blocks":[{weak,strong}]}, answered with the chunks to send
 
 	// Server ACK type (matches client type for simplicity)
 	msgTypeAck byte = msgTypeSyncComplete
 )
 
-// ChunkedVideoInfo tracks ongoing chunked video transfers
-type ChunkedVideoInfo struct {
+// Media file extensions recognised in phone directories
+var (
+	photoExtensions = []string{".jpg", ".jpeg", ".png", ".heic"}
+	videoExtensions = []string{".mp4", ".mov", ".m4v", ".avi", ".mkv"}
+)
+
+// musicLibraryDir holds slideshow background music (downloads and audio extracted from videos)
+const musicLibraryDir = "/data/music"
+
+// musicExtensions are the audio formats usable as slideshow background music
+var musicExtensions = []string{".mp3", ".m4a"}
+
+// presetFolders are directories under the receive dir that contain files, not phone libraries
+var presetFolders = map[string]bool{
+	"music": true,
+	"data":  true,
+}
+
+// hasExtension reports whether name has one of exts (case-insensitive)
+func hasExtension(name string, exts []string) bool {
+	ext := strings.ToLower(filepath.Ext(name))
+	for _, e := range exts {
+		if ext == e {
+			return true
+		}
+	}
+	return false
+}
+
+// thumbnailName returns the thumbnail file name generated for an original media file:
+// "tbn-<name>" for jpg/png, "tbn-<base>.jpg" for HEIC photos and videos.
+func thumbnailName(name string) string {
+	ext := filepath.Ext(name)
+	if strings.ToLower(ext) == ".heic" || hasExtension(name, videoExtensions) {
+		return "tbn-" + strings.TrimSuffix(name, ext) + ".jpg"
+	}
+	return "tbn-" + name
+}
+
+// originalForThumbnail resolves a thumbnail name (or an original file name) to the
+// original media file in phoneDir or its <year>/<month> directories, trying photo
+// extensions before video extensions.
+func originalForThumbnail(phoneDir, thumbName string) (string, bool) {
+	var candidates []string
+	if !strings.HasPrefix(strings.ToLower(thumbName), "tbn-") {
+		candidates = append(candidates, thumbName)
+	}
+	base := strings.TrimSuffix(thumbName, filepath.Ext(thumbName))
+	if strings.HasPrefix(strings.ToLower(base), "tbn-") {
+		base = base[4:]
+	}
+	for _, ext := range append(append([]string{}, photoExtensions...), videoExtensions...) {
+		candidates = append(candidates, base+ext)
+	}
+
+	find := func(dir string) (string, bool) {
+		for _, name := range candidates {
+			path := filepath.Join(dir, name)
+			if info, err := os.Stat(path); err == nil && !info.IsDir() {
+				return path, true
+			}
+		}
+		return "", false
+	}
+	if path, ok := find(phoneDir); ok {
+		return path, true
+	}
+	// Originals the receive directory had no room for, wherever their storage pool is
+	for _, name := range candidates {
+		if path, ok := openCatalog(phoneDir).PooledFile(name); ok {
+			return path, true
+		}
+	}
+	// Originals filed by capture date
+	for _, dir := range phoneMediaDirs(phoneDir)[1:] {
+		if path, ok := find(dir); ok {
+			return path, true
+		}
+	}
+	return "", false
+}
+
+// ChunkedFileInfo tracks ongoing chunked file transfers (videos, and photos too large for one message)
+type ChunkedFileInfo struct {
 	ID             string
+	Media          string // media type from the start message (mp4, heic, dng, ...), used for the extension
 	TotalSize      int64
 	ChunkSize      int
 	TotalChunks    int
-	ReceivedChunks int
-	TempFilePath   string   // temporary file to write chunks
-	TempFile       *os.File // file handle
+	ReceivedChunks int         // distinct chunk indices written
+	Received       chunkBitmap // which chunk indices have been written
+	TempFilePath   string      // temporary file to write chunks
+	TempFile       *os.File    // file handle
+	DataEnd        int64       // end offset of the last chunk, the file's real size
 	RecvDir        string
+	SHA256         string // expected hex SHA-256 of the whole file, empty if the client didn't send one
+	Taken          string // capture time from the start message, optional
+	MTime          string // file modification time on the phone from the start message, optional
+	LastActivity   time.Time
 }
 
-// Global state for thumbnail generation control
-var (
-	thumbnailGenerationMutex sync.Mutex
-)
-
 type Config struct {
 	ServerName string `json:"server_name"`
 	ReceiveDir string `json:"receive_dir"`
 	HttpPort   string `json:"http_port"`
+	TcpPort    string `json:"tcp_port"`
+	UdpPort    string `json:"udp_port"`
+
+	// Push configures the mobile push notification relay (optional)
+	Push *PushConfig `json:"push"`
+
+	// Digest mails a weekly digest per phone with inline thumbnails, to recipients of its own choosing (optional)
+	Digest *DigestConfig `json:"digest"`
+
+	// DedupHardlink hard-links files identical to one already stored for another phone instead of writing a copy
+	DedupHardlink bool `json:"dedup_hardlink"`
+
+	// OrganizeByDate files received media under <phone>/<year>/<month>/ by capture time instead of directly in <phone>/
+	OrganizeByDate bool `json:"organize_by_date"`
+
+	// ThumbnailScaler selects the photo thumbnail scaling kernel: catmullrom (default), bilinear, approx, nearest or box
+	ThumbnailScaler string `json:"thumbnail_scaler"`
+
+	// ThumbnailWidth and ThumbnailQuality set the maximum width (default 320) and JPEG quality
+	// (default 80) of thumbnails; existing thumbnails are regenerated when these change
+	ThumbnailWidth   int `json:"thumbnail_width"`
+	ThumbnailQuality int `json:"thumbnail_quality"`
+
+	// ThumbnailWorkers is how many files a background thumbnail run works on at once
+	// (default: one per CPU, fewer on the small hardware profile)
+	ThumbnailWorkers int `json:"thumbnail_workers"`
+
+	// Geocoder resolves photo positions to place names (optional)
+	Geocoder *GeocoderConfig `json:"geocoder"`
+
+	// Analyzers are image analysis plugins run over new media, e.g. object detection or pet recognition (optional)
+	Analyzers []AnalyzerConfig `json:"analyzers"`
+
+	// SkipCreatedVideoThumbnails leaves videos made on the server (slideshows, trims) without thumbnails
+	SkipCreatedVideoThumbnails bool `json:"skip_created_video_thumbnails"`
+
+	// IdleTimeoutSec closes sync connections silent for this long (default 300); clients ping to stay connected
+	IdleTimeoutSec int `json:"idle_timeout_sec"`
+
+	// StaleTransferMin drops chunked transfers that received nothing for this long (default 10)
+	StaleTransferMin int `json:"stale_transfer_min"`
+
+	// MaxPayloadMB limits a single sync message or uploaded file (default 500, at most 4095); lower it on small devices
+	MaxPayloadMB int `json:"max_payload_mb"`
+
+	// Quotas limits the disk use of each phone and keeps a minimum of free space (optional)
+	Quotas *QuotaConfig `json:"quotas"`
+
+	// Durability is when a received file is acknowledged: fsync (default) once it and its directory
+	// are flushed to disk, batched as soon as it is written, flushing every DurabilityBatchMS (default 1000)
+	Durability        string `json:"durability"`
+	DurabilityBatchMS int    `json:"durability_batch_ms"`
+
+	// StoragePools are more volumes new originals spill over to once the receive directory's is nearly full (optional)
+	StoragePools *StoragePoolsConfig `json:"storage_pools"`
+
+	// Retention removes screenshots, created videos and the like after a while, on a schedule (optional)
+	Retention *RetentionConfig `json:"retention"`
+
+	// Archive sets when and how originals move to archive_dir: by age, compressed (optional)
+	Archive *ArchiveConfig `json:"archive"`
+
+	// Snapshots packs each phone's recent media into dated ZIP archives in a backup folder (optional)
+	Snapshots *SnapshotConfig `json:"snapshots"`
+
+	// Scrub re-hashes stored originals on a schedule and flags damaged ones (optional)
+	Scrub *ScrubConfig `json:"scrub"`
+
+	// HardwareProfile clamps concurrency, buffer sizes and ffmpeg settings: auto (default) picks small
+	// on low-memory machines and ARM boards, standard or small force one
+	HardwareProfile string `json:"hardware_profile"`
+
+	// HTTPLimits sets the web server's timeouts per kind of route and its request size limits (optional)
+	HTTPLimits *HTTPLimitsConfig `json:"http_limits"`
+
+	// WebAuth puts the web UI behind a password with a landing page for visitors, optionally hidden behind a knock
+	WebAuth *WebAuthConfig `json:"web_auth"`
+
+	// PublicGallery serves only the published albums, downsized and optionally watermarked, on a port of its own for sharing over the internet
+	PublicGallery *PublicGalleryConfig `json:"public_gallery"`
+
+	// Watermark overlays text or a logo on photos shared through public albums, exports and share downloads; originals are never changed (optional)
+	Watermark *WatermarkConfig `json:"watermark"`
+
+	// HeaderReadTimeoutSec is how long a message header may take once its first byte arrived (default 30)
+	HeaderReadTimeoutSec int `json:"header_read_timeout_sec"`
+
+	// PayloadReadTimeoutSec is how long reading one message payload may take in total (default unlimited, only idle_timeout_sec applies)
+	PayloadReadTimeoutSec int `json:"payload_read_timeout_sec"`
+
+	// ShutdownGraceSec is how long uploads and tool jobs may finish after SIGINT/SIGTERM (default 30)
+	ShutdownGraceSec int `json:"shutdown_grace_sec"`
+
+	// Telemetry enables opt-in anonymous usage reports (off by default)
+	Telemetry *TelemetryConfig `json:"telemetry"`
+
+	// Offline runs the server without internet access: music download, usage report uploads
+	// and the http geocoder are off, and the web UI says so
+	Offline bool `json:"offline"`
+
+	// QuicPort enables the QUIC sync transport on this UDP port (off when empty)
+	QuicPort string `json:"quic_port"`
+
+	// QuicCertFile/QuicKeyFile are the QUIC TLS certificate; a self-signed one is created when unset
+	QuicCertFile string `json:"quic_cert_file"`
+	QuicKeyFile  string `json:"quic_key_file"`
+
+	// HttpsPort serves the web UI over TLS with HTTP/2 on this port (off when empty); TLSCertFile/TLSKeyFile
+	// default to the self-signed certificate shared with QUIC
+	HttpsPort   string `json:"https_port"`
+	TLSCertFile string `json:"tls_cert_file"`
+	TLSKeyFile  string `json:"tls_key_file"`
+
+	// LegacyDiscovery answers discovery queries with the original "photo_server:NAME,IP:..." string
+	// instead of JSON, for clients that predate the JSON response
+	LegacyDiscovery bool `json:"legacy_discovery"`
+
+	// PayloadKey AES-GCM encrypts the payloads clients send once they negotiate "encryption", for networks where
+	// TLS can't be used; the server's replies stay in the clear (see encryption.go). 16, 24 or 32 bytes, hex or
+	// base64, shared with the app (off when empty)
+	PayloadKey string `json:"payload_key"`
+
+	// WebSocketOrigins are the browser origins (besides the server's own pages) allowed to sync over /ws/sync; "*" allows any
+	WebSocketOrigins []string `json:"websocket_origins"`
+
+	// GrpcPort enables the PhotoSync gRPC service on this TCP port (off when empty)
+	GrpcPort string `json:"grpc_port"`
+
+	// Devices requires phones to be allowlisted here or approved on the devices page before they can sync (any phone when unset)
+	Devices *DevicesConfig `json:"devices"`
+
+	// MediaLibrary keeps a Year/Month tree of links to all originals for Plex or Jellyfin (off when unset)
+	MediaLibrary *MediaLibraryConfig `json:"media_library"`
+
+	// Mirror also writes every stored original, read-only, into a tree for other services to read (off when unset)
+	Mirror *MirrorConfig `json:"mirror"`
+
+	// Backup copies received originals and the catalogs to an S3-compatible bucket (optional)
+	Backup *BackupConfig `json:"backup"`
+
+	// Secondary keeps a verified second copy of the library in another local directory, e.g. a USB drive or NFS mount (optional)
+	Secondary *SecondaryConfig `json:"secondary"`
+
+	// RemoteExport pushes new originals to an offsite host over SSH with rsync or sftp on a schedule (optional)
+	RemoteExport *RemoteExportConfig `json:"remote_export"`
+
+	// ExportMountRoots are where USB drives get mounted, for the export page (default /media, /run/media, /mnt, /Volumes; D:-Z: on Windows)
+	ExportMountRoots []string `json:"export_mount_roots"`
+
+	// ArchiveDir is the cold storage archived originals move to, as <archive_dir>/<phone>/<name>; their thumbnails and metadata stay online (archiving disabled when unset)
+	ArchiveDir string `json:"archive_dir"`
+}
+
+// normalizePort validates a configured port ("9922" or ":9922") and returns it in
+// ":9922" listen form, or def when unset
+func normalizePort(value, def string) (string, error) {
+	if value == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(strings.TrimPrefix(value, ":"))
+	if err != nil || n < 1 || n > 65535 {
+		return "", fmt.Errorf("invalid port %q", value)
+	}
+	return ":" + strconv.Itoa(n), nil
+}
+
+// portNumber returns the numeric port of a normalized ":port" value
+func portNumber(port string) int {
+	n, _ := strconv.Atoi(strings.TrimPrefix(port, ":"))
+	return n
+}
+
+// validatePorts normalizes the configured TCP, UDP and HTTP ports, filling in defaults
+func validatePorts(config *Config) error {
+	var err error
+	if config.TcpPort, err = normalizePort(config.TcpPort, defaultTCPPort); err != nil {
+		return fmt.Errorf("tcp_port: %w", err)
+	}
+	if config.UdpPort, err = normalizePort(config.UdpPort, defaultUDPPort); err != nil {
+		return fmt.Errorf("udp_port: %w", err)
+	}
+	if config.HttpPort, err = normalizePort(config.HttpPort, defaultHTTPPort); err != nil {
+		return fmt.Errorf("http_port: %w", err)
+	}
+	if config.TcpPort == config.HttpPort {
+		return fmt.Errorf("tcp_port and http_port are both %s", config.TcpPort)
+	}
+	if config.QuicPort, err = normalizePort(config.QuicPort, ""); err != nil {
+		return fmt.Errorf("quic_port: %w", err)
+	}
+	if config.QuicPort != "" && config.QuicPort == config.UdpPort {
+		return fmt.Errorf("quic_port and udp_port are both %s", config.QuicPort)
+	}
+	if config.HttpsPort, err = normalizePort(config.HttpsPort, ""); err != nil {
+		return fmt.Errorf("https_port: %w", err)
+	}
+	if config.HttpsPort != "" && (config.HttpsPort == config.TcpPort || config.HttpsPort == config.HttpPort) {
+		return fmt.Errorf("https_port %s is already used by tcp_port or http_port", config.HttpsPort)
+	}
+	if config.GrpcPort, err = normalizePort(config.GrpcPort, ""); err != nil {
+		return fmt.Errorf("grpc_port: %w", err)
+	}
+	if config.GrpcPort != "" && (config.GrpcPort == config.TcpPort || config.GrpcPort == config.HttpPort || config.GrpcPort == config.HttpsPort) {
+		return fmt.Errorf("grpc_port %s is already used by tcp_port, http_port or https_port", config.GrpcPort)
+	}
+	if pg := config.PublicGallery; pg != nil {
+		if pg.Port, err = normalizePort(pg.Port, ""); err != nil {
+			return fmt.Errorf("public_gallery.port: %w", err)
+		}
+		if pg.Port == "" {
+			return fmt.Errorf("public_gallery.port is required")
+		}
+		if pg.Port == config.TcpPort || pg.Port == config.HttpPort || pg.Port == config.HttpsPort || pg.Port == config.GrpcPort {
+			return fmt.Errorf("public_gallery.port %s is already used by tcp_port, http_port, https_port or grpc_port", pg.Port)
+		}
+	}
+	return nil
 }
 
 func loadConfig(configPath string) (*Config, error) {
@@ -182,6 +507,30 @@ func getMsgTypeName(msgType byte) string {
 		return "CHUNKED_VIDEO_DATA"
 	case msgTypeChunkedVideoComplete:
 		return "CHUNKED_VIDEO_COMPLETE"
+	case msgTypeRegisterDevice:
+		return "REGISTER_DEVICE"
+	case msgTypeHaveList:
+		return "HAVE_LIST"
+	case msgTypeMissingList:
+		return "MISSING_LIST"
+	case msgTypeHello:
+		return "HELLO"
+	case msgTypePing:
+		return "PING"
+	case msgTypeClientLog:
+		return "CLIENT_LOG"
+	case msgTypeSyncProgress:
+		return "SYNC_PROGRESS"
+	case msgTypeBatchUpload:
+		return "BATCH_UPLOAD"
+	case msgTypeSyncSummary:
+		return "SYNC_SUMMARY"
+	case msgTypeSubscribeChanges:
+		return "SUBSCRIBE_CHANGES"
+	case msgTypeMediaChanged:
+		return "MEDIA_CHANGED"
+	case msgTypeDeltaStart:
+		return "DELTA_START"
 	default:
 		return "UNKNOWN"
 	}
@@ -194,35 +543,77 @@ func handleTCPConnection(conn net.Conn, config *Config) {
 		baseRecvDir = config.ReceiveDir
 	}
 
-	// Current receive directory (may be modified by msgTypeSetPhoneName)
+	// Current receive directory (may be modified by msgTypeSetPhoneName or msgTypeRegisterDevice)
 	recvDir := baseRecvDir
 
-	// Track chunked video transfers for this connection
-	chunkedVideos := make(map[string]*ChunkedVideoInfo)
+	// Stable device identity once the client registers; the directory then no longer follows the phone name
+	deviceID := ""
+
+	// Features both sides support, agreed in the HELLO handshake. Clients that never send
+	// HELLO speak the original v1 framing and get the defaults.
+	clientFeatures := map[string]bool{}
+
+	// A shutdown waits for the connection's open transfers, see shutdown.go
+	state := trackSyncConn(conn)
+	defer state.done()
 
-	// Per-connection thumbnail generation cancel function
-	var thumbnailCancel context.CancelFunc
-	var thumbnailMutex sync.Mutex
+	// Stalled peers time out instead of blocking a read forever
+	idle := newIdleConn(conn, idleTimeout(config))
+	conn = idle
+
+	// With a devices section in the config, nothing is written until the phone identifies
+	// as an approved device
+	approved := config.Devices == nil
+	notApproved := errors.New("device not approved, see the server's devices page")
+
+	// Track chunked file transfers for this connection
+	chunkedFiles := make(map[string]*ChunkedFileInfo)
+
+	// The client's local media from its last thumb list request with "have", for restores
+	var clientHas *clientManifest
+
+	// Delete previews awaiting the client's confirmation, by confirm token
+	deletePreviews := make(map[string]deletePreview)
+
+	// Live progress for /api/sync-status and, once negotiated, msgTypeSyncProgress reports
+	session := newSyncSession(conn.RemoteAddr().String(), "tcp")
+
+	// ACKs are sent in the original text format unless the client negotiates "json_ack"
+	acks := &ackSender{conn: conn, session: session}
+
+	// Payloads are decrypted once the client negotiates "encryption"
+	payloads := &payloadReader{conn: conn}
+	sessionDone := make(chan struct{})
+	reportingProgress := false
+
+	// Media changes made on the server are pushed once the client negotiates "changes" or subscribes
+	var changes *changeSubscriber
 
 	defer func() {
 		log.Printf("Closing connection from %s\n", conn.RemoteAddr().String())
 
-		// Cancel any ongoing thumbnail generation for this connection
-		thumbnailMutex.Lock()
-		if thumbnailCancel != nil {
-			log.Printf("Cancelling thumbnail generation for connection from %s", conn.RemoteAddr().String())
-			thumbnailCancel()
+		close(sessionDone)
+		session.close()
+		if recvDir != baseRecvDir {
+			recordSyncHistory(recvDir, deviceID, session)
 		}
-		thumbnailMutex.Unlock()
 
-		// Clean up any incomplete chunked video transfers
-		for id, info := range chunkedVideos {
+		// Clean up any incomplete chunked file transfers; at shutdown they are kept for resuming
+		for id, info := range chunkedFiles {
 			if info.TempFile != nil {
 				info.TempFile.Close()
 			}
+			if draining() {
+				err := persistChunkedTransfer(info)
+				if err == nil {
+					log.Printf("Kept incomplete chunked file %s (%d/%d chunks) for resuming", id, info.ReceivedChunks, info.TotalChunks)
+					continue
+				}
+				log.Printf("Error keeping incomplete chunked file %s: %v", id, err)
+			}
 			if info.TempFilePath != "" {
 				os.Remove(info.TempFilePath)
-				log.Printf("Cleaned up incomplete chunked video temp file for %s", id)
+				log.Printf("Cleaned up incomplete chunked file temp file for %s", id)
 			}
 		}
 
@@ -231,58 +622,103 @@ func handleTCPConnection(conn net.Conn, config *Config) {
 		// Trigger thumbnail generation when connection closes
 		// Only generate if recvDir has been set (i.e., phone name was received)
 		if recvDir != baseRecvDir {
-			log.Printf("Connection closed, triggering thumbnail generation for %s\n", recvDir)
-			go func(dir string) {
-				ctx, cancel := context.WithCancel(context.Background())
-				defer cancel()
-
-				if err := generateThumbnails(ctx, dir); err != nil {
-					if err == context.Canceled {
-						log.Printf("Thumbnail generation cancelled for %s\n", dir)
-					} else {
-						log.Printf("Thumbnail generation error: %v\n", err)
-					}
-				} else {
-					log.Printf("Thumbnail generation completed for %s\n", dir)
-				}
-			}(recvDir)
+			jobsFor(recvDir).startThumbnails("connection closed")
 		}
 	}()
 
 	// Protocol: 1 byte type, 4 bytes length (big-endian uint32), then payload
 	// Payload is JSON. JSON: {"id":"...","data":"<base64>","media":"jpg"}
 	for {
-		// Read header: 1 + 4 bytes
+		// Between messages: a shutdown may close the connection now, unless a transfer is open
+		state.busy.Store(false)
+		state.transfers.Store(int32(len(chunkedFiles)))
+		if draining() && len(chunkedFiles) == 0 {
+			log.Printf("Server shutting down, closing connection from %s\n", conn.RemoteAddr().String())
+			return
+		}
+
+		// Read header: 1 + 4 bytes. Waiting for the next message is only bounded by the
+		// idle timeout; once it starts, the header and then the payload must arrive in time.
 		header := make([]byte, 5)
-		if _, err := io.ReadFull(conn, header); err != nil {
-			if err != io.EOF {
+		idle.limitReads(0)
+		_, err := io.ReadFull(conn, header[:1])
+		if err == nil {
+			idle.limitReads(headerReadTimeout(config))
+			_, err = io.ReadFull(conn, header[1:])
+		}
+		if err != nil {
+			if err != io.EOF && !draining() {
 				log.Printf("Error reading header from TCP connection: %v\n", err)
 			}
 			return
 		}
+		state.busy.Store(true)
+		idle.limitReads(payloadReadTimeout(config))
 
 		msgType := header[0]
 		length := binary.BigEndian.Uint32(header[1:5])
 
+		dropStaleTransfers(chunkedFiles, staleTransferTimeout(config))
+		if recvDir != baseRecvDir {
+			session.setPhone(filepath.Base(recvDir))
+			if changes != nil {
+				changes.setOwnPhone(filepath.Base(recvDir))
+			}
+		}
+
 		// Get readable message type name
 		msgTypeName := getMsgTypeName(msgType)
 
 		// Log request header info
 		log.Printf("Request: type=%s(%d), len=%d", msgTypeName, msgType, length)
 
-		if msgType != msgTypeImageData && msgType != msgTypeVideoData && msgType != msgTypeSyncComplete && msgType != msgTypeSetPhoneName && msgType != msgTypeGetMediaCount && msgType != msgTypeMediaThumbList && msgType != msgTypeChunkedVideoStart && msgType != msgTypeChunkedVideoData && msgType != msgTypeChunkedVideoComplete {
+		if msgType != msgTypeImageData && msgType != msgTypeVideoData && msgType != msgTypeSyncComplete && msgType != msgTypeSetPhoneName && msgType != msgTypeGetMediaCount && msgType != msgTypeMediaThumbList && msgType != msgTypeChunkedVideoStart && msgType != msgTypeChunkedVideoData && msgType != msgTypeChunkedVideoComplete && msgType != msgTypeRegisterDevice && msgType != msgTypeHaveList && msgType != msgTypeHello && msgType != msgTypePing && msgType != msgTypeClientLog && msgType != msgTypeBatchUpload && msgType != msgTypeSubscribeChanges && msgType != msgTypeMediaDelList && msgType != msgTypeDeltaStart {
 			log.Printf("Unknown message type %d, closing connection\n", msgType)
 			return
 		}
 
+		// Keepalive: echo the payload back so the client can also measure round trips
+		if msgType == msgTypePing {
+			if length > maxPingPayload {
+				log.Printf("Ping payload too large (%d bytes), closing connection\n", length)
+				return
+			}
+			ping := make([]byte, length)
+			if _, err := io.ReadFull(conn, ping); err != nil {
+				log.Printf("Error reading ping payload: %v\n", err)
+				return
+			}
+			if err := writeMessage(conn, msgTypePing, ping); err != nil {
+				log.Printf("Error sending ping response: %v\n", err)
+				return
+			}
+			continue
+		}
+
 		if msgType == msgTypeSyncComplete {
-			log.Printf("Received sync complete message type, generating thumbnails under %s\n", recvDir)
-			go func() {
-				ctx := context.Background()
-				if err := generateThumbnails(ctx, recvDir); err != nil {
-					log.Printf("Thumbnail generation error: %v\n", err)
+			jobsFor(recvDir).startThumbnails("sync complete")
+
+			// Let the household's other devices know, and warn if the disk is filling up
+			if recvDir != baseRecvDir {
+				notifyEvent(config, PushEvent{
+					Type:         pushEventSyncComplete,
+					Title:        "Sync complete",
+					Message:      fmt.Sprintf("%s finished syncing to %s", filepath.Base(recvDir), config.ServerName),
+					SourceDevice: deviceID,
+				})
+			}
+			checkLowDiskSpace(config)
+
+			// Report what this connection stored before closing, so the app can show it
+			if clientFeatures["sync_summary"] {
+				summary, err := session.summaryJSON()
+				if err == nil {
+					err = writeMessage(conn, msgTypeSyncSummary, summary)
 				}
-			}()
+				if err != nil {
+					log.Printf("Error sending sync summary: %v\n", err)
+				}
+			}
 			return
 		} // Handle media count request immediately; request payload is ignored if present
 		if msgType == msgTypeGetMediaCount {
@@ -310,11 +746,14 @@ func handleTCPConnection(conn net.Conn, config *Config) {
 			// Defaults
 			pageIndex := 0
 			pageSize := 100
+			batchSize := 1
+			var filter thumbListFilter
+			var filterErr error
 
 			if length > 0 {
 				// Read request payload and parse pagination
-				tmp := make([]byte, length)
-				if _, err := io.ReadFull(conn, tmp); err != nil {
+				tmp, err := payloads.read(msgType, length)
+				if err != nil {
 					log.Printf("Error reading thumb list payload: %v\n", err)
 					return
 				}
@@ -323,8 +762,18 @@ func handleTCPConnection(conn net.Conn, config *Config) {
 				log.Printf("MEDIA_THUMB_LIST payload (JSON): %s", string(tmp))
 
 				var req struct {
-					PageIndex int `json:"pageIndex"`
-					PageSize  int `json:"pageSize"`
+					PageIndex int    `json:"pageIndex"`
+					PageSize  int    `json:"pageSize"`
+					MediaType string `json:"mediaType"` // optional: "photo" or "video"
+					FromDate  string `json:"fromDate"`  // optional capture date range, e.g. "2024-05-01", "2024-05" or RFC 3339
+					ToDate    string `json:"toDate"`    // inclusive for partial dates
+					Since     string `json:"since"`     // optional cursor of an earlier list: only files added since
+					BatchSize int    `json:"batchSize"` // with "thumb_stream": thumbnails per message (default 1)
+
+					// Restores: have is the client's local media, kept for the connection so later
+					// pages can leave it out; notOnClient lists only what it doesn't hold
+					Have        []HaveItem `json:"have"`
+					NotOnClient bool       `json:"notOnClient"`
 				}
 				if err := json.Unmarshal(tmp, &req); err != nil {
 					log.Printf("Invalid thumb list JSON, using defaults: %v\n", err)
@@ -335,11 +784,38 @@ func handleTCPConnection(conn net.Conn, config *Config) {
 					if req.PageSize > 0 {
 						pageSize = req.PageSize
 					}
+					if req.BatchSize > 0 {
+						batchSize = min(req.BatchSize, maxThumbStreamBatch)
+					}
+					filter, filterErr = parseThumbListFilter(req.MediaType, req.FromDate, req.ToDate, req.Since,
+						openCatalog(recvDir).Location())
+					if req.Have != nil {
+						clientHas = newClientManifest(req.Have)
+					}
+					if req.NotOnClient && filterErr == nil {
+						if clientHas == nil {
+							filterErr = fmt.Errorf("notOnClient needs the client's media in have first")
+						} else {
+							filter.NotOnClient = clientHas
+							countFeature("restore_list")
+						}
+					}
 				}
 			}
 
-			payload, err := buildThumbsJSONPayloadPaged(recvDir, pageIndex, pageSize)
-			if err != nil {
+			// Clients that negotiated "thumb_stream" get the page in several messages
+			if filterErr == nil && clientFeatures["thumb_stream"] {
+				if err := streamThumbsPaged(conn, recvDir, filter, pageIndex, pageSize, batchSize); err != nil {
+					log.Printf("Error sending thumbnail list response: %v\n", err)
+				}
+				continue
+			}
+
+			var payload []byte
+			if filterErr != nil {
+				log.Printf("Invalid thumb list filter: %v\n", filterErr)
+				payload, _ = json.Marshal(map[string]interface{}{"photos": []string{}, "error": filterErr.Error()})
+			} else if payload, err = buildThumbsJSONPayloadPaged(recvDir, filter, pageIndex, pageSize); err != nil {
 				log.Printf("Error building thumbnails JSON: %v\n", err)
 				// On error, still send an empty list
 				payload = []byte(`{"photos":[]}`)
@@ -354,16 +830,16 @@ func handleTCPConnection(conn net.Conn, config *Config) {
 			continue
 		}
 
-		// Handle chunked video start
+		// Handle chunked file start
 		if msgType == msgTypeChunkedVideoStart {
 			if length == 0 {
-				log.Printf("Received zero-length chunked video start payload, skipping")
+				log.Printf("Received zero-length chunked file start payload, skipping")
 				continue
 			}
 
-			tmp := make([]byte, length)
-			if _, err := io.ReadFull(conn, tmp); err != nil {
-				log.Printf("Error reading chunked video start payload: %v\n", err)
+			tmp, err := payloads.read(msgType, length)
+			if err != nil {
+				log.Printf("Error reading chunked file start payload: %v\n", err)
 				return
 			}
 
@@ -373,56 +849,118 @@ func handleTCPConnection(conn net.Conn, config *Config) {
 				TotalSize   int64  `json:"totalSize"`
 				ChunkSize   int    `json:"chunkSize"`
 				TotalChunks int    `json:"totalChunks"`
+				SHA256      string `json:"sha256"` // optional, hex SHA-256 of the complete file
+				Taken       string `json:"taken"`  // optional capture time, RFC 3339 or device-local
+				MTime       string `json:"mtime"`  // optional file modification time on the phone, like taken or Unix seconds/milliseconds
 			}
 			if err := json.Unmarshal(tmp, &req); err != nil {
-				log.Printf("Invalid chunked video start JSON: %v\n", err)
+				log.Printf("Invalid chunked file start JSON: %v\n", err)
 				continue
 			}
 
-			log.Printf("Chunked video start: id=%s, totalSize=%d, chunkSize=%d, totalChunks=%d",
+			log.Printf("Chunked file start: id=%s, totalSize=%d, chunkSize=%d, totalChunks=%d",
 				req.ID, req.TotalSize, req.ChunkSize, req.TotalChunks)
 
+			err = validateClientID(req.ID)
+			if err == nil {
+				err = validateChunkedStart(req.TotalSize, req.ChunkSize, req.TotalChunks)
+			}
+			if err != nil {
+				log.Printf("Rejecting chunked file %q: %v\n", req.ID, err)
+				if err := acks.send(errorAck(ackKindStart, req.ID, ackCodeInvalid, err)); err != nil {
+					log.Printf("Error writing chunked file start error ACK: %v\n", err)
+				}
+				continue
+			}
+			if !approved {
+				if err := acks.send(errorAck(ackKindStart, req.ID, ackCodeApproval, notApproved)); err != nil {
+					log.Printf("Error writing chunked file start error ACK: %v\n", err)
+				}
+				continue
+			}
+
+			// A restarted transfer replaces the previous attempt
+			if old, exists := chunkedFiles[req.ID]; exists {
+				old.TempFile.Close()
+				os.Remove(old.TempFilePath)
+				delete(chunkedFiles, req.ID)
+			}
+
+			// Pick up a transfer interrupted by a server restart; COMPLETE then lists what is missing
+			if info, ok := resumeChunkedTransfer(recvDir, req.ID, req.TotalSize, req.ChunkSize, req.TotalChunks, strings.ToLower(req.SHA256)); ok {
+				chunkedFiles[req.ID] = info
+				log.Printf("Resuming chunked file %s with %d/%d chunks", req.ID, info.ReceivedChunks, info.TotalChunks)
+				ack := okAck(ackKindStart, req.ID)
+				ack.Resumed = info.ReceivedChunks
+				if err := acks.send(ack); err != nil {
+					log.Printf("Error writing chunked file start ACK: %v\n", err)
+				}
+				continue
+			}
+
+			if ack, ok := admitUpload(config, recvDir, ackKindStart, req.ID, req.TotalSize); !ok {
+				if err := acks.send(ack); err != nil {
+					log.Printf("Error writing chunked file start error ACK: %v\n", err)
+				}
+				checkLowDiskSpace(config)
+				continue
+			}
+
 			// Create temporary file to write chunks
 			tmpFile, err := os.CreateTemp(recvDir, fmt.Sprintf(".chunked_%s_*.tmp",
 				strings.ReplaceAll(req.ID, string(filepath.Separator), "_")))
 			if err != nil {
-				log.Printf("Error creating temp file for chunked video: %v\n", err)
+				log.Printf("Error creating temp file for chunked file: %v\n", err)
 				continue
 			}
 			tmpPath := tmpFile.Name()
-			log.Printf("Created temp file for chunked video: %s", tmpPath)
+			log.Printf("Created temp file for chunked file: %s", tmpPath)
+
+			// Reserve the whole file up front rather than failing halfway through a 4GB upload
+			if err := reserveChunkedFile(tmpFile, recvDir, req.TotalSize); err != nil {
+				log.Printf("Cannot reserve %d bytes for chunked file %s: %v\n", req.TotalSize, req.ID, err)
+				tmpFile.Close()
+				os.Remove(tmpPath)
+				if err := acks.send(errorAck(ackKindStart, req.ID, writeErrorCode(err), err)); err != nil {
+					log.Printf("Error writing chunked file start error ACK: %v\n", err)
+				}
+				checkLowDiskSpace(config)
+				continue
+			}
 
-			// Initialize chunked video tracking
-			chunkedVideos[req.ID] = &ChunkedVideoInfo{
+			// Initialize chunked file tracking
+			chunkedFiles[req.ID] = &ChunkedFileInfo{
 				ID:             req.ID,
 				TotalSize:      req.TotalSize,
 				ChunkSize:      req.ChunkSize,
 				TotalChunks:    req.TotalChunks,
 				ReceivedChunks: 0,
+				Received:       newChunkBitmap(req.TotalChunks),
 				TempFilePath:   tmpPath,
 				TempFile:       tmpFile,
+				Media:          req.Media,
 				RecvDir:        recvDir,
+				SHA256:         strings.ToLower(req.SHA256),
+				Taken:          req.Taken,
+				MTime:          req.MTime,
+				LastActivity:   clock.Now(),
 			}
 
 			// Send ACK: OK:START
-			ack := []byte("OK:START")
-			ackHeader := make([]byte, 5)
-			ackHeader[0] = msgTypeAck
-			binary.BigEndian.PutUint32(ackHeader[1:5], uint32(len(ack)))
-			if _, err := conn.Write(append(ackHeader, ack...)); err != nil {
-				log.Printf("Error writing chunked video start ACK: %v\n", err)
+			if err := acks.send(okAck(ackKindStart, req.ID)); err != nil {
+				log.Printf("Error writing chunked file start ACK: %v\n", err)
 			}
 			continue
-		} // Handle chunked video data
+		} // Handle chunked file data
 		if msgType == msgTypeChunkedVideoData {
 			if length == 0 {
-				log.Printf("Received zero-length chunked video data payload, skipping")
+				log.Printf("Received zero-length chunked file data payload, skipping")
 				continue
 			}
 
-			tmp := make([]byte, length)
-			if _, err := io.ReadFull(conn, tmp); err != nil {
-				log.Printf("Error reading chunked video data payload: %v\n", err)
+			tmp, err := payloads.read(msgType, length)
+			if err != nil {
+				log.Printf("Error reading chunked file data payload: %v\n", err)
 				return
 			}
 
@@ -430,9 +968,10 @@ func handleTCPConnection(conn net.Conn, config *Config) {
 				ID         string `json:"id"`
 				ChunkIndex int    `json:"chunkIndex"`
 				Data       string `json:"data"`
+				SHA256     string `json:"sha256"` // optional, hex SHA-256 of the decoded chunk
 			}
 			if err := json.Unmarshal(tmp, &req); err != nil {
-				log.Printf("Invalid chunked video data JSON: %v\n", err)
+				log.Printf("Invalid chunked file data JSON: %v\n", err)
 				continue
 			}
 
@@ -440,50 +979,99 @@ func handleTCPConnection(conn net.Conn, config *Config) {
 			chunkBytes, err := base64.StdEncoding.DecodeString(req.Data)
 			if err != nil {
 				log.Printf("Error decoding chunk data for id=%s, chunk=%d: %v\n", req.ID, req.ChunkIndex, err)
+				// Ask the client to retransmit this chunk
+				if err := acks.send(chunkAck(req.ID, req.ChunkIndex, ackCodeDecode, err)); err != nil {
+					log.Printf("Error writing chunked file data error ACK: %v\n", err)
+				}
+				continue
+			}
+
+			// Verify chunk checksum before touching the temp file
+			if !checksumMatches(chunkBytes, req.SHA256) {
+				log.Printf("Checksum mismatch for id=%s, chunk=%d, requesting retransmit\n", req.ID, req.ChunkIndex)
+				if err := acks.send(chunkAck(req.ID, req.ChunkIndex, ackCodeChecksum, nil)); err != nil {
+					log.Printf("Error writing chunked file data error ACK: %v\n", err)
+				}
 				continue
 			}
 
 			log.Printf("Received chunk %d for video %s, size=%d bytes", req.ChunkIndex, req.ID, len(chunkBytes))
 
-			// Write chunk to temporary file
-			if info, exists := chunkedVideos[req.ID]; exists {
-				// Write chunk data to temp file
-				if _, err := info.TempFile.Write(chunkBytes); err != nil {
-					log.Printf("Error writing chunk to temp file: %v\n", err)
-					// Clean up
-					info.TempFile.Close()
-					os.Remove(info.TempFilePath)
-					delete(chunkedVideos, req.ID)
+			// Write chunk to temporary file at its offset
+			if info, exists := chunkedFiles[req.ID]; exists {
+				if code, err := info.writeChunk(req.ChunkIndex, chunkBytes); err != nil {
+					log.Printf("Error writing chunk %d of %s: %v\n", req.ChunkIndex, req.ID, err)
+					if code == ackCodeIO || code == ackCodeDiskFull {
+						// Disk trouble: abandon the transfer
+						info.TempFile.Close()
+						os.Remove(info.TempFilePath)
+						delete(chunkedFiles, req.ID)
+					}
+					if err := acks.send(chunkAck(req.ID, req.ChunkIndex, code, err)); err != nil {
+						log.Printf("Error writing chunked file data error ACK: %v\n", err)
+					}
 					continue
 				}
 
-				info.ReceivedChunks++
+				info.LastActivity = clock.Now()
+				session.addBytes(len(chunkBytes))
+				session.fileProgress(info)
 				log.Printf("Written chunk %d/%d for video %s to temp file", info.ReceivedChunks, info.TotalChunks, req.ID)
 			} else {
 				log.Printf("Warning: Received chunk for unknown video ID: %s\n", req.ID)
 			}
 
 			// Send ACK: OK:CHUNK:index
-			ack := []byte(fmt.Sprintf("OK:CHUNK:%d", req.ChunkIndex))
-			ackHeader := make([]byte, 5)
-			ackHeader[0] = msgTypeAck
-			binary.BigEndian.PutUint32(ackHeader[1:5], uint32(len(ack)))
-			if _, err := conn.Write(append(ackHeader, ack...)); err != nil {
-				log.Printf("Error writing chunked video data ACK: %v\n", err)
+			if err := acks.send(chunkAck(req.ID, req.ChunkIndex, ackCodeOK, nil)); err != nil {
+				log.Printf("Error writing chunked file data ACK: %v\n", err)
 			}
 			continue
 		}
 
-		// Handle chunked video complete
+		// Handle a re-sent file: only the blocks its stored copy lacks are transferred
+		if msgType == msgTypeDeltaStart {
+			tmp, err := payloads.read(msgType, length)
+			if err != nil {
+				log.Printf("Error reading delta start payload: %v\n", err)
+				return
+			}
+			if !acks.json {
+				// The text ACKs can't list the chunks to send
+				if err := acks.send(errorAck(ackKindStart, "", ackCodeInvalid, nil)); err != nil {
+					log.Printf("Error writing delta start error ACK: %v\n", err)
+				}
+				continue
+			}
+			if !approved {
+				if err := acks.send(errorAck(ackKindStart, "", ackCodeApproval, notApproved)); err != nil {
+					log.Printf("Error writing delta start error ACK: %v\n", err)
+				}
+				continue
+			}
+			info, ack := startDeltaTransfer(config, recvDir, tmp)
+			if info != nil {
+				if old, exists := chunkedFiles[info.ID]; exists {
+					old.TempFile.Close()
+					os.Remove(old.TempFilePath)
+				}
+				chunkedFiles[info.ID] = info
+			}
+			if err := acks.send(ack); err != nil {
+				log.Printf("Error writing delta start ACK: %v\n", err)
+			}
+			continue
+		}
+
+		// Handle chunked file complete
 		if msgType == msgTypeChunkedVideoComplete {
 			if length == 0 {
-				log.Printf("Received zero-length chunked video complete payload, skipping")
+				log.Printf("Received zero-length chunked file complete payload, skipping")
 				continue
 			}
 
-			tmp := make([]byte, length)
-			if _, err := io.ReadFull(conn, tmp); err != nil {
-				log.Printf("Error reading chunked video complete payload: %v\n", err)
+			tmp, err := payloads.read(msgType, length)
+			if err != nil {
+				log.Printf("Error reading chunked file complete payload: %v\n", err)
 				return
 			}
 
@@ -492,34 +1080,106 @@ func handleTCPConnection(conn net.Conn, config *Config) {
 				TotalChunks int    `json:"totalChunks"`
 			}
 			if err := json.Unmarshal(tmp, &req); err != nil {
-				log.Printf("Invalid chunked video complete JSON: %v\n", err)
+				log.Printf("Invalid chunked file complete JSON: %v\n", err)
 				continue
 			}
 
-			log.Printf("Chunked video complete: id=%s, totalChunks=%d", req.ID, req.TotalChunks)
+			log.Printf("Chunked file complete: id=%s, totalChunks=%d", req.ID, req.TotalChunks)
 
 			// Finalize the video file
-			if info, exists := chunkedVideos[req.ID]; exists {
-				// Close temp file
+			ack := okAck(ackKindFile, req.ID)
+			if info, exists := chunkedFiles[req.ID]; exists {
+				// Every chunk index must have arrived; otherwise keep the transfer open and
+				// tell the client which chunks to resend before completing again
+				if missing := info.missingChunks(maxMissingInAck); len(missing) > 0 {
+					log.Printf("Chunked file %s incomplete: %d/%d chunks received\n",
+						req.ID, info.ReceivedChunks, info.TotalChunks)
+					ack := errorAck(ackKindFile, req.ID, ackCodeMissing, nil)
+					ack.Missing = missing
+					if err := acks.send(ack); err != nil {
+						log.Printf("Error writing chunked file complete error ACK: %v\n", err)
+					}
+					continue
+				}
+
+				// Flush and close temp file; the rename below must not overtake the data. In
+				// the fsync mode a file that can't be flushed isn't acknowledged.
+				if err := syncReceivedData(info.TempFile); err != nil {
+					log.Printf("Error flushing chunked file %s: %v\n", req.ID, err)
+					info.TempFile.Close()
+					os.Remove(info.TempFilePath)
+					delete(chunkedFiles, req.ID)
+					if err := acks.send(errorAck(ackKindFile, req.ID, writeErrorCode(err), err)); err != nil {
+						log.Printf("Error writing chunked file complete error ACK: %v\n", err)
+					}
+					continue
+				}
 				info.TempFile.Close()
 
-				// Verify received chunks count
-				if info.ReceivedChunks != info.TotalChunks {
-					log.Printf("Warning: Expected %d chunks but received %d for video %s",
-						info.TotalChunks, info.ReceivedChunks, req.ID)
+				// The chunks must add up to the announced size (the temp file itself is preallocated)
+				if info.TotalSize > 0 && info.DataEnd != info.TotalSize {
+					log.Printf("Chunked file %s has %d bytes, expected %d\n", req.ID, info.DataEnd, info.TotalSize)
+					os.Remove(info.TempFilePath)
+					delete(chunkedFiles, req.ID)
+					err := fmt.Errorf("received %d bytes, expected %d", info.DataEnd, info.TotalSize)
+					if err := acks.send(errorAck(ackKindFile, req.ID, ackCodeSize, err)); err != nil {
+						log.Printf("Error writing chunked file complete error ACK: %v\n", err)
+					}
+					continue
+				}
+
+				// Verify whole-file checksum; on mismatch the client has to resend the video
+				sum, err := calculateSHA256(info.TempFilePath)
+				if err != nil {
+					log.Printf("Error hashing chunked file %s: %v\n", req.ID, err)
+				}
+				if info.SHA256 != "" && (err != nil || sum != info.SHA256) {
+					log.Printf("Checksum mismatch for chunked file %s (expected %s, got %s, err=%v)\n",
+						req.ID, info.SHA256, sum, err)
+					os.Remove(info.TempFilePath)
+					delete(chunkedFiles, req.ID)
+					if err := acks.send(errorAck(ackKindFile, req.ID, ackCodeChecksum, nil)); err != nil {
+						log.Printf("Error writing chunked file complete error ACK: %v\n", err)
+					}
+					continue
+				}
+
+				// Determine final filename: IDs with an extension are kept as-is, otherwise the
+				// media type from the start message is appended (videos default to mp4)
+				fname := filepath.Join(info.RecvDir, req.ID)
+				if filepath.Ext(req.ID) == "" {
+					media := info.Media
+					if media == "" {
+						media = "mp4"
+					}
+					fname = mediaFileName(info.RecvDir, req.ID, media)
 				}
 
-				// Determine final filename
-				ext := strings.ToLower(filepath.Ext(req.ID))
-				if ext == "" {
-					ext = ".mp4" // default to mp4
+				// Skip storing a second copy of content this phone already has
+				catalog := openCatalog(info.RecvDir)
+				if sum != "" {
+					if existing, dup := catalog.FindByHash(sum); dup {
+						log.Printf("Chunked file %s is a duplicate of %s, not storing\n", req.ID, existing)
+						os.Remove(info.TempFilePath)
+						delete(chunkedFiles, req.ID)
+						catalog.AddAlias(fname, existing)
+						countFeature("dedup")
+						session.fileDone()
+						if err := acks.send(storedAck(req.ID, existing, sum, ackDedupExisting)); err != nil {
+							log.Printf("Error writing chunked file complete ACK: %v\n", err)
+						}
+						continue
+					}
 				}
 
-				var fname string
-				if strings.ToLower(filepath.Ext(req.ID)) != "" {
-					fname = filepath.Join(info.RecvDir, req.ID)
-				} else {
-					fname = filepath.Join(info.RecvDir, req.ID+ext)
+				fname, info.Taken = datedPath(config, info.RecvDir, fname, readFileHead(info.TempFilePath), info.Taken)
+				fname = spillPath(info.RecvDir, fname, info.DataEnd)
+
+				// Create parent directories if the ID contains path separators (or the file is filed by date)
+				if dir := filepath.Dir(fname); dir != info.RecvDir {
+					if err := os.MkdirAll(dir, 0o755); err != nil {
+						log.Printf("Error creating directory for id=%s: %v\n", req.ID, err)
+					}
 				}
 
 				// Move temp file to final location
@@ -528,35 +1188,60 @@ func handleTCPConnection(conn net.Conn, config *Config) {
 					// Try copy and delete as fallback
 					if copyErr := copyFile(info.TempFilePath, fname); copyErr != nil {
 						log.Printf("Error copying temp file: %v\n", copyErr)
+						os.Remove(fname)
+						os.Remove(info.TempFilePath)
+						delete(chunkedFiles, req.ID)
+						if err := acks.send(errorAck(ackKindFile, req.ID, writeErrorCode(copyErr), copyErr)); err != nil {
+							log.Printf("Error writing chunked file complete error ACK: %v\n", err)
+						}
+						continue
 					} else {
 						os.Remove(info.TempFilePath)
 						// Get file size
 						if fileInfo, statErr := os.Stat(fname); statErr == nil {
-							log.Printf("Saved chunked video: %s (size=%d bytes, chunks=%d)\n",
+							log.Printf("Saved chunked file: %s (size=%d bytes, chunks=%d)\n",
 								fname, fileInfo.Size(), info.TotalChunks)
 						}
 					}
 				} else {
 					// Get file size
 					if fileInfo, err := os.Stat(fname); err == nil {
-						log.Printf("Saved chunked video: %s (size=%d bytes, chunks=%d)\n",
+						log.Printf("Saved chunked file: %s (size=%d bytes, chunks=%d)\n",
 							fname, fileInfo.Size(), info.TotalChunks)
 					}
 				}
+				if err := receivedFileStored(fname); err != nil {
+					log.Printf("Error flushing %s: %v\n", fname, err)
+					os.Remove(fname)
+					delete(chunkedFiles, req.ID)
+					if err := acks.send(errorAck(ackKindFile, req.ID, writeErrorCode(err), err)); err != nil {
+						log.Printf("Error writing chunked file complete error ACK: %v\n", err)
+					}
+					continue
+				}
+
+				preserveFileTimes(info.RecvDir, fname, info.MTime, info.Taken)
+				if sum != "" {
+					catalog.Record(fname, sum)
+					catalog.SetSource(fname, session.source(info.ID, info.Media, info.Taken, info.MTime))
+					recordCaptureTime(info.RecvDir, fname, info.Taken)
+				}
+				countFeature("chunked_upload")
+				session.fileDone()
+				if info.RecvDir != baseRecvDir {
+					applyAutoShareRules(config, baseRecvDir, filepath.Base(info.RecvDir), filepath.Base(fname))
+				}
+				ack = storedAck(req.ID, catalog.catalogName(fname), sum, "")
 
 				// Clean up tracking
-				delete(chunkedVideos, req.ID)
+				delete(chunkedFiles, req.ID)
 			} else {
 				log.Printf("Warning: Received complete signal for unknown video ID: %s\n", req.ID)
 			}
 
 			// Send ACK: OK:video_id
-			ack := []byte("OK:" + req.ID)
-			ackHeader := make([]byte, 5)
-			ackHeader[0] = msgTypeAck
-			binary.BigEndian.PutUint32(ackHeader[1:5], uint32(len(ack)))
-			if _, err := conn.Write(append(ackHeader, ack...)); err != nil {
-				log.Printf("Error writing chunked video complete ACK: %v\n", err)
+			if err := acks.send(ack); err != nil {
+				log.Printf("Error writing chunked file complete ACK: %v\n", err)
 			}
 			continue
 		}
@@ -566,53 +1251,292 @@ func handleTCPConnection(conn net.Conn, config *Config) {
 			continue
 		}
 
-		if length > 500*1024*1024 { // limit 500MB for safety (to handle large videos)
+		if int64(length) > maxPayloadSize(config) {
 			log.Printf("Payload too large (%d bytes), closing connection\n", length)
 			return
 		}
 
-		payload := make([]byte, length)
-		if _, err := io.ReadFull(conn, payload); err != nil {
+		payload, err := payloads.read(msgType, length)
+		if err != nil {
 			log.Printf("Error reading payload: %v\n", err)
 			return
 		}
 
 		if msgType == msgTypeSetPhoneName {
-			// Cancel any running thumbnail generation for this connection when new sync starts
-			thumbnailMutex.Lock()
-			if thumbnailCancel != nil {
-				log.Printf("Cancelling ongoing thumbnail generation (new sync starting)")
-				thumbnailCancel()
-			}
-
-			// Create new context for potential thumbnail generation during this sync
-			_, cancel := context.WithCancel(context.Background())
-			thumbnailCancel = cancel
-			thumbnailMutex.Unlock()
-
 			//client phone name is in this request,
 			phoneName := string(payload)
 			log.Printf("SET_PHONE_NAME payload (full string): %s", phoneName)
+
+			// A registered device keeps its directory; the new name is only a display name
+			if deviceID != "" {
+				if _, err := registerDevice(baseRecvDir, deviceID, phoneName); err != nil {
+					log.Printf("Error updating device name for %s: %v\n", deviceID, err)
+				}
+				continue
+			}
+
+			if err := validatePhoneName(phoneName); err != nil {
+				log.Printf("Rejecting phone name: %v\n", err)
+				if err := acks.send(errorAck(ackKindDevice, phoneName, ackCodeInvalid, err)); err != nil {
+					log.Printf("Error writing phone name ACK: %v\n", err)
+				}
+				continue
+			}
+
+			status, err := deviceAccess(config, baseRecvDir, nameDevicePrefix+phoneName, phoneName, "")
+			if err != nil || status != deviceApproved {
+				log.Printf("Phone %q is not approved (%s %v), not accepting files\n", phoneName, status, err)
+				continue
+			}
+			approved = true
+
 			//create a sub directory under receive dir
 			recvDir = filepath.Join(baseRecvDir, phoneName)
 			if err := os.MkdirAll(recvDir, 0o755); err != nil {
 				log.Printf("Error creating receive dir: %v\n", err)
 				return
 			}
+			// A new sync starting: the phone's thumbnail run would only compete with it
+			jobsFor(recvDir).cancelThumbnails("new sync starting")
+			continue
+		}
+
+		if msgType == msgTypeRegisterDevice {
+			var req struct {
+				DeviceID string `json:"deviceId"`
+				Name     string `json:"name"`
+				TimeZone string `json:"timeZone"`    // optional IANA zone for device-local capture times
+				Token    string `json:"token"`       // optional device token from pairing, or an allowlist token from the config
+				Pairing  string `json:"pairingCode"` // optional PIN or QR token from the home page, exchanged for a device token
+			}
+			if err := json.Unmarshal(payload, &req); err != nil {
+				log.Printf("Invalid register device JSON: %v\n", err)
+				continue
+			}
+
+			// Pairing hands out the device token in the ACK, so it needs JSON ACKs
+			issuedToken := ""
+			if req.Pairing != "" {
+				// Check first: a token paired but never delivered would lock the device out
+				token, err := "", fmt.Errorf("pairing needs the json_ack feature")
+				if acks.json {
+					token, err = pairDevice(config, baseRecvDir, conn.RemoteAddr().String(), req.DeviceID, req.Name, req.Pairing)
+				}
+				if err != nil {
+					if err := acks.send(errorAck(ackKindDevice, req.DeviceID, ackCodeApproval, err)); err != nil {
+						log.Printf("Error writing register device ACK: %v\n", err)
+					}
+					continue
+				}
+				issuedToken, req.Token = token, token
+			}
+
+			status, err := deviceAccess(config, baseRecvDir, req.DeviceID, req.Name, req.Token)
+			if err == nil && status != deviceApproved {
+				err = fmt.Errorf("device is %s", status)
+			}
+			if err != nil {
+				log.Printf("Device %q (%s) not accepted: %v\n", req.DeviceID, req.Name, err)
+				if err := acks.send(errorAck(ackKindDevice, req.DeviceID, ackCodeApproval, err)); err != nil {
+					log.Printf("Error writing register device ACK: %v\n", err)
+				}
+				continue
+			}
+			approved = true
+
+			rec, err := registerDevice(baseRecvDir, req.DeviceID, req.Name)
+			if err != nil {
+				log.Printf("Error registering device %q: %v\n", req.DeviceID, err)
+				return
+			}
+			deviceID = rec.ID
+			session.setDevice(rec.ID)
+			recvDir = filepath.Join(baseRecvDir, rec.Dir)
+			if err := os.MkdirAll(recvDir, 0o755); err != nil {
+				log.Printf("Error creating receive dir: %v\n", err)
+				return
+			}
+			log.Printf("Device %s (%s) registered, storing under %s", rec.ID, rec.Name, recvDir)
+			jobsFor(recvDir).cancelThumbnails("new sync starting")
+			if req.TimeZone != "" {
+				if err := openCatalog(recvDir).SetTimeZone(req.TimeZone); err != nil {
+					log.Printf("Ignoring time zone %q from device %s: %v\n", req.TimeZone, rec.ID, err)
+				}
+			}
+
+			// Send ACK: OK:DEVICE:<dir>
+			ack := okAck(ackKindDevice, rec.ID)
+			ack.Device = rec.Dir
+			ack.Token = issuedToken
+			if err := acks.send(ack); err != nil {
+				log.Printf("Error writing register device ACK: %v\n", err)
+			}
+			continue
+		}
+
+		// Incremental sync: reply with the subset of the client's media we don't have yet
+		if msgType == msgTypeHaveList {
+			countFeature("have_list")
+			resp, missing, err := buildMissingListPayload(recvDir, payload)
+			if err != nil {
+				log.Printf("Invalid have list JSON: %v\n", err)
+				continue
+			}
+			session.expect(missing)
+			if err := writeMessage(conn, msgTypeMissingList, resp); err != nil {
+				log.Printf("Error sending missing list response: %v\n", err)
+			}
+			continue
+		}
+
+		// Diagnostic reports from the client, kept in a rolling log shown in the web UI
+		if msgType == msgTypeClientLog {
+			if recvDir == baseRecvDir {
+				log.Printf("Client log before phone name/device registration, ignoring\n")
+				if err := acks.send(errorAck(ackKindLog, "", ackCodeNoDevice, nil)); err != nil {
+					log.Printf("Error writing client log ACK: %v\n", err)
+				}
+				continue
+			}
+			n, err := appendClientLog(recvDir, deviceID, payload)
+			if err != nil {
+				log.Printf("Error storing client log: %v\n", err)
+				if err := acks.send(errorAck(ackKindLog, "", ackCodeInvalid, err)); err != nil {
+					log.Printf("Error writing client log ACK: %v\n", err)
+				}
+				continue
+			}
+			countFeature("client_log")
+			log.Printf("Stored %d client log entries for %s", n, filepath.Base(recvDir))
+			if err := acks.send(okAck(ackKindLog, "")); err != nil {
+				log.Printf("Error writing client log ACK: %v\n", err)
+			}
+			continue
+		}
+
+		// Delete server-side copies, optionally after previewing them
+		if msgType == msgTypeMediaDelList {
+			var resp MediaDelResponse
+			switch {
+			case recvDir == baseRecvDir:
+				resp.Error = "set the phone name or register the device first"
+			case !approved:
+				resp.Error = notApproved.Error()
+			default:
+				resp = handleMediaDelList(recvDir, deletePreviews, payload)
+			}
+			b, _ := json.Marshal(resp)
+			if err := writeMessage(conn, msgTypeMediaDelAck, b); err != nil {
+				log.Printf("Error sending media delete ACK: %v\n", err)
+				return
+			}
+			continue
+		}
+
+		// Many small files in one archive, stored as if sent one by one
+		if msgType == msgTypeBatchUpload {
+			if err := handleBatchUpload(config, baseRecvDir, recvDir, session, acks, payload); err != nil {
+				log.Printf("Error writing batch upload ACK: %v\n", err)
+				return
+			}
+			continue
+		}
+
+		// Handshake: agree on the protocol version and features for the rest of the connection
+		if msgType == msgTypeHello {
+			resp, features, profile, err := buildHelloResponse(config, payload)
+			if err != nil {
+				log.Printf("Invalid hello JSON: %v\n", err)
+				continue
+			}
+			if profile != nil {
+				session.setProfile(profile)
+				countFeature("client_profile")
+				log.Printf("HELLO: client profile %s", profile)
+			}
+			clientFeatures = features
+			acks.json = clientFeatures["json_ack"]
+			countFeature("hello")
+			if clientFeatures["progress"] && !reportingProgress {
+				reportingProgress = true
+				go session.reportProgress(conn, sessionDone)
+			}
+			log.Printf("HELLO: negotiated features %v", sortedFeatures(clientFeatures))
+			if err := writeMessage(conn, msgTypeHello, resp); err != nil {
+				log.Printf("Error sending hello response: %v\n", err)
+				return
+			}
+			if clientFeatures["encryption"] {
+				// Everything after the HELLO reply is encrypted
+				if payloads.aead, err = newPayloadCipher(config); err != nil {
+					log.Printf("Error setting up payload encryption: %v\n", err)
+					return
+				}
+				countFeature("encryption")
+			}
+			if clientFeatures["changes"] && changes == nil {
+				changes = subscribeChanges(conn, sessionDone)
+				if recvDir != baseRecvDir {
+					changes.setOwnPhone(filepath.Base(recvDir))
+				}
+			}
 			continue
-		} // Parse JSON
+		}
+
+		// Pick the phones whose server-side media changes this connection is told about
+		if msgType == msgTypeSubscribeChanges {
+			var sub ChangeSubscription
+			if len(payload) > 0 {
+				if err := json.Unmarshal(payload, &sub); err != nil {
+					log.Printf("Invalid subscribe changes JSON: %v\n", err)
+					continue
+				}
+			}
+			if !approved {
+				sub.Phones = nil // only its own phone until the device is approved
+			}
+			if changes == nil {
+				changes = subscribeChanges(conn, sessionDone)
+				if recvDir != baseRecvDir {
+					changes.setOwnPhone(filepath.Base(recvDir))
+				}
+			}
+			resp, _ := json.Marshal(ChangeSubscription{Phones: changes.selectPhones(sub)})
+			countFeature("subscribe_changes")
+			if err := writeMessage(conn, msgTypeSubscribeChanges, resp); err != nil {
+				log.Printf("Error sending subscribe changes response: %v\n", err)
+				return
+			}
+			continue
+		}
+
+		// Parse JSON
 		var obj struct {
-			ID    string `json:"id"`
-			Data  string `json:"data"`
-			Media string `json:"media"`
+			ID     string `json:"id"`
+			Data   string `json:"data"`
+			Media  string `json:"media"`
+			SHA256 string `json:"sha256"` // optional, hex SHA-256 of the decoded file
+			Taken  string `json:"taken"`  // optional capture time, RFC 3339 or device-local
+			MTime  string `json:"mtime"`  // optional file modification time on the phone, like taken or Unix seconds/milliseconds
 		}
 		if err := json.Unmarshal(payload, &obj); err != nil {
 			log.Printf("Error unmarshaling JSON payload: %v\n", err)
+			if obj.ID != "" {
+				if err := acks.send(errorAck(ackKindFile, obj.ID, ackCodeDecode, err)); err != nil {
+					log.Printf("Error writing error ACK to client: %v\n", err)
+				}
+			}
 			continue
 		}
 
 		if obj.ID == "" || obj.Data == "" || obj.Media == "" {
 			log.Printf("Invalid payload fields: id/data/media required\n")
+			if obj.ID != "" {
+				err := errors.New("id, data and media are required")
+				if err := acks.send(errorAck(ackKindFile, obj.ID, ackCodeInvalid, err)); err != nil {
+					log.Printf("Error writing error ACK to client: %v\n", err)
+				}
+			}
 			continue
 		}
 
@@ -620,6 +1544,17 @@ func handleTCPConnection(conn net.Conn, config *Config) {
 		fileBytes, err := base64.StdEncoding.DecodeString(obj.Data)
 		if err != nil {
 			log.Printf("Error decoding base64 data for id=%s: %v\n", obj.ID, err)
+			if err := acks.send(errorAck(ackKindFile, obj.ID, ackCodeDecode, err)); err != nil {
+				log.Printf("Error writing error ACK to client: %v\n", err)
+			}
+			continue
+		}
+
+		if !checksumMatches(fileBytes, obj.SHA256) {
+			log.Printf("Checksum mismatch for id=%s, requesting retransmit\n", obj.ID)
+			if err := acks.send(errorAck(ackKindFile, obj.ID, ackCodeChecksum, nil)); err != nil {
+				log.Printf("Error writing error ACK to client: %v\n", err)
+			}
 			continue
 		}
 
@@ -633,51 +1568,130 @@ func handleTCPConnection(conn net.Conn, config *Config) {
 			log.Printf("  First %d bytes: %x", previewBytes, fileBytes[:previewBytes])
 		}
 
-		// Save to <recvDir>/<id>.<ext>
-		ext := strings.ToLower(obj.Media)
-		// sanitize ext to prevent path issues: keep letters/numbers
-		if strings.ContainsAny(ext, "/\\") || ext == "" {
-			ext = "bin"
+		// Save to <recvDir>/<id>.<ext>, deduplicated against what the phone already has
+		ack := errorAck(ackKindFile, obj.ID, ackCodeApproval, notApproved)
+		if approved {
+			ack = storeReceivedFile(config, baseRecvDir, recvDir, session, obj.ID, obj.Media, obj.Taken, obj.MTime, fileBytes)
 		}
 
-		// Check if ID already has the extension to avoid double extensions
-		var fname string
-		idExt := strings.ToLower(filepath.Ext(obj.ID))
-		expectedExt := "." + ext
-		if idExt == expectedExt {
-			// ID already has the correct extension
-			fname = filepath.Join(recvDir, obj.ID)
-		} else {
-			// Need to add extension
-			fname = filepath.Join(recvDir, fmt.Sprintf("%s.%s", obj.ID, ext))
+		// Send an ACK back: OK:<id>, or {"status":"ok",...} for json_ack clients
+		if err := acks.send(ack); err != nil {
+			log.Printf("Error writing ACK to client: %v\n", err)
+		}
+	}
+}
+
+// storeReceivedFile saves a received (and already verified) file as <recvDir>/<id>.<ext>,
+// skipping content this phone already has, and returns the ACK for the client
+func storeReceivedFile(config *Config, baseRecvDir, recvDir string, session *syncSession, id, media, taken, mtime string, fileBytes []byte) Ack {
+	if err := validateClientID(id); err != nil {
+		log.Printf("Rejecting file: %v\n", err)
+		return errorAck(ackKindFile, id, ackCodeInvalid, err)
+	}
+	clientName := mediaFileName(recvDir, id, media)
+	fname, taken := datedPath(config, recvDir, clientName, fileBytes, taken)
+
+	// Create parent directories if the ID contains path separators (or the file is filed by date)
+	if dir := filepath.Dir(fname); dir != recvDir {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			log.Printf("Error creating directory for id=%s: %v\n", id, err)
+			return errorAck(ackKindFile, id, writeErrorCode(err), err)
 		}
+	}
 
-		// Create parent directories if obj.ID contains path separators
-		if dir := filepath.Dir(fname); dir != recvDir {
-			if err := os.MkdirAll(dir, 0o755); err != nil {
-				log.Printf("Error creating directory for id=%s: %v\n", obj.ID, err)
-				continue
+	// Content-hash deduplication: identical content already stored for this phone
+	// (possibly under another name) is acknowledged without writing it again
+	sum := sha256.Sum256(fileBytes)
+	fileHash := hex.EncodeToString(sum[:])
+	catalog := openCatalog(recvDir)
+	if existing, dup := catalog.FindByHash(fileHash); dup {
+		log.Printf("File id=%s is a duplicate of %s, not storing\n", id, existing)
+		catalog.AddAlias(clientName, existing)
+		countFeature("dedup")
+		session.addBytes(len(fileBytes))
+		session.fileDone()
+		return storedAck(id, existing, fileHash, ackDedupExisting)
+	}
+
+	if ack, ok := admitUpload(config, recvDir, ackKindFile, id, int64(len(fileBytes))); !ok {
+		checkLowDiskSpace(config)
+		return ack
+	}
+	fname = spillPath(recvDir, fname, int64(len(fileBytes)))
+
+	// Optionally share the disk blocks of an identical file stored for another phone
+	linked := false
+	if config != nil && config.DedupHardlink {
+		if src, ok := findHashInOtherPhones(baseRecvDir, recvDir, fileHash); ok {
+			if err := os.Link(src, fname); err == nil {
+				if err := receivedFileStored(fname); err != nil {
+					log.Printf("Error flushing %s: %v\n", fname, err)
+					os.Remove(fname)
+					return errorAck(ackKindFile, id, writeErrorCode(err), err)
+				}
+				linked = true
+				countFeature("dedup_hardlink")
+				log.Printf("Hard-linked %s to identical file %s\n", fname, src)
+			} else {
+				log.Printf("Error hard-linking %s to %s, writing a copy: %v\n", fname, src, err)
 			}
 		}
+	}
 
-		if err := os.WriteFile(fname, fileBytes, 0o644); err != nil {
-			log.Printf("Error saving file for id=%s: %v\n", obj.ID, err)
-			continue
+	if !linked {
+		if err := writeReceivedFile(fname, fileBytes); err != nil {
+			log.Printf("Error saving file for id=%s: %v\n", id, err)
+			checkLowDiskSpace(config)
+			return errorAck(ackKindFile, id, writeErrorCode(err), err)
 		}
+		// Not on hard links: the times belong to the other phone's file too
+		preserveFileTimes(recvDir, fname, mtime, taken)
+	}
+	catalog.Record(fname, fileHash)
+	catalog.SetSource(fname, session.source(id, media, taken, mtime))
+	recordCaptureTime(recvDir, fname, taken)
+	countFeature("upload")
+	session.addBytes(len(fileBytes))
+	session.fileDone()
+	if recvDir != baseRecvDir {
+		applyAutoShareRules(config, baseRecvDir, filepath.Base(recvDir), filepath.Base(fname))
+	}
 
-		log.Printf("Saved received file: %s (type=%d size=%d bytes)\n", fname, msgType, len(fileBytes))
+	log.Printf("Saved received file: %s (size=%d bytes)\n", fname, len(fileBytes))
+	dedup := ""
+	if linked {
+		dedup = ackDedupHardlink
+	}
+	return storedAck(id, catalog.catalogName(fname), fileHash, dedup)
+}
 
-		// Send a simple ACK back, payload format: OK:<id>
-		// Simple ACK format: type 3, length, payload
-		ack := []byte("OK:" + obj.ID)
-		// Prepend simple framing for ACK (type msgTypeAck with length)
-		ackHeader := make([]byte, 5)
-		ackHeader[0] = msgTypeAck
-		binary.BigEndian.PutUint32(ackHeader[1:5], uint32(len(ack)))
-		if _, err := conn.Write(append(ackHeader, ack...)); err != nil {
-			log.Printf("Error writing ACK to client: %v\n", err)
-		}
+// mediaFileName returns the storage path <recvDir>/<id>.<ext> for a received file,
+// leaving the ID as-is when it already carries the media extension.
+func mediaFileName(recvDir, id, media string) string {
+	ext := strings.ToLower(media)
+	// sanitize ext to prevent path issues: keep letters/numbers
+	if ext == "" || strings.IndexFunc(ext, func(r rune) bool { return (r < 'a' || r > 'z') && (r < '0' || r > '9') }) >= 0 {
+		ext = "bin"
+	}
+
+	// Check if ID already has the extension to avoid double extensions
+	idExt := strings.ToLower(filepath.Ext(id))
+	expectedExt := "." + ext
+	if idExt == expectedExt {
+		// ID already has the correct extension
+		return filepath.Join(recvDir, id)
 	}
+	// Need to add extension
+	return filepath.Join(recvDir, fmt.Sprintf("%s.%s", id, ext))
+}
+
+// writeMessage sends one framed message: type(1 byte) + length(4 bytes big-endian) + payload
+func writeMessage(conn net.Conn, msgType byte, payload []byte) error {
+	header := make([]byte, 5)
+	header[0] = msgType
+	binary.BigEndian.PutUint32(header[1:5], uint32(len(payload)))
+	_, err := conn.Write(append(header, payload...))
+	return err
 }
 
 // copyFile copies a file from src to dst
@@ -698,24 +1712,84 @@ func copyFile(src, dst string) error {
 	return err
 }
 
+// partialFileSuffix marks a received file still being written. Such files are hidden and
+// skipped by the catalog; leftovers of a crash are removed by the stale transfer cleaner.
+const partialFileSuffix = ".partial"
+
+// writeFileAtomic writes a received file to a hidden .<name>.*.partial next to it, flushes
+// it to disk and renames it into place, then flushes the directory. A crash or power loss
+// mid-write leaves at most a .partial file, never a truncated photo under the real name.
+func writeFileAtomic(path string, data []byte) error {
+	if err := writeFileRenamed(path, data, true); err != nil {
+		return err
+	}
+	if err := syncDir(filepath.Dir(path)); err != nil {
+		log.Printf("Warning: Failed to flush directory of %s: %v\n", path, err)
+	}
+	return nil
+}
+
+// writeFileRenamed writes a file through a .partial file like writeFileAtomic, flushing
+// its data to disk before the rename only when flush is set; the directory is left to
+// the caller
+func writeFileRenamed(path string, data []byte, flush bool) error {
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*"+partialFileSuffix)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if err == nil && flush {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(f.Name(), 0o644)
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return nil
+}
+
 func startTCPServer(config *Config) error {
-	listener, err := net.Listen("tcp", tcpPort)
+	listener, err := net.Listen("tcp", config.TcpPort)
 	if err != nil {
 		return fmt.Errorf("failed to start TCP server: %v", err)
 	}
 	defer listener.Close()
 
-	log.Printf("TCP Server listening on port%s\n", tcpPort)
+	log.Printf("TCP Server listening on port%s\n", config.TcpPort)
+	onShutdown(func(context.Context) { listener.Close() })
+	if netSim != nil {
+		listener = &simListener{Listener: listener, sim: netSim}
+	}
+	return serveTCP(listener, config)
+}
 
+// serveTCP handles sync connections accepted on listener until it is closed
+func serveTCP(listener net.Listener, config *Config) error {
 	for {
 		conn, err := listener.Accept()
 		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
 			log.Printf("Error accepting TCP connection: %v\n", err)
 			continue
 		}
 
 		log.Printf("New TCP connection from %s\n", conn.RemoteAddr().String())
-		go handleTCPConnection(conn, config)
+		go func() {
+			atomic.AddInt64(&activeConnections, 1)
+			defer atomic.AddInt64(&activeConnections, -1)
+			handleTCPConnection(conn, config)
+		}()
 	}
 }
 
@@ -729,7 +1803,7 @@ func startUDPServer(config *Config) error {
 	// Set up UDP broadcast address for listening
 	addr := &net.UDPAddr{
 		IP:   net.IPv4(0, 0, 0, 0), // Listen on all available interfaces
-		Port: 7799,
+		Port: portNumber(config.UdpPort),
 	}
 
 	conn, err := net.ListenUDP("udp", addr)
@@ -737,14 +1811,23 @@ func startUDPServer(config *Config) error {
 		return fmt.Errorf("failed to start UDP server: %v", err)
 	}
 	defer conn.Close()
+	onShutdown(func(context.Context) { conn.Close() })
 
-	log.Printf("UDP Server listening on port%s\n", udpPort)
+	log.Printf("UDP Server listening on port%s\n", config.UdpPort)
 	log.Printf("UDP Server IP: %s, Broadcast: %s\n", netInfo.IP.String(), netInfo.Broadcast.String())
+	return serveUDP(conn, config, netInfo)
+}
 
+// serveUDP answers discovery and status queries on conn until it is closed, advertising
+// netInfo's address
+func serveUDP(conn *net.UDPConn, config *Config, netInfo *NetworkInfo) error {
 	buffer := make([]byte, bufferSize)
 	for {
 		n, remoteAddr, err := conn.ReadFromUDP(buffer)
 		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
 			log.Printf("Error reading from UDP: %v\n", err)
 			continue
 		}
@@ -753,11 +1836,15 @@ func startUDPServer(config *Config) error {
 		log.Printf("Received UDP data from %s: %s\n", remoteAddr.String(), data)
 
 		// Check if this is a server discovery request
-		if strings.TrimSpace(data) == "who is photo server?" {
-			response := fmt.Sprintf("photo_server:%s,IP:%s", config.ServerName, netInfo.IP.String())
+		if strings.TrimSpace(data) == discoveryQuery {
+			response, err := buildDiscoveryResponse(config, netInfo.IP.String())
+			if err != nil {
+				log.Printf("Error encoding discovery response: %v\n", err)
+				continue
+			}
 
 			// Send response to both the requester and broadcast address
-			_, err = conn.WriteToUDP([]byte(response), remoteAddr)
+			_, err = conn.WriteToUDP(response, remoteAddr)
 			if err != nil {
 				log.Printf("Error sending server info response to requester: %v\n", err)
 			}
@@ -767,13 +1854,26 @@ func startUDPServer(config *Config) error {
 				IP:   netInfo.Broadcast,
 				Port: remoteAddr.Port,
 			}
-			_, err = conn.WriteToUDP([]byte(response), broadcastAddr)
+			_, err = conn.WriteToUDP(response, broadcastAddr)
 			if err != nil {
 				log.Printf("Error sending server info response to broadcast: %v\n", err)
 			}
 			continue
 		}
 
+		// Lightweight status query: library statistics as JSON
+		if strings.TrimSpace(data) == "photo server status?" {
+			response, err := json.Marshal(collectLibraryStats(config))
+			if err != nil {
+				log.Printf("Error encoding status response: %v\n", err)
+				continue
+			}
+			if _, err := conn.WriteToUDP(response, remoteAddr); err != nil {
+				log.Printf("Error sending status response: %v\n", err)
+			}
+			continue
+		}
+
 		// Echo back other messages
 		_, err = conn.WriteToUDP(buffer[:n], remoteAddr)
 		if err != nil {
@@ -814,10 +1914,9 @@ func convertHEICToImage(heicPath string) (image.Image, string, error) {
 
 	// Use /usr/local/bin/heif-convert directly
 	heifConvertPath := "/usr/local/bin/heif-convert"
-	cmd := exec.Command(heifConvertPath, heicPath, tmpPath)
 
 	log.Printf("Converting HEIC using heif-convert: %s", heicPath)
-	if output, err := cmd.CombinedOutput(); err != nil {
+	if output, err := runTool(context.Background(), heifConvertTimeout, heifConvertPath, heicPath, tmpPath); err != nil {
 		return nil, "", fmt.Errorf("heif-convert failed: %w, output: %s", err, string(output))
 	}
 
@@ -835,253 +1934,446 @@ func convertHEICToImage(heicPath string) (image.Image, string, error) {
 
 	log.Printf("Successfully converted HEIC to %s using heif-convert", format)
 	return img, format, nil
-} // generateThumbnails scans the phone directory and writes thumbnails into a subdirectory named "thumbnails".
+}
+
+// generateThumbnails scans the phone directory and writes thumbnails into a subdirectory named "thumbnails".
 // For photos (jpg/jpeg/png): thumbnails keep the original extension and are named with prefix "tbn-".
 // For videos (mp4/mov/m4v/avi/mkv): thumbnails are JPEG files named "tbn-<original-basename>.jpg".
+// Files are thumbnailed in the phone's thumbnailRunWorkers worker slots, which thumbnails
+// wanted on demand share; the files that failed are reported together in a
+// *thumbnailRunError once the others are done.
 func generateThumbnails(ctx context.Context, parentDir string) error {
-	// Acquire lock to ensure only one thumbnail generation at a time
-	thumbnailGenerationMutex.Lock()
-	defer thumbnailGenerationMutex.Unlock()
+	// One run per phone at a time; other phones' runs go on side by side, as many as the
+	// hardware profile allows
+	jobs := jobsFor(parentDir)
+	jobs.thumbnailRun.Lock()
+	defer jobs.thumbnailRun.Unlock()
+	if thumbnailRunSlots != nil {
+		select {
+		case thumbnailRunSlots <- struct{}{}:
+		case <-ctx.Done():
+			log.Printf("Thumbnail generation cancelled for %s", parentDir)
+			return ctx.Err()
+		}
+		defer func() { <-thumbnailRunSlots }()
+	}
 
-	log.Printf("Starting thumbnail generation for %s (acquired lock)", parentDir)
+	log.Printf("Starting thumbnail generation for %s", parentDir)
 
 	thumbDir := filepath.Join(parentDir, "thumbnails")
 	if err := os.MkdirAll(thumbDir, 0o755); err != nil {
 		return fmt.Errorf("creating thumbnails dir: %w", err)
 	}
 
-	entries, err := os.ReadDir(parentDir)
-	if err != nil {
-		return fmt.Errorf("read parent dir: %w", err)
+	// Originals may also be filed in <year>/<month> directories; thumbnails stay in one place
+	type thumbnailJob struct{ dir, name string }
+	var files []thumbnailJob
+	for _, dir := range phoneMediaDirs(parentDir) {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			return fmt.Errorf("read parent dir: %w", err)
+		}
+		for _, e := range entries {
+			if !e.IsDir() {
+				files = append(files, thumbnailJob{dir, e.Name()})
+			}
+		}
 	}
 
-	for _, e := range entries {
-		// Check if context is cancelled
+	queue := make(chan thumbnailJob)
+	runErr := &thumbnailRunError{dir: parentDir, files: len(files)}
+	var errsMutex sync.Mutex
+	var wg sync.WaitGroup
+	for i := 0; i < max(1, min(thumbnailRunWorkers, len(files))); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range queue {
+				release, err := jobs.acquireWorker(ctx)
+				if err != nil {
+					continue // cancelled, the queue is closing
+				}
+				err = generateThumbnail(ctx, job.dir, thumbDir, job.name)
+				release()
+				if err != nil {
+					errsMutex.Lock()
+					runErr.errs = append(runErr.errs, err)
+					errsMutex.Unlock()
+				}
+			}
+		}()
+	}
+feed:
+	for _, job := range files {
 		select {
+		case queue <- job:
 		case <-ctx.Done():
-			log.Printf("Thumbnail generation cancelled for %s", parentDir)
-			return ctx.Err()
-		default:
+			break feed
+		}
+	}
+	close(queue)
+	wg.Wait()
+
+	if ctx.Err() != nil {
+		log.Printf("Thumbnail generation cancelled for %s", parentDir)
+		return ctx.Err()
+	}
+	if len(runErr.errs) > 0 {
+		return runErr
+	}
+	return nil
+}
+
+var (
+	thumbnailLocksMutex sync.Mutex
+	thumbnailLocks      = make(map[string]*thumbnailLock) // thumbnail path -> its writer
+)
+
+type thumbnailLock struct {
+	sync.Mutex
+	users int
+}
+
+// lockThumbnail serializes writing one thumbnail path and returns the unlock. Originals
+// can share a thumbnail (a Live Photo's IMG_1234.HEIC and IMG_1234.MOV both make
+// tbn-IMG_1234.jpg), and batch runs and on-demand requests can want it at the same time.
+func lockThumbnail(thumbPath string) func() {
+	thumbnailLocksMutex.Lock()
+	l, ok := thumbnailLocks[thumbPath]
+	if !ok {
+		l = &thumbnailLock{}
+		thumbnailLocks[thumbPath] = l
+	}
+	l.users++
+	thumbnailLocksMutex.Unlock()
+
+	l.Lock()
+	return func() {
+		l.Unlock()
+		thumbnailLocksMutex.Lock()
+		if l.users--; l.users == 0 {
+			delete(thumbnailLocks, thumbPath)
 		}
+		thumbnailLocksMutex.Unlock()
+	}
+}
 
-		if e.IsDir() {
-			continue
+// writeThumbnailFile has write produce a thumbnail in a temporary file next to thumbPath,
+// then renames it into place, so nobody sees a half-written thumbnail. The temporary name
+// is hidden and has no image extension, so listings pass it over.
+func writeThumbnailFile(thumbPath string, write func(tmpPath string) error) error {
+	tmp, err := os.CreateTemp(filepath.Dir(thumbPath), ".tbn-*.tmp")
+	if err != nil {
+		return fmt.Errorf("create thumbnail failed %s: %w", thumbPath, err)
+	}
+	tmpPath := tmp.Name()
+	tmp.Close()
+	if err := write(tmpPath); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := os.Rename(tmpPath, thumbPath); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("create thumbnail failed %s: %w", thumbPath, err)
+	}
+	return nil
+}
+
+// generateThumbnail writes the thumbnail of one media file in parentDir into thumbDir,
+// unless it already exists, and returns why it couldn't; other file types are skipped.
+func generateThumbnail(ctx context.Context, parentDir, thumbDir, name string) error {
+	if strings.HasPrefix(strings.ToLower(name), "tbn-") {
+		return nil
+	}
+	ext := strings.ToLower(filepath.Ext(name))
+	srcPath := filepath.Join(parentDir, name)
+
+	// Handle images
+	if ext == ".jpg" || ext == ".jpeg" || ext == ".png" || ext == ".heic" {
+		// For HEIC files, thumbnail will be saved as .jpg
+		thumbName := name
+		if ext == ".heic" {
+			// Replace .heic extension with .jpg for thumbnail
+			base := strings.TrimSuffix(name, ext)
+			thumbName = base + ".jpg"
 		}
-		name := e.Name()
-		if strings.HasPrefix(strings.ToLower(name), "tbn-") {
-			continue
+		thumbPath := filepath.Join(thumbDir, "tbn-"+thumbName)
+		defer lockThumbnail(thumbPath)()
+		if _, err := os.Stat(thumbPath); err == nil {
+			// already exists
+			return nil
 		}
-		ext := strings.ToLower(filepath.Ext(name))
-		srcPath := filepath.Join(parentDir, name)
-
-		// Handle images
-		if ext == ".jpg" || ext == ".jpeg" || ext == ".png" || ext == ".heic" {
-			// For HEIC files, thumbnail will be saved as .jpg
-			thumbName := name
-			if ext == ".heic" {
-				// Replace .heic extension with .jpg for thumbnail
-				base := strings.TrimSuffix(name, ext)
-				thumbName = base + ".jpg"
-			}
-			thumbPath := filepath.Join(thumbDir, "tbn-"+thumbName)
-			if _, err := os.Stat(thumbPath); err == nil {
-				// already exists
-				continue
-			}
 
-			var img image.Image
-			var format string
-			var err error
-
-			// For .heic files, check if they're actually JPEG
-			if ext == ".heic" {
-				// Check file signature (FF D8 FF = JPEG magic bytes)
-				isActuallyJPEG := false
-				if f, err := os.Open(srcPath); err == nil {
-					header := make([]byte, 3)
-					if n, _ := io.ReadFull(f, header); n == 3 {
-						if header[0] == 0xFF && header[1] == 0xD8 && header[2] == 0xFF {
-							isActuallyJPEG = true
-							log.Printf("File %s has .heic extension but is actually a JPEG", name)
-						}
+		var img image.Image
+		var format string
+		var err error
+
+		// For .heic files, check if they're actually JPEG
+		if ext == ".heic" {
+			// Check file signature (FF D8 FF = JPEG magic bytes)
+			isActuallyJPEG := false
+			if f, err := os.Open(srcPath); err == nil {
+				header := make([]byte, 3)
+				if n, _ := io.ReadFull(f, header); n == 3 {
+					if header[0] == 0xFF && header[1] == 0xD8 && header[2] == 0xFF {
+						isActuallyJPEG = true
+						log.Printf("File %s has .heic extension but is actually a JPEG", name)
 					}
-					f.Close()
 				}
+				f.Close()
+			}
 
-				if isActuallyJPEG {
-					// It's actually a JPEG, decode directly
-					f, err := os.Open(srcPath)
-					if err != nil {
-						log.Printf("open source image failed %s: %v", srcPath, err)
-						continue
-					}
-					img, format, err = image.Decode(f)
-					f.Close()
-					if err != nil {
-						log.Printf("decode JPEG failed %s: %v", srcPath, err)
-						continue
-					}
-				} else {
-					// It's a real HEIC file, convert it
-					img, format, err = convertHEICToImage(srcPath)
-					if err != nil {
-						log.Printf("failed to convert HEIC %s: %v", srcPath, err)
-						continue
-					}
-				}
-			} else {
-				// Standard image decoding for non-HEIC files
+			if isActuallyJPEG {
+				// It's actually a JPEG, decode directly
 				f, err := os.Open(srcPath)
 				if err != nil {
-					log.Printf("open source image failed %s: %v", srcPath, err)
-					continue
+					return fmt.Errorf("open source image failed %s: %w", srcPath, err)
 				}
-
 				img, format, err = image.Decode(f)
-				_ = f.Close()
+				f.Close()
 				if err != nil {
-					// Check file size and first few bytes for debugging
-					info, _ := os.Stat(srcPath)
-					firstBytes := make([]byte, 16)
-					if tmpF, tmpErr := os.Open(srcPath); tmpErr == nil {
-						io.ReadFull(tmpF, firstBytes)
-						tmpF.Close()
-						log.Printf("decode image failed %s (size: %d, format detected: %s, first bytes: %x): %v",
-							srcPath, info.Size(), format, firstBytes, err)
-					} else {
-						log.Printf("decode image failed %s: %v", srcPath, err)
-					}
-					continue
+					return fmt.Errorf("decode JPEG failed %s: %w", srcPath, err)
+				}
+			} else {
+				// It's a real HEIC file, convert it
+				img, format, err = convertHEICToImage(srcPath)
+				if err != nil {
+					return fmt.Errorf("failed to convert HEIC %s: %w", srcPath, err)
 				}
 			}
-
-			// calculate thumbnail size (max width 320px, keep aspect)
-			b := img.Bounds()
-			w := b.Dx()
-			h := b.Dy()
-			maxW := 320
-			newW := w
-			newH := h
-			if w > maxW {
-				ratio := float64(maxW) / float64(w)
-				newW = maxW
-				newH = int(float64(h) * ratio)
-			}
-			if newW <= 0 {
-				newW = 1
+		} else {
+			// Standard image decoding for non-HEIC files
+			f, err := os.Open(srcPath)
+			if err != nil {
+				return fmt.Errorf("open source image failed %s: %w", srcPath, err)
 			}
-			if newH <= 0 {
-				newH = 1
+
+			img, format, err = image.Decode(f)
+			_ = f.Close()
+			if err != nil {
+				// Check file size and first few bytes for debugging
+				info, _ := os.Stat(srcPath)
+				firstBytes := make([]byte, 16)
+				if tmpF, tmpErr := os.Open(srcPath); tmpErr == nil {
+					io.ReadFull(tmpF, firstBytes)
+					tmpF.Close()
+					return fmt.Errorf("decode image failed %s (size: %d, format detected: %s, first bytes: %x): %w",
+						srcPath, info.Size(), format, firstBytes, err)
+				}
+				return fmt.Errorf("decode image failed %s: %w", srcPath, err)
 			}
+		}
 
-			thumbImg := image.NewRGBA(image.Rect(0, 0, newW, newH))
-			draw.CatmullRom.Scale(thumbImg, thumbImg.Bounds(), img, img.Bounds(), draw.Over, nil)
+		// calculate thumbnail size (max width from the thumbnail policy, keep aspect)
+		b := img.Bounds()
+		newW, newH := thumbnailSize(b.Dx(), b.Dy())
 
-			out, err := os.Create(thumbPath)
+		thumbImg := image.NewRGBA(image.Rect(0, 0, newW, newH))
+		thumbnailScaler.Scale(thumbImg, thumbImg.Bounds(), img, img.Bounds(), draw.Over, nil)
+
+		err = writeThumbnailFile(thumbPath, func(tmpPath string) error {
+			out, err := os.Create(tmpPath)
 			if err != nil {
-				log.Printf("create thumbnail failed %s: %v", thumbPath, err)
-				continue
+				return fmt.Errorf("create thumbnail failed %s: %w", thumbPath, err)
 			}
 			// HEIC files are converted to JPEG, so encode as JPEG
 			// PNG files keep PNG format, all others (including HEIC) use JPEG
 			if ext == ".png" {
-				if err := png.Encode(out, thumbImg); err != nil {
-					log.Printf("encode png failed %s: %v", thumbPath, err)
-				}
+				err = png.Encode(out, thumbImg)
 			} else {
 				// jpg/jpeg/heic and others -> jpeg
-				if err := jpeg.Encode(out, thumbImg, &jpeg.Options{Quality: 80}); err != nil {
-					log.Printf("encode jpeg failed %s: %v", thumbPath, err)
-				}
+				err = jpeg.Encode(out, thumbImg, &jpeg.Options{Quality: currentThumbnailPolicy.Quality})
 			}
-			_ = out.Close()
-			log.Printf("thumbnail written: %s", thumbPath)
-			continue
+			if closeErr := out.Close(); err == nil {
+				err = closeErr
+			}
+			if err != nil {
+				return fmt.Errorf("encode thumbnail failed %s: %w", thumbPath, err)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
 		}
+		recordThumbnailPolicy(filepath.Dir(thumbDir), srcPath)
+		log.Printf("thumbnail written: %s", thumbPath)
+		return nil
+	}
 
-		// Handle videos (use ffmpeg if available)
-		if ext == ".mp4" || ext == ".mov" || ext == ".m4v" || ext == ".avi" || ext == ".mkv" {
-			// Check if this video was created by the video creation feature
-			base := strings.TrimSuffix(name, ext)
-			markerPath := filepath.Join(parentDir, "."+base+".created")
-			if _, err := os.Stat(markerPath); err == nil {
-				// This video was created from photos, skip thumbnail generation
-				log.Printf("Skipping thumbnail for created video: %s", name)
-				continue
-			}
+	// Handle videos (use ffmpeg if available)
+	if ext == ".mp4" || ext == ".mov" || ext == ".m4v" || ext == ".avi" || ext == ".mkv" {
+		base := strings.TrimSuffix(name, ext)
+		if skipsThumbnail(filepath.Dir(thumbDir), srcPath) {
+			log.Printf("Skipping thumbnail for created video: %s", name)
+			return nil
+		}
 
-			thumbPath := filepath.Join(thumbDir, "tbn-"+base+".jpg")
-			if _, err := os.Stat(thumbPath); err == nil {
-				// already exists
-				continue
-			}
-			if err := generateVideoThumbnail(srcPath, thumbPath); err != nil {
-				log.Printf("video thumbnail failed %s -> %s: %v", srcPath, thumbPath, err)
-			} else {
-				log.Printf("thumbnail written: %s", thumbPath)
-			}
-			continue
+		thumbPath := filepath.Join(thumbDir, "tbn-"+base+".jpg")
+		defer lockThumbnail(thumbPath)()
+		if _, err := os.Stat(thumbPath); err == nil {
+			// already exists
+			return nil
+		}
+		err := writeThumbnailFile(thumbPath, func(tmpPath string) error {
+			return generateVideoThumbnail(ctx, srcPath, tmpPath)
+		})
+		if err != nil {
+			return fmt.Errorf("video thumbnail failed %s -> %s: %w", srcPath, thumbPath, err)
 		}
-		// Other file types: skip
+		recordThumbnailPolicy(filepath.Dir(thumbDir), srcPath)
+		log.Printf("thumbnail written: %s", thumbPath)
+		return nil
 	}
+	// Other file types: skip
 	return nil
 }
 
-// generateVideoThumbnail uses ffmpeg CLI to extract a frame and scale it to width 320 (preserving aspect).
-func generateVideoThumbnail(srcPath, dstPath string) error {
+// thumbnailSize returns the thumbnail dimensions for a w x h image (max width from the
+// thumbnail policy, 320px by default, keep aspect)
+func thumbnailSize(w, h int) (int, int) {
+	maxW := currentThumbnailPolicy.Width
+	newW := w
+	newH := h
+	if w > maxW {
+		ratio := float64(maxW) / float64(w)
+		newW = maxW
+		newH = int(float64(h) * ratio)
+	}
+	if newW <= 0 {
+		newW = 1
+	}
+	if newH <= 0 {
+		newH = 1
+	}
+	return newW, newH
+}
+
+// generateVideoThumbnail uses ffmpeg CLI to extract a frame and scale it to the thumbnail width (preserving aspect).
+func generateVideoThumbnail(ctx context.Context, srcPath, dstPath string) error {
 	// Ensure ffmpeg is available
-	if _, err := exec.LookPath("ffmpeg"); err != nil {
+	if _, err := tools.LookPath("ffmpeg"); err != nil {
 		return fmt.Errorf("ffmpeg not found in PATH: %w", err)
 	}
 
-	// Use a context with timeout to avoid hanging
-	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
-	defer cancel()
-
 	// ffmpeg -y -ss 00:00:01 -i input -frames:v 1 -vf "scale=320:-1" output.jpg
-	cmd := exec.CommandContext(
-		ctx, "ffmpeg",
+	// runTool enforces the timeout so a broken file can't hang thumbnailing
+	if _, err := runTool(ctx, videoThumbnailTimeout, "ffmpeg",
 		"-y",
 		"-ss", "00:00:01",
 		"-i", srcPath,
 		"-frames:v", "1",
-		"-vf", "scale=320:-1",
+		"-vf", fmt.Sprintf("scale=%d:-1", currentThumbnailPolicy.Width),
+		"-f", "mjpeg", // a JPEG, whatever dstPath's extension
 		dstPath,
-	)
-	// Reduce noise: redirect stdout/stderr to files or discard
-	cmd.Stdout = nil
-	cmd.Stderr = nil
-
-	if err := cmd.Run(); err != nil {
+	); err != nil {
 		return err
 	}
 	return nil
 }
 
 // buildThumbsJSONPayloadPaged is like buildThumbsJSONPayload but returns only a page
-// of thumbnails based on pageIndex (0-based) and pageSize. Stable order by filename.
-func buildThumbsJSONPayloadPaged(dir string, pageIndex, pageSize int) ([]byte, error) {
-	thumbDir := filepath.Join(dir, "thumbnails")
-	entries, err := os.ReadDir(thumbDir)
+// of the thumbnails selected by filter, based on pageIndex (0-based) and pageSize. Stable
+// order by filename. The cursor in the reply, sent back as since, lists what's new.
+func buildThumbsJSONPayloadPaged(dir string, filter thumbListFilter, pageIndex, pageSize int) ([]byte, error) {
+	cursor := thumbListCursor(clock.Now())
+	thumbs, err := listThumbnailsPaged(dir, filter, pageIndex, pageSize)
 	if err != nil {
-		if os.IsNotExist(err) {
-			return []byte(`{"photos":[]}`), nil
-		}
-		return nil, fmt.Errorf("read thumbnails dir: %w", err)
+		return nil, err
 	}
 
-	// Filter to image files only and sort stably by name
-	var names []string
-	for _, e := range entries {
-		if e.IsDir() {
-			continue
+	out := thumbsPayload{Photos: make([]thumbPhotoItem, 0, len(thumbs)), Cursor: cursor}
+	for _, t := range thumbs {
+		out.Photos = append(out.Photos, t.photoItem())
+	}
+	return json.Marshal(out)
+}
+
+// thumbsPayload is a MEDIA_THUMB_DATA message. Streamed lists (the "thumb_stream"
+// feature) send a page as several of them, More set on all but the last.
+type thumbsPayload struct {
+	Photos []thumbPhotoItem `json:"photos"`
+	Cursor string           `json:"cursor"`
+	More   bool             `json:"more,omitempty"`
+}
+
+type thumbPhotoItem struct {
+	ID    string    `json:"id"`
+	Data  string    `json:"data"`
+	Media string    `json:"media"`
+	Exif  *ExifInfo `json:"exif,omitempty"`
+}
+
+func (t thumbnailItem) photoItem() thumbPhotoItem {
+	item := thumbPhotoItem{ID: t.ID, Data: base64.StdEncoding.EncodeToString(t.Data), Media: t.Media}
+	if !t.Exif.empty() {
+		item.Exif = t.Exif
+	}
+	return item
+}
+
+// maxThumbStreamBatch bounds the thumbnails per message of a streamed list
+const maxThumbStreamBatch = 100
+
+// streamThumbsPaged sends one page of thumbnails as MEDIA_THUMB_DATA messages of up to
+// batchSize thumbnails each, reading them as it goes, so large pages never sit in memory
+func streamThumbsPaged(conn net.Conn, dir string, filter thumbListFilter, pageIndex, pageSize, batchSize int) error {
+	cursor := thumbListCursor(clock.Now())
+	batch := thumbsPayload{Photos: make([]thumbPhotoItem, 0, batchSize), Cursor: cursor, More: true}
+	send := func() error {
+		b, err := json.Marshal(batch)
+		if err != nil {
+			return err
+		}
+		batch.Photos = batch.Photos[:0]
+		return writeMessage(conn, msgTypeMediaThumbData, b)
+	}
+	err := eachThumbnailPaged(dir, filter, pageIndex, pageSize, func(t thumbnailItem) error {
+		batch.Photos = append(batch.Photos, t.photoItem())
+		if len(batch.Photos) < batchSize {
+			return nil
 		}
-		ext := strings.ToLower(filepath.Ext(e.Name()))
-		if ext == ".jpg" || ext == ".jpeg" || ext == ".png" || ext == ".heic" {
-			names = append(names, e.Name())
+		return send()
+	})
+	if err != nil {
+		log.Printf("Error streaming thumbnails of %s: %v\n", dir, err)
+	}
+	// The last message, possibly empty, ends the page
+	batch.More = false
+	return send()
+}
+
+// thumbnailItem is one thumbnail of a phone directory: the media ID of its original, the
+// media type ("video" for video originals) and the thumbnail image
+type thumbnailItem struct {
+	ID    string
+	Media string
+	Data  []byte
+	Exif  *ExifInfo
+}
+
+// listThumbnailsPaged returns one page of the thumbnails in dir selected by filter,
+// pageIndex 0-based, in stable order by filename
+func listThumbnailsPaged(dir string, filter thumbListFilter, pageIndex, pageSize int) ([]thumbnailItem, error) {
+	var out []thumbnailItem
+	err := eachThumbnailPaged(dir, filter, pageIndex, pageSize, func(t thumbnailItem) error {
+		out = append(out, t)
+		return nil
+	})
+	return out, err
+}
+
+// eachThumbnailPaged passes the thumbnails of one page to fn as they are read, like
+// listThumbnailsPaged without holding the page in memory. An error from fn stops it.
+func eachThumbnailPaged(dir string, filter thumbListFilter, pageIndex, pageSize int, fn func(thumbnailItem) error) error {
+	thumbDir := filepath.Join(dir, "thumbnails")
+	names, err := thumbnailNames(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
 		}
+		return err
 	}
-	sort.SliceStable(names, func(i, j int) bool { return names[i] < names[j] })
+	names = filterThumbnailNames(dir, names, filter)
 
 	// Sanitize pagination
 	if pageIndex < 0 {
@@ -1092,7 +2384,7 @@ func buildThumbsJSONPayloadPaged(dir string, pageIndex, pageSize int) ([]byte, e
 	}
 	start := pageIndex * pageSize
 	if start >= len(names) {
-		return []byte(`{"photos":[]}`), nil
+		return nil
 	}
 	end := start + pageSize
 	if end > len(names) {
@@ -1100,18 +2392,9 @@ func buildThumbsJSONPayloadPaged(dir string, pageIndex, pageSize int) ([]byte, e
 	}
 	page := names[start:end]
 
-	type photoItem struct {
-		ID    string `json:"id"`
-		Data  string `json:"data"`
-		Media string `json:"media"`
-	}
-	type payload struct {
-		Photos []photoItem `json:"photos"`
-	}
-	out := payload{Photos: make([]photoItem, 0, len(page))}
-
 	for _, name := range page {
 		ext := strings.ToLower(filepath.Ext(name))
+		ensureThumbnail(context.Background(), dir, name)
 		b, err := os.ReadFile(filepath.Join(thumbDir, name))
 		if err != nil {
 			log.Printf("read thumb failed %s: %v", name, err)
@@ -1140,41 +2423,31 @@ func buildThumbsJSONPayloadPaged(dir string, pageIndex, pageSize int) ([]byte, e
 			}
 		}
 
+		item := thumbnailItem{ID: base, Media: media, Data: b}
 		if isVideo {
-			media = "video"
+			item.Media = "video"
+		} else if orig, ok := originalForThumbnail(dir, name); ok {
+			item.Exif = openCatalog(dir).Exif(orig)
 		}
 
-		out.Photos = append(out.Photos, photoItem{
-			ID:    base,
-			Data:  base64.StdEncoding.EncodeToString(b),
-			Media: media,
-		})
+		if err := fn(item); err != nil {
+			return err
+		}
 	}
-	return json.Marshal(out)
+	return nil
 }
 
 // countPhotosInDir returns the number of thumbnail files in the thumbnails directory.
 // This counts jpg, jpeg, png, and heic thumbnails.
 func countPhotosInDir(dir string) (int, error) {
-	thumbDir := filepath.Join(dir, "thumbnails")
-	entries, err := os.ReadDir(thumbDir)
+	names, err := thumbnailNames(dir)
 	if err != nil {
 		if os.IsNotExist(err) {
 			return 0, nil
 		}
 		return 0, err
 	}
-	count := 0
-	for _, e := range entries {
-		if e.IsDir() {
-			continue
-		}
-		ext := strings.ToLower(filepath.Ext(e.Name()))
-		if ext == ".jpg" || ext == ".jpeg" || ext == ".png" || ext == ".heic" {
-			count++
-		}
-	}
-	return count, nil
+	return len(names), nil
 }
 
 // cleanOrphanedThumbnails scans all phone directories and removes thumbnails whose original files don't exist
@@ -1229,24 +2502,11 @@ func cleanOrphanedThumbnails(baseDir string) {
 				continue
 			}
 
-			// Extract base name from thumbnail
-			base := strings.TrimSuffix(thumbName, ext)
-			if strings.HasPrefix(strings.ToLower(base), "tbn-") {
-				base = base[4:]
-			}
-
-			// Check if original file exists with any valid extension
-			imageExts := []string{".jpg", ".jpeg", ".png", ".heic"}
-			videoExts := []string{".mp4", ".mov", ".m4v", ".avi", ".mkv"}
-			allExts := append(imageExts, videoExts...)
-
-			foundOriginal := false
-			for _, origExt := range allExts {
-				origPath := filepath.Join(phoneDir, base+origExt)
-				if _, err := os.Stat(origPath); err == nil {
-					foundOriginal = true
-					break
-				}
+			// Check if original file exists with any valid extension, possibly filed by date,
+			// or is archived
+			_, foundOriginal := originalForThumbnail(phoneDir, thumbName)
+			if !foundOriginal {
+				_, foundOriginal = openCatalog(phoneDir).ArchivedFile(thumbName)
 			}
 
 			// If original doesn't exist, delete the orphaned thumbnail
@@ -1370,6 +2630,32 @@ func calculateMD5(filePath string) (string, error) {
 	return fmt.Sprintf("%x", hash.Sum(nil)), nil
 }
 
+// calculateSHA256 calculates the hex SHA-256 hash of a file
+func calculateSHA256(filePath string) (string, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// checksumMatches reports whether data hashes to the expected hex SHA-256.
+// An empty expectation always matches so clients that don't send checksums keep working.
+func checksumMatches(data []byte, expected string) bool {
+	if expected == "" {
+		return true
+	}
+	sum := sha256.Sum256(data)
+	return strings.EqualFold(hex.EncodeToString(sum[:]), expected)
+}
+
 // startOrphanedThumbnailCleaner starts a periodic cleanup task
 func startOrphanedThumbnailCleaner(config *Config, interval time.Duration) {
 	baseDir := config.ReceiveDir
@@ -1377,17 +2663,19 @@ func startOrphanedThumbnailCleaner(config *Config, interval time.Duration) {
 		baseDir = "received"
 	}
 
-	ticker := time.NewTicker(interval)
+	ticker := clock.NewTicker(interval)
 	defer ticker.Stop()
 
 	log.Printf("Started orphaned thumbnail cleaner (interval: %v)", interval)
 
 	// Run immediately on startup
 	cleanOrphanedThumbnails(baseDir)
+	cleanStaleChunkedTempFiles(baseDir, staleTransferTimeout(config))
 
 	// Then run periodically
-	for range ticker.C {
+	for range ticker.C() {
 		cleanOrphanedThumbnails(baseDir)
+		cleanStaleChunkedTempFiles(baseDir, staleTransferTimeout(config))
 	}
 }
 
@@ -1395,6 +2683,12 @@ func main() {
 	// Parse command-line flags
 	showVersion := flag.Bool("v", false, "show version and exit")
 	configPath := flag.String("f", "config.json", "path to config file")
+	benchScaler := flag.String("bench-scaler", "", "time all thumbnail scalers on the given image and exit")
+	netSimSpec := flag.String("netsim", "", "developer option: simulate a bad network on sync connections, e.g. latency=200ms,jitter=100ms,bandwidth=256KB,disconnect=2m")
+	reorganize := flag.String("reorganize", "", "file the originals of legacy flat phone folders by date (\"date\") or undo it (\"rollback\"), then exit")
+	importDir := flag.String("import", "", "import the photos and videos of an existing folder tree into the phone named by -import-phone, then exit")
+	importPhone := flag.String("import-phone", "", "phone folder that -import brings files into")
+	importMove := flag.Bool("import-move", false, "with -import, move the files instead of copying them")
 	flag.Parse()
 
 	// Show version and exit if requested
@@ -1403,15 +2697,172 @@ func main() {
 		os.Exit(0)
 	}
 
+	// Benchmark thumbnail scalers and exit if requested
+	if *benchScaler != "" {
+		if err := benchmarkScalers(*benchScaler, 10); err != nil {
+			log.Fatalf("Scaler benchmark failed: %v", err)
+		}
+		os.Exit(0)
+	}
+
+	if *netSimSpec != "" {
+		sim, err := parseNetSim(*netSimSpec)
+		if err != nil {
+			log.Fatalf("Invalid -netsim: %v", err)
+		}
+		netSim = sim
+		log.Printf("WARNING: simulating a bad network on TCP sync connections: %s\n", sim)
+	}
+
 	// Load configuration
 	config, err := loadConfig(*configPath)
 	if err != nil {
 		log.Printf("Error loading config from %s: %v\n", *configPath, err)
 		config = &Config{ServerName: "unknown"} // Use default name if config fails
 	}
+	if err := setStoragePools(config); err != nil {
+		log.Printf("Storage pools disabled: %v\n", err)
+		config.StoragePools = nil
+	}
+
+	// Reorganize the library instead of serving it
+	if *reorganize != "" {
+		if err := runReorganizeCommand(config, *reorganize, os.Stdout); err != nil {
+			log.Fatalf("Reorganize failed: %v", err)
+		}
+		os.Exit(0)
+	}
+
+	// Import an existing folder tree instead of serving the library
+	if *importDir != "" {
+		if err := runImportCommand(config, *importDir, *importPhone, *importMove, os.Stdout); err != nil {
+			log.Fatalf("Import failed: %v", err)
+		}
+		os.Exit(0)
+	}
 
 	log.Printf("Server Name: %s\n", config.ServerName)
 
+	if err := validatePorts(config); err != nil {
+		log.Fatalf("Invalid port configuration: %v", err)
+	}
+
+	if _, err := newPayloadCipher(config); err != nil {
+		log.Fatalf("Invalid payload_key: %v", err)
+	}
+
+	if err := setHardwareProfile(config); err != nil {
+		log.Printf("Invalid hardware_profile in config, using the standard profile: %v\n", err)
+	}
+
+	setOfflineMode(config)
+
+	if err := setDurability(config); err != nil {
+		log.Printf("Invalid durability in config, flushing each file before acknowledging it: %v\n", err)
+	}
+
+	if err := setThumbnailPolicy(config); err != nil {
+		log.Printf("Invalid thumbnail settings in config, using the defaults for them: %v\n", err)
+	}
+
+	// Watch external tools (ffmpeg, heif-convert, ...) for stuck processes
+	go startToolWatchdog(time.Minute)
+
+	// Anonymous usage reports, only when enabled in the config
+	go startTelemetry(config)
+
+	// Upgrade catalogs written by older versions before anything reads them
+	catalogBaseDir := config.ReceiveDir
+	if catalogBaseDir == "" {
+		catalogBaseDir = "received"
+	}
+	migrateCatalogs(catalogBaseDir)
+	adoptCreatedVideoMarkers(catalogBaseDir)
+	createdVideoThumbnails = !config.SkipCreatedVideoThumbnails
+
+	// Redo thumbnails made with other thumbnail settings, in the background
+	go regenerateOutdatedThumbnails(catalogBaseDir)
+
+	if err := setOriginalsMirror(config); err != nil {
+		log.Printf("Originals mirror disabled: %v\n", err)
+	} else {
+		go fillOriginalsMirror(catalogBaseDir)
+	}
+
+	if err := setMediaLibraryExport(config, catalogBaseDir); err != nil {
+		log.Printf("Media library layout disabled: %v\n", err)
+	} else if mediaLibrary != nil {
+		go mediaLibrary.sync()
+	}
+
+	if err := setGeocoder(config); err != nil {
+		log.Printf("Reverse geocoding disabled: %v\n", err)
+	} else {
+		go geocodeLibrary(catalogBaseDir)
+	}
+	if err := setAnalyzers(config); err != nil {
+		log.Printf("Analyzers disabled: %v\n", err)
+	} else {
+		go analyzeLibrary(catalogBaseDir)
+	}
+	if err := checkWatermark(config); err != nil {
+		log.Printf("Watermark disabled: %v\n", err)
+		config.Watermark = nil
+	}
+	if err := setBackup(config); err != nil {
+		log.Printf("S3 backup disabled: %v\n", err)
+	}
+	if err := setSecondary(config); err != nil {
+		log.Printf("Secondary copy disabled: %v\n", err)
+	}
+
+	if err := checkRetention(config); err != nil {
+		log.Printf("Retention policies disabled: %v\n", err)
+		config.Retention = nil
+	} else {
+		go startRetention(config)
+	}
+
+	if err := setArchive(config); err != nil {
+		log.Printf("Archive disabled: %v\n", err)
+		config.Archive = nil
+	} else {
+		go startArchive(config)
+	}
+
+	if err := checkSnapshots(config); err != nil {
+		log.Printf("Snapshots disabled: %v\n", err)
+		config.Snapshots = nil
+	} else {
+		go startSnapshots(config)
+	}
+
+	if err := checkRemoteExport(config); err != nil {
+		log.Printf("Remote export disabled: %v\n", err)
+		config.RemoteExport = nil
+	} else {
+		go startRemoteExport(config)
+	}
+
+	rerequestDamaged = config.Scrub != nil && config.Scrub.Rerequest
+	go startScrub(config)
+
+	if err := checkDigest(config); err != nil {
+		log.Printf("Digest emails disabled: %v\n", err)
+		config.Digest = nil
+	} else {
+		go startDigest(config)
+	}
+
+	// On Ctrl-C or a service stop, let transfers in progress finish and write pending catalog changes
+	go func() {
+		stop := make(chan os.Signal, 1)
+		signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+		<-stop
+		shutdown(config)
+		os.Exit(0)
+	}()
+
 	var wg sync.WaitGroup
 	wg.Add(4) // Increased to 4 for the cleanup task
 
@@ -1445,6 +2896,24 @@ func main() {
 		}
 	}()
 
+	// Start QUIC server, when configured; TCP stays available for older clients
+	if config.QuicPort != "" {
+		go func() {
+			if err := startQUICServer(config); err != nil {
+				log.Printf("QUIC Server error: %v\n", err)
+			}
+		}()
+	}
+
+	// Start gRPC server, when configured
+	if config.GrpcPort != "" {
+		go func() {
+			if err := startGRPCServer(config); err != nil {
+				log.Printf("gRPC Server error: %v\n", err)
+			}
+		}()
+	}
+
 	log.Println("Servers starting...")
 	wg.Wait()
 }
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net"
+	"path/filepath"
+	"sync"
+)
+
+// Media change events pushed as msgTypeMediaChanged
+const (
+	mediaChangeAdded   = "added"   // e.g. a slideshow was created or a file restored from the trash
+	mediaChangeDeleted = "deleted" // moved to the trash or archived from the web UI
+)
+
+// changeQueueSize bounds the notifications waiting for a slow connection; more are dropped,
+// the app catches up with its next thumbnail list
+const changeQueueSize = 32
+
+// MediaChange tells subscribed connections that media of a phone was added or deleted on
+// the server side, so the app can update without a full resync. IDs are thumbnail names
+// as in MEDIA_THUMB_LIST, names the originals relative to the phone directory.
+type MediaChange struct {
+	Phone string   `json:"phone"`
+	Event string   `json:"event"`
+	IDs   []string `json:"ids"`
+	Names []string `json:"names"`
+}
+
+// ChangeSubscription selects the phones a connection gets changes of: its own phone when
+// empty, "*" for all
+type ChangeSubscription struct {
+	Phones []string `json:"phones"`
+}
+
+// changeSubscriber is one connection receiving media change notifications
+type changeSubscriber struct {
+	conn  net.Conn
+	queue chan []byte
+
+	mu     sync.Mutex
+	own    string          // the connection's phone, followed as it is set
+	phones map[string]bool // explicit selection; nil for the connection's own phone
+}
+
+var (
+	changeSubscribersMutex sync.Mutex
+	changeSubscribers      = make(map[*changeSubscriber]struct{})
+)
+
+// subscribeChanges starts pushing media changes to conn until done is closed
+func subscribeChanges(conn net.Conn, done <-chan struct{}) *changeSubscriber {
+	s := &changeSubscriber{conn: conn, queue: make(chan []byte, changeQueueSize)}
+	changeSubscribersMutex.Lock()
+	changeSubscribers[s] = struct{}{}
+	changeSubscribersMutex.Unlock()
+
+	go func() {
+		defer func() {
+			changeSubscribersMutex.Lock()
+			delete(changeSubscribers, s)
+			changeSubscribersMutex.Unlock()
+		}()
+		for {
+			select {
+			case <-done:
+				return
+			case b := <-s.queue:
+				if err := writeMessage(conn, msgTypeMediaChanged, b); err != nil {
+					log.Printf("Error sending media change: %v\n", err)
+					return
+				}
+			}
+		}
+	}()
+	return s
+}
+
+// setOwnPhone follows the phone the connection syncs as
+func (s *changeSubscriber) setOwnPhone(phone string) {
+	s.mu.Lock()
+	s.own = phone
+	s.mu.Unlock()
+}
+
+// selectPhones applies a subscription and returns the phones now selected
+func (s *changeSubscriber) selectPhones(sub ChangeSubscription) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(sub.Phones) == 0 {
+		s.phones = nil
+		return []string{}
+	}
+	s.phones = make(map[string]bool, len(sub.Phones))
+	for _, p := range sub.Phones {
+		s.phones[p] = true
+	}
+	return sub.Phones
+}
+
+func (s *changeSubscriber) wants(phone string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.phones == nil {
+		return s.own == phone
+	}
+	return s.phones["*"] || s.phones[phone]
+}
+
+// notifyMediaChange tells the subscribed connections about originals at paths of phoneDir
+// that were added or deleted on the server side
+func notifyMediaChange(phoneDir, event string, paths ...string) {
+	if len(paths) == 0 {
+		return
+	}
+	catalog := openCatalog(phoneDir)
+	change := MediaChange{Phone: filepath.Base(phoneDir), Event: event}
+	for _, path := range paths {
+		change.IDs = append(change.IDs, thumbnailName(filepath.Base(path)))
+		change.Names = append(change.Names, catalog.catalogName(path))
+	}
+
+	changeSubscribersMutex.Lock()
+	defer changeSubscribersMutex.Unlock()
+	var b []byte
+	for s := range changeSubscribers {
+		if !s.wants(change.Phone) {
+			continue
+		}
+		if b == nil {
+			var err error
+			if b, err = json.Marshal(change); err != nil {
+				return
+			}
+		}
+		select {
+		case s.queue <- b:
+		default:
+			log.Printf("Media change notifications to %s are backing up, dropping one", s.conn.RemoteAddr())
+		}
+	}
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// mediaLibrarySyncDelay collects the catalog changes of a sync into one update of the layout
+	mediaLibrarySyncDelay = 10 * time.Second
+
+	// mediaLibraryManifest lists the links the server made, so other files in the directory
+	// are never touched
+	mediaLibraryManifest = ".photo_sync_links.json"
+)
+
+// MediaLibraryConfig maintains a Plex/Jellyfin friendly view of the library: links to the
+// originals of all phones, filed as <year>/<month>/<date>_<time>_<phone>_<name>.<ext> by
+// capture time, updated as media arrives, is deleted or gets a corrected date
+type MediaLibraryConfig struct {
+	Dir  string `json:"dir"`
+	Link string `json:"link"` // "symlink" (default) or "hardlink" (same file system only, for servers in containers that can't follow links)
+
+	// Sidecars writes each file's Sidecar next to its link as <name>.json, so tools indexing
+	// the layout (PhotoPrism, digiKam) keep its provenance
+	Sidecars bool `json:"sidecars"`
+}
+
+type mediaLibraryExport struct {
+	dir      string
+	hardlink bool
+	sidecars bool
+	baseDir  string
+
+	syncMutex  sync.Mutex // one update at a time
+	timerMutex sync.Mutex
+	timer      *time.Timer
+}
+
+// mediaLibrary is the layout export in use, set from the config at startup (nil: off)
+var mediaLibrary *mediaLibraryExport
+
+// setMediaLibraryExport enables the media library layout from the config
+func setMediaLibraryExport(config *Config, baseDir string) error {
+	m := config.MediaLibrary
+	if m == nil || m.Dir == "" {
+		return nil
+	}
+	e := &mediaLibraryExport{dir: m.Dir, baseDir: baseDir, sidecars: m.Sidecars}
+	switch m.Link {
+	case "", "symlink":
+	case "hardlink":
+		e.hardlink = true
+	default:
+		return fmt.Errorf("unknown link type %q, use symlink or hardlink", m.Link)
+	}
+	if err := os.MkdirAll(m.Dir, 0o755); err != nil {
+		return err
+	}
+	mediaLibrary = e
+	return nil
+}
+
+// mediaLibraryChanged schedules an update of the layout after catalogs changed
+func mediaLibraryChanged() {
+	e := mediaLibrary
+	if e == nil {
+		return
+	}
+	e.timerMutex.Lock()
+	defer e.timerMutex.Unlock()
+	if e.timer == nil {
+		e.timer = time.AfterFunc(mediaLibrarySyncDelay, func() {
+			e.timerMutex.Lock()
+			e.timer = nil
+			e.timerMutex.Unlock()
+			e.sync()
+		})
+	}
+}
+
+// mediaLibraryName is the normalized layout path of an original, relative to the layout dir
+func mediaLibraryName(phone string, e *CatalogEntry) string {
+	taken := e.ModTime
+	if e.Taken != nil {
+		taken = *e.Taken
+	}
+	base := filepath.Base(e.Name)
+	ext := strings.ToLower(filepath.Ext(base))
+	name := taken.Format("2006-01-02_150405") + "_" + sanitizeLibraryName(phone) + "_" +
+		sanitizeLibraryName(strings.TrimSuffix(base, filepath.Ext(base))) + ext
+	return filepath.Join(taken.Format("2006"), taken.Format("01"), name)
+}
+
+// sanitizeLibraryName keeps letters, digits, dots, dashes and underscores
+func sanitizeLibraryName(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '.', r == '-', r == '_':
+			return r
+		}
+		return '_'
+	}, s)
+}
+
+// linked reports whether the layout file at path already points at src
+func (e *mediaLibraryExport) linked(path, src string) bool {
+	if e.hardlink {
+		a, err := os.Stat(path)
+		if err != nil {
+			return false
+		}
+		b, err := os.Stat(src)
+		return err == nil && os.SameFile(a, b)
+	}
+	target, err := os.Readlink(path)
+	return err == nil && target == src
+}
+
+// sync brings the layout in line with the catalogs: links for new or re-dated originals,
+// and links of removed ones (and any that moved) deleted
+func (e *mediaLibraryExport) sync() {
+	e.syncMutex.Lock()
+	defer e.syncMutex.Unlock()
+
+	want := make(map[string]string)     // layout path -> absolute original
+	sidecars := make(map[string][]byte) // layout path -> its sidecar
+	for _, phone := range libraryPhones(e.baseDir) {
+		phoneDir := filepath.Join(e.baseDir, phone)
+		absPhoneDir, err := filepath.Abs(phoneDir)
+		if err != nil {
+			continue
+		}
+		for _, entry := range openCatalog(phoneDir).AllEntries() {
+			if entry.Archived {
+				continue // its link goes until it is retrieved
+			}
+			path, err := filepath.Abs(entryPath(absPhoneDir, &entry))
+			if err != nil {
+				continue
+			}
+			rel := mediaLibraryName(phone, &entry)
+			want[rel] = path
+			if e.sidecars {
+				if b, err := json.MarshalIndent(sidecarFor(phone, &entry), "", "  "); err == nil {
+					sidecars[rel] = b
+				}
+			}
+		}
+	}
+
+	var made []string
+	if b, err := os.ReadFile(filepath.Join(e.dir, mediaLibraryManifest)); err == nil {
+		json.Unmarshal(b, &made)
+	}
+	removed, added := 0, 0
+	for _, rel := range made {
+		path := filepath.Join(e.dir, rel)
+		if src, ok := want[rel]; ok && e.linked(path, src) {
+			delete(want, rel)
+			continue
+		}
+		if err := os.Remove(path); err == nil {
+			os.Remove(path + ".json")
+			removed++
+			// Drop the month and year folders once empty
+			os.Remove(filepath.Dir(path))
+			os.Remove(filepath.Dir(filepath.Dir(path)))
+		}
+	}
+
+	kept := make([]string, 0, len(made)+len(want))
+	for _, rel := range made {
+		if _, pending := want[rel]; !pending {
+			if _, err := os.Lstat(filepath.Join(e.dir, rel)); err == nil {
+				kept = append(kept, rel)
+			}
+		}
+	}
+	for rel, src := range want {
+		path := filepath.Join(e.dir, rel)
+		if _, err := os.Lstat(path); err == nil {
+			continue // not ours, leave it alone
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			log.Printf("Error creating media library folder for %s: %v", rel, err)
+			continue
+		}
+		var err error
+		if e.hardlink {
+			err = os.Link(src, path)
+		} else {
+			err = os.Symlink(src, path)
+		}
+		if err != nil {
+			log.Printf("Error linking %s into the media library: %v", src, err)
+			continue
+		}
+		kept = append(kept, rel)
+		added++
+	}
+
+	// Sidecars are rewritten when what they say changed, and removed with their links
+	for _, rel := range kept {
+		path := filepath.Join(e.dir, rel) + ".json"
+		b, ok := sidecars[rel]
+		if !ok {
+			os.Remove(path)
+			continue
+		}
+		if old, err := os.ReadFile(path); err == nil && bytes.Equal(old, b) {
+			continue
+		}
+		if err := os.WriteFile(path, b, 0o644); err != nil {
+			log.Printf("Error writing media library sidecar %s: %v", rel, err)
+		}
+	}
+
+	sort.Strings(kept)
+	if b, err := json.Marshal(kept); err == nil {
+		if err := os.WriteFile(filepath.Join(e.dir, mediaLibraryManifest), b, 0o644); err != nil {
+			log.Printf("Error writing media library manifest: %v", err)
+		}
+	}
+	if added > 0 || removed > 0 {
+		log.Printf("Media library at %s updated: %d link(s) added, %d removed", e.dir, added, removed)
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// mediaListItem is one original in the phone media list
+type mediaListItem struct {
+	Name      string    `json:"name"`
+	Dir       string    `json:"dir,omitempty"` // "2024/06" when filed by date
+	Media     string    `json:"media"`         // photo or video
+	Size      int64     `json:"size"`
+	Taken     time.Time `json:"taken"`
+	Thumbnail string    `json:"thumbnail"`
+}
+
+// registerMediaListRoutes adds the paged media list of a phone, for clients and scripts that
+// walk whole libraries, and the list of what a device lacks, for restore screens:
+//
+//	GET /api/phones/Pixel/items?limit=1000&cursor=<next of the previous page>
+//	POST /api/phones/Pixel/items/not-on-client?limit=1000 {"items": [HaveItem...]}
+func registerMediaListRoutes(router *mux.Router, config *Config) {
+	// serveList writes a page of the originals of a phone; clientHas, if set, leaves out
+	// the ones the client holds
+	serveList := func(w http.ResponseWriter, r *http.Request, clientHas *clientManifest) {
+		phoneName := mux.Vars(r)["phoneName"]
+		phoneDir, err := phoneDirFromRequest(config, r)
+		if err != nil {
+			http.Error(w, "Invalid phone name", http.StatusBadRequest)
+			return
+		}
+		after, err := decodeListCursor(r.URL.Query().Get("cursor"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if _, err := os.Stat(phoneDir); os.IsNotExist(err) {
+			http.NotFound(w, r)
+			return
+		}
+		catalog := openCatalog(phoneDir)
+		held := make(map[string]bool)
+		if clientHas != nil {
+			for _, e := range catalog.AllEntries() {
+				if clientHas.has(&e) {
+					held[filepath.Base(filepath.FromSlash(e.Name))] = true
+				}
+			}
+		}
+		names, more, err := pageDirNames(phoneMediaDirs(phoneDir), after, listPageSize(r), func(e os.DirEntry) bool {
+			name := e.Name()
+			return !e.IsDir() && !held[name] && !strings.HasPrefix(name, ".") && !strings.HasPrefix(strings.ToLower(name), "tbn-") &&
+				(hasExtension(name, photoExtensions) || hasExtension(name, videoExtensions))
+		})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		if clientHas != nil {
+			countFeature("restore_list")
+		} else {
+			countFeature("media_list")
+		}
+		list := newJSONListWriter(w, "items")
+		for _, name := range names {
+			path, ok := originalForThumbnail(phoneDir, name)
+			info, err := os.Stat(path)
+			if !ok || err != nil {
+				continue // deleted while listing
+			}
+			dir, _ := filepath.Rel(phoneDir, filepath.Dir(path))
+			media := "photo"
+			if hasExtension(name, videoExtensions) {
+				media = "video"
+			}
+			list.Item(mediaListItem{
+				Name:      name,
+				Dir:       strings.TrimPrefix(filepath.ToSlash(dir), "."),
+				Media:     media,
+				Size:      info.Size(),
+				Taken:     catalog.CaptureTime(path, info),
+				Thumbnail: thumbnailName(name),
+			})
+		}
+		next := ""
+		if more && len(names) > 0 {
+			next = encodeListCursor(names[len(names)-1])
+		}
+		if err := list.Close(next); err != nil {
+			log.Printf("Media list of %s: client went away: %v", phoneName, err)
+		}
+	}
+
+	handleTransfer(router, "/api/phones/{phoneName}/items", func(w http.ResponseWriter, r *http.Request) {
+		serveList(w, r, nil)
+	}).Methods("GET")
+
+	// The device posts the media it holds; the cursor pages as for the full list, with the
+	// same manifest sent again for every page
+	handleTransfer(router, "/api/phones/{phoneName}/items/not-on-client", func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Items []HaveItem `json:"items"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid manifest: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		serveList(w, r, newClientManifest(req.Items))
+	}).Methods("POST")
+}
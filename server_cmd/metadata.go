@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Approximate date precisions of hand-entered capture dates
+const (
+	precisionYear  = "year"
+	precisionMonth = "month"
+	precisionDay   = "day"
+)
+
+// parseApproxDate parses a hand-entered capture date: "1987", "1987-06", "1987-06-14" or
+// an exact capture time. Partial dates are placed at noon on the first day of the period,
+// in loc, and the precision says how much of the date is known ("" when exact).
+func parseApproxDate(value string, loc *time.Location) (time.Time, string, error) {
+	value = strings.TrimSpace(value)
+	for _, f := range []struct{ layout, precision string }{
+		{"2006", precisionYear},
+		{"2006-01", precisionMonth},
+		{"2006-01-02", precisionDay},
+	} {
+		if t, err := time.ParseInLocation(f.layout, value, loc); err == nil {
+			return t.Add(12 * time.Hour), f.precision, nil
+		}
+	}
+	t, _, err := parseCaptureTime(value, loc)
+	return t, "", err
+}
+
+// writeExifMetadata writes a capture date and/or GPS position into the file's EXIF with
+// exiftool, so other photo software sees the same
+func writeExifMetadata(ctx context.Context, path string, taken *time.Time, place *Place) error {
+	args := []string{"-overwrite_original"}
+	if taken != nil {
+		args = append(args,
+			"-DateTimeOriginal="+taken.Format("2006:01:02 15:04:05"),
+			"-OffsetTimeOriginal="+taken.Format("-07:00"))
+	}
+	if place != nil {
+		latRef, lonRef := "N", "E"
+		if place.Lat < 0 {
+			latRef = "S"
+		}
+		if place.Lon < 0 {
+			lonRef = "W"
+		}
+		args = append(args,
+			fmt.Sprintf("-GPSLatitude=%f", math.Abs(place.Lat)), "-GPSLatitudeRef="+latRef,
+			fmt.Sprintf("-GPSLongitude=%f", math.Abs(place.Lon)), "-GPSLongitudeRef="+lonRef)
+	}
+	args = append(args, path)
+	if output, err := runTool(ctx, exifWriteTimeout, "exiftool", args...); err != nil {
+		return fmt.Errorf("exiftool failed: %v, output: %s", err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// registerMetadataRoutes adds the bulk date/location editor for scans and old photos
+func registerMetadataRoutes(router *mux.Router, config *Config) {
+	// Assign an (approximate) date and/or a place to the selected photos
+	router.HandleFunc("/api/phones/{phoneName}/metadata", func(w http.ResponseWriter, r *http.Request) {
+		phoneDir, err := phoneDirFromRequest(config, r)
+		if err != nil {
+			writeJSON(w, map[string]interface{}{"success": false, "error": "Invalid phone name"})
+			return
+		}
+		var req struct {
+			Photos     []string `json:"photos"` // thumbnail or original names
+			Date       string   `json:"date"`   // "1987", "1987-06", "1987-06-14" or a full time; empty keeps it
+			Place      *Place   `json:"place"`
+			ClearPlace bool     `json:"clearPlace"`
+			WriteExif  bool     `json:"writeExif"` // also write into the files' EXIF (JPEG/HEIC)
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeJSON(w, map[string]interface{}{"success": false, "error": "Invalid request: " + err.Error()})
+			return
+		}
+		if len(req.Photos) == 0 {
+			writeJSON(w, map[string]interface{}{"success": false, "error": "No photos selected"})
+			return
+		}
+		if req.Date == "" && req.Place == nil && !req.ClearPlace {
+			writeJSON(w, map[string]interface{}{"success": false, "error": "Nothing to change"})
+			return
+		}
+		if p := req.Place; p != nil && (p.Lat < -90 || p.Lat > 90 || p.Lon < -180 || p.Lon > 180) {
+			writeJSON(w, map[string]interface{}{"success": false, "error": "Latitude must be within ±90 and longitude within ±180"})
+			return
+		}
+		if req.Place != nil {
+			req.Place.Name = truncateString(strings.TrimSpace(req.Place.Name), 200)
+		}
+
+		catalog := openCatalog(phoneDir)
+
+		var taken *time.Time
+		precision := ""
+		if req.Date != "" {
+			t, p, err := parseApproxDate(req.Date, catalog.Location())
+			if err != nil {
+				writeJSON(w, map[string]interface{}{"success": false, "error": "Invalid date: " + err.Error()})
+				return
+			}
+			taken, precision = &t, p
+		}
+
+		var paths []string
+		for _, photo := range req.Photos {
+			if orig, ok := originalForThumbnail(phoneDir, photo); ok {
+				paths = append(paths, orig)
+			}
+		}
+
+		// EXIF first: rewriting the file changes its hash, so it is recorded again (keeping
+		// the user's fields) before the fields below are set. Otherwise the scrub would
+		// take the edit for damage and the backups would upload it under the old hash.
+		var exifErrors []string
+		if req.WriteExif {
+			for _, path := range paths {
+				if !hasExtension(path, []string{".jpg", ".jpeg", ".heic"}) {
+					continue
+				}
+				if err := writeExifMetadata(context.Background(), path, taken, req.Place); err != nil {
+					log.Printf("Error writing EXIF to %s: %v", path, err)
+					exifErrors = append(exifErrors, filepath.Base(path))
+					continue
+				}
+				sum, err := calculateSHA256(path)
+				if err != nil {
+					log.Printf("Error hashing %s after writing EXIF: %v", path, err)
+					continue
+				}
+				catalog.Record(path, sum)
+			}
+		}
+
+		updated := catalog.SetMetadata(paths, taken, precision, req.Place, req.ClearPlace)
+		countFeature("metadata_edit")
+		log.Printf("Updated metadata of %d file(s) in %s (date=%q place=%v)", updated, phoneDir, req.Date, req.Place)
+
+		resp := map[string]interface{}{"success": true, "updated": updated}
+		if len(exifErrors) > 0 {
+			resp["exifFailed"] = exifErrors
+		}
+		writeJSON(w, resp)
+	}).Methods("POST")
+}
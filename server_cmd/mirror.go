@@ -0,0 +1,181 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// mirrorFileMode makes mirrored originals read-only for everyone; services reading the
+// mirror can't change them, and the canonical copies are separate files anyway
+const mirrorFileMode = 0o444
+
+// MirrorConfig writes every stored original also into a second tree, read-only and owned
+// by another user or group, for services like Plex or Syncthing. The mirror follows the
+// library: deleted files disappear from it, restored ones come back.
+type MirrorConfig struct {
+	Dir   string `json:"dir"`
+	Owner string `json:"owner"` // user name or uid the copies belong to (default: the server's user)
+	Group string `json:"group"` // group name or gid; prefer this over owner, an owner could make its copies writable again
+}
+
+// originalsMirror is the mirror in use, set from the config at startup (nil: off)
+var originalsMirror *mirror
+
+type mirror struct {
+	dir      string
+	uid, gid int // -1 leaves the owner or group as created
+	chownErr sync.Once
+}
+
+// setOriginalsMirror enables the originals mirror from the config
+func setOriginalsMirror(config *Config) error {
+	m := config.Mirror
+	if m == nil || m.Dir == "" {
+		return nil
+	}
+	mir := &mirror{dir: m.Dir, uid: -1, gid: -1}
+	if m.Owner != "" {
+		uid, err := strconv.Atoi(m.Owner)
+		if err != nil {
+			u, lookupErr := user.Lookup(m.Owner)
+			if lookupErr != nil {
+				return fmt.Errorf("mirror owner: %w", lookupErr)
+			}
+			if uid, err = strconv.Atoi(u.Uid); err != nil {
+				return fmt.Errorf("mirror owner %s has no numeric uid", m.Owner)
+			}
+		}
+		mir.uid = uid
+	}
+	if m.Group != "" {
+		gid, err := strconv.Atoi(m.Group)
+		if err != nil {
+			g, lookupErr := user.LookupGroup(m.Group)
+			if lookupErr != nil {
+				return fmt.Errorf("mirror group: %w", lookupErr)
+			}
+			if gid, err = strconv.Atoi(g.Gid); err != nil {
+				return fmt.Errorf("mirror group %s has no numeric gid", m.Group)
+			}
+		}
+		mir.gid = gid
+	}
+	if err := os.MkdirAll(m.Dir, 0o755); err != nil {
+		return err
+	}
+	originalsMirror = mir
+	return nil
+}
+
+// path returns where the original at path of phoneDir is mirrored. Originals in a storage
+// pool are mirrored under their catalog name like the rest, never next to themselves.
+func (m *mirror) path(phoneDir, path string) string {
+	name := phoneFileName(phoneDir, path)
+	if strings.HasPrefix(name, "..") || filepath.IsAbs(name) {
+		name = filepath.Base(path)
+	}
+	return filepath.Join(m.dir, filepath.Base(phoneDir), filepath.FromSlash(name))
+}
+
+// copy writes the mirror copy of the original at path, replacing an older one
+func (m *mirror) copy(phoneDir, path string) error {
+	dest := m.path(phoneDir, path)
+	if err := os.MkdirAll(filepath.Dir(dest), 0o755); err != nil {
+		return err
+	}
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	info, err := src.Stat()
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(dest), ".mirror_*.tmp")
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(tmp, src)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), mirrorFileMode)
+	}
+	if err == nil && (m.uid >= 0 || m.gid >= 0) {
+		if chownErr := os.Chown(tmp.Name(), m.uid, m.gid); chownErr != nil {
+			m.chownErr.Do(func() {
+				log.Printf("Warning: can't give mirrored originals their owner/group (the server needs the rights to): %v", chownErr)
+			})
+		}
+	}
+	if err == nil {
+		os.Chtimes(tmp.Name(), info.ModTime(), info.ModTime())
+		err = os.Rename(tmp.Name(), dest)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
+
+// mirrorOriginal copies a stored original into the mirror, if one is configured
+func mirrorOriginal(phoneDir, path string) {
+	if originalsMirror == nil {
+		return
+	}
+	if err := originalsMirror.copy(phoneDir, path); err != nil {
+		log.Printf("Error mirroring %s: %v", path, err)
+	}
+}
+
+// unmirrorOriginal removes the mirror copy of an original that left the library
+func unmirrorOriginal(phoneDir, path string) {
+	if originalsMirror == nil {
+		return
+	}
+	if err := os.Remove(originalsMirror.path(phoneDir, path)); err != nil && !os.IsNotExist(err) {
+		log.Printf("Error removing mirror copy of %s: %v", path, err)
+	}
+}
+
+// fillOriginalsMirror copies the originals that are missing from the mirror or differ in
+// size, e.g. after the mirror was enabled on an existing library
+func fillOriginalsMirror(baseDir string) {
+	if originalsMirror == nil {
+		return
+	}
+	copied := 0
+	for _, phone := range libraryPhones(baseDir) {
+		phoneDir := filepath.Join(baseDir, phone)
+		for _, e := range openCatalog(phoneDir).AllEntries() {
+			if draining() {
+				return
+			}
+			if e.Archived {
+				continue
+			}
+			path := entryPath(phoneDir, &e)
+			if info, err := os.Stat(originalsMirror.path(phoneDir, path)); err == nil && info.Size() == e.Size {
+				continue
+			}
+			if err := originalsMirror.copy(phoneDir, path); err != nil {
+				log.Printf("Error mirroring %s: %v", path, err)
+				continue
+			}
+			copied++
+		}
+	}
+	if copied > 0 {
+		log.Printf("Copied %d original(s) into the mirror at %s", copied, originalsMirror.dir)
+	}
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// narrationDirName holds a phone's uploaded voiceover tracks for slideshows
+const narrationDirName = ".narration"
+
+// maxNarrationSize bounds a voiceover upload
+const maxNarrationSize = 200 * 1024 * 1024
+
+// narrationExtensions are the accepted voiceover formats (webm/ogg from browser recordings)
+var narrationExtensions = []string{".mp3", ".m4a", ".aac", ".wav", ".ogg", ".opus", ".webm"}
+
+// NarrationOptions attaches a voiceover to a slideshow. While the voice is speaking the
+// background music is ducked by sidechain compression keyed on the narration.
+type NarrationOptions struct {
+	File          string  `json:"file"`          // name in the phone's narration folder
+	Delay         float64 `json:"delay"`         // seconds into the video the narration starts
+	Volume        float64 `json:"volume"`        // narration gain, default 1
+	MusicVolume   float64 `json:"musicVolume"`   // background music gain before ducking, default 0.8
+	DuckThreshold float64 `json:"duckThreshold"` // narration level (0-1) above which the music ducks, default 0.03
+	DuckRatio     float64 `json:"duckRatio"`     // how hard the music is ducked (1-20), default 8
+	DuckRelease   float64 `json:"duckRelease"`   // ms for the music to come back after the voice stops, default 600
+}
+
+// resolve fills in defaults, validates the options and returns the narration file path
+func (n *NarrationOptions) resolve(phoneDir string) (string, error) {
+	if n.File == "" || strings.Contains(n.File, "..") || strings.ContainsAny(n.File, "/\\") {
+		return "", fmt.Errorf("invalid narration file %q", n.File)
+	}
+	path := filepath.Join(phoneDir, narrationDirName, n.File)
+	if _, err := os.Stat(path); err != nil {
+		return "", fmt.Errorf("narration file %s not found", n.File)
+	}
+	if n.Volume == 0 {
+		n.Volume = 1
+	}
+	if n.MusicVolume == 0 {
+		n.MusicVolume = 0.8
+	}
+	if n.DuckThreshold == 0 {
+		n.DuckThreshold = 0.03
+	}
+	if n.DuckRatio == 0 {
+		n.DuckRatio = 8
+	}
+	if n.DuckRelease == 0 {
+		n.DuckRelease = 600
+	}
+	switch {
+	case n.Delay < 0:
+		return "", fmt.Errorf("narration delay must not be negative")
+	case n.Volume < 0 || n.Volume > 4 || n.MusicVolume < 0 || n.MusicVolume > 4:
+		return "", fmt.Errorf("volumes must be between 0 and 4")
+	case n.DuckThreshold < 0.001 || n.DuckThreshold > 1:
+		return "", fmt.Errorf("duckThreshold must be between 0.001 and 1")
+	case n.DuckRatio < 1 || n.DuckRatio > 20:
+		return "", fmt.Errorf("duckRatio must be between 1 and 20")
+	case n.DuckRelease < 10 || n.DuckRelease > 9000:
+		return "", fmt.Errorf("duckRelease must be between 10 and 9000 ms")
+	}
+	return path, nil
+}
+
+// audioFilter returns the filter_complex audio chain producing [aout]. voiceInput is the
+// ffmpeg input index of the narration and musicInput that of the looped background music,
+// or -1 for a slideshow without music.
+func (n *NarrationOptions) audioFilter(voiceInput, musicInput int) string {
+	delayMs := int(n.Delay * 1000)
+	voice := fmt.Sprintf("[%d:a]adelay=%d|%d,volume=%.3f,apad", voiceInput, delayMs, delayMs, n.Volume)
+	if musicInput < 0 {
+		return voice + "[aout]"
+	}
+	return voice + ",asplit=2[voice][key];" +
+		fmt.Sprintf("[%d:a]volume=%.3f[music];", musicInput, n.MusicVolume) +
+		fmt.Sprintf("[music][key]sidechaincompress=threshold=%.4f:ratio=%.2f:attack=20:release=%.0f[ducked];",
+			n.DuckThreshold, n.DuckRatio, n.DuckRelease) +
+		// amix halves each input; bring the sum back to the configured levels
+		"[ducked][voice]amix=inputs=2:duration=longest:dropout_transition=0,volume=2[aout]"
+}
+
+// registerNarrationRoutes adds voiceover upload and listing for slideshows to the router
+func registerNarrationRoutes(router *mux.Router, config *Config) {
+	narrationDirFor := func(r *http.Request) (string, bool) {
+		phoneDir, err := phoneDirFromRequest(config, r)
+		if err != nil {
+			return "", false
+		}
+		return filepath.Join(phoneDir, narrationDirName), true
+	}
+
+	// List the phone's narration tracks
+	handleTransfer(router, "/api/narration/{phoneName}", func(w http.ResponseWriter, r *http.Request) {
+		dir, ok := narrationDirFor(r)
+		if !ok {
+			writeJSON(w, map[string]interface{}{"success": false, "error": "Invalid phone name"})
+			return
+		}
+		files := []string{}
+		if entries, err := os.ReadDir(dir); err == nil {
+			for _, e := range entries {
+				if !e.IsDir() && hasExtension(e.Name(), narrationExtensions) {
+					files = append(files, e.Name())
+				}
+			}
+		}
+		sort.Strings(files)
+		writeJSON(w, map[string]interface{}{"success": true, "files": files})
+	}).Methods("GET")
+
+	// Upload a narration track (multipart field "file")
+	handleTransfer(router, "/api/narration/{phoneName}", func(w http.ResponseWriter, r *http.Request) {
+		dir, ok := narrationDirFor(r)
+		if !ok {
+			writeJSON(w, map[string]interface{}{"success": false, "error": "Invalid phone name"})
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, maxNarrationSize)
+		file, header, err := r.FormFile("file")
+		if err != nil {
+			writeJSON(w, map[string]interface{}{"success": false, "error": "Invalid upload: " + err.Error()})
+			return
+		}
+		defer file.Close()
+
+		name := filepath.Base(header.Filename)
+		if !hasExtension(name, narrationExtensions) || strings.HasPrefix(name, ".") {
+			writeJSON(w, map[string]interface{}{"success": false, "error": "Unsupported audio format"})
+			return
+		}
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			writeJSON(w, map[string]interface{}{"success": false, "error": err.Error()})
+			return
+		}
+
+		// Keep earlier takes: add a suffix if the name is taken
+		ext := filepath.Ext(name)
+		base := strings.TrimSuffix(name, ext)
+		path := filepath.Join(dir, name)
+		for i := 2; ; i++ {
+			if _, err := os.Stat(path); os.IsNotExist(err) {
+				break
+			}
+			path = filepath.Join(dir, fmt.Sprintf("%s-%d%s", base, i, ext))
+		}
+
+		out, err := os.Create(path)
+		if err != nil {
+			writeJSON(w, map[string]interface{}{"success": false, "error": err.Error()})
+			return
+		}
+		if _, err := io.Copy(out, file); err != nil {
+			out.Close()
+			os.Remove(path)
+			writeJSON(w, map[string]interface{}{"success": false, "error": "Upload failed: " + err.Error()})
+			return
+		}
+		if err := out.Close(); err != nil {
+			os.Remove(path)
+			writeJSON(w, map[string]interface{}{"success": false, "error": err.Error()})
+			return
+		}
+
+		log.Printf("Narration uploaded: %s", path)
+		writeJSON(w, map[string]interface{}{"success": true, "filename": filepath.Base(path)})
+	}).Methods("POST")
+}
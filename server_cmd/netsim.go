@@ -0,0 +1,203 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math/rand"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// netSimConfig describes the bad network -netsim imposes on sync connections, for soak
+// testing resume, timeouts and retries. Not for production use.
+type netSimConfig struct {
+	Latency         time.Duration // added to every read and before every write
+	Jitter          time.Duration // random extra latency, up to this much
+	Bandwidth       int64         // bytes per second in each direction, 0 for unlimited
+	DisconnectEvery time.Duration // mean time between random disconnects of a connection, 0 for never
+}
+
+// netSim is set from -netsim; nil leaves connections alone
+var netSim *netSimConfig
+
+// parseNetSim reads a -netsim value such as "latency=200ms,jitter=100ms,bandwidth=256KB,disconnect=2m".
+// Bandwidth takes a B, KB or MB suffix and means per second.
+func parseNetSim(spec string) (*netSimConfig, error) {
+	sim := &netSimConfig{}
+	for _, field := range strings.Split(spec, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(field, "=")
+		if !ok {
+			return nil, fmt.Errorf("%q is not key=value", field)
+		}
+		var err error
+		switch strings.ToLower(key) {
+		case "latency":
+			sim.Latency, err = time.ParseDuration(value)
+		case "jitter":
+			sim.Jitter, err = time.ParseDuration(value)
+		case "bandwidth":
+			sim.Bandwidth, err = parseByteRate(value)
+		case "disconnect":
+			sim.DisconnectEvery, err = time.ParseDuration(value)
+		default:
+			return nil, fmt.Errorf("unknown setting %q (latency, jitter, bandwidth, disconnect)", key)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", key, err)
+		}
+	}
+	if sim.Latency < 0 || sim.Jitter < 0 || sim.Bandwidth < 0 || sim.DisconnectEvery < 0 {
+		return nil, fmt.Errorf("settings must not be negative")
+	}
+	return sim, nil
+}
+
+// parseByteRate parses "512KB" style sizes; a bare number is bytes
+func parseByteRate(value string) (int64, error) {
+	upper := strings.ToUpper(strings.TrimSpace(value))
+	multiplier := int64(1)
+	for _, unit := range []struct {
+		suffix string
+		size   int64
+	}{{"MB", 1 << 20}, {"KB", 1 << 10}, {"B", 1}} {
+		if strings.HasSuffix(upper, unit.suffix) {
+			upper, multiplier = strings.TrimSuffix(upper, unit.suffix), unit.size
+			break
+		}
+	}
+	n, err := strconv.ParseInt(strings.TrimSpace(upper), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size %q", value)
+	}
+	return n * multiplier, nil
+}
+
+func (sim *netSimConfig) String() string {
+	return fmt.Sprintf("latency %v (+%v jitter), bandwidth %d B/s, disconnect every ~%v",
+		sim.Latency, sim.Jitter, sim.Bandwidth, sim.DisconnectEvery)
+}
+
+// simListener hands out connections that behave like a poor Wi-Fi link
+type simListener struct {
+	net.Listener
+	sim *netSimConfig
+}
+
+func (l *simListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return newSimConn(conn, l.sim), nil
+}
+
+// simConn delays and throttles reads and writes and may drop the connection at random
+type simConn struct {
+	net.Conn
+	sim       *netSimConfig
+	reads     throttle
+	writes    throttle
+	writeMu   sync.Mutex // keeps a frame's slices together when the progress reporter writes too
+	dropTimer *time.Timer
+}
+
+func newSimConn(conn net.Conn, sim *netSimConfig) *simConn {
+	c := &simConn{Conn: conn, sim: sim}
+	if sim.DisconnectEvery > 0 {
+		after := time.Duration(rand.ExpFloat64() * float64(sim.DisconnectEvery))
+		c.dropTimer = time.AfterFunc(after, func() {
+			log.Printf("netsim: dropping connection from %s after %v", conn.RemoteAddr(), after.Round(time.Millisecond))
+			c.Conn.Close()
+		})
+	}
+	return c
+}
+
+// delay sleeps for the configured latency plus jitter
+func (c *simConn) delay() {
+	d := c.sim.Latency
+	if c.sim.Jitter > 0 {
+		d += time.Duration(rand.Int63n(int64(c.sim.Jitter)))
+	}
+	if d > 0 {
+		time.Sleep(d)
+	}
+}
+
+// slice is how much is moved at once under a bandwidth limit: a tenth of a second's
+// worth, so throughput stays smooth
+func (c *simConn) slice(n int) int {
+	if c.sim.Bandwidth <= 0 {
+		return n
+	}
+	limit := int(c.sim.Bandwidth / 10)
+	if limit < 1 {
+		limit = 1
+	}
+	if n > limit {
+		return limit
+	}
+	return n
+}
+
+func (c *simConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b[:c.slice(len(b))])
+	if n > 0 {
+		c.delay()
+		c.reads.wait(n, c.sim.Bandwidth)
+	}
+	return n, err
+}
+
+func (c *simConn) Write(b []byte) (int, error) {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	c.delay()
+	written := 0
+	for written < len(b) {
+		end := written + c.slice(len(b)-written)
+		c.writes.wait(end-written, c.sim.Bandwidth)
+		n, err := c.Conn.Write(b[written:end])
+		written += n
+		if err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
+
+func (c *simConn) Close() error {
+	if c.dropTimer != nil {
+		c.dropTimer.Stop()
+	}
+	return c.Conn.Close()
+}
+
+// throttle spaces transfers in one direction to a byte rate
+type throttle struct {
+	mu   sync.Mutex
+	next time.Time // when the link is free again
+}
+
+// wait blocks until n bytes fit within rate bytes per second
+func (t *throttle) wait(n int, rate int64) {
+	if rate <= 0 {
+		return
+	}
+	t.mu.Lock()
+	now := time.Now()
+	if t.next.Before(now) {
+		t.next = now
+	}
+	t.next = t.next.Add(time.Duration(int64(n) * int64(time.Second) / rate))
+	until := t.next
+	t.mu.Unlock()
+	time.Sleep(time.Until(until))
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// pushTokensFileName stores registered mobile push tokens in the base receive dir
+const pushTokensFileName = ".push_tokens.json"
+
+// Push event types relayed to registered clients
+const (
+	pushEventSyncComplete = "sync_complete"  // another device finished a sync
+	pushEventSharedAlbum  = "shared_album"   // new content in a shared album
+	pushEventLowDiskSpace = "low_disk_space" // free space fell below push.low_disk_mb
+	pushEventComment      = "comment"        // someone commented on a photo
+)
+
+// lowDiskNotifyInterval rate-limits low disk space notifications
+const lowDiskNotifyInterval = 6 * time.Hour
+
+// PushConfig configures the push relay. Provider "webhook" POSTs a JSON message per token
+// to URL (for a self-hosted FCM/APNs gateway), "ntfy" publishes to an ntfy/gotify style
+// topic URL using the token as the topic name.
+type PushConfig struct {
+	Provider  string `json:"provider"`
+	URL       string `json:"url"`
+	AuthToken string `json:"auth_token"`
+	LowDiskMB int64  `json:"low_disk_mb"` // notify when free space drops below this, 0 disables
+}
+
+// PushRegistration is one mobile client's push token
+type PushRegistration struct {
+	DeviceID   string    `json:"deviceId"`
+	Token      string    `json:"token"`
+	Platform   string    `json:"platform"`         // android, ios
+	Events     []string  `json:"events,omitempty"` // subscribed events, empty means all
+	Registered time.Time `json:"registered"`
+}
+
+// PushEvent is a notification to relay to registered devices
+type PushEvent struct {
+	Type         string `json:"event"`
+	Title        string `json:"title"`
+	Message      string `json:"message"`
+	SourceDevice string `json:"sourceDevice,omitempty"` // device that caused the event, not notified
+}
+
+var (
+	pushMutex       sync.Mutex
+	lastLowDiskPush time.Time
+)
+
+func loadPushRegistrations(baseDir string) (map[string]*PushRegistration, error) {
+	regs := make(map[string]*PushRegistration)
+	b, err := os.ReadFile(filepath.Join(baseDir, pushTokensFileName))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return regs, nil
+		}
+		return nil, err
+	}
+	if err := json.Unmarshal(b, &regs); err != nil {
+		return nil, fmt.Errorf("parse push tokens: %w", err)
+	}
+	return regs, nil
+}
+
+func savePushRegistrations(baseDir string, regs map[string]*PushRegistration) error {
+	b, err := json.MarshalIndent(regs, "", "  ")
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(baseDir, pushTokensFileName)
+	if err := os.WriteFile(path+".tmp", b, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// validNtfyTopic reports whether token is usable as an ntfy topic name, which becomes a
+// path segment of the topic URL
+func validNtfyTopic(token string) bool {
+	if token == "" || len(token) > 64 {
+		return false
+	}
+	for _, c := range token {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '-' || c == '_':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// registerPushToken stores or replaces the push token for a device
+func registerPushToken(config *Config, baseDir string, reg PushRegistration) error {
+	if reg.DeviceID == "" || reg.Token == "" {
+		return fmt.Errorf("deviceId and token are required")
+	}
+	if config != nil && config.Push != nil && strings.EqualFold(config.Push.Provider, "ntfy") && !validNtfyTopic(reg.Token) {
+		return fmt.Errorf("token must be an ntfy topic name: up to 64 letters, digits, '-' or '_'")
+	}
+	pushMutex.Lock()
+	defer pushMutex.Unlock()
+
+	regs, err := loadPushRegistrations(baseDir)
+	if err != nil {
+		return err
+	}
+	reg.Registered = clock.Now()
+	regs[reg.DeviceID] = &reg
+	return savePushRegistrations(baseDir, regs)
+}
+
+// unregisterPushToken removes a device's push token
+func unregisterPushToken(baseDir, deviceID string) error {
+	pushMutex.Lock()
+	defer pushMutex.Unlock()
+
+	regs, err := loadPushRegistrations(baseDir)
+	if err != nil {
+		return err
+	}
+	delete(regs, deviceID)
+	return savePushRegistrations(baseDir, regs)
+}
+
+// notifyEvent relays an event to every subscribed device except its source.
+// Delivery happens in the background; failures are logged only.
+func notifyEvent(config *Config, ev PushEvent) {
+	if config == nil || config.Push == nil || config.Push.URL == "" {
+		return
+	}
+	baseDir := config.ReceiveDir
+	if baseDir == "" {
+		baseDir = "received"
+	}
+
+	pushMutex.Lock()
+	regs, err := loadPushRegistrations(baseDir)
+	pushMutex.Unlock()
+	if err != nil {
+		log.Printf("Error loading push tokens: %v", err)
+		return
+	}
+
+	push := *config.Push
+	for _, reg := range regs {
+		if reg.DeviceID == ev.SourceDevice || !subscribed(reg, ev.Type) {
+			continue
+		}
+		go func(reg PushRegistration) {
+			if err := sendPush(push, reg, ev); err != nil {
+				log.Printf("Push %s to device %s failed: %v", ev.Type, reg.DeviceID, err)
+			}
+		}(*reg)
+	}
+}
+
+func subscribed(reg *PushRegistration, event string) bool {
+	if len(reg.Events) == 0 {
+		return true
+	}
+	for _, e := range reg.Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+func sendPush(push PushConfig, reg PushRegistration, ev PushEvent) error {
+	var req *http.Request
+	var err error
+	switch strings.ToLower(push.Provider) {
+	case "ntfy":
+		if !validNtfyTopic(reg.Token) {
+			return fmt.Errorf("token of device %s is not an ntfy topic name", reg.DeviceID)
+		}
+		topicURL := strings.TrimSuffix(push.URL, "/") + "/" + url.PathEscape(reg.Token)
+		req, err = http.NewRequest("POST", topicURL, strings.NewReader(ev.Message))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Title", ev.Title)
+		req.Header.Set("Tags", ev.Type)
+	case "", "webhook":
+		body, err := json.Marshal(map[string]interface{}{
+			"token":    reg.Token,
+			"platform": reg.Platform,
+			"event":    ev.Type,
+			"title":    ev.Title,
+			"message":  ev.Message,
+		})
+		if err != nil {
+			return err
+		}
+		req, err = http.NewRequest("POST", push.URL, bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+	default:
+		return fmt.Errorf("unknown push provider %q", push.Provider)
+	}
+	if push.AuthToken != "" {
+		req.Header.Set("Authorization", "Bearer "+push.AuthToken)
+	}
+
+	client := &http.Client{Timeout: 15 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("push provider returned %s", resp.Status)
+	}
+	return nil
+}
+
+// checkLowDiskSpace notifies devices when free space in the receive dir is below the
+// configured threshold, at most once per lowDiskNotifyInterval.
+func checkLowDiskSpace(config *Config) {
+	if config == nil || config.Push == nil || config.Push.LowDiskMB <= 0 {
+		return
+	}
+	baseDir := config.ReceiveDir
+	if baseDir == "" {
+		baseDir = "received"
+	}
+	free, err := diskFreeBytes(baseDir)
+	if err != nil || free >= uint64(config.Push.LowDiskMB)*1024*1024 {
+		return
+	}
+
+	pushMutex.Lock()
+	if clock.Since(lastLowDiskPush) < lowDiskNotifyInterval {
+		pushMutex.Unlock()
+		return
+	}
+	lastLowDiskPush = clock.Now()
+	pushMutex.Unlock()
+
+	notifyEvent(config, PushEvent{
+		Type:    pushEventLowDiskSpace,
+		Title:   config.ServerName + ": low disk space",
+		Message: fmt.Sprintf("Only %d MB free on the photo server", free/1024/1024),
+	})
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"image/jpeg"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// documentNameHints are words in the names of screenshots, scans and other pictures of text
+var documentNameHints = []string{"screenshot", "screen shot", "screen_shot", "scan", "document", "receipt", "whiteboard"}
+
+// tesseractAnalyzer reads the text in photos with the tesseract OCR tool, so they can be
+// found by what they say. By default only screenshots and documents are read: files
+// named like them, and pictures without a camera in their EXIF (saved, scanned or made
+// by an app).
+type tesseractAnalyzer struct {
+	config  AnalyzerConfig
+	command string
+	lang    string
+	timeout time.Duration
+}
+
+func newTesseractAnalyzer(ac AnalyzerConfig) (mediaAnalyzer, error) {
+	command := ac.Command
+	if command == "" {
+		command = "tesseract"
+	}
+	if _, err := tools.LookPath(command); err != nil {
+		return nil, err
+	}
+	if ac.Scope != "" && ac.Scope != "documents" && ac.Scope != "all" {
+		return nil, fmt.Errorf("unknown scope %q", ac.Scope)
+	}
+	if ac.Lang == "" {
+		ac.Lang = "eng"
+	}
+	timeout := analyzerTimeout
+	if ac.TimeoutSec > 0 {
+		timeout = time.Duration(ac.TimeoutSec) * time.Second
+	}
+	return &tesseractAnalyzer{config: ac, command: command, lang: ac.Lang, timeout: timeout}, nil
+}
+
+func (a *tesseractAnalyzer) name() string { return a.config.Name }
+
+// handles photos only: a video's text would need its frames read one by one
+func (a *tesseractAnalyzer) handles(media string) bool { return media == "photo" }
+
+// looksLikeDocument reports whether a photo is probably a screenshot or a picture of a document
+func looksLikeDocument(in *AnalysisInput) bool {
+	name := strings.ToLower(filepath.Base(in.Name))
+	for _, hint := range documentNameHints {
+		if strings.Contains(name, hint) {
+			return true
+		}
+	}
+	return in.Exif == nil || in.Exif.Make == "" && in.Exif.Model == ""
+}
+
+func (a *tesseractAnalyzer) analyze(ctx context.Context, in *AnalysisInput) (*Analysis, error) {
+	if a.config.Scope != "all" && !looksLikeDocument(in) {
+		return &Analysis{}, nil // a camera photo: looked at, nothing to read
+	}
+	tmpDir, err := os.MkdirTemp("", "ocr-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	src := in.Path
+	if hasExtension(src, []string{".heic"}) {
+		// tesseract can't read HEIC; hand it a JPEG instead
+		img, _, err := convertHEICToImage(src)
+		if err != nil {
+			return nil, err
+		}
+		src = filepath.Join(tmpDir, "page.jpg")
+		f, err := os.Create(src)
+		if err != nil {
+			return nil, err
+		}
+		err = jpeg.Encode(f, img, &jpeg.Options{Quality: 95})
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// tesseract logs to stderr, so take the text from the file it writes
+	outBase := filepath.Join(tmpDir, "text")
+	if output, err := runTool(ctx, a.timeout, a.command, src, outBase, "-l", a.lang); err != nil {
+		return nil, fmt.Errorf("%s failed: %v, output: %s", a.command, err, strings.TrimSpace(string(output)))
+	}
+	text, err := os.ReadFile(outBase + ".txt")
+	if err != nil {
+		return nil, err
+	}
+	// Keep the words, not the layout: runs of blank lines and spaces add nothing to search
+	lines := strings.Split(string(text), "\n")
+	kept := lines[:0]
+	for _, line := range lines {
+		if line = strings.Join(strings.Fields(line), " "); line != "" {
+			kept = append(kept, line)
+		}
+	}
+	result := &Analysis{Text: strings.Join(kept, "\n")}
+	if result.Text != "" {
+		result.Tags = []string{"text"}
+	}
+	return result, nil
+}
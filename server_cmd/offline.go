@@ -0,0 +1,39 @@
+package main
+
+import (
+	"log"
+	"strings"
+)
+
+// offlineMode is set from config.Offline: the server runs without internet access, so
+// the features that need it are off instead of failing or hanging on timeouts. Nothing
+// else changes: the web UI is served from the binary and loads nothing from elsewhere.
+var offlineMode bool
+
+// setOfflineMode turns off what needs internet access when the config asks for offline
+// operation. Services the config points at a host, such as the push relay, S3 backup or
+// remote export, are left alone, as those are usually on the local network.
+func setOfflineMode(config *Config) {
+	if !config.Offline {
+		return
+	}
+	offlineMode = true
+	if t := config.Telemetry; t != nil && t.Enabled && !t.LocalOnly {
+		t.LocalOnly = true
+		log.Printf("Offline mode: usage reports are kept locally instead of sent to %s", t.Endpoint)
+	}
+	if g := config.Geocoder; g != nil && strings.EqualFold(g.Provider, "http") {
+		config.Geocoder = nil
+		log.Printf("Offline mode: the http geocoder is off, use the offline provider for place names")
+	}
+	log.Printf("Offline mode: %s are off", strings.Join(offlineDisabled(), ", "))
+}
+
+// offlineDisabled lists what offline mode turns off, for the log and the home page; nil
+// when the server isn't offline
+func offlineDisabled() []string {
+	if !offlineMode {
+		return nil
+	}
+	return []string{"music download", "usage report uploads", "the http geocoder"}
+}
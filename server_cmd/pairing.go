@@ -0,0 +1,248 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	qrcode "github.com/skip2/go-qrcode"
+)
+
+const (
+	// pairingRotation is how long a pairing PIN and its QR token stay valid. Each is good
+	// for one device; a new pair is drawn as soon as one is used.
+	pairingRotation = 5 * time.Minute
+
+	// maxPairingFailures wrong codes retire the current PIN and token, so guessing has to
+	// start over against a new PIN
+	maxPairingFailures = 5
+
+	// pairingFailWait is how long a remote waits after a wrong code, doubling with every
+	// further one up to pairingMaxWait
+	pairingFailWait = 2 * time.Second
+	pairingMaxWait  = 5 * time.Minute
+)
+
+// pairingCode is what the home page shows for pairing a phone
+type pairingCode struct {
+	PIN     string    `json:"pin"`   // 6 digits, typed into the app
+	Token   string    `json:"token"` // longer one-time token carried by the QR code
+	Expires time.Time `json:"expires"`
+}
+
+var (
+	pairingMutex    sync.Mutex
+	currentPairing  pairingCode
+	pairingFailures int // wrong codes against currentPairing
+
+	pairingThrottle = newFailureThrottle(pairingFailWait, pairingMaxWait)
+)
+
+// randomDigits returns n random decimal digits
+func randomDigits(n int) (string, error) {
+	max := big.NewInt(1)
+	for i := 0; i < n; i++ {
+		max.Mul(max, big.NewInt(10))
+	}
+	v, err := rand.Int(rand.Reader, max)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%0*d", n, v), nil
+}
+
+// randomToken returns n random bytes, hex encoded
+func randomToken(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// activePairing returns the current PIN and token, drawing new ones once they expired.
+// Callers hold pairingMutex.
+func activePairing() (pairingCode, error) {
+	if clock.Now().Before(currentPairing.Expires) {
+		return currentPairing, nil
+	}
+	pin, err := randomDigits(6)
+	if err != nil {
+		return pairingCode{}, err
+	}
+	token, err := randomToken(16)
+	if err != nil {
+		return pairingCode{}, err
+	}
+	currentPairing = pairingCode{PIN: pin, Token: token, Expires: clock.Now().Add(pairingRotation)}
+	pairingFailures = 0
+	return currentPairing, nil
+}
+
+// consumePairingCode reports whether code is the current PIN or QR token, and if so
+// retires it so it can't pair a second device. Too many wrong codes retire it as well.
+func consumePairingCode(code string) bool {
+	pairingMutex.Lock()
+	defer pairingMutex.Unlock()
+
+	current, err := activePairing()
+	if err != nil || code == "" {
+		return false
+	}
+	if subtle.ConstantTimeCompare([]byte(code), []byte(current.PIN)) != 1 &&
+		subtle.ConstantTimeCompare([]byte(code), []byte(current.Token)) != 1 {
+		if pairingFailures++; pairingFailures >= maxPairingFailures {
+			log.Printf("%d wrong pairing codes, drawing a new PIN", pairingFailures)
+			currentPairing = pairingCode{}
+		}
+		return false
+	}
+	currentPairing = pairingCode{}
+	return true
+}
+
+// hashDeviceToken is how device tokens are kept in the registry
+func hashDeviceToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// pairDevice approves a device that presents the current pairing PIN or QR token and
+// returns the device token it must send with every later REGISTER_DEVICE. A remote that
+// sent a wrong code has to wait before it may try again.
+func pairDevice(config *Config, baseDir, remote, id, name, code string) (string, error) {
+	if config.Devices == nil || !config.Devices.Pairing {
+		return "", fmt.Errorf("pairing is not enabled on this server")
+	}
+	if !validDeviceID(id) {
+		return "", fmt.Errorf("invalid device id %q", id)
+	}
+	if wait := pairingThrottle.wait(remote); wait > 0 {
+		return "", fmt.Errorf("too many wrong pairing codes, try again in %s", wait.Round(time.Second))
+	}
+	if !consumePairingCode(code) {
+		wait := pairingThrottle.failed(remote)
+		log.Printf("Device %s (%s) from %s sent a wrong or expired pairing code, next try in %s", id, name, remote, wait)
+		return "", fmt.Errorf("wrong or expired pairing code")
+	}
+	pairingThrottle.succeeded(remote)
+	token, err := randomToken(32)
+	if err != nil {
+		return "", err
+	}
+
+	devicesMutex.Lock()
+	defer devicesMutex.Unlock()
+
+	devices, err := loadDevices(baseDir)
+	if err != nil {
+		return "", err
+	}
+	rec, ok := devices[id]
+	if !ok {
+		now := clock.Now()
+		rec = &DeviceRecord{ID: id, Name: name, FirstSeen: now, LastSeen: now}
+		devices[id] = rec
+	}
+	rec.Status = deviceApproved
+	rec.TokenHash = hashDeviceToken(token)
+	if err := os.MkdirAll(baseDir, 0o755); err != nil {
+		return "", err
+	}
+	if err := saveDevices(baseDir, devices); err != nil {
+		return "", err
+	}
+	log.Printf("Paired device %s (%s)", id, name)
+	return token, nil
+}
+
+// pairingURL is what the QR code holds: where to sync and the one-time token
+func pairingURL(config *Config, host, token string) string {
+	q := url.Values{}
+	q.Set("host", host)
+	q.Set("port", fmt.Sprint(portNumber(config.TcpPort)))
+	q.Set("http", fmt.Sprint(portNumber(config.HttpPort)))
+	q.Set("name", config.ServerName)
+	q.Set("token", token)
+	return "photosync://pair?" + q.Encode()
+}
+
+// pairingHost is the address phones should connect to: the one the browser used to
+// reach the web UI, which is on the same network
+func pairingHost(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.Host)
+	if err != nil {
+		host = r.Host
+	}
+	if host == "" || host == "localhost" || net.ParseIP(host).IsLoopback() {
+		if info, err := getDefaultInterfaceInfo(); err == nil {
+			host = info.IP.String()
+		}
+	}
+	return host
+}
+
+// registerPairingRoutes adds the current pairing code and its QR image for the home page
+func registerPairingRoutes(router *mux.Router, config *Config) {
+	enabled := func(w http.ResponseWriter) bool {
+		if config.Devices == nil || !config.Devices.Pairing {
+			http.Error(w, "Pairing is not enabled", http.StatusNotFound)
+			return false
+		}
+		w.Header().Set("Cache-Control", "no-store")
+		return true
+	}
+
+	router.HandleFunc("/api/pairing", func(w http.ResponseWriter, r *http.Request) {
+		if !enabled(w) {
+			return
+		}
+		pairingMutex.Lock()
+		code, err := activePairing()
+		pairingMutex.Unlock()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success":   true,
+			"pin":       code.PIN,
+			"expires":   code.Expires,
+			"expiresIn": int(code.Expires.Sub(clock.Now()).Seconds()),
+			"url":       pairingURL(config, pairingHost(r), code.Token),
+		})
+	}).Methods("GET")
+
+	router.HandleFunc("/pairing/qr.png", func(w http.ResponseWriter, r *http.Request) {
+		if !enabled(w) {
+			return
+		}
+		pairingMutex.Lock()
+		code, err := activePairing()
+		pairingMutex.Unlock()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		png, err := qrcode.Encode(pairingURL(config, pairingHost(r), code.Token), qrcode.Medium, 256)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "image/png")
+		w.Write(png)
+	}).Methods("GET")
+}
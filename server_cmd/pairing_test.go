@@ -0,0 +1,112 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	pb "photo_sync_server/photosyncpb"
+)
+
+// resetPairing forgets the pairing code and the throttle's failures, for a test
+func resetPairing(t *testing.T) {
+	t.Helper()
+	reset := func() {
+		pairingMutex.Lock()
+		currentPairing, pairingFailures = pairingCode{}, 0
+		pairingMutex.Unlock()
+		pairingThrottle = newFailureThrottle(pairingFailWait, pairingMaxWait)
+	}
+	reset()
+	t.Cleanup(reset)
+}
+
+func currentPairingCode(t *testing.T) pairingCode {
+	t.Helper()
+	pairingMutex.Lock()
+	defer pairingMutex.Unlock()
+	code, err := activePairing()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return code
+}
+
+func TestPairingCodeExpires(t *testing.T) {
+	c := useFakeClock(t, time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC))
+	resetPairing(t)
+	config := &Config{Devices: &DevicesConfig{Pairing: true}}
+	baseDir := t.TempDir()
+
+	// The QR link's token is good until the code rotates
+	code := currentPairingCode(t)
+	c.Advance(pairingRotation - time.Second)
+	if again := currentPairingCode(t); again != code {
+		t.Fatal("pairing code rotated early")
+	}
+	c.Advance(2 * time.Second)
+	if _, err := pairDevice(config, baseDir, "192.0.2.1:5000", "device-1", "Pixel", code.Token); err == nil {
+		t.Fatal("expired QR token paired a device")
+	}
+
+	// A fresh one pairs once
+	code = currentPairingCode(t)
+	token, err := pairDevice(config, baseDir, "192.0.2.2:5000", "device-2", "Pixel", code.Token)
+	if err != nil || token == "" {
+		t.Fatalf("pairing with the current token: %q, %v", token, err)
+	}
+	if _, err := pairDevice(config, baseDir, "192.0.2.2:5000", "device-3", "Pixel", code.Token); err == nil {
+		t.Error("a used QR token paired a second device")
+	}
+}
+
+func TestWrongPairingCodesRotateThePIN(t *testing.T) {
+	useFakeClock(t, time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC))
+	resetPairing(t)
+
+	code := currentPairingCode(t)
+	for i := 0; i < maxPairingFailures; i++ {
+		if consumePairingCode("not the pin") {
+			t.Fatal("a wrong code was accepted")
+		}
+	}
+	if consumePairingCode(code.PIN) {
+		t.Error("the PIN still works after maxPairingFailures wrong codes")
+	}
+}
+
+func TestPairingThrottlesWrongCodes(t *testing.T) {
+	c := useFakeClock(t, time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC))
+	resetPairing(t)
+	config := &Config{Devices: &DevicesConfig{Pairing: true}}
+	baseDir := t.TempDir()
+
+	if _, err := pairDevice(config, baseDir, "192.0.2.1:5000", "device-1", "Pixel", "000000x"); err == nil {
+		t.Fatal("a wrong code paired a device")
+	}
+	// Even the right code has to wait, from any port of the same address
+	code := currentPairingCode(t)
+	if _, err := pairDevice(config, baseDir, "192.0.2.1:6000", "device-1", "Pixel", code.PIN); err == nil {
+		t.Fatal("paired during the wait after a wrong code")
+	}
+	c.Advance(pairingFailWait)
+	if _, err := pairDevice(config, baseDir, "192.0.2.1:6000", "device-1", "Pixel", code.PIN); err != nil {
+		t.Fatalf("pairing after the wait: %v", err)
+	}
+}
+
+func TestPairedDeviceOverGRPC(t *testing.T) {
+	resetPairing(t)
+	config := &Config{ReceiveDir: t.TempDir(), Devices: &DevicesConfig{Pairing: true}}
+	token, err := pairDevice(config, config.ReceiveDir, "192.0.2.1:5000", "device-1", "Pixel", currentPairingCode(t).Token)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &photoSyncService{config: config}
+	if _, err := s.phoneDir(&pb.Device{DeviceId: "device-1", Name: "Pixel"}); err == nil {
+		t.Error("a paired device was accepted without its token")
+	}
+	if _, err := s.phoneDir(&pb.Device{DeviceId: "device-1", Name: "Pixel", Token: token}); err != nil {
+		t.Errorf("a paired device with its token: %v", err)
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"image"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// Panorama kinds recorded in the catalog
+const (
+	panoramaEquirectangular = "equirectangular" // 360° photo sphere, shown in the interactive viewer
+	panoramaWide            = "wide"            // flat panorama, shown in a pannable strip
+)
+
+const (
+	// xmpScanSize is how much of the file head is searched for the XMP packet
+	xmpScanSize = 512 * 1024
+
+	// A 2:1 image at least this wide without metadata is taken to be a photo sphere
+	minSphereWidth = 4000
+
+	// wideAspectRatio is the width/height ratio from which a photo counts as a panorama
+	wideAspectRatio = 2.5
+)
+
+// detectPanorama classifies a photo as a 360° sphere, a wide panorama or neither ("").
+// Google Photo Sphere XMP metadata (GPano:ProjectionType) wins; otherwise the aspect
+// ratio decides. Formats Go can't decode (HEIC) are only classified by metadata.
+func detectPanorama(path string) string {
+	if !hasExtension(path, photoExtensions) {
+		return ""
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+
+	head := make([]byte, xmpScanSize)
+	n, _ := io.ReadFull(f, head)
+	head = head[:n]
+	if i := bytes.Index(head, []byte("GPano:ProjectionType")); i >= 0 {
+		// Attribute (GPano:ProjectionType="...") or element (<GPano:ProjectionType>...) form
+		value := head[i+len("GPano:ProjectionType"):]
+		if len(value) > 64 {
+			value = value[:64]
+		}
+		if bytes.Contains(bytes.ToLower(value), []byte("equirectangular")) {
+			return panoramaEquirectangular
+		}
+		return panoramaWide
+	}
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return ""
+	}
+	cfg, _, err := image.DecodeConfig(f)
+	if err != nil || cfg.Height == 0 {
+		return ""
+	}
+	ratio := float64(cfg.Width) / float64(cfg.Height)
+	switch {
+	case ratio > 1.98 && ratio < 2.02 && cfg.Width >= minSphereWidth:
+		return panoramaEquirectangular
+	case ratio >= wideAspectRatio:
+		return panoramaWide
+	}
+	return ""
+}
+
+// registerPanoramaRoutes adds the media info lookup the lightbox uses to pick a viewer
+func registerPanoramaRoutes(router *mux.Router, config *Config) {
+	router.HandleFunc("/api/media-info/{phoneName}/{fileName}", func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		phoneName := vars["phoneName"]
+		fileName := vars["fileName"]
+
+		w.Header().Set("Content-Type", "application/json")
+		if validatePhoneName(phoneName) != nil ||
+			strings.Contains(fileName, "..") || strings.ContainsAny(fileName, "/\\") {
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Invalid path"})
+			return
+		}
+
+		baseDir := config.ReceiveDir
+		if baseDir == "" {
+			baseDir = "received"
+		}
+		phoneDir := filepath.Join(baseDir, phoneName)
+		orig, ok := originalForThumbnail(phoneDir, fileName)
+		if !ok {
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Not found"})
+			return
+		}
+		catalog := openCatalog(phoneDir)
+		resp := map[string]interface{}{
+			"success":  true,
+			"name":     filepath.Base(orig),
+			"panorama": catalog.Panorama(orig),
+		}
+		if info, err := os.Stat(orig); err == nil {
+			resp["taken"] = catalog.CaptureTime(orig, info)
+		}
+		if precision, place := catalog.Metadata(orig); precision != "" || place != nil {
+			resp["takenPrecision"] = precision
+			resp["place"] = place
+		}
+		json.NewEncoder(w).Encode(resp)
+	}).Methods("GET")
+}
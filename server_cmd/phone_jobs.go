@@ -0,0 +1,223 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+)
+
+// phoneJobs manages the background work of one phone directory. Every connection, HTTP
+// upload and editing action for a phone goes through the same manager from the registry,
+// so a sync starting on one phone only ever cancels that phone's thumbnail run, and
+// phones syncing at once are thumbnailed side by side instead of queueing behind a
+// single lock. Thumbnails wanted on demand share the phone's worker slots with its run;
+// analysis goes through the same manager, see analysis.go.
+type phoneJobs struct {
+	dir string
+
+	mu         sync.Mutex               // guards the fields below up to thumbnailRun
+	cancel     context.CancelFunc       // stops the queued or running thumbnail run, nil when idle
+	generation uint64                   // bumped per run, so a finished run only clears its own cancel
+	onDemand   map[string]chan struct{} // thumbnail path -> closed when its on-demand generation is done
+
+	analysisPending    []string           // files waiting for the analyzers, in order
+	analysisQueued     map[string]bool    // the same, as a set
+	analysisCancel     context.CancelFunc // stops the analysis run, nil when idle
+	analysisGeneration uint64             // like generation, for analysis runs
+
+	thumbnailRun sync.Mutex     // held while the phone's thumbnails are generated
+	workers      chan struct{}  // one slot per thumbnail being generated, thumbnailRunWorkers in all
+	running      sync.WaitGroup // thumbnail and analysis runs and on-demand thumbnails not finished yet
+}
+
+var (
+	phoneJobsMutex sync.Mutex
+	phoneJobsByDir = make(map[string]*phoneJobs)
+)
+
+// thumbnailRunSlots bounds how many phones are thumbnailed in the background at once; nil
+// for no limit. Set by the hardware profile at startup.
+var thumbnailRunSlots chan struct{}
+
+// thumbnailRunWorkers is how many files a thumbnail run works on at once: the hardware
+// profile's thumbnail workers unless thumbnail_workers is set
+var thumbnailRunWorkers = runtime.NumCPU()
+
+// maxThumbnailRunErrors is how many of a run's failures its error spells out
+const maxThumbnailRunErrors = 5
+
+// thumbnailRunError reports the files of a thumbnail run that got no thumbnail; the
+// others were thumbnailed
+type thumbnailRunError struct {
+	dir   string
+	files int // files the run looked at
+	errs  []error
+}
+
+func (e *thumbnailRunError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d of %d file(s) under %s got no thumbnail", len(e.errs), e.files, e.dir)
+	for i, err := range e.errs {
+		if i == maxThumbnailRunErrors {
+			fmt.Fprintf(&b, "; and %d more", len(e.errs)-i)
+			break
+		}
+		b.WriteString("; ")
+		b.WriteString(err.Error())
+	}
+	return b.String()
+}
+
+func (e *thumbnailRunError) Unwrap() []error { return e.errs }
+
+// jobsFor returns the manager of a phone directory, keyed like the catalogs so relative
+// and absolute spellings of a directory share one
+func jobsFor(phoneDir string) *phoneJobs {
+	key, err := filepath.Abs(phoneDir)
+	if err != nil {
+		key = filepath.Clean(phoneDir)
+	}
+
+	phoneJobsMutex.Lock()
+	defer phoneJobsMutex.Unlock()
+
+	j, ok := phoneJobsByDir[key]
+	if !ok {
+		j = &phoneJobs{
+			dir:            phoneDir,
+			onDemand:       make(map[string]chan struct{}),
+			analysisQueued: make(map[string]bool),
+			workers:        make(chan struct{}, max(1, thumbnailRunWorkers)),
+		}
+		phoneJobsByDir[key] = j
+	}
+	return j
+}
+
+// startThumbnails cancels the phone's queued or running thumbnail run and starts a new one
+// in the background. Thumbnails the old run already wrote are skipped by the new one.
+func (j *phoneJobs) startThumbnails(reason string) {
+	ctx, cancel := context.WithCancel(context.Background())
+	j.mu.Lock()
+	if j.cancel != nil {
+		j.cancel()
+	}
+	j.generation++
+	generation := j.generation
+	j.cancel = cancel
+	j.mu.Unlock()
+
+	log.Printf("Generating thumbnails under %s (%s)\n", j.dir, reason)
+	j.running.Add(1)
+	go func() {
+		defer j.running.Done()
+		defer j.finished(generation, cancel)
+		if err := generateThumbnails(ctx, j.dir); err != nil {
+			if errors.Is(err, context.Canceled) {
+				log.Printf("Thumbnail generation cancelled for %s\n", j.dir)
+			} else {
+				log.Printf("Thumbnail generation error: %v\n", err)
+			}
+			return
+		}
+		log.Printf("Thumbnail generation completed for %s\n", j.dir)
+	}()
+}
+
+// cancelThumbnails stops the phone's queued or running thumbnail run, e.g. because the
+// phone is starting another sync and the run would only compete with it for the disk
+func (j *phoneJobs) cancelThumbnails(reason string) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.cancel != nil {
+		log.Printf("Cancelling thumbnail generation for %s (%s)\n", j.dir, reason)
+		j.cancel()
+		j.cancel = nil
+	}
+}
+
+// finished releases a run's context; a newer run started meanwhile keeps its own
+func (j *phoneJobs) finished(generation uint64, cancel context.CancelFunc) {
+	cancel()
+	j.mu.Lock()
+	if j.generation == generation {
+		j.cancel = nil
+	}
+	j.mu.Unlock()
+}
+
+// acquireWorker waits for one of the phone's thumbnail worker slots and returns its release
+func (j *phoneJobs) acquireWorker(ctx context.Context) (func(), error) {
+	select {
+	case j.workers <- struct{}{}:
+		return func() { <-j.workers }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// thumbnailOnDemand generates the thumbnail of the original orig into thumbDir in one of
+// the phone's worker slots and returns a channel that is closed when it is done. Calls for
+// the same thumbnail share one generation, which isn't tied to any caller: one that gives
+// up leaves it running for the next.
+func (j *phoneJobs) thumbnailOnDemand(orig, thumbDir string) <-chan struct{} {
+	thumbPath := filepath.Join(thumbDir, thumbnailName(filepath.Base(orig)))
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if done, ok := j.onDemand[thumbPath]; ok {
+		return done
+	}
+	done := make(chan struct{})
+	j.onDemand[thumbPath] = done
+
+	j.running.Add(1)
+	go func() {
+		defer j.running.Done()
+		defer func() {
+			j.mu.Lock()
+			delete(j.onDemand, thumbPath)
+			j.mu.Unlock()
+			close(done)
+		}()
+		release, _ := j.acquireWorker(context.Background())
+		defer release()
+
+		if err := os.MkdirAll(thumbDir, 0o755); err != nil {
+			return
+		}
+		countFeature("thumbnail_on_demand")
+		if err := generateThumbnail(context.Background(), filepath.Dir(orig), thumbDir, filepath.Base(orig)); err != nil {
+			log.Printf("On-demand thumbnail failed: %v", err)
+		}
+	}()
+	return done
+}
+
+// stopPhoneJobs cancels every phone's thumbnail and analysis runs at shutdown and waits
+// until deadline for them and the on-demand thumbnails to finish, so nothing writes to
+// the catalogs once they are flushed
+func stopPhoneJobs(deadline time.Time) {
+	phoneJobsMutex.Lock()
+	all := make([]*phoneJobs, 0, len(phoneJobsByDir))
+	for _, j := range phoneJobsByDir {
+		all = append(all, j)
+	}
+	phoneJobsMutex.Unlock()
+
+	for _, j := range all {
+		j.cancelThumbnails("shutting down")
+		j.cancelAnalysis("shutting down")
+	}
+	for _, j := range all {
+		if !waitTimeout(&j.running, deadline) {
+			log.Printf("Thumbnail generation for %s still running at shutdown", j.dir)
+		}
+	}
+}
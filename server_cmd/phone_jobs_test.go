@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// writeTestJPEGs puts n small JPEG originals into dir and returns their thumbnail names
+func writeTestJPEGs(t *testing.T, dir string, n int) []string {
+	t.Helper()
+	var thumbs []string
+	for i := 0; i < n; i++ {
+		name := fmt.Sprintf("IMG_%04d.jpg", i)
+		f, err := os.Create(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = jpeg.Encode(f, image.NewGray(image.Rect(0, 0, 64, 48)), nil)
+		f.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		thumbs = append(thumbs, thumbnailName(name))
+	}
+	return thumbs
+}
+
+func TestJobsForSharesOneManagerPerDirectory(t *testing.T) {
+	dir := t.TempDir()
+	t.Chdir(filepath.Dir(dir))
+	spellings := []string{dir, dir + "/", filepath.Base(dir), "./" + filepath.Base(dir)}
+
+	managers := make([]*phoneJobs, 32)
+	var wg sync.WaitGroup
+	for i := range managers {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			managers[i] = jobsFor(spellings[i%len(spellings)])
+		}(i)
+	}
+	wg.Wait()
+	for i, j := range managers {
+		if j != managers[0] {
+			t.Fatalf("jobsFor(%q) returned another manager than jobsFor(%q)", spellings[i%len(spellings)], spellings[0])
+		}
+	}
+	if other := jobsFor(t.TempDir()); other == managers[0] {
+		t.Fatal("two phone directories share a manager")
+	}
+}
+
+func TestPhoneJobsConcurrentStartAndCancel(t *testing.T) {
+	dir := t.TempDir()
+	thumbs := writeTestJPEGs(t, dir, 8)
+	j := jobsFor(dir)
+
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 20; i++ {
+				switch (g + i) % 3 {
+				case 0:
+					jobsFor(dir).startThumbnails("test")
+				case 1:
+					jobsFor(dir).cancelThumbnails("test")
+				case 2:
+					ensureThumbnail(context.Background(), dir, thumbs[(g+i)%len(thumbs)])
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	// A last run after all the cancelling makes every thumbnail
+	j.startThumbnails("test")
+	j.running.Wait()
+
+	j.mu.Lock()
+	idle := j.cancel == nil && len(j.onDemand) == 0
+	j.mu.Unlock()
+	if !idle {
+		t.Error("manager still has a run or an on-demand thumbnail after all finished")
+	}
+	for _, name := range thumbs {
+		if _, err := os.Stat(filepath.Join(dir, "thumbnails", name)); err != nil {
+			t.Errorf("thumbnail %s missing: %v", name, err)
+		}
+	}
+	if n := len(j.workers); n != 0 {
+		t.Errorf("%d worker slot(s) still taken", n)
+	}
+}
+
+func TestEnsureThumbnailSharesOneGeneration(t *testing.T) {
+	dir := t.TempDir()
+	thumbs := writeTestJPEGs(t, dir, 1)
+
+	var wg sync.WaitGroup
+	results := make([]bool, 16)
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i] = ensureThumbnail(context.Background(), dir, thumbs[0])
+		}(i)
+	}
+	wg.Wait()
+	for i, ok := range results {
+		if !ok {
+			t.Errorf("request %d got no thumbnail", i)
+		}
+	}
+
+	// A caller that gives up doesn't stop the generation for the others
+	os.Remove(filepath.Join(dir, "thumbnails", thumbs[0]))
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	ensureThumbnail(ctx, dir, thumbs[0])
+	jobsFor(dir).running.Wait()
+	if _, err := os.Stat(filepath.Join(dir, "thumbnails", thumbs[0])); err != nil {
+		t.Errorf("thumbnail missing after its requester gave up: %v", err)
+	}
+}
+
+func TestStopPhoneJobsCancelsRuns(t *testing.T) {
+	dir := t.TempDir()
+	writeTestJPEGs(t, dir, 4)
+	j := jobsFor(dir)
+
+	j.startThumbnails("test")
+	stopPhoneJobs(time.Now().Add(time.Minute))
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.cancel != nil {
+		t.Error("thumbnail run not cancelled")
+	}
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"golang.org/x/image/draw"
+)
+
+// photoDownloadQuality is the JPEG quality of converted and resized downloads
+const photoDownloadQuality = 90
+
+// EXIF orientation: the IFD0 tag telling how a JPEG's pixels have to be turned
+const (
+	exifTagOrientation = 0x0112
+	exifTypeShort      = 3
+)
+
+// exifOrientation returns the EXIF orientation (1-8) of a JPEG, 1 when it has none
+func exifOrientation(data []byte) int {
+	tiff, bo, ok := exifTIFF(data)
+	if !ok {
+		return 1
+	}
+	typ, count, value, ok := exifEntry(tiff, bo, bo.Uint32(tiff[4:8]), exifTagOrientation)
+	if !ok || typ != exifTypeShort || count != 1 {
+		return 1
+	}
+	if o := int(bo.Uint16(value)); o >= 1 && o <= 8 {
+		return o
+	}
+	return 1
+}
+
+// orientImage turns src upright according to an EXIF orientation
+func orientImage(src image.Image, orientation int) image.Image {
+	if orientation <= 1 || orientation > 8 {
+		return src
+	}
+	b := src.Bounds()
+	w, h := b.Dx(), b.Dy()
+	dw, dh := w, h
+	if orientation >= 5 {
+		dw, dh = h, w
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var dx, dy int
+			switch orientation {
+			case 2: // mirrored
+				dx, dy = w-1-x, y
+			case 3: // upside down
+				dx, dy = w-1-x, h-1-y
+			case 4: // upside down, mirrored
+				dx, dy = x, h-1-y
+			case 5: // transposed
+				dx, dy = y, x
+			case 6: // needs turning 90° clockwise
+				dx, dy = h-1-y, x
+			case 7: // transversed
+				dx, dy = h-1-y, w-1-x
+			case 8: // needs turning 90° counterclockwise
+				dx, dy = y, w-1-x
+			}
+			dst.Set(dx, dy, src.At(b.Min.X+x, b.Min.Y+y))
+		}
+	}
+	return dst
+}
+
+// decodePhoto decodes a photo upright; HEIC goes through heif-convert, which already
+// applies the rotation
+func decodePhoto(path string) (image.Image, error) {
+	if strings.EqualFold(filepath.Ext(path), ".heic") {
+		img, _, err := convertHEICToImage(path)
+		return img, err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	img, _, err := image.Decode(f)
+	if err != nil {
+		return nil, err
+	}
+	return orientImage(img, exifOrientation(readFileHead(path))), nil
+}
+
+// photoDownloadImage prepares a converted download: the photo scaled to fit size x size
+// (unscaled when 0 or already smaller) and flattened onto white, since JPEG has no alpha
+func photoDownloadImage(img image.Image, size int) image.Image {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if size > 0 && (w > size || h > size) {
+		if w >= h {
+			w, h = size, max(1, h*size/b.Dx())
+		} else {
+			w, h = max(1, w*size/b.Dy()), size
+		}
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.Draw(dst, dst.Bounds(), &image.Uniform{C: color.White}, image.Point{}, draw.Src)
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, b, draw.Over, nil)
+	return dst
+}
+
+// registerPhotoDownloadRoutes adds downloads of a photo as original, as JPEG and resized,
+// for sharing with people whose devices can't open HEIC
+func registerPhotoDownloadRoutes(router *mux.Router, config *Config) {
+	handleTransfer(router, "/download-photo/{phoneName}/{thumbName}", func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		phoneName := vars["phoneName"]
+		thumbName := vars["thumbName"]
+		if validatePhoneName(phoneName) != nil || strings.Contains(thumbName, "..") {
+			http.Error(w, "Invalid path", http.StatusBadRequest)
+			return
+		}
+		baseDir := config.ReceiveDir
+		if baseDir == "" {
+			baseDir = "received"
+		}
+		orig, ok := originalForThumbnail(filepath.Join(baseDir, phoneName), thumbName)
+		if !ok && serveArchived(w, filepath.Join(baseDir, phoneName), thumbName) {
+			return
+		}
+		if !ok || !hasExtension(orig, photoExtensions) {
+			http.NotFound(w, r)
+			return
+		}
+
+		format := r.URL.Query().Get("format")
+		size, _ := strconv.Atoi(r.URL.Query().Get("size"))
+		if size < 0 {
+			size = 0
+		}
+		name := filepath.Base(orig)
+
+		switch format {
+		case "", "original":
+			countFeature("photo_download_original")
+			w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name))
+			http.ServeFile(w, r, orig)
+		case "jpeg":
+			img, err := decodePhoto(orig)
+			if err != nil {
+				log.Printf("Error converting %s for download: %v", orig, err)
+				http.Error(w, "Error converting photo", http.StatusInternalServerError)
+				return
+			}
+			base := strings.TrimSuffix(name, filepath.Ext(name))
+			if size > 0 {
+				base += "_" + strconv.Itoa(size)
+			}
+			out := photoDownloadImage(img, size).(*image.RGBA)
+			// For sharing: with the watermark
+			if r.URL.Query().Get("watermark") == "1" && config.Watermark != nil {
+				config.Watermark.draw(out)
+				countFeature("photo_download_watermarked")
+			}
+			countFeature("photo_download_jpeg")
+			w.Header().Set("Content-Type", "image/jpeg")
+			w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", base+".jpg"))
+			if err := jpeg.Encode(w, out, &jpeg.Options{Quality: photoDownloadQuality}); err != nil {
+				log.Printf("Error writing JPEG download of %s: %v", orig, err)
+			}
+		default:
+			http.Error(w, "Unknown format "+format, http.StatusBadRequest)
+		}
+	}).Methods("GET")
+}
@@ -0,0 +1,22 @@
+//go:build linux
+
+package main
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// preallocateFile reserves size bytes of disk for f so a transfer can't run out of space
+// halfway; filesystems without fallocate support fall back to a sparse Truncate
+func preallocateFile(f *os.File, size int64) error {
+	err := syscall.Fallocate(int(f.Fd()), 0, 0, size)
+	if err == nil {
+		return nil
+	}
+	if errors.Is(err, syscall.ENOSPC) {
+		return errNoSpace
+	}
+	return f.Truncate(size)
+}
@@ -0,0 +1,11 @@
+//go:build !linux
+
+package main
+
+import "os"
+
+// preallocateFile sizes f to size bytes; without fallocate the blocks aren't reserved,
+// the free space check before the transfer is the guard
+func preallocateFile(f *os.File, size int64) error {
+	return f.Truncate(size)
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"log"
+	"math"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"golang.org/x/image/draw"
+)
+
+const (
+	// printDPI is the resolution print services expect
+	printDPI = 300
+	// printQuality is the JPEG quality of prints, higher than downloads as labs enlarge
+	printQuality = 95
+	// maxPrintPhotos bounds one print export, as every photo is rendered at full print size
+	maxPrintPhotos = 200
+	// maxPrintBorderMM is the widest white border a print can get
+	maxPrintBorderMM = 20
+)
+
+// printPaper is a paper size, portrait
+type printPaper struct {
+	Label         string
+	Width, Height float64 // inches
+}
+
+// printPapers are the paper size presets of print exports
+var printPapers = map[string]printPaper{
+	"4x6": {"4×6 in", 4, 6},
+	"5x7": {"5×7 in", 5, 7},
+	"a4":  {"A4", 210 / 25.4, 297 / 25.4},
+}
+
+// pixels returns the paper's size at printDPI, turned landscape when landscape is set
+func (p printPaper) pixels(landscape bool) (int, int) {
+	w, h := int(math.Round(p.Width*printDPI)), int(math.Round(p.Height*printDPI))
+	if landscape {
+		return h, w
+	}
+	return w, h
+}
+
+// printImage lays a photo out on paper at printDPI, in the paper's orientation that suits
+// it: filling the paper and cropping the overhang evenly ("crop"), or whole and centered on
+// white ("pad"). A border of borderPx white pixels is kept around it either way.
+func printImage(img image.Image, paper printPaper, pad bool, borderPx int) *image.RGBA {
+	b := img.Bounds()
+	pw, ph := paper.pixels(b.Dx() > b.Dy())
+	dst := image.NewRGBA(image.Rect(0, 0, pw, ph))
+	draw.Draw(dst, dst.Bounds(), &image.Uniform{C: color.White}, image.Point{}, draw.Src)
+	area := dst.Bounds().Inset(borderPx)
+	aw, ah := area.Dx(), area.Dy()
+
+	src := b
+	if pad {
+		// Scale to fit, keeping the aspect ratio
+		w, h := aw, b.Dy()*aw/b.Dx()
+		if h > ah {
+			w, h = b.Dx()*ah/b.Dy(), ah
+		}
+		at := area.Min.Add(image.Pt((aw-w)/2, (ah-h)/2))
+		area = image.Rectangle{Min: at, Max: at.Add(image.Pt(max(1, w), max(1, h)))}
+	} else if b.Dx()*ah > b.Dy()*aw {
+		// Wider than the paper: cut the sides
+		w := b.Dy() * aw / ah
+		src.Min.X += (b.Dx() - w) / 2
+		src.Max.X = src.Min.X + w
+	} else {
+		// Taller than the paper: cut top and bottom
+		h := b.Dx() * ah / aw
+		src.Min.Y += (b.Dy() - h) / 2
+		src.Max.Y = src.Min.Y + h
+	}
+	draw.CatmullRom.Scale(dst, area, img, src, draw.Over, nil)
+	return dst
+}
+
+// encodePrintJPEG encodes a print with a JFIF header giving its resolution, which Go's
+// encoder leaves out, so print services print it at its intended size
+func encodePrintJPEG(img image.Image) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: printQuality}); err != nil {
+		return nil, err
+	}
+	data := buf.Bytes()
+	jfif := []byte{0xFF, 0xE0, 0x00, 0x10, 'J', 'F', 'I', 'F', 0x00, 0x01, 0x01,
+		0x01, // density in dots per inch
+		byte(printDPI >> 8), byte(printDPI & 0xFF), byte(printDPI >> 8), byte(printDPI & 0xFF),
+		0x00, 0x00} // no embedded thumbnail
+	out := make([]byte, 0, len(data)+len(jfif))
+	out = append(out, data[:2]...) // SOI
+	out = append(out, jfif...)
+	return append(out, data[2:]...), nil
+}
+
+// registerPrintExportRoutes adds the print-ready export of selected photos: each laid out
+// on a paper size preset at printDPI, downloaded as a ZIP to send to a print service
+func registerPrintExportRoutes(router *mux.Router, config *Config) {
+	handleTransfer(router, "/api/phones/{phoneName}/print-export", func(w http.ResponseWriter, r *http.Request) {
+		phoneName := mux.Vars(r)["phoneName"]
+		phoneDir, err := phoneDirFromRequest(config, r)
+		if err != nil {
+			writeJSON(w, map[string]interface{}{"success": false, "error": "Invalid phone name"})
+			return
+		}
+		var req struct {
+			Photos   []string `json:"photos"`
+			Paper    string   `json:"paper"`    // a printPapers key
+			Fit      string   `json:"fit"`      // "crop" (default) or "pad"
+			BorderMM float64  `json:"borderMM"` // white border on every side, 0 for none
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeJSON(w, map[string]interface{}{"success": false, "error": "Invalid request: " + err.Error()})
+			return
+		}
+		paper, ok := printPapers[strings.ToLower(req.Paper)]
+		if !ok {
+			writeJSON(w, map[string]interface{}{"success": false, "error": fmt.Sprintf("Unknown paper size %q", req.Paper)})
+			return
+		}
+		if req.Fit != "" && req.Fit != "crop" && req.Fit != "pad" {
+			writeJSON(w, map[string]interface{}{"success": false, "error": "Fit must be crop or pad"})
+			return
+		}
+		if req.BorderMM < 0 || req.BorderMM > maxPrintBorderMM {
+			writeJSON(w, map[string]interface{}{"success": false, "error": fmt.Sprintf("Border must be 0 to %d mm", maxPrintBorderMM)})
+			return
+		}
+		if len(req.Photos) > maxPrintPhotos {
+			writeJSON(w, map[string]interface{}{"success": false, "error": fmt.Sprintf("At most %d photos per print export", maxPrintPhotos)})
+			return
+		}
+
+		var originals []string
+		for _, photo := range req.Photos {
+			if strings.Contains(photo, "..") {
+				continue
+			}
+			if orig, ok := originalForThumbnail(phoneDir, photo); ok && hasExtension(orig, photoExtensions) {
+				originals = append(originals, orig)
+			}
+		}
+		if len(originals) == 0 {
+			writeJSON(w, map[string]interface{}{"success": false, "error": "No photos selected"})
+			return
+		}
+
+		started := time.Now()
+		key := strings.ToLower(req.Paper)
+		borderPx := int(math.Round(req.BorderMM / 25.4 * printDPI))
+		w.Header().Set("Content-Type", "application/zip")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", fmt.Sprintf("%s-print-%s.zip", phoneName, key)))
+		zw := zip.NewWriter(w)
+		used := make(map[string]bool)
+		written := 0
+		for _, orig := range originals {
+			renditionMutex.Lock()
+			var data []byte
+			img, err := decodePhoto(orig)
+			if err == nil {
+				data, err = encodePrintJPEG(printImage(img, paper, req.Fit == "pad", borderPx))
+			}
+			renditionMutex.Unlock()
+			if err != nil {
+				// The download has started, so a photo that can't be printed is left out
+				log.Printf("Print export: skipping %s: %v", orig, err)
+				continue
+			}
+
+			stem := strings.TrimSuffix(filepath.Base(orig), filepath.Ext(orig))
+			name := fmt.Sprintf("%s_%s.jpg", stem, key)
+			for i := 2; used[name]; i++ {
+				name = fmt.Sprintf("%s_%d_%s.jpg", stem, i, key)
+			}
+			used[name] = true
+			// JPEGs don't compress further, so they are stored
+			f, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Store, Modified: clock.Now()})
+			if err == nil {
+				_, err = f.Write(data)
+			}
+			if err != nil {
+				log.Printf("Print export of %s aborted: %v", phoneName, err)
+				return
+			}
+			written++
+		}
+		if err := zw.Close(); err != nil {
+			log.Printf("Print export of %s aborted: %v", phoneName, err)
+			return
+		}
+		countFeature("print_export")
+		log.Printf("Print export: %d photo(s) of %s on %s in %v", written, phoneName, paper.Label,
+			time.Since(started).Round(time.Millisecond))
+	}).Methods("POST")
+}
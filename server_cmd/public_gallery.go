@@ -0,0 +1,354 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	defaultPublicMaxPixels = 2048
+	defaultPublicCacheSec  = 24 * 60 * 60
+)
+
+// PublicGalleryConfig serves the published albums, and nothing else, on a listener of
+// their own that can be exposed to the internet. Photos are served re-encoded: no larger
+// than max_pixels, without their EXIF data (GPS positions included), with the watermark.
+// Videos only show their thumbnail. The rest of the server stays on the private ports.
+type PublicGalleryConfig struct {
+	Port      string   `json:"port"`       // required, e.g. "8090"
+	TLS       bool     `json:"tls"`        // serve HTTPS with tls_cert_file/tls_key_file (or the generated certificate)
+	Title     string   `json:"title"`      // shown on the album list, default the server name
+	Albums    []string `json:"albums"`     // names of the shared albums to publish
+	MaxPixels int      `json:"max_pixels"` // long side of the largest photo served, default 2048
+	Watermark string   `json:"watermark"`  // text drawn into served photos instead of the server's watermark, e.g. "© The Smiths"
+	CacheSec  int      `json:"cache_sec"`  // how long browsers and proxies may cache pages and photos, default a day
+}
+
+// publicItem is a published album item, known to visitors only by an opaque ID
+type publicItem struct {
+	ID      string
+	Phone   string
+	Name    string
+	Path    string
+	IsVideo bool
+	Taken   time.Time
+}
+
+func (pg *PublicGalleryConfig) maxPixels() int {
+	if pg.MaxPixels <= 0 {
+		return defaultPublicMaxPixels
+	}
+	return pg.MaxPixels
+}
+
+func (pg *PublicGalleryConfig) cacheSec() int {
+	if pg.CacheSec <= 0 {
+		return defaultPublicCacheSec
+	}
+	return pg.CacheSec
+}
+
+func (pg *PublicGalleryConfig) published(album string) bool {
+	for _, name := range pg.Albums {
+		if name == album {
+			return true
+		}
+	}
+	return false
+}
+
+// publicItemID hides the phone and file name of an album item behind a stable ID
+func publicItemID(phone, name string) string {
+	sum := sha256.Sum256([]byte(phone + "/" + name))
+	return hex.EncodeToString(sum[:8])
+}
+
+// publicAlbumItems lists the items of a published album that still exist, newest first
+func publicAlbumItems(baseDir, albumName string) ([]publicItem, bool) {
+	albumsMutex.Lock()
+	albums, err := loadAlbums(baseDir)
+	albumsMutex.Unlock()
+	if err != nil {
+		return nil, false
+	}
+	a, ok := albums[albumName]
+	if !ok {
+		return nil, false
+	}
+	var items []publicItem
+	for _, it := range a.Items {
+		phoneDir := filepath.Join(baseDir, it.Phone)
+		path, ok := originalForThumbnail(phoneDir, it.Name)
+		if !ok {
+			continue
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		items = append(items, publicItem{
+			ID:      publicItemID(it.Phone, it.Name),
+			Phone:   it.Phone,
+			Name:    it.Name,
+			Path:    path,
+			IsVideo: hasExtension(it.Name, videoExtensions),
+			Taken:   openCatalog(phoneDir).CaptureTime(path, info),
+		})
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].Taken.After(items[j].Taken) })
+	return items, true
+}
+
+// watermark is the watermark of served photos: the gallery's own text, styled like the
+// server's watermark if there is one, or else the server's watermark
+func (pg *PublicGalleryConfig) watermark(server *WatermarkConfig) *WatermarkConfig {
+	if pg.Watermark == "" {
+		return server
+	}
+	wm := WatermarkConfig{}
+	if server != nil {
+		wm = *server
+	}
+	wm.Text, wm.Logo = pg.Watermark, ""
+	return &wm
+}
+
+// publicHeaders is the public listener's middleware: read-only methods, no framing, no
+// sniffing, no referrers, and nothing loaded from elsewhere
+func publicHeaders(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		h := w.Header()
+		h.Set("X-Content-Type-Options", "nosniff")
+		h.Set("X-Frame-Options", "DENY")
+		h.Set("Referrer-Policy", "no-referrer")
+		h.Set("Content-Security-Policy", "default-src 'none'; img-src 'self'; style-src 'unsafe-inline'; frame-ancestors 'none'")
+		if r.TLS != nil {
+			h.Set("Strict-Transport-Security", "max-age=31536000")
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// newPublicGalleryRouter builds the public listener's handler
+func newPublicGalleryRouter(config *Config) *mux.Router {
+	pg := config.PublicGallery
+	baseDir := config.ReceiveDir
+	if baseDir == "" {
+		baseDir = "received"
+	}
+	cacheControl := "public, max-age=" + strconv.Itoa(pg.cacheSec())
+	router := mux.NewRouter()
+
+	findItem := func(w http.ResponseWriter, r *http.Request) (publicItem, bool) {
+		vars := mux.Vars(r)
+		if pg.published(vars["album"]) {
+			items, _ := publicAlbumItems(baseDir, vars["album"])
+			for _, it := range items {
+				if it.ID == vars["id"] {
+					return it, true
+				}
+			}
+		}
+		http.NotFound(w, r)
+		return publicItem{}, false
+	}
+
+	router.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		var albums []string
+		albumsMutex.Lock()
+		if all, err := loadAlbums(baseDir); err == nil {
+			for _, name := range pg.Albums {
+				if _, ok := all[name]; ok {
+					albums = append(albums, name)
+				}
+			}
+		}
+		albumsMutex.Unlock()
+		title := pg.Title
+		if title == "" {
+			title = config.ServerName
+		}
+
+		tmpl := `<!DOCTYPE html>
+<html>
+<head>
+    <title>{{.Title}}</title>
+    <meta name="viewport" content="width=device-width, initial-scale=1">
+    <style>
+        body { font-family: 'Segoe UI', Tahoma, Arial, sans-serif; margin: 0; padding: 20px; background: #000000; color: #ffffff; }
+        h1 { font-weight: 300; letter-spacing: 1px; }
+        ul { list-style: none; padding: 0; }
+        li { margin: 10px 0; font-size: 18px; }
+        a { color: #88aaff; text-decoration: none; }
+    </style>
+</head>
+<body>
+    <h1>📚 {{.Title}}</h1>
+    <ul>
+        {{range .Albums}}<li><a href="/a/{{.}}">📚 {{.}}</a></li>{{else}}<li>Nothing published yet.</li>{{end}}
+    </ul>
+</body>
+</html>`
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Header().Set("Cache-Control", "public, max-age=60")
+		template.Must(template.New("public").Parse(tmpl)).Execute(w, struct {
+			Title  string
+			Albums []string
+		}{title, albums})
+	}).Methods("GET", "HEAD")
+
+	router.HandleFunc("/a/{album}", func(w http.ResponseWriter, r *http.Request) {
+		albumName := mux.Vars(r)["album"]
+		items, ok := publicAlbumItems(baseDir, albumName)
+		if !pg.published(albumName) || !ok {
+			http.NotFound(w, r)
+			return
+		}
+		countFeature("public_gallery")
+
+		tmpl := `<!DOCTYPE html>
+<html>
+<head>
+    <title>{{.Name}}</title>
+    <meta name="viewport" content="width=device-width, initial-scale=1">
+    <style>
+        body { font-family: 'Segoe UI', Tahoma, Arial, sans-serif; margin: 0; padding: 20px; background: #000000; color: #ffffff; }
+        h1 { font-weight: 300; letter-spacing: 1px; }
+        a { color: #88aaff; text-decoration: none; font-size: 14px; }
+        .gallery { display: grid; grid-template-columns: repeat(auto-fill, minmax(200px, 1fr)); gap: 20px; padding: 10px; }
+        .gallery-item { background: #1a1a1a; padding: 10px; border-radius: 12px; text-align: center; border: 1px solid #2a2a2a; }
+        .gallery-item img { width: 180px; height: 180px; object-fit: cover; border-radius: 8px; }
+        .caption { margin-top: 8px; font-size: 12px; color: #888888; }
+    </style>
+</head>
+<body>
+    <a href="/">← All albums</a>
+    <h1>📚 {{.Name}}</h1>
+    <div class="gallery">
+        {{range .Items}}
+        <div class="gallery-item">
+            {{if .IsVideo}}<img src="/a/{{$.Name}}/{{.ID}}/thumb" alt="" loading="lazy">
+            <div class="caption">🎬 {{.Taken.Format "2006-01-02"}}</div>
+            {{else}}<a href="/a/{{$.Name}}/{{.ID}}/photo" target="_blank"><img src="/a/{{$.Name}}/{{.ID}}/thumb" alt="" loading="lazy"></a>
+            <div class="caption">{{.Taken.Format "2006-01-02"}}</div>{{end}}
+        </div>
+        {{else}}
+        <p>This album is empty.</p>
+        {{end}}
+    </div>
+</body>
+</html>`
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Header().Set("Cache-Control", "public, max-age=60") // new items show up soon
+		template.Must(template.New("publicAlbum").Parse(tmpl)).Execute(w, struct {
+			Name  string
+			Items []publicItem
+		}{albumName, items})
+	}).Methods("GET", "HEAD")
+
+	router.HandleFunc("/a/{album}/{id}/thumb", func(w http.ResponseWriter, r *http.Request) {
+		it, ok := findItem(w, r)
+		if !ok {
+			return
+		}
+		thumbPath := filepath.Join(baseDir, it.Phone, "thumbnails", thumbnailName(filepath.Base(it.Path)))
+		f, err := os.Open(thumbPath)
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		defer f.Close()
+		info, err := f.Stat()
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "image/jpeg")
+		w.Header().Set("Cache-Control", cacheControl)
+		http.ServeContent(w, r, "", info.ModTime(), f)
+	}).Methods("GET", "HEAD")
+
+	router.HandleFunc("/a/{album}/{id}/photo", func(w http.ResponseWriter, r *http.Request) {
+		it, ok := findItem(w, r)
+		if !ok {
+			return
+		}
+		if it.IsVideo {
+			http.NotFound(w, r)
+			return
+		}
+		path, err := cachedRendition(baseDir, it.Path, pg.maxPixels(), pg.watermark(config.Watermark))
+		if err != nil {
+			log.Printf("Public gallery: error rendering %s/%s: %v", it.Phone, it.Name, err)
+			http.Error(w, "Photo unavailable", http.StatusInternalServerError)
+			return
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		defer f.Close()
+		info, err := f.Stat()
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "image/jpeg")
+		w.Header().Set("Cache-Control", cacheControl)
+		w.Header().Set("ETag", `"`+filepath.Base(path)+`"`)
+		http.ServeContent(w, r, "", info.ModTime(), f)
+	}).Methods("GET", "HEAD")
+
+	router.Use(publicHeaders)
+	router.Use(routeLimits(config))
+	return router
+}
+
+// servePublicGallery serves the published albums on their own port
+func servePublicGallery(config *Config) error {
+	pg := config.PublicGallery
+	server := newHTTPServer(config, pg.Port, newPublicGalleryRouter(config))
+	onShutdown(func(ctx context.Context) { server.Shutdown(ctx) })
+
+	var err error
+	if pg.TLS {
+		baseDir := config.ReceiveDir
+		if baseDir == "" {
+			baseDir = "received"
+		}
+		cert, certErr := loadServerCertificate(config, config.TLSCertFile, config.TLSKeyFile, baseDir)
+		if certErr != nil {
+			return fmt.Errorf("failed to load TLS certificate: %v", certErr)
+		}
+		server.TLSConfig = &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}
+		log.Printf("Public gallery listening on port %s (HTTPS, %d album(s) published)\n", pg.Port, len(pg.Albums))
+		err = server.ListenAndServeTLS("", "")
+	} else {
+		log.Printf("Public gallery listening on port %s (%d album(s) published)\n", pg.Port, len(pg.Albums))
+		err = server.ListenAndServe()
+	}
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
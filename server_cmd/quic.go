@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"sync/atomic"
+
+	"github.com/quic-go/quic-go"
+)
+
+// quicALPN is the TLS application protocol clients must offer on the QUIC transport
+const quicALPN = "photo-sync/1"
+
+// quicCertFingerprint is the hex SHA-256 of the QUIC certificate, set once the listener runs
+var quicCertFingerprint atomic.Value
+
+// quicStreamConn presents the first bidirectional stream of a QUIC connection as a net.Conn,
+// so the framed sync protocol runs over it unchanged
+type quicStreamConn struct {
+	*quic.Stream
+	conn *quic.Conn
+}
+
+func (c *quicStreamConn) LocalAddr() net.Addr  { return c.conn.LocalAddr() }
+func (c *quicStreamConn) RemoteAddr() net.Addr { return c.conn.RemoteAddr() }
+
+func (c *quicStreamConn) Close() error {
+	c.Stream.CancelRead(0)
+	c.Stream.Close()
+	return c.conn.CloseWithError(0, "")
+}
+
+// startQUICServer accepts sync sessions over QUIC: each connection's first bidirectional
+// stream speaks the same framed protocol as a TCP connection
+func startQUICServer(config *Config) error {
+	baseDir := config.ReceiveDir
+	if baseDir == "" {
+		baseDir = "received"
+	}
+	cert, err := loadServerCertificate(config, config.QuicCertFile, config.QuicKeyFile, baseDir)
+	if err != nil {
+		return fmt.Errorf("failed to load QUIC certificate: %v", err)
+	}
+	fingerprint := certificateFingerprint(cert)
+	quicCertFingerprint.Store(fingerprint)
+
+	tlsConf := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		NextProtos:   []string{quicALPN},
+		MinVersion:   tls.VersionTLS13,
+	}
+	// Clients keep the session alive with PING, as on TCP
+	quicConf := &quic.Config{MaxIdleTimeout: idleTimeout(config)}
+	listener, err := quic.ListenAddr(config.QuicPort, tlsConf, quicConf)
+	if err != nil {
+		return fmt.Errorf("failed to start QUIC server: %v", err)
+	}
+	defer listener.Close()
+	onShutdown(func(context.Context) { listener.Close() })
+
+	log.Printf("QUIC Server listening on port%s (certificate sha256 %s)\n", config.QuicPort, fingerprint)
+
+	for {
+		conn, err := listener.Accept(context.Background())
+		if err != nil {
+			if errors.Is(err, quic.ErrServerClosed) {
+				return nil
+			}
+			log.Printf("Error accepting QUIC connection: %v\n", err)
+			continue
+		}
+		go func() {
+			ctx, cancel := context.WithTimeout(conn.Context(), idleTimeout(config))
+			stream, err := conn.AcceptStream(ctx)
+			cancel()
+			if err != nil {
+				log.Printf("No stream opened on QUIC connection from %s: %v\n", conn.RemoteAddr(), err)
+				conn.CloseWithError(0, "")
+				return
+			}
+
+			log.Printf("New QUIC connection from %s\n", conn.RemoteAddr().String())
+			atomic.AddInt64(&activeConnections, 1)
+			defer atomic.AddInt64(&activeConnections, -1)
+			handleTCPConnection(&quicStreamConn{Stream: stream, conn: conn}, config)
+		}()
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"path/filepath"
+)
+
+// QuotaConfig limits the disk space phones may fill, so a runaway phone can't take the
+// whole disk. Quotas are in MB and count a phone's originals, trashed ones included until
+// the trash is emptied.
+type QuotaConfig struct {
+	DefaultMB int64            `json:"default_mb"`  // per phone directory, 0 for no limit
+	Phones    map[string]int64 `json:"phones"`      // by phone directory name, overriding default_mb; 0 for no limit
+	MinFreeMB int64            `json:"min_free_mb"` // uploads are refused when they would leave less free space
+}
+
+// QuotaUsage is a phone's disk use against its quota, sent with quota_exceeded and
+// disk_full ACKs and shown on the home page
+type QuotaUsage struct {
+	UsedBytes    int64  `json:"usedBytes"`
+	LimitBytes   int64  `json:"limitBytes,omitempty"`  // 0 for no quota
+	FreeBytes    uint64 `json:"freeBytes"`             // free on the library's disk
+	FreeUnknown  bool   `json:"freeUnknown,omitempty"` // the free space couldn't be read, FreeBytes is 0
+	MinFreeBytes uint64 `json:"minFreeBytes,omitempty"`
+}
+
+// Percent is how much of the quota is used, 0 without one
+func (u QuotaUsage) Percent() int {
+	if u.LimitBytes <= 0 {
+		return 0
+	}
+	return int(min(100, u.UsedBytes*100/u.LimitBytes))
+}
+
+// phoneQuotaBytes returns the quota of a phone directory, 0 for none
+func phoneQuotaBytes(config *Config, phone string) int64 {
+	if config == nil || config.Quotas == nil {
+		return 0
+	}
+	if mb, ok := config.Quotas.Phones[phone]; ok {
+		return mb << 20
+	}
+	return config.Quotas.DefaultMB << 20
+}
+
+// minFreeBytes returns the free space uploads must leave on the library's disk, 0 for none
+func minFreeBytes(config *Config) uint64 {
+	if config == nil || config.Quotas == nil || config.Quotas.MinFreeMB <= 0 {
+		return 0
+	}
+	return uint64(config.Quotas.MinFreeMB) << 20
+}
+
+// phoneQuotaUsage returns the disk use of the phone directory recvDir
+func phoneQuotaUsage(config *Config, recvDir string) QuotaUsage {
+	u := QuotaUsage{
+		UsedBytes:    openCatalog(recvDir).UsedBytes(),
+		LimitBytes:   phoneQuotaBytes(config, filepath.Base(recvDir)),
+		MinFreeBytes: minFreeBytes(config),
+	}
+	free, err := poolFreeBytes(recvDir)
+	u.FreeBytes, u.FreeUnknown = free, err != nil
+	return u
+}
+
+// admitUpload checks that size more bytes fit the quota of the phone directory recvDir and
+// leave the minimum free space. A refusal is an error ACK for kind and id, carrying the
+// usage; ok is true when the upload may go ahead. When the free space can't be read only
+// the quota is checked: a disk that fails statfs isn't necessarily full.
+func admitUpload(config *Config, recvDir, kind, id string, size int64) (Ack, bool) {
+	limit, minFree := phoneQuotaBytes(config, filepath.Base(recvDir)), minFreeBytes(config)
+	if limit <= 0 && minFree == 0 {
+		return Ack{}, true
+	}
+	u := phoneQuotaUsage(config, recvDir)
+	if minFree > 0 && u.FreeUnknown {
+		log.Printf("Free space of %s unknown, not checking min_free_mb for %s\n", recvDir, id)
+	}
+	var code string
+	var err error
+	switch {
+	case limit > 0 && u.UsedBytes+size > limit:
+		code = ackCodeQuota
+		err = fmt.Errorf("%s more would exceed the quota of %s: %s of %s stored", formatBytes(size), filepath.Base(recvDir), formatBytes(u.UsedBytes), formatBytes(limit))
+	case minFree > 0 && !u.FreeUnknown && u.FreeBytes < uint64(size)+minFree:
+		code = ackCodeDiskFull
+		err = fmt.Errorf("only %s free on the server, %s must stay free", formatBytes(int64(u.FreeBytes)), formatBytes(int64(minFree)))
+	default:
+		return Ack{}, true
+	}
+	log.Printf("Refusing %s for %s: %v\n", id, recvDir, err)
+	ack := errorAck(kind, id, code, err)
+	ack.Quota = &u
+	return ack, false
+}
@@ -0,0 +1,385 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	// remoteExportStateFileName records what the remote target holds, in the receive directory
+	remoteExportStateFileName   = ".remote_export.json"
+	defaultRemoteExportInterval = time.Hour
+)
+
+// RemoteExportConfig pushes new originals to a remote host over SSH on a schedule, with
+// rsync or, where the remote has no rsync, sftp. Files go to <target>/<phone>/<name>;
+// files deleted on the server are left on the remote. Authentication is by SSH key only,
+// the server never prompts.
+type RemoteExportConfig struct {
+	Target      string   `json:"target"`       // user@host:/path, the directory must exist
+	Method      string   `json:"method"`       // "rsync" (default) or "sftp"
+	Port        int      `json:"port"`         // SSH port, default 22
+	SSHKey      string   `json:"ssh_key"`      // private key file, default the user's SSH keys
+	IntervalMin int      `json:"interval_min"` // between pushes, default 60
+	BwLimitKBps int      `json:"bwlimit_kbps"` // rsync only, 0 for no limit
+	Phones      []string `json:"phones"`       // phones to push, empty for all
+}
+
+var (
+	remoteExportMutex     sync.Mutex                   // guards everything below
+	remoteExportPushed    map[string]map[string]string // phone -> catalog name -> SHA-256 pushed
+	remoteExportRunning   bool
+	remoteExportLastRun   time.Time
+	remoteExportLastError string
+	remoteExportFiles     int // pushed since startup
+)
+
+func (rc *RemoteExportConfig) method() string {
+	if rc.Method == "" {
+		return "rsync"
+	}
+	return rc.Method
+}
+
+func (rc *RemoteExportConfig) interval() time.Duration {
+	if rc.IntervalMin <= 0 {
+		return defaultRemoteExportInterval
+	}
+	return time.Duration(rc.IntervalMin) * time.Minute
+}
+
+// host and dir split the target into its user@host and remote directory
+func (rc *RemoteExportConfig) host() string {
+	host, _, _ := strings.Cut(rc.Target, ":")
+	return host
+}
+
+func (rc *RemoteExportConfig) dir() string {
+	_, dir, _ := strings.Cut(rc.Target, ":")
+	return strings.TrimSuffix(dir, "/")
+}
+
+// sshOptions are the options both ssh and sftp take, except the port flag
+func (rc *RemoteExportConfig) sshOptions() []string {
+	opts := []string{"-o", "BatchMode=yes"}
+	if rc.SSHKey != "" {
+		opts = append(opts, "-i", rc.SSHKey)
+	}
+	return opts
+}
+
+func (rc *RemoteExportConfig) phones(baseDir string) []string {
+	if len(rc.Phones) == 0 {
+		return libraryPhones(baseDir)
+	}
+	var phones []string
+	for _, phone := range rc.Phones {
+		if info, err := os.Stat(filepath.Join(baseDir, phone)); err == nil && info.IsDir() {
+			phones = append(phones, phone)
+		}
+	}
+	return phones
+}
+
+// checkRemoteExport validates the remote export config and loads what was pushed before
+func checkRemoteExport(config *Config) error {
+	rc := config.RemoteExport
+	if rc == nil {
+		return nil
+	}
+	if host, dir, ok := strings.Cut(rc.Target, ":"); !ok || host == "" || dir == "" || strings.Contains(host, "/") {
+		return fmt.Errorf("target must be user@host:/path, got %q", rc.Target)
+	}
+	switch rc.method() {
+	case "rsync":
+		if _, err := tools.LookPath("rsync"); err != nil {
+			return err
+		}
+	case "sftp":
+		if _, err := tools.LookPath("sftp"); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("method must be rsync or sftp, got %q", rc.Method)
+	}
+	if rc.SSHKey != "" {
+		if _, err := os.Stat(rc.SSHKey); err != nil {
+			return fmt.Errorf("ssh_key: %w", err)
+		}
+	}
+	baseDir := config.ReceiveDir
+	if baseDir == "" {
+		baseDir = "received"
+	}
+	pushed := make(map[string]map[string]string)
+	b, err := os.ReadFile(filepath.Join(baseDir, remoteExportStateFileName))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err == nil {
+		if err := json.Unmarshal(b, &pushed); err != nil {
+			return fmt.Errorf("%s: %w", remoteExportStateFileName, err)
+		}
+	}
+	remoteExportMutex.Lock()
+	remoteExportPushed = pushed
+	remoteExportMutex.Unlock()
+	return nil
+}
+
+// remoteExportPending lists a phone's originals whose current content wasn't pushed yet
+func remoteExportPending(baseDir, phone string) []CatalogEntry {
+	entries := openCatalog(filepath.Join(baseDir, phone)).AllEntries()
+	remoteExportMutex.Lock()
+	defer remoteExportMutex.Unlock()
+	var pending []CatalogEntry
+	for _, e := range entries {
+		if e.SHA256 != "" && e.Damaged == "" && !e.Archived && !strings.EqualFold(remoteExportPushed[phone][e.Name], e.SHA256) {
+			pending = append(pending, e)
+		}
+	}
+	sort.Slice(pending, func(i, j int) bool { return pending[i].Name < pending[j].Name })
+	return pending
+}
+
+// pushRsync sends files of a phone with one rsync run per folder they are in: the phone
+// directory, and the phone's directory in the storage pools holding any. rsync skips what
+// the remote has already and keeps partial files, so a retry after a broken connection
+// resumes.
+func pushRsync(rc *RemoteExportConfig, phoneDir, phone string, files []CatalogEntry) error {
+	byPool := make(map[string][]CatalogEntry)
+	for _, e := range files {
+		byPool[e.Pool] = append(byPool[e.Pool], e)
+	}
+	for pool, group := range byPool {
+		root := phoneDir
+		if pool != "" {
+			dir, ok := poolDir(pool)
+			if !ok {
+				return fmt.Errorf("storage pool %s isn't configured", pool)
+			}
+			root = filepath.Join(dir, phone)
+		}
+		if err := rsyncFiles(rc, root, phone, group); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rsyncFiles sends files under root to the phone's folder on the remote
+func rsyncFiles(rc *RemoteExportConfig, root, phone string, files []CatalogEntry) error {
+	list, err := os.CreateTemp("", "remote-export-*.txt")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(list.Name())
+	for _, e := range files {
+		fmt.Fprintln(list, e.Name)
+	}
+	if err := list.Close(); err != nil {
+		return err
+	}
+
+	ssh := "ssh " + strings.Join(rc.sshOptions(), " ")
+	if rc.Port > 0 {
+		ssh += " -p " + strconv.Itoa(rc.Port)
+	}
+	args := []string{"-a", "--partial", "--protect-args", "--files-from=" + list.Name(), "-e", ssh}
+	if rc.BwLimitKBps > 0 {
+		args = append(args, "--bwlimit="+strconv.Itoa(rc.BwLimitKBps))
+	}
+	args = append(args, root+string(filepath.Separator), rc.host()+":"+rc.dir()+"/"+phone+"/")
+	if output, err := runTool(context.Background(), remoteExportTimeout, "rsync", args...); err != nil {
+		return fmt.Errorf("rsync: %v: %s", err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// pushSFTP sends files of a phone with an sftp batch: the folders are created as needed
+// (a leading - ignores that they exist), then every file is put with its times
+func pushSFTP(rc *RemoteExportConfig, phoneDir, phone string, files []CatalogEntry) error {
+	batch, err := os.CreateTemp("", "remote-export-*.sftp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(batch.Name())
+	quote := func(s string) string { return `"` + s + `"` }
+	made := make(map[string]bool)
+	for _, e := range files {
+		if strings.ContainsAny(e.Name, "\"\n") {
+			log.Printf("Remote export: skipping %s/%s, sftp can't quote its name", phone, e.Name)
+			continue
+		}
+		dest := rc.dir() + "/" + phone + "/" + e.Name
+		var dirs []string
+		for d := path.Dir(dest); d != rc.dir() && d != "/" && d != "." && !made[d]; d = path.Dir(d) {
+			dirs = append(dirs, d)
+			made[d] = true
+		}
+		for i := len(dirs) - 1; i >= 0; i-- {
+			fmt.Fprintf(batch, "-mkdir %s\n", quote(dirs[i]))
+		}
+		fmt.Fprintf(batch, "put -p %s %s\n", quote(entryPath(phoneDir, &e)), quote(dest))
+	}
+	if err := batch.Close(); err != nil {
+		return err
+	}
+
+	args := append([]string{"-b", batch.Name()}, rc.sshOptions()...)
+	if rc.Port > 0 {
+		args = append(args, "-P", strconv.Itoa(rc.Port))
+	}
+	args = append(args, rc.host())
+	if output, err := runTool(context.Background(), remoteExportTimeout, "sftp", args...); err != nil {
+		return fmt.Errorf("sftp: %v: %s", err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// runRemoteExport pushes every phone's new originals. A phone's files count as pushed
+// only once its whole run succeeded; a failed one is retried in full at the next run.
+func runRemoteExport(config *Config) error {
+	remoteExportMutex.Lock()
+	if remoteExportRunning {
+		remoteExportMutex.Unlock()
+		return fmt.Errorf("a push is running already")
+	}
+	remoteExportRunning = true
+	remoteExportMutex.Unlock()
+
+	rc := config.RemoteExport
+	baseDir := config.ReceiveDir
+	if baseDir == "" {
+		baseDir = "received"
+	}
+	var errs []string
+	for _, phone := range rc.phones(baseDir) {
+		if draining() {
+			break
+		}
+		pending := remoteExportPending(baseDir, phone)
+		if len(pending) == 0 {
+			continue
+		}
+		started := time.Now()
+		phoneDir := filepath.Join(baseDir, phone)
+		var err error
+		if rc.method() == "sftp" {
+			err = pushSFTP(rc, phoneDir, phone, pending)
+		} else {
+			err = pushRsync(rc, phoneDir, phone, pending)
+		}
+		if err != nil {
+			log.Printf("Remote export: %s: %v", phone, err)
+			errs = append(errs, fmt.Sprintf("%s: %v", phone, err))
+			continue
+		}
+		log.Printf("Remote export: pushed %d file(s) of %s to %s in %v", len(pending), phone, rc.host(),
+			time.Since(started).Round(time.Second))
+
+		remoteExportMutex.Lock()
+		if remoteExportPushed[phone] == nil {
+			remoteExportPushed[phone] = make(map[string]string)
+		}
+		for _, e := range pending {
+			remoteExportPushed[phone][e.Name] = strings.ToLower(e.SHA256)
+		}
+		remoteExportFiles += len(pending)
+		b, err := json.Marshal(remoteExportPushed)
+		if err == nil {
+			err = writeFileAtomic(filepath.Join(baseDir, remoteExportStateFileName), b)
+		}
+		remoteExportMutex.Unlock()
+		if err != nil {
+			log.Printf("Error saving %s: %v", remoteExportStateFileName, err)
+		}
+	}
+
+	remoteExportMutex.Lock()
+	defer remoteExportMutex.Unlock()
+	remoteExportRunning = false
+	remoteExportLastRun = clock.Now()
+	remoteExportLastError = strings.Join(errs, "; ")
+	if len(errs) > 0 {
+		return fmt.Errorf("%s", remoteExportLastError)
+	}
+	return nil
+}
+
+// startRemoteExport pushes at startup and then every interval
+func startRemoteExport(config *Config) {
+	rc := config.RemoteExport
+	if rc == nil {
+		return
+	}
+	log.Printf("Remote export enabled: %s to %s every %v", rc.method(), rc.Target, rc.interval())
+
+	runRemoteExport(config)
+	ticker := clock.NewTicker(rc.interval())
+	defer ticker.Stop()
+	for range ticker.C() {
+		runRemoteExport(config)
+	}
+}
+
+// registerRemoteExportRoutes adds the remote export's status and a way to push now
+func registerRemoteExportRoutes(router *mux.Router, config *Config) {
+	router.HandleFunc("/api/remote-export", func(w http.ResponseWriter, r *http.Request) {
+		rc := config.RemoteExport
+		if rc == nil {
+			writeJSON(w, map[string]interface{}{"success": true, "enabled": false})
+			return
+		}
+		baseDir := config.ReceiveDir
+		if baseDir == "" {
+			baseDir = "received"
+		}
+		pending := make(map[string]int)
+		for _, phone := range rc.phones(baseDir) {
+			pending[phone] = len(remoteExportPending(baseDir, phone))
+		}
+		remoteExportMutex.Lock()
+		defer remoteExportMutex.Unlock()
+		status := map[string]interface{}{"success": true, "enabled": true, "target": rc.Target, "method": rc.method(),
+			"running": remoteExportRunning, "pushed": remoteExportFiles, "pending": pending}
+		if !remoteExportLastRun.IsZero() {
+			status["lastRun"] = remoteExportLastRun
+		}
+		if remoteExportLastError != "" {
+			status["lastError"] = remoteExportLastError
+		}
+		writeJSON(w, status)
+	}).Methods("GET")
+
+	// Starts a push now; it runs in the background, poll the status for the result
+	router.HandleFunc("/api/remote-export/run", func(w http.ResponseWriter, r *http.Request) {
+		if config.RemoteExport == nil {
+			writeJSON(w, map[string]interface{}{"success": false, "error": "No remote export configured"})
+			return
+		}
+		remoteExportMutex.Lock()
+		running := remoteExportRunning
+		remoteExportMutex.Unlock()
+		if running {
+			writeJSON(w, map[string]interface{}{"success": false, "error": "A push is running already"})
+			return
+		}
+		go runRemoteExport(config)
+		countFeature("remote_export_run")
+		writeJSON(w, map[string]interface{}{"success": true})
+	}).Methods("POST")
+}
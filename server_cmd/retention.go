@@ -0,0 +1,272 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// defaultRetentionInterval is how often the retention policies run unless configured
+const defaultRetentionInterval = 24 * time.Hour
+
+// RetentionConfig removes media by policy on a schedule. Removed files go to the phone's
+// trash, so they can still be restored for trashRetention. Favorites and files in shared
+// albums are always kept.
+type RetentionConfig struct {
+	DryRun        bool              `json:"dry_run"`        // only log what would be removed
+	IntervalHours int               `json:"interval_hours"` // between runs, default 24
+	Policies      []RetentionPolicy `json:"policies"`       // the first policy matching a file decides
+}
+
+// RetentionPolicy keeps the files it matches for KeepDays after the server received or
+// made them. All set match fields must match; a policy with keep_days 0 keeps its files
+// forever, e.g. "originals" after a policy for screenshots.
+type RetentionPolicy struct {
+	Name     string `json:"name"`              // shown in the log, defaults to a description
+	Kind     string `json:"kind,omitempty"`    // screenshots, created (slideshows, trims), originals, photos or videos; empty matches all
+	Phone    string `json:"phone,omitempty"`   // phone directory, empty matches every phone
+	Pattern  string `json:"pattern,omitempty"` // filepath.Match glob on the file name
+	KeepDays int    `json:"keep_days"`
+}
+
+var retentionKinds = map[string]bool{"": true, "screenshots": true, "created": true, "originals": true, "photos": true, "videos": true}
+
+// String names the policy in the log
+func (p RetentionPolicy) String() string {
+	if p.Name != "" {
+		return p.Name
+	}
+	s := p.Kind
+	if s == "" {
+		s = "all"
+	}
+	if p.Phone != "" {
+		s += " of " + p.Phone
+	}
+	if p.Pattern != "" {
+		s += " matching " + p.Pattern
+	}
+	if p.KeepDays <= 0 {
+		return s + ", kept forever"
+	}
+	return fmt.Sprintf("%s, kept %d days", s, p.KeepDays)
+}
+
+// matches reports whether the policy applies to a cataloged file of a phone
+func (p RetentionPolicy) matches(phone string, e CatalogEntry) bool {
+	base := filepath.Base(filepath.FromSlash(e.Name))
+	if p.Phone != "" && p.Phone != phone {
+		return false
+	}
+	if p.Pattern != "" {
+		if ok, _ := filepath.Match(p.Pattern, base); !ok {
+			return false
+		}
+	}
+	switch p.Kind {
+	case "screenshots":
+		return isScreenshot(base)
+	case "created":
+		return e.Created
+	case "originals":
+		return !e.Created
+	case "photos":
+		return hasExtension(base, photoExtensions)
+	case "videos":
+		return hasExtension(base, videoExtensions)
+	}
+	return true
+}
+
+// isScreenshot recognizes screenshots by the names Android and iOS give them, e.g.
+// Screenshot_20240101-120000.png or "Screen Shot 2024-01-01 at 12.00.00.png"
+func isScreenshot(name string) bool {
+	name = strings.ToLower(name)
+	name = strings.NewReplacer(" ", "", "_", "", "-", "").Replace(name)
+	return strings.HasPrefix(name, "screenshot") || strings.HasPrefix(name, "scr2") // SCR_2024... on some Samsungs
+}
+
+// RetentionRemoval is a file removed (or, in a dry run, due for removal) by a policy
+type RetentionRemoval struct {
+	Phone  string    `json:"phone"`
+	Name   string    `json:"name"` // catalog name inside the phone directory
+	Bytes  int64     `json:"bytes"`
+	Added  time.Time `json:"added"`
+	Policy string    `json:"policy"`
+}
+
+// RetentionReport describes one run of the policies
+type RetentionReport struct {
+	Started time.Time          `json:"started"`
+	DryRun  bool               `json:"dryRun"`
+	Removed []RetentionRemoval `json:"removed"`
+	Bytes   int64              `json:"bytes"`
+	Errors  []string           `json:"errors,omitempty"`
+}
+
+var (
+	retentionRunMutex   sync.Mutex // one run at a time
+	lastRetentionReport *RetentionReport
+)
+
+// checkRetention validates the retention policies of the config
+func checkRetention(config *Config) error {
+	if config.Retention == nil {
+		return nil
+	}
+	for i, p := range config.Retention.Policies {
+		if !retentionKinds[p.Kind] {
+			return fmt.Errorf("policy %d: unknown kind %q, use screenshots, created, originals, photos or videos", i+1, p.Kind)
+		}
+		if _, err := filepath.Match(p.Pattern, ""); err != nil {
+			return fmt.Errorf("policy %d: invalid pattern %q: %v", i+1, p.Pattern, err)
+		}
+		if p.KeepDays < 0 {
+			return fmt.Errorf("policy %d: keep_days must not be negative", i+1)
+		}
+	}
+	return nil
+}
+
+// runRetention applies the policies to every phone of the library. A dry run, or a config
+// with dry_run set, only reports what would be removed.
+func runRetention(config *Config, dryRun bool) *RetentionReport {
+	retentionRunMutex.Lock()
+	defer retentionRunMutex.Unlock()
+
+	rc := config.Retention
+	report := &RetentionReport{Started: clock.Now(), DryRun: dryRun || rc.DryRun, Removed: []RetentionRemoval{}}
+	baseDir := config.ReceiveDir
+	if baseDir == "" {
+		baseDir = "received"
+	}
+
+	// Files in shared albums are kept whatever their policy says
+	inAlbums := make(map[string]bool)
+	albumsMutex.Lock()
+	if albums, err := loadAlbums(baseDir); err == nil {
+		for _, a := range albums {
+			for _, it := range a.Items {
+				inAlbums[it.Phone+"/"+filepath.Base(filepath.FromSlash(it.Name))] = true
+			}
+		}
+	}
+	albumsMutex.Unlock()
+
+	for _, phone := range libraryPhones(baseDir) {
+		phoneDir := filepath.Join(baseDir, phone)
+		catalog := openCatalog(phoneDir)
+		var removed []string
+		for _, e := range catalog.AllEntries() {
+			if e.Favorite || inAlbums[phone+"/"+filepath.Base(filepath.FromSlash(e.Name))] {
+				continue
+			}
+			var policy *RetentionPolicy
+			for i := range rc.Policies {
+				if rc.Policies[i].matches(phone, e) {
+					policy = &rc.Policies[i]
+					break
+				}
+			}
+			if policy == nil || policy.KeepDays <= 0 || clock.Since(e.Added) < time.Duration(policy.KeepDays)*24*time.Hour {
+				continue
+			}
+
+			r := RetentionRemoval{Phone: phone, Name: e.Name, Bytes: e.Size, Added: e.Added, Policy: policy.String()}
+			if report.DryRun {
+				log.Printf("Retention (dry run): would remove %s/%s (%s, received %s) by policy %q", phone, e.Name, formatBytes(e.Size), e.Added.Format("2006-01-02"), r.Policy)
+			} else {
+				path := entryPath(phoneDir, &e)
+				var err error
+				if e.Archived {
+					err = trashArchived(phoneDir, e.Name) // trashed in the archive, not retrieved
+				} else {
+					err = catalog.MoveToTrash(path)
+				}
+				if err != nil {
+					report.Errors = append(report.Errors, fmt.Sprintf("%s/%s: %v", phone, e.Name, err))
+					continue
+				}
+				thumbPath := filepath.Join(phoneDir, "thumbnails", thumbnailName(filepath.Base(path)))
+				if err := os.Remove(thumbPath); err != nil && !os.IsNotExist(err) {
+					log.Printf("Warning: Failed to delete thumbnail %s: %v", thumbPath, err)
+				}
+				log.Printf("Retention: moved %s/%s (%s, received %s) to the trash by policy %q", phone, e.Name, formatBytes(e.Size), e.Added.Format("2006-01-02"), r.Policy)
+				removed = append(removed, path)
+			}
+			report.Removed = append(report.Removed, r)
+			report.Bytes += r.Bytes
+		}
+		notifyMediaChange(phoneDir, mediaChangeDeleted, removed...)
+	}
+
+	verb := "removed"
+	if report.DryRun {
+		verb = "would remove"
+	}
+	log.Printf("Retention run %s %d file(s), %s, %d error(s)", verb, len(report.Removed), formatBytes(report.Bytes), len(report.Errors))
+	lastRetentionReport = report
+	return report
+}
+
+// startRetention runs the retention policies at startup and then on their schedule
+func startRetention(config *Config) {
+	rc := config.Retention
+	if rc == nil || len(rc.Policies) == 0 {
+		return
+	}
+	interval := defaultRetentionInterval
+	if rc.IntervalHours > 0 {
+		interval = time.Duration(rc.IntervalHours) * time.Hour
+	}
+	mode := ""
+	if rc.DryRun {
+		mode = ", dry run"
+	}
+	log.Printf("Retention policies enabled (%d, every %v%s)", len(rc.Policies), interval, mode)
+
+	runRetention(config, false)
+	ticker := clock.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C() {
+		runRetention(config, false)
+	}
+}
+
+// registerRetentionRoutes adds the retention policies' status and a way to run them now
+func registerRetentionRoutes(router *mux.Router, config *Config) {
+	router.HandleFunc("/api/retention", func(w http.ResponseWriter, r *http.Request) {
+		rc := config.Retention
+		if rc == nil {
+			writeJSON(w, map[string]interface{}{"success": true, "enabled": false})
+			return
+		}
+		policies := make([]string, len(rc.Policies))
+		for i, p := range rc.Policies {
+			policies[i] = p.String()
+		}
+		retentionRunMutex.Lock()
+		last := lastRetentionReport
+		retentionRunMutex.Unlock()
+		writeJSON(w, map[string]interface{}{"success": true, "enabled": len(rc.Policies) > 0, "dryRun": rc.DryRun, "policies": policies, "last": last})
+	}).Methods("GET")
+
+	// Runs the policies now; ?dry_run=1 only reports what they would remove
+	router.HandleFunc("/api/retention/run", func(w http.ResponseWriter, r *http.Request) {
+		if config.Retention == nil || len(config.Retention.Policies) == 0 {
+			writeJSON(w, map[string]interface{}{"success": false, "error": "No retention policies configured"})
+			return
+		}
+		dryRun := r.URL.Query().Get("dry_run") == "1" || r.URL.Query().Get("dry_run") == "true"
+		report := runRetention(config, dryRun)
+		countFeature("retention_run")
+		writeJSON(w, map[string]interface{}{"success": len(report.Errors) == 0, "report": report})
+	}).Methods("POST")
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRetentionKeepsFilesForKeepDays(t *testing.T) {
+	c := useFakeClock(t, time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC))
+	baseDir := t.TempDir()
+	phoneDir := filepath.Join(baseDir, "Pixel")
+	if err := os.MkdirAll(phoneDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	catalog := openCatalog(phoneDir)
+	for _, name := range []string{"Screenshot_20240601-120000.png", "IMG_0001.jpg", "Screenshot_20240601-120500.png"} {
+		path := filepath.Join(phoneDir, name)
+		if err := os.WriteFile(path, []byte(name), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		catalog.Record(path, "")
+	}
+	catalog.SetFavorite([]string{filepath.Join(phoneDir, "Screenshot_20240601-120500.png")}, true)
+
+	config := &Config{ReceiveDir: baseDir, Retention: &RetentionConfig{Policies: []RetentionPolicy{
+		{Kind: "screenshots", KeepDays: 30},
+		{Kind: "originals"},
+	}}}
+	if err := checkRetention(config); err != nil {
+		t.Fatal(err)
+	}
+
+	c.Advance(29 * 24 * time.Hour)
+	if report := runRetention(config, false); len(report.Removed) != 0 {
+		t.Fatalf("removed %+v before keep_days passed", report.Removed)
+	}
+
+	c.Advance(2 * 24 * time.Hour)
+	report := runRetention(config, true)
+	if len(report.Removed) != 1 || !report.DryRun {
+		t.Fatalf("dry run reports %+v, want the one screenshot that isn't a favorite", report.Removed)
+	}
+	if _, ok := catalog.Entry(filepath.Join(phoneDir, "Screenshot_20240601-120000.png")); !ok {
+		t.Fatal("dry run removed the screenshot")
+	}
+
+	report = runRetention(config, false)
+	if len(report.Removed) != 1 || report.Removed[0].Name != "Screenshot_20240601-120000.png" || len(report.Errors) != 0 {
+		t.Fatalf("run removed %+v, errors %v", report.Removed, report.Errors)
+	}
+	if _, ok := catalog.Entry(filepath.Join(phoneDir, "Screenshot_20240601-120000.png")); ok {
+		t.Error("screenshot still in the library")
+	}
+	for _, name := range []string{"IMG_0001.jpg", "Screenshot_20240601-120500.png"} {
+		if _, ok := catalog.Entry(filepath.Join(phoneDir, name)); !ok {
+			t.Errorf("%s removed", name)
+		}
+	}
+
+	// The trash keeps it for trashRetention, then lets go of it for good
+	if trash := catalog.TrashEntries(); len(trash) != 1 {
+		t.Fatalf("%d file(s) in the trash, want 1", len(trash))
+	}
+	c.Advance(trashRetention - time.Hour)
+	if trash := catalog.TrashEntries(); len(trash) != 1 {
+		t.Fatalf("trash emptied %v early", time.Hour)
+	}
+	c.Advance(2 * time.Hour)
+	if trash := catalog.TrashEntries(); len(trash) != 0 {
+		t.Errorf("%d file(s) still in the trash after trashRetention", len(trash))
+	}
+}
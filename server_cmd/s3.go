@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// s3Client uploads objects to an S3-compatible bucket (AWS, MinIO, Backblaze B2, ...)
+// with Signature Version 4. Only what the backup needs is implemented.
+type s3Client struct {
+	endpoint  *url.URL
+	region    string
+	bucket    string
+	accessKey string
+	secretKey string
+	pathStyle bool // endpoint/bucket/key instead of bucket.endpoint/key, for MinIO and most self-hosted stores
+	http      *http.Client
+}
+
+// s3EscapePath encodes an object key for the request path the way SigV4 expects: every
+// byte but the unreserved characters and the slashes between segments
+func s3EscapePath(key string) string {
+	var b strings.Builder
+	for i := 0; i < len(key); i++ {
+		c := key[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || strings.IndexByte("-._~/", c) >= 0 {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// objectURL returns the URL of an object of the bucket
+func (c *s3Client) objectURL(key string) *url.URL {
+	u := *c.endpoint
+	p := "/" + strings.TrimPrefix(key, "/")
+	if c.pathStyle {
+		p = "/" + c.bucket + p
+	} else {
+		u.Host = c.bucket + "." + u.Host
+	}
+	u.Path = strings.TrimSuffix(c.endpoint.Path, "/") + p
+	u.RawPath = strings.TrimSuffix(c.endpoint.EscapedPath(), "/") + s3EscapePath(p)
+	return &u
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// sign adds the SigV4 Authorization header to req. The host, the x-amz-* headers and
+// Content-Type are signed; payloadHash is the hex SHA-256 of the body.
+func (c *s3Client) sign(req *http.Request, payloadHash string, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	day := amzDate[:8]
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		lower := strings.ToLower(name)
+		if strings.HasPrefix(lower, "x-amz-") || lower == "content-type" {
+			headers[lower] = strings.TrimSpace(strings.Join(values, ","))
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.Query().Encode(),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	scope := day + "/" + c.region + "/s3/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := hmacSHA256([]byte("AWS4"+c.secretKey), day)
+	key = hmacSHA256(key, c.region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		c.accessKey, scope, signedHeaders, signature))
+}
+
+// put uploads size bytes of body as key. The store checks the body against sha256Hex, so
+// a file that changed or rotted since it was hashed is refused rather than backed up.
+func (c *s3Client) put(ctx context.Context, key string, body io.Reader, size int64, sha256Hex string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, c.objectURL(key).String(), body)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+	if size == 0 {
+		req.Body = http.NoBody
+	}
+	contentType := mime.TypeByExtension(path.Ext(key))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	req.Header.Set("Content-Type", contentType)
+	c.sign(req, strings.ToLower(sha256Hex), time.Now()) // the store checks it against its own clock
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return fmt.Errorf("PUT %s: %s: %s", key, resp.Status, s3ErrorMessage(msg))
+	}
+	io.Copy(io.Discard, resp.Body)
+	return nil
+}
+
+// putFile uploads the file at path, whose content hashes to sha256Hex
+func (c *s3Client) putFile(ctx context.Context, key, path, sha256Hex string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	return c.put(ctx, key, f, info.Size(), sha256Hex)
+}
+
+// putBytes uploads data as key
+func (c *s3Client) putBytes(ctx context.Context, key string, data []byte) error {
+	sum := sha256.Sum256(data)
+	return c.put(ctx, key, bytes.NewReader(data), int64(len(data)), hex.EncodeToString(sum[:]))
+}
+
+// s3ErrorMessage picks the code and message out of an S3 XML error body
+func s3ErrorMessage(body []byte) string {
+	s := string(body)
+	field := func(tag string) string {
+		start := strings.Index(s, "<"+tag+">")
+		end := strings.Index(s, "</"+tag+">")
+		if start < 0 || end < start {
+			return ""
+		}
+		return s[start+len(tag)+2 : end]
+	}
+	if code := field("Code"); code != "" {
+		if msg := field("Message"); msg != "" {
+			return code + ": " + msg
+		}
+		return code
+	}
+	return strings.TrimSpace(s)
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/image/draw"
+)
+
+// thumbnailScalers are the selectable scaling kernels for photo thumbnails (config "thumbnail_scaler").
+// catmullrom is the highest quality and the slowest; box averages source pixels per output
+// pixel, which is much cheaper and looks just as good when shrinking to grid size.
+var thumbnailScalers = map[string]draw.Scaler{
+	"catmullrom": draw.CatmullRom,
+	"bilinear":   draw.BiLinear,
+	"approx":     draw.ApproxBiLinear,
+	"nearest":    draw.NearestNeighbor,
+	"box":        boxScaler{},
+}
+
+// thumbnailScaler is the scaler used by generateThumbnails, set from config at startup
+var thumbnailScaler draw.Scaler = draw.CatmullRom
+
+// setThumbnailScaler selects the thumbnail scaler by name; an empty name keeps the default
+func setThumbnailScaler(name string) error {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if name == "" {
+		return nil
+	}
+	s, ok := thumbnailScalers[name]
+	if !ok {
+		return fmt.Errorf("unknown thumbnail scaler %q", name)
+	}
+	thumbnailScaler = s
+	return nil
+}
+
+// boxScaler is a fast area-averaging downscaler. It has direct paths for the decoder
+// output types we see in practice (YCbCr from JPEG, RGBA/NRGBA from PNG) writing into an
+// *image.RGBA, and falls back to ApproxBiLinear for anything else or for upscaling.
+type boxScaler struct{}
+
+func (boxScaler) Scale(dst draw.Image, dr image.Rectangle, src image.Image, sr image.Rectangle, op draw.Op, opts *draw.Options) {
+	d, ok := dst.(*image.RGBA)
+	if !ok || opts != nil || dr.Dx() > sr.Dx() || dr.Dy() > sr.Dy() || dr.Empty() || sr.Empty() {
+		draw.ApproxBiLinear.Scale(dst, dr, src, sr, op, opts)
+		return
+	}
+
+	switch s := src.(type) {
+	case *image.YCbCr:
+		boxScaleYCbCr(d, dr, s, sr)
+	case *image.RGBA:
+		boxScaleRGBA(d, dr, s.Pix, s.Stride, s.Rect, sr, true)
+	case *image.NRGBA:
+		boxScaleRGBA(d, dr, s.Pix, s.Stride, s.Rect, sr, false)
+	default:
+		draw.ApproxBiLinear.Scale(dst, dr, src, sr, op, opts)
+	}
+}
+
+// boxSpan returns the source range [lo, hi) covered by output index i of n over a source span of size
+func boxSpan(i, n, size int) (int, int) {
+	lo := i * size / n
+	hi := (i + 1) * size / n
+	if hi <= lo {
+		hi = lo + 1
+	}
+	return lo, hi
+}
+
+func boxScaleYCbCr(d *image.RGBA, dr image.Rectangle, s *image.YCbCr, sr image.Rectangle) {
+	dw, dh := dr.Dx(), dr.Dy()
+	sw, sh := sr.Dx(), sr.Dy()
+	for dy := 0; dy < dh; dy++ {
+		y0, y1 := boxSpan(dy, dh, sh)
+		for dx := 0; dx < dw; dx++ {
+			x0, x1 := boxSpan(dx, dw, sw)
+			var ys, cbs, crs, n uint32
+			for y := y0; y < y1; y++ {
+				sy := sr.Min.Y + y
+				for x := x0; x < x1; x++ {
+					sx := sr.Min.X + x
+					ys += uint32(s.Y[s.YOffset(sx, sy)])
+					ci := s.COffset(sx, sy)
+					cbs += uint32(s.Cb[ci])
+					crs += uint32(s.Cr[ci])
+					n++
+				}
+			}
+			r, g, b := color.YCbCrToRGB(uint8(ys/n), uint8(cbs/n), uint8(crs/n))
+			i := d.PixOffset(dr.Min.X+dx, dr.Min.Y+dy)
+			d.Pix[i+0] = r
+			d.Pix[i+1] = g
+			d.Pix[i+2] = b
+			d.Pix[i+3] = 0xff
+		}
+	}
+}
+
+// boxScaleRGBA averages 4-byte RGBA/NRGBA pixels. premultiplied tells whether the source
+// is already alpha-premultiplied (RGBA) or needs converting (NRGBA).
+func boxScaleRGBA(d *image.RGBA, dr image.Rectangle, pix []uint8, stride int, rect, sr image.Rectangle, premultiplied bool) {
+	dw, dh := dr.Dx(), dr.Dy()
+	sw, sh := sr.Dx(), sr.Dy()
+	for dy := 0; dy < dh; dy++ {
+		y0, y1 := boxSpan(dy, dh, sh)
+		for dx := 0; dx < dw; dx++ {
+			x0, x1 := boxSpan(dx, dw, sw)
+			var rs, gs, bs, as, n uint32
+			for y := y0; y < y1; y++ {
+				row := (sr.Min.Y+y-rect.Min.Y)*stride + (sr.Min.X-rect.Min.X)*4
+				for x := x0; x < x1; x++ {
+					p := pix[row+x*4 : row+x*4+4 : row+x*4+4]
+					a := uint32(p[3])
+					if premultiplied {
+						rs += uint32(p[0])
+						gs += uint32(p[1])
+						bs += uint32(p[2])
+					} else {
+						rs += uint32(p[0]) * a / 0xff
+						gs += uint32(p[1]) * a / 0xff
+						bs += uint32(p[2]) * a / 0xff
+					}
+					as += a
+					n++
+				}
+			}
+			i := d.PixOffset(dr.Min.X+dx, dr.Min.Y+dy)
+			d.Pix[i+0] = uint8(rs / n)
+			d.Pix[i+1] = uint8(gs / n)
+			d.Pix[i+2] = uint8(bs / n)
+			d.Pix[i+3] = uint8(as / n)
+		}
+	}
+}
+
+// benchmarkScalers decodes the given image and times every thumbnail scaler on it,
+// so operators can pick the fastest acceptable one for their hardware (-bench-scaler flag).
+func benchmarkScalers(path string, rounds int) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("open image: %w", err)
+	}
+	img, format, err := image.Decode(f)
+	f.Close()
+	if err != nil {
+		return fmt.Errorf("decode image: %w", err)
+	}
+	if rounds <= 0 {
+		rounds = 10
+	}
+
+	b := img.Bounds()
+	newW, newH := thumbnailSize(b.Dx(), b.Dy())
+	log.Printf("Benchmarking thumbnail scalers on %s (%s %dx%d -> %dx%d, %d rounds)",
+		path, format, b.Dx(), b.Dy(), newW, newH, rounds)
+
+	names := make([]string, 0, len(thumbnailScalers))
+	for name := range thumbnailScalers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		s := thumbnailScalers[name]
+		start := time.Now()
+		for i := 0; i < rounds; i++ {
+			thumbImg := image.NewRGBA(image.Rect(0, 0, newW, newH))
+			s.Scale(thumbImg, thumbImg.Bounds(), img, b, draw.Over, nil)
+		}
+		perOp := time.Since(start) / time.Duration(rounds)
+		fmt.Printf("%-12s %12v/op\n", name, perOp)
+	}
+	return nil
+}
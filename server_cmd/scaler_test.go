@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"testing"
+
+	"golang.org/x/image/draw"
+)
+
+// scaleBox shrinks src into a new w x h RGBA image with the box scaler
+func scaleBox(src image.Image, w, h int) *image.RGBA {
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	boxScaler{}.Scale(dst, dst.Bounds(), src, src.Bounds(), draw.Src, nil)
+	return dst
+}
+
+func TestBoxScalerYCbCr(t *testing.T) {
+	// Left half black, right half white, neutral chroma
+	src := image.NewYCbCr(image.Rect(0, 0, 4, 2), image.YCbCrSubsampleRatio420)
+	for y := 0; y < 2; y++ {
+		for x := 0; x < 4; x++ {
+			if x >= 2 {
+				src.Y[src.YOffset(x, y)] = 0xff
+			}
+		}
+	}
+	for i := range src.Cb {
+		src.Cb[i], src.Cr[i] = 0x80, 0x80
+	}
+
+	halves := scaleBox(src, 2, 1)
+	for x, luma := range []uint8{0x00, 0xff} {
+		r, g, b := color.YCbCrToRGB(luma, 0x80, 0x80)
+		if got, want := halves.RGBAAt(x, 0), (color.RGBA{r, g, b, 0xff}); got != want {
+			t.Errorf("pixel %d = %v, want %v", x, got, want)
+		}
+	}
+
+	r, g, b := color.YCbCrToRGB(0x7f, 0x80, 0x80)
+	if got, want := scaleBox(src, 1, 1).RGBAAt(0, 0), (color.RGBA{r, g, b, 0xff}); got != want {
+		t.Errorf("average = %v, want %v", got, want)
+	}
+}
+
+func TestBoxScalerRGBA(t *testing.T) {
+	src := image.NewRGBA(image.Rect(0, 0, 2, 1))
+	src.SetRGBA(0, 0, color.RGBA{200, 0, 0, 0xff})
+	src.SetRGBA(1, 0, color.RGBA{0, 0, 0, 0})
+	if got, want := scaleBox(src, 1, 1).RGBAAt(0, 0), (color.RGBA{100, 0, 0, 127}); got != want {
+		t.Errorf("average = %v, want %v", got, want)
+	}
+}
+
+func TestBoxScalerNRGBAPremultipliesAlpha(t *testing.T) {
+	// The transparent pixel's color must not bleed into the average
+	src := image.NewNRGBA(image.Rect(0, 0, 2, 1))
+	src.SetNRGBA(0, 0, color.NRGBA{200, 0, 0, 0xff})
+	src.SetNRGBA(1, 0, color.NRGBA{0, 200, 0, 0})
+	if got, want := scaleBox(src, 1, 1).RGBAAt(0, 0), (color.RGBA{100, 0, 0, 127}); got != want {
+		t.Errorf("average = %v, want %v", got, want)
+	}
+
+	half := image.NewNRGBA(image.Rect(0, 0, 1, 1))
+	half.SetNRGBA(0, 0, color.NRGBA{200, 100, 0, 0x80})
+	if got, want := scaleBox(half, 1, 1).RGBAAt(0, 0), (color.RGBA{100, 50, 0, 0x80}); got != want {
+		t.Errorf("half transparent = %v, want %v", got, want)
+	}
+}
+
+func TestBoxScalerFallsBack(t *testing.T) {
+	src := image.NewRGBA(image.Rect(0, 0, 2, 2))
+	for i := range src.Pix {
+		src.Pix[i] = uint8(i * 15)
+	}
+
+	gray := image.NewGray(image.Rect(0, 0, 2, 2))
+	copy(gray.Pix, []uint8{0, 80, 160, 240})
+
+	// Upscaling, destinations other than *image.RGBA and other sources go to ApproxBiLinear
+	tests := []struct {
+		name string
+		dst  draw.Image
+		src  image.Image
+	}{
+		{"upscale", image.NewRGBA(image.Rect(0, 0, 4, 4)), src},
+		{"nrgba dst", image.NewNRGBA(image.Rect(0, 0, 1, 1)), src},
+		{"rgba64 dst", image.NewRGBA64(image.Rect(0, 0, 1, 1)), src},
+		{"gray src", image.NewRGBA(image.Rect(0, 0, 1, 1)), gray},
+	}
+	for _, tt := range tests {
+		want := cloneImage(tt.dst)
+		draw.ApproxBiLinear.Scale(want, want.Bounds(), tt.src, tt.src.Bounds(), draw.Src, nil)
+		boxScaler{}.Scale(tt.dst, tt.dst.Bounds(), tt.src, tt.src.Bounds(), draw.Src, nil)
+		if !samePixels(tt.dst, want) {
+			t.Errorf("%s: box output differs from ApproxBiLinear", tt.name)
+		}
+	}
+}
+
+func cloneImage(img draw.Image) draw.Image {
+	switch img := img.(type) {
+	case *image.RGBA:
+		return image.NewRGBA(img.Rect)
+	case *image.NRGBA:
+		return image.NewNRGBA(img.Rect)
+	case *image.RGBA64:
+		return image.NewRGBA64(img.Rect)
+	}
+	panic("unexpected image type")
+}
+
+func samePixels(a, b draw.Image) bool {
+	switch a := a.(type) {
+	case *image.RGBA:
+		return bytes.Equal(a.Pix, b.(*image.RGBA).Pix)
+	case *image.NRGBA:
+		return bytes.Equal(a.Pix, b.(*image.NRGBA).Pix)
+	case *image.RGBA64:
+		return bytes.Equal(a.Pix, b.(*image.RGBA64).Pix)
+	}
+	return false
+}
+
+// BenchmarkThumbnailScalers shrinks a 12 megapixel JPEG-style image to thumbnail size
+func BenchmarkThumbnailScalers(b *testing.B) {
+	src := image.NewYCbCr(image.Rect(0, 0, 4032, 3024), image.YCbCrSubsampleRatio420)
+	for i := range src.Y {
+		src.Y[i] = uint8(i)
+	}
+	for i := range src.Cb {
+		src.Cb[i], src.Cr[i] = uint8(i>>3), uint8(i>>5)
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, 400, 300))
+
+	for _, name := range []string{"box", "catmullrom"} {
+		scaler := thumbnailScalers[name]
+		b.Run(name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				scaler.Scale(dst, dst.Bounds(), src, src.Bounds(), draw.Src, nil)
+			}
+		})
+	}
+}
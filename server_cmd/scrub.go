@@ -0,0 +1,456 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	// defaultScrubInterval is how often the library is re-hashed unless configured
+	defaultScrubInterval = 7 * 24 * time.Hour
+	// scrubCheckInterval is how often the scheduler looks whether a run is due
+	scrubCheckInterval = time.Hour
+	// scrubStateFileName keeps the last run in the receive directory, so restarts don't
+	// start the schedule over
+	scrubStateFileName = ".scrub.json"
+)
+
+// ScrubConfig re-hashes the stored originals on a schedule and flags the ones that no
+// longer match the catalog: bit rot, truncation, files gone missing.
+type ScrubConfig struct {
+	IntervalHours int  `json:"interval_hours"` // between runs, default 168 (weekly)
+	MaxMBps       int  `json:"max_mbps"`       // read rate limit, so syncs aren't starved; 0 for none
+	Rerequest     bool `json:"rerequest"`      // report damaged files as missing to HAVE_LIST, so the phone sends them again
+}
+
+// rerequestDamaged makes haveMedia count damaged files as missing; set at startup
+var rerequestDamaged bool
+
+// ScrubRun is the progress and outcome of one pass over the library
+type ScrubRun struct {
+	Started  time.Time `json:"started"`
+	Finished time.Time `json:"finished,omitempty"`
+	Phone    string    `json:"phone,omitempty"` // being checked
+	Total    int       `json:"total"`
+	Checked  int       `json:"checked"`
+	Bytes    int64     `json:"bytes"`
+	Damaged  int       `json:"damaged"` // newly found in this run
+	Errors   []string  `json:"errors,omitempty"`
+}
+
+// ScrubIssue is a file flagged as damaged
+type ScrubIssue struct {
+	Phone     string `json:"phone"`
+	Name      string `json:"name"` // catalog name inside the phone directory
+	Problem   string `json:"problem"`
+	Size      int64  `json:"size"` // of the intact file
+	Thumbnail string `json:"thumbnail"`
+}
+
+var (
+	scrubMutex   sync.Mutex
+	scrubCurrent *ScrubRun // the run in progress, nil when idle
+	scrubLast    *ScrubRun
+)
+
+func loadScrubState(baseDir string) *ScrubRun {
+	b, err := os.ReadFile(filepath.Join(baseDir, scrubStateFileName))
+	if err != nil {
+		return nil
+	}
+	var run ScrubRun
+	if err := json.Unmarshal(b, &run); err != nil {
+		log.Printf("Warning: ignoring %s: %v", scrubStateFileName, err)
+		return nil
+	}
+	return &run
+}
+
+func saveScrubState(baseDir string, run *ScrubRun) error {
+	b, err := json.MarshalIndent(run, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(baseDir, scrubStateFileName), b)
+}
+
+// scrubFile re-hashes one original and returns what is wrong with it, or "" if nothing.
+// A file whose modification time changed since it was cataloged was edited, e.g. its
+// EXIF rewritten, not damaged: that is "modified", with sum its new hash.
+func scrubFile(path string, e CatalogEntry) (problem, sum string, err error) {
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return "missing", "", nil
+	}
+	if err != nil {
+		return "", "", err
+	}
+	modified := !e.ModTime.IsZero() && !info.ModTime().Equal(e.ModTime)
+	if info.Size() < e.Size && !modified {
+		return "truncated", "", nil
+	}
+	if sum, err = calculateSHA256(path); err != nil {
+		return "", "", err
+	}
+	switch {
+	case strings.EqualFold(sum, e.SHA256):
+		return "", sum, nil
+	case modified:
+		return "modified", sum, nil
+	}
+	return "corrupted", sum, nil
+}
+
+// runScrub checks every cataloged original of the library against its recorded hash.
+// Files already flagged are skipped until they are stored again or accepted.
+func runScrub(config *Config, baseDir string) {
+	maxRate := 0
+	if config.Scrub != nil {
+		maxRate = config.Scrub.MaxMBps
+	}
+
+	scrubMutex.Lock()
+	run := scrubCurrent
+	scrubMutex.Unlock()
+
+	type job struct {
+		phone string
+		e     CatalogEntry
+	}
+	var jobs []job
+	for _, phone := range libraryPhones(baseDir) {
+		for _, e := range openCatalog(filepath.Join(baseDir, phone)).AllEntries() {
+			if e.SHA256 != "" && e.Damaged == "" && !e.Archived {
+				jobs = append(jobs, job{phone, e})
+			}
+		}
+	}
+	sort.Slice(jobs, func(i, j int) bool {
+		if jobs[i].phone != jobs[j].phone {
+			return jobs[i].phone < jobs[j].phone
+		}
+		return jobs[i].e.Name < jobs[j].e.Name
+	})
+	scrubMutex.Lock()
+	run.Total = len(jobs)
+	scrubMutex.Unlock()
+	log.Printf("Integrity scrub started: %d original(s)", len(jobs))
+
+	for _, j := range jobs {
+		phoneDir := filepath.Join(baseDir, j.phone)
+		path := entryPath(phoneDir, &j.e)
+		started := time.Now()
+		problem, sum, err := scrubFile(path, j.e)
+		if problem == "modified" {
+			log.Printf("Integrity scrub: %s/%s changed since it was cataloged, recording it again", j.phone, j.e.Name)
+			openCatalog(phoneDir).Record(path, sum)
+		}
+
+		scrubMutex.Lock()
+		run.Phone = j.phone
+		run.Checked++
+		if err != nil {
+			run.Errors = append(run.Errors, fmt.Sprintf("%s/%s: %v", j.phone, j.e.Name, err))
+		} else if problem == "modified" {
+			run.Bytes += j.e.Size
+		} else if problem != "" {
+			if openCatalog(phoneDir).MarkDamaged(path, j.e.SHA256, problem) {
+				run.Damaged++
+				log.Printf("Integrity scrub: %s/%s is %s", j.phone, j.e.Name, problem)
+			}
+		} else {
+			run.Bytes += j.e.Size
+		}
+		scrubMutex.Unlock()
+
+		if maxRate > 0 && (problem == "" || problem == "modified") && err == nil {
+			// Pace the reads to the configured rate
+			if wait := time.Duration(float64(j.e.Size)/float64(maxRate<<20)*float64(time.Second)) - time.Since(started); wait > 0 {
+				time.Sleep(wait)
+			}
+		}
+	}
+
+	scrubMutex.Lock()
+	run.Phone = ""
+	run.Finished = clock.Now()
+	scrubCurrent, scrubLast = nil, run
+	final := *run
+	scrubMutex.Unlock()
+	if err := saveScrubState(baseDir, &final); err != nil {
+		log.Printf("Warning: failed to save %s: %v", scrubStateFileName, err)
+	}
+	countFeature("scrub")
+	log.Printf("Integrity scrub finished: %d checked (%s), %d damaged, %d error(s) in %v", final.Checked,
+		formatBytes(final.Bytes), final.Damaged, len(final.Errors), final.Finished.Sub(final.Started).Round(time.Second))
+}
+
+// startScrubRun starts a scrub in the background unless one is running
+func startScrubRun(config *Config, baseDir string) error {
+	scrubMutex.Lock()
+	defer scrubMutex.Unlock()
+	if scrubCurrent != nil {
+		return fmt.Errorf("a scrub is already running")
+	}
+	scrubCurrent = &ScrubRun{Started: clock.Now()}
+	go runScrub(config, baseDir)
+	return nil
+}
+
+// startScrub runs the integrity scrub whenever its interval has passed since the last run
+func startScrub(config *Config) {
+	sc := config.Scrub
+	if sc == nil {
+		return
+	}
+	baseDir := config.ReceiveDir
+	if baseDir == "" {
+		baseDir = "received"
+	}
+	interval := defaultScrubInterval
+	if sc.IntervalHours > 0 {
+		interval = time.Duration(sc.IntervalHours) * time.Hour
+	}
+	log.Printf("Integrity scrub enabled (every %v, rerequest damaged files: %v)", interval, sc.Rerequest)
+
+	scrubMutex.Lock()
+	scrubLast = loadScrubState(baseDir)
+	scrubMutex.Unlock()
+
+	due := func() bool {
+		scrubMutex.Lock()
+		defer scrubMutex.Unlock()
+		return scrubCurrent == nil && (scrubLast == nil || clock.Since(scrubLast.Finished) >= interval)
+	}
+	if due() {
+		startScrubRun(config, baseDir)
+	}
+	ticker := clock.NewTicker(scrubCheckInterval)
+	defer ticker.Stop()
+	for range ticker.C() {
+		if due() {
+			startScrubRun(config, baseDir)
+		}
+	}
+}
+
+// scrubIssues lists the files currently flagged as damaged
+func scrubIssues(baseDir string) []ScrubIssue {
+	issues := []ScrubIssue{}
+	for _, phone := range libraryPhones(baseDir) {
+		for _, e := range openCatalog(filepath.Join(baseDir, phone)).AllEntries() {
+			if e.Damaged != "" {
+				issues = append(issues, ScrubIssue{Phone: phone, Name: e.Name, Problem: e.Damaged, Size: e.Size,
+					Thumbnail: thumbnailName(filepath.Base(filepath.FromSlash(e.Name)))})
+			}
+		}
+	}
+	sort.Slice(issues, func(i, j int) bool {
+		if issues[i].Phone != issues[j].Phone {
+			return issues[i].Phone < issues[j].Phone
+		}
+		return issues[i].Name < issues[j].Name
+	})
+	return issues
+}
+
+// registerScrubRoutes adds the scrub's status and findings, and ways to run it now and to
+// accept a flagged file as it is
+func registerScrubRoutes(router *mux.Router, config *Config) {
+	baseDirFor := func() string {
+		if config.ReceiveDir == "" {
+			return "received"
+		}
+		return config.ReceiveDir
+	}
+
+	router.HandleFunc("/api/scrub", func(w http.ResponseWriter, r *http.Request) {
+		baseDir := baseDirFor()
+		scrubMutex.Lock()
+		var current, last *ScrubRun
+		if scrubCurrent != nil {
+			c := *scrubCurrent
+			current = &c
+		}
+		if scrubLast == nil {
+			scrubLast = loadScrubState(baseDir)
+		}
+		last = scrubLast
+		scrubMutex.Unlock()
+		writeJSON(w, map[string]interface{}{"success": true, "scheduled": config.Scrub != nil,
+			"rerequest": config.Scrub != nil && config.Scrub.Rerequest, "running": current, "last": last,
+			"issues": scrubIssues(baseDir)})
+	}).Methods("GET")
+
+	router.HandleFunc("/api/scrub/run", func(w http.ResponseWriter, r *http.Request) {
+		if err := startScrubRun(config, baseDirFor()); err != nil {
+			writeJSON(w, map[string]interface{}{"success": false, "error": err.Error()})
+			return
+		}
+		writeJSON(w, map[string]interface{}{"success": true})
+	}).Methods("POST")
+
+	// Accepts a flagged file as it is now: its current content becomes the cataloged one,
+	// or, if it is missing, it is dropped from the catalog
+	router.HandleFunc("/api/scrub/accept", func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Phone string `json:"phone"`
+			Name  string `json:"name"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeJSON(w, map[string]interface{}{"success": false, "error": "Invalid request"})
+			return
+		}
+		if validatePhoneName(req.Phone) != nil ||
+			req.Name == "" || strings.Contains(req.Name, "..") {
+			writeJSON(w, map[string]interface{}{"success": false, "error": "Invalid phone or file name"})
+			return
+		}
+		phoneDir := filepath.Join(baseDirFor(), req.Phone)
+		path := openCatalog(phoneDir).Path(req.Name)
+		catalog := openCatalog(phoneDir)
+		if catalog.Damaged(path) == "" {
+			writeJSON(w, map[string]interface{}{"success": false, "error": "File isn't flagged"})
+			return
+		}
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			catalog.Forget(path)
+		} else {
+			sum, err := calculateSHA256(path)
+			if err != nil {
+				writeJSON(w, map[string]interface{}{"success": false, "error": err.Error()})
+				return
+			}
+			catalog.Record(path, sum)
+		}
+		log.Printf("Integrity scrub: accepted %s/%s as it is", req.Phone, req.Name)
+		writeJSON(w, map[string]interface{}{"success": true})
+	}).Methods("POST")
+
+	router.HandleFunc("/scrub", func(w http.ResponseWriter, r *http.Request) {
+		tmpl := `<!DOCTYPE html>
+<html>
+<head>
+    <title>Integrity Scrub - Photo Sync Server</title>
+    <style>
+        body { font-family: 'Segoe UI', Tahoma, Arial, sans-serif; margin: 0; padding: 20px; background: #000000; color: #ffffff; }
+        h1 { color: #ffffff; font-weight: 300; letter-spacing: 1px; }
+        h2 { font-size: 20px; margin-top: 30px; color: #aaaaaa; font-weight: 300; }
+        .back-link { display: inline-block; margin-bottom: 20px; color: #88aaff; text-decoration: none; font-size: 14px; }
+        .back-link:hover { color: #aaccff; text-decoration: underline; }
+        .panel { background: #1a1a1a; border: 1px solid #2a2a2a; border-radius: 12px; padding: 20px; max-width: 700px; font-size: 14px; color: #aaaaaa; }
+        .hint { color: #888888; font-size: 12px; }
+        table { border-collapse: collapse; width: 100%; max-width: 700px; font-size: 13px; }
+        th, td { text-align: left; padding: 8px; border-bottom: 1px solid #2a2a2a; vertical-align: middle; }
+        th { color: #888888; font-weight: normal; }
+        td img { width: 48px; height: 48px; object-fit: cover; border-radius: 4px; }
+        a { color: #88aaff; text-decoration: none; }
+        button { background: #667eea; color: #ffffff; border: none; border-radius: 6px; padding: 10px 20px; font-size: 14px; cursor: pointer; margin: 16px 8px 0 0; }
+        button:hover { background: #5a6fd6; }
+        button.secondary { background: #333333; padding: 6px 12px; margin: 0; font-size: 12px; }
+        button:disabled { opacity: 0.4; cursor: default; }
+        .error { color: #ff6b6b; margin-top: 12px; }
+        .bar { background: #333333; border-radius: 4px; height: 8px; margin: 8px 0; overflow: hidden; }
+        .bar div { background: #667eea; height: 100%; }
+        .problem { color: #ff6b6b; }
+    </style>
+</head>
+<body>
+    <a href="/" class="back-link">← Back to Home</a>
+    <h1>🩹 Integrity Scrub</h1>
+    <div class="panel">
+        The scrub re-reads every original and compares it with the checksum recorded when it was received,
+        to catch disk errors, truncated files and files gone missing.
+        <div class="hint" id="schedule"></div>
+        <button id="run" onclick="run()">Scrub now</button>
+        <div class="error" id="error"></div>
+    </div>
+
+    <h2>Progress</h2>
+    <div class="panel" id="progress"><span class="hint">No scrub has run yet.</span></div>
+
+    <h2>Damaged files</h2>
+    <table id="issues"></table>
+
+    <script>
+        function escapeHTML(s) {
+            return String(s).replace(/[&<>"']/g, function(c) {
+                return {'&': '&amp;', '<': '&lt;', '>': '&gt;', '"': '&quot;', "'": '&#39;'}[c];
+            });
+        }
+
+        function post(url, body) {
+            document.getElementById('error').textContent = '';
+            fetch(url, {method: 'POST', headers: {'Content-Type': 'application/json'}, body: JSON.stringify(body || {})})
+                .then(function(r) { return r.json(); })
+                .then(function(data) {
+                    if (!data.success) document.getElementById('error').textContent = data.error;
+                    refresh();
+                })
+                .catch(function(e) { document.getElementById('error').textContent = 'Error: ' + e; });
+        }
+
+        function run() { post('/api/scrub/run'); }
+
+        function accept(phone, name) {
+            if (confirm('Keep ' + name + ' as it is now? Its current content becomes the reference.')) {
+                post('/api/scrub/accept', {phone: phone, name: name});
+            }
+        }
+
+        function refresh() {
+            fetch('/api/scrub')
+                .then(function(r) { return r.json(); })
+                .then(function(data) {
+                    document.getElementById('schedule').textContent = data.scheduled ?
+                        'Runs on a schedule.' + (data.rerequest ? ' Damaged files are requested again from their phone on its next sync.' : '') :
+                        'Not scheduled; add a scrub section to the config to run it regularly.';
+                    document.getElementById('run').disabled = !!data.running;
+
+                    const run = data.running || data.last;
+                    if (run) {
+                        const percent = run.total > 0 ? Math.min(100, 100 * run.checked / run.total) : 100;
+                        let html = data.running ? 'Running' + (run.phone ? ' · ' + escapeHTML(run.phone) : '') :
+                            'Last run finished ' + new Date(run.finished).toLocaleString();
+                        html += '<div class="bar"><div style="width:' + percent.toFixed(1) + '%"></div></div>';
+                        html += run.checked + ' / ' + run.total + ' originals checked, ' + run.damaged + ' damaged';
+                        (run.errors || []).forEach(function(e) { html += '<br><span class="problem">' + escapeHTML(e) + '</span>'; });
+                        document.getElementById('progress').innerHTML = html;
+                    }
+
+                    const issues = data.issues || [];
+                    document.getElementById('issues').innerHTML = issues.length ?
+                        '<tr><th></th><th>Phone</th><th>File</th><th>Problem</th><th></th></tr>' + issues.map(function(it) {
+                            const phone = encodeURIComponent(it.phone);
+                            return '<tr><td><img src="/thumb/' + phone + '/' + encodeURIComponent(it.thumbnail) + '" alt=""></td>' +
+                                '<td>📱 ' + escapeHTML(it.phone) + '</td><td>' + escapeHTML(it.name) + '</td>' +
+                                '<td class="problem">' + escapeHTML(it.problem) + '</td>' +
+                                '<td><button class="secondary" data-phone="' + escapeHTML(it.phone) + '" data-name="' + escapeHTML(it.name) +
+                                '" onclick="accept(this.dataset.phone, this.dataset.name)">Accept as is</button></td></tr>';
+                        }).join('') : '<tr><td class="hint">No damaged files.</td></tr>';
+                })
+                .catch(function() {});
+        }
+
+        refresh();
+        setInterval(refresh, 2000);
+    </script>
+</body>
+</html>`
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if err := template.Must(template.New("scrub").Parse(tmpl)).Execute(w, nil); err != nil {
+			log.Printf("Error rendering scrub page: %v", err)
+		}
+	}).Methods("GET")
+}
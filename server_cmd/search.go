@@ -0,0 +1,163 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// maxSearchResults bounds the matches /api/search returns
+const maxSearchResults = 500
+
+// searchText is what a file can be found by: its name, place, and the tags, labels and
+// text the analyzers found, lowercased
+func searchText(e *CatalogEntry) string {
+	parts := []string{e.Name}
+	if e.Geo != nil {
+		parts = append(parts, e.Geo.Label(), e.Geo.Region)
+	}
+	for _, a := range e.Analysis {
+		parts = append(parts, a.Tags...)
+		for _, l := range a.Labels {
+			parts = append(parts, l.Name)
+		}
+		if a.Text != "" {
+			parts = append(parts, a.Text)
+		}
+	}
+	return strings.ToLower(strings.Join(parts, "\n"))
+}
+
+// searchTerms splits a query into the words that must all be found
+func searchTerms(query string) []string {
+	return strings.Fields(strings.ToLower(query))
+}
+
+// matchesSearch reports whether every term is in a file's search text
+func matchesSearch(e *CatalogEntry, terms []string) bool {
+	if len(terms) == 0 {
+		return false
+	}
+	text := searchText(e)
+	for _, t := range terms {
+		if !strings.Contains(text, t) {
+			return false
+		}
+	}
+	return true
+}
+
+// searchSnippet returns the analyzer text around the first term, for showing why a file matched
+func searchSnippet(e *CatalogEntry, terms []string) string {
+	const around = 60
+	for _, a := range e.Analysis {
+		lower := strings.ToLower(a.Text)
+		i := strings.Index(lower, terms[0])
+		if a.Text == "" || i < 0 || len(lower) != len(a.Text) {
+			continue
+		}
+		start, end := max(0, i-around), min(len(a.Text), i+len(terms[0])+around)
+		snippet := strings.ToValidUTF8(strings.Join(strings.Fields(a.Text[start:end]), " "), "")
+		if start > 0 {
+			snippet = "…" + snippet
+		}
+		if end < len(a.Text) {
+			snippet += "…"
+		}
+		return snippet
+	}
+	return ""
+}
+
+// applySearch keeps the gallery items (thumbnail or video names) whose originals match
+// the query, archived ones included
+func applySearch(phoneDir string, items []string, query string) []string {
+	terms := searchTerms(query)
+	catalog := openCatalog(phoneDir)
+	var result []string
+	for _, item := range items {
+		orig, ok := originalForThumbnail(phoneDir, item)
+		if !ok {
+			key, archived := catalog.ArchivedFile(item)
+			if !archived {
+				continue
+			}
+			orig = catalog.Path(key)
+		}
+		if e, ok := catalog.Entry(orig); ok && matchesSearch(&e, terms) {
+			result = append(result, item)
+		}
+	}
+	return result
+}
+
+// galleryItem is the name the gallery shows a file under: its thumbnail, or a video itself
+func galleryItem(e *CatalogEntry) string {
+	name := path.Base(e.Name)
+	if hasExtension(name, videoExtensions) {
+		return name
+	}
+	return thumbnailName(name)
+}
+
+// registerSearchRoutes adds searching the library by name, place, tags and the text read
+// in photos
+func registerSearchRoutes(router *mux.Router, config *Config) {
+	router.HandleFunc("/api/search", func(w http.ResponseWriter, r *http.Request) {
+		baseDir := config.ReceiveDir
+		if baseDir == "" {
+			baseDir = "received"
+		}
+		terms := searchTerms(r.URL.Query().Get("q"))
+		phones := libraryPhones(baseDir)
+		if phone := r.URL.Query().Get("phone"); phone != "" {
+			phones = []string{phone}
+		}
+
+		type searchMatch struct {
+			Phone   string `json:"phone"`
+			File    string `json:"file"` // path in the phone directory
+			Item    string `json:"item"` // name in the gallery: thumbnail, or video file
+			Snippet string `json:"snippet,omitempty"`
+		}
+		matches := []searchMatch{}
+		truncated := false
+		for _, phone := range phones {
+			if len(terms) == 0 || truncated {
+				break
+			}
+			if validatePhoneName(phone) != nil {
+				continue
+			}
+			for _, e := range openCatalog(filepath.Join(baseDir, phone)).AllEntries() {
+				if !matchesSearch(&e, terms) {
+					continue
+				}
+				if len(matches) == maxSearchResults {
+					truncated = true
+					break
+				}
+				matches = append(matches, searchMatch{Phone: phone, File: e.Name, Item: galleryItem(&e),
+					Snippet: searchSnippet(&e, terms)})
+			}
+		}
+		sort.Slice(matches, func(i, j int) bool {
+			if matches[i].Phone != matches[j].Phone {
+				return matches[i].Phone < matches[j].Phone
+			}
+			return matches[i].File < matches[j].File
+		})
+		countFeature("search")
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success":   true,
+			"matches":   matches,
+			"truncated": truncated,
+		})
+	}).Methods("GET")
+}
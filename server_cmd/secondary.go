@@ -0,0 +1,740 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	// secondaryStateFileName records what the secondary directory holds, in the receive directory
+	secondaryStateFileName = ".secondary.json"
+	// secondaryMarkerName is written into the secondary directory when it is first used.
+	// Without it the directory is taken as unavailable, so an unmounted drive's empty mount
+	// point doesn't fill up the system disk.
+	secondaryMarkerName = ".photo_sync_secondary"
+	// defaultSecondaryScanInterval is how often the library is compared with the copy, to
+	// pick up files the queue missed and deletions, unless configured
+	defaultSecondaryScanInterval = time.Hour
+	// defaultSecondaryVerifyInterval is how often every copy is read back and checked
+	defaultSecondaryVerifyInterval = 7 * 24 * time.Hour
+	// secondaryMaxBackoff caps the wait after failed copies
+	secondaryMaxBackoff = 5 * time.Minute
+)
+
+// SecondaryConfig keeps a second copy of the library in another local directory, e.g. a
+// USB drive or an NFS mount, as <dir>/<phone>/<name> plus the catalogs and albums. Files
+// are copied in the background as they are received, checked against their catalog hash,
+// and read back and verified on a schedule. Files deleted from the library are removed
+// from the copy at the next scan.
+type SecondaryConfig struct {
+	Dir         string `json:"dir"`
+	ScanMin     int    `json:"scan_min"`     // minutes between scans of the library, default 60
+	VerifyHours int    `json:"verify_hours"` // hours between verifications of every copy, default 168
+}
+
+// secondaryState is what the secondary directory holds
+type secondaryState struct {
+	Dir        string                       `json:"dir"`                  // the directory this state is about
+	Phones     map[string]map[string]string `json:"phones"`               // phone -> catalog name -> SHA-256 of the copy
+	LastVerify time.Time                    `json:"lastVerify,omitempty"` // end of the last full verification
+	Verified   int                          `json:"verified"`             // copies checked by it
+	VerifyBad  int                          `json:"verifyBad"`            // copies it found missing or changed
+}
+
+type secondaryJob struct {
+	phone, name string // name is the catalog name inside the phone directory
+}
+
+var (
+	// secondaryDir is the secondary directory in use, set from the config at startup ("": off)
+	secondaryDir string
+
+	secondaryMutex       sync.Mutex // guards everything below
+	secondary            *secondaryState
+	secondaryQueue       []secondaryJob
+	secondaryQueued      map[secondaryJob]bool
+	secondaryIndexDue    map[string]bool // phones whose catalog changed since it was copied
+	secondaryCurrent     string          // file being copied
+	secondaryCopied      int             // since startup
+	secondaryVerifying   bool
+	secondaryVerifyNow   bool
+	secondaryUnavailable string // why the directory can't be used, "" while it can
+	secondaryLastError   string
+	secondaryErrorTime   time.Time
+	secondaryWake        = make(chan struct{}, 1)
+)
+
+// setSecondary enables the secondary copy from the config
+func setSecondary(config *Config) error {
+	sc := config.Secondary
+	if sc == nil {
+		return nil
+	}
+	if sc.Dir == "" {
+		return fmt.Errorf("no dir set")
+	}
+	baseDir := config.ReceiveDir
+	if baseDir == "" {
+		baseDir = "received"
+	}
+	dir, err := filepath.Abs(sc.Dir)
+	if err != nil {
+		return err
+	}
+	if absBase, err := filepath.Abs(baseDir); err == nil {
+		if rel, err := filepath.Rel(absBase, dir); err == nil && !strings.HasPrefix(rel, "..") {
+			return fmt.Errorf("%s is inside the receive directory", sc.Dir)
+		}
+	}
+	scanInterval := defaultSecondaryScanInterval
+	if sc.ScanMin > 0 {
+		scanInterval = time.Duration(sc.ScanMin) * time.Minute
+	}
+	verifyInterval := defaultSecondaryVerifyInterval
+	if sc.VerifyHours > 0 {
+		verifyInterval = time.Duration(sc.VerifyHours) * time.Hour
+	}
+
+	st, err := loadSecondaryState(baseDir)
+	if err != nil {
+		return err
+	}
+	if st.Dir != dir {
+		// First use of this directory: mark it as ours
+		st = &secondaryState{Dir: dir, Phones: make(map[string]map[string]string)}
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return err
+		}
+		if err := writeFileAtomic(filepath.Join(dir, secondaryMarkerName), []byte("Secondary copy of a photo_sync_server library\n")); err != nil {
+			return err
+		}
+	}
+	secondaryMutex.Lock()
+	secondary = st
+	secondaryQueued = make(map[secondaryJob]bool)
+	secondaryIndexDue = make(map[string]bool)
+	saveSecondaryState(baseDir)
+	secondaryMutex.Unlock()
+	secondaryDir = dir
+	log.Printf("Secondary copy enabled: %s (scan every %v, verify every %v)", dir, scanInterval, verifyInterval)
+	go runSecondary(baseDir, scanInterval, verifyInterval)
+	return nil
+}
+
+func loadSecondaryState(baseDir string) (*secondaryState, error) {
+	st := &secondaryState{}
+	b, err := os.ReadFile(filepath.Join(baseDir, secondaryStateFileName))
+	if os.IsNotExist(err) {
+		return st, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, st); err != nil {
+		return nil, fmt.Errorf("%s: %w", secondaryStateFileName, err)
+	}
+	if st.Phones == nil {
+		st.Phones = make(map[string]map[string]string)
+	}
+	return st, nil
+}
+
+// saveSecondaryState writes the copy's state; callers hold secondaryMutex
+func saveSecondaryState(baseDir string) {
+	b, err := json.Marshal(secondary)
+	if err == nil {
+		err = writeFileAtomic(filepath.Join(baseDir, secondaryStateFileName), b)
+	}
+	if err != nil {
+		log.Printf("Error saving %s: %v", secondaryStateFileName, err)
+	}
+}
+
+// secondaryPhone returns the copied files of a phone, creating the map; callers hold secondaryMutex
+func secondaryPhone(phone string) map[string]string {
+	files, ok := secondary.Phones[phone]
+	if !ok {
+		files = make(map[string]string)
+		secondary.Phones[phone] = files
+	}
+	return files
+}
+
+// queueSecondary adds a file to the backlog unless it is queued already; callers hold secondaryMutex
+func queueSecondary(job secondaryJob) {
+	if !secondaryQueued[job] {
+		secondaryQueued[job] = true
+		secondaryQueue = append(secondaryQueue, job)
+	}
+}
+
+func wakeSecondary() {
+	select {
+	case secondaryWake <- struct{}{}:
+	default:
+	}
+}
+
+// secondaryLater queues an original that was just stored for copying
+func secondaryLater(phoneDir, path string) {
+	if secondaryDir == "" {
+		return
+	}
+	secondaryMutex.Lock()
+	queueSecondary(secondaryJob{phone: filepath.Base(phoneDir), name: phoneFileName(phoneDir, path)})
+	secondaryMutex.Unlock()
+	wakeSecondary()
+}
+
+// checkSecondaryDir records whether the secondary directory can be written, and returns
+// why not
+func checkSecondaryDir() error {
+	var err error
+	if info, statErr := os.Stat(secondaryDir); statErr != nil {
+		err = statErr
+	} else if !info.IsDir() {
+		err = fmt.Errorf("%s is not a directory", secondaryDir)
+	} else if _, statErr := os.Stat(filepath.Join(secondaryDir, secondaryMarkerName)); statErr != nil {
+		err = fmt.Errorf("%s has no %s file; is the drive mounted?", secondaryDir, secondaryMarkerName)
+	}
+	secondaryMutex.Lock()
+	defer secondaryMutex.Unlock()
+	if err == nil {
+		if secondaryUnavailable != "" {
+			log.Printf("Secondary copy: %s is available again", secondaryDir)
+		}
+		secondaryUnavailable = ""
+		return nil
+	}
+	if secondaryUnavailable == "" {
+		log.Printf("Secondary copy unavailable: %v", err)
+	}
+	secondaryUnavailable = err.Error()
+	return err
+}
+
+// scanSecondary queues every original whose current content isn't in the copy, and
+// removes copies of files that left the library. Copies of files in the trash stay until
+// the trash lets go of them, since the primary can still restore them until then.
+func scanSecondary(baseDir string) {
+	if checkSecondaryDir() != nil {
+		return
+	}
+	queued, removed := 0, 0
+	for _, phone := range libraryPhones(baseDir) {
+		catalog := openCatalog(filepath.Join(baseDir, phone))
+		entries := catalog.AllEntries()
+		inLibrary := make(map[string]bool, len(entries))
+		for _, t := range catalog.TrashEntries() {
+			inLibrary[t.Name] = true
+		}
+		secondaryMutex.Lock()
+		files := secondaryPhone(phone)
+		for _, e := range entries {
+			inLibrary[e.Name] = true
+			if e.SHA256 != "" && e.Damaged == "" && !e.Archived && !strings.EqualFold(files[e.Name], e.SHA256) {
+				queueSecondary(secondaryJob{phone: phone, name: e.Name})
+				queued++
+			}
+		}
+		var gone []string
+		for name := range files {
+			if !inLibrary[name] {
+				gone = append(gone, name)
+			}
+		}
+		secondaryMutex.Unlock()
+
+		for _, name := range gone {
+			err := os.Remove(filepath.Join(secondaryDir, phone, filepath.FromSlash(name)))
+			if err != nil && !os.IsNotExist(err) {
+				log.Printf("Secondary copy: error removing %s/%s: %v", phone, name, err)
+				continue
+			}
+			secondaryMutex.Lock()
+			delete(files, name)
+			secondaryIndexDue[phone] = true
+			secondaryMutex.Unlock()
+			removed++
+		}
+	}
+	if queued > 0 || removed > 0 {
+		log.Printf("Secondary copy: %d file(s) to copy, %d removed", queued, removed)
+	}
+}
+
+// copySecondaryFile copies one queued original unless the copy has its content already.
+// The content is hashed on the way and must match the catalog, so a file that changed or
+// rotted since it was received doesn't replace a good copy.
+func copySecondaryFile(baseDir string, job secondaryJob) error {
+	phoneDir := filepath.Join(baseDir, job.phone)
+	path := openCatalog(phoneDir).Path(job.name)
+	e, ok := openCatalog(phoneDir).Entry(path)
+	if !ok || e.SHA256 == "" || e.Damaged != "" {
+		return nil // gone, or not to be trusted
+	}
+	secondaryMutex.Lock()
+	if strings.EqualFold(secondaryPhone(job.phone)[job.name], e.SHA256) {
+		secondaryMutex.Unlock()
+		return nil
+	}
+	secondaryCurrent = job.phone + "/" + job.name
+	secondaryMutex.Unlock()
+	defer func() {
+		secondaryMutex.Lock()
+		secondaryCurrent = ""
+		secondaryMutex.Unlock()
+	}()
+
+	src, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil // deleted meanwhile
+	}
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	info, err := src.Stat()
+	if err != nil {
+		return err
+	}
+	dest := filepath.Join(secondaryDir, job.phone, filepath.FromSlash(job.name))
+	if err := os.MkdirAll(filepath.Dir(dest), 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(dest), "."+filepath.Base(dest)+".*"+partialFileSuffix)
+	if err != nil {
+		return err
+	}
+	hash := sha256.New()
+	_, err = io.Copy(io.MultiWriter(tmp, hash), src)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if sum := hex.EncodeToString(hash.Sum(nil)); !strings.EqualFold(sum, e.SHA256) {
+		os.Remove(tmp.Name())
+		log.Printf("Secondary copy: %s/%s doesn't match its catalog hash, not copied (see the integrity scrub)", job.phone, job.name)
+		return nil
+	}
+	os.Chmod(tmp.Name(), info.Mode().Perm())
+	os.Chtimes(tmp.Name(), info.ModTime(), info.ModTime())
+	if err := os.Rename(tmp.Name(), dest); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	syncDir(filepath.Dir(dest))
+
+	secondaryMutex.Lock()
+	defer secondaryMutex.Unlock()
+	secondaryPhone(job.phone)[job.name] = strings.ToLower(e.SHA256)
+	secondaryIndexDue[job.phone] = true
+	secondaryCopied++
+	secondaryLastError = ""
+	return nil
+}
+
+// copySecondaryIndexes copies the catalogs that changed and the shared albums, so the
+// copy can stand in for the library with its dates, favorites and albums
+func copySecondaryIndexes(baseDir string) error {
+	secondaryMutex.Lock()
+	var phones []string
+	for phone := range secondaryIndexDue {
+		phones = append(phones, phone)
+	}
+	secondaryMutex.Unlock()
+	if len(phones) == 0 {
+		return nil
+	}
+	sort.Strings(phones)
+
+	for _, phone := range phones {
+		data, err := openCatalog(filepath.Join(baseDir, phone)).snapshot()
+		if err != nil {
+			return err
+		}
+		if err := os.MkdirAll(filepath.Join(secondaryDir, phone), 0o755); err != nil {
+			return err
+		}
+		if err := writeFileAtomic(filepath.Join(secondaryDir, phone, catalogFileName), data); err != nil {
+			return err
+		}
+		secondaryMutex.Lock()
+		delete(secondaryIndexDue, phone)
+		secondaryMutex.Unlock()
+	}
+	albumsMutex.Lock()
+	data, err := os.ReadFile(filepath.Join(baseDir, albumsFileName))
+	albumsMutex.Unlock()
+	if err == nil {
+		return writeFileAtomic(filepath.Join(secondaryDir, albumsFileName), data)
+	}
+	return nil
+}
+
+// verifySecondary reads back every copy and checks it against the hash it was copied
+// with. Missing or changed copies are forgotten and queued to be copied again.
+func verifySecondary(baseDir string) {
+	if checkSecondaryDir() != nil {
+		return
+	}
+	secondaryMutex.Lock()
+	secondaryVerifying = true
+	todo := make(map[string]map[string]string, len(secondary.Phones))
+	for phone, files := range secondary.Phones {
+		todo[phone] = make(map[string]string, len(files))
+		for name, sum := range files {
+			todo[phone][name] = sum
+		}
+	}
+	secondaryMutex.Unlock()
+
+	started := time.Now()
+	checked, bad := 0, 0
+	for phone, files := range todo {
+		for name, sum := range files {
+			if draining() {
+				secondaryMutex.Lock()
+				secondaryVerifying = false
+				secondaryMutex.Unlock()
+				return
+			}
+			got, err := calculateSHA256(filepath.Join(secondaryDir, phone, filepath.FromSlash(name)))
+			checked++
+			if err == nil && strings.EqualFold(got, sum) {
+				continue
+			}
+			if err != nil {
+				log.Printf("Secondary copy: verifying %s/%s: %v", phone, name, err)
+			} else {
+				log.Printf("Secondary copy: %s/%s changed on the secondary drive, copying it again", phone, name)
+			}
+			bad++
+			secondaryMutex.Lock()
+			if secondary.Phones[phone][name] == sum {
+				delete(secondary.Phones[phone], name)
+				queueSecondary(secondaryJob{phone: phone, name: name})
+			}
+			secondaryMutex.Unlock()
+		}
+	}
+
+	secondaryMutex.Lock()
+	defer secondaryMutex.Unlock()
+	secondaryVerifying = false
+	secondary.LastVerify, secondary.Verified, secondary.VerifyBad = clock.Now(), checked, bad
+	saveSecondaryState(baseDir)
+	log.Printf("Secondary copy: verified %d file(s) in %v, %d bad", checked, time.Since(started).Round(time.Second), bad)
+}
+
+func setSecondaryError(err error) {
+	secondaryMutex.Lock()
+	secondaryLastError, secondaryErrorTime = err.Error(), clock.Now()
+	secondaryMutex.Unlock()
+	log.Printf("Secondary copy: %v", err)
+}
+
+// runSecondary works through the backlog, one copy at a time, and verifies the copy when
+// caught up and due. Failed copies go back to the end of the queue and the next one waits
+// a bit longer, up to secondaryMaxBackoff.
+func runSecondary(baseDir string, scanInterval, verifyInterval time.Duration) {
+	scanSecondary(baseDir)
+	ticker := clock.NewTicker(scanInterval)
+	defer ticker.Stop()
+	backoff := time.Duration(0)
+	for {
+		if draining() {
+			return
+		}
+		secondaryMutex.Lock()
+		var job secondaryJob
+		ok := len(secondaryQueue) > 0
+		if ok {
+			job = secondaryQueue[0]
+			secondaryQueue = secondaryQueue[1:]
+			delete(secondaryQueued, job)
+		}
+		secondaryMutex.Unlock()
+
+		if !ok {
+			// Caught up: the catalogs go last, so they describe what was copied
+			if checkSecondaryDir() == nil {
+				if err := copySecondaryIndexes(baseDir); err != nil {
+					setSecondaryError(err)
+				}
+			}
+			secondaryMutex.Lock()
+			saveSecondaryState(baseDir)
+			verify := secondaryUnavailable == "" && (secondaryVerifyNow || clock.Since(secondary.LastVerify) >= verifyInterval)
+			secondaryVerifyNow = false
+			secondaryMutex.Unlock()
+			if verify {
+				verifySecondary(baseDir)
+				continue
+			}
+			select {
+			case <-secondaryWake:
+			case <-ticker.C():
+				scanSecondary(baseDir)
+			}
+			continue
+		}
+
+		err := checkSecondaryDir()
+		if err == nil {
+			err = copySecondaryFile(baseDir, job)
+			if err != nil {
+				setSecondaryError(err)
+			}
+		}
+		if err != nil {
+			secondaryMutex.Lock()
+			queueSecondary(job)
+			secondaryMutex.Unlock()
+			backoff = min(max(2*backoff, time.Second), secondaryMaxBackoff)
+			time.Sleep(backoff)
+			continue
+		}
+		backoff = 0
+	}
+}
+
+// secondaryHealth sums up the secondary copy as "ok", "syncing", "verifying", "error" or
+// "unavailable", with a detail for people
+func secondaryHealth() (string, string) {
+	secondaryMutex.Lock()
+	defer secondaryMutex.Unlock()
+	switch {
+	case secondaryUnavailable != "":
+		return "unavailable", secondaryUnavailable
+	case secondaryLastError != "":
+		return "error", secondaryLastError
+	case len(secondaryQueue) > 0 || secondaryCurrent != "":
+		return "syncing", fmt.Sprintf("%d file(s) to copy", len(secondaryQueue)+min(1, len(secondaryCurrent)))
+	case secondaryVerifying:
+		return "verifying", "reading back every copy"
+	case secondary.LastVerify.IsZero():
+		return "ok", "up to date, not verified yet"
+	}
+	return "ok", fmt.Sprintf("up to date, verified %s", secondary.LastVerify.Format("2006-01-02"))
+}
+
+// secondaryIndicator is the secondary copy's health for the home page, "" when off
+func secondaryIndicator() string {
+	if secondaryDir == "" {
+		return ""
+	}
+	health, detail := secondaryHealth()
+	icon := map[string]string{"ok": "✅", "syncing": "🔄", "verifying": "🔍", "error": "⚠️", "unavailable": "❌"}[health]
+	if health == "unavailable" || health == "error" {
+		detail = health
+	}
+	return icon + " " + detail
+}
+
+// SecondaryPhoneStatus is the secondary copy of one phone for the status page
+type SecondaryPhoneStatus struct {
+	Phone        string `json:"phone"`
+	Files        int    `json:"files"`
+	Pending      int    `json:"pending"`
+	PendingBytes int64  `json:"pendingBytes"`
+}
+
+// secondaryStatus compares every phone with what the copy holds
+func secondaryStatus(baseDir string) []SecondaryPhoneStatus {
+	var list []SecondaryPhoneStatus
+	for _, phone := range libraryPhones(baseDir) {
+		entries := openCatalog(filepath.Join(baseDir, phone)).AllEntries()
+		secondaryMutex.Lock()
+		files := secondaryPhone(phone)
+		s := SecondaryPhoneStatus{Phone: phone}
+		for _, e := range entries {
+			if e.SHA256 == "" || e.Damaged != "" {
+				continue
+			}
+			if strings.EqualFold(files[e.Name], e.SHA256) {
+				s.Files++
+			} else {
+				s.Pending++
+				s.PendingBytes += e.Size
+			}
+		}
+		secondaryMutex.Unlock()
+		list = append(list, s)
+	}
+	return list
+}
+
+// registerSecondaryRoutes adds the secondary copy's status page and ways to scan and
+// verify it now
+func registerSecondaryRoutes(router *mux.Router, config *Config) {
+	baseDirFor := func() string {
+		if config.ReceiveDir == "" {
+			return "received"
+		}
+		return config.ReceiveDir
+	}
+
+	router.HandleFunc("/api/secondary", func(w http.ResponseWriter, r *http.Request) {
+		if secondaryDir == "" {
+			writeJSON(w, map[string]interface{}{"success": true, "enabled": false})
+			return
+		}
+		phones := secondaryStatus(baseDirFor())
+		health, detail := secondaryHealth()
+		secondaryMutex.Lock()
+		defer secondaryMutex.Unlock()
+		status := map[string]interface{}{"success": true, "enabled": true, "dir": secondaryDir, "health": health,
+			"detail": detail, "queued": len(secondaryQueue), "current": secondaryCurrent, "copied": secondaryCopied,
+			"verifying": secondaryVerifying, "verified": secondary.Verified, "verifyBad": secondary.VerifyBad,
+			"phones": phones}
+		if !secondary.LastVerify.IsZero() {
+			status["lastVerify"] = secondary.LastVerify
+		}
+		if secondaryLastError != "" {
+			status["lastError"], status["lastErrorTime"] = secondaryLastError, secondaryErrorTime
+		}
+		writeJSON(w, status)
+	}).Methods("GET")
+
+	router.HandleFunc("/api/secondary/scan", func(w http.ResponseWriter, r *http.Request) {
+		if secondaryDir == "" {
+			writeJSON(w, map[string]interface{}{"success": false, "error": "No secondary copy configured"})
+			return
+		}
+		scanSecondary(baseDirFor())
+		wakeSecondary()
+		countFeature("secondary_scan")
+		writeJSON(w, map[string]interface{}{"success": true})
+	}).Methods("POST")
+
+	// Verifies every copy once the backlog is copied
+	router.HandleFunc("/api/secondary/verify", func(w http.ResponseWriter, r *http.Request) {
+		if secondaryDir == "" {
+			writeJSON(w, map[string]interface{}{"success": false, "error": "No secondary copy configured"})
+			return
+		}
+		secondaryMutex.Lock()
+		secondaryVerifyNow = true
+		secondaryMutex.Unlock()
+		wakeSecondary()
+		countFeature("secondary_verify")
+		writeJSON(w, map[string]interface{}{"success": true})
+	}).Methods("POST")
+
+	router.HandleFunc("/secondary", func(w http.ResponseWriter, r *http.Request) {
+		tmpl := `<!DOCTYPE html>
+<html>
+<head>
+    <title>Secondary Copy - Photo Sync Server</title>
+    <style>
+        body { font-family: 'Segoe UI', Tahoma, Arial, sans-serif; margin: 0; padding: 20px; background: #000000; color: #ffffff; }
+        h1 { color: #ffffff; font-weight: 300; letter-spacing: 1px; }
+        h2 { font-size: 20px; margin-top: 30px; color: #aaaaaa; font-weight: 300; }
+        .back-link { display: inline-block; margin-bottom: 20px; color: #88aaff; text-decoration: none; font-size: 14px; }
+        .back-link:hover { color: #aaccff; text-decoration: underline; }
+        .panel { background: #1a1a1a; border: 1px solid #2a2a2a; border-radius: 12px; padding: 20px; max-width: 700px; font-size: 14px; color: #aaaaaa; }
+        .hint { color: #888888; font-size: 12px; }
+        table { border-collapse: collapse; width: 100%; max-width: 700px; font-size: 13px; }
+        th, td { text-align: left; padding: 8px; border-bottom: 1px solid #2a2a2a; }
+        th { color: #888888; font-weight: normal; }
+        button { background: #667eea; color: #ffffff; border: none; border-radius: 6px; padding: 10px 20px; font-size: 14px; cursor: pointer; margin: 16px 8px 0 0; }
+        button:hover { background: #5a6fd6; }
+        .health { font-size: 18px; color: #ffffff; margin-bottom: 8px; }
+        .error { color: #ff6b6b; margin-top: 12px; }
+        .done { color: #4ade80; }
+    </style>
+</head>
+<body>
+    <a href="/" class="back-link">← Back to Home</a>
+    <h1>🗄 Secondary Copy</h1>
+    <div class="panel" id="summary"><span class="hint">Loading…</span></div>
+
+    <h2>Phones</h2>
+    <table id="phones"></table>
+
+    <script>
+        const icons = {ok: '✅ Healthy', syncing: '🔄 Copying', verifying: '🔍 Verifying', error: '⚠️ Error', unavailable: '❌ Unavailable'};
+
+        function escapeHTML(s) {
+            return String(s).replace(/[&<>"']/g, function(c) {
+                return {'&': '&amp;', '<': '&lt;', '>': '&gt;', '"': '&quot;', "'": '&#39;'}[c];
+            });
+        }
+
+        function formatBytes(n) {
+            if (n >= 1073741824) return (n / 1073741824).toFixed(1) + ' GB';
+            if (n >= 1048576) return (n / 1048576).toFixed(1) + ' MB';
+            if (n >= 1024) return (n / 1024).toFixed(0) + ' KB';
+            return n + ' B';
+        }
+
+        function when(t) {
+            return t && !t.startsWith('0001') ? new Date(t).toLocaleString() : 'never';
+        }
+
+        function post(url) {
+            fetch(url, {method: 'POST'}).then(refresh);
+        }
+
+        function refresh() {
+            fetch('/api/secondary')
+                .then(function(r) { return r.json(); })
+                .then(function(data) {
+                    if (!data.enabled) {
+                        document.getElementById('summary').innerHTML = 'No secondary copy configured. Add a secondary section with ' +
+                            'the dir of a USB drive or network mount to the config.';
+                        return;
+                    }
+                    let html = '<div class="health">' + (icons[data.health] || escapeHTML(data.health)) + '</div>';
+                    html += escapeHTML(data.dir) + ': ' + escapeHTML(data.detail) + '<br>';
+                    if (data.current) html += 'Copying ' + escapeHTML(data.current) + '<br>';
+                    html += '<span class="hint">' + data.copied + ' file(s) copied since the server started · last verified ' + when(data.lastVerify);
+                    if (data.lastVerify) html += ': ' + data.verified + ' file(s), ' + data.verifyBad + ' bad';
+                    html += '</span>';
+                    if (data.lastError) html += '<div class="error">' + escapeHTML(data.lastError) + ' (' + when(data.lastErrorTime) + ')</div>';
+                    html += '<div><button onclick="post(\'/api/secondary/scan\')">Scan for new files</button>' +
+                        '<button onclick="post(\'/api/secondary/verify\')"' + (data.verifying ? ' disabled' : '') + '>Verify now</button></div>';
+                    document.getElementById('summary').innerHTML = html;
+
+                    const phones = data.phones || [];
+                    document.getElementById('phones').innerHTML = phones.length ?
+                        '<tr><th>Phone</th><th>Copied</th><th>Waiting</th></tr>' + phones.map(function(p) {
+                            return '<tr><td>📱 ' + escapeHTML(p.phone) + '</td><td>' + p.files + '</td><td>' +
+                                (p.pending ? p.pending + ' (' + formatBytes(p.pendingBytes) + ')' : '<span class="done">none</span>') + '</td></tr>';
+                        }).join('') : '<tr><td class="hint">No phones yet.</td></tr>';
+                })
+                .catch(function() {});
+        }
+
+        refresh();
+        setInterval(refresh, 3000);
+    </script>
+</body>
+</html>`
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if err := template.Must(template.New("secondary").Parse(tmpl)).Execute(w, nil); err != nil {
+			log.Printf("Error rendering secondary copy page: %v", err)
+		}
+	}).Methods("GET")
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// defaultShutdownGrace is how long uploads in progress and running tool jobs get to
+// finish after SIGINT/SIGTERM before the server closes them and exits
+const defaultShutdownGrace = 30 * time.Second
+
+// shutdownGrace returns the configured shutdown grace period
+func shutdownGrace(config *Config) time.Duration {
+	if config == nil || config.ShutdownGraceSec <= 0 {
+		return defaultShutdownGrace
+	}
+	return time.Duration(config.ShutdownGraceSec) * time.Second
+}
+
+// syncConnState lets a shutdown tell whether a sync connection is between messages
+type syncConnState struct {
+	conn      net.Conn
+	busy      atomic.Bool  // a message is being read or handled
+	transfers atomic.Int32 // chunked transfers open on the connection
+}
+
+var (
+	shutdownMutex  sync.Mutex
+	drainingCh     = make(chan struct{})
+	stopAccepting  []func(ctx context.Context)
+	syncConns      = make(map[*syncConnState]bool)
+	syncConnsGroup sync.WaitGroup
+)
+
+// onShutdown registers how a server stops accepting connections. stop may block until
+// the server's in-flight requests finish or ctx (the end of the grace period) expires.
+func onShutdown(stop func(ctx context.Context)) {
+	shutdownMutex.Lock()
+	defer shutdownMutex.Unlock()
+	stopAccepting = append(stopAccepting, stop)
+}
+
+// draining reports whether the server is shutting down: sync connections finish their
+// open transfers and then close instead of waiting for more
+func draining() bool {
+	select {
+	case <-drainingCh:
+		return true
+	default:
+		return false
+	}
+}
+
+// trackSyncConn registers a sync connection for draining; call done when it closes
+func trackSyncConn(conn net.Conn) *syncConnState {
+	state := &syncConnState{conn: conn}
+	shutdownMutex.Lock()
+	syncConns[state] = true
+	syncConnsGroup.Add(1)
+	shutdownMutex.Unlock()
+	return state
+}
+
+func (s *syncConnState) done() {
+	shutdownMutex.Lock()
+	delete(syncConns, s)
+	shutdownMutex.Unlock()
+	syncConnsGroup.Done()
+}
+
+// closeIfIdle closes a connection that is waiting for its next message with no transfer
+// open; nothing is lost, the app syncs the rest next time
+func (s *syncConnState) closeIfIdle() {
+	if !s.busy.Load() && s.transfers.Load() == 0 {
+		s.conn.Close()
+	}
+}
+
+// waitTimeout waits for wg until deadline and reports whether it finished
+func waitTimeout(wg *sync.WaitGroup, deadline time.Time) bool {
+	finished := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(finished)
+	}()
+	select {
+	case <-finished:
+		return true
+	case <-time.After(time.Until(deadline)):
+		return false
+	}
+}
+
+// shutdown stops accepting connections, cancels the thumbnail runs, lets uploads in
+// progress and running tool jobs finish within the grace period, then closes what is
+// left: partial chunked transfers are kept on disk so the app can resume them after the
+// restart.
+func shutdown(config *Config) {
+	grace := shutdownGrace(config)
+	deadline := time.Now().Add(grace)
+	log.Printf("Shutting down: no new connections, giving uploads and tool jobs up to %v to finish", grace)
+
+	shutdownMutex.Lock()
+	close(drainingCh)
+	stops := stopAccepting
+	conns := make([]*syncConnState, 0, len(syncConns))
+	for c := range syncConns {
+		conns = append(conns, c)
+	}
+	shutdownMutex.Unlock()
+
+	ctx, cancel := context.WithDeadline(context.Background(), deadline)
+	defer cancel()
+	var servers sync.WaitGroup
+	for _, stop := range stops {
+		servers.Add(1)
+		go func(stop func(ctx context.Context)) {
+			defer servers.Done()
+			stop(ctx)
+		}(stop)
+	}
+	for _, c := range conns {
+		c.closeIfIdle()
+	}
+
+	if !waitTimeout(&syncConnsGroup, deadline) {
+		shutdownMutex.Lock()
+		log.Printf("Grace period over, closing %d sync connection(s) and keeping their partial transfers", len(syncConns))
+		for c := range syncConns {
+			c.conn.Close()
+		}
+		shutdownMutex.Unlock()
+		// Give the handlers a moment to save their transfers
+		waitTimeout(&syncConnsGroup, time.Now().Add(5*time.Second))
+	}
+
+	stopPhoneJobs(deadline)
+	for len(runningTools()) > 0 && time.Now().Before(deadline) {
+		time.Sleep(100 * time.Millisecond)
+	}
+	killAllTools()
+
+	waitTimeout(&servers, deadline)
+	flushBatchedSyncs()
+	log.Println("Writing catalogs...")
+	flushCatalogs()
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// MediaSource is the provenance of a stored file: how it arrived and what the client said
+// about it. Recorded in the catalog entry, served as part of the file's sidecar.
+type MediaSource struct {
+	Via      string `json:"via"`               // "tcp", "http", "grpc" or "import"
+	ClientID string `json:"clientId"`          // the ID the client sent, its name on the phone (for imports, the path in the imported folder)
+	Media    string `json:"media,omitempty"`   // the media type the client sent
+	Device   string `json:"device,omitempty"`  // registered device ID
+	Session  string `json:"session,omitempty"` // sync session, as listed on the sync sessions page
+	Remote   string `json:"remote,omitempty"`  // client address
+	Taken    string `json:"taken,omitempty"`   // capture time as the client sent it
+	MTime    string `json:"mtime,omitempty"`   // modification time on the phone as the client sent it
+}
+
+// source describes a file the session is storing
+func (s *syncSession) source(id, media, taken, mtime string) *MediaSource {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	src := &MediaSource{Via: s.via, ClientID: id, Media: media, Device: s.deviceID,
+		Remote: s.progress.Remote, Taken: taken, MTime: mtime}
+	// Only TCP syncs are kept in the sync history, see recordSyncHistory
+	if s.via == "tcp" {
+		src.Session = syncSessionID(s.progress.Started)
+	}
+	return src
+}
+
+// Sidecar is the metadata of one original for external tools (PhotoPrism, digiKam and
+// the like) that ingest the library: its identity, capture time, provenance and analysis
+type Sidecar struct {
+	File      string       `json:"file"` // path relative to the phone directory
+	Phone     string       `json:"phone"`
+	SHA256    string       `json:"sha256,omitempty"`
+	Size      int64        `json:"size"`
+	Added     time.Time    `json:"added"`
+	Taken     *time.Time   `json:"taken,omitempty"`
+	TakenZone string       `json:"takenZone,omitempty"`
+	Place     *Place       `json:"place,omitempty"`
+	Favorite  bool         `json:"favorite,omitempty"`
+	Created   bool         `json:"created,omitempty"` // made on the server, not synced
+	Source    *MediaSource `json:"source,omitempty"`
+
+	Analysis map[string]*Analysis `json:"analysis,omitempty"` // what the analysis plugins found, by analyzer
+}
+
+func sidecarFor(phone string, e *CatalogEntry) Sidecar {
+	return Sidecar{File: e.Name, Phone: phone, SHA256: e.SHA256, Size: e.Size, Added: e.Added,
+		Taken: e.Taken, TakenZone: e.TakenZone, Place: e.Place, Favorite: e.Favorite,
+		Created: e.Created, Source: e.Source, Analysis: e.Analysis}
+}
+
+// registerSidecarRoutes serves the sidecars of a phone's originals: all of them, for
+// tools importing the library, or one by its file name
+func registerSidecarRoutes(router *mux.Router, config *Config) {
+	router.HandleFunc("/api/phones/{phoneName}/sidecars", func(w http.ResponseWriter, r *http.Request) {
+		phoneName := mux.Vars(r)["phoneName"]
+		phoneDir, err := phoneDirFromRequest(config, r)
+		if err != nil {
+			writeJSON(w, map[string]interface{}{"success": false, "error": "Invalid phone name"})
+			return
+		}
+		entries := openCatalog(phoneDir).AllEntries()
+		sort.Slice(entries, func(i, j int) bool { return entries[i].Name < entries[j].Name })
+		sidecars := make([]Sidecar, 0, len(entries))
+		for i := range entries {
+			sidecars = append(sidecars, sidecarFor(phoneName, &entries[i]))
+		}
+		countFeature("sidecars")
+		writeJSON(w, map[string]interface{}{"success": true, "sidecars": sidecars})
+	}).Methods("GET")
+
+	// One file's sidecar, by its path in the phone directory or its thumbnail name, as
+	// <file>.json like the media library's sidecar files
+	router.HandleFunc("/api/phones/{phoneName}/sidecars/{file:.+}.json", func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		phoneDir, err := phoneDirFromRequest(config, r)
+		if err != nil || strings.Contains(vars["file"], "..") {
+			http.Error(w, "Invalid name", http.StatusBadRequest)
+			return
+		}
+		catalog := openCatalog(phoneDir)
+		path := catalog.Path(vars["file"])
+		if orig, ok := originalForThumbnail(phoneDir, vars["file"]); ok && strings.HasPrefix(vars["file"], "tbn-") {
+			path = orig
+		}
+		e, ok := catalog.Entry(path)
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		writeJSON(w, sidecarFor(vars["phoneName"], &e))
+	}).Methods("GET")
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Thresholds of the built-in smart views
+const (
+	recentlyAddedWindow = 7 * 24 * time.Hour
+	largeFileSize       = 100 << 20
+	longVideoSeconds    = 5 * 60
+)
+
+// smartView is a built-in gallery view computed from the catalog, pinned on the phone page
+type smartView struct {
+	ID    string
+	Title string
+	// match selects catalog entries; path is the original's location
+	match func(ctx context.Context, catalog *Catalog, path string, e *CatalogEntry) bool
+	// less orders the matching entries, most interesting first
+	less func(a, b *CatalogEntry) bool
+}
+
+// smartViews are the views offered on the phone page. "Recently deleted" isn't listed: it
+// has its own page, see trash.go.
+var smartViews = []smartView{
+	{
+		ID:    "recent",
+		Title: "Added in last 7 days",
+		match: func(ctx context.Context, catalog *Catalog, path string, e *CatalogEntry) bool {
+			return clock.Since(entryAdded(e)) <= recentlyAddedWindow
+		},
+		less: func(a, b *CatalogEntry) bool { return entryAdded(a).After(entryAdded(b)) },
+	},
+	{
+		ID:    "large",
+		Title: "Large files > 100MB",
+		match: func(ctx context.Context, catalog *Catalog, path string, e *CatalogEntry) bool {
+			return e.Size > largeFileSize
+		},
+		less: func(a, b *CatalogEntry) bool { return a.Size > b.Size },
+	},
+	{
+		ID:    "long",
+		Title: "Videos longer than 5 min",
+		match: func(ctx context.Context, catalog *Catalog, path string, e *CatalogEntry) bool {
+			return hasExtension(path, videoExtensions) && catalog.VideoDuration(ctx, path) > longVideoSeconds
+		},
+		less: func(a, b *CatalogEntry) bool { return *a.Duration > *b.Duration },
+	},
+}
+
+// findSmartView returns the smart view with the given ID
+func findSmartView(id string) (smartView, bool) {
+	for _, v := range smartViews {
+		if v.ID == id {
+			return v, true
+		}
+	}
+	return smartView{}, false
+}
+
+// entryAdded is when a file was added to the library; catalogs written before this was
+// recorded fall back to the file's modification time
+func entryAdded(e *CatalogEntry) time.Time {
+	if !e.Added.IsZero() {
+		return e.Added
+	}
+	return e.ModTime
+}
+
+// applySmartView keeps the gallery items (thumbnail or video names) whose originals are in
+// the view, ordered as the view sorts them
+func applySmartView(ctx context.Context, view smartView, phoneDir string, items []string) []string {
+	catalog := openCatalog(phoneDir)
+	type match struct {
+		item  string
+		entry CatalogEntry
+	}
+	var matches []match
+	for _, item := range items {
+		orig, ok := originalForThumbnail(phoneDir, item)
+		if !ok {
+			continue
+		}
+		e, ok := catalog.Entry(orig)
+		if !ok || !view.match(ctx, catalog, orig, &e) {
+			continue
+		}
+		// match may have probed the file; take the entry as it is now
+		if e, ok = catalog.Entry(orig); ok {
+			matches = append(matches, match{item, e})
+		}
+	}
+	sort.SliceStable(matches, func(i, j int) bool { return view.less(&matches[i].entry, &matches[j].entry) })
+
+	result := make([]string, len(matches))
+	for i, m := range matches {
+		result[i] = m.item
+	}
+	return result
+}
+
+// probeVideoDuration returns the length of a video in seconds
+func probeVideoDuration(ctx context.Context, path string) (float64, error) {
+	output, err := runTool(ctx, videoProbeTimeout, "ffprobe",
+		"-v", "error",
+		"-show_entries", "format=duration",
+		"-of", "csv=p=0",
+		path)
+	if err != nil {
+		return 0, fmt.Errorf("ffprobe failed: %v, output: %s", err, string(output))
+	}
+	seconds, err := strconv.ParseFloat(strings.TrimSpace(string(output)), 64)
+	if err != nil {
+		return 0, fmt.Errorf("unexpected ffprobe output %q", strings.TrimSpace(string(output)))
+	}
+	return seconds, nil
+}
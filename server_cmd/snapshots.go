@@ -0,0 +1,299 @@
+package main
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	defaultSnapshotDays     = 7
+	defaultSnapshotInterval = 24 * time.Hour
+	defaultSnapshotKeep     = 7
+	// snapshotCheckInterval is how often the scheduler looks whether a phone is due
+	snapshotCheckInterval = time.Hour
+)
+
+// SnapshotConfig packs each phone's recent media into a dated ZIP archive on a schedule,
+// e.g. into a folder a cloud sync client uploads. Archives are written to
+// <dir>/<phone>/<phone>-<date>.zip; the oldest are removed beyond keep.
+type SnapshotConfig struct {
+	Dir           string   `json:"dir"`
+	Days          int      `json:"days"`           // media received in the last days, default 7
+	IntervalHours int      `json:"interval_hours"` // between snapshots of a phone, default 24
+	Keep          int      `json:"keep"`           // archives kept per phone, default 7
+	Phones        []string `json:"phones"`         // phones to snapshot, empty for all
+}
+
+// SnapshotArchive is one archive written or found in the snapshot folder
+type SnapshotArchive struct {
+	Phone    string    `json:"phone"`
+	Name     string    `json:"name"`
+	Files    int       `json:"files,omitempty"` // only known for archives written by this process
+	Bytes    int64     `json:"bytes"`
+	Modified time.Time `json:"modified"`
+}
+
+var snapshotMutex sync.Mutex // one run at a time
+
+func (sc *SnapshotConfig) days() int {
+	if sc.Days <= 0 {
+		return defaultSnapshotDays
+	}
+	return sc.Days
+}
+
+func (sc *SnapshotConfig) interval() time.Duration {
+	if sc.IntervalHours <= 0 {
+		return defaultSnapshotInterval
+	}
+	return time.Duration(sc.IntervalHours) * time.Hour
+}
+
+func (sc *SnapshotConfig) keep() int {
+	if sc.Keep <= 0 {
+		return defaultSnapshotKeep
+	}
+	return sc.Keep
+}
+
+func (sc *SnapshotConfig) phones(baseDir string) []string {
+	if len(sc.Phones) == 0 {
+		return libraryPhones(baseDir)
+	}
+	var phones []string
+	for _, phone := range sc.Phones {
+		if info, err := os.Stat(filepath.Join(baseDir, phone)); err == nil && info.IsDir() {
+			phones = append(phones, phone)
+		}
+	}
+	return phones
+}
+
+// snapshotArchives lists a phone's archives in the snapshot folder, oldest first
+func snapshotArchives(dir, phone string) []SnapshotArchive {
+	matches, _ := filepath.Glob(filepath.Join(dir, phone, phone+"-*.zip"))
+	sort.Strings(matches) // dated names sort by date
+	list := make([]SnapshotArchive, 0, len(matches))
+	for _, path := range matches {
+		if info, err := os.Stat(path); err == nil {
+			list = append(list, SnapshotArchive{Phone: phone, Name: filepath.Base(path), Bytes: info.Size(), Modified: info.ModTime()})
+		}
+	}
+	return list
+}
+
+// writeSnapshot packs the given originals of a phone into a ZIP at path. Media is stored
+// as is; it is compressed already. The archive is written under a hidden .partial name
+// and renamed when complete, so a sync client never uploads half an archive.
+func writeSnapshot(path, phoneDir string, entries []CatalogEntry) (int64, error) {
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*"+partialFileSuffix)
+	if err != nil {
+		return 0, err
+	}
+	fail := func(err error) (int64, error) {
+		f.Close()
+		os.Remove(f.Name())
+		return 0, err
+	}
+
+	zw := zip.NewWriter(f)
+	for _, e := range entries {
+		if draining() {
+			return fail(fmt.Errorf("server shutting down"))
+		}
+		src, err := os.Open(entryPath(phoneDir, &e))
+		if os.IsNotExist(err) {
+			continue // deleted meanwhile
+		}
+		if err != nil {
+			return fail(err)
+		}
+		info, err := src.Stat()
+		if err == nil {
+			var w io.Writer
+			w, err = zw.CreateHeader(&zip.FileHeader{Name: e.Name, Method: zip.Store, Modified: info.ModTime()})
+			if err == nil {
+				_, err = io.Copy(w, src)
+			}
+		}
+		src.Close()
+		if err != nil {
+			return fail(fmt.Errorf("%s: %w", e.Name, err))
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return fail(err)
+	}
+	if err := f.Sync(); err != nil {
+		return fail(err)
+	}
+	size, _ := f.Seek(0, io.SeekCurrent)
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return 0, err
+	}
+	if err := os.Chmod(f.Name(), 0o644); err != nil {
+		os.Remove(f.Name())
+		return 0, err
+	}
+	if err := os.Rename(f.Name(), path); err != nil {
+		os.Remove(f.Name())
+		return 0, err
+	}
+	syncDir(filepath.Dir(path))
+	return size, nil
+}
+
+// snapshotPhone writes today's archive of a phone's recent media and rotates the old
+// ones. A phone without recent media gets no archive.
+func snapshotPhone(sc *SnapshotConfig, baseDir, phone string) (*SnapshotArchive, error) {
+	phoneDir := filepath.Join(baseDir, phone)
+	since := clock.Now().Add(-time.Duration(sc.days()) * 24 * time.Hour)
+	var recent []CatalogEntry
+	var total int64
+	for _, e := range openCatalog(phoneDir).AllEntries() {
+		if e.Added.After(since) && e.Damaged == "" && !e.Archived {
+			recent = append(recent, e)
+			total += e.Size
+		}
+	}
+	if len(recent) == 0 {
+		return nil, nil
+	}
+	sort.Slice(recent, func(i, j int) bool { return recent[i].Name < recent[j].Name })
+
+	dir := filepath.Join(sc.Dir, phone)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	if free, err := diskFreeBytes(dir); err == nil && free < uint64(total) {
+		return nil, fmt.Errorf("%s needs %s, only %s free in %s", phone, formatBytes(total), formatBytes(int64(free)), dir)
+	}
+	name := fmt.Sprintf("%s-%s.zip", phone, clock.Now().Format("2006-01-02"))
+	size, err := writeSnapshot(filepath.Join(dir, name), phoneDir, recent)
+	if err != nil {
+		return nil, err
+	}
+
+	archives := snapshotArchives(sc.Dir, phone)
+	for len(archives) > sc.keep() {
+		old := filepath.Join(dir, archives[0].Name)
+		if err := os.Remove(old); err != nil {
+			log.Printf("Snapshots: failed to remove %s: %v", old, err)
+		} else {
+			log.Printf("Snapshots: removed old archive %s", old)
+		}
+		archives = archives[1:]
+	}
+	return &SnapshotArchive{Phone: phone, Name: name, Files: len(recent), Bytes: size, Modified: clock.Now()}, nil
+}
+
+// runSnapshots snapshots the phones that are due, or all of them when force is set
+func runSnapshots(config *Config, force bool) ([]SnapshotArchive, []string) {
+	snapshotMutex.Lock()
+	defer snapshotMutex.Unlock()
+
+	sc := config.Snapshots
+	baseDir := config.ReceiveDir
+	if baseDir == "" {
+		baseDir = "received"
+	}
+	written := []SnapshotArchive{}
+	var errs []string
+	for _, phone := range sc.phones(baseDir) {
+		if archives := snapshotArchives(sc.Dir, phone); !force && len(archives) > 0 {
+			if clock.Since(archives[len(archives)-1].Modified) < sc.interval() {
+				continue
+			}
+		}
+		started := time.Now()
+		a, err := snapshotPhone(sc, baseDir, phone)
+		if err != nil {
+			log.Printf("Snapshots: %s: %v", phone, err)
+			errs = append(errs, fmt.Sprintf("%s: %v", phone, err))
+			continue
+		}
+		if a != nil {
+			log.Printf("Snapshots: wrote %s (%d file(s), %s) in %v", filepath.Join(sc.Dir, phone, a.Name), a.Files,
+				formatBytes(a.Bytes), time.Since(started).Round(time.Millisecond))
+			written = append(written, *a)
+		}
+	}
+	return written, errs
+}
+
+// checkSnapshots validates the snapshot config and creates its folder
+func checkSnapshots(config *Config) error {
+	sc := config.Snapshots
+	if sc == nil {
+		return nil
+	}
+	if sc.Dir == "" {
+		return fmt.Errorf("no dir set")
+	}
+	return os.MkdirAll(sc.Dir, 0o755)
+}
+
+// startSnapshots writes the snapshots whenever a phone's newest archive is older than
+// the interval, checking at startup and then hourly
+func startSnapshots(config *Config) {
+	sc := config.Snapshots
+	if sc == nil {
+		return
+	}
+	log.Printf("Snapshots enabled: last %d day(s) every %v into %s, keeping %d per phone", sc.days(), sc.interval(), sc.Dir, sc.keep())
+
+	runSnapshots(config, false)
+	ticker := clock.NewTicker(snapshotCheckInterval)
+	defer ticker.Stop()
+	for range ticker.C() {
+		runSnapshots(config, false)
+	}
+}
+
+// registerSnapshotRoutes adds the list of snapshot archives and a way to write them now
+func registerSnapshotRoutes(router *mux.Router, config *Config) {
+	router.HandleFunc("/api/snapshots", func(w http.ResponseWriter, r *http.Request) {
+		sc := config.Snapshots
+		if sc == nil {
+			writeJSON(w, map[string]interface{}{"success": true, "enabled": false})
+			return
+		}
+		baseDir := config.ReceiveDir
+		if baseDir == "" {
+			baseDir = "received"
+		}
+		archives := []SnapshotArchive{}
+		for _, phone := range sc.phones(baseDir) {
+			archives = append(archives, snapshotArchives(sc.Dir, phone)...)
+		}
+		writeJSON(w, map[string]interface{}{"success": true, "enabled": true, "dir": sc.Dir, "days": sc.days(),
+			"keep": sc.keep(), "archives": archives})
+	}).Methods("GET")
+
+	// Snapshots every phone now, whether due or not
+	handleTransfer(router, "/api/snapshots/run", func(w http.ResponseWriter, r *http.Request) {
+		if config.Snapshots == nil {
+			writeJSON(w, map[string]interface{}{"success": false, "error": "No snapshots configured"})
+			return
+		}
+		written, errs := runSnapshots(config, true)
+		countFeature("snapshot_run")
+		resp := map[string]interface{}{"success": len(errs) == 0, "written": written}
+		if len(errs) > 0 {
+			resp["error"] = strings.Join(errs, "; ")
+		}
+		writeJSON(w, resp)
+	}).Methods("POST")
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// statsCacheTTL bounds how often the library is rescanned for status queries
+const statsCacheTTL = 30 * time.Second
+
+// activeConnections counts open TCP sync connections
+var activeConnections int64
+
+// LibraryStats is a summary of the library and server state for status queries
+type LibraryStats struct {
+	ServerName        string  `json:"server_name"`
+	Version           string  `json:"version"`
+	Phones            int     `json:"phones"`
+	Photos            int     `json:"photos"`
+	Videos            int     `json:"videos"`
+	TotalItems        int     `json:"total_items"`
+	TotalBytes        int64   `json:"total_bytes"`
+	FreeBytes         uint64  `json:"free_bytes"`
+	LoadAverage       float64 `json:"load_average"`
+	ActiveConnections int64   `json:"active_connections"`
+}
+
+var (
+	statsMutex     sync.Mutex
+	statsCache     LibraryStats
+	statsCacheTime time.Time
+)
+
+// collectLibraryStats counts the originals of every phone in baseDir.
+// Library totals are cached for statsCacheTTL; load and connection counts are always fresh.
+func collectLibraryStats(config *Config) LibraryStats {
+	baseDir := config.ReceiveDir
+	if baseDir == "" {
+		baseDir = "received"
+	}
+
+	statsMutex.Lock()
+	defer statsMutex.Unlock()
+
+	if clock.Since(statsCacheTime) > statsCacheTTL {
+		stats := LibraryStats{}
+		for _, phone := range libraryPhones(baseDir) {
+			stats.Phones++
+			// Originals may be filed in <year>/<month> directories or on pool volumes
+			for _, dir := range phoneMediaDirs(filepath.Join(baseDir, phone)) {
+				files, err := os.ReadDir(dir)
+				if err != nil {
+					continue
+				}
+				for _, f := range files {
+					if f.IsDir() {
+						continue
+					}
+					isPhoto := hasExtension(f.Name(), photoExtensions)
+					isVideo := hasExtension(f.Name(), videoExtensions)
+					if !isPhoto && !isVideo {
+						continue
+					}
+					if isPhoto {
+						stats.Photos++
+					} else {
+						stats.Videos++
+					}
+					if info, err := f.Info(); err == nil {
+						stats.TotalBytes += info.Size()
+					}
+				}
+			}
+		}
+		stats.TotalItems = stats.Photos + stats.Videos
+		if free, err := diskFreeBytes(baseDir); err == nil {
+			stats.FreeBytes = free
+		}
+		statsCache = stats
+		statsCacheTime = clock.Now()
+	}
+
+	stats := statsCache
+	stats.ServerName = config.ServerName
+	stats.Version = version
+	stats.LoadAverage = loadAverage()
+	stats.ActiveConnections = atomic.LoadInt64(&activeConnections)
+	return stats
+}
+
+// loadAverage returns the 1-minute load average, or 0 where /proc/loadavg isn't available
+func loadAverage() float64 {
+	b, err := os.ReadFile("/proc/loadavg")
+	if err != nil {
+		return 0
+	}
+	fields := strings.Fields(string(b))
+	if len(fields) == 0 {
+		return 0
+	}
+	load, _ := strconv.ParseFloat(fields[0], 64)
+	return load
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLibraryStatsCountsDatedFiles(t *testing.T) {
+	baseDir := t.TempDir()
+	for name, size := range map[string]int{
+		"Pixel/IMG_1.jpg":            10,
+		"Pixel/2024/05/IMG_2.jpg":    20,
+		"Pixel/2024/05/VID_3.mp4":    30,
+		"Pixel/thumbnails/IMG_1.jpg": 5,
+		"Music/song.mp3":             40,
+		".trash/IMG_4.jpg":           50,
+	} {
+		path := filepath.Join(baseDir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, make([]byte, size), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	useFakeClock(t, time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC))
+	statsMutex.Lock()
+	statsCacheTime = time.Time{}
+	statsMutex.Unlock()
+
+	stats := collectLibraryStats(&Config{ReceiveDir: baseDir})
+	if stats.Phones != 1 || stats.Photos != 2 || stats.Videos != 1 || stats.TotalBytes != 60 {
+		t.Errorf("stats = %d phones, %d photos, %d videos, %d bytes, want 1, 2, 1, 60",
+			stats.Phones, stats.Photos, stats.Videos, stats.TotalBytes)
+	}
+}
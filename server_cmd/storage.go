@@ -0,0 +1,534 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// storageLargestFiles is how many of the largest files the storage page lists
+const storageLargestFiles = 50
+
+// storageGroup is one tile of the storage treemap
+type storageGroup struct {
+	Key   string `json:"key"`
+	Bytes int64  `json:"bytes"`
+	Files int    `json:"files"`
+}
+
+// storageFile is one of the largest files in the current drill-down
+type storageFile struct {
+	Phone string `json:"phone"`
+	Name  string `json:"name"` // catalog name inside the phone directory
+	Bytes int64  `json:"bytes"`
+	Type  string `json:"type"`
+	Month string `json:"month"`
+	Video bool   `json:"video"`
+}
+
+// StorageBreakdown is the library's disk use by phone, capture month and file type,
+// limited to the files matching the drill-down filters
+type StorageBreakdown struct {
+	Bytes   int64          `json:"bytes"`
+	Files   int            `json:"files"`
+	ByPhone []storageGroup `json:"byPhone"`
+	ByMonth []storageGroup `json:"byMonth"`
+	ByType  []storageGroup `json:"byType"`
+	Largest []storageFile  `json:"largest"`
+}
+
+// storageFilter narrows the breakdown; empty fields match everything
+type storageFilter struct {
+	Phone string
+	Month string // "2006-01"
+	Type  string // upper-case extension, e.g. "HEIC"
+}
+
+// storageType is the type a file is counted under: its extension
+func storageType(name string) string {
+	return strings.ToUpper(strings.TrimPrefix(filepath.Ext(name), "."))
+}
+
+// libraryPhones lists the phone directories of the library
+func libraryPhones(baseDir string) []string {
+	entries, err := os.ReadDir(baseDir)
+	if err != nil {
+		return nil
+	}
+	var phones []string
+	for _, e := range entries {
+		if e.IsDir() && !presetFolders[strings.ToLower(e.Name())] && !strings.HasPrefix(e.Name(), ".") {
+			phones = append(phones, e.Name())
+		}
+	}
+	return phones
+}
+
+// collectStorageBreakdown adds up the cataloged originals of every phone
+func collectStorageBreakdown(baseDir string, filter storageFilter) StorageBreakdown {
+	var b StorageBreakdown
+	byPhone := make(map[string]*storageGroup)
+	byMonth := make(map[string]*storageGroup)
+	byType := make(map[string]*storageGroup)
+	add := func(groups map[string]*storageGroup, key string, size int64) {
+		g, ok := groups[key]
+		if !ok {
+			g = &storageGroup{Key: key}
+			groups[key] = g
+		}
+		g.Bytes += size
+		g.Files++
+	}
+
+	for _, phone := range libraryPhones(baseDir) {
+		if filter.Phone != "" && phone != filter.Phone {
+			continue
+		}
+		for _, e := range openCatalog(filepath.Join(baseDir, phone)).AllEntries() {
+			if e.Archived {
+				continue // takes no room in the library
+			}
+			taken := e.ModTime
+			if e.Taken != nil {
+				taken = *e.Taken
+			}
+			f := storageFile{Phone: phone, Name: e.Name, Bytes: e.Size, Type: storageType(e.Name),
+				Month: taken.Format("2006-01"), Video: hasExtension(e.Name, videoExtensions)}
+			if (filter.Month != "" && f.Month != filter.Month) || (filter.Type != "" && f.Type != filter.Type) {
+				continue
+			}
+			b.Bytes += f.Bytes
+			b.Files++
+			add(byPhone, f.Phone, f.Bytes)
+			add(byMonth, f.Month, f.Bytes)
+			add(byType, f.Type, f.Bytes)
+			b.Largest = append(b.Largest, f)
+		}
+	}
+
+	sorted := func(groups map[string]*storageGroup) []storageGroup {
+		list := make([]storageGroup, 0, len(groups))
+		for _, g := range groups {
+			list = append(list, *g)
+		}
+		sort.Slice(list, func(i, j int) bool { return list[i].Bytes > list[j].Bytes })
+		return list
+	}
+	b.ByPhone, b.ByMonth, b.ByType = sorted(byPhone), sorted(byMonth), sorted(byType)
+	sort.Slice(b.Largest, func(i, j int) bool { return b.Largest[i].Bytes > b.Largest[j].Bytes })
+	if len(b.Largest) > storageLargestFiles {
+		b.Largest = b.Largest[:storageLargestFiles]
+	}
+	return b
+}
+
+// transcodeVideo re-encodes a video as H.264 of at most 1080p next to the original, then
+// moves the original to the trash (so it can still be restored) and returns how many bytes
+// that saved. Videos that wouldn't get smaller are left alone.
+func transcodeVideo(ctx context.Context, phoneDir, path string) (int64, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0, err
+	}
+	dest := strings.TrimSuffix(path, filepath.Ext(path)) + ".mp4"
+	if dest != path {
+		if _, err := os.Stat(dest); err == nil {
+			return 0, fmt.Errorf("%s already exists", filepath.Base(dest))
+		}
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".upload_*.tmp")
+	if err != nil {
+		return 0, err
+	}
+	tmp.Close()
+	defer os.Remove(tmp.Name())
+
+	output, err := runTool(ctx, videoTrimTimeout, "ffmpeg",
+		"-y",
+		"-i", path,
+		"-map", "0:v:0",
+		"-map", "0:a?",
+		"-vf", "scale='min(1920,iw)':'min(1920,ih)':force_original_aspect_ratio=decrease,scale=trunc(iw/2)*2:trunc(ih/2)*2",
+		"-c:v", "libx264",
+		"-preset", x264Preset("slow"),
+		"-threads", x264Threads(),
+		"-crf", "26",
+		"-pix_fmt", "yuv420p",
+		"-c:a", "aac",
+		"-b:a", "128k",
+		"-map_metadata", "0",
+		"-movflags", "+faststart",
+		"-f", "mp4",
+		tmp.Name())
+	if err != nil {
+		return 0, fmt.Errorf("ffmpeg failed: %v, output: %s", err, string(output))
+	}
+	small, err := os.Stat(tmp.Name())
+	if err != nil {
+		return 0, err
+	}
+	if small.Size() >= info.Size() {
+		return 0, fmt.Errorf("already compact, transcoding would not save space")
+	}
+
+	catalog := openCatalog(phoneDir)
+	trashName := catalog.catalogName(path)
+	if err := catalog.MoveToTrash(path); err != nil {
+		return 0, err
+	}
+	if err := os.Rename(tmp.Name(), dest); err != nil {
+		catalog.RestoreFromTrash(trashName)
+		return 0, err
+	}
+	os.Chmod(dest, 0o644)
+	os.Chtimes(dest, info.ModTime(), info.ModTime())
+	sum, err := calculateSHA256(dest)
+	if err == nil {
+		catalog.Record(dest, sum)
+		catalog.CopyUserFieldsFromTrash(trashName, dest)
+	}
+	if dest != path {
+		notifyMediaChange(phoneDir, mediaChangeDeleted, path)
+		notifyMediaChange(phoneDir, mediaChangeAdded, dest)
+	}
+	return info.Size() - small.Size(), nil
+}
+
+// registerStorageRoutes adds the storage breakdown page, its API and the space-reclaiming actions
+func registerStorageRoutes(router *mux.Router, config *Config) {
+	baseDirFor := func() string {
+		if config.ReceiveDir == "" {
+			return "received"
+		}
+		return config.ReceiveDir
+	}
+
+	router.HandleFunc("/api/storage", func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		filter := storageFilter{Phone: q.Get("phone"), Month: q.Get("month"), Type: q.Get("type")}
+		b := collectStorageBreakdown(baseDirFor(), filter)
+		_, ffmpegErr := tools.LookPath("ffmpeg")
+		writeJSON(w, map[string]interface{}{
+			"success":   true,
+			"breakdown": b,
+			"actions": map[string]bool{
+				"trash":     true,
+				"transcode": ffmpegErr == nil,
+				"archive":   config.ArchiveDir != "",
+			},
+		})
+	}).Methods("GET")
+
+	handleTransfer(router, "/api/storage/action", func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Action string `json:"action"` // trash, transcode or archive
+			Phone  string `json:"phone"`
+			Name   string `json:"name"` // catalog name, as listed by /api/storage
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeJSON(w, map[string]interface{}{"success": false, "error": "Invalid request: " + err.Error()})
+			return
+		}
+		if validatePhoneName(req.Phone) != nil ||
+			req.Name == "" || strings.Contains(req.Name, "..") || strings.Contains(req.Name, "\\") || strings.HasPrefix(req.Name, "/") {
+			writeJSON(w, map[string]interface{}{"success": false, "error": "Invalid file"})
+			return
+		}
+		phoneDir := filepath.Join(baseDirFor(), req.Phone)
+		path := openCatalog(phoneDir).Path(req.Name)
+		if _, err := os.Stat(path); err != nil {
+			writeJSON(w, map[string]interface{}{"success": false, "error": "File not found"})
+			return
+		}
+
+		var saved int64
+		var err error
+		switch req.Action {
+		case "trash":
+			var info os.FileInfo
+			if info, err = os.Stat(path); err == nil {
+				if err = openCatalog(phoneDir).MoveToTrash(path); err == nil {
+					os.Remove(filepath.Join(filepath.Dir(path), "thumbnails", thumbnailName(filepath.Base(path))))
+					notifyMediaChange(phoneDir, mediaChangeDeleted, path)
+					saved = info.Size()
+				}
+			}
+		case "transcode":
+			if !hasExtension(path, videoExtensions) {
+				err = fmt.Errorf("only videos can be transcoded")
+			} else {
+				saved, err = transcodeVideo(r.Context(), phoneDir, path)
+			}
+		case "archive":
+			var info os.FileInfo
+			if info, err = os.Stat(path); err == nil {
+				if err = archiveFile(phoneDir, req.Name); err == nil {
+					saved = info.Size()
+				}
+			}
+		default:
+			err = fmt.Errorf("unknown action %q", req.Action)
+		}
+		if err != nil {
+			log.Printf("Storage %s of %s failed: %v", req.Action, path, err)
+			writeJSON(w, map[string]interface{}{"success": false, "error": err.Error()})
+			return
+		}
+		countFeature("storage_" + req.Action)
+		log.Printf("Storage %s of %s freed %d bytes", req.Action, path, saved)
+		writeJSON(w, map[string]interface{}{"success": true, "saved": saved})
+	}).Methods("POST")
+
+	router.HandleFunc("/storage", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if err := storagePageTemplate.Execute(w, nil); err != nil {
+			log.Printf("Error rendering storage page: %v", err)
+		}
+	}).Methods("GET")
+}
+
+var storagePageTemplate = template.Must(template.New("storage").Parse(`<!DOCTYPE html>
+<html>
+<head>
+    <title>Storage - Photo Sync Server</title>
+    <style>
+        body { font-family: 'Segoe UI', Tahoma, Arial, sans-serif; margin: 0; padding: 20px; background: #000000; color: #ffffff; }
+        h1 { color: #ffffff; font-weight: 300; letter-spacing: 1px; }
+        .back-link { display: inline-block; margin-bottom: 20px; color: #88aaff; text-decoration: none; font-size: 14px; }
+        .back-link:hover { color: #aaccff; text-decoration: underline; }
+        .summary { color: #aaaaaa; font-size: 14px; margin-bottom: 12px; }
+        .filters { display: flex; flex-wrap: wrap; gap: 8px; margin-bottom: 12px; min-height: 28px; }
+        .chip { background: #667eea; color: #ffffff; border-radius: 14px; padding: 4px 12px; font-size: 13px; cursor: pointer; }
+        .chip:hover { background: #5a6fd6; }
+        .tabs { display: flex; gap: 8px; margin-bottom: 10px; }
+        .tabs button, .actions button { background: #1a1a1a; color: #ffffff; border: 1px solid #333333; border-radius: 4px; padding: 6px 14px; cursor: pointer; font-size: 13px; }
+        .tabs button.active { background: #667eea; border-color: #667eea; }
+        .actions button:hover, .tabs button:hover { background: #2a2a2a; }
+        #treemap { position: relative; width: 100%; height: 420px; background: #111111; border-radius: 6px; overflow: hidden; }
+        .tile { position: absolute; box-sizing: border-box; border: 2px solid #000000; padding: 6px; overflow: hidden; cursor: pointer; font-size: 12px; color: #ffffff; }
+        .tile:hover { filter: brightness(1.2); }
+        .tile .label { font-weight: 600; }
+        .tile .size { opacity: 0.8; }
+        table { border-collapse: collapse; width: 100%; font-size: 13px; margin-top: 20px; }
+        th, td { text-align: left; padding: 6px 8px; border-bottom: 1px solid #2a2a2a; }
+        th { color: #888888; font-weight: normal; }
+        td.num { text-align: right; white-space: nowrap; }
+        .actions { white-space: nowrap; }
+        #status { color: #aaaaaa; font-size: 13px; margin-top: 8px; min-height: 18px; }
+    </style>
+</head>
+<body>
+    <a href="/" class="back-link">← Back to Home</a>
+    <h1>💽 Storage</h1>
+    <div class="summary" id="summary">Loading…</div>
+    <div class="filters" id="filters"></div>
+    <div class="tabs">
+        <button data-group="byPhone" class="active">By phone</button>
+        <button data-group="byMonth">By month</button>
+        <button data-group="byType">By type</button>
+    </div>
+    <div id="treemap"></div>
+    <div id="status"></div>
+    <h3>Largest files</h3>
+    <table>
+        <thead><tr><th>File</th><th>Phone</th><th>Month</th><th>Type</th><th class="num">Size</th><th></th></tr></thead>
+        <tbody id="largest"></tbody>
+    </table>
+    <script>
+        const groupFilter = { byPhone: 'phone', byMonth: 'month', byType: 'type' };
+        const filterLabels = { phone: 'Phone', month: 'Month', type: 'Type' };
+        const filters = {};
+        let group = 'byPhone';
+        let data = null;
+
+        function formatBytes(n) {
+            if (n >= 1073741824) return (n / 1073741824).toFixed(1) + ' GB';
+            if (n >= 1048576) return (n / 1048576).toFixed(1) + ' MB';
+            if (n >= 1024) return (n / 1024).toFixed(0) + ' KB';
+            return n + ' B';
+        }
+
+        function escapeHTML(s) {
+            const div = document.createElement('div');
+            div.textContent = s;
+            return div.innerHTML;
+        }
+
+        // Squarified treemap: lay the (sorted) items out in rows along the shorter side,
+        // adding to a row while that keeps its tiles closer to square
+        function squarify(items, x, y, w, h, out) {
+            const total = items.reduce((s, it) => s + it.value, 0);
+            if (!items.length || total <= 0) return;
+            const scale = (w * h) / total;
+            let row = [];
+            let rest = items.slice();
+            const worst = (row, side) => {
+                const sum = row.reduce((s, it) => s + it.value * scale, 0);
+                let max = 0;
+                for (const it of row) {
+                    const a = it.value * scale;
+                    max = Math.max(max, (side * side * a) / (sum * sum), (sum * sum) / (side * side * a));
+                }
+                return max;
+            };
+            const side = Math.min(w, h);
+            while (rest.length && (row.length === 0 || worst(row.concat([rest[0]]), side) <= worst(row, side))) {
+                row.push(rest.shift());
+            }
+            const rowArea = row.reduce((s, it) => s + it.value * scale, 0);
+            if (w >= h) {
+                const rw = rowArea / h;
+                let ty = y;
+                for (const it of row) {
+                    const th = (it.value * scale) / rw;
+                    out.push({ item: it, x: x, y: ty, w: rw, h: th });
+                    ty += th;
+                }
+                squarify(rest, x + rw, y, w - rw, h, out);
+            } else {
+                const rh = rowArea / w;
+                let tx = x;
+                for (const it of row) {
+                    const tw = (it.value * scale) / rh;
+                    out.push({ item: it, x: tx, y: y, w: tw, h: rh });
+                    tx += tw;
+                }
+                squarify(rest, x, y + rh, w, h - rh, out);
+            }
+        }
+
+        function renderTreemap() {
+            const box = document.getElementById('treemap');
+            box.innerHTML = '';
+            const groups = (data[group] || []).filter(g => g.bytes > 0);
+            if (!groups.length) {
+                box.innerHTML = '<p style="padding: 20px; color: #888888;">Nothing stored here.</p>';
+                return;
+            }
+            const tiles = [];
+            squarify(groups.map(g => ({ value: g.bytes, group: g })), 0, 0, box.clientWidth, box.clientHeight, tiles);
+            tiles.forEach((t, i) => {
+                const g = t.item.group;
+                const tile = document.createElement('div');
+                tile.className = 'tile';
+                tile.style.left = t.x + 'px';
+                tile.style.top = t.y + 'px';
+                tile.style.width = t.w + 'px';
+                tile.style.height = t.h + 'px';
+                tile.style.background = 'hsl(' + ((i * 47) % 360) + ', 45%, 35%)';
+                tile.title = g.key + ': ' + formatBytes(g.bytes) + ' in ' + g.files + ' file(s)';
+                if (t.w > 50 && t.h > 30) {
+                    tile.innerHTML = '<div class="label">' + escapeHTML(g.key) + '</div><div class="size">' + formatBytes(g.bytes) + '</div>';
+                }
+                tile.onclick = () => {
+                    filters[groupFilter[group]] = g.key;
+                    load();
+                };
+                box.appendChild(tile);
+            });
+        }
+
+        function renderFilters() {
+            const el = document.getElementById('filters');
+            el.innerHTML = '';
+            Object.keys(filters).forEach(key => {
+                const chip = document.createElement('span');
+                chip.className = 'chip';
+                chip.textContent = filterLabels[key] + ': ' + filters[key] + ' ✕';
+                chip.onclick = () => {
+                    delete filters[key];
+                    load();
+                };
+                el.appendChild(chip);
+            });
+        }
+
+        function renderLargest(actions) {
+            const body = document.getElementById('largest');
+            body.innerHTML = '';
+            data.largest.forEach(f => {
+                const tr = document.createElement('tr');
+                tr.innerHTML = '<td>' + escapeHTML(f.name) + '</td><td>' + escapeHTML(f.phone) + '</td><td>' + f.month +
+                    '</td><td>' + escapeHTML(f.type) + '</td><td class="num">' + formatBytes(f.bytes) + '</td><td class="actions"></td>';
+                const cell = tr.querySelector('.actions');
+                const add = (action, label, title) => {
+                    const btn = document.createElement('button');
+                    btn.textContent = label;
+                    btn.title = title;
+                    btn.onclick = () => runAction(action, f, btn);
+                    cell.appendChild(btn);
+                    cell.appendChild(document.createTextNode(' '));
+                };
+                add('trash', '🗑 Trash', 'Move to Recently deleted');
+                if (f.video && actions.transcode) add('transcode', '🎞 Transcode', 'Re-encode as 1080p H.264; the original goes to Recently deleted');
+                if (actions.archive) add('archive', '📦 Archive', 'Move the original to the archive; its thumbnail stays in the gallery');
+                body.appendChild(tr);
+            });
+        }
+
+        function runAction(action, f, btn) {
+            if (action !== 'transcode' && !confirm(action[0].toUpperCase() + action.slice(1) + ' ' + f.name + '?')) {
+                return;
+            }
+            const status = document.getElementById('status');
+            status.textContent = (action === 'transcode' ? 'Transcoding ' : 'Working on ') + f.name + '…';
+            btn.disabled = true;
+            fetch('/api/storage/action', {
+                method: 'POST',
+                headers: { 'Content-Type': 'application/json' },
+                body: JSON.stringify({ action: action, phone: f.phone, name: f.name })
+            })
+            .then(response => response.json())
+            .then(result => {
+                btn.disabled = false;
+                if (!result.success) {
+                    status.textContent = 'Error: ' + result.error;
+                    return;
+                }
+                status.textContent = f.name + ': freed ' + formatBytes(result.saved);
+                load();
+            })
+            .catch(err => {
+                btn.disabled = false;
+                status.textContent = 'Error: ' + err;
+            });
+        }
+
+        function load() {
+            renderFilters();
+            fetch('/api/storage?' + new URLSearchParams(filters))
+            .then(response => response.json())
+            .then(result => {
+                data = result.breakdown;
+                document.getElementById('summary').textContent = formatBytes(data.bytes) + ' in ' + data.files + ' file(s)';
+                renderTreemap();
+                renderLargest(result.actions);
+            })
+            .catch(err => {
+                document.getElementById('summary').textContent = 'Error: ' + err;
+            });
+        }
+
+        document.querySelectorAll('.tabs button').forEach(btn => {
+            btn.onclick = () => {
+                document.querySelectorAll('.tabs button').forEach(b => b.classList.remove('active'));
+                btn.classList.add('active');
+                group = btn.dataset.group;
+                if (data) renderTreemap();
+            };
+        });
+        window.addEventListener('resize', () => { if (data) renderTreemap(); });
+        load();
+    </script>
+</body>
+</html>`))
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// defaultPoolMinFreeMB is the free space below which a volume takes no new originals
+const defaultPoolMinFreeMB = 2048
+
+// StoragePoolsConfig spreads the originals over more volumes than the receive directory's:
+// once it is nearly full, new originals go to the first pool with room, as
+// <dir>/<phone>/<name>. The catalogs, thumbnails and everything else stay in the receive
+// directory; each catalog entry records the pool its file is in, so files are found
+// wherever they landed. Files already stored are never moved between pools.
+type StoragePoolsConfig struct {
+	MinFreeMB int64         `json:"min_free_mb"` // a volume with less free space takes no new originals, default 2048
+	Pools     []StoragePool `json:"pools"`       // used in this order after the receive directory
+}
+
+// StoragePool is one extra volume
+type StoragePool struct {
+	Name string `json:"name"` // recorded in the catalogs, so keep it when the volume is mounted elsewhere
+	Dir  string `json:"dir"`
+}
+
+var (
+	// storagePools is config.StoragePools once checked, nil without pools
+	storagePools *StoragePoolsConfig
+
+	spillMutex  sync.Mutex
+	spillLogged map[string]bool // pools whose first spilled file was logged
+)
+
+// setStoragePools validates the storage pools and enables them
+func setStoragePools(config *Config) error {
+	sp := config.StoragePools
+	if sp == nil || len(sp.Pools) == 0 {
+		return nil
+	}
+	baseDir := config.ReceiveDir
+	if baseDir == "" {
+		baseDir = "received"
+	}
+	absBase, _ := filepath.Abs(baseDir)
+	names := make(map[string]bool)
+	for _, p := range sp.Pools {
+		if p.Name == "" || strings.ContainsAny(p.Name, "/\\") {
+			return fmt.Errorf("invalid pool name %q", p.Name)
+		}
+		if names[p.Name] {
+			return fmt.Errorf("pool name %q used twice", p.Name)
+		}
+		names[p.Name] = true
+		if p.Dir == "" {
+			return fmt.Errorf("pool %s has no dir", p.Name)
+		}
+		abs, err := filepath.Abs(p.Dir)
+		if err != nil {
+			return err
+		}
+		if abs == absBase || strings.HasPrefix(abs, absBase+string(filepath.Separator)) ||
+			strings.HasPrefix(absBase, abs+string(filepath.Separator)) {
+			return fmt.Errorf("pool %s overlaps the receive directory", p.Name)
+		}
+		// A pool that isn't mounted just takes nothing until it is
+		if info, err := os.Stat(p.Dir); err != nil || !info.IsDir() {
+			log.Printf("Storage pool %s: %s is not available", p.Name, p.Dir)
+		}
+	}
+	storagePools = sp
+	log.Printf("Storage pools enabled: %d pool(s) after %s", len(sp.Pools), baseDir)
+	return nil
+}
+
+func (sp *StoragePoolsConfig) minFree() uint64 {
+	if sp.MinFreeMB <= 0 {
+		return defaultPoolMinFreeMB << 20
+	}
+	return uint64(sp.MinFreeMB) << 20
+}
+
+// poolDir returns the directory of a pool, if it is configured
+func poolDir(name string) (string, bool) {
+	if storagePools == nil {
+		return "", false
+	}
+	for _, p := range storagePools.Pools {
+		if p.Name == name {
+			return p.Dir, true
+		}
+	}
+	return "", false
+}
+
+// poolOnline reports whether a pool is configured and its volume is there
+func poolOnline(name string) bool {
+	dir, ok := poolDir(name)
+	if !ok {
+		return false
+	}
+	info, err := os.Stat(dir)
+	return err == nil && info.IsDir()
+}
+
+// poolPhoneDirs returns a phone's directories in the pools, in pool order
+func poolPhoneDirs(phoneDir string) []string {
+	if storagePools == nil {
+		return nil
+	}
+	dirs := make([]string, 0, len(storagePools.Pools))
+	for _, p := range storagePools.Pools {
+		dirs = append(dirs, filepath.Join(p.Dir, filepath.Base(phoneDir)))
+	}
+	return dirs
+}
+
+// poolOf returns the pool holding path, a file of the phone directory phoneDir stored in a
+// pool, and its name relative to the phone's directory there
+func poolOf(phoneDir, path string) (pool, rel string, ok bool) {
+	if storagePools == nil {
+		return "", "", false
+	}
+	for _, p := range storagePools.Pools {
+		r, err := filepath.Rel(filepath.Join(p.Dir, filepath.Base(phoneDir)), path)
+		if err == nil && r != "." && !strings.HasPrefix(r, "..") && !filepath.IsAbs(r) {
+			return p.Name, filepath.ToSlash(r), true
+		}
+	}
+	return "", "", false
+}
+
+// entryPath returns where a catalog entry's file is: in its pool, or in phoneDir
+func entryPath(phoneDir string, e *CatalogEntry) string {
+	if e.Pool != "" {
+		if dir, ok := poolDir(e.Pool); ok {
+			return filepath.Join(dir, filepath.Base(phoneDir), filepath.FromSlash(e.Name))
+		}
+	}
+	return filepath.Join(phoneDir, filepath.FromSlash(e.Name))
+}
+
+// trashedPath returns where a trashed catalog entry's file is kept: in the trash of the
+// phone directory or, for a file from a pool, of the phone's directory in that pool, and
+// for an archived original in the trash of the phone's archive directory
+func trashedPath(phoneDir string, e *CatalogEntry) string {
+	if e.Archived {
+		return archivedTrashPath(phoneDir, e.Name)
+	}
+	if e.Pool != "" {
+		if dir, ok := poolDir(e.Pool); ok {
+			return filepath.Join(dir, filepath.Base(phoneDir), trashDirName, filepath.FromSlash(e.Name))
+		}
+	}
+	return filepath.Join(phoneDir, trashDirName, filepath.FromSlash(e.Name))
+}
+
+// spillPath returns where a new original of size bytes meant for path, inside the phone
+// directory recvDir, is stored: path itself while the receive directory's volume has room,
+// else the same place in the first pool that has. When every volume is full it stays
+// path, and the write fails or the quota's free space check refuses it as before. A file
+// that is stored already is replaced where it is.
+func spillPath(recvDir, path string, size int64) string {
+	sp := storagePools
+	if sp == nil {
+		return path
+	}
+	rel, err := filepath.Rel(recvDir, path)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return path
+	}
+	// A file stored again replaces the copy there is, wherever it is
+	for _, dir := range poolPhoneDirs(recvDir) {
+		if _, err := os.Stat(filepath.Join(dir, rel)); err == nil {
+			return filepath.Join(dir, rel)
+		}
+	}
+	if _, err := os.Stat(path); err == nil {
+		return path
+	}
+	if free, err := diskFreeBytes(recvDir); err != nil || free >= uint64(size)+sp.minFree() {
+		return path
+	}
+	for _, p := range sp.Pools {
+		if !poolOnline(p.Name) {
+			continue
+		}
+		if free, err := diskFreeBytes(p.Dir); err != nil || free < uint64(size)+sp.minFree() {
+			continue
+		}
+		dest := filepath.Join(p.Dir, filepath.Base(recvDir), rel)
+		if err := os.MkdirAll(filepath.Dir(dest), 0o755); err != nil {
+			log.Printf("Storage pool %s: %v", p.Name, err)
+			continue
+		}
+		spillMutex.Lock()
+		if !spillLogged[p.Name] {
+			if spillLogged == nil {
+				spillLogged = make(map[string]bool)
+			}
+			spillLogged[p.Name] = true
+			log.Printf("Receive directory nearly full, storing new originals in pool %s (%s)", p.Name, p.Dir)
+		}
+		spillMutex.Unlock()
+		return dest
+	}
+	return path
+}
+
+// poolFreeBytes returns the most free space on the receive directory's volume or an online
+// pool, where the next original could go, for the quota's free space check. It fails only
+// when none of them could be read.
+func poolFreeBytes(recvDir string) (uint64, error) {
+	free, err := diskFreeBytes(recvDir)
+	if storagePools == nil {
+		return free, err
+	}
+	for _, p := range storagePools.Pools {
+		if !poolOnline(p.Name) {
+			continue
+		}
+		if f, perr := diskFreeBytes(p.Dir); perr == nil {
+			free, err = max(free, f), nil
+		}
+	}
+	return free, err
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// syncProgressInterval is how often a changed progress report is pushed to the client
+const syncProgressInterval = 2 * time.Second
+
+// SyncProgress is the state of one sync connection, sent to the client as msgTypeSyncProgress
+// and listed by /api/sync-status. Byte counts are media bytes, not wire bytes.
+type SyncProgress struct {
+	Phone          string    `json:"phone,omitempty"`
+	Remote         string    `json:"-"` // only shown in the web UI
+	Started        time.Time `json:"started"`
+	BytesReceived  int64     `json:"bytesReceived"`
+	FilesCompleted int       `json:"filesCompleted"`
+	FilesExpected  int       `json:"filesExpected,omitempty"` // from the HAVE_LIST exchange
+	BytesExpected  int64     `json:"bytesExpected,omitempty"` // from the sizes in the HAVE_LIST, when sent
+	CurrentFile    string    `json:"currentFile,omitempty"`   // chunked transfer in progress
+	CurrentBytes   int64     `json:"currentBytes,omitempty"`
+	CurrentTotal   int64     `json:"currentTotal,omitempty"`
+	BytesPerSec    int64     `json:"bytesPerSec"`
+	ETASeconds     int64     `json:"etaSeconds"` // -1 when there is nothing to estimate from
+
+	// Profile is what the client said about its battery and network in HELLO
+	Profile *ClientProfile `json:"profile,omitempty"`
+}
+
+// syncSession tracks the progress of one TCP sync connection
+type syncSession struct {
+	mu        sync.Mutex
+	progress  SyncProgress
+	firstData time.Time
+	changed   bool
+
+	// Outcomes for the SYNC_COMPLETE summary
+	received   int
+	duplicates int
+	failures   map[string]SyncFailure // by ACK kind and ID
+
+	// Provenance of the files stored, see MediaSource
+	via      string
+	deviceID string
+}
+
+var (
+	syncSessionsMutex sync.Mutex
+	syncSessions      = make(map[*syncSession]struct{})
+)
+
+// newSyncSession registers a connection in the live sync status; via is the protocol it
+// uses, "tcp", "http" or "grpc"
+func newSyncSession(remote, via string) *syncSession {
+	s := &syncSession{progress: SyncProgress{Remote: remote, Started: clock.Now()}, via: via}
+	syncSessionsMutex.Lock()
+	syncSessions[s] = struct{}{}
+	syncSessionsMutex.Unlock()
+	return s
+}
+
+// close removes the connection from the live sync status
+func (s *syncSession) close() {
+	syncSessionsMutex.Lock()
+	delete(syncSessions, s)
+	syncSessionsMutex.Unlock()
+}
+
+func (s *syncSession) setPhone(phone string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.progress.Phone != phone {
+		s.progress.Phone = phone
+		s.changed = true
+	}
+}
+
+// setDevice records the registered device the connection belongs to
+func (s *syncSession) setDevice(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.deviceID = id
+}
+
+// setProfile records the client's profile from its HELLO
+func (s *syncSession) setProfile(p *ClientProfile) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.progress.Profile = p
+	s.changed = true
+}
+
+// expect records the files the server is about to receive, as answered to a HAVE_LIST
+func (s *syncSession) expect(items []HaveItem) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.progress.FilesExpected = s.progress.FilesCompleted + len(items)
+	s.progress.BytesExpected = s.progress.BytesReceived
+	for _, item := range items {
+		s.progress.BytesExpected += item.Size
+	}
+	s.changed = true
+}
+
+// addBytes counts received media bytes
+func (s *syncSession) addBytes(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.firstData.IsZero() {
+		s.firstData = clock.Now()
+	}
+	s.progress.BytesReceived += int64(n)
+	s.changed = true
+}
+
+// fileProgress records the state of the chunked transfer in progress
+func (s *syncSession) fileProgress(info *ChunkedFileInfo) {
+	received := int64(info.ReceivedChunks) * int64(info.ChunkSize)
+	if info.TotalSize > 0 && received > info.TotalSize {
+		received = info.TotalSize
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.progress.CurrentFile = info.ID
+	s.progress.CurrentBytes = received
+	s.progress.CurrentTotal = info.TotalSize
+	s.changed = true
+}
+
+// fileDone counts a stored (or deduplicated) file
+func (s *syncSession) fileDone() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.progress.FilesCompleted++
+	s.progress.CurrentFile = ""
+	s.progress.CurrentBytes = 0
+	s.progress.CurrentTotal = 0
+	s.changed = true
+}
+
+// snapshot returns the current progress with the transfer rate and ETA filled in
+func (s *syncSession) snapshot() SyncProgress {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p := s.progress
+	p.ETASeconds = -1
+	if elapsed := clock.Since(s.firstData).Seconds(); !s.firstData.IsZero() && elapsed >= 1 {
+		p.BytesPerSec = int64(float64(p.BytesReceived) / elapsed)
+	}
+	remaining := int64(0)
+	switch {
+	case p.BytesExpected > 0:
+		remaining = p.BytesExpected - p.BytesReceived
+	case p.CurrentTotal > 0:
+		remaining = p.CurrentTotal - p.CurrentBytes
+	}
+	if remaining < 0 {
+		remaining = 0
+	}
+	if p.BytesPerSec > 0 && (p.BytesExpected > 0 || p.CurrentTotal > 0) {
+		p.ETASeconds = remaining / p.BytesPerSec
+	}
+	return p
+}
+
+// takeChanged reports whether anything changed since the last call
+func (s *syncSession) takeChanged() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	changed := s.changed
+	s.changed = false
+	return changed
+}
+
+// reportProgress pushes msgTypeSyncProgress to a client that negotiated "progress" whenever
+// the progress changed, until done is closed
+func (s *syncSession) reportProgress(conn net.Conn, done <-chan struct{}) {
+	ticker := time.NewTicker(syncProgressInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			if !s.takeChanged() {
+				continue
+			}
+			b, err := json.Marshal(s.snapshot())
+			if err != nil {
+				continue
+			}
+			if err := writeMessage(conn, msgTypeSyncProgress, b); err != nil {
+				log.Printf("Error sending sync progress: %v\n", err)
+				return
+			}
+		}
+	}
+}
+
+// registerSyncStatusRoutes adds the live sync status endpoint to the router
+func registerSyncStatusRoutes(router *mux.Router, config *Config) {
+	router.HandleFunc("/api/sync-status", func(w http.ResponseWriter, r *http.Request) {
+		type sessionStatus struct {
+			SyncProgress
+			Remote string `json:"remote"`
+		}
+		syncSessionsMutex.Lock()
+		sessions := make([]sessionStatus, 0, len(syncSessions))
+		for s := range syncSessions {
+			p := s.snapshot()
+			sessions = append(sessions, sessionStatus{SyncProgress: p, Remote: p.Remote})
+		}
+		syncSessionsMutex.Unlock()
+		sort.Slice(sessions, func(i, j int) bool { return sessions[i].Started.Before(sessions[j].Started) })
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "sessions": sessions})
+	}).Methods("GET")
+}
@@ -0,0 +1,348 @@
+package main
+
+import (
+	"archive/zip"
+	"fmt"
+	"html/template"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// SessionItem is a file that arrived in a sync session, named as the gallery names it
+type SessionItem struct {
+	Name      string `json:"name"`      // catalog name of the original
+	Gallery   string `json:"gallery"`   // thumbnail name for photos, file name for videos, as the gallery's actions take
+	Thumbnail string `json:"thumbnail"` // for /thumb
+	Size      int64  `json:"size"`
+	IsVideo   bool   `json:"isVideo"`
+}
+
+// syncSessionID identifies a session of the sync history by its start, in milliseconds
+func syncSessionID(started time.Time) string {
+	return strconv.FormatInt(started.UnixMilli(), 10)
+}
+
+// findSyncSession returns the session of a phone's sync history with the given ID
+func findSyncSession(phoneDir, id string) (*SyncHistoryEntry, bool) {
+	syncHistoryMutex.Lock()
+	entries, err := loadSyncHistory(phoneDir)
+	syncHistoryMutex.Unlock()
+	if err != nil {
+		return nil, false
+	}
+	for i := range entries {
+		if syncSessionID(entries[i].Started) == id {
+			return &entries[i], true
+		}
+	}
+	return nil, false
+}
+
+// sessionItems reconstructs what a session stored from the catalog: the files recorded
+// while it ran and still in the library. Duplicates the session sent aren't listed, as
+// nothing was stored for them.
+func sessionItems(phoneDir string, s *SyncHistoryEntry) []SessionItem {
+	var items []SessionItem
+	for _, e := range openCatalog(phoneDir).AllEntries() {
+		if e.Created || e.Added.Before(s.Started) || e.Added.After(s.Finished) {
+			continue
+		}
+		base := filepath.Base(filepath.FromSlash(e.Name))
+		item := SessionItem{Name: e.Name, Gallery: thumbnailName(base), Thumbnail: thumbnailName(base),
+			Size: e.Size, IsVideo: hasExtension(base, videoExtensions)}
+		if item.IsVideo {
+			item.Gallery = base
+		}
+		items = append(items, item)
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].Name < items[j].Name })
+	return items
+}
+
+// registerSyncSessionRoutes adds session playback: what a phone's sync sessions stored,
+// with actions on that set, e.g. everything from yesterday's sync
+func registerSyncSessionRoutes(router *mux.Router, config *Config) {
+	router.HandleFunc("/api/phones/{phoneName}/sync-sessions/{session}", func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		phoneDir, err := phoneDirFromRequest(config, r)
+		if err != nil {
+			writeJSON(w, map[string]interface{}{"success": false, "error": "Invalid phone name"})
+			return
+		}
+		s, ok := findSyncSession(phoneDir, vars["session"])
+		if !ok {
+			writeJSON(w, map[string]interface{}{"success": false, "error": "Session not found"})
+			return
+		}
+		items := sessionItems(phoneDir, s)
+		if items == nil {
+			items = []SessionItem{}
+		}
+		countFeature("sync_session_view")
+		writeJSON(w, map[string]interface{}{"success": true, "session": s, "items": items})
+	}).Methods("GET")
+
+	// The originals a session stored, as a ZIP
+	handleTransfer(router, "/api/phones/{phoneName}/sync-sessions/{session}/zip", func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		phoneName := vars["phoneName"]
+		phoneDir, err := phoneDirFromRequest(config, r)
+		if err != nil {
+			http.Error(w, "Invalid phone name", http.StatusBadRequest)
+			return
+		}
+		s, ok := findSyncSession(phoneDir, vars["session"])
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		items := sessionItems(phoneDir, s)
+		if len(items) == 0 {
+			http.Error(w, "Nothing of this session is in the library", http.StatusNotFound)
+			return
+		}
+
+		name := fmt.Sprintf("%s-sync-%s.zip", phoneName, s.Started.Local().Format("2006-01-02-1504"))
+		w.Header().Set("Content-Type", "application/zip")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name))
+		catalog := openCatalog(phoneDir)
+		zw := zip.NewWriter(w)
+		for _, it := range items {
+			src, err := os.Open(catalog.Path(it.Name))
+			if err != nil {
+				continue // deleted meanwhile
+			}
+			info, err := src.Stat()
+			if err == nil {
+				var f io.Writer
+				// Photos and videos don't compress further, so they are stored
+				if f, err = zw.CreateHeader(&zip.FileHeader{Name: it.Name, Method: zip.Store, Modified: info.ModTime()}); err == nil {
+					_, err = io.Copy(f, src)
+				}
+			}
+			src.Close()
+			if err != nil {
+				log.Printf("Sync session download of %s aborted: %v", phoneName, err)
+				return
+			}
+		}
+		if err := zw.Close(); err != nil {
+			log.Printf("Sync session download of %s aborted: %v", phoneName, err)
+			return
+		}
+		countFeature("sync_session_zip")
+	}).Methods("GET")
+
+	router.HandleFunc("/phone/{phoneName}/sessions", func(w http.ResponseWriter, r *http.Request) {
+		phoneName := mux.Vars(r)["phoneName"]
+		phoneDir, err := phoneDirFromRequest(config, r)
+		if err != nil {
+			http.Error(w, "Invalid phone name", http.StatusBadRequest)
+			return
+		}
+		syncHistoryMutex.Lock()
+		entries, err := loadSyncHistory(phoneDir)
+		syncHistoryMutex.Unlock()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		sort.SliceStable(entries, func(i, j int) bool { return entries[i].Started.After(entries[j].Started) })
+
+		tmpl := `<!DOCTYPE html>
+<html>
+<head>
+    <title>{{.PhoneName}} - Sync sessions</title>
+    <style>
+        body { font-family: 'Segoe UI', Tahoma, Arial, sans-serif; margin: 0; padding: 20px; background: #000000; color: #ffffff; }
+        h1 { color: #ffffff; font-weight: 300; letter-spacing: 1px; }
+        .back-link { display: inline-block; margin-bottom: 20px; color: #88aaff; text-decoration: none; font-size: 14px; }
+        .back-link:hover { color: #aaccff; text-decoration: underline; }
+        .note { color: #888888; font-size: 13px; }
+        .sessions { display: flex; flex-direction: column; gap: 6px; max-width: 720px; margin-bottom: 20px; }
+        .session { background: #111111; border: 1px solid #2a2a2a; border-radius: 6px; padding: 8px 12px; cursor: pointer; font-size: 13px; display: flex; justify-content: space-between; }
+        .session:hover, .session.active { border-color: #88aaff; }
+        .session .meta { color: #888888; }
+        .toolbar { display: flex; gap: 10px; margin-bottom: 16px; flex-wrap: wrap; }
+        button { background: #1a1a1a; color: #ffffff; border: 1px solid #333333; border-radius: 4px; padding: 6px 14px; cursor: pointer; font-size: 13px; }
+        button:hover { background: #2a2a2a; }
+        .grid { display: grid; grid-template-columns: repeat(auto-fill, minmax(140px, 1fr)); gap: 10px; }
+        .item { background: #111111; border: 1px solid #2a2a2a; border-radius: 6px; padding: 6px; font-size: 12px; }
+        .item img { width: 100%; height: 110px; object-fit: cover; border-radius: 4px; background: #222222; }
+        .item .name { overflow: hidden; text-overflow: ellipsis; white-space: nowrap; margin-top: 4px; }
+    </style>
+</head>
+<body>
+    <a href="/phone/{{.PhoneName}}" class="back-link">← Back to {{.PhoneName}}</a>
+    <h1>🔄 Sync sessions</h1>
+    {{if .Sessions}}
+    <p class="note">Pick a session to see what it brought in. Files stored since are listed only while they are still in the library.</p>
+    <div class="sessions">
+        {{range .Sessions}}
+        <div class="session" data-id="{{.ID}}" onclick="openSession('{{.ID}}')">
+            <span><time datetime="{{.Started.Format "2006-01-02T15:04:05Z07:00"}}">{{.Started.Format "2006-01-02 15:04"}}</time>{{with .DeviceID}} · {{.}}{{end}}</span>
+            <span class="meta">{{.FilesReceived}} new · {{.Duplicates}} duplicate(s){{if .FailedCount}} · {{.FailedCount}} failed{{end}} · {{formatSize .BytesReceived}}</span>
+        </div>
+        {{end}}
+    </div>
+    <div id="sessionView" style="display: none;">
+        <div class="toolbar">
+            <span id="sessionCount" class="note"></span>
+            <button onclick="makeSlideshow()">🎬 Make slideshow</button>
+            <button onclick="downloadZip()">⬇ Download ZIP</button>
+            <button onclick="addToAlbum()">📚 Add to album</button>
+            <button onclick="deleteItems()">🗑️ Delete</button>
+        </div>
+        <div class="grid" id="sessionItems"></div>
+    </div>
+    {{else}}
+    <p>No sync sessions recorded yet.</p>
+    {{end}}
+    <script>
+        const phoneName = {{.PhoneName}};
+        let sessionId = null;
+        let items = [];
+
+        document.querySelectorAll('time').forEach(t => {
+            t.textContent = new Date(t.getAttribute('datetime')).toLocaleString([], { dateStyle: 'medium', timeStyle: 'short' });
+        });
+
+        function escapeHTML(s) {
+            return String(s).replace(/[&<>"']/g, c => ({ '&': '&amp;', '<': '&lt;', '>': '&gt;', '"': '&quot;', "'": '&#39;' }[c]));
+        }
+
+        function openSession(id) {
+            sessionId = id;
+            document.querySelectorAll('.session').forEach(el => el.classList.toggle('active', el.dataset.id === id));
+            fetch('/api/phones/' + encodeURIComponent(phoneName) + '/sync-sessions/' + id)
+                .then(r => r.json())
+                .then(data => {
+                    if (!data.success) {
+                        alert('Error: ' + data.error);
+                        return;
+                    }
+                    items = data.items;
+                    document.getElementById('sessionView').style.display = 'block';
+                    document.getElementById('sessionCount').textContent = items.length + ' item(s) from this session in the library';
+                    document.getElementById('sessionItems').innerHTML = items.map((it, i) =>
+                        '<label class="item"><img src="/thumb/' + encodeURIComponent(phoneName) + '/' + encodeURIComponent(it.thumbnail) + '" loading="lazy" alt="">' +
+                        '<div class="name" title="' + escapeHTML(it.name) + '"><input type="checkbox" checked data-index="' + i + '"> ' +
+                        (it.isVideo ? '🎬 ' : '') + escapeHTML(it.name) + '</div></label>').join('');
+                })
+                .catch(err => alert('Error: ' + err.message));
+        }
+
+        // The checked items; all of them are checked when a session is opened
+        function selected() {
+            return Array.from(document.querySelectorAll('#sessionItems input:checked')).map(cb => items[cb.dataset.index]);
+        }
+
+        function makeSlideshow() {
+            const photos = selected().filter(it => !it.isVideo).map(it => it.gallery);
+            if (photos.length === 0) {
+                alert('No photos selected');
+                return;
+            }
+            const name = prompt('Video name:', 'sync-' + new Date(parseInt(sessionId)).toISOString().slice(0, 10));
+            if (!name) {
+                return;
+            }
+            document.getElementById('sessionCount').textContent = 'Creating video... This may take a few minutes.';
+            fetch('/create-video', {
+                method: 'POST',
+                headers: { 'Content-Type': 'application/json' },
+                body: JSON.stringify({ phoneName: phoneName, photos: photos, videoName: name })
+            })
+            .then(r => r.json())
+            .then(data => {
+                if (data.success) {
+                    alert('Created ' + data.filename);
+                } else {
+                    alert('Error creating video: ' + (data.error || 'Unknown error'));
+                }
+                openSession(sessionId);
+            })
+            .catch(err => alert('Error creating video: ' + err.message));
+        }
+
+        function downloadZip() {
+            location.href = '/api/phones/' + encodeURIComponent(phoneName) + '/sync-sessions/' + sessionId + '/zip';
+        }
+
+        function addToAlbum() {
+            const photos = selected().map(it => it.gallery);
+            if (photos.length === 0) {
+                alert('Nothing selected');
+                return;
+            }
+            const album = prompt('Add to which shared album? (a new album is created if needed)', '');
+            if (!album) {
+                return;
+            }
+            fetch('/api/albums/' + encodeURIComponent(album) + '/items', {
+                method: 'POST',
+                headers: { 'Content-Type': 'application/json' },
+                body: JSON.stringify({ phoneName: phoneName, photos: photos })
+            })
+            .then(r => r.json())
+            .then(data => alert(data.success ? 'Added ' + data.added + ' item(s) to ' + album : 'Error: ' + data.error))
+            .catch(err => alert('Error: ' + err.message));
+        }
+
+        function deleteItems() {
+            const photos = selected().map(it => it.gallery);
+            if (photos.length === 0) {
+                alert('Nothing selected');
+                return;
+            }
+            if (!confirm('Delete ' + photos.length + ' item(s)?\n\nThey can be restored from Recently deleted for 30 days.')) {
+                return;
+            }
+            fetch('/delete-photos', {
+                method: 'POST',
+                headers: { 'Content-Type': 'application/json' },
+                body: JSON.stringify({ phoneName: phoneName, photos: photos })
+            })
+            .then(r => r.json())
+            .then(data => {
+                if (!data.success) {
+                    alert('Error deleting: ' + (data.error || 'Unknown error'));
+                }
+                openSession(sessionId);
+            })
+            .catch(err => alert('Error deleting: ' + err.message));
+        }
+
+        {{with .Open}}openSession({{.}});{{end}}
+    </script>
+</body>
+</html>`
+
+		type sessionRow struct {
+			SyncHistoryEntry
+			ID string
+		}
+		var rows []sessionRow
+		for i := range entries {
+			rows = append(rows, sessionRow{entries[i], syncSessionID(entries[i].Started)})
+		}
+		t := template.Must(template.New("sessions").Funcs(template.FuncMap{"formatSize": formatBytes}).Parse(tmpl))
+		data := struct {
+			PhoneName string
+			Sessions  []sessionRow
+			Open      string // session to show right away, from ?session=
+		}{phoneName, rows, r.URL.Query().Get("session")}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if err := t.Execute(w, data); err != nil {
+			log.Printf("Error rendering sync sessions page: %v", err)
+		}
+	}).Methods("GET")
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"sort"
+	"time"
+)
+
+// maxSummaryFailures bounds the failures listed in a sync summary; the count covers all
+const maxSummaryFailures = 500
+
+// SyncFailure is a file the server didn't store, with the code and reason of its last ACK
+type SyncFailure struct {
+	ID      string `json:"id"`
+	Code    string `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+// SyncSummary is the msgTypeSyncSummary answer to SYNC_COMPLETE for clients that negotiated
+// "sync_summary": what this connection stored, so the app can show a verified report
+type SyncSummary struct {
+	Phone         string        `json:"phone,omitempty"`
+	Started       time.Time     `json:"started"`
+	Finished      time.Time     `json:"finished"`
+	FilesReceived int           `json:"filesReceived"` // newly stored files
+	BytesReceived int64         `json:"bytesReceived"` // media bytes received, retransmits included
+	Duplicates    int           `json:"duplicates"`    // files skipped because the content was already stored
+	FailedCount   int           `json:"failedCount"`
+	Failures      []SyncFailure `json:"failures"` // at most maxSummaryFailures, by ID
+}
+
+// recordAck counts the outcome of a file, start or batch ACK for the summary. A file that
+// failed and was then resent successfully no longer counts as failed.
+func (s *syncSession) recordAck(ack Ack) {
+	if ack.Kind != ackKindFile && ack.Kind != ackKindStart && ack.Kind != ackKindBatch {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := ack.Kind + ":" + ack.ID
+	switch {
+	case ack.Status == ackStatusOK:
+		delete(s.failures, key)
+		if ack.Kind != ackKindFile {
+			return
+		}
+		if ack.Code == ackCodeDuplicate {
+			s.duplicates++
+		} else {
+			s.received++
+		}
+	case ack.Code == ackCodeMissing:
+		// Not a failure: the client resends the listed chunks and completes again
+	default:
+		if s.failures == nil {
+			s.failures = make(map[string]SyncFailure)
+		}
+		s.failures[key] = SyncFailure{ID: ack.ID, Code: ack.Code, Message: ack.Message}
+	}
+}
+
+// summary returns the session's summary as of now
+func (s *syncSession) summary() SyncSummary {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sum := SyncSummary{
+		Phone:         s.progress.Phone,
+		Started:       s.progress.Started,
+		Finished:      clock.Now(),
+		FilesReceived: s.received,
+		BytesReceived: s.progress.BytesReceived,
+		Duplicates:    s.duplicates,
+		FailedCount:   len(s.failures),
+		Failures:      make([]SyncFailure, 0, len(s.failures)),
+	}
+	for _, f := range s.failures {
+		sum.Failures = append(sum.Failures, f)
+	}
+	sort.Slice(sum.Failures, func(i, j int) bool { return sum.Failures[i].ID < sum.Failures[j].ID })
+	if len(sum.Failures) > maxSummaryFailures {
+		sum.Failures = sum.Failures[:maxSummaryFailures]
+	}
+	return sum
+}
+
+// summaryJSON is the msgTypeSyncSummary payload
+func (s *syncSession) summaryJSON() ([]byte, error) {
+	return json.Marshal(s.summary())
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// usageReportsFileName collects reports in local-only mode, one JSON object per line
+const usageReportsFileName = ".usage_reports.jsonl"
+
+// defaultTelemetryInterval is how often a report is produced unless configured
+const defaultTelemetryInterval = 24 * time.Hour
+
+// TelemetryConfig enables anonymous usage reporting. Nothing is collected or sent unless
+// Enabled is set. LocalOnly writes the reports to the receive dir instead of sending them.
+type TelemetryConfig struct {
+	Enabled       bool   `json:"enabled"`
+	Endpoint      string `json:"endpoint"`
+	LocalOnly     bool   `json:"local_only"`
+	IntervalHours int    `json:"interval_hours"`
+}
+
+// UsageReport is everything a report contains: aggregate counts only, no names, paths,
+// addresses or identifiers
+type UsageReport struct {
+	Version      string           `json:"version"`
+	OS           string           `json:"os"`
+	Arch         string           `json:"arch"`
+	Period       string           `json:"period"` // length of the period the feature counts cover
+	Phones       int              `json:"phones"`
+	Photos       int              `json:"photos"`
+	Videos       int              `json:"videos"`
+	FeatureUsage map[string]int64 `json:"featureUsage"`
+}
+
+var (
+	featureUsageMutex sync.Mutex
+	featureUsage      = make(map[string]int64)
+	featureUsageSince = clock.Now()
+)
+
+// countFeature counts one use of a feature for the usage report
+func countFeature(name string) {
+	featureUsageMutex.Lock()
+	featureUsage[name]++
+	featureUsageMutex.Unlock()
+}
+
+// takeFeatureUsage returns the counts since the last report and starts a new period
+func takeFeatureUsage() (map[string]int64, time.Duration) {
+	featureUsageMutex.Lock()
+	defer featureUsageMutex.Unlock()
+
+	counts := featureUsage
+	period := clock.Since(featureUsageSince)
+	featureUsage = make(map[string]int64)
+	featureUsageSince = clock.Now()
+	return counts, period
+}
+
+// restoreFeatureUsage adds counts back after a failed send so they go out with the next report
+func restoreFeatureUsage(counts map[string]int64) {
+	featureUsageMutex.Lock()
+	defer featureUsageMutex.Unlock()
+
+	for name, n := range counts {
+		featureUsage[name] += n
+	}
+}
+
+func buildUsageReport(config *Config) (UsageReport, map[string]int64) {
+	stats := collectLibraryStats(config)
+	counts, period := takeFeatureUsage()
+	return UsageReport{
+		Version:      version,
+		OS:           runtime.GOOS,
+		Arch:         runtime.GOARCH,
+		Period:       period.Round(time.Minute).String(),
+		Phones:       stats.Phones,
+		Photos:       stats.Photos,
+		Videos:       stats.Videos,
+		FeatureUsage: counts,
+	}, counts
+}
+
+// startTelemetry produces a usage report every interval when telemetry is enabled
+func startTelemetry(config *Config) {
+	t := config.Telemetry
+	if t == nil || !t.Enabled {
+		return
+	}
+	if !t.LocalOnly && t.Endpoint == "" {
+		log.Printf("Telemetry enabled without an endpoint and not local_only, disabled")
+		return
+	}
+	interval := defaultTelemetryInterval
+	if t.IntervalHours > 0 {
+		interval = time.Duration(t.IntervalHours) * time.Hour
+	}
+	if t.LocalOnly {
+		log.Printf("Usage reports enabled (local only, every %v)", interval)
+	} else {
+		log.Printf("Anonymous usage reports enabled (to %s, every %v)", t.Endpoint, interval)
+	}
+
+	ticker := clock.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C() {
+		report, counts := buildUsageReport(config)
+		if err := deliverUsageReport(config, report); err != nil {
+			log.Printf("Usage report failed: %v", err)
+			restoreFeatureUsage(counts)
+		}
+	}
+}
+
+func deliverUsageReport(config *Config, report UsageReport) error {
+	b, err := json.Marshal(report)
+	if err != nil {
+		return err
+	}
+
+	if config.Telemetry.LocalOnly {
+		baseDir := config.ReceiveDir
+		if baseDir == "" {
+			baseDir = "received"
+		}
+		f, err := os.OpenFile(filepath.Join(baseDir, usageReportsFileName), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = f.Write(append(b, '\n'))
+		return err
+	}
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Post(config.Telemetry.Endpoint, "application/json", bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("endpoint returned %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+// throttleForget is how long a remote has to stay quiet before its failures are forgotten
+const throttleForget = time.Hour
+
+// failureThrottle slows down guessing a secret, or retrying a service that keeps failing.
+// After a failed attempt a remote has to wait before its next one, twice as long after
+// every further failure up to a maximum; a success forgets its failures. Remotes are told
+// apart by IP address, so opening more connections doesn't help.
+type failureThrottle struct {
+	base, max time.Duration
+
+	mu      sync.Mutex
+	remotes map[string]*throttleState
+}
+
+type throttleState struct {
+	failures int
+	until    time.Time // no attempts before
+}
+
+func newFailureThrottle(base, max time.Duration) *failureThrottle {
+	return &failureThrottle{base: base, max: max, remotes: make(map[string]*throttleState)}
+}
+
+// remoteHost is the address a throttle keys a remote by: its IP, without the port
+func remoteHost(remote string) string {
+	if host, _, err := net.SplitHostPort(remote); err == nil {
+		return host
+	}
+	return remote
+}
+
+// wait returns how long remote has to wait before its next attempt, zero if it may try now
+func (t *failureThrottle) wait(remote string) time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	st, ok := t.remotes[remoteHost(remote)]
+	if !ok {
+		return 0
+	}
+	return max(0, st.until.Sub(clock.Now()))
+}
+
+// failed records a failed attempt of remote and returns how long it now has to wait
+func (t *failureThrottle) failed(remote string) time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := clock.Now()
+	for host, st := range t.remotes {
+		if now.Sub(st.until) > throttleForget {
+			delete(t.remotes, host)
+		}
+	}
+	host := remoteHost(remote)
+	st, ok := t.remotes[host]
+	if !ok {
+		st = &throttleState{}
+		t.remotes[host] = st
+	}
+	wait := t.base
+	for i := 0; i < st.failures && wait < t.max; i++ {
+		wait *= 2
+	}
+	wait = min(wait, t.max)
+	st.failures++
+	st.until = now.Add(wait)
+	return wait
+}
+
+// succeeded forgets the failures of remote
+func (t *failureThrottle) succeeded(remote string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.remotes, remoteHost(remote))
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFailureThrottleBacksOff(t *testing.T) {
+	c := useFakeClock(t, time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC))
+	th := newFailureThrottle(time.Second, 10*time.Second)
+	const remote = "192.0.2.1:5000"
+
+	if wait := th.wait(remote); wait != 0 {
+		t.Fatalf("a new remote waits %v", wait)
+	}
+	for _, want := range []time.Duration{1, 2, 4, 8, 10, 10} {
+		if wait := th.failed(remote); wait != want*time.Second {
+			t.Fatalf("failed() = %v, want %v", wait, want*time.Second)
+		}
+	}
+	if wait := th.wait("192.0.2.1:6000"); wait != 10*time.Second {
+		t.Errorf("another port of the same address waits %v, want 10s", wait)
+	}
+	if wait := th.wait("192.0.2.2:5000"); wait != 0 {
+		t.Errorf("another address waits %v", wait)
+	}
+
+	c.Advance(4 * time.Second)
+	if wait := th.wait(remote); wait != 6*time.Second {
+		t.Errorf("wait after 4s = %v, want 6s", wait)
+	}
+	c.Advance(6 * time.Second)
+	if wait := th.wait(remote); wait != 0 {
+		t.Errorf("still waiting %v once the wait passed", wait)
+	}
+
+	// Failures count on until a success
+	if wait := th.failed(remote); wait != 10*time.Second {
+		t.Errorf("failed() after the wait = %v, want the maximum", wait)
+	}
+	th.succeeded(remote)
+	if wait := th.failed(remote); wait != time.Second {
+		t.Errorf("failed() after a success = %v, want the base wait", wait)
+	}
+}
+
+func TestFailureThrottleForgetsQuietRemotes(t *testing.T) {
+	c := useFakeClock(t, time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC))
+	th := newFailureThrottle(time.Second, time.Minute)
+
+	for i := 0; i < 5; i++ {
+		th.failed("192.0.2.1")
+	}
+	c.Advance(throttleForget + time.Minute)
+	th.failed("192.0.2.2") // prunes while recording
+	if wait := th.failed("192.0.2.1"); wait != time.Second {
+		t.Errorf("failed() after an hour's quiet = %v, want the base wait", wait)
+	}
+}
+
+func TestGeocoderBackoff(t *testing.T) {
+	useFakeClock(t, time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC))
+	backoff := newFailureThrottle(geocodeErrorWait, geocodeMaxWait)
+
+	wait := time.Duration(0)
+	for i := 0; i < 20; i++ {
+		next := backoff.failed("geocoder")
+		if next < wait || next > geocodeMaxWait {
+			t.Fatalf("failure %d waits %v after %v", i+1, next, wait)
+		}
+		wait = next
+	}
+	if wait != geocodeMaxWait {
+		t.Errorf("backoff tops out at %v, want %v", wait, geocodeMaxWait)
+	}
+}
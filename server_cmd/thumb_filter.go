@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// thumbListFilter narrows a MEDIA_THUMB_LIST request; the zero value lists everything
+type thumbListFilter struct {
+	MediaType   string          // "photo", "video" or empty for both
+	From, To    time.Time       // capture time range, To exclusive; zero for an open end
+	Since       time.Time       // only files added after this, from the cursor of an earlier list
+	NotOnClient *clientManifest // only files the client doesn't hold, for restores
+}
+
+func (f thumbListFilter) empty() bool {
+	return f.MediaType == "" && f.From.IsZero() && f.To.IsZero() && f.Since.IsZero() && f.NotOnClient == nil
+}
+
+// parseThumbListFilter reads the filter fields of a thumb list request. Dates are "2024",
+// "2024-05", "2024-05-01" (in loc, toDate covering the whole period) or exact times.
+func parseThumbListFilter(mediaType, fromDate, toDate, since string, loc *time.Location) (thumbListFilter, error) {
+	var f thumbListFilter
+	switch strings.ToLower(mediaType) {
+	case "", "all":
+	case "photo", "image":
+		f.MediaType = "photo"
+	case "video":
+		f.MediaType = "video"
+	default:
+		return f, fmt.Errorf("unknown mediaType %q", mediaType)
+	}
+	if fromDate != "" {
+		t, precision, err := parseApproxDate(fromDate, loc)
+		if err != nil {
+			return f, fmt.Errorf("fromDate: %w", err)
+		}
+		f.From, _ = approxDateRange(t, precision)
+	}
+	if toDate != "" {
+		t, precision, err := parseApproxDate(toDate, loc)
+		if err != nil {
+			return f, fmt.Errorf("toDate: %w", err)
+		}
+		_, f.To = approxDateRange(t, precision)
+	}
+	if since != "" {
+		nanos, err := strconv.ParseInt(since, 10, 64)
+		if err != nil {
+			return f, fmt.Errorf("invalid since cursor %q", since)
+		}
+		f.Since = time.Unix(0, nanos)
+	}
+	return f, nil
+}
+
+// approxDateRange returns the start and (exclusive) end of a date parsed by parseApproxDate
+func approxDateRange(t time.Time, precision string) (time.Time, time.Time) {
+	start := t.Add(-12 * time.Hour) // partial dates are placed at noon
+	switch precision {
+	case precisionYear:
+		return start, start.AddDate(1, 0, 0)
+	case precisionMonth:
+		return start, start.AddDate(0, 1, 0)
+	case precisionDay:
+		return start, start.AddDate(0, 0, 1)
+	}
+	return t, t.Add(time.Nanosecond)
+}
+
+// thumbListCursor is handed out with a thumb list; sent back as since, it selects what was
+// added after the list was made
+func thumbListCursor(now time.Time) string {
+	return strconv.FormatInt(now.UnixNano(), 10)
+}
+
+// matches reports whether a cataloged original is selected by the filter
+func (f thumbListFilter) matches(e *CatalogEntry) bool {
+	video := hasExtension(e.Name, videoExtensions)
+	if (f.MediaType == "photo" && video) || (f.MediaType == "video" && !video) {
+		return false
+	}
+	taken := e.ModTime
+	if e.Taken != nil {
+		taken = *e.Taken
+	}
+	if (!f.From.IsZero() && taken.Before(f.From)) || (!f.To.IsZero() && !taken.Before(f.To)) {
+		return false
+	}
+	if f.NotOnClient != nil && f.NotOnClient.has(e) {
+		return false
+	}
+	return f.Since.IsZero() || entryAdded(e).After(f.Since)
+}
+
+// filterThumbnailNames keeps the thumbnail names of dir whose originals match the filter
+func filterThumbnailNames(dir string, names []string, f thumbListFilter) []string {
+	if f.empty() {
+		return names
+	}
+	selected := make(map[string]bool)
+	for _, e := range openCatalog(dir).AllEntries() {
+		if f.matches(&e) {
+			selected[thumbnailName(filepath.Base(e.Name))] = true
+		}
+	}
+	kept := names[:0:0]
+	for _, name := range names {
+		if selected[name] {
+			kept = append(kept, name)
+		}
+	}
+	return kept
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ensureThumbnail generates the thumbnail thumbName of phoneDir if it doesn't exist yet and
+// reports whether it exists afterwards. It is generated through the phone's jobs, so
+// concurrent requests for the same thumbnail wait for one generation; giving up when ctx
+// ends leaves it running for the next request.
+func ensureThumbnail(ctx context.Context, phoneDir, thumbName string) bool {
+	thumbDir := filepath.Join(phoneDir, "thumbnails")
+	thumbPath := filepath.Join(thumbDir, thumbName)
+	if _, err := os.Stat(thumbPath); err == nil {
+		return true
+	}
+	orig, ok := originalForThumbnail(phoneDir, thumbName)
+	if !ok || !isPhoneMediaDir(phoneDir, filepath.Dir(orig)) || thumbnailName(filepath.Base(orig)) != thumbName {
+		return false
+	}
+
+	done := jobsFor(phoneDir).thumbnailOnDemand(orig, thumbDir)
+	select {
+	case <-done:
+	case <-ctx.Done():
+		return false
+	}
+	_, err := os.Stat(thumbPath)
+	return err == nil
+}
+
+// thumbnailNames returns the thumbnails of dir in name order: the generated ones plus the
+// names the not yet thumbnailed originals will get, which ensureThumbnail can produce
+func thumbnailNames(dir string) ([]string, error) {
+	seen := make(map[string]bool)
+	entries, err := os.ReadDir(filepath.Join(dir, "thumbnails"))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("read thumbnails dir: %w", err)
+	}
+	for _, e := range entries {
+		if !e.IsDir() && hasExtension(e.Name(), photoExtensions) {
+			seen[e.Name()] = true
+		}
+	}
+
+	if _, err := os.Stat(dir); err != nil {
+		return nil, err
+	}
+	for _, mediaDir := range phoneMediaDirs(dir) {
+		originals, err := os.ReadDir(mediaDir)
+		if err != nil {
+			return nil, fmt.Errorf("read phone dir: %w", err)
+		}
+		for _, e := range originals {
+			name := e.Name()
+			if e.IsDir() || strings.HasPrefix(name, ".") || strings.HasPrefix(strings.ToLower(name), "tbn-") {
+				continue
+			}
+			if hasExtension(name, videoExtensions) {
+				if skipsThumbnail(dir, filepath.Join(mediaDir, name)) {
+					continue
+				}
+			} else if !hasExtension(name, photoExtensions) {
+				continue
+			}
+			seen[thumbnailName(name)] = true
+		}
+	}
+
+	names := make([]string, 0, len(seen))
+	for name := range seen {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	defaultThumbnailWidth   = 320
+	defaultThumbnailQuality = 80
+
+	// thumbnailRegenInterval spaces out regenerating thumbnails after a policy change, so
+	// a large library is redone in the background without starving syncs and the gallery
+	thumbnailRegenInterval = 200 * time.Millisecond
+
+	// maxThumbnailWorkers bounds thumbnail_workers; more would only thrash the disk
+	maxThumbnailWorkers = 64
+)
+
+// thumbnailPolicy is how thumbnails are generated (config thumbnail_width, thumbnail_quality
+// and thumbnail_scaler). The catalog records the policy of every thumbnail; ones made under
+// another policy are regenerated at startup.
+type thumbnailPolicy struct {
+	Width   int    // maximum width in pixels
+	Quality int    // JPEG quality of photo thumbnails
+	Scaler  string // thumbnailScalers name, for photo thumbnails
+}
+
+var (
+	defaultThumbnailPolicy = thumbnailPolicy{Width: defaultThumbnailWidth, Quality: defaultThumbnailQuality, Scaler: "catmullrom"}
+
+	// currentThumbnailPolicy is set from the config at startup
+	currentThumbnailPolicy = defaultThumbnailPolicy
+)
+
+// key identifies the policy as recorded in the catalog. Video thumbnails are extracted by
+// ffmpeg, so only the width applies to them.
+func (p thumbnailPolicy) key(video bool) string {
+	if video {
+		return fmt.Sprintf("w%d", p.Width)
+	}
+	return fmt.Sprintf("w%d-q%d-%s", p.Width, p.Quality, p.Scaler)
+}
+
+// setThumbnailPolicy selects the thumbnail policy from the config; invalid values keep
+// their defaults
+func setThumbnailPolicy(config *Config) error {
+	p := defaultThumbnailPolicy
+	var errs []string
+	if err := setThumbnailScaler(config.ThumbnailScaler); err != nil {
+		errs = append(errs, err.Error())
+	} else if name := strings.ToLower(strings.TrimSpace(config.ThumbnailScaler)); name != "" {
+		p.Scaler = name
+	}
+	switch {
+	case config.ThumbnailWidth == 0:
+	case config.ThumbnailWidth < 32 || config.ThumbnailWidth > 2048:
+		errs = append(errs, fmt.Sprintf("thumbnail_width %d is not between 32 and 2048", config.ThumbnailWidth))
+	default:
+		p.Width = config.ThumbnailWidth
+	}
+	switch {
+	case config.ThumbnailQuality == 0:
+	case config.ThumbnailQuality < 1 || config.ThumbnailQuality > 100:
+		errs = append(errs, fmt.Sprintf("thumbnail_quality %d is not between 1 and 100", config.ThumbnailQuality))
+	default:
+		p.Quality = config.ThumbnailQuality
+	}
+	switch {
+	case config.ThumbnailWorkers == 0:
+	case config.ThumbnailWorkers < 1 || config.ThumbnailWorkers > maxThumbnailWorkers:
+		errs = append(errs, fmt.Sprintf("thumbnail_workers %d is not between 1 and %d", config.ThumbnailWorkers, maxThumbnailWorkers))
+	default:
+		// Not part of the policy: it changes how fast thumbnails are made, not how they look
+		thumbnailRunWorkers = config.ThumbnailWorkers
+	}
+	currentThumbnailPolicy = p
+	if len(errs) > 0 {
+		return fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// recordThumbnailPolicy notes in the phone's catalog that the thumbnail of the original at
+// path was just generated under the current policy
+func recordThumbnailPolicy(phoneDir, path string) {
+	openCatalog(phoneDir).SetThumbnailPolicy(path, currentThumbnailPolicy.key(hasExtension(path, videoExtensions)))
+}
+
+// regenerateOutdatedThumbnails replaces, one at a time, the thumbnails that were generated
+// under a different policy than the current one. Thumbnails that don't exist yet are left
+// to the next sync or gallery visit.
+func regenerateOutdatedThumbnails(baseDir string) {
+	regenerated := 0
+	for _, phone := range libraryPhones(baseDir) {
+		phoneDir := filepath.Join(baseDir, phone)
+		thumbDir := filepath.Join(phoneDir, "thumbnails")
+		names := openCatalog(phoneDir).OutdatedThumbnails(
+			currentThumbnailPolicy.key(false), currentThumbnailPolicy.key(true),
+			defaultThumbnailPolicy.key(false), defaultThumbnailPolicy.key(true))
+		for _, name := range names {
+			if draining() {
+				return
+			}
+			orig := openCatalog(phoneDir).Path(name)
+			thumbPath := filepath.Join(thumbDir, thumbnailName(filepath.Base(orig)))
+			if _, err := os.Stat(thumbPath); err != nil {
+				continue
+			}
+			release, _ := jobsFor(phoneDir).acquireWorker(context.Background())
+			os.Remove(thumbPath)
+			if err := generateThumbnail(context.Background(), filepath.Dir(orig), thumbDir, filepath.Base(orig)); err != nil {
+				log.Printf("Thumbnail regeneration failed: %v", err)
+			}
+			release()
+			regenerated++
+			time.Sleep(thumbnailRegenInterval)
+		}
+	}
+	if regenerated > 0 {
+		log.Printf("Regenerated %d thumbnail(s) for the new thumbnail settings", regenerated)
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"log"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Self-signed certificate kept in the receive dir when no certificate is configured, so its
+// fingerprint (advertised in discovery for pinning) stays the same across restarts. QUIC
+// introduced it, hence the names; HTTPS shares it.
+const (
+	selfSignedCertFileName = ".quic_cert.pem"
+	selfSignedKeyFileName  = ".quic_key.pem"
+)
+
+// selfSignedCertMutex serializes creating the self-signed certificate
+var selfSignedCertMutex sync.Mutex
+
+// loadServerCertificate loads the configured certificate, or the self-signed one in baseDir,
+// creating it on first use
+func loadServerCertificate(config *Config, certFile, keyFile, baseDir string) (tls.Certificate, error) {
+	if certFile != "" || keyFile != "" {
+		return tls.LoadX509KeyPair(certFile, keyFile)
+	}
+
+	// QUIC and HTTPS may load it concurrently at startup
+	selfSignedCertMutex.Lock()
+	defer selfSignedCertMutex.Unlock()
+
+	certPath := filepath.Join(baseDir, selfSignedCertFileName)
+	keyPath := filepath.Join(baseDir, selfSignedKeyFileName)
+	if cert, err := tls.LoadX509KeyPair(certPath, keyPath); err == nil {
+		return cert, nil
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: "photo_sync_server " + config.ServerName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().AddDate(20, 0, 0),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	if err := os.WriteFile(keyPath, keyPEM, 0o600); err != nil {
+		return tls.Certificate{}, err
+	}
+	if err := os.WriteFile(certPath, certPEM, 0o644); err != nil {
+		return tls.Certificate{}, err
+	}
+	log.Printf("Created self-signed certificate %s", certPath)
+	return tls.X509KeyPair(certPEM, keyPEM)
+}
+
+// certificateFingerprint returns the hex SHA-256 of a certificate's leaf, for pinning
+func certificateFingerprint(cert tls.Certificate) string {
+	sum := sha256.Sum256(cert.Certificate[0])
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Timeouts for the external tools we run. Every child process goes through runTool,
+// so nothing can hold a job slot forever.
+const (
+	heifConvertTimeout    = 60 * time.Second
+	videoThumbnailTimeout = 15 * time.Second
+	videoCreateTimeout    = 10 * time.Minute
+	videoTrimTimeout      = 10 * time.Minute
+	videoProbeTimeout     = 30 * time.Second
+	videoFrameTimeout     = 60 * time.Second
+	audioExtractTimeout   = 10 * time.Minute
+	musicDownloadTimeout  = 5 * time.Minute
+	exifWriteTimeout      = 30 * time.Second
+	geocodeTimeout        = 30 * time.Second
+	remoteExportTimeout   = 6 * time.Hour
+
+	// toolKillGrace is how long past its deadline a child may linger before the watchdog kills it
+	toolKillGrace = 30 * time.Second
+)
+
+// toolJob is one running external process tracked by the watchdog
+type toolJob struct {
+	ID       int
+	Name     string
+	Args     []string
+	Started  time.Time
+	Deadline time.Time
+	cmd      *exec.Cmd
+	killed   bool
+}
+
+var (
+	toolJobsMutex sync.Mutex
+	toolJobs      = make(map[int]*toolJob)
+	toolJobSeq    int
+)
+
+// ToolRunner starts external tools. runTool goes through tools, which tests replace
+// with fakeTools so ffmpeg and friends need not be installed.
+type ToolRunner interface {
+	LookPath(name string) (string, error)
+	Run(ctx context.Context, timeout time.Duration, name string, args ...string) ([]byte, error)
+}
+
+var tools ToolRunner = execTools{}
+
+// runTool runs an external tool with a mandatory timeout and returns its combined output
+func runTool(ctx context.Context, timeout time.Duration, name string, args ...string) ([]byte, error) {
+	if timeout <= 0 {
+		return nil, fmt.Errorf("runTool %s: timeout is required", name)
+	}
+	return tools.Run(ctx, timeout, name, args...)
+}
+
+// execTools runs the real programs, tracked by the watchdog
+type execTools struct{}
+
+func (execTools) LookPath(name string) (string, error) {
+	return exec.LookPath(name)
+}
+
+// Run starts the child in its own process group so that when the timeout or ctx expires
+// the whole group is killed, including helpers it spawned (ffmpeg filters, yt-dlp, ...).
+func (execTools) Run(ctx context.Context, timeout time.Duration, name string, args ...string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, name, args...)
+	setProcessGroup(cmd)
+	cmd.Cancel = func() error { return killProcessGroup(cmd) }
+	// Don't wait forever for grandchildren that keep the output pipes open
+	cmd.WaitDelay = 5 * time.Second
+
+	// Started before the job is registered, so the watchdog only ever sees cmd.Process set
+	var output bytes.Buffer
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	now := clock.Now()
+	toolJobsMutex.Lock()
+	toolJobSeq++
+	job := &toolJob{
+		ID:       toolJobSeq,
+		Name:     name,
+		Args:     args,
+		Started:  now,
+		Deadline: now.Add(timeout),
+		cmd:      cmd,
+	}
+	toolJobs[job.ID] = job
+	toolJobsMutex.Unlock()
+
+	defer func() {
+		toolJobsMutex.Lock()
+		delete(toolJobs, job.ID)
+		toolJobsMutex.Unlock()
+	}()
+
+	err := cmd.Wait()
+	if ctx.Err() == context.DeadlineExceeded {
+		return output.Bytes(), fmt.Errorf("%s timed out after %v: %w", name, timeout, err)
+	}
+	return output.Bytes(), err
+}
+
+// runningTools returns a snapshot of the external processes currently running
+func runningTools() []toolJob {
+	toolJobsMutex.Lock()
+	defer toolJobsMutex.Unlock()
+
+	jobs := make([]toolJob, 0, len(toolJobs))
+	for _, job := range toolJobs {
+		jobs = append(jobs, *job)
+	}
+	return jobs
+}
+
+// startToolWatchdog periodically reports long-running children and kills the process
+// group of any that outlived their deadline by more than toolKillGrace. This covers
+// children whose context cancellation didn't take (stuck in uninterruptible I/O, ignored signals).
+func startToolWatchdog(interval time.Duration) {
+	ticker := clock.NewTicker(interval)
+	defer ticker.Stop()
+
+	log.Printf("Started external tool watchdog (interval: %v)", interval)
+
+	for now := range ticker.C() {
+		killStuckTools(now)
+	}
+}
+
+// killStuckTools is one watchdog pass at now
+func killStuckTools(now time.Time) {
+	toolJobsMutex.Lock()
+	defer toolJobsMutex.Unlock()
+	for _, job := range toolJobs {
+		if now.Before(job.Deadline) {
+			continue
+		}
+		overdue := now.Sub(job.Deadline)
+		log.Printf("Watchdog: %s (job %d) running for %v, %v past its deadline: %s %s",
+			job.Name, job.ID, now.Sub(job.Started).Round(time.Second), overdue.Round(time.Second),
+			job.Name, strings.Join(job.Args, " "))
+		if overdue > toolKillGrace && !job.killed {
+			if err := killProcessGroup(job.cmd); err != nil {
+				log.Printf("Watchdog: failed to kill stuck %s (job %d): %v", job.Name, job.ID, err)
+			} else {
+				log.Printf("Watchdog: killed stuck %s (job %d)", job.Name, job.ID)
+			}
+			job.killed = true
+		}
+	}
+}
+
+// killAllTools kills every external process still running, at shutdown
+func killAllTools() {
+	toolJobsMutex.Lock()
+	defer toolJobsMutex.Unlock()
+	for _, job := range toolJobs {
+		if job.killed {
+			continue
+		}
+		log.Printf("Killing %s (job %d), still running at shutdown", job.Name, job.ID)
+		if err := killProcessGroup(job.cmd); err != nil {
+			log.Printf("Failed to kill %s (job %d): %v", job.Name, job.ID, err)
+		}
+		job.killed = true
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// toolCall is one invocation seen by fakeTools
+type toolCall struct {
+	Name string // base name of the tool
+	Args []string
+}
+
+// fakeTools stands in for the external tools. Handlers are looked up by the tool's base
+// name, so "/usr/local/bin/heif-convert" finds "heif-convert"; tools without a handler
+// are reported as not installed.
+type fakeTools struct {
+	mu       sync.Mutex
+	handlers map[string]func(args []string) ([]byte, error)
+	calls    []toolCall
+}
+
+func newFakeTools() *fakeTools {
+	return &fakeTools{handlers: make(map[string]func(args []string) ([]byte, error))}
+}
+
+// Handle scripts the tool called name: fn gets the arguments and returns the output
+func (f *fakeTools) Handle(name string, fn func(args []string) ([]byte, error)) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.handlers[name] = fn
+}
+
+func (f *fakeTools) LookPath(name string) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.handlers[filepath.Base(name)] == nil {
+		return "", fmt.Errorf("%s: %w", name, exec.ErrNotFound)
+	}
+	return name, nil
+}
+
+func (f *fakeTools) Run(ctx context.Context, timeout time.Duration, name string, args ...string) ([]byte, error) {
+	f.mu.Lock()
+	f.calls = append(f.calls, toolCall{Name: filepath.Base(name), Args: append([]string(nil), args...)})
+	fn := f.handlers[filepath.Base(name)]
+	f.mu.Unlock()
+	if fn == nil {
+		return nil, fmt.Errorf("%s: %w", name, exec.ErrNotFound)
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return fn(args)
+}
+
+// Calls returns the invocations of the tool called name so far
+func (f *fakeTools) Calls(name string) []toolCall {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var calls []toolCall
+	for _, c := range f.calls {
+		if c.Name == name {
+			calls = append(calls, c)
+		}
+	}
+	return calls
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// trashDirName is the folder in each phone directory that holds deleted files until
+// trashRetention has passed. Like other dot folders it is skipped by the catalog, the
+// gallery and exports.
+const trashDirName = ".trash"
+
+// trashRetention is how long deleted files can be restored from "Recently deleted"
+const trashRetention = 30 * 24 * time.Hour
+
+// registerTrashRoutes adds the "Recently deleted" page with restoring and emptying
+func registerTrashRoutes(router *mux.Router, config *Config) {
+	router.HandleFunc("/api/phones/{phoneName}/trash", func(w http.ResponseWriter, r *http.Request) {
+		phoneDir, err := phoneDirFromRequest(config, r)
+		if err != nil {
+			writeJSON(w, map[string]interface{}{"success": false, "error": "Invalid phone name"})
+			return
+		}
+		var req struct {
+			Action string   `json:"action"` // "restore" or "delete"
+			Names  []string `json:"names"`  // names as listed on the page
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeJSON(w, map[string]interface{}{"success": false, "error": "Invalid request: " + err.Error()})
+			return
+		}
+		if req.Action != "restore" && req.Action != "delete" {
+			writeJSON(w, map[string]interface{}{"success": false, "error": "Unknown action " + req.Action})
+			return
+		}
+
+		catalog := openCatalog(phoneDir)
+		done := 0
+		var errors, restored []string
+		for _, name := range req.Names {
+			var err error
+			if req.Action == "restore" {
+				err = catalog.RestoreFromTrash(name)
+			} else {
+				err = catalog.DeleteFromTrash(name)
+			}
+			if err != nil {
+				errors = append(errors, err.Error())
+				continue
+			}
+			if req.Action == "restore" {
+				restored = append(restored, catalog.Path(name))
+			}
+			done++
+		}
+		notifyMediaChange(phoneDir, mediaChangeAdded, restored...)
+		countFeature("trash_" + req.Action)
+		log.Printf("Trash %s: %d file(s) in %s", req.Action, done, phoneDir)
+		writeJSON(w, map[string]interface{}{"success": done > 0 || len(errors) == 0, "done": done, "errors": errors})
+	}).Methods("POST")
+
+	// Trashed originals, for the previews on the page
+	handleTransfer(router, "/trash/{phoneName}", func(w http.ResponseWriter, r *http.Request) {
+		phoneDir, err := phoneDirFromRequest(config, r)
+		if err != nil {
+			http.Error(w, "Invalid phone name", http.StatusBadRequest)
+			return
+		}
+		name := r.URL.Query().Get("name")
+		for _, t := range openCatalog(phoneDir).TrashEntries() {
+			if t.Name == name {
+				w.Header().Set("Cache-Control", "private, max-age=3600")
+				http.ServeFile(w, r, trashedPath(phoneDir, &t.CatalogEntry))
+				return
+			}
+		}
+		http.NotFound(w, r)
+	}).Methods("GET")
+
+	router.HandleFunc("/phone/{phoneName}/trash", func(w http.ResponseWriter, r *http.Request) {
+		phoneName := mux.Vars(r)["phoneName"]
+		phoneDir, err := phoneDirFromRequest(config, r)
+		if err != nil {
+			http.Error(w, "Invalid phone name", http.StatusBadRequest)
+			return
+		}
+
+		tmpl := `<!DOCTYPE html>
+<html>
+<head>
+    <title>{{.PhoneName}} - Recently deleted</title>
+    <style>
+        body { font-family: 'Segoe UI', Tahoma, Arial, sans-serif; margin: 0; padding: 20px; background: #000000; color: #ffffff; }
+        h1 { color: #ffffff; font-weight: 300; letter-spacing: 1px; }
+        .back-link { display: inline-block; margin-bottom: 20px; color: #88aaff; text-decoration: none; font-size: 14px; }
+        .back-link:hover { color: #aaccff; text-decoration: underline; }
+        .note { color: #888888; font-size: 13px; }
+        .toolbar { display: flex; gap: 10px; margin-bottom: 16px; }
+        button { background: #1a1a1a; color: #ffffff; border: 1px solid #333333; border-radius: 4px; padding: 6px 14px; cursor: pointer; font-size: 13px; }
+        button:hover { background: #2a2a2a; }
+        .trash-grid { display: grid; grid-template-columns: repeat(auto-fill, minmax(160px, 1fr)); gap: 12px; }
+        .trash-item { background: #111111; border: 1px solid #2a2a2a; border-radius: 6px; padding: 8px; font-size: 12px; }
+        .trash-item img, .trash-item .video { width: 100%; height: 120px; object-fit: cover; border-radius: 4px; background: #222222; }
+        .trash-item .video { display: flex; align-items: center; justify-content: center; font-size: 32px; }
+        .trash-item .name { overflow: hidden; text-overflow: ellipsis; white-space: nowrap; margin-top: 6px; }
+        .trash-item .meta { color: #888888; }
+    </style>
+</head>
+<body>
+    <a href="/phone/{{.PhoneName}}" class="back-link">← Back to {{.PhoneName}}</a>
+    <h1>🗑 Recently deleted</h1>
+    <p class="note">Deleted photos and videos stay here for {{.RetentionDays}} days before they are removed for good.</p>
+    {{if .Items}}
+    <div class="toolbar">
+        <button onclick="trashAction('restore', selectedNames())">↩ Restore selected</button>
+        <button onclick="trashAction('delete', selectedNames())">Delete selected now</button>
+        <button onclick="trashAction('delete', allNames())">Empty trash</button>
+    </div>
+    <div class="trash-grid">
+        {{range .Items}}
+        <label class="trash-item">
+            {{if isVideo .Name}}<div class="video">🎬</div>{{else}}<img src="/trash/{{$.PhoneName}}?name={{.Name}}" alt="" loading="lazy">{{end}}
+            <div class="name" title="{{.Name}}"><input type="checkbox" data-name="{{.Name}}"> {{.Name}}</div>
+            <div class="meta">{{formatSize .Size}} · deleted {{.Deleted.Format "2006-01-02 15:04"}}</div>
+        </label>
+        {{end}}
+    </div>
+    {{else}}
+    <p>Nothing was deleted recently.</p>
+    {{end}}
+    <script>
+        function selectedNames() {
+            return Array.from(document.querySelectorAll('.trash-item input:checked')).map(cb => cb.dataset.name);
+        }
+        function allNames() {
+            return Array.from(document.querySelectorAll('.trash-item input')).map(cb => cb.dataset.name);
+        }
+        function trashAction(action, names) {
+            if (names.length === 0) {
+                alert('Nothing selected');
+                return;
+            }
+            if (action === 'delete' && !confirm('Delete ' + names.length + ' file(s) for good? This cannot be undone.')) {
+                return;
+            }
+            fetch('/api/phones/{{.PhoneName}}/trash', {
+                method: 'POST',
+                headers: { 'Content-Type': 'application/json' },
+                body: JSON.stringify({ action: action, names: names })
+            })
+            .then(response => response.json())
+            .then(data => {
+                if (data.errors && data.errors.length) {
+                    alert('Some files failed: ' + data.errors.join('; '));
+                }
+                location.reload();
+            })
+            .catch(err => alert('Error: ' + err));
+        }
+    </script>
+</body>
+</html>`
+
+		t := template.Must(template.New("trash").Funcs(template.FuncMap{
+			"isVideo":    func(name string) bool { return hasExtension(name, videoExtensions) },
+			"formatSize": formatBytes,
+		}).Parse(tmpl))
+		data := struct {
+			PhoneName     string
+			Items         []TrashEntry
+			RetentionDays int
+		}{phoneName, openCatalog(phoneDir).TrashEntries(), int(trashRetention / (24 * time.Hour))}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if err := t.Execute(w, data); err != nil {
+			log.Printf("Error rendering trash page: %v", err)
+		}
+	}).Methods("GET")
+}
+
+// formatBytes formats a file size for the web UI, like the pages' JavaScript does
+func formatBytes(n int64) string {
+	switch {
+	case n >= 1<<30:
+		return fmt.Sprintf("%.1f GB", float64(n)/(1<<30))
+	case n >= 1<<20:
+		return fmt.Sprintf("%.1f MB", float64(n)/(1<<20))
+	case n >= 1<<10:
+		return fmt.Sprintf("%.0f KB", float64(n)/(1<<10))
+	}
+	return fmt.Sprintf("%d B", n)
+}
@@ -0,0 +1,713 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"image/jpeg"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// maxExportErrors caps the per-file errors kept on an export job
+const maxExportErrors = 50
+
+// ExportDrive is a mounted removable drive an export can be written to
+type ExportDrive struct {
+	Path      string `json:"path"`
+	Name      string `json:"name"`
+	FreeBytes uint64 `json:"freeBytes"`
+}
+
+// exportMountRoots returns the directories removable drives are mounted under
+func exportMountRoots(config *Config) []string {
+	if len(config.ExportMountRoots) > 0 {
+		return config.ExportMountRoots
+	}
+	if runtime.GOOS == "windows" {
+		var roots []string
+		for c := 'D'; c <= 'Z'; c++ {
+			roots = append(roots, string(c)+`:\`)
+		}
+		return roots
+	}
+	return []string{"/media", "/run/media", "/mnt", "/Volumes"}
+}
+
+// findExportDrives lists the drives mounted at or up to two levels below the mount roots
+// (e.g. /media/<user>/<label>), except the one holding the receive dir
+func findExportDrives(config *Config) []ExportDrive {
+	baseDir := config.ReceiveDir
+	if baseDir == "" {
+		baseDir = "received"
+	}
+	baseDir, _ = filepath.Abs(baseDir)
+
+	var drives []ExportDrive
+	seen := make(map[string]bool)
+	var scan func(dir string, depth int)
+	scan = func(dir string, depth int) {
+		if seen[dir] {
+			return
+		}
+		seen[dir] = true
+		if isRemovableDrive(dir) {
+			if rel, err := filepath.Rel(dir, baseDir); err == nil && !strings.HasPrefix(rel, "..") {
+				return
+			}
+			free, _ := diskFreeBytes(dir)
+			name := filepath.Base(dir)
+			if name == "." || name == string(filepath.Separator) {
+				name = dir
+			}
+			drives = append(drives, ExportDrive{Path: dir, Name: name, FreeBytes: free})
+			return
+		}
+		if depth == 2 {
+			return
+		}
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			return
+		}
+		for _, e := range entries {
+			if e.IsDir() && !strings.HasPrefix(e.Name(), ".") {
+				scan(filepath.Join(dir, e.Name()), depth+1)
+			}
+		}
+	}
+	for _, root := range exportMountRoots(config) {
+		scan(filepath.Clean(root), 0)
+	}
+	sort.Slice(drives, func(i, j int) bool { return drives[i].Path < drives[j].Path })
+	return drives
+}
+
+// exportFile is one original to copy, with the folder it goes to on the drive
+type exportFile struct {
+	Src       string
+	Folder    string
+	Taken     time.Time
+	Size      int64
+	Watermark *WatermarkConfig // exported as a watermarked JPEG when set
+}
+
+// ExportJob tracks one export to a drive; the fields are guarded by exportJobsMutex
+type ExportJob struct {
+	ID          string     `json:"id"`
+	Drive       string     `json:"drive"`
+	Dest        string     `json:"dest"`
+	Status      string     `json:"status"` // running, done, failed or cancelled
+	TotalFiles  int        `json:"totalFiles"`
+	CopiedFiles int        `json:"copiedFiles"`
+	TotalBytes  int64      `json:"totalBytes"`
+	CopiedBytes int64      `json:"copiedBytes"`
+	CurrentFile string     `json:"currentFile,omitempty"`
+	Errors      []string   `json:"errors,omitempty"`
+	Started     time.Time  `json:"started"`
+	Finished    *time.Time `json:"finished,omitempty"`
+
+	cancel context.CancelFunc
+}
+
+var (
+	exportJobsMutex sync.Mutex
+	exportJobs      = make(map[string]*ExportJob)
+	exportJobSeq    int
+)
+
+// snapshot returns a copy of the job that is safe to encode. Callers hold exportJobsMutex.
+func (j *ExportJob) snapshot() ExportJob {
+	s := *j
+	s.Errors = append([]string(nil), j.Errors...)
+	s.cancel = nil
+	return s
+}
+
+func (j *ExportJob) update(fn func(j *ExportJob)) {
+	exportJobsMutex.Lock()
+	fn(j)
+	exportJobsMutex.Unlock()
+}
+
+// ExportRequest selects what to export: the items of the chosen albums, or every phone's
+// media when no album is chosen, optionally limited to a capture date range
+type ExportRequest struct {
+	Drive  string   `json:"drive"`
+	Folder string   `json:"folder"` // top-level folder created on the drive
+	Albums []string `json:"albums"`
+	From   string   `json:"from"` // YYYY-MM-DD, inclusive
+	To     string   `json:"to"`   // YYYY-MM-DD, inclusive
+	// Watermark exports photos as JPEGs with the configured watermark instead of the originals
+	Watermark bool `json:"watermark"`
+}
+
+// collectExportFiles resolves an export request to the originals to copy. Album items go
+// into one folder per album, other media into one folder per capture month.
+func collectExportFiles(baseDir string, req ExportRequest) ([]exportFile, error) {
+	var from, to time.Time
+	var err error
+	if req.From != "" {
+		if from, err = time.ParseInLocation("2006-01-02", req.From, time.Local); err != nil {
+			return nil, fmt.Errorf("invalid from date: %v", err)
+		}
+	}
+	if req.To != "" {
+		if to, err = time.ParseInLocation("2006-01-02", req.To, time.Local); err != nil {
+			return nil, fmt.Errorf("invalid to date: %v", err)
+		}
+		to = to.AddDate(0, 0, 1)
+	}
+	inRange := func(t time.Time) bool {
+		return (from.IsZero() || !t.Before(from)) && (to.IsZero() || t.Before(to))
+	}
+
+	var files []exportFile
+	add := func(phoneDir, path, folder string) {
+		info, err := os.Stat(path)
+		if err != nil || !info.Mode().IsRegular() {
+			return
+		}
+		taken := openCatalog(phoneDir).CaptureTime(path, info)
+		if !inRange(taken) {
+			return
+		}
+		if folder == "" {
+			folder = taken.Format("2006-01")
+		}
+		files = append(files, exportFile{Src: path, Folder: folder, Taken: taken, Size: info.Size()})
+	}
+
+	if len(req.Albums) > 0 {
+		albumsMutex.Lock()
+		albums, err := loadAlbums(baseDir)
+		albumsMutex.Unlock()
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range req.Albums {
+			a, ok := albums[name]
+			if !ok {
+				return nil, fmt.Errorf("album %q not found", name)
+			}
+			for _, it := range a.Items {
+				phoneDir := filepath.Join(baseDir, it.Phone)
+				path := filepath.Join(phoneDir, it.Name)
+				if orig, ok := originalForThumbnail(phoneDir, it.Name); ok {
+					path = orig
+				}
+				add(phoneDir, path, a.Name)
+			}
+		}
+	} else {
+		if req.From == "" && req.To == "" {
+			return nil, fmt.Errorf("select albums or a date range")
+		}
+		entries, err := os.ReadDir(baseDir)
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range entries {
+			if !e.IsDir() || presetFolders[strings.ToLower(e.Name())] || strings.HasPrefix(e.Name(), ".") {
+				continue
+			}
+			phoneDir := filepath.Join(baseDir, e.Name())
+			filepath.WalkDir(phoneDir, func(path string, d fs.DirEntry, err error) error {
+				if err != nil {
+					return nil
+				}
+				name := d.Name()
+				if d.IsDir() {
+					if path != phoneDir && (name == "thumbnails" || strings.HasPrefix(name, ".")) {
+						return filepath.SkipDir
+					}
+					return nil
+				}
+				if !strings.HasPrefix(name, ".") && (hasExtension(name, photoExtensions) || hasExtension(name, videoExtensions)) {
+					add(phoneDir, path, "")
+				}
+				return nil
+			})
+		}
+	}
+
+	sort.SliceStable(files, func(i, j int) bool {
+		if files[i].Folder != files[j].Folder {
+			return files[i].Folder < files[j].Folder
+		}
+		return files[i].Taken.Before(files[j].Taken)
+	})
+	return files, nil
+}
+
+// exportTarget returns where f goes in dir: its own name, or name_1, name_2, ... when a
+// different file already has it. skip is set when f was already exported there, judged by
+// size and modification time (set to the capture time; FAT keeps it to 2 seconds). A
+// watermarked photo is a JPEG of unknown size, judged by its modification time alone.
+func exportTarget(dir string, f exportFile) (string, bool) {
+	name := filepath.Base(f.Src)
+	ext := filepath.Ext(name)
+	stem := strings.TrimSuffix(name, ext)
+	if f.Watermark != nil {
+		name, ext = stem+".jpg", ".jpg"
+	}
+	for i := 0; ; i++ {
+		candidate := name
+		if i > 0 {
+			candidate = fmt.Sprintf("%s_%d%s", stem, i, ext)
+		}
+		target := filepath.Join(dir, candidate)
+		info, err := os.Stat(target)
+		if err != nil {
+			return target, false
+		}
+		if d := info.ModTime().Sub(f.Taken); (info.Size() == f.Size || f.Watermark != nil) && d > -2*time.Second && d < 2*time.Second {
+			return target, true
+		}
+	}
+}
+
+// progressWriter adds the bytes written through it to the job's progress
+type progressWriter struct {
+	w   io.Writer
+	job *ExportJob
+}
+
+func (p *progressWriter) Write(b []byte) (int, error) {
+	n, err := p.w.Write(b)
+	p.job.update(func(j *ExportJob) { j.CopiedBytes += int64(n) })
+	return n, err
+}
+
+// copyExportFile copies one original to target, flushed to the drive before returning,
+// with the capture time as modification time so file browsers sort it correctly
+func copyExportFile(ctx context.Context, job *ExportJob, f exportFile, target string) error {
+	if f.Watermark != nil {
+		return renderExportFile(job, f, target)
+	}
+	in, err := os.Open(f.Src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	tmp := target + ".part"
+	out, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(&progressWriter{w: out, job: job}, &contextReader{ctx: ctx, r: in})
+	if err == nil {
+		err = out.Sync()
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, target); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	os.Chtimes(target, f.Taken, f.Taken)
+	return nil
+}
+
+// renderExportFile writes a photo to target as a full-size JPEG with the watermark. Its
+// progress counts as the original's size, which the job's total is made of.
+func renderExportFile(job *ExportJob, f exportFile, target string) error {
+	img, err := renderPhoto(f.Src, 0, f.Watermark)
+	if err != nil {
+		return err
+	}
+	tmp := target + ".part"
+	out, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	err = jpeg.Encode(out, img, &jpeg.Options{Quality: photoDownloadQuality})
+	if err == nil {
+		err = out.Sync()
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, target)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	os.Chtimes(target, f.Taken, f.Taken)
+	job.update(func(j *ExportJob) { j.CopiedBytes += f.Size })
+	return nil
+}
+
+// contextReader stops a copy once ctx is cancelled
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (c *contextReader) Read(b []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return c.r.Read(b)
+}
+
+// runExportJob copies the files to the job's destination, recording progress on the job
+func runExportJob(ctx context.Context, job *ExportJob, files []exportFile) {
+	status := "done"
+	for _, f := range files {
+		if ctx.Err() != nil {
+			status = "cancelled"
+			break
+		}
+		job.update(func(j *ExportJob) { j.CurrentFile = filepath.Base(f.Src) })
+
+		dir := filepath.Join(job.Dest, f.Folder)
+		err := os.MkdirAll(dir, 0o755)
+		if err == nil {
+			target, skip := exportTarget(dir, f)
+			if skip {
+				// Already on the drive from an earlier, interrupted export
+				job.update(func(j *ExportJob) { j.CopiedBytes += f.Size })
+			} else {
+				err = copyExportFile(ctx, job, f, target)
+			}
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				status = "cancelled"
+				break
+			}
+			log.Printf("Export %s: error copying %s: %v", job.ID, f.Src, err)
+			job.update(func(j *ExportJob) {
+				if len(j.Errors) < maxExportErrors {
+					j.Errors = append(j.Errors, fmt.Sprintf("%s: %v", filepath.Base(f.Src), err))
+				}
+			})
+			if writeErrorCode(err) == ackCodeDiskFull {
+				// Every following file would fail the same way
+				status = "failed"
+				break
+			}
+			continue
+		}
+		job.update(func(j *ExportJob) { j.CopiedFiles++ })
+	}
+
+	job.update(func(j *ExportJob) {
+		j.Status = status
+		j.CurrentFile = ""
+		now := clock.Now()
+		j.Finished = &now
+	})
+	log.Printf("Export %s to %s %s: %d/%d files", job.ID, job.Dest, status, job.CopiedFiles, job.TotalFiles)
+}
+
+// startExportJob validates the request, checks the drive has room and starts copying in
+// the background
+func startExportJob(config *Config, req ExportRequest) (*ExportJob, error) {
+	baseDir := config.ReceiveDir
+	if baseDir == "" {
+		baseDir = "received"
+	}
+
+	// Only detected drives can be written to
+	var drive *ExportDrive
+	for _, d := range findExportDrives(config) {
+		if d.Path == req.Drive {
+			drive = &d
+			break
+		}
+	}
+	if drive == nil {
+		return nil, fmt.Errorf("drive %q is not connected", req.Drive)
+	}
+	folder := strings.TrimSpace(req.Folder)
+	if folder == "" {
+		folder = "Photos " + clock.Now().Format("2006-01-02")
+	}
+	if strings.Contains(folder, "..") || strings.ContainsAny(folder, "/\\:") || strings.HasPrefix(folder, ".") {
+		return nil, fmt.Errorf("invalid folder name %q", folder)
+	}
+
+	if req.Watermark && config.Watermark == nil {
+		return nil, fmt.Errorf("no watermark configured")
+	}
+	files, err := collectExportFiles(baseDir, req)
+	if err != nil {
+		return nil, err
+	}
+	if req.Watermark {
+		for i := range files {
+			if hasExtension(files[i].Src, photoExtensions) {
+				files[i].Watermark = config.Watermark
+			}
+		}
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("nothing to export for this selection")
+	}
+	var total int64
+	for _, f := range files {
+		total += f.Size
+	}
+	if uint64(total) > drive.FreeBytes {
+		return nil, fmt.Errorf("export needs %d MB but the drive has only %d MB free", total/1024/1024, drive.FreeBytes/1024/1024)
+	}
+
+	exportJobsMutex.Lock()
+	defer exportJobsMutex.Unlock()
+	for _, j := range exportJobs {
+		if j.Drive == drive.Path && j.Status == "running" {
+			return nil, fmt.Errorf("an export to %s is already running", drive.Name)
+		}
+	}
+	exportJobSeq++
+	ctx, cancel := context.WithCancel(context.Background())
+	job := &ExportJob{
+		ID:         strconv.Itoa(exportJobSeq),
+		Drive:      drive.Path,
+		Dest:       filepath.Join(drive.Path, folder),
+		Status:     "running",
+		TotalFiles: len(files),
+		TotalBytes: total,
+		Started:    clock.Now(),
+		cancel:     cancel,
+	}
+	exportJobs[job.ID] = job
+	log.Printf("Export %s: copying %d file(s), %d MB to %s", job.ID, len(files), total/1024/1024, job.Dest)
+	go runExportJob(ctx, job, files)
+	return job, nil
+}
+
+// registerExportRoutes adds the USB drive export page and API to the router
+func registerExportRoutes(router *mux.Router, config *Config) {
+	router.HandleFunc("/api/export/drives", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, map[string]interface{}{"success": true, "drives": findExportDrives(config)})
+	}).Methods("GET")
+
+	// Start an export
+	router.HandleFunc("/api/export", func(w http.ResponseWriter, r *http.Request) {
+		var req ExportRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeJSON(w, map[string]interface{}{"success": false, "error": "Invalid request: " + err.Error()})
+			return
+		}
+		job, err := startExportJob(config, req)
+		if err != nil {
+			writeJSON(w, map[string]interface{}{"success": false, "error": err.Error()})
+			return
+		}
+		countFeature("usb_export")
+		writeJSON(w, map[string]interface{}{"success": true, "jobId": job.ID})
+	}).Methods("POST")
+
+	// Export jobs of this run, newest first
+	router.HandleFunc("/api/export/jobs", func(w http.ResponseWriter, r *http.Request) {
+		exportJobsMutex.Lock()
+		jobs := make([]ExportJob, 0, len(exportJobs))
+		for _, j := range exportJobs {
+			jobs = append(jobs, j.snapshot())
+		}
+		exportJobsMutex.Unlock()
+		sort.Slice(jobs, func(i, j int) bool { return jobs[i].Started.After(jobs[j].Started) })
+		writeJSON(w, map[string]interface{}{"success": true, "jobs": jobs})
+	}).Methods("GET")
+
+	router.HandleFunc("/api/export/jobs/{id}/cancel", func(w http.ResponseWriter, r *http.Request) {
+		exportJobsMutex.Lock()
+		job, ok := exportJobs[mux.Vars(r)["id"]]
+		if ok && job.Status == "running" {
+			job.cancel()
+		}
+		exportJobsMutex.Unlock()
+		if !ok {
+			writeJSON(w, map[string]interface{}{"success": false, "error": "Unknown export job"})
+			return
+		}
+		writeJSON(w, map[string]interface{}{"success": true})
+	}).Methods("POST")
+
+	// Export page: pick a drive, albums and/or a date range, and follow the progress
+	router.HandleFunc("/export", func(w http.ResponseWriter, r *http.Request) {
+		baseDir := config.ReceiveDir
+		if baseDir == "" {
+			baseDir = "received"
+		}
+		var albumNames []string
+		albumsMutex.Lock()
+		if albums, err := loadAlbums(baseDir); err == nil {
+			for name := range albums {
+				albumNames = append(albumNames, name)
+			}
+		}
+		albumsMutex.Unlock()
+		sort.Strings(albumNames)
+
+		tmpl := `<!DOCTYPE html>
+<html>
+<head>
+    <title>Export to USB Drive - Photo Sync Server</title>
+    <style>
+        body { font-family: 'Segoe UI', Tahoma, Arial, sans-serif; margin: 0; padding: 20px; background: #000000; color: #ffffff; }
+        h1 { color: #ffffff; font-weight: 300; letter-spacing: 1px; }
+        h2 { font-size: 20px; margin-top: 30px; color: #aaaaaa; font-weight: 300; }
+        .back-link { display: inline-block; margin-bottom: 20px; color: #88aaff; text-decoration: none; font-size: 14px; }
+        .back-link:hover { color: #aaccff; text-decoration: underline; }
+        .panel { background: #1a1a1a; border: 1px solid #2a2a2a; border-radius: 12px; padding: 20px; max-width: 700px; }
+        label { display: block; margin: 12px 0 6px 0; color: #aaaaaa; font-size: 14px; }
+        select, input[type=text], input[type=date] { background: #000000; color: #ffffff; border: 1px solid #333333; border-radius: 6px; padding: 8px; font-size: 14px; }
+        .albums label { display: inline-block; margin: 4px 16px 4px 0; color: #ffffff; }
+        .hint { color: #888888; font-size: 12px; }
+        button { background: #667eea; color: #ffffff; border: none; border-radius: 6px; padding: 10px 20px; font-size: 14px; cursor: pointer; margin-top: 16px; }
+        button:hover { background: #5a6fd6; }
+        button.secondary { background: #333333; padding: 6px 12px; margin: 0 0 0 8px; }
+        .error { color: #ff6b6b; margin-top: 12px; }
+        .job { background: #1a1a1a; border: 1px solid #2a2a2a; border-radius: 12px; padding: 12px 16px; margin-bottom: 12px; max-width: 700px; font-size: 13px; color: #aaaaaa; }
+        .job b { color: #ffffff; }
+        .bar { background: #333333; border-radius: 4px; height: 8px; margin: 8px 0; overflow: hidden; }
+        .bar div { background: #667eea; height: 100%; }
+        .status-done { color: #4ade80; }
+        .status-failed, .status-cancelled { color: #ff6b6b; }
+    </style>
+</head>
+<body>
+    <a href="/" class="back-link">← Back to Home</a>
+    <h1>💾 Export to USB Drive</h1>
+    <div class="panel">
+        <label for="drive">Drive</label>
+        <select id="drive"></select>
+        <button class="secondary" onclick="loadDrives()">↻ Refresh</button>
+        <div class="hint" id="driveHint"></div>
+
+        <label>Albums (one folder per album)</label>
+        <div class="albums">
+            {{range .Albums}}<label><input type="checkbox" name="album" value="{{.}}"> 📚 {{.}}</label>{{else}}<span class="hint">No albums yet</span>{{end}}
+        </div>
+
+        <label>Taken between (without albums: all phones, one folder per month)</label>
+        <input type="date" id="from"> – <input type="date" id="to">
+
+        <label for="folder">Folder on the drive</label>
+        <input type="text" id="folder" placeholder="Photos (today's date)" size="40">
+
+        {{if .Watermark}}<label><input type="checkbox" id="watermark"> Watermark photos (exported as JPEG; videos are copied as they are)</label>{{end}}
+
+        <div><button onclick="startExport()">Export</button></div>
+        <div class="error" id="error"></div>
+    </div>
+
+    <h2>Exports</h2>
+    <div id="jobs"><p class="hint">No exports yet.</p></div>
+
+    <script>
+        function formatBytes(n) {
+            if (n >= 1073741824) return (n / 1073741824).toFixed(1) + ' GB';
+            if (n >= 1048576) return (n / 1048576).toFixed(1) + ' MB';
+            if (n >= 1024) return (n / 1024).toFixed(0) + ' KB';
+            return n + ' B';
+        }
+
+        function escapeHTML(s) {
+            return String(s).replace(/[&<>"']/g, function(c) {
+                return {'&': '&amp;', '<': '&lt;', '>': '&gt;', '"': '&quot;', "'": '&#39;'}[c];
+            });
+        }
+
+        function loadDrives() {
+            fetch('/api/export/drives')
+                .then(function(r) { return r.json(); })
+                .then(function(data) {
+                    const drives = data.drives || [];
+                    document.getElementById('drive').innerHTML = drives.map(function(d) {
+                        return '<option value="' + escapeHTML(d.path) + '">' + escapeHTML(d.name) + ' (' + formatBytes(d.freeBytes) + ' free)</option>';
+                    }).join('');
+                    document.getElementById('driveHint').textContent = drives.length ? '' : 'No USB drive found. Plug one in and refresh.';
+                });
+        }
+
+        function startExport() {
+            const albums = Array.from(document.querySelectorAll('input[name=album]:checked')).map(function(c) { return c.value; });
+            const req = {
+                drive: document.getElementById('drive').value,
+                folder: document.getElementById('folder').value,
+                albums: albums,
+                from: document.getElementById('from').value,
+                to: document.getElementById('to').value,
+                watermark: !!(document.getElementById('watermark') || {}).checked
+            };
+            document.getElementById('error').textContent = '';
+            fetch('/api/export', {method: 'POST', headers: {'Content-Type': 'application/json'}, body: JSON.stringify(req)})
+                .then(function(r) { return r.json(); })
+                .then(function(data) {
+                    if (!data.success) {
+                        document.getElementById('error').textContent = data.error;
+                        return;
+                    }
+                    refreshJobs();
+                })
+                .catch(function(e) { document.getElementById('error').textContent = 'Error: ' + e; });
+        }
+
+        function cancelExport(id) {
+            fetch('/api/export/jobs/' + id + '/cancel', {method: 'POST'}).then(refreshJobs);
+        }
+
+        function refreshJobs() {
+            fetch('/api/export/jobs')
+                .then(function(r) { return r.json(); })
+                .then(function(data) {
+                    const jobs = data.jobs || [];
+                    if (!jobs.length) return;
+                    document.getElementById('jobs').innerHTML = jobs.map(function(j) {
+                        const percent = j.totalBytes > 0 ? Math.min(100, 100 * j.copiedBytes / j.totalBytes) : 100;
+                        let html = '<div class="job"><b>' + escapeHTML(j.dest) + '</b> · <span class="status-' + j.status + '">' + j.status + '</span>';
+                        if (j.status === 'running') html += '<button class="secondary" onclick="cancelExport(\'' + j.id + '\')">Cancel</button>';
+                        html += '<div class="bar"><div style="width:' + percent.toFixed(1) + '%"></div></div>';
+                        html += j.copiedFiles + ' / ' + j.totalFiles + ' files · ' + formatBytes(j.copiedBytes) + ' / ' + formatBytes(j.totalBytes);
+                        if (j.currentFile) html += '<br>' + escapeHTML(j.currentFile);
+                        (j.errors || []).forEach(function(e) { html += '<br><span class="status-failed">' + escapeHTML(e) + '</span>'; });
+                        return html + '</div>';
+                    }).join('');
+                })
+                .catch(function() {});
+        }
+
+        loadDrives();
+        refreshJobs();
+        setInterval(refreshJobs, 2000);
+    </script>
+</body>
+</html>`
+
+		t := template.Must(template.New("export").Parse(tmpl))
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		data := struct {
+			Albums    []string
+			Watermark bool
+		}{albumNames, config.Watermark != nil}
+		if err := t.Execute(w, data); err != nil {
+			log.Printf("Error rendering export page: %v", err)
+		}
+	}).Methods("GET")
+}
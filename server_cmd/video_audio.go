@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// extractVideoAudio writes the audio track of srcPath into the music library as mp3 or
+// m4a and returns the new file's path. A positive end limits the extract to [start, end).
+func extractVideoAudio(ctx context.Context, srcPath, format string, start, end float64) (string, error) {
+	if format == "" {
+		format = "mp3"
+	}
+	if format != "mp3" && format != "m4a" {
+		return "", fmt.Errorf("unsupported audio format %q", format)
+	}
+	if start < 0 || (end > 0 && end <= start) {
+		return "", fmt.Errorf("invalid range %.3f-%.3f", start, end)
+	}
+	if err := os.MkdirAll(musicLibraryDir, 0755); err != nil {
+		return "", fmt.Errorf("create music directory: %w", err)
+	}
+
+	// Name after the video, with a suffix if that name is taken
+	base := strings.TrimSuffix(filepath.Base(srcPath), filepath.Ext(srcPath))
+	outputPath := filepath.Join(musicLibraryDir, base+"."+format)
+	for i := 2; ; i++ {
+		if _, err := os.Stat(outputPath); os.IsNotExist(err) {
+			break
+		}
+		outputPath = filepath.Join(musicLibraryDir, fmt.Sprintf("%s-%d.%s", base, i, format))
+	}
+
+	args := []string{"-y"}
+	if start > 0 {
+		args = append(args, "-ss", strconv.FormatFloat(start, 'f', 3, 64))
+	}
+	args = append(args, "-i", srcPath)
+	if end > 0 {
+		args = append(args, "-t", strconv.FormatFloat(end-start, 'f', 3, 64))
+	}
+	args = append(args, "-vn", "-map", "0:a:0")
+
+	encode := func(codecArgs ...string) ([]byte, error) {
+		full := append(append(append([]string{}, args...), codecArgs...), outputPath)
+		return runTool(ctx, audioExtractTimeout, "ffmpeg", full...)
+	}
+
+	var output []byte
+	var err error
+	if format == "mp3" {
+		output, err = encode("-c:a", "libmp3lame", "-q:a", "2")
+	} else {
+		// Phone videos almost always carry AAC, which copies into m4a without re-encoding
+		if output, err = encode("-c:a", "copy"); err != nil {
+			log.Printf("Audio stream copy failed for %s, re-encoding: %v", srcPath, err)
+			output, err = encode("-c:a", "aac", "-b:a", "192k")
+		}
+	}
+	if err != nil {
+		os.Remove(outputPath)
+		return "", fmt.Errorf("ffmpeg failed: %v, output: %s", err, string(output))
+	}
+	return outputPath, nil
+}
+
+// registerVideoAudioRoutes adds the audio extraction player action to the router
+func registerVideoAudioRoutes(router *mux.Router, config *Config) {
+	handleTransfer(router, "/extract-audio", func(w http.ResponseWriter, r *http.Request) {
+
+		var req struct {
+			PhoneName string  `json:"phoneName"`
+			Video     string  `json:"video"`  // thumbnail or original name, as in the gallery
+			Format    string  `json:"format"` // mp3 (default) or m4a
+			Start     float64 `json:"start"`  // optional, seconds
+			End       float64 `json:"end"`    // optional, seconds; 0 means to the end
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeJSON(w, map[string]interface{}{"success": false, "error": "Invalid request: " + err.Error()})
+			return
+		}
+		if validatePhoneName(req.PhoneName) != nil ||
+			strings.Contains(req.Video, "..") || strings.ContainsAny(req.Video, "/\\") {
+			writeJSON(w, map[string]interface{}{"success": false, "error": "Invalid video"})
+			return
+		}
+
+		baseDir := config.ReceiveDir
+		if baseDir == "" {
+			baseDir = "received"
+		}
+		srcPath, ok := originalForThumbnail(filepath.Join(baseDir, req.PhoneName), req.Video)
+		if !ok || !hasExtension(srcPath, videoExtensions) {
+			writeJSON(w, map[string]interface{}{"success": false, "error": "Video not found"})
+			return
+		}
+
+		outputPath, err := extractVideoAudio(r.Context(), srcPath, req.Format, req.Start, req.End)
+		if err != nil {
+			log.Printf("Error extracting audio: %v", err)
+			writeJSON(w, map[string]interface{}{"success": false, "error": fmt.Sprintf("Audio extraction failed: %v", err)})
+			return
+		}
+
+		log.Printf("Audio extracted to music library: %s", outputPath)
+		countFeature("audio_extract")
+		writeJSON(w, map[string]interface{}{
+			"success":  true,
+			"filename": filepath.Base(outputPath),
+			"message":  "Audio saved to the music library",
+		})
+	}).Methods("POST")
+}
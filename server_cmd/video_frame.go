@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// extractVideoFrame saves the frame of srcPath at the given second as a full-resolution
+// JPEG next to the video, catalogs it and generates its thumbnail. Saving the same frame
+// twice returns the existing photo.
+func extractVideoFrame(ctx context.Context, srcPath string, at float64) (string, error) {
+	if at < 0 {
+		return "", fmt.Errorf("invalid timestamp %.3f", at)
+	}
+
+	phoneDir := filepath.Dir(srcPath)
+	base := strings.TrimSuffix(filepath.Base(srcPath), filepath.Ext(srcPath))
+	outputPath := filepath.Join(phoneDir, fmt.Sprintf("%s_frame_%dms.jpg", base, int64(at*1000)))
+	if _, err := os.Stat(outputPath); err == nil {
+		return outputPath, nil
+	}
+
+	// Seek on the input for speed; ffmpeg decodes forward from the previous keyframe so
+	// the frame is exact. -q:v 2 is near-lossless JPEG, no scaling keeps full resolution.
+	output, err := runTool(ctx, videoFrameTimeout, "ffmpeg",
+		"-y",
+		"-ss", strconv.FormatFloat(at, 'f', 3, 64),
+		"-i", srcPath,
+		"-frames:v", "1",
+		"-q:v", "2",
+		outputPath)
+	if err != nil {
+		os.Remove(outputPath)
+		return "", fmt.Errorf("ffmpeg failed: %v, output: %s", err, string(output))
+	}
+	if _, err := os.Stat(outputPath); err != nil {
+		return "", fmt.Errorf("no frame at %.3fs", at)
+	}
+
+	if sum, err := calculateSHA256(outputPath); err == nil {
+		openCatalog(phoneDir).Record(outputPath, sum)
+	}
+	if err := generateThumbnails(ctx, phoneDir); err != nil {
+		log.Printf("Thumbnail generation after frame extraction failed: %v", err)
+	}
+	return outputPath, nil
+}
+
+// registerVideoFrameRoutes adds the "save this frame" player action to the router
+func registerVideoFrameRoutes(router *mux.Router, config *Config) {
+	handleTransfer(router, "/extract-frame", func(w http.ResponseWriter, r *http.Request) {
+
+		var req struct {
+			PhoneName string  `json:"phoneName"`
+			Video     string  `json:"video"` // thumbnail or original name, as in the gallery
+			Time      float64 `json:"time"`  // seconds
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeJSON(w, map[string]interface{}{"success": false, "error": "Invalid request: " + err.Error()})
+			return
+		}
+		if validatePhoneName(req.PhoneName) != nil ||
+			strings.Contains(req.Video, "..") || strings.ContainsAny(req.Video, "/\\") {
+			writeJSON(w, map[string]interface{}{"success": false, "error": "Invalid video"})
+			return
+		}
+
+		baseDir := config.ReceiveDir
+		if baseDir == "" {
+			baseDir = "received"
+		}
+		srcPath, ok := originalForThumbnail(filepath.Join(baseDir, req.PhoneName), req.Video)
+		if !ok || !hasExtension(srcPath, videoExtensions) {
+			writeJSON(w, map[string]interface{}{"success": false, "error": "Video not found"})
+			return
+		}
+
+		outputPath, err := extractVideoFrame(r.Context(), srcPath, req.Time)
+		if err != nil {
+			log.Printf("Error extracting frame: %v", err)
+			writeJSON(w, map[string]interface{}{"success": false, "error": fmt.Sprintf("Frame extraction failed: %v", err)})
+			return
+		}
+
+		log.Printf("Frame saved: %s", outputPath)
+		countFeature("video_frame")
+		writeJSON(w, map[string]interface{}{
+			"success":  true,
+			"filename": filepath.Base(outputPath),
+			"message":  "Frame saved",
+		})
+	}).Methods("POST")
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+// minPresetFrameDuration is the shortest a photo may be shown when a preset's duration
+// limit squeezes the slideshow
+const minPresetFrameDuration = 0.5
+
+// VideoPreset bundles the output settings of a created video for a playback target
+type VideoPreset struct {
+	Name           string  `json:"name"`
+	Label          string  `json:"label"`
+	Width          int     `json:"width"`
+	Height         int     `json:"height"`
+	FPS            int     `json:"fps,omitempty"`            // 0 keeps the concat demuxer's rate
+	CRF            int     `json:"crf"`                      // quality when the size isn't limited
+	MaxBitrateKbps int     `json:"maxBitrateKbps,omitempty"` // peak video bitrate cap
+	AudioKbps      int     `json:"audioKbps"`
+	Profile        string  `json:"profile,omitempty"` // H.264 profile/level for picky decoders
+	Level          string  `json:"level,omitempty"`
+	MaxDuration    float64 `json:"maxDuration,omitempty"`  // seconds; photos are shown shorter to fit
+	MaxSizeBytes   int64   `json:"maxSizeBytes,omitempty"` // file size limit; the bitrate is derived from it
+}
+
+// videoPresets are the selectable output presets, in the order the web UI lists them.
+// high/medium/low are the original quality settings.
+var videoPresets = []VideoPreset{
+	{Name: "high", Label: "High (1080p)", Width: 1920, Height: 1080, CRF: 23, AudioKbps: 128},
+	{Name: "medium", Label: "Medium (720p)", Width: 1280, Height: 720, CRF: 23, AudioKbps: 128},
+	{Name: "low", Label: "Low (480p)", Width: 854, Height: 480, CRF: 23, AudioKbps: 128},
+	{Name: "tv4k", Label: "TV 4K (2160p)", Width: 3840, Height: 2160, FPS: 30, CRF: 20,
+		MaxBitrateKbps: 40000, AudioKbps: 192, Profile: "high", Level: "5.1"},
+	{Name: "tv1080", Label: "TV 1080p", Width: 1920, Height: 1080, FPS: 30, CRF: 20,
+		MaxBitrateKbps: 15000, AudioKbps: 192, Profile: "high", Level: "4.1"},
+	{Name: "whatsapp", Label: "WhatsApp (under 16 MB)", Width: 1280, Height: 720, FPS: 30, CRF: 26,
+		AudioKbps: 96, Profile: "main", Level: "3.1", MaxDuration: 180, MaxSizeBytes: 16 * 1000 * 1000},
+	{Name: "instagram", Label: "Instagram Reel (9:16)", Width: 1080, Height: 1920, FPS: 30, CRF: 21,
+		MaxBitrateKbps: 8000, AudioKbps: 128, Profile: "high", Level: "4.1", MaxDuration: 90},
+}
+
+// videoPresetFor returns the named preset, falling back to medium
+func videoPresetFor(name string) VideoPreset {
+	for _, p := range videoPresets {
+		if p.Name == name {
+			return p
+		}
+	}
+	return videoPresets[1]
+}
+
+// scale returns the preset's frame size in ffmpeg "w:h" form
+func (p VideoPreset) scale() string {
+	return fmt.Sprintf("%d:%d", p.Width, p.Height)
+}
+
+// fitFrameDuration shortens the time per photo so count photos fit the preset's duration
+// limit, failing when that would flash them by too quickly
+func (p VideoPreset) fitFrameDuration(frameDuration float64, count int) (float64, error) {
+	if p.MaxDuration <= 0 || frameDuration*float64(count) <= p.MaxDuration {
+		return frameDuration, nil
+	}
+	fitted := p.MaxDuration / float64(count)
+	if fitted < minPresetFrameDuration {
+		return 0, fmt.Errorf("%d photos don't fit the %.0fs limit of %s, select at most %d",
+			count, p.MaxDuration, p.Label, int(p.MaxDuration/minPresetFrameDuration))
+	}
+	return fitted, nil
+}
+
+// encoderArgs returns the ffmpeg output options of the preset for a video of the given
+// length; withAudio adds the audio encoder settings
+func (p VideoPreset) encoderArgs(duration float64, withAudio bool) []string {
+	args := []string{
+		"-c:v", "libx264",
+		"-preset", x264Preset("faster"), // Use faster preset for speed
+		"-threads", x264Threads(), // All available CPU cores, unless the hardware profile limits them
+		"-pix_fmt", "yuv420p",
+	}
+	if p.FPS > 0 {
+		args = append(args, "-r", strconv.Itoa(p.FPS))
+	}
+	if p.Profile != "" {
+		args = append(args, "-profile:v", p.Profile, "-level", p.Level)
+	}
+
+	if p.MaxSizeBytes > 0 && duration > 0 {
+		// Average bitrate that lands under the size limit, with 5% for container overhead
+		audioKbps := 0
+		if withAudio {
+			audioKbps = p.AudioKbps
+		}
+		kbps := int(float64(p.MaxSizeBytes)*8*0.95/duration/1000) - audioKbps
+		if kbps < 100 {
+			kbps = 100
+		}
+		rate := strconv.Itoa(kbps) + "k"
+		args = append(args, "-b:v", rate, "-maxrate", rate, "-bufsize", strconv.Itoa(kbps*2)+"k")
+	} else {
+		args = append(args, "-crf", strconv.Itoa(p.CRF))
+		if p.MaxBitrateKbps > 0 {
+			args = append(args, "-maxrate", strconv.Itoa(p.MaxBitrateKbps)+"k", "-bufsize", strconv.Itoa(p.MaxBitrateKbps*2)+"k")
+		}
+	}
+
+	if withAudio {
+		args = append(args, "-c:a", "aac", "-b:a", strconv.Itoa(p.AudioKbps)+"k")
+	}
+	// Playable while still downloading, as TVs and messengers stream the file
+	return append(args, "-movflags", "+faststart")
+}
+
+// registerVideoPresetRoutes adds the preset list for API clients to the router
+func registerVideoPresetRoutes(router *mux.Router, config *Config) {
+	router.HandleFunc("/api/video-presets", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "presets": videoPresets})
+	}).Methods("GET")
+}
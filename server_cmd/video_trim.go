@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// keyframeTolerance is how close (seconds) an in-point must be to a keyframe for a
+// stream copy to start on the exact frame the user picked
+const keyframeTolerance = 0.02
+
+// Trim modes
+const (
+	trimModeAuto     = "auto"     // stream copy when the in-point is on a keyframe, re-encode otherwise
+	trimModeCopy     = "copy"     // stream copy, fast but snaps to the previous keyframe
+	trimModeReencode = "reencode" // frame accurate, slower
+)
+
+// videoKeyframes lists the keyframe timestamps (seconds) of the first video stream
+func videoKeyframes(ctx context.Context, srcPath string) ([]float64, error) {
+	output, err := runTool(ctx, videoProbeTimeout, "ffprobe",
+		"-v", "error",
+		"-select_streams", "v:0",
+		"-skip_frame", "nokey",
+		"-show_entries", "frame=best_effort_timestamp_time",
+		"-of", "csv=p=0",
+		srcPath)
+	if err != nil {
+		return nil, fmt.Errorf("ffprobe failed: %v, output: %s", err, string(output))
+	}
+	var keyframes []float64
+	for _, line := range strings.Split(string(output), "\n") {
+		line = strings.Trim(strings.TrimSpace(line), ",")
+		if t, err := strconv.ParseFloat(line, 64); err == nil {
+			keyframes = append(keyframes, t)
+		}
+	}
+	return keyframes, nil
+}
+
+// onKeyframe reports whether t is within keyframeTolerance of a keyframe
+func onKeyframe(keyframes []float64, t float64) bool {
+	for _, k := range keyframes {
+		if math.Abs(k-t) <= keyframeTolerance {
+			return true
+		}
+	}
+	return false
+}
+
+// trimmedVideoPath picks a free name for the trimmed copy next to the original
+func trimmedVideoPath(srcPath, ext string) string {
+	base := strings.TrimSuffix(srcPath, filepath.Ext(srcPath)) + "_trim"
+	path := base + ext
+	for i := 2; ; i++ {
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			return path
+		}
+		path = fmt.Sprintf("%s%d%s", base, i, ext)
+	}
+}
+
+// trimVideo writes the [start, end) section of srcPath to a new file next to it and
+// returns its path. Stream copies keep the original container; re-encodes produce mp4.
+// The result is marked and cataloged like a video created from photos.
+func trimVideo(ctx context.Context, srcPath string, start, end float64, mode string) (string, error) {
+	if start < 0 || end <= start {
+		return "", fmt.Errorf("invalid trim range %.3f-%.3f", start, end)
+	}
+	if mode == "" {
+		mode = trimModeAuto
+	}
+
+	reencode := false
+	switch mode {
+	case trimModeCopy:
+	case trimModeReencode:
+		reencode = true
+	case trimModeAuto:
+		if start > 0 {
+			keyframes, err := videoKeyframes(ctx, srcPath)
+			if err != nil {
+				log.Printf("Keyframe probe failed for %s, re-encoding: %v", srcPath, err)
+				reencode = true
+			} else {
+				reencode = !onKeyframe(keyframes, start)
+			}
+		}
+	default:
+		return "", fmt.Errorf("unknown trim mode %q", mode)
+	}
+
+	ext := filepath.Ext(srcPath)
+	if reencode {
+		ext = ".mp4"
+	}
+	outputPath := trimmedVideoPath(srcPath, ext)
+
+	// Seeking on the input resets timestamps, so the length is given as a duration
+	args := []string{
+		"-y",
+		"-ss", strconv.FormatFloat(start, 'f', 3, 64),
+		"-i", srcPath,
+		"-t", strconv.FormatFloat(end-start, 'f', 3, 64),
+	}
+	if reencode {
+		args = append(args,
+			"-map", "0:v:0",
+			"-map", "0:a?",
+			"-c:v", "libx264",
+			"-preset", x264Preset("faster"),
+			"-threads", x264Threads(),
+			"-crf", "18",
+			"-pix_fmt", "yuv420p",
+			"-c:a", "aac",
+			"-b:a", "192k",
+			"-movflags", "+faststart",
+		)
+	} else {
+		args = append(args,
+			"-map", "0",
+			"-c", "copy",
+			"-avoid_negative_ts", "make_zero",
+		)
+	}
+	args = append(args, outputPath)
+
+	log.Printf("Trimming %s [%.3f-%.3f] (reencode=%v) -> %s", srcPath, start, end, reencode, outputPath)
+	if output, err := runTool(ctx, videoTrimTimeout, "ffmpeg", args...); err != nil {
+		os.Remove(outputPath)
+		return "", fmt.Errorf("ffmpeg failed: %v, output: %s", err, string(output))
+	}
+
+	// Created on the server (not synced), like slideshow videos
+	recordCreatedVideo(filepath.Dir(outputPath), outputPath)
+	return outputPath, nil
+}
+
+// registerVideoTrimRoutes adds the video trim action to the router
+func registerVideoTrimRoutes(router *mux.Router, config *Config) {
+	handleTransfer(router, "/trim-video", func(w http.ResponseWriter, r *http.Request) {
+
+		var req struct {
+			PhoneName string  `json:"phoneName"`
+			Video     string  `json:"video"` // thumbnail or original name, as in the gallery
+			Start     float64 `json:"start"` // seconds
+			End       float64 `json:"end"`   // seconds
+			Mode      string  `json:"mode"`  // auto, copy or reencode
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeJSON(w, map[string]interface{}{"success": false, "error": "Invalid request: " + err.Error()})
+			return
+		}
+		if validatePhoneName(req.PhoneName) != nil ||
+			strings.Contains(req.Video, "..") || strings.ContainsAny(req.Video, "/\\") {
+			writeJSON(w, map[string]interface{}{"success": false, "error": "Invalid video"})
+			return
+		}
+
+		baseDir := config.ReceiveDir
+		if baseDir == "" {
+			baseDir = "received"
+		}
+		srcPath, ok := originalForThumbnail(filepath.Join(baseDir, req.PhoneName), req.Video)
+		if !ok || !hasExtension(srcPath, videoExtensions) {
+			writeJSON(w, map[string]interface{}{"success": false, "error": "Video not found"})
+			return
+		}
+
+		// Trim synchronously so the copy is ready before we respond
+		outputPath, err := trimVideo(r.Context(), srcPath, req.Start, req.End, req.Mode)
+		if err != nil {
+			log.Printf("Error trimming video: %v", err)
+			writeJSON(w, map[string]interface{}{"success": false, "error": fmt.Sprintf("Trim failed: %v", err)})
+			return
+		}
+
+		log.Printf("Video trimmed successfully: %s", outputPath)
+		countFeature("video_trim")
+		writeJSON(w, map[string]interface{}{
+			"success":  true,
+			"filename": filepath.Base(outputPath),
+			"message":  "Video trimmed successfully",
+		})
+	}).Methods("POST")
+}
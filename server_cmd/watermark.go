@@ -0,0 +1,211 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"golang.org/x/image/draw"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+)
+
+const (
+	defaultWatermarkOpacity = 0.6
+	defaultWatermarkSizePct = 5
+	// renditionsDirName caches the photos as shared: resized and watermarked, in the
+	// receive directory
+	renditionsDirName = ".renditions"
+)
+
+// WatermarkConfig overlays text or a logo on the copies of photos that leave the server:
+// photos seen by visitors of public albums and the public gallery, exports to USB drives
+// made with the watermark option, and JPEG downloads for sharing. Originals are never
+// changed; the watermark is drawn into the rendition.
+type WatermarkConfig struct {
+	Text     string  `json:"text"`     // e.g. "© The Smiths"
+	Logo     string  `json:"logo"`     // PNG file, drawn instead of the text; transparency is kept
+	Position string  `json:"position"` // bottom-right (default), bottom-left, top-right, top-left or center
+	Opacity  float64 `json:"opacity"`  // 0 to 1, default 0.6
+	SizePct  int     `json:"size_pct"` // height as a percentage of the photo's shorter side, default 5
+}
+
+// watermarkLogo is the decoded logo of config.Watermark, loaded at startup
+var watermarkLogo image.Image
+
+// renditionMutex renders one rendition at a time, so visitors can't tie up every CPU
+var renditionMutex sync.Mutex
+
+// checkWatermark validates the watermark config and loads its logo
+func checkWatermark(config *Config) error {
+	wm := config.Watermark
+	if wm == nil {
+		return nil
+	}
+	if wm.Text == "" && wm.Logo == "" {
+		return fmt.Errorf("set text or logo")
+	}
+	switch wm.Position {
+	case "", "bottom-right", "bottom-left", "top-right", "top-left", "center":
+	default:
+		return fmt.Errorf("unknown position %q", wm.Position)
+	}
+	if wm.Opacity < 0 || wm.Opacity > 1 {
+		return fmt.Errorf("opacity must be between 0 and 1, got %v", wm.Opacity)
+	}
+	if wm.SizePct < 0 || wm.SizePct > 50 {
+		return fmt.Errorf("size_pct must be between 1 and 50, got %d", wm.SizePct)
+	}
+	if wm.Logo != "" {
+		f, err := os.Open(wm.Logo)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		logo, err := png.Decode(f)
+		if err != nil {
+			return fmt.Errorf("logo %s: %w", wm.Logo, err)
+		}
+		watermarkLogo = logo
+	}
+	return nil
+}
+
+func (wm *WatermarkConfig) opacity() float64 {
+	if wm.Opacity == 0 {
+		return defaultWatermarkOpacity
+	}
+	return wm.Opacity
+}
+
+func (wm *WatermarkConfig) sizePct() int {
+	if wm.SizePct == 0 {
+		return defaultWatermarkSizePct
+	}
+	return wm.SizePct
+}
+
+// key identifies the look of the watermark, for caching renditions
+func (wm *WatermarkConfig) key() string {
+	if wm == nil {
+		return "none"
+	}
+	return fmt.Sprintf("%s|%s|%s|%g|%d", wm.Text, wm.Logo, wm.Position, wm.opacity(), wm.sizePct())
+}
+
+// textImage draws text white with a dark shadow, at the basic font's size
+func textImage(text string) *image.RGBA {
+	face := basicfont.Face7x13
+	m := face.Metrics()
+	img := image.NewRGBA(image.Rect(0, 0, font.MeasureString(face, text).Ceil()+3, m.Height.Ceil()+3))
+	for i, c := range []color.Color{color.RGBA{0, 0, 0, 160}, color.White} {
+		d := &font.Drawer{Dst: img, Src: image.NewUniform(c), Face: face, Dot: fixed.P(2-i, 2-i+m.Ascent.Ceil())}
+		d.DrawString(text)
+	}
+	return img
+}
+
+// draw overlays the watermark on img, scaled to the configured share of its shorter side
+// and no wider than two thirds of it
+func (wm *WatermarkConfig) draw(img *image.RGBA) {
+	var mark image.Image
+	if wm.Logo != "" && watermarkLogo != nil {
+		mark = watermarkLogo
+	} else if wm.Text != "" {
+		mark = textImage(wm.Text)
+	} else {
+		return
+	}
+
+	b, mb := img.Bounds(), mark.Bounds()
+	h := max(1, min(b.Dx(), b.Dy())*wm.sizePct()/100)
+	w := max(1, mb.Dx()*h/mb.Dy())
+	if limit := b.Dx() * 2 / 3; w > limit {
+		w, h = limit, max(1, mb.Dy()*limit/mb.Dx())
+	}
+	margin := max(4, h/3)
+	var at image.Point
+	switch wm.Position {
+	case "top-left":
+		at = image.Pt(b.Min.X+margin, b.Min.Y+margin)
+	case "top-right":
+		at = image.Pt(b.Max.X-w-margin, b.Min.Y+margin)
+	case "bottom-left":
+		at = image.Pt(b.Min.X+margin, b.Max.Y-h-margin)
+	case "center":
+		at = image.Pt(b.Min.X+(b.Dx()-w)/2, b.Min.Y+(b.Dy()-h)/2)
+	default:
+		at = image.Pt(b.Max.X-w-margin, b.Max.Y-h-margin)
+	}
+
+	scaled := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.ApproxBiLinear.Scale(scaled, scaled.Bounds(), mark, mb, draw.Src, nil)
+	alpha := image.NewUniform(color.Alpha{A: uint8(wm.opacity() * 255)})
+	draw.DrawMask(img, image.Rectangle{Min: at, Max: at.Add(image.Pt(w, h))}, scaled, image.Point{}, alpha, image.Point{}, draw.Over)
+}
+
+// renderPhoto decodes the photo at path upright, scales it to fit maxPixels (0 keeps its
+// size) and draws the watermark, if any
+func renderPhoto(path string, maxPixels int, wm *WatermarkConfig) (*image.RGBA, error) {
+	img, err := decodePhoto(path)
+	if err != nil {
+		return nil, err
+	}
+	out := photoDownloadImage(img, maxPixels).(*image.RGBA)
+	if wm != nil {
+		wm.draw(out)
+	}
+	return out, nil
+}
+
+// cachedRendition returns the path of a JPEG rendition of the photo at src, rendering it
+// into the cache first. The cache key covers the file and the settings, so edits and
+// changed settings render again.
+func cachedRendition(baseDir, src string, maxPixels int, wm *WatermarkConfig) (string, error) {
+	info, err := os.Stat(src)
+	if err != nil {
+		return "", err
+	}
+	key := sha256.Sum256([]byte(fmt.Sprintf("%s|%d|%d|%d|%s", src, info.Size(), info.ModTime().UnixNano(), maxPixels, wm.key())))
+	cached := filepath.Join(baseDir, renditionsDirName, hex.EncodeToString(key[:12])+".jpg")
+	if _, err := os.Stat(cached); err == nil {
+		return cached, nil
+	}
+
+	renditionMutex.Lock()
+	defer renditionMutex.Unlock()
+	if _, err := os.Stat(cached); err == nil {
+		return cached, nil // rendered while we waited
+	}
+	out, err := renderPhoto(src, maxPixels, wm)
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(filepath.Dir(cached), 0o755); err != nil {
+		return "", err
+	}
+	f, err := os.CreateTemp(filepath.Dir(cached), "."+filepath.Base(cached)+".*"+partialFileSuffix)
+	if err != nil {
+		return "", err
+	}
+	err = jpeg.Encode(f, out, &jpeg.Options{Quality: photoDownloadQuality})
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), cached)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return cached, nil
+}
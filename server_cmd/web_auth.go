@@ -0,0 +1,404 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	webSessionCookie = "psync_session"
+	webKnockCookie   = "psync_knock"
+	webSessionMaxAge = 30 * 24 * time.Hour
+	webKnockMaxAge   = 365 * 24 * time.Hour
+	webLoginFailWait = time.Second // after a wrong password, doubling with every further one
+	webLoginMaxWait  = 5 * time.Minute
+)
+
+// webLoginThrottle slows down password guessing, by the sign-in form and by Basic auth alike
+var webLoginThrottle = newFailureThrottle(webLoginFailWait, webLoginMaxWait)
+
+// WebAuthConfig puts the web UI behind a password. Visitors who haven't signed in get a
+// landing page with the server's name, a contact and the public albums instead of the
+// library; scripts and apps can send the password by HTTP Basic auth (any user name).
+//
+// With Knock set the server hides completely: every request but the phones' answers 404
+// until a visitor opens a page with ?knock=<knock> once, which is remembered in a cookie.
+type WebAuthConfig struct {
+	Password     string   `json:"password"`
+	Contact      string   `json:"contact,omitempty"`       // e.g. an email address, shown on the landing page
+	Message      string   `json:"message,omitempty"`       // shown on the landing page
+	PublicAlbums []string `json:"public_albums,omitempty"` // shared albums anyone may view
+	Knock        string   `json:"knock,omitempty"`
+}
+
+// webAuthOpenRoutes need no sign-in: the sign-in itself
+var webAuthOpenRoutes = map[string]bool{
+	"/login":  true,
+	"/logout": true,
+}
+
+// webAuthDeviceRoutes are used by phones, which authenticate with their device approval
+// and token instead. They skip the knock too: an app can't follow its redirect, and a
+// WebSocket upgrade can't either.
+var webAuthDeviceRoutes = map[string]bool{
+	"/ws/sync":                      true,
+	"/api/phones/{phoneName}/media": true,
+	"/api/push/register":            true,
+	"/api/push/unregister":          true,
+}
+
+func (wa *WebAuthConfig) enabled() bool {
+	return wa != nil && (wa.Password != "" || wa.Knock != "")
+}
+
+// cookieValue derives a cookie from a secret, so changing the secret signs everyone out
+func cookieValue(secret, purpose string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(purpose))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func hasCookie(r *http.Request, name, want string) bool {
+	c, err := r.Cookie(name)
+	return err == nil && subtle.ConstantTimeCompare([]byte(c.Value), []byte(want)) == 1
+}
+
+func (wa *WebAuthConfig) passwordMatches(password string) bool {
+	return subtle.ConstantTimeCompare([]byte(password), []byte(wa.Password)) == 1
+}
+
+// checkPassword reports whether a sign-in attempt from remote has the right password. A
+// remote that sent a wrong one has to wait before its next attempt; attempts before then
+// are refused unchecked, and throttled says so.
+func (wa *WebAuthConfig) checkPassword(remote, password string) (ok, throttled bool) {
+	if webLoginThrottle.wait(remote) > 0 {
+		return false, true
+	}
+	if !wa.passwordMatches(password) {
+		wait := webLoginThrottle.failed(remote)
+		log.Printf("Web sign-in failed from %s, next try in %s", remote, wait)
+		return false, false
+	}
+	webLoginThrottle.succeeded(remote)
+	return true, false
+}
+
+// signedIn reports whether the request carries the cookie of a current session
+func (wa *WebAuthConfig) signedIn(r *http.Request, baseDir string) bool {
+	if wa.Password == "" {
+		return true
+	}
+	c, err := r.Cookie(webSessionCookie)
+	return err == nil && validWebSession(baseDir, c.Value, wa.Password)
+}
+
+func (wa *WebAuthConfig) knocked(r *http.Request) bool {
+	return wa.Knock == "" || hasCookie(r, webKnockCookie, cookieValue(wa.Knock, "knock"))
+}
+
+// publicAlbumFile reports whether a thumbnail or original belongs to a public album
+func (wa *WebAuthConfig) publicAlbumFile(baseDir, phone, name string) bool {
+	if len(wa.PublicAlbums) == 0 {
+		return false
+	}
+	albumsMutex.Lock()
+	albums, err := loadAlbums(baseDir)
+	albumsMutex.Unlock()
+	if err != nil {
+		return false
+	}
+	for _, albumName := range wa.PublicAlbums {
+		a, ok := albums[albumName]
+		if !ok {
+			continue
+		}
+		for _, it := range a.Items {
+			base := filepath.Base(filepath.FromSlash(it.Name))
+			if it.Phone == phone && (name == base || name == thumbnailName(base)) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// routeTemplate returns the path template of the request's route, "" if none matched
+func routeTemplate(r *http.Request) string {
+	route := mux.CurrentRoute(r)
+	if route == nil {
+		return ""
+	}
+	tmpl, err := route.GetPathTemplate()
+	if err != nil {
+		return ""
+	}
+	return tmpl
+}
+
+// public reports whether the route may be seen without signing in
+func (wa *WebAuthConfig) public(r *http.Request, baseDir string) bool {
+	tmpl := routeTemplate(r)
+	if tmpl == "" {
+		return false
+	}
+	if webAuthOpenRoutes[tmpl] {
+		return true
+	}
+	if r.Method != http.MethodGet {
+		return false
+	}
+	vars := mux.Vars(r)
+	switch tmpl {
+	case "/album/{album}":
+		for _, name := range wa.PublicAlbums {
+			if name == vars["album"] {
+				return true
+			}
+		}
+	case "/thumb/{phoneName}/{fileName}":
+		return wa.publicAlbumFile(baseDir, vars["phoneName"], vars["fileName"])
+	case "/orig/{phoneName}/{thumbName}":
+		return wa.publicAlbumFile(baseDir, vars["phoneName"], vars["thumbName"])
+	}
+	return false
+}
+
+// webAuth is the router middleware that hides the server from visitors who haven't
+// knocked and shows the landing page to those who haven't signed in
+func webAuth(config *Config) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			wa := config.WebAuth
+			if !wa.enabled() || webAuthDeviceRoutes[routeTemplate(r)] {
+				next.ServeHTTP(w, r)
+				return
+			}
+			baseDir := receiveDir(config)
+
+			basicAuth := false
+			if _, password, ok := r.BasicAuth(); ok && wa.Password != "" {
+				basicAuth, _ = wa.checkPassword(r.RemoteAddr, password)
+			}
+			if !wa.knocked(r) && !basicAuth {
+				if knock := r.URL.Query().Get("knock"); knock == "" || subtle.ConstantTimeCompare([]byte(knock), []byte(wa.Knock)) != 1 {
+					http.NotFound(w, r)
+					return
+				}
+				http.SetCookie(w, &http.Cookie{Name: webKnockCookie, Value: cookieValue(wa.Knock, "knock"), Path: "/",
+					MaxAge: int(webKnockMaxAge.Seconds()), HttpOnly: true, Secure: r.TLS != nil, SameSite: http.SameSiteLaxMode})
+				// Drop the knock from the address bar, and from the history with it
+				u := *r.URL
+				q := u.Query()
+				q.Del("knock")
+				u.RawQuery = q.Encode()
+				http.Redirect(w, r, u.RequestURI(), http.StatusSeeOther)
+				return
+			}
+
+			if basicAuth || wa.signedIn(r, baseDir) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if wa.public(r, baseDir) {
+				if config.Watermark == nil || !serveWatermarked(w, r, config, baseDir) {
+					next.ServeHTTP(w, r)
+				}
+				return
+			}
+			if strings.HasPrefix(r.URL.Path, "/api/") || r.Method != http.MethodGet {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusUnauthorized)
+				json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Sign in required"})
+				return
+			}
+			serveLandingPage(w, config, baseDir, r.URL.Query().Get("failed"), r.URL.RequestURI())
+		})
+	}
+}
+
+// serveWatermarked serves a visitor of a public album the watermarked rendition of a
+// photo instead of its original. Other routes and videos are left to the router.
+func serveWatermarked(w http.ResponseWriter, r *http.Request, config *Config, baseDir string) bool {
+	if tmpl, err := mux.CurrentRoute(r).GetPathTemplate(); err != nil || tmpl != "/orig/{phoneName}/{thumbName}" {
+		return false
+	}
+	vars := mux.Vars(r)
+	orig, ok := originalForThumbnail(filepath.Join(baseDir, vars["phoneName"]), vars["thumbName"])
+	if !ok || !hasExtension(orig, photoExtensions) {
+		return false
+	}
+	path, err := cachedRendition(baseDir, orig, 0, config.Watermark)
+	if err != nil {
+		log.Printf("Error watermarking %s: %v", orig, err)
+		http.Error(w, "Error rendering photo", http.StatusInternalServerError)
+		return true
+	}
+	http.ServeFile(w, r, path)
+	return true
+}
+
+// serveLandingPage shows visitors who haven't signed in who runs the server and what
+// they may see without signing in. failed is why the last sign-in failed: "1" for a wrong
+// password, "wait" for one that was refused by the throttle.
+func serveLandingPage(w http.ResponseWriter, config *Config, baseDir, failed, next string) {
+	wa := config.WebAuth
+	var albums []string
+	albumsMutex.Lock()
+	if all, err := loadAlbums(baseDir); err == nil {
+		for _, name := range wa.PublicAlbums {
+			if _, ok := all[name]; ok {
+				albums = append(albums, name)
+			}
+		}
+	}
+	albumsMutex.Unlock()
+	sort.Strings(albums)
+
+	tmpl := `<!DOCTYPE html>
+<html>
+<head>
+    <title>{{.ServerName}}</title>
+    <meta name="viewport" content="width=device-width, initial-scale=1">
+    <style>
+        body { font-family: 'Segoe UI', Tahoma, Arial, sans-serif; margin: 0; padding: 40px 20px; background: #000000; color: #ffffff; text-align: center; }
+        h1 { font-weight: 300; letter-spacing: 1px; }
+        .card { max-width: 420px; margin: 0 auto; background: #1a1a1a; border: 1px solid #2a2a2a; border-radius: 12px; padding: 24px; }
+        .note { color: #aaaaaa; font-size: 14px; }
+        .error { color: #ff6b6b; font-size: 14px; }
+        a { color: #88aaff; text-decoration: none; }
+        a:hover { color: #aaccff; text-decoration: underline; }
+        ul { list-style: none; padding: 0; }
+        li { margin: 6px 0; }
+        input { padding: 8px; border-radius: 6px; border: 1px solid #444444; background: #000000; color: #ffffff; }
+        button { padding: 8px 16px; border-radius: 6px; border: none; background: #667eea; color: #ffffff; cursor: pointer; }
+    </style>
+</head>
+<body>
+    <div class="card">
+        <h1>📷 {{.ServerName}}</h1>
+        {{if .Message}}<p>{{.Message}}</p>{{end}}
+        {{if .Contact}}<p class="note">Contact: {{.Contact}}</p>{{end}}
+        {{if .Albums}}
+        <h2>📚 Public Albums</h2>
+        <ul>
+            {{range .Albums}}
+            <li><a href="/album/{{.}}">📚 {{.}}</a></li>
+            {{end}}
+        </ul>
+        {{end}}
+        {{if .Login}}
+        <h2>🔒 Sign in</h2>
+        {{if .Failed}}<p class="error">{{.Failed}}</p>{{end}}
+        <form method="POST" action="/login">
+            <input type="hidden" name="next" value="{{.Next}}">
+            <input type="password" name="password" placeholder="Password" autofocus>
+            <button type="submit">Sign in</button>
+        </form>
+        {{end}}
+    </div>
+</body>
+</html>`
+
+	serverName := config.ServerName
+	if serverName == "" {
+		serverName = "Photo Sync Server"
+	}
+	failure := ""
+	switch failed {
+	case "1":
+		failure = "Wrong password"
+	case "wait":
+		failure = "Too many wrong passwords, try again in a moment"
+	}
+	t := template.Must(template.New("landing").Parse(tmpl))
+	data := struct {
+		ServerName string
+		Message    string
+		Contact    string
+		Albums     []string
+		Login      bool
+		Failed     string
+		Next       string
+	}{
+		ServerName: serverName,
+		Message:    wa.Message,
+		Contact:    wa.Contact,
+		Albums:     albums,
+		Login:      wa.Password != "",
+		Failed:     failure,
+		Next:       next,
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusUnauthorized)
+	t.Execute(w, data)
+}
+
+// safeRedirect keeps the sign-in from redirecting to another site
+func safeRedirect(next string) string {
+	u, err := url.Parse(next)
+	if err != nil || u.IsAbs() || u.Host != "" || !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") {
+		return "/"
+	}
+	q := u.Query()
+	q.Del("failed")
+	u.RawQuery = q.Encode()
+	return u.RequestURI()
+}
+
+// registerWebAuthRoutes adds signing in and out of the web UI
+func registerWebAuthRoutes(router *mux.Router, config *Config) {
+	router.HandleFunc("/login", func(w http.ResponseWriter, r *http.Request) {
+		wa := config.WebAuth
+		if !wa.enabled() || wa.Password == "" {
+			http.Redirect(w, r, "/", http.StatusSeeOther)
+			return
+		}
+		next := safeRedirect(r.FormValue("next"))
+		if ok, throttled := wa.checkPassword(r.RemoteAddr, r.FormValue("password")); !ok {
+			failed := "1"
+			if throttled {
+				failed = "wait"
+			}
+			u, _ := url.Parse(next)
+			q := u.Query()
+			q.Set("failed", failed)
+			u.RawQuery = q.Encode()
+			http.Redirect(w, r, u.RequestURI(), http.StatusSeeOther)
+			return
+		}
+		session, err := startWebSession(receiveDir(config), wa.Password)
+		if err != nil {
+			log.Printf("Error starting web session: %v", err)
+			http.Error(w, "Error signing in", http.StatusInternalServerError)
+			return
+		}
+		http.SetCookie(w, &http.Cookie{Name: webSessionCookie, Value: session, Path: "/",
+			MaxAge: int(webSessionMaxAge.Seconds()), HttpOnly: true, Secure: r.TLS != nil, SameSite: http.SameSiteLaxMode})
+		countFeature("web_sign_in")
+		http.Redirect(w, r, next, http.StatusSeeOther)
+	}).Methods("POST")
+
+	router.HandleFunc("/logout", func(w http.ResponseWriter, r *http.Request) {
+		if c, err := r.Cookie(webSessionCookie); err == nil {
+			if err := endWebSession(receiveDir(config), c.Value); err != nil {
+				log.Printf("Error ending web session: %v", err)
+			}
+		}
+		http.SetCookie(w, &http.Cookie{Name: webSessionCookie, Value: "", Path: "/", MaxAge: -1, HttpOnly: true})
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+	}).Methods("GET", "POST")
+}
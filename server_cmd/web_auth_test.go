@@ -0,0 +1,91 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+func webAuthRouter(config *Config) *mux.Router {
+	router := mux.NewRouter()
+	registerWebAuthRoutes(router, config)
+	ok := func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("ok")) }
+	router.HandleFunc("/", ok)
+	router.HandleFunc("/ws/sync", ok)
+	router.Use(webAuth(config))
+	return router
+}
+
+func serveWebAuth(router *mux.Router, r *http.Request) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+	return w
+}
+
+func TestWebSessions(t *testing.T) {
+	c := useFakeClock(t, time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC))
+	config := &Config{ReceiveDir: t.TempDir(), WebAuth: &WebAuthConfig{Password: "secret"}}
+	router := webAuthRouter(config)
+
+	signIn := func() *http.Cookie {
+		t.Helper()
+		r := httptest.NewRequest("POST", "/login", strings.NewReader(url.Values{"password": {"secret"}, "next": {"/"}}.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		for _, cookie := range serveWebAuth(router, r).Result().Cookies() {
+			if cookie.Name == webSessionCookie {
+				return cookie
+			}
+		}
+		t.Fatal("signing in set no session cookie")
+		return nil
+	}
+	home := func(cookie *http.Cookie) int {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.AddCookie(cookie)
+		return serveWebAuth(router, r).Code
+	}
+
+	first, second := signIn(), signIn()
+	if first.Value == second.Value {
+		t.Fatal("two sign-ins got the same session ID")
+	}
+	if code := home(first); code != http.StatusOK {
+		t.Fatalf("signed in: status %d", code)
+	}
+	if code := home(&http.Cookie{Name: webSessionCookie, Value: cookieValue("secret", "session")}); code != http.StatusUnauthorized {
+		t.Errorf("a session that was never started: status %d", code)
+	}
+
+	// Signing out ends only that session
+	r := httptest.NewRequest("POST", "/logout", nil)
+	r.AddCookie(first)
+	serveWebAuth(router, r)
+	if code := home(first); code != http.StatusUnauthorized {
+		t.Errorf("signed out session: status %d", code)
+	}
+	if code := home(second); code != http.StatusOK {
+		t.Errorf("other session after signing out: status %d", code)
+	}
+
+	c.Advance(webSessionMaxAge)
+	if code := home(second); code != http.StatusUnauthorized {
+		t.Errorf("expired session: status %d", code)
+	}
+}
+
+func TestKnockSkipsDeviceRoutes(t *testing.T) {
+	config := &Config{ReceiveDir: t.TempDir(), WebAuth: &WebAuthConfig{Knock: "open-sesame"}}
+	router := webAuthRouter(config)
+
+	if code := serveWebAuth(router, httptest.NewRequest("GET", "/", nil)).Code; code != http.StatusNotFound {
+		t.Errorf("page without knocking: status %d", code)
+	}
+	if code := serveWebAuth(router, httptest.NewRequest("GET", "/ws/sync", nil)).Code; code != http.StatusOK {
+		t.Errorf("/ws/sync without knocking: status %d", code)
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// webSessionsFileName stores the web UI's sign-in sessions in the base receive dir, so a
+// restart doesn't sign everyone out
+const webSessionsFileName = ".web_sessions.json"
+
+// webSession is one signed-in browser. The file keys sessions by the SHA-256 of their ID,
+// so it can't be used to sign in itself.
+type webSession struct {
+	Expires  time.Time `json:"expires"`
+	Password string    `json:"password"` // cookieValue of the password at sign-in; changing it signs everyone out
+}
+
+var (
+	webSessionsMutex  sync.Mutex
+	webSessions       map[string]*webSession // loaded on first use
+	webSessionsLoaded string                 // base dir webSessions was loaded from
+)
+
+func hashSessionID(id string) string {
+	sum := sha256.Sum256([]byte(id))
+	return hex.EncodeToString(sum[:])
+}
+
+// loadWebSessions returns the sessions of baseDir, reading the file once and dropping
+// the expired ones. Callers hold webSessionsMutex.
+func loadWebSessions(baseDir string) (map[string]*webSession, error) {
+	if webSessions == nil || webSessionsLoaded != baseDir {
+		sessions := make(map[string]*webSession)
+		b, err := os.ReadFile(filepath.Join(baseDir, webSessionsFileName))
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		if err == nil {
+			if err := json.Unmarshal(b, &sessions); err != nil {
+				return nil, fmt.Errorf("parse web sessions: %w", err)
+			}
+		}
+		webSessions, webSessionsLoaded = sessions, baseDir
+	}
+	now := clock.Now()
+	for hash, s := range webSessions {
+		if !now.Before(s.Expires) {
+			delete(webSessions, hash)
+		}
+	}
+	return webSessions, nil
+}
+
+// saveWebSessions writes the sessions back. Callers hold webSessionsMutex.
+func saveWebSessions(baseDir string, sessions map[string]*webSession) error {
+	b, err := json.MarshalIndent(sessions, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(baseDir, 0o755); err != nil {
+		return err
+	}
+	path := filepath.Join(baseDir, webSessionsFileName)
+	if err := os.WriteFile(path+".tmp", b, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// startWebSession signs a browser in and returns the random session ID for its cookie
+func startWebSession(baseDir, password string) (string, error) {
+	id, err := randomToken(32)
+	if err != nil {
+		return "", err
+	}
+	webSessionsMutex.Lock()
+	defer webSessionsMutex.Unlock()
+
+	sessions, err := loadWebSessions(baseDir)
+	if err != nil {
+		return "", err
+	}
+	sessions[hashSessionID(id)] = &webSession{
+		Expires:  clock.Now().Add(webSessionMaxAge),
+		Password: cookieValue(password, "session"),
+	}
+	return id, saveWebSessions(baseDir, sessions)
+}
+
+// validWebSession reports whether id is an unexpired session signed in with password
+func validWebSession(baseDir, id, password string) bool {
+	if id == "" {
+		return false
+	}
+	webSessionsMutex.Lock()
+	defer webSessionsMutex.Unlock()
+
+	sessions, err := loadWebSessions(baseDir)
+	if err != nil {
+		return false
+	}
+	s, ok := sessions[hashSessionID(id)]
+	return ok && s.Password == cookieValue(password, "session")
+}
+
+// endWebSession signs a session out; unknown IDs are ignored
+func endWebSession(baseDir, id string) error {
+	webSessionsMutex.Lock()
+	defer webSessionsMutex.Unlock()
+
+	sessions, err := loadWebSessions(baseDir)
+	if err != nil {
+		return err
+	}
+	hash := hashSessionID(id)
+	if _, ok := sessions[hash]; !ok {
+		return nil
+	}
+	delete(sessions, hash)
+	return saveWebSessions(baseDir, sessions)
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
+)
+
+// wsSubprotocol is offered to clients that ask for one; the framing is the TCP protocol's
+const wsSubprotocol = "photo-sync"
+
+// wsConn presents a WebSocket as the byte stream of the framed sync protocol. Incoming
+// binary messages are read back to back, so a client may split a frame across messages or
+// combine small frames in one; each frame the server writes goes out as one binary message.
+type wsConn struct {
+	ws      *websocket.Conn
+	reader  io.Reader // rest of the message being read
+	writeMu sync.Mutex
+}
+
+func (c *wsConn) Read(b []byte) (int, error) {
+	for {
+		if c.reader == nil {
+			msgType, r, err := c.ws.NextReader()
+			if err != nil {
+				return 0, err
+			}
+			if msgType != websocket.BinaryMessage {
+				continue // text messages carry nothing for the sync protocol
+			}
+			c.reader = r
+		}
+		n, err := c.reader.Read(b)
+		if err == io.EOF {
+			c.reader = nil
+			if n > 0 {
+				return n, nil
+			}
+			continue
+		}
+		return n, err
+	}
+}
+
+// Write sends b as one binary message; the progress reporter writes concurrently with
+// the connection's loop
+func (c *wsConn) Write(b []byte) (int, error) {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if err := c.ws.WriteMessage(websocket.BinaryMessage, b); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+func (c *wsConn) Close() error {
+	c.writeMu.Lock()
+	c.ws.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(time.Second))
+	c.writeMu.Unlock()
+	return c.ws.Close()
+}
+
+func (c *wsConn) LocalAddr() net.Addr                { return c.ws.LocalAddr() }
+func (c *wsConn) RemoteAddr() net.Addr               { return c.ws.RemoteAddr() }
+func (c *wsConn) SetReadDeadline(t time.Time) error  { return c.ws.SetReadDeadline(t) }
+func (c *wsConn) SetWriteDeadline(t time.Time) error { return c.ws.SetWriteDeadline(t) }
+
+func (c *wsConn) SetDeadline(t time.Time) error {
+	if err := c.ws.SetReadDeadline(t); err != nil {
+		return err
+	}
+	return c.ws.SetWriteDeadline(t)
+}
+
+// registerWebSocketRoutes adds /ws/sync, which tunnels the sync protocol's frames over a
+// WebSocket for browser clients and networks where only the HTTP port is reachable
+func registerWebSocketRoutes(router *mux.Router, config *Config) {
+	upgrader := websocket.Upgrader{
+		ReadBufferSize:  64 * 1024,
+		WriteBufferSize: 64 * 1024,
+		Subprotocols:    []string{wsSubprotocol},
+		CheckOrigin: func(r *http.Request) bool {
+			origin := r.Header.Get("Origin")
+			if origin == "" {
+				return true // not a browser
+			}
+			for _, allowed := range config.WebSocketOrigins {
+				if allowed == "*" || strings.EqualFold(allowed, origin) {
+					return true
+				}
+			}
+			// Same origin: the page was served by this server
+			return strings.EqualFold(strings.TrimPrefix(strings.TrimPrefix(origin, "http://"), "https://"), r.Host)
+		},
+	}
+
+	handleStream(router, "/ws/sync", func(w http.ResponseWriter, r *http.Request) {
+		ws, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			log.Printf("WebSocket upgrade from %s failed: %v\n", r.RemoteAddr, err)
+			return // the upgrader already replied
+		}
+		// Messages up to one full frame: header plus the largest payload
+		ws.SetReadLimit(maxPayloadSize(config) + 5)
+
+		log.Printf("New WebSocket sync connection from %s\n", r.RemoteAddr)
+		countFeature("websocket_sync")
+		atomic.AddInt64(&activeConnections, 1)
+		defer atomic.AddInt64(&activeConnections, -1)
+		handleTCPConnection(&wsConn{ws: ws}, config)
+	}).Methods("GET")
+}